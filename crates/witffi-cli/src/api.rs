@@ -0,0 +1,507 @@
+//! `witffi api`, recording the exported Go API of the bindings and checking
+//! regenerated bindings against it.
+//!
+//! The bindings are generated into a scratch directory, as `witffi check`
+//! does, and every exported declaration of every Go package there is
+//! listed in a manifest, `api.txt` in the output directory by default: a
+//! line per declaration, `pkg <package>, <declaration>`, sorted, in the
+//! spirit of Go's own `api/go1.txt`. Committed at each release, the
+//! manifest lets `witffi api --check` fail when regeneration would remove
+//! or change a declaration callers may use.
+
+use std::path::Path;
+
+use snafu::prelude::*;
+
+use crate::{ApiArgs, Language, Result};
+
+/// The manifest, in the output directory unless `--manifest` says
+/// otherwise.
+pub const FILE_NAME: &str = "api.txt";
+
+/// Record the Go API of every Go binding in `apis`, or check it against the
+/// recorded one.
+pub fn run(apis: Vec<ApiArgs>) -> Result<()> {
+    let go: Vec<ApiArgs> = apis
+        .into_iter()
+        .filter(|api| matches!(api.generate.lang, Some(Language::Go)))
+        .collect();
+    if go.is_empty() {
+        whatever!(
+            "no Go bindings to record the API of: pass --lang go or add a [go] table to {}",
+            crate::config::FILE_NAME
+        );
+    }
+
+    let mut broken = 0;
+    for (index, api) in go.into_iter().enumerate() {
+        let (output, scratch) = crate::check::generate_scratch(api.generate, index)?;
+        let listed = manifest(&scratch);
+        let _ = std::fs::remove_dir_all(&scratch);
+        let new_api = listed?;
+        let path = api.manifest.unwrap_or_else(|| output.join(FILE_NAME));
+
+        if !api.check {
+            let text: String = new_api.iter().map(|line| format!("{line}\n")).collect();
+            crate::write_generated(&path, text, false)?;
+            continue;
+        }
+        let recorded = std::fs::read_to_string(&path).with_whatever_context(|_| {
+            format!(
+                "reading {}: record the API with `witffi api` first",
+                path.display()
+            )
+        })?;
+        let old_api: Vec<&str> = recorded.lines().filter(|line| !line.is_empty()).collect();
+        let removed: Vec<&&str> = old_api
+            .iter()
+            .filter(|line| !new_api.iter().any(|new| new == **line))
+            .collect();
+        let added: Vec<&String> = new_api
+            .iter()
+            .filter(|line| !old_api.contains(&line.as_str()))
+            .collect();
+        for declaration in &removed {
+            println!("- {declaration}");
+        }
+        for declaration in &added {
+            println!("+ {declaration}");
+        }
+        if removed.is_empty() && !added.is_empty() {
+            eprintln!(
+                "{} declaration(s) added to the Go API of {}: run `witffi api` to record them",
+                added.len(),
+                path.display()
+            );
+        }
+        broken += removed.len();
+    }
+    if broken > 0 {
+        whatever!("{broken} declaration(s) of the recorded Go API removed or changed");
+    }
+    Ok(())
+}
+
+/// The manifest of the Go packages under `dir`: the exported declarations
+/// of each non-test Go file, as `pkg <package>, <declaration>` lines,
+/// sorted. The package at `dir` is named by its package clause, and those
+/// below it by their directory (e.g. `parser`).
+fn manifest(dir: &Path) -> Result<Vec<String>> {
+    let mut files = Vec::new();
+    crate::check::list_files(dir, Path::new(""), &mut files)
+        .with_whatever_context(|_| format!("listing {}", dir.display()))?;
+
+    let mut lines = Vec::new();
+    for file in files {
+        let name = file.to_string_lossy();
+        if !name.ends_with(".go") || name.ends_with("_test.go") {
+            continue;
+        }
+        let code = std::fs::read_to_string(dir.join(&file))
+            .with_whatever_context(|_| format!("reading generated {}", file.display()))?;
+        let package = match file
+            .parent()
+            .filter(|parent| !parent.as_os_str().is_empty())
+        {
+            Some(parent) => parent.to_string_lossy().replace('\\', "/"),
+            None => code
+                .lines()
+                .find_map(|line| line.strip_prefix("package "))
+                .unwrap_or_default()
+                .trim()
+                .to_string(),
+        };
+        lines.extend(
+            go_api(&code)
+                .into_iter()
+                .map(|declaration| format!("pkg {package}, {declaration}")),
+        );
+    }
+    lines.sort();
+    lines.dedup();
+    Ok(lines)
+}
+
+/// The exported top-level declarations of Go `code`: the first line of
+/// each `func` and `type` declaration naming an exported identifier, or a
+/// method of an exported type; the exported constants and variables, as
+/// `const <name> <type>` or `var <name> <type>`; and the exported fields of
+/// exported structs and methods of exported interfaces, as `field
+/// <type>.<field>` and `method <type>.<method>`.
+///
+/// Signatures are listed without the names of their parameters, results
+/// and receivers, as in Go's `api/go1.txt`, since renaming them breaks no
+/// caller.
+pub fn go_api(code: &str) -> Vec<String> {
+    let exported = |name: &str| name.starts_with(|c: char| c.is_uppercase());
+    let mut api = Vec::new();
+    // How the members of the `const (`, `var (` or type body being read are
+    // listed, empty for those of unexported types
+    let mut block: Option<String> = None;
+    for line in code.lines() {
+        if let Some(kind) = &block {
+            if line == ")" || line == "}" {
+                block = None;
+            } else if let Some(member) = line.strip_prefix('\t').filter(|m| !m.starts_with('\t')) {
+                // Without comments and gofmt's alignment, which moves as
+                // members come and go
+                let member = member.split(" //").next().unwrap_or_default();
+                let member = member.split_whitespace().collect::<Vec<_>>().join(" ");
+                if !kind.is_empty() && exported(&member) {
+                    api.push(match kind.as_str() {
+                        "const" | "var" => {
+                            let name = member.split(" =").next().unwrap_or(&member);
+                            format!("{kind} {name}")
+                        }
+                        _ if kind.starts_with("method ") => match member.find('(') {
+                            Some(open) => {
+                                format!(
+                                    "{kind}.{}{}",
+                                    &member[..open],
+                                    unnamed_func(&member[open..])
+                                )
+                            }
+                            // An embedded interface
+                            None => format!("{kind}.{member}"),
+                        },
+                        _ => match member.split_once(' ') {
+                            Some((name, ty)) => format!("{kind}.{name} {}", unnamed_type(ty)),
+                            // An embedded type
+                            None => format!("{kind}.{member}"),
+                        },
+                    });
+                }
+            }
+            continue;
+        }
+
+        if line == "const (" || line == "var (" {
+            block = Some(line.trim_end_matches(" (").to_string());
+            continue;
+        }
+        for keyword in ["const ", "var "] {
+            if let Some(rest) = line.strip_prefix(keyword).filter(|rest| exported(rest)) {
+                let name = rest.split(" =").next().unwrap_or(rest);
+                api.push(format!("{keyword}{name}"));
+            }
+        }
+        if let Some(rest) = line.strip_prefix("type ") {
+            let name = rest.split([' ', '[']).next().unwrap_or(rest);
+            let mut members = String::new();
+            if exported(name) {
+                let declaration = line.trim_end_matches('{').trim_end();
+                api.push(match declaration.strip_prefix(&format!("type {name} ")) {
+                    Some(ty) => format!("type {name} {}", unnamed_type(ty)),
+                    None => declaration.to_string(),
+                });
+                if rest.ends_with(" struct {") {
+                    members = format!("field {name}");
+                } else if rest.ends_with(" interface {") {
+                    members = format!("method {name}");
+                }
+            }
+            if line.ends_with('{') {
+                block = Some(members);
+            }
+            continue;
+        }
+        let Some(mut rest) = line.strip_prefix("func ") else {
+            continue;
+        };
+        if let Some(method) = rest.strip_prefix('(') {
+            let Some((receiver, after)) = method.split_once(") ") else {
+                continue;
+            };
+            let receiver_type = receiver.rsplit(' ').next().unwrap_or(receiver);
+            if !exported(receiver_type.trim_start_matches('*')) {
+                continue;
+            }
+            rest = after;
+        }
+        if exported(rest) {
+            api.push(unnamed_decl(line.trim_end_matches('{').trim_end()));
+        }
+    }
+    api
+}
+
+/// The `func` declaration `decl`, up to its body, without the names of its
+/// receiver, parameters and results.
+fn unnamed_decl(decl: &str) -> String {
+    let Some(mut rest) = decl.strip_prefix("func ") else {
+        return decl.to_string();
+    };
+    let mut unnamed = "func ".to_string();
+    if rest.starts_with('(') {
+        let Some(close) = closing(rest) else {
+            return decl.to_string();
+        };
+        unnamed.push_str(&format!("({}) ", unnamed_params(&rest[1..close])));
+        rest = rest[close + 1..].trim_start();
+    }
+    // The name and any type parameters, which the signature refers to
+    let Some(mut open) = rest.find(['(', '[']) else {
+        return decl.to_string();
+    };
+    if rest[open..].starts_with('[') {
+        let Some(close) = closing(&rest[open..]) else {
+            return decl.to_string();
+        };
+        open += close + 1;
+    }
+    unnamed.push_str(&rest[..open]);
+    unnamed.push_str(&unnamed_func(&rest[open..]));
+    unnamed
+}
+
+/// The signature `sig`, `(<parameters>) <results>`, without the names of
+/// its parameters and results.
+fn unnamed_func(sig: &str) -> String {
+    let Some(close) = closing(sig) else {
+        return sig.to_string();
+    };
+    let params = unnamed_params(&sig[1..close]);
+    let results = sig[close + 1..].trim();
+    let results = match results
+        .strip_prefix('(')
+        .filter(|_| closing(results) == Some(results.len() - 1))
+    {
+        // A single result needs no parentheses once unnamed
+        Some(list) => match unnamed_params(&list[..list.len() - 1]) {
+            list if split_top(&list, ',').len() > 1 => format!(" ({list})"),
+            list => format!(" {list}"),
+        },
+        None if results.is_empty() => String::new(),
+        None => format!(" {}", unnamed_type(results)),
+    };
+    format!("({params}){results}")
+}
+
+/// The types of the parameter (or result) list `list`, e.g. `int, int` for
+/// `a, b int`.
+fn unnamed_params(list: &str) -> String {
+    let items: Vec<&str> = split_top(list, ',')
+        .into_iter()
+        .map(str::trim)
+        .filter(|item| !item.is_empty())
+        .collect();
+    // Go names all parameters or none: `a, b int` names two, `chan int` none
+    let named = items.iter().any(|item| {
+        split_top(item, ' ').first().is_some_and(|first| {
+            first.len() < item.len()
+                && first.chars().all(|c| c.is_alphanumeric() || c == '_')
+                && !["chan", "func", "interface", "map", "struct"].contains(first)
+        })
+    });
+    let mut types: Vec<String> = Vec::new();
+    let mut ty = "";
+    for item in items.iter().rev() {
+        if !named {
+            ty = item;
+        } else if let Some((_, item_ty)) = item
+            .split_once(' ')
+            .filter(|(name, _)| !name.contains(['(', '[']))
+        {
+            ty = item_ty.trim();
+        }
+        types.push(unnamed_type(ty));
+    }
+    types.reverse();
+    types.join(", ")
+}
+
+/// The type `ty`, without the names of the parameters and results of the
+/// function type it is, if it is one.
+fn unnamed_type(ty: &str) -> String {
+    if let Some(variadic) = ty.strip_prefix("...") {
+        return format!("...{}", unnamed_type(variadic));
+    }
+    if ty.starts_with("func(") {
+        format!("func{}", unnamed_func(&ty[4..]))
+    } else {
+        ty.to_string()
+    }
+}
+
+/// The index of the bracket closing the one `s` starts with.
+fn closing(s: &str) -> Option<usize> {
+    let mut depth = 0;
+    for (i, c) in s.char_indices() {
+        match c {
+            '(' | '[' | '{' => depth += 1,
+            ')' | ']' | '}' => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some(i);
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+/// `s` split at each `separator` outside brackets.
+fn split_top(s: &str, separator: char) -> Vec<&str> {
+    let mut parts = Vec::new();
+    let (mut depth, mut start) = (0, 0);
+    for (i, c) in s.char_indices() {
+        match c {
+            '(' | '[' | '{' => depth += 1,
+            ')' | ']' | '}' => depth -= 1,
+            c if c == separator && depth == 0 => {
+                parts.push(&s[start..i]);
+                start = i + c.len_utf8();
+            }
+            _ => {}
+        }
+    }
+    parts.push(&s[start..]);
+    parts
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_go_api_members() {
+        let code = "\
+package demo
+
+type Status uint8
+
+const (
+\tStatusPending Status = iota
+\tStatusPaid
+\tstatusCount
+)
+
+var ErrClosed = errors.New(\"closed\")
+
+var (
+\tbufferPool = sync.Pool{
+\t\tNew: func() any { return nil },
+\t}
+)
+
+type Order struct {
+\tID     uint64
+\tStatus Status // the latest status
+\tnote   string
+}
+
+type order struct {
+\tID uint64
+}
+
+type Fetcher interface {
+\tFetch(url string) ([]byte, error)
+}
+";
+        assert_eq!(
+            go_api(code),
+            [
+                "type Status uint8",
+                "const StatusPending Status",
+                "const StatusPaid",
+                "var ErrClosed",
+                "type Order struct",
+                "field Order.ID uint64",
+                "field Order.Status Status",
+                "type Fetcher interface",
+                "method Fetcher.Fetch(string) ([]byte, error)",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_go_api() {
+        let code = "\
+package demo
+
+type Counter struct {
+\thandle *C.FfiCounter
+}
+
+type counterRef struct{}
+
+func NewCounter(start uint32) *Counter {
+\treturn nil
+}
+
+func (c *Counter) Get() uint32 {
+\treturn 0
+}
+
+func (c *Counter) claim() bool {
+\treturn true
+}
+
+func (c counterRef) Get() uint32 {
+\treturn 0
+}
+
+func lowerString(s string) C.FfiStr {
+";
+        assert_eq!(
+            go_api(code),
+            [
+                "type Counter struct",
+                "func NewCounter(uint32) *Counter",
+                "func (*Counter) Get() uint32",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_go_api_unnamed() {
+        let code = "\
+package demo
+
+type Handler func(ctx context.Context, req []byte) (resp []byte, err error)
+
+type Fetcher interface {
+\tFetch(url string, retries int) (body []byte, err error)
+}
+
+type Stream[T any] struct {
+\tC <-chan T
+}
+
+type Options struct {
+\tOnEvent func(name string, data map[string]any)
+}
+
+func Parse(a, b string, rest ...int) (n int, err error) {
+
+func (p *Parser) Each(yield func(index int, item string) bool) error {
+
+func Map[T any](items []T, f func(item T) T) []T {
+
+func Pipe(in <-chan int, out chan<- int, done func()) {
+";
+        assert_eq!(
+            go_api(code),
+            [
+                "type Handler func(context.Context, []byte) ([]byte, error)",
+                "type Fetcher interface",
+                "method Fetcher.Fetch(string, int) ([]byte, error)",
+                "type Stream[T any] struct",
+                "field Stream.C <-chan T",
+                "type Options struct",
+                "field Options.OnEvent func(string, map[string]any)",
+                "func Parse(string, string, ...int) (int, error)",
+                "func (*Parser) Each(func(int, string) bool) error",
+                "func Map[T any]([]T, func(T) T) []T",
+                "func Pipe(<-chan int, chan<- int, func())",
+            ]
+        );
+
+        // Renaming a parameter changes nothing
+        assert_eq!(
+            go_api("func Get(key string) (value int) {\n"),
+            go_api("func Get(name string) int {\n"),
+        );
+    }
+}
@@ -0,0 +1,530 @@
+//! `witffi build`, building the Rust library the Go bindings link against.
+//!
+//! cargo builds the library for the target Go is building for, and the
+//! static archive is copied where the bindings' `#cgo LDFLAGS: -l<lib>`
+//! can find it, with the C headers their `#include`s name beside them.
+//! Every C function the generated Go calls is then looked up in the
+//! archive's symbol table, so that a library missing an export (built from
+//! an older WIT, say) fails here, naming the functions, rather than at link
+//! time.
+//!
+//! Bindings with the wazero or wasmtime backend embed the library as
+//! WebAssembly instead: cargo builds it for `wasm32-wasip1`, and the module
+//! is copied into the package for its `//go:embed`.
+//!
+//! Bindings with the sidecar backend run a binary of the crate instead,
+//! `<lib>-sidecar`, which cargo builds for the target Go is building for.
+//! Bindings with the gRPC backend have nothing to build: they call a
+//! service deployed on its own.
+
+use std::collections::{BTreeSet, HashSet};
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+use snafu::prelude::*;
+
+use crate::{BuildArgs, GenerateArgs, GoBackend, Language, Result};
+
+/// The C headers the Rust generation writes beside the scaffolding, which
+/// cgo needs beside the Go bindings.
+const HEADERS: &[&str] = &["ffi.h", "witffi_types.h"];
+
+/// The target the library is built for to run as WebAssembly.
+const WASM_TARGET: &str = "wasm32-wasip1";
+
+/// Build the library of every Go binding in `builds` and place it for
+/// them.
+pub fn run(builds: Vec<BuildArgs>) -> Result<()> {
+    // Every language comes from the same command line, so shares its
+    // cargo options
+    let Some(first) = builds.first() else {
+        return Ok(());
+    };
+    let go: Vec<&GenerateArgs> = builds
+        .iter()
+        .map(|build| &build.generate)
+        .filter(|args| matches!(args.lang, Some(Language::Go)))
+        .collect();
+    if go.is_empty() {
+        whatever!(
+            "no Go bindings to build the library for: pass --lang go or add a [go] table to {}",
+            crate::config::FILE_NAME
+        );
+    }
+    let (wasm, go): (Vec<&GenerateArgs>, Vec<&GenerateArgs>) = go
+        .into_iter()
+        .filter(|args| !matches!(args.go_backend, GoBackend::Grpc))
+        .partition(|args| matches!(args.go_backend, GoBackend::Wazero | GoBackend::Wasmtime));
+    if !wasm.is_empty() {
+        build_wasm(first, &wasm)?;
+    }
+    let (sidecar, go): (Vec<&GenerateArgs>, Vec<&GenerateArgs>) = go
+        .into_iter()
+        .partition(|args| matches!(args.go_backend, GoBackend::Sidecar));
+    if !sidecar.is_empty() {
+        build_sidecar(first, &sidecar)?;
+    }
+    if go.is_empty() {
+        return Ok(());
+    }
+    // The headers are generated with the Rust scaffolding
+    let headers_dir = builds
+        .iter()
+        .map(|build| &build.generate)
+        .find(|args| matches!(args.lang, Some(Language::Rust)))
+        .and_then(|args| args.output.clone());
+
+    let target = match &first.target {
+        Some(target) => Some(target.clone()),
+        None => go_target()?,
+    };
+    let artifacts = cargo_build(first, target.as_deref())?;
+
+    for args in go {
+        let Some(output) = &args.output else {
+            whatever!(
+                "no Go output directory given: pass --output or set `output` in {}",
+                crate::config::FILE_NAME
+            );
+        };
+        let lib_name = args.lib_name.as_deref().unwrap_or("witffi");
+        let archive_name = match target.as_deref() {
+            Some(target) if target.ends_with("-msvc") => format!("{lib_name}.lib"),
+            _ => format!("lib{lib_name}.a"),
+        };
+        let archive = artifacts.join(&archive_name);
+        let bytes = std::fs::read(&archive).with_whatever_context(|_| {
+            format!(
+                "reading {}: is `{lib_name}` (--lib-name) the library's name, built as a \
+                 staticlib?",
+                archive.display()
+            )
+        })?;
+        let symbols = archive_symbols(&bytes)
+            .map_err(|message| format!("reading {}: {message}", archive.display()))
+            .map_err(snafu::FromString::without_source)?;
+
+        for package in go_packages(output, args.all_worlds) {
+            if let Some(headers_dir) = headers_dir.as_ref().filter(|dir| **dir != package) {
+                for header in HEADERS {
+                    copy(&headers_dir.join(header), &package.join(header))?;
+                }
+            }
+            let missing = missing_functions(&package, &symbols)?;
+            if !missing.is_empty() {
+                whatever!(
+                    "{} does not define {} called by the Go bindings in {}: {}",
+                    archive.display(),
+                    if missing.len() == 1 {
+                        "a function"
+                    } else {
+                        "functions"
+                    },
+                    package.display(),
+                    missing.into_iter().collect::<Vec<_>>().join(", ")
+                );
+            }
+            let lib_dir = first.lib_dir.clone().unwrap_or_else(|| package.clone());
+            std::fs::create_dir_all(&lib_dir)
+                .with_whatever_context(|_| format!("creating {}", lib_dir.display()))?;
+            copy(&archive, &lib_dir.join(&archive_name))?;
+            let lib_dir = std::path::absolute(&lib_dir).unwrap_or(lib_dir);
+            eprintln!(
+                "Built {} for {}: link with CGO_LDFLAGS=-L{}",
+                archive_name,
+                package.display(),
+                lib_dir.display()
+            );
+        }
+    }
+    Ok(())
+}
+
+/// Build the library as WebAssembly and copy the module into the package
+/// of each WebAssembly binding in `go`, which embeds it.
+fn build_wasm(build: &BuildArgs, go: &[&GenerateArgs]) -> Result<()> {
+    let artifacts = cargo_build(build, Some(WASM_TARGET))?;
+    for args in go {
+        let Some(output) = &args.output else {
+            whatever!(
+                "no Go output directory given: pass --output or set `output` in {}",
+                crate::config::FILE_NAME
+            );
+        };
+        let lib_name = args.lib_name.as_deref().unwrap_or("witffi");
+        let module_name = format!("{lib_name}.wasm");
+        let module = artifacts.join(&module_name);
+        if !module.is_file() {
+            whatever!(
+                "{} was not built: is `{lib_name}` (--lib-name) the library's name, built as a \
+                 cdylib for {WASM_TARGET}?",
+                module.display()
+            );
+        }
+        for package in go_packages(output, args.all_worlds) {
+            copy(&module, &package.join(&module_name))?;
+            eprintln!(
+                "Built {module_name} for {}: go build embeds it",
+                package.display()
+            );
+        }
+    }
+    Ok(())
+}
+
+/// Build the sidecar binary each sidecar binding in `go` starts.
+fn build_sidecar(build: &BuildArgs, go: &[&GenerateArgs]) -> Result<()> {
+    let target = match &build.target {
+        Some(target) => Some(target.clone()),
+        None => go_target()?,
+    };
+    let artifacts = cargo_build(build, target.as_deref())?;
+    let exe_suffix = match target.as_deref() {
+        Some(target) if target.contains("-windows") => ".exe",
+        Some(_) => "",
+        None => std::env::consts::EXE_SUFFIX,
+    };
+    for args in go {
+        let lib_name = args.lib_name.as_deref().unwrap_or("witffi");
+        let binary_name = format!("{lib_name}-sidecar{exe_suffix}");
+        let binary = artifacts.join(&binary_name);
+        if !binary.is_file() {
+            whatever!(
+                "{} was not built: does the crate have a binary named `{lib_name}-sidecar` \
+                 calling witffi_serve_sidecar?",
+                binary.display()
+            );
+        }
+        eprintln!(
+            "Built {binary_name}: put {} on the PATH, or pass it to Start",
+            binary.display()
+        );
+    }
+    Ok(())
+}
+
+/// Run `cargo build` as `build` asks, for `target` if given, returning the
+/// directory the artifacts are written to.
+fn cargo_build(build: &BuildArgs, target: Option<&str>) -> Result<PathBuf> {
+    let mut cargo = Command::new("cargo");
+    cargo.arg("build");
+    if build.release {
+        cargo.arg("--release");
+    }
+    if let Some(profile) = &build.profile {
+        cargo.args(["--profile", profile]);
+    }
+    if let Some(target) = target {
+        cargo.args(["--target", target]);
+    }
+    if let Some(package) = &build.package {
+        cargo.args(["--package", package]);
+    }
+    if let Some(manifest_path) = &build.manifest_path {
+        cargo.arg("--manifest-path").arg(manifest_path);
+    }
+    if let Some(target_dir) = &build.target_dir {
+        cargo.arg("--target-dir").arg(target_dir);
+    }
+    let status = cargo.status().whatever_context("running cargo build")?;
+    if !status.success() {
+        whatever!("cargo build failed ({status})");
+    }
+
+    let target_dir = match &build.target_dir {
+        Some(target_dir) => target_dir.clone(),
+        None => match std::env::var_os("CARGO_TARGET_DIR") {
+            Some(target_dir) => PathBuf::from(target_dir),
+            None => workspace_root(build.manifest_path.as_deref())?.join("target"),
+        },
+    };
+    let profile_dir = match build.profile.as_deref() {
+        None if build.release => "release",
+        None | Some("dev" | "test") => "debug",
+        Some("bench") => "release",
+        Some(profile) => profile,
+    };
+    Ok(match target {
+        Some(target) => target_dir.join(target).join(profile_dir),
+        None => target_dir.join(profile_dir),
+    })
+}
+
+/// The root directory of the cargo workspace of `manifest_path`, or of the
+/// current directory.
+fn workspace_root(manifest_path: Option<&Path>) -> Result<PathBuf> {
+    let mut cargo = Command::new("cargo");
+    cargo.args(["locate-project", "--workspace", "--message-format", "plain"]);
+    if let Some(manifest_path) = manifest_path {
+        cargo.arg("--manifest-path").arg(manifest_path);
+    }
+    let output = cargo
+        .output()
+        .whatever_context("running cargo locate-project")?;
+    if !output.status.success() {
+        whatever!(
+            "locating the cargo workspace: {}",
+            String::from_utf8_lossy(&output.stderr).trim()
+        );
+    }
+    let manifest = PathBuf::from(String::from_utf8_lossy(&output.stdout).trim());
+    Ok(manifest.parent().unwrap_or(Path::new("")).to_path_buf())
+}
+
+/// The Rust target triple to build for when Go cross-compiles, as `$GOOS`
+/// and `$GOARCH` ask. None when neither is set, building for the host.
+fn go_target() -> Result<Option<String>> {
+    let goos = std::env::var("GOOS").ok();
+    let goarch = std::env::var("GOARCH").ok();
+    if goos.is_none() && goarch.is_none() {
+        return Ok(None);
+    }
+    let goos = goos.unwrap_or_else(|| std::env::consts::OS.replace("macos", "darwin"));
+    let goarch = goarch.unwrap_or_else(|| match std::env::consts::ARCH {
+        "x86_64" => "amd64".to_string(),
+        "aarch64" => "arm64".to_string(),
+        arch => arch.to_string(),
+    });
+    let target = match (goos.as_str(), goarch.as_str()) {
+        ("linux", "amd64") => "x86_64-unknown-linux-gnu",
+        ("linux", "arm64") => "aarch64-unknown-linux-gnu",
+        ("linux", "386") => "i686-unknown-linux-gnu",
+        ("linux", "arm") => "armv7-unknown-linux-gnueabihf",
+        ("darwin", "amd64") => "x86_64-apple-darwin",
+        ("darwin", "arm64") => "aarch64-apple-darwin",
+        ("ios", "arm64") => "aarch64-apple-ios",
+        ("android", "arm64") => "aarch64-linux-android",
+        ("android", "amd64") => "x86_64-linux-android",
+        ("windows", "amd64") => "x86_64-pc-windows-gnu",
+        ("freebsd", "amd64") => "x86_64-unknown-freebsd",
+        _ => whatever!("no Rust target known for GOOS={goos} GOARCH={goarch}: pass --target"),
+    };
+    Ok(Some(target.to_string()))
+}
+
+/// The directories of the Go packages generated into `output`: one per
+/// world with `--all-worlds`.
+fn go_packages(output: &Path, all_worlds: bool) -> Vec<PathBuf> {
+    if !all_worlds {
+        return vec![output.to_path_buf()];
+    }
+    let Ok(entries) = std::fs::read_dir(output) else {
+        return Vec::new();
+    };
+    let mut packages: Vec<PathBuf> = entries
+        .filter_map(|entry| entry.ok())
+        .map(|entry| entry.path())
+        .filter(|path| path.is_dir())
+        .collect();
+    packages.sort();
+    packages
+}
+
+/// The C functions called by the generated Go files of `package` that
+/// `symbols` does not define. The library's functions are those `ffi.h`
+/// declares, leaving out the C library and the Go functions exported to
+/// Rust.
+fn missing_functions(package: &Path, symbols: &HashSet<String>) -> Result<BTreeSet<String>> {
+    let header_path = package.join("ffi.h");
+    let header = std::fs::read_to_string(&header_path).with_whatever_context(|_| {
+        format!(
+            "reading {}, the C header of the Rust library: generate the Rust scaffolding \
+             into the Go package, or both from {}",
+            header_path.display(),
+            crate::config::FILE_NAME
+        )
+    })?;
+    let declared = c_calls(&header);
+    let entries = std::fs::read_dir(package)
+        .with_whatever_context(|_| format!("reading {}", package.display()))?;
+    let mut missing = BTreeSet::new();
+    for path in entries
+        .filter_map(|entry| entry.ok())
+        .map(|entry| entry.path())
+    {
+        if path.extension().is_none_or(|extension| extension != "go") {
+            continue;
+        }
+        let code = std::fs::read_to_string(&path)
+            .with_whatever_context(|_| format!("reading {}", path.display()))?;
+        if !crate::embed::is_generated(&code) {
+            continue;
+        }
+        // Mach-O symbols carry a leading underscore
+        missing.extend(c_references(&code).into_iter().filter(|function| {
+            declared.contains(function)
+                && !symbols.contains(function)
+                && !symbols.contains(&format!("_{function}"))
+        }));
+    }
+    Ok(missing)
+}
+
+/// The names Go `code` refers to as `C.<name>`.
+fn c_references(code: &str) -> BTreeSet<String> {
+    code.match_indices("C.")
+        .filter(|(index, _)| !code[..*index].ends_with(is_ident))
+        .map(|(index, _)| leading_ident(&code[index + 2..]).to_string())
+        .filter(|name| !name.is_empty())
+        .collect()
+}
+
+/// The functions C `header` declares or calls: every name directly
+/// followed by `(`.
+fn c_calls(header: &str) -> BTreeSet<String> {
+    header
+        .match_indices('(')
+        .map(|(index, _)| {
+            let before = &header[..index];
+            &before[before.trim_end_matches(is_ident).len()..]
+        })
+        .filter(|name| !name.is_empty())
+        .map(str::to_string)
+        .collect()
+}
+
+/// The identifier at the start of `text`.
+fn leading_ident(text: &str) -> &str {
+    &text[..text.find(|c| !is_ident(c)).unwrap_or(text.len())]
+}
+
+/// Whether `c` can appear in a C or Go identifier.
+fn is_ident(c: char) -> bool {
+    c.is_ascii_alphanumeric() || c == '_'
+}
+
+/// The symbols defined by the `ar` archive `bytes`, from its symbol table:
+/// the GNU (and Windows) `/` or `/SYM64/` member, or the BSD `__.SYMDEF`
+/// one.
+fn archive_symbols(bytes: &[u8]) -> Result<HashSet<String>, String> {
+    let mut rest = bytes
+        .strip_prefix(b"!<arch>\n")
+        .ok_or("not an ar archive")?;
+    let truncated = || "truncated archive".to_string();
+    while rest.len() >= 60 {
+        let (header, body) = rest.split_at(60);
+        let field = |range: std::ops::Range<usize>| {
+            String::from_utf8_lossy(&header[range]).trim().to_string()
+        };
+        let size: usize = field(48..58).parse().map_err(|_| truncated())?;
+        let data = body.get(..size).ok_or_else(truncated)?;
+        rest = body.get(size + size % 2..).unwrap_or_default();
+
+        let mut name = field(0..16);
+        let mut data = data;
+        // BSD names longer than 16 bytes follow the header
+        if let Some(len) = name.strip_prefix("#1/") {
+            let len: usize = len.parse().map_err(|_| truncated())?;
+            let long_name = data.get(..len).ok_or_else(truncated)?;
+            name = String::from_utf8_lossy(long_name)
+                .trim_end_matches('\0')
+                .to_string();
+            data = &data[len..];
+        }
+        match name.as_str() {
+            "/" => return Ok(gnu_symbols(data, 4).ok_or_else(truncated)?),
+            "/SYM64/" => return Ok(gnu_symbols(data, 8).ok_or_else(truncated)?),
+            "__.SYMDEF" | "__.SYMDEF SORTED" => {
+                return Ok(bsd_symbols(data).ok_or_else(truncated)?);
+            }
+            _ => {}
+        }
+    }
+    Err("the archive has no symbol table".to_string())
+}
+
+/// The names in a GNU symbol table: a big-endian count of `width` bytes,
+/// that many member offsets, then the NUL-terminated names.
+fn gnu_symbols(data: &[u8], width: usize) -> Option<HashSet<String>> {
+    let count = data
+        .get(..width)?
+        .iter()
+        .fold(0usize, |count, byte| count << 8 | *byte as usize);
+    let names = data.get(width + count * width..)?;
+    Some(
+        names
+            .split(|byte| *byte == 0)
+            .take(count)
+            .map(|name| String::from_utf8_lossy(name).into_owned())
+            .collect(),
+    )
+}
+
+/// The names in a BSD symbol table: the little-endian byte length of the
+/// (name offset, member offset) pairs, the pairs, then the byte length of
+/// the string table and the table.
+fn bsd_symbols(data: &[u8]) -> Option<HashSet<String>> {
+    let u32_at = |at: usize| {
+        let bytes = data.get(at..at + 4)?;
+        Some(u32::from_le_bytes(bytes.try_into().ok()?) as usize)
+    };
+    let pairs_len = u32_at(0)?;
+    let strings = data.get(4 + pairs_len + 4..)?;
+    (0..pairs_len / 8)
+        .map(|pair| {
+            let offset = u32_at(4 + pair * 8)?;
+            let name = strings.get(offset..)?.split(|byte| *byte == 0).next()?;
+            Some(String::from_utf8_lossy(name).into_owned())
+        })
+        .collect()
+}
+
+/// Copy the file at `from` to `to`.
+fn copy(from: &Path, to: &Path) -> Result<()> {
+    std::fs::copy(from, to)
+        .with_whatever_context(|_| format!("copying {} to {}", from.display(), to.display()))?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_symbols() {
+        let code = "\
+func Parse(input string) {
+\tptr := C.zcash_eip681_parser_parse(C.CString(input))
+\tdefer C.free(unsafe.Pointer(ptr))
+\t_ = ABC.unrelated
+}
+";
+        assert_eq!(
+            c_references(code),
+            BTreeSet::from(["CString", "free", "zcash_eip681_parser_parse"].map(String::from))
+        );
+        let header = "typedef void (*FfiLogSink)(uint8_t level);\nvoid *zcash_eip681_parser_parse(FfiByteSlice input);\n";
+        assert_eq!(
+            c_calls(header),
+            BTreeSet::from(["zcash_eip681_parser_parse".to_string()])
+        );
+
+        // A GNU archive holding only its symbol table
+        let mut table = Vec::new();
+        table.extend(2u32.to_be_bytes());
+        table.extend([0; 8]);
+        table.extend(b"zcash_eip681_parser_parse\0rust_eh_personality\0");
+        let mut archive = b"!<arch>\n".to_vec();
+        archive.extend(
+            format!(
+                "{:<16}{:<12}{:<6}{:<6}{:<8}{:<10}`\n",
+                "/",
+                0,
+                0,
+                0,
+                0,
+                table.len()
+            )
+            .bytes(),
+        );
+        archive.extend(&table);
+        assert_eq!(
+            archive_symbols(&archive),
+            Ok(HashSet::from([
+                "zcash_eip681_parser_parse".to_string(),
+                "rust_eh_personality".to_string(),
+            ]))
+        );
+        assert!(archive_symbols(b"!<arch>\n").is_err());
+    }
+}
@@ -0,0 +1,247 @@
+//! `witffi check`, failing when committed bindings are out of date.
+//!
+//! Each language is generated into a scratch directory, and every file
+//! written there is compared with the one of the same name in the real
+//! output directory. Of the files in the output directory that generation
+//! does not write, Go marked as generated is left over from an earlier
+//! generation and is stale; others, such as hand-written Go beside the
+//! bindings, are left alone.
+
+use std::collections::BTreeSet;
+use std::path::{Path, PathBuf};
+
+use snafu::prelude::*;
+
+use crate::{GenerateArgs, Result};
+
+/// Lines of unchanged context shown around a change.
+const CONTEXT: usize = 3;
+
+/// Generate each of `checks` into a scratch directory and compare the
+/// result with its output directory, printing a diff of every stale file.
+pub fn run(checks: Vec<GenerateArgs>) -> Result<()> {
+    let mut stale = 0;
+    for (index, args) in checks.into_iter().enumerate() {
+        let (output, scratch) = generate_scratch(args, index)?;
+        let compared = compare(&scratch, &output);
+        let _ = std::fs::remove_dir_all(&scratch);
+        stale += compared?;
+    }
+    if stale > 0 {
+        whatever!("{stale} generated file(s) out of date; run `witffi generate` to update them");
+    }
+    eprintln!("Generated files are up to date");
+    Ok(())
+}
+
+/// Generate `args` into a scratch directory, as it would be generated into
+/// its output directory. Returns the output directory and the scratch
+/// directory, which the caller removes; `index` tells apart the scratch
+/// directories of one run.
+pub fn generate_scratch(args: GenerateArgs, index: usize) -> Result<(PathBuf, PathBuf)> {
+    let Some(output) = args.output.clone() else {
+        whatever!(
+            "no output directory given: pass --output or set `output` in {}",
+            crate::config::FILE_NAME
+        );
+    };
+    let scratch = std::env::temp_dir().join(format!("witffi-check-{}-{index}", std::process::id()));
+    // Subpackages import the real output directory, not the scratch one
+    let go_import_path = args.go_import_path.clone().or_else(|| {
+        args.go_subpackages
+            .then(|| crate::go_module_path(&output))
+            .flatten()
+    });
+    // Bindings generated into an existing package take its name
+    let go_package = match args.all_worlds {
+        false => crate::embed::package_name(&output, args.go_package.as_deref())?,
+        true => args.go_package.clone(),
+    };
+    let generated = crate::generate(
+        GenerateArgs {
+            output: Some(scratch.clone()),
+            final_output: Some(output.clone()),
+            go_import_path,
+            go_package,
+            // The scratch directory has nothing to reuse
+            incremental: false,
+            ..args
+        },
+        true,
+    );
+    if let Err(err) = generated {
+        let _ = std::fs::remove_dir_all(&scratch);
+        return Err(err);
+    }
+    Ok((output, scratch))
+}
+
+/// Compare every file under `scratch` with its counterpart under `output`,
+/// printing a diff for each that differs and naming each generated Go file
+/// of `output` that `scratch` lacks. Returns how many are stale.
+fn compare(scratch: &Path, output: &Path) -> Result<usize> {
+    let mut files = Vec::new();
+    list_files(scratch, Path::new(""), &mut files)
+        .with_whatever_context(|_| format!("listing {}", scratch.display()))?;
+    files.sort();
+
+    let mut stale = 0;
+    for file in leftovers(&files, output)? {
+        println!("{} is no longer generated", output.join(file).display());
+        stale += 1;
+    }
+    for file in files {
+        let generated = std::fs::read(scratch.join(&file))
+            .with_whatever_context(|_| format!("reading generated {}", file.display()))?;
+        let committed_path = output.join(&file);
+        let Ok(committed) = std::fs::read(&committed_path) else {
+            println!("{} is missing", committed_path.display());
+            stale += 1;
+            continue;
+        };
+        if committed != generated {
+            print!(
+                "{}",
+                diff(
+                    &committed_path.display().to_string(),
+                    &String::from_utf8_lossy(&committed),
+                    &String::from_utf8_lossy(&generated),
+                )
+            );
+            stale += 1;
+        }
+    }
+    Ok(stale)
+}
+
+/// The Go files marked as generated in the directories of `output` that
+/// `files` are generated into, but that are not among `files`, relative to
+/// `output` like them.
+fn leftovers(files: &[PathBuf], output: &Path) -> Result<Vec<PathBuf>> {
+    let dirs: BTreeSet<&Path> = files.iter().filter_map(|file| file.parent()).collect();
+    let mut leftovers = Vec::new();
+    for dir in dirs {
+        // A directory generation has yet to create holds nothing left over
+        let Ok(entries) = std::fs::read_dir(output.join(dir)) else {
+            continue;
+        };
+        for entry in entries {
+            let entry = entry
+                .with_whatever_context(|_| format!("listing {}", output.join(dir).display()))?;
+            let file = dir.join(entry.file_name());
+            if files.contains(&file) || file.extension().is_none_or(|ext| ext != "go") {
+                continue;
+            }
+            let Ok(code) = std::fs::read_to_string(output.join(&file)) else {
+                continue;
+            };
+            if crate::embed::is_generated(&code) {
+                leftovers.push(file);
+            }
+        }
+    }
+    leftovers.sort();
+    Ok(leftovers)
+}
+
+/// Collect the paths, relative to `root`, of the files under
+/// `root.join(dir)`.
+pub fn list_files(root: &Path, dir: &Path, files: &mut Vec<PathBuf>) -> std::io::Result<()> {
+    for entry in std::fs::read_dir(root.join(dir))? {
+        let entry = entry?;
+        let path = dir.join(entry.file_name());
+        if entry.file_type()?.is_dir() {
+            list_files(root, &path, files)?;
+        } else {
+            files.push(path);
+        }
+    }
+    Ok(())
+}
+
+/// A unified diff turning the committed file at `path` into the generated
+/// one, as a single hunk spanning the first to the last changed line.
+fn diff(path: &str, committed: &str, generated: &str) -> String {
+    let old: Vec<&str> = committed.lines().collect();
+    let new: Vec<&str> = generated.lines().collect();
+    let prefix = old.iter().zip(&new).take_while(|(a, b)| a == b).count();
+    let suffix = old[prefix..]
+        .iter()
+        .rev()
+        .zip(new[prefix..].iter().rev())
+        .take_while(|(a, b)| a == b)
+        .count();
+
+    let start = prefix.saturating_sub(CONTEXT);
+    let old_end = (old.len() - suffix + CONTEXT).min(old.len());
+    let new_end = (new.len() - suffix + CONTEXT).min(new.len());
+    let mut out = format!("--- {path}\n+++ {path} (generated)\n");
+    out.push_str(&format!(
+        "@@ -{},{} +{},{} @@\n",
+        start + 1,
+        old_end - start,
+        start + 1,
+        new_end - start
+    ));
+    for line in &old[start..prefix] {
+        out.push_str(&format!(" {line}\n"));
+    }
+    for line in &old[prefix..old.len() - suffix] {
+        out.push_str(&format!("-{line}\n"));
+    }
+    for line in &new[prefix..new.len() - suffix] {
+        out.push_str(&format!("+{line}\n"));
+    }
+    for line in &old[old.len() - suffix..old_end] {
+        out.push_str(&format!(" {line}\n"));
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_leftovers() {
+        let dir = std::env::temp_dir().join(format!("witffi-leftovers-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let generated = "// Code generated by witffi. DO NOT EDIT.\n\npackage demo\n";
+        std::fs::write(dir.join("bindings.go"), generated).unwrap();
+        std::fs::write(dir.join("feature_old_bindings.go"), generated).unwrap();
+        std::fs::write(dir.join("wallet.go"), "package demo\n").unwrap();
+        std::fs::write(dir.join("notes.txt"), generated).unwrap();
+
+        let files = vec![PathBuf::from("bindings.go"), PathBuf::from("ffi.h")];
+        assert_eq!(
+            leftovers(&files, &dir).unwrap(),
+            vec![PathBuf::from("feature_old_bindings.go")],
+            "only generated Go no longer written should be left over"
+        );
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_diff() {
+        let committed =
+            "package demo\n\n// a\n// b\n// c\n// d\nfunc Old() {}\n// e\n// f\n// g\n// h\n";
+        let generated = "package demo\n\n// a\n// b\n// c\n// d\nfunc New() {}\nfunc Extra() {}\n// e\n// f\n// g\n// h\n";
+        assert_eq!(
+            diff("bindings.go", committed, generated),
+            "\
+--- bindings.go
++++ bindings.go (generated)
+@@ -4,7 +4,8 @@
+ // b
+ // c
+ // d
+-func Old() {}
++func New() {}
++func Extra() {}
+ // e
+ // f
+ // g
+"
+        );
+    }
+}
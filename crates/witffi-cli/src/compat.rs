@@ -0,0 +1,68 @@
+//! `witffi compat`, checking a WIT package for breaking changes against an
+//! older version of it.
+//!
+//! Every change to the world's functions and types is printed, marked
+//! breaking or compatible as code built against the older version sees it
+//! (see `witffi_core::compat`), and the command fails if any is breaking.
+//! Run in CI against the WIT of the last release, it keeps the library's
+//! version honest about its FFI surface.
+
+use std::path::PathBuf;
+
+use snafu::prelude::*;
+use witffi_core::compat::{self, Severity};
+
+use crate::{CompatArgs, Result};
+
+/// Compare the WIT of `compats` with the WIT they are checked against.
+pub fn run(compats: Vec<CompatArgs>) -> Result<()> {
+    // Every language generates from the same WIT, so it is compared once
+    let mut compared: Vec<(PathBuf, Option<String>)> = Vec::new();
+    let mut breaking = 0;
+    for args in compats {
+        let Some(wit) = args.generate.wit.clone() else {
+            whatever!(
+                "no WIT given: pass --wit or set `wit` in {}",
+                crate::config::FILE_NAME
+            );
+        };
+        let key = (wit.clone(), args.generate.world.clone());
+        if compared.contains(&key) {
+            continue;
+        }
+        compared.push(key);
+
+        let (new, new_pkg) = witffi_core::load_wit_package(&wit)
+            .with_whatever_context(|_| format!("loading WIT from {}", wit.display()))?;
+        let new_world = witffi_core::select_world(&new, new_pkg, args.generate.world.as_deref())
+            .whatever_context("selecting the world to compare")?;
+        let (old, old_pkg) = witffi_core::load_wit_package(&args.against)
+            .with_whatever_context(|_| format!("loading WIT from {}", args.against.display()))?;
+        // The old package is expected to name the world the same way
+        let world_name = &new.worlds[new_world].name;
+        let old_world = witffi_core::select_world(&old, old_pkg, Some(world_name))
+            .with_whatever_context(|_| {
+                format!("finding world `{world_name}` in {}", args.against.display())
+            })?;
+
+        let changes = compat::compare_worlds(&old, old_world, &new, new_world);
+        for change in &changes {
+            let severity = match change.severity {
+                Severity::Breaking => "breaking",
+                Severity::Compatible => "compatible",
+            };
+            println!("{severity}: {change}");
+        }
+        breaking += changes
+            .iter()
+            .filter(|change| change.severity == Severity::Breaking)
+            .count();
+        if changes.is_empty() {
+            eprintln!("No changes to world `{world_name}`");
+        }
+    }
+    if breaking > 0 {
+        whatever!("{breaking} breaking change(s): release them as a new major version");
+    }
+    Ok(())
+}
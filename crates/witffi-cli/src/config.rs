@@ -0,0 +1,459 @@
+//! `witffi.toml`, the project configuration read by `witffi generate`.
+//!
+//! The file holds the options of `witffi generate`, named like the
+//! command-line flags without their leading `--`. Top-level keys apply to
+//! every language. A table named after a language (`[rust]`, `[swift]`,
+//! `[kotlin]` or `[go]`) holds the options of that language's bindings,
+//! its `output` directory among them; in `[go]`, the `go-` prefix of the Go
+//! flags may be left out:
+//!
+//! ```toml
+//! wit = "wit"
+//! c-prefix = "zcash_eip681"
+//!
+//! [rust]
+//! output = "src/ffi"
+//!
+//! [go]
+//! output = "go/eip681"
+//! resource-cleanup = "add-cleanup"
+//! timeout = { "parser.parse" = "500ms" }
+//! ```
+//!
+//! `true` sets a flag, arrays repeat an option, and tables give `<key>=<value>`
+//! options such as `--go-timeout` one entry per key. Relative `wit` and
+//! `output` paths are relative to the file, which is found in the current
+//! directory or its nearest parent that has one.
+//!
+//! The settings of a single function may instead be gathered in a
+//! `[functions."<interface>.<function>"]` table, at the top level or in a
+//! language's table, each standing for the flag naming the function:
+//!
+//! ```toml
+//! [functions."parser.parse"]
+//! timeout = "500ms"     # --go-timeout parser.parse=500ms
+//! zero-copy = true      # --go-zero-copy parser.parse
+//! batch = true          # --batch parser.parse
+//! context = true        # --go-context-function parser.parse
+//! rename = "ParseURI"   # --go-function-name parser.parse=ParseURI
+//! skip = false          # --skip parser.parse
+//! ```
+
+use std::path::{Path, PathBuf};
+
+/// The file `witffi generate` reads from the current directory by default.
+pub const FILE_NAME: &str = "witffi.toml";
+
+/// The options whose values are paths, resolved against the file's directory.
+const PATH_OPTIONS: &[&str] = &["wit", "output", "compose"];
+
+/// The table of per-function settings.
+const FUNCTIONS: &str = "functions";
+
+/// The per-function settings, the flag each stands for, and whether it takes
+/// a value (passed as `<function>=<value>`) rather than being switched on.
+const FUNCTION_SETTINGS: &[(&str, &str, bool)] = &[
+    ("timeout", "go-timeout", true),
+    ("zero-copy", "go-zero-copy", false),
+    ("batch", "batch", false),
+    ("context", "go-context-function", false),
+    ("rename", "go-function-name", true),
+    ("skip", "skip", false),
+];
+
+/// A parsed `witffi.toml`.
+#[derive(Debug, Clone)]
+pub struct Config {
+    /// Where the file was read from.
+    path: PathBuf,
+    table: toml::Table,
+}
+
+impl Default for Config {
+    fn default() -> Self {
+        Self {
+            path: PathBuf::new(),
+            table: toml::Table::new(),
+        }
+    }
+}
+
+impl Config {
+    /// Wrap the parsed contents of the configuration file at `path`.
+    pub fn new(table: toml::Table, path: impl Into<PathBuf>) -> Self {
+        Self {
+            path: path.into(),
+            table,
+        }
+    }
+
+    /// The languages among `languages` that the file has a table for, in
+    /// the order given.
+    pub fn languages<'a>(&self, languages: &[&'a str]) -> Vec<&'a str> {
+        languages
+            .iter()
+            .copied()
+            .filter(|lang| self.table.get(*lang).is_some_and(toml::Value::is_table))
+            .collect()
+    }
+
+    /// The command-line arguments the file stands for, for `command` (the
+    /// `generate` subcommand) generating `lang`: the top-level options, then
+    /// `--lang` and the options of the language's table, so that those take
+    /// precedence. Without `lang`, only the top-level options. Tables of the
+    /// other `languages` are skipped.
+    pub fn args(
+        &self,
+        command: &clap::Command,
+        languages: &[&str],
+        lang: Option<&str>,
+    ) -> Result<Vec<String>, String> {
+        let mut args = Vec::new();
+        for (key, value) in &self.table {
+            if value.is_table() && languages.contains(&key.as_str()) {
+                continue;
+            }
+            if key == FUNCTIONS {
+                self.push_function_args(&mut args, command, value)?;
+                continue;
+            }
+            let arg = find_arg(command, key)
+                .ok_or_else(|| format!("unknown option `{key}` in {}", self.path.display()))?;
+            self.push_arg(&mut args, arg, key, value)?;
+        }
+        let Some(lang) = lang else {
+            return Ok(args);
+        };
+        args.push(format!("--lang={lang}"));
+        let Some(section) = self.table.get(lang).and_then(toml::Value::as_table) else {
+            return Ok(args);
+        };
+        let file = self.path.display();
+        for (key, value) in section {
+            if key == "lang" {
+                return Err(format!("`lang` is implied by the [{lang}] table of {file}"));
+            }
+            if key == FUNCTIONS {
+                self.push_function_args(&mut args, command, value)?;
+                continue;
+            }
+            let arg = find_arg(command, &format!("{lang}-{key}"))
+                .or_else(|| find_arg(command, key))
+                .ok_or_else(|| format!("unknown option `{key}` in [{lang}] of {file}"))?;
+            self.push_arg(&mut args, arg, key, value)?;
+        }
+        Ok(args)
+    }
+
+    /// Push the arguments setting `arg` to `value`, given as `key`.
+    fn push_arg(
+        &self,
+        args: &mut Vec<String>,
+        arg: &clap::Arg,
+        key: &str,
+        value: &toml::Value,
+    ) -> Result<(), String> {
+        let long = arg.get_long().unwrap_or(key);
+        if !arg.get_action().takes_values() {
+            match value {
+                toml::Value::Boolean(true) => args.push(format!("--{long}")),
+                toml::Value::Boolean(false) => {}
+                _ => {
+                    return Err(format!(
+                        "`{key}` in {} must be true or false",
+                        self.path.display()
+                    ));
+                }
+            }
+            return Ok(());
+        }
+        let values = match value {
+            toml::Value::Array(items) => items
+                .iter()
+                .map(|item| Ok(self.resolve_path(long, self.scalar(key, item)?)))
+                .collect::<Result<Vec<_>, String>>()?,
+            toml::Value::Table(entries) => entries
+                .iter()
+                .map(|(entry, value)| {
+                    let value = match value {
+                        // Multi-part values, such as custom Go types, are
+                        // comma-separated on the command line
+                        toml::Value::Array(parts) => parts
+                            .iter()
+                            .map(|part| self.scalar(key, part))
+                            .collect::<Result<Vec<_>, _>>()?
+                            .join(","),
+                        value => self.scalar(key, value)?,
+                    };
+                    Ok(format!("{entry}={value}"))
+                })
+                .collect::<Result<Vec<_>, String>>()?,
+            value => vec![self.resolve_path(long, self.scalar(key, value)?)],
+        };
+        args.extend(values.into_iter().map(|value| format!("--{long}={value}")));
+        Ok(())
+    }
+
+    /// `value`, given for the option `long`, resolved against the file's
+    /// directory if the option is a path.
+    fn resolve_path(&self, long: &str, value: String) -> String {
+        if !PATH_OPTIONS.contains(&long) {
+            return value;
+        }
+        let dir = self.path.parent().unwrap_or(Path::new(""));
+        dir.join(value).display().to_string()
+    }
+
+    /// Push the arguments standing for the `[functions]` tables in
+    /// `functions`.
+    fn push_function_args(
+        &self,
+        args: &mut Vec<String>,
+        command: &clap::Command,
+        functions: &toml::Value,
+    ) -> Result<(), String> {
+        let file = self.path.display();
+        let Some(functions) = functions.as_table() else {
+            return Err(format!(
+                "`{FUNCTIONS}` in {file} must be a table of function tables"
+            ));
+        };
+        for (function, settings) in functions {
+            let Some(settings) = settings.as_table() else {
+                return Err(format!(
+                    "`{FUNCTIONS}.\"{function}\"` in {file} must be a table"
+                ));
+            };
+            for (setting, value) in settings {
+                let Some((_, flag, takes_value)) =
+                    FUNCTION_SETTINGS.iter().find(|(name, ..)| name == setting)
+                else {
+                    return Err(format!(
+                        "unknown setting `{setting}` of function `{function}` in {file}"
+                    ));
+                };
+                let flag = find_arg(command, flag)
+                    .and_then(clap::Arg::get_long)
+                    .unwrap_or(flag);
+                match (value, takes_value) {
+                    (toml::Value::Boolean(true), false) => {
+                        args.push(format!("--{flag}={function}"));
+                    }
+                    (toml::Value::Boolean(false), false) => {}
+                    (_, false) => {
+                        return Err(format!(
+                            "`{setting}` of function `{function}` in {file} must be true or false"
+                        ));
+                    }
+                    (toml::Value::Boolean(_), true) => {
+                        return Err(format!(
+                            "`{setting}` of function `{function}` in {file} must be a string"
+                        ));
+                    }
+                    (value, true) => {
+                        let value = self.scalar(setting, value)?;
+                        args.push(format!("--{flag}={function}={value}"));
+                    }
+                }
+            }
+        }
+        Ok(())
+    }
+
+    /// A single option value as command-line text.
+    fn scalar(&self, key: &str, value: &toml::Value) -> Result<String, String> {
+        match value {
+            toml::Value::String(s) => Ok(s.clone()),
+            toml::Value::Integer(i) => Ok(i.to_string()),
+            toml::Value::Float(f) => Ok(f.to_string()),
+            toml::Value::Boolean(b) => Ok(b.to_string()),
+            _ => Err(format!(
+                "`{key}` in {} must be a string, number or boolean, or a list or table of them",
+                self.path.display()
+            )),
+        }
+    }
+}
+
+/// The argument of `command` whose long flag is `name`.
+fn find_arg<'a>(command: &'a clap::Command, name: &str) -> Option<&'a clap::Arg> {
+    command
+        .get_arguments()
+        .find(|arg| arg.get_long() == Some(name))
+}
+
+/// Find the configuration file to read: `path` if given, otherwise
+/// [`FILE_NAME`] in the current directory or the nearest parent that has
+/// one, as when `go generate` runs witffi from a Go package below the
+/// project root.
+pub fn locate(path: Option<&Path>) -> Option<PathBuf> {
+    if let Some(path) = path {
+        return Some(path.to_path_buf());
+    }
+    locate_from(&std::env::current_dir().ok()?)
+}
+
+/// [`FILE_NAME`] in `dir` or the nearest parent that has one.
+fn locate_from(dir: &Path) -> Option<PathBuf> {
+    dir.ancestors()
+        .map(|dir| dir.join(FILE_NAME))
+        .find(|path| path.is_file())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use clap::{Arg, ArgAction, Command};
+
+    const LANGUAGES: &[&str] = &["rust", "go"];
+
+    fn command() -> Command {
+        Command::new("generate").args([
+            Arg::new("wit").long("wit"),
+            Arg::new("lang").long("lang"),
+            Arg::new("output").long("output"),
+            Arg::new("c-prefix").long("c-prefix"),
+            Arg::new("compose")
+                .long("compose")
+                .action(ArgAction::Append),
+            Arg::new("go-context")
+                .long("go-context")
+                .action(ArgAction::SetTrue),
+            Arg::new("go-timeout")
+                .long("go-timeout")
+                .action(ArgAction::Append),
+            Arg::new("go-custom-type")
+                .long("go-custom-type")
+                .action(ArgAction::Append),
+            Arg::new("batch").long("batch").action(ArgAction::Append),
+            Arg::new("skip").long("skip").action(ArgAction::Append),
+            Arg::new("go-function-name")
+                .long("go-function-name")
+                .action(ArgAction::Append),
+        ])
+    }
+
+    fn parse(text: &str) -> Config {
+        Config::new(text.parse().expect("invalid TOML"), "project/witffi.toml")
+    }
+
+    #[test]
+    fn test_language_tables() {
+        let config = parse(
+            r#"
+            wit = "wit"
+            c-prefix = "demo"
+            batch = ["parser.parse"]
+            compose = ["../search/search.wasm"]
+
+            [rust]
+            output = "src/ffi"
+
+            [go]
+            output = "go"
+            context = true
+            timeout = { "parser.parse" = "500ms" }
+            custom-type = { when = ["time.Time", "liftTime", "lowerTime", "time"] }
+            "#,
+        );
+        assert_eq!(config.languages(LANGUAGES), ["rust", "go"]);
+        let args = config
+            .args(&command(), LANGUAGES, Some("go"))
+            .expect("failed to convert the configuration");
+        assert_eq!(
+            args,
+            [
+                "--batch=parser.parse",
+                "--c-prefix=demo",
+                "--compose=project/../search/search.wasm",
+                "--wit=project/wit",
+                "--lang=go",
+                "--go-context",
+                "--go-custom-type=when=time.Time,liftTime,lowerTime,time",
+                "--output=project/go",
+                "--go-timeout=parser.parse=500ms",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_unknown_options() {
+        let err = parse("[go]\nresolution = 4\n")
+            .args(&command(), LANGUAGES, Some("go"))
+            .expect_err("unknown options should be rejected");
+        assert_eq!(
+            err,
+            "unknown option `resolution` in [go] of project/witffi.toml"
+        );
+        let err = parse("go-context = 1\n")
+            .args(&command(), LANGUAGES, None)
+            .expect_err("flags should take booleans");
+        assert_eq!(
+            err,
+            "`go-context` in project/witffi.toml must be true or false"
+        );
+    }
+
+    #[test]
+    fn test_function_tables() {
+        let config = parse(
+            r#"
+            [functions."parser.parse"]
+            timeout = "500ms"
+            batch = true
+            skip = false
+
+            [go.functions."types.counter.get"]
+            rename = "Value"
+            "#,
+        );
+        let args = config
+            .args(&command(), LANGUAGES, Some("go"))
+            .expect("failed to convert the configuration");
+        assert_eq!(
+            args,
+            [
+                "--batch=parser.parse",
+                "--go-timeout=parser.parse=500ms",
+                "--lang=go",
+                "--go-function-name=types.counter.get=Value",
+            ]
+        );
+
+        let err = parse("[functions.\"parser.parse\"]\nretries = 3\n")
+            .args(&command(), LANGUAGES, None)
+            .expect_err("unknown settings should be rejected");
+        assert_eq!(
+            err,
+            "unknown setting `retries` of function `parser.parse` in project/witffi.toml"
+        );
+        let err = parse("[functions.\"parser.parse\"]\nbatch = \"yes\"\n")
+            .args(&command(), LANGUAGES, None)
+            .expect_err("switches should take booleans");
+        assert_eq!(
+            err,
+            "`batch` of function `parser.parse` in project/witffi.toml must be true or false"
+        );
+    }
+
+    #[test]
+    fn test_locate() {
+        let root = std::env::temp_dir().join(format!("witffi-config-{}", std::process::id()));
+        let package = root.join("go").join("parser");
+        std::fs::create_dir_all(&package).unwrap();
+        assert_eq!(locate_from(&package), None);
+
+        std::fs::write(root.join(FILE_NAME), "").unwrap();
+        assert_eq!(locate_from(&package), Some(root.join(FILE_NAME)));
+        assert_eq!(locate_from(&root), Some(root.join(FILE_NAME)));
+
+        // The nearest file wins, and directories are not files
+        std::fs::write(root.join("go").join(FILE_NAME), "").unwrap();
+        std::fs::create_dir(package.join(FILE_NAME)).unwrap();
+        assert_eq!(locate_from(&package), Some(root.join("go").join(FILE_NAME)));
+
+        let explicit = Path::new("elsewhere/witffi.toml");
+        assert_eq!(locate(Some(explicit)), Some(explicit.to_path_buf()));
+        std::fs::remove_dir_all(&root).unwrap();
+    }
+}
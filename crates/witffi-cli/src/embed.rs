@@ -0,0 +1,120 @@
+//! Generating Go bindings into an existing, hand-written Go package.
+//!
+//! The bindings take the name of the package already in the output
+//! directory, and their files can carry a prefix (e.g. `zz_generated_`) so
+//! they sort apart from the hand-written ones. Files that witffi did not
+//! generate are never overwritten: a file counts as generated when it
+//! carries Go's `// Code generated ... DO NOT EDIT.` comment.
+
+use std::path::Path;
+
+use snafu::prelude::*;
+
+use crate::Result;
+
+/// The name of the Go package whose hand-written files are in `dir`, if
+/// any. Test files, which may belong to an external `_test` package, and
+/// generated files are skipped.
+fn existing_package(dir: &Path) -> Result<Option<String>> {
+    let Ok(entries) = std::fs::read_dir(dir) else {
+        return Ok(None);
+    };
+    let mut paths: Vec<_> = entries
+        .filter_map(|entry| entry.ok())
+        .map(|entry| entry.path())
+        .filter(|path| {
+            path.file_name()
+                .and_then(|name| name.to_str())
+                .is_some_and(|name| name.ends_with(".go") && !name.ends_with("_test.go"))
+        })
+        .collect();
+    paths.sort();
+    for path in paths {
+        let code = std::fs::read_to_string(&path)
+            .with_whatever_context(|_| format!("reading {}", path.display()))?;
+        if is_generated(&code) {
+            continue;
+        }
+        if let Some(package) = package_clause(&code) {
+            return Ok(Some(package.to_string()));
+        }
+    }
+    Ok(None)
+}
+
+/// The name of the package to generate into `dir`: the one already there,
+/// which `requested` (from `--go-package`) must agree with, or else
+/// `requested`.
+pub fn package_name(dir: &Path, requested: Option<&str>) -> Result<Option<String>> {
+    let Some(existing) = existing_package(dir)? else {
+        return Ok(requested.map(str::to_string));
+    };
+    if let Some(requested) = requested.filter(|requested| *requested != existing) {
+        whatever!(
+            "{} holds Go package `{existing}`, not `{requested}`",
+            dir.display()
+        );
+    }
+    Ok(Some(existing))
+}
+
+/// `path` with `prefix` added to its file name, e.g. `parser/bindings.go`
+/// becoming `parser/zz_generated_bindings.go`.
+pub fn prefixed(path: &str, prefix: &str) -> String {
+    match path.rsplit_once('/') {
+        Some((dir, name)) => format!("{dir}/{prefix}{name}"),
+        None => format!("{prefix}{path}"),
+    }
+}
+
+/// Fail if `path` holds a file that witffi did not generate.
+pub fn check_overwrite(path: &Path) -> Result<()> {
+    let Ok(existing) = std::fs::read_to_string(path) else {
+        return Ok(());
+    };
+    if !is_generated(&existing) {
+        whatever!(
+            "refusing to overwrite {}, which is not generated code (pass --go-file-prefix to \
+             name the generated files apart)",
+            path.display()
+        );
+    }
+    Ok(())
+}
+
+/// Whether Go `code` is marked as generated, by a `// Code generated ...
+/// DO NOT EDIT.` line before its package clause.
+pub fn is_generated(code: &str) -> bool {
+    code.lines()
+        .take_while(|line| !line.starts_with("package "))
+        .any(|line| line.starts_with("// Code generated ") && line.ends_with(" DO NOT EDIT."))
+}
+
+/// The package named by the package clause of Go `code`.
+fn package_clause(code: &str) -> Option<&str> {
+    code.lines()
+        .find_map(|line| line.strip_prefix("package "))
+        .and_then(|rest| rest.split_whitespace().next())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_go_files() {
+        let hand_written = "// Package wallet signs payments.\n//go:build linux\n\npackage wallet // import \"example.com/wallet\"\n";
+        let generated = "// Code generated by witffi. DO NOT EDIT.\n\npackage wallet\n";
+        assert!(!is_generated(hand_written));
+        assert!(is_generated(generated));
+        assert_eq!(package_clause(hand_written), Some("wallet"));
+        assert_eq!(
+            prefixed("bindings.go", "zz_generated_"),
+            "zz_generated_bindings.go"
+        );
+        assert_eq!(
+            prefixed("parser/bindings.go", "zz_generated_"),
+            "parser/zz_generated_bindings.go"
+        );
+    }
+}
@@ -0,0 +1,195 @@
+//! Skipping the rewrite of Go files whose generated code has not changed.
+//!
+//! Generating the code of every file is quick, but formatting each with
+//! `gofmt` (a process per file) and rewriting it is not, and rewriting
+//! unchanged files churns Go's build cache, editors and watchers. With
+//! `--incremental`, a `.witffi-cache` file in the output directory records
+//! a hash of each file's generated code and of the file as it stands once
+//! the post-generate commands have run. A file whose code hashes as it did
+//! last time, and that has not been changed on disk since, is neither
+//! formatted nor rewritten, though its code is still generated, so that
+//! with `--go-subpackages` only the subpackages of the interfaces that
+//! changed are rewritten. Files no longer generated are dropped from the
+//! cache.
+
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+
+use snafu::prelude::*;
+
+use crate::Result;
+
+/// The cache file, in the output directory.
+pub const FILE_NAME: &str = ".witffi-cache";
+
+/// The hashes recorded for the files of an output directory.
+#[derive(Debug, Default)]
+pub struct Cache {
+    /// The output directory.
+    dir: PathBuf,
+    /// The hash of the generated code and of the file as written, by path
+    /// relative to the output directory, as of the last run.
+    entries: BTreeMap<String, (u64, u64)>,
+    /// The hash of the code of each file generated this run, by path
+    /// relative to the output directory.
+    generated: BTreeMap<String, u64>,
+}
+
+impl Cache {
+    /// The cache of the output directory `dir`, empty if it has none or
+    /// cannot be read.
+    pub fn load(dir: &Path) -> Self {
+        let entries = std::fs::read_to_string(dir.join(FILE_NAME))
+            .map(|text| parse(&text))
+            .unwrap_or_default();
+        Self {
+            dir: dir.to_path_buf(),
+            entries,
+            generated: BTreeMap::new(),
+        }
+    }
+
+    /// Whether the file `name` was written from code hashing to `code_hash`
+    /// and is unchanged since.
+    pub fn is_fresh(&self, name: &str, code_hash: u64) -> bool {
+        let Some((cached_code, cached_file)) = self.entries.get(name) else {
+            return false;
+        };
+        *cached_code == code_hash
+            && std::fs::read(self.dir.join(name)).is_ok_and(|file| hash(&file) == *cached_file)
+    }
+
+    /// Record that the file `name` was generated from code hashing to
+    /// `code_hash` this run, whether or not it was written.
+    pub fn record(&mut self, name: &str, code_hash: u64) {
+        self.generated.insert(name.to_string(), code_hash);
+    }
+
+    /// Save the cache beside the files it describes, with the files
+    /// generated this run as they now stand on disk, once anything run
+    /// after generating them has had its say. Files not generated this
+    /// run, or since removed, are left out.
+    pub fn save(&self) -> Result<()> {
+        let entries = self
+            .generated
+            .iter()
+            .filter_map(|(name, code_hash)| {
+                let file = std::fs::read(self.dir.join(name)).ok()?;
+                Some((name.clone(), (*code_hash, hash(&file))))
+            })
+            .collect();
+        let path = self.dir.join(FILE_NAME);
+        std::fs::write(&path, format(&entries))
+            .with_whatever_context(|_| format!("writing {}", path.display()))
+    }
+}
+
+/// The hash of a file's generated `code`, and of whether it is formatted
+/// with `gofmt` before it is written: either changes what is written.
+pub fn code_hash(code: &str, formatted: bool) -> u64 {
+    fnv1a([u8::from(formatted)].iter().chain(code.as_bytes()))
+}
+
+/// The 64-bit FNV-1a hash of `bytes`, which unlike `std`'s hashers is the
+/// same across Rust releases.
+pub fn hash(bytes: &[u8]) -> u64 {
+    fnv1a(bytes)
+}
+
+/// The 64-bit FNV-1a hash of the bytes of `bytes`.
+fn fnv1a<'a>(bytes: impl IntoIterator<Item = &'a u8>) -> u64 {
+    bytes.into_iter().fold(0xcbf2_9ce4_8422_2325, |hash, byte| {
+        (hash ^ u64::from(*byte)).wrapping_mul(0x0100_0000_01b3)
+    })
+}
+
+/// The entries of a cache file: a line per file, holding its two hashes in
+/// hex and its path. Malformed lines are skipped.
+fn parse(text: &str) -> BTreeMap<String, (u64, u64)> {
+    text.lines()
+        .filter_map(|line| {
+            let mut fields = line.splitn(3, ' ');
+            let code = u64::from_str_radix(fields.next()?, 16).ok()?;
+            let file = u64::from_str_radix(fields.next()?, 16).ok()?;
+            Some((fields.next()?.to_string(), (code, file)))
+        })
+        .collect()
+}
+
+/// The text of a cache file holding `entries`.
+fn format(entries: &BTreeMap<String, (u64, u64)>) -> String {
+    entries
+        .iter()
+        .map(|(name, (code, file))| format!("{code:016x} {file:016x} {name}\n"))
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_cache_file() {
+        assert_eq!(hash(b""), 0xcbf2_9ce4_8422_2325);
+        assert_eq!(hash(b"a"), 0xaf63_dc4c_8601_ec8c);
+
+        let entries = BTreeMap::from([
+            ("bindings.go".to_string(), (1, hash(b"package demo\n"))),
+            ("parser/bindings.go".to_string(), (u64::MAX, 0)),
+        ]);
+        let text = format(&entries);
+        assert_eq!(
+            text.lines().next(),
+            Some("0000000000000001 cc1d73f5e2c2baf8 bindings.go")
+        );
+        assert_eq!(parse(&format!("{text}garbage\n")), entries);
+    }
+
+    #[test]
+    fn test_code_hash() {
+        let code = "package demo\n";
+        assert_ne!(code_hash(code, true), code_hash(code, false));
+        assert_ne!(code_hash(code, false), hash(code.as_bytes()));
+        assert_ne!(code_hash("", true), code_hash("\u{1}", false));
+    }
+
+    #[test]
+    fn test_cache_save() {
+        let dir = std::env::temp_dir().join(format!("witffi-cache-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        std::fs::write(dir.join("kept.go"), "package demo\n").unwrap();
+        std::fs::write(dir.join("stale.go"), "package demo\n").unwrap();
+
+        let mut cache = Cache::load(&dir);
+        cache.record("kept.go", 1);
+        cache.record("stale.go", 2);
+        cache.save().unwrap();
+        let cache = Cache::load(&dir);
+        assert!(cache.is_fresh("kept.go", 1) && cache.is_fresh("stale.go", 2));
+        assert!(
+            !cache.is_fresh("kept.go", 2),
+            "changed code should be stale"
+        );
+
+        // A post-generate command rewriting the file after it was written
+        // is recorded, so it does not make the file stale next time
+        let mut cache = Cache::load(&dir);
+        cache.record("kept.go", 1);
+        std::fs::write(dir.join("kept.go"), "package demo // edited\n").unwrap();
+        cache.save().unwrap();
+
+        let cache = Cache::load(&dir);
+        assert!(cache.is_fresh("kept.go", 1));
+        assert!(
+            !cache.entries.contains_key("stale.go"),
+            "files no longer generated should be pruned"
+        );
+
+        std::fs::write(dir.join("kept.go"), "package demo // by hand\n").unwrap();
+        assert!(
+            !cache.is_fresh("kept.go", 1),
+            "edited files should be stale"
+        );
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+}
@@ -1,9 +1,22 @@
 //! witffi CLI — generate native FFI bindings from WIT definitions.
 
-use std::path::PathBuf;
+mod api;
+mod build;
+mod check;
+mod compat;
+mod config;
+mod embed;
+mod incremental;
+mod new;
+mod watch;
 
-use clap::{Parser, Subcommand, ValueEnum};
+use std::ffi::{OsStr, OsString};
+use std::path::{Path, PathBuf};
+
+use clap::{Args, CommandFactory, Parser, Subcommand, ValueEnum};
 use snafu::prelude::*;
+use wit_parser::WorldId;
+use witffi_core::names::{self, GO_INITIALISMS, GoNaming};
 
 type Result<T, E = snafu::Whatever> = std::result::Result<T, E>;
 
@@ -20,45 +33,639 @@ struct Cli {
 #[derive(Subcommand)]
 enum Commands {
     /// Generate bindings for a target language.
-    Generate {
-        /// Path to a WIT file or directory.
-        #[arg(long, short)]
-        wit: PathBuf,
-
-        /// Target language to generate bindings for.
-        #[arg(long, short)]
-        lang: Language,
-
-        /// Output directory for generated files.
-        #[arg(long, short)]
-        output: PathBuf,
-
-        /// Prefix for C function names (e.g. "zcash_eip681").
-        #[arg(long, default_value = "witffi")]
-        c_prefix: String,
-
-        /// Prefix for C type names (e.g. "Ffi").
-        #[arg(long, default_value = "Ffi")]
-        c_type_prefix: String,
-
-        /// Kotlin package name (e.g. "zcash.eip681").
-        ///
-        /// If not specified, derived from the WIT package name.
-        /// Used by both `--lang rust` (for JNI class paths in the macro)
-        /// and `--lang kotlin` (for the `package` declaration).
-        #[arg(long)]
-        kotlin_package: Option<String>,
-
-        /// Library name for `System.loadLibrary()` / JNI loading.
-        ///
-        /// Used by both `--lang rust` (embedded in JNI macro) and
-        /// `--lang kotlin` (in the `Bindings.kt` init block).
-        #[arg(long)]
-        lib_name: Option<String>,
-    },
-}
-
-#[derive(ValueEnum, Clone, Debug)]
+    ///
+    /// Options are also read from `witffi.toml` in the current directory or
+    /// the nearest parent that has one. Options given on the command line
+    /// take precedence, and lists add to those of the file.
+    ///
+    /// Run by `go generate` (as `//go:generate witffi generate`), the Go
+    /// bindings are regenerated into the directive's package.
+    // Options from the file come first, and later ones override them
+    #[command(args_override_self = true)]
+    Generate(GenerateArgs),
+
+    /// Generate bindings, then regenerate them whenever the WIT or another
+    /// watched file changes.
+    ///
+    /// Takes the options of `generate`, including those of `witffi.toml`,
+    /// which is read once at start. After each run, the declarations added
+    /// to or removed from the Go API are printed.
+    #[command(args_override_self = true)]
+    Watch(WatchArgs),
+
+    /// Check that the generated files in the output directory are up to
+    /// date.
+    ///
+    /// Takes the options of `generate`, including those of `witffi.toml`,
+    /// and generates into a temporary directory instead. Prints a diff of
+    /// every file that differs from the one in the output directory and
+    /// fails if there is any.
+    #[command(args_override_self = true)]
+    Check(GenerateArgs),
+
+    /// Build the Rust library with cargo and place it for the Go bindings.
+    ///
+    /// Takes the options of `generate`, including those of `witffi.toml`,
+    /// to find the Go bindings and the library's name. Builds for the
+    /// target `$GOOS` and `$GOARCH` name, if set, copies the static
+    /// archive and the C headers beside the bindings, and fails if the
+    /// archive lacks any C function the bindings call. Bindings with the
+    /// wazero or wasmtime backend get the library built for wasm32-wasip1
+    /// instead, copied into their package.
+    #[command(args_override_self = true)]
+    Build(BuildArgs),
+
+    /// Compare the WIT with an older version of it and report breaking
+    /// changes.
+    ///
+    /// Takes the options of `generate`, including those of `witffi.toml`,
+    /// to find the WIT and the world. Prints every change to the world's
+    /// functions and types, marked breaking if code built against the old
+    /// WIT would no longer build or would misread values (a removed
+    /// function, a changed signature, a reordered enum, a field added to a
+    /// record), and fails if any is.
+    #[command(args_override_self = true)]
+    Compat(CompatArgs),
+
+    /// Record the exported Go API of the bindings in a manifest, or check
+    /// regenerated bindings against it.
+    ///
+    /// Takes the options of `generate`, including those of `witffi.toml`,
+    /// and generates into a temporary directory. Writes every exported
+    /// declaration of the Go packages to `api.txt` in the output directory
+    /// or, with `--check`, prints the declarations removed from and added
+    /// to the recorded API and fails if any was removed or changed.
+    #[command(args_override_self = true)]
+    Api(ApiArgs),
+
+    /// Create a Rust library with Go bindings, ready to build.
+    ///
+    /// Lays out a Rust crate built as a staticlib, a WIT world with an
+    /// example function under `wit/`, a `witffi.toml`, a Go module under
+    /// `go/`, tests on both sides and a `Makefile` building and testing
+    /// everything, then generates the bindings.
+    New(NewArgs),
+}
+
+#[derive(Args)]
+struct BuildArgs {
+    #[command(flatten)]
+    generate: GenerateArgs,
+
+    /// Build with the release profile.
+    #[arg(long)]
+    release: bool,
+
+    /// Build with this cargo profile.
+    #[arg(long, conflicts_with = "release")]
+    profile: Option<String>,
+
+    /// Rust target triple to build for (e.g. `aarch64-apple-darwin`).
+    /// Defaults to the one `$GOOS` and `$GOARCH` name, or the host.
+    #[arg(long)]
+    target: Option<String>,
+
+    /// The cargo package of the library, in a workspace.
+    #[arg(long, short)]
+    package: Option<String>,
+
+    /// Path to the `Cargo.toml` of the library or its workspace.
+    #[arg(long)]
+    manifest_path: Option<PathBuf>,
+
+    /// cargo's target directory, if not the workspace's `target`.
+    #[arg(long)]
+    target_dir: Option<PathBuf>,
+
+    /// Copy the static archive here instead of beside the Go bindings.
+    #[arg(long)]
+    lib_dir: Option<PathBuf>,
+}
+
+#[derive(Args)]
+struct CompatArgs {
+    #[command(flatten)]
+    generate: GenerateArgs,
+
+    /// The older WIT to compare with, as a `.wit` file or a directory.
+    #[arg(long, value_name = "OLD_WIT")]
+    against: PathBuf,
+}
+
+#[derive(Args)]
+struct ApiArgs {
+    #[command(flatten)]
+    generate: GenerateArgs,
+
+    /// Check the API against the manifest instead of writing it.
+    #[arg(long)]
+    check: bool,
+
+    /// The manifest, if not `api.txt` in the output directory.
+    #[arg(long, value_name = "FILE")]
+    manifest: Option<PathBuf>,
+}
+
+#[derive(Args)]
+struct NewArgs {
+    /// The name of the project, in kebab-case: the crate, the WIT world and
+    /// (without dashes) the Go package.
+    name: String,
+
+    /// Directory to create the project in. Defaults to the project's name.
+    #[arg(long)]
+    path: Option<PathBuf>,
+
+    /// Module path of the Go module. Defaults to `example.com/<name>`.
+    #[arg(long)]
+    go_module: Option<String>,
+}
+
+#[derive(Args)]
+struct WatchArgs {
+    #[command(flatten)]
+    generate: GenerateArgs,
+
+    /// Also regenerate when a file under this path changes, such as the
+    /// sources of the Rust crate implementing the bindings. May be
+    /// repeated.
+    #[arg(long = "watch", value_name = "PATH")]
+    paths: Vec<PathBuf>,
+
+    /// Run `cargo build` in the current directory after each regeneration.
+    #[arg(long)]
+    cargo_build: bool,
+
+    /// How often to look for changes (e.g. `500ms` or `2s`).
+    #[arg(long, default_value = "500ms", value_parser = parse_duration)]
+    interval: std::time::Duration,
+}
+
+#[derive(Args, Clone)]
+struct GenerateArgs {
+    /// Read options from this file instead of `witffi.toml`. Without
+    /// `--lang`, bindings are generated for every language the file has a
+    /// table for.
+    #[arg(long)]
+    config: Option<PathBuf>,
+
+    /// Path to a WIT file or directory. Packages in a `deps/` directory
+    /// beside it are resolved too. A compiled component (`.wasm`) is read
+    /// for the WIT embedded in it.
+    #[arg(long, short)]
+    wit: Option<PathBuf>,
+
+    /// The world to generate bindings for, when the WIT package defines
+    /// more than one.
+    #[arg(long, conflicts_with = "all_worlds")]
+    world: Option<String>,
+
+    /// Generate every world of the WIT package, each into a subdirectory
+    /// of the output directory named after the world.
+    #[arg(long)]
+    all_worlds: bool,
+
+    /// Compose the world with the main world of this WIT package or
+    /// compiled component, which imports what it exports or the other way
+    /// round, and generate one API for the composition. May be repeated.
+    #[arg(long, value_name = "PATH", conflicts_with = "all_worlds")]
+    compose: Vec<PathBuf>,
+
+    /// Target language to generate bindings for.
+    #[arg(long, short)]
+    lang: Option<Language>,
+
+    /// Output directory for generated files.
+    #[arg(long, short)]
+    output: Option<PathBuf>,
+
+    /// Run this command after generating, with the output directory as
+    /// its last argument (e.g. `goimports -w` or an API-diff tool). The
+    /// command is split on whitespace and run without a shell. May be
+    /// repeated, and the commands run in order; any failing fails
+    /// generation.
+    #[arg(long, value_name = "COMMAND")]
+    post_generate: Vec<String>,
+
+    /// Skip rewriting the Go files whose generated code has not changed
+    /// since the last run, as recorded in `.witffi-cache` in the output
+    /// directory. Every file's code is still generated; only the formatting
+    /// and writing of unchanged ones is skipped.
+    #[arg(long)]
+    incremental: bool,
+
+    /// The output directory the bindings belong in when generated
+    /// elsewhere, as `witffi check` generates them into a scratch
+    /// directory, which paths in them relative to their directory are
+    /// relative to. Not a flag.
+    #[arg(skip)]
+    final_output: Option<PathBuf>,
+
+    /// Prefix for C function names (e.g. "zcash_eip681").
+    ///
+    /// If not specified, derived from the WIT package id (e.g.
+    /// `zcash:eip681@0.1.0` gives `zcash_eip681_v0_1_0`, and each world's
+    /// name is added with `--all-worlds`), so that bindings of different
+    /// packages link into one program. Must be given alike for every
+    /// language generated from the same library.
+    #[arg(long)]
+    c_prefix: Option<String>,
+
+    /// Prefix for C type names (e.g. "Ffi").
+    #[arg(long, default_value = "Ffi")]
+    c_type_prefix: String,
+
+    /// Kotlin package name (e.g. "zcash.eip681").
+    ///
+    /// If not specified, derived from the WIT package name.
+    /// Used by both `--lang rust` (for JNI class paths in the macro)
+    /// and `--lang kotlin` (for the `package` declaration).
+    #[arg(long)]
+    kotlin_package: Option<String>,
+
+    /// Library name for `System.loadLibrary()` / JNI loading.
+    ///
+    /// Used by both `--lang rust` (embedded in JNI macro) and
+    /// `--lang kotlin` (in the `Bindings.kt` init block).
+    #[arg(long)]
+    lib_name: Option<String>,
+
+    /// Add a batched form of an exported function (e.g. `parser.parse`
+    /// adds `parser.parse-batch`), taking and returning lists so many
+    /// calls cross the FFI boundary once. Must be given alike for every
+    /// language generated from the same library. May be repeated.
+    #[arg(long)]
+    batch: Vec<String>,
+
+    /// Leave an exported function (e.g. `parser.parse`) out of the
+    /// bindings. Must be given alike for every language generated from the
+    /// same library. May be repeated.
+    #[arg(long)]
+    skip: Vec<String>,
+
+    /// Generate only this exported interface (e.g. `parser`) of the world,
+    /// leaving the others out along with their functions. Must be given
+    /// alike for every language generated from the same library. May be
+    /// repeated.
+    #[arg(long, value_name = "INTERFACE")]
+    only: Vec<String>,
+
+    /// Leave this exported interface (e.g. `admin`) of the world out of the
+    /// bindings. Must be given alike for every language generated from the
+    /// same library. May be repeated.
+    #[arg(long, value_name = "INTERFACE")]
+    exclude: Vec<String>,
+
+    /// Also generate a variant of each function returning a string or
+    /// `list<u8>` that writes the result into a caller-supplied buffer
+    /// (`_into` in C, `XxxInto` in Go). Must be given alike for
+    /// `--lang rust` and `--lang go`.
+    #[arg(long)]
+    into_variants: bool,
+
+    /// Transfer `list<record>` results of a record whose fields are all
+    /// numbers or bools column by column, one array per field, instead
+    /// of element by element (e.g. `point`). Must be given alike for
+    /// `--lang rust` and `--lang go`. May be repeated.
+    #[arg(long)]
+    columnar: Vec<String>,
+
+    /// Route the boxes handed across the FFI through
+    /// `witffi_types::accounting`, so that a library built with the
+    /// witffi-types `alloc-accounting` feature counts every allocation
+    /// and free per type. For `--lang go`, also writes the files defining
+    /// `AllocStats` and `CheckNoLeaks` under the `witffidebug` build tag.
+    /// Must be given alike for `--lang rust` and `--lang go`.
+    #[arg(long)]
+    alloc_accounting: bool,
+
+    /// Install a debug allocator in the Rust library that stamps
+    /// canaries around every block and aborts when one is found damaged
+    /// on free, catching writes past the ends of buffers handed to the
+    /// caller. Must be given alike for `--lang rust` and `--lang go`.
+    #[arg(long)]
+    alloc_canaries: bool,
+
+    /// Add `init_library` and `shutdown_library` to the world trait, and
+    /// for `--lang go` the `Init(opts...)` and `Shutdown()` calling them,
+    /// to control when the library's global state is set up and torn
+    /// down. Must be given alike for `--lang rust` and `--lang go`.
+    #[arg(long)]
+    lifecycle: bool,
+
+    /// Export `_set_log_sink` from the Rust library, forwarding its `log`
+    /// records to the caller, and for `--lang go` generate
+    /// `SetLogger(*slog.Logger)` registering one. The library must enable
+    /// `witffi-types`' `log` feature. Must be given alike for
+    /// `--lang rust` and `--lang go`.
+    #[arg(long)]
+    log_bridge: bool,
+
+    /// Name of the generated Go package. If not specified, derived from
+    /// the world name (`--lang go` only).
+    #[arg(long)]
+    go_package: Option<String>,
+
+    /// Prefix the names of the generated Go files with this (e.g.
+    /// `zz_generated_`), to generate into a package with hand-written files
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_file_prefix: Option<String>,
+
+    /// Write the Go files as generated rather than formatted with `gofmt`,
+    /// for machines without a Go toolchain (`--lang go` only).
+    #[arg(long)]
+    go_no_fmt: bool,
+
+    /// How the Go bindings call the Rust library: through cgo; through
+    /// purego, loading it at run time in builds without cgo; or through
+    /// wazero or wasmtime, running it as WebAssembly embedded in the
+    /// package; or through a sidecar process running it, or a gRPC service
+    /// serving it (with the service's `.proto` written beside the bindings).
+    /// These backends support freestanding functions passing numbers,
+    /// bools, strings and `list<u8>` (`--lang go` only).
+    #[arg(long, value_enum, default_value = "cgo")]
+    go_backend: GoBackend,
+
+    /// How Go resource handles are released when they are garbage
+    /// collected without an explicit `Close` (`--lang go` only).
+    #[arg(long, value_enum, default_value = "manual")]
+    go_resource_cleanup: GoResourceCleanup,
+
+    /// Override `--go-resource-cleanup` for a single resource, given as
+    /// `<resource>=<strategy>` (e.g. `counter=manual`). May be repeated.
+    #[arg(long, value_parser = parse_resource_cleanup_override)]
+    go_resource_cleanup_override: Vec<(String, GoResourceCleanup)>,
+
+    /// Map `option<T>` to a generic `Option[T]` instead of `*T`
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_generic_options: bool,
+
+    /// Return tuple results as multiple Go return values instead of a
+    /// single `TupleOfN` (`--lang go` only).
+    #[arg(long)]
+    go_multi_value_results: bool,
+
+    /// Flatten a WIT record into named Go return values wherever it is a
+    /// function result, e.g. `(value uint64, remainder string, err error)`.
+    /// May be repeated (`--lang go` only).
+    #[arg(long, value_name = "RECORD")]
+    go_named_results: Vec<String>,
+
+    /// Give generated `Stream[T]` values an `All` method returning an
+    /// `iter.Seq[T]`, which needs Go 1.23+ (`--lang go` only).
+    #[arg(long)]
+    go_stream_iterators: bool,
+
+    /// Give every exported function a leading `ctx context.Context`
+    /// parameter whose cancellation Rust can check with
+    /// `witffi_types::is_cancelled` (`--lang go` only).
+    #[arg(long)]
+    go_context: bool,
+
+    /// Give one exported function (e.g. `net.fetch`) the parameter of
+    /// `--go-context`. May be repeated (`--lang go` only).
+    #[arg(long)]
+    go_context_function: Vec<String>,
+
+    /// Give an exported function a deadline, given as
+    /// `<function>=<duration>` (e.g. `parser.parse=500ms` or
+    /// `types.counter.get=2s`). Once it passes, Rust sees the call as
+    /// cancelled, and a call that can fail returns `ErrTimeout`. May be
+    /// repeated (`--lang go` only).
+    #[arg(long, value_parser = parse_timeout)]
+    go_timeout: Vec<(String, std::time::Duration)>,
+
+    /// Run every call into the library on one dedicated OS thread, for
+    /// Rust libraries with thread-affine state (`--lang go` only).
+    #[arg(long)]
+    go_dispatch_thread: bool,
+
+    /// Run calls to an exported function known to block for long
+    /// periods on a bounded worker pool, sized with `InitBlockingPool`.
+    /// Named like `--go-timeout` functions (e.g. `net.fetch`). May be
+    /// repeated (`--lang go` only).
+    #[arg(long)]
+    go_blocking: Vec<String>,
+
+    /// Serialize calls into an exported interface (e.g. `parser`) or
+    /// resource (e.g. `types.counter`) whose Rust implementation is not
+    /// thread-safe, with a lock that imports calling back into it pass
+    /// through. May be repeated (`--lang go` only).
+    #[arg(long)]
+    go_single_threaded: Vec<String>,
+
+    /// Allocate fresh scratch memory on every call instead of reusing it
+    /// through a `sync.Pool` (`--lang go` only).
+    #[arg(long)]
+    go_no_buffer_pool: bool,
+
+    /// Pass byte lists nested in list and optional arguments to Rust
+    /// pinned in place rather than copied; needs Go 1.21+ (`--lang go`
+    /// only).
+    #[arg(long)]
+    go_pin_bytes: bool,
+
+    /// Stage lowered arguments in chunks of C memory of this many bytes,
+    /// freed together when a call returns, and export `ReadArenaStats`
+    /// (`--lang go` only).
+    #[arg(long, value_parser = clap::value_parser!(u32).range(1..))]
+    go_arena_chunk_size: Option<u32>,
+
+    /// Return the string result of an exported function (e.g.
+    /// `render.page`) as a `BorrowedString` pointing at Rust-owned
+    /// memory instead of copying it. May be repeated (`--lang go` only).
+    #[arg(long)]
+    go_borrowed_string: Vec<String>,
+
+    /// Return the list result of an exported function (e.g.
+    /// `ledger.entries`) as a `*ListView[T]` reading elements from Rust
+    /// memory on demand. May be repeated (`--lang go` only).
+    #[arg(long)]
+    go_list_view: Vec<String>,
+
+    /// Leave the result of an exported function in Rust memory: as with
+    /// `--go-list-view` for a list, and `--go-borrowed-string` for a
+    /// string. May be repeated (`--lang go` only).
+    #[arg(long)]
+    go_zero_copy: Vec<String>,
+
+    /// Also generate a `Seq` variant of the wrapper of an exported
+    /// function returning a list (e.g. `ledger.entries`), returning an
+    /// `iter.Seq[T]` that lifts elements as the range reaches them.
+    /// Needs Go 1.23+. May be repeated (`--lang go` only).
+    #[arg(long)]
+    go_list_seq: Vec<String>,
+
+    /// Mark the C functions the bindings call `#cgo noescape` and
+    /// `#cgo nocallback` where that is known to be safe. Needs Go 1.24+
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_cgo_annotations: bool,
+
+    /// Leave an exported function (e.g. `parser.parse`) unannotated by
+    /// `--go-cgo-annotations`. May be repeated (`--lang go` only).
+    #[arg(long)]
+    go_cgo_unannotated: Vec<String>,
+
+    /// Intern lifted strings of at most this many bytes, so repeated
+    /// results share one copy instead of allocating each time
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_intern_strings: Option<usize>,
+
+    /// Also write `cgocheck_test.go`, which lowers a sample of every list
+    /// shape passed to Rust, for running under `GOEXPERIMENT=cgocheck2`
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_cgocheck_tests: bool,
+
+    /// Return a Rust panic as an `ErrForeignPanic` error, carrying its
+    /// backtrace in debug builds (`--lang go` only).
+    #[arg(long)]
+    go_foreign_panics: bool,
+
+    /// Rebuild errors serialized with `witffi_types::error_chain` as
+    /// wrapped `*ErrorChain` errors, one per source (`--lang go` only).
+    #[arg(long)]
+    go_error_chains: bool,
+
+    /// Generate `SetCallHooks`, registering `BeforeCall` and `AfterCall`
+    /// hooks run around every call into Rust (`--lang go` only).
+    #[arg(long)]
+    go_call_hooks: bool,
+
+    /// Start an OpenTelemetry span for every call into Rust, with the
+    /// global tracer provider (`--lang go` only). The generated package
+    /// then depends on `go.opentelemetry.io/otel`.
+    #[arg(long)]
+    go_otel_spans: bool,
+
+    /// Run every call into Rust under a `runtime/pprof` label naming the
+    /// WIT function, so CPU profiles attribute cgo time to each binding
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_pprof_labels: bool,
+
+    /// Also write `metrics.go`, registering Prometheus call, error and
+    /// latency metrics of every exported function (`--lang go` only).
+    /// Implies `--go-call-hooks`.
+    #[arg(long)]
+    go_metrics: bool,
+
+    /// Also write `http_handlers.go`, whose `NewHTTPHandler` serves every
+    /// exported function it can as a JSON endpoint, for debugging the
+    /// bindings with curl (`--lang go` only).
+    #[arg(long)]
+    go_http_handlers: bool,
+
+    /// Also write `call_dump_debug.go` and `call_dump.go`: built with the
+    /// `witffidump` tag, every call's arguments and results are logged
+    /// to `slog` at Debug level (`--lang go` only).
+    #[arg(long)]
+    go_call_dump: bool,
+
+    /// Truncate values in call dumps past this many bytes (`--lang go`
+    /// only).
+    #[arg(long, default_value_t = 256)]
+    go_call_dump_max_len: usize,
+
+    /// Leave a value out of call dumps, given as `<function>.<param>` or
+    /// `<function>.return` (e.g. `wallet.sign.key`). May be repeated
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_call_dump_redact: Vec<String>,
+
+    /// Track every open resource handle, listed by `LiveHandles` and
+    /// checked by `CheckNoLiveHandles`. Also writes `handles_debug.go`
+    /// and `handles.go`: built with the `witffidebug` tag, handles record
+    /// the stack they were created on (`--lang go` only).
+    #[arg(long)]
+    go_handle_table: bool,
+
+    /// When the Go bindings set up the Rust library: at package init, or
+    /// on the first call for deployments that must not do work at import
+    /// time (`--lang go` only).
+    #[arg(long, value_enum, default_value = "eager")]
+    go_init: GoInitMode,
+
+    /// Map a WIT type to a non-default Go type, given as
+    /// `<type>=<mapping>` (e.g. `u128=big-int` or `headers=map`). `time` and
+    /// `duration` may also target one record field as `<record>.<field>`.
+    /// May be repeated (`--lang go` only).
+    #[arg(long, value_parser = parse_type_mapping)]
+    go_type_mapping: Vec<(String, GoTypeMapping)>,
+
+    /// Map a WIT type alias to a custom Go type, given as
+    /// `<type>=<go-type>,<lift-func>,<lower-func>[,<import>]`. The
+    /// conversion functions must be defined in the Go package. May be
+    /// repeated (`--lang go` only).
+    #[arg(long, value_parser = parse_custom_type)]
+    go_custom_type: Vec<(String, witffi_go::generate::GoCustomMapping)>,
+
+    /// Write Go's common initialisms (`ID`, `URI`, `HTTP` and so on) in all
+    /// caps in Go names, e.g. `ChainID` for `chain-id` (`--lang go` only).
+    #[arg(long)]
+    go_initialisms: bool,
+
+    /// Also write this word in all caps in Go names, e.g. `eip` for
+    /// `EIP681`. May be repeated (`--lang go` only).
+    #[arg(long)]
+    go_initialism: Vec<String>,
+
+    /// Name a WIT identifier explicitly in Go, given as `<wit-name>=<GoName>`
+    /// (e.g. `chain-id=ChainID`). May be repeated (`--lang go` only).
+    #[arg(long, value_parser = parse_go_name)]
+    go_name: Vec<(String, String)>,
+
+    /// Name an exported function explicitly in Go, given as
+    /// `<function>=<GoName>` (e.g. `parser.parse=ParseURI`). May be
+    /// repeated (`--lang go` only).
+    #[arg(long, value_parser = parse_go_name)]
+    go_function_name: Vec<(String, String)>,
+
+    /// Appended to Go names that would be reserved words, such as a `type`
+    /// parameter (`--lang go` only).
+    #[arg(long, default_value = "_", value_parser = parse_keyword_suffix)]
+    go_keyword_suffix: String,
+
+    /// Also generate a subpackage per exported interface, re-exporting its
+    /// types and functions without the interface prefix (e.g.
+    /// `parser.Parse`) (`--lang go` only).
+    #[arg(long)]
+    go_subpackages: bool,
+
+    /// The Go import path of the output directory, which subpackages
+    /// import. Defaults to the path given by the enclosing `go.mod`
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_import_path: Option<String>,
+
+    /// The number of threads generating the Go bindings, or 0 for one per
+    /// available core (`--lang go` only).
+    #[arg(long, default_value_t = 0, value_name = "N")]
+    go_threads: usize,
+
+    /// Log a warning through `slog` the first time each function the WIT
+    /// marks `@deprecated` is called (`--lang go` only).
+    #[arg(long)]
+    go_deprecation_warnings: bool,
+
+    /// Note where each WIT item is defined in the doc comments of the Go
+    /// declarations generated for it, as `// source: parser.wit:42`
+    /// (`--lang go` only).
+    #[arg(long)]
+    go_source_comments: bool,
+
+    /// Precede each Go wrapper with a `//line` directive, so that compiler
+    /// errors and stack traces in it point at the function's WIT definition.
+    /// Implies `--go-source-comments` (`--lang go` only).
+    #[arg(long)]
+    go_line_directives: bool,
+}
+
+#[derive(ValueEnum, Clone, Copy, Debug)]
 enum Language {
     /// Generate Rust scaffolding (idiomatic types, trait, dual macros) + C header.
     Rust,
@@ -70,145 +677,1030 @@ enum Language {
     Go,
 }
 
+#[derive(ValueEnum, Clone, Copy, Debug)]
+enum GoBackend {
+    /// Call the library through cgo, linking it at build time.
+    Cgo,
+    /// Load the library at run time through purego, without cgo.
+    Purego,
+    /// Run the library built for wasm32-wasip1 under wazero, without cgo.
+    Wazero,
+    /// Run the library built for wasm32-wasip1 under wasmtime-go, compiled
+    /// to native code.
+    Wasmtime,
+    /// Run the library in a sidecar process, speaking to it over pipes.
+    Sidecar,
+    /// Call the library's gRPC service, served with witffi_register_grpc!.
+    Grpc,
+}
+
+impl From<GoBackend> for witffi_go::generate::GoBackend {
+    fn from(value: GoBackend) -> Self {
+        match value {
+            GoBackend::Cgo => Self::Cgo,
+            GoBackend::Purego => Self::Purego,
+            GoBackend::Wazero => Self::Wazero,
+            GoBackend::Wasmtime => Self::Wasmtime,
+            GoBackend::Sidecar => Self::Sidecar,
+            GoBackend::Grpc => Self::Grpc,
+        }
+    }
+}
+
+#[derive(ValueEnum, Clone, Copy, Debug)]
+enum GoResourceCleanup {
+    /// Only release handles on an explicit `Close`.
+    Manual,
+    /// Register `runtime.AddCleanup` (Go 1.24+).
+    AddCleanup,
+    /// Register `runtime.SetFinalizer` (older Go toolchains).
+    Finalizer,
+}
+
+impl From<GoResourceCleanup> for witffi_go::generate::ResourceCleanup {
+    fn from(value: GoResourceCleanup) -> Self {
+        match value {
+            GoResourceCleanup::Manual => Self::Manual,
+            GoResourceCleanup::AddCleanup => Self::AddCleanup,
+            GoResourceCleanup::Finalizer => Self::Finalizer,
+        }
+    }
+}
+
+#[derive(ValueEnum, Clone, Copy, Debug)]
+enum GoInitMode {
+    /// Set up the library when the package is imported.
+    Eager,
+    /// Set up the library on the first call, guarded by `sync.Once`.
+    Lazy,
+}
+
+impl From<GoInitMode> for witffi_go::generate::GoInitMode {
+    fn from(value: GoInitMode) -> Self {
+        match value {
+            GoInitMode::Eager => Self::Eager,
+            GoInitMode::Lazy => Self::Lazy,
+        }
+    }
+}
+
+#[derive(ValueEnum, Clone, Copy, Debug)]
+enum GoTypeMapping {
+    /// Surface 128-bit integers as `*big.Int`.
+    BigInt,
+    /// Surface a `list<tuple<K, V>>` alias as `map[K]V`.
+    Map,
+    /// Surface nanoseconds since the Unix epoch, or a `datetime` record, as
+    /// `time.Time`.
+    Time,
+    /// Surface nanoseconds as `time.Duration`.
+    Duration,
+}
+
+impl From<GoTypeMapping> for witffi_go::generate::GoTypeMapping {
+    fn from(value: GoTypeMapping) -> Self {
+        match value {
+            GoTypeMapping::BigInt => Self::BigInt,
+            GoTypeMapping::Map => Self::Map,
+            GoTypeMapping::Time => Self::Time,
+            GoTypeMapping::Duration => Self::Duration,
+        }
+    }
+}
+
+/// Parse a `<resource>=<strategy>` cleanup override.
+fn parse_resource_cleanup_override(s: &str) -> Result<(String, GoResourceCleanup), String> {
+    let (resource, strategy) = s
+        .split_once('=')
+        .ok_or_else(|| format!("expected <resource>=<strategy>, got `{s}`"))?;
+    let strategy = GoResourceCleanup::from_str(strategy, true)?;
+    Ok((resource.to_string(), strategy))
+}
+
+/// Parse a `<function>=<duration>` timeout, where the duration is a whole
+/// number of `ns`, `us`, `ms`, `s`, `m` or `h`.
+fn parse_timeout(s: &str) -> Result<(String, std::time::Duration), String> {
+    let (function, duration) = s
+        .split_once('=')
+        .ok_or_else(|| format!("expected <function>=<duration>, got `{s}`"))?;
+    Ok((function.to_string(), parse_duration(duration)?))
+}
+
+/// Parse a duration: a whole number of `ns`, `us`, `ms`, `s`, `m` or `h`.
+fn parse_duration(duration: &str) -> Result<std::time::Duration, String> {
+    let split = duration
+        .find(|c: char| !c.is_ascii_digit())
+        .ok_or_else(|| format!("duration `{duration}` has no unit (e.g. `500ms`)"))?;
+    let (amount, unit) = duration.split_at(split);
+    let amount: u64 = amount
+        .parse()
+        .map_err(|_| format!("invalid duration `{duration}`"))?;
+    let duration = match unit {
+        "ns" => std::time::Duration::from_nanos(amount),
+        "us" => std::time::Duration::from_micros(amount),
+        "ms" => std::time::Duration::from_millis(amount),
+        "s" => std::time::Duration::from_secs(amount),
+        "m" => std::time::Duration::from_secs(amount * 60),
+        "h" => std::time::Duration::from_secs(amount * 60 * 60),
+        _ => return Err(format!("unknown duration unit `{unit}` in `{duration}`")),
+    };
+    Ok(duration)
+}
+
+/// Parse a `<type>=<mapping>` Go type mapping.
+fn parse_type_mapping(s: &str) -> Result<(String, GoTypeMapping), String> {
+    let (wit_type, mapping) = s
+        .split_once('=')
+        .ok_or_else(|| format!("expected <type>=<mapping>, got `{s}`"))?;
+    let mapping = GoTypeMapping::from_str(mapping, true)?;
+    Ok((wit_type.to_string(), mapping))
+}
+
+/// Parse a `<wit-name>=<GoName>` Go name override, which must be an
+/// exported Go identifier.
+fn parse_go_name(s: &str) -> Result<(String, String), String> {
+    let (wit_name, go_name) = s
+        .split_once('=')
+        .ok_or_else(|| format!("expected <wit-name>=<GoName>, got `{s}`"))?;
+    let is_ident = go_name.chars().all(|c| c.is_alphanumeric() || c == '_');
+    if !is_ident || !go_name.starts_with(|c: char| c.is_uppercase()) {
+        return Err(format!(
+            "`{go_name}` is not an exported Go identifier (e.g. `ChainID`)"
+        ));
+    }
+    Ok((wit_name.to_string(), go_name.to_string()))
+}
+
+/// Parse a suffix for Go names that would be reserved words, which must
+/// keep them identifiers.
+fn parse_keyword_suffix(s: &str) -> Result<String, String> {
+    if s.is_empty() || !s.chars().all(|c| c.is_alphanumeric() || c == '_') {
+        return Err(format!("`{s}` cannot end a Go identifier"));
+    }
+    Ok(s.to_string())
+}
+
+/// Parse a `<type>=<go-type>,<lift-func>,<lower-func>[,<import>]` custom
+/// Go type mapping.
+fn parse_custom_type(s: &str) -> Result<(String, witffi_go::generate::GoCustomMapping), String> {
+    let expected = || format!("expected <type>=<go-type>,<lift>,<lower>[,<import>], got `{s}`");
+    let (wit_type, spec) = s.split_once('=').ok_or_else(expected)?;
+    let parts: Vec<&str> = spec.split(',').collect();
+    let (go_type, lift, lower, import) = match parts.as_slice() {
+        [go_type, lift, lower] => (go_type, lift, lower, None),
+        [go_type, lift, lower, import] => (go_type, lift, lower, Some(import.to_string())),
+        _ => return Err(expected()),
+    };
+    Ok((
+        wit_type.to_string(),
+        witffi_go::generate::GoCustomMapping {
+            go_type: go_type.to_string(),
+            lift: lift.to_string(),
+            lower: lower.to_string(),
+            import,
+        },
+    ))
+}
+
 #[snafu::report]
 fn main() -> Result<()> {
-    let cli = Cli::parse();
-
-    match cli.command {
-        Commands::Generate {
-            wit,
-            lang,
-            output,
-            c_prefix,
-            c_type_prefix,
-            kotlin_package,
-            lib_name,
-        } => {
-            let (resolve, world_id) = witffi_core::load_wit(&wit)
-                .with_whatever_context(|_| format!("loading WIT from {}", wit.display()))?;
-
-            std::fs::create_dir_all(&output).with_whatever_context(|_| {
-                format!("creating output directory {}", output.display())
-            })?;
+    let argv: Vec<OsString> = std::env::args_os().collect();
+    let cli = Cli::parse_from(&argv);
+    let args = match &cli.command {
+        Commands::Generate(args) | Commands::Check(args) => args,
+        Commands::Watch(args) => &args.generate,
+        Commands::Build(args) => &args.generate,
+        Commands::Compat(args) => &args.generate,
+        Commands::Api(args) => &args.generate,
+        Commands::New(args) => return new::run(args),
+    };
 
-            match lang {
-                Language::Rust => {
-                    let rust_config = witffi_rust::generate::RustConfig {
-                        c_prefix: c_prefix.clone(),
-                        c_type_prefix: c_type_prefix.clone(),
-                        kotlin_package,
-                        library_name: lib_name,
-                    };
-                    let rust_generator =
-                        witffi_rust::RustGenerator::new(&resolve, world_id, rust_config);
-
-                    let rust_code = rust_generator
-                        .generate()
-                        .whatever_context("generating Rust code")?;
-                    let rust_path = output.join("ffi.rs");
-                    std::fs::write(&rust_path, &rust_code)
-                        .with_whatever_context(|_| format!("writing {}", rust_path.display()))?;
-                    eprintln!("Wrote {}", rust_path.display());
-
-                    let c_header = rust_generator
-                        .generate_c_header()
-                        .whatever_context("generating C header")?;
-                    let header_path = output.join("ffi.h");
-                    std::fs::write(&header_path, &c_header)
-                        .with_whatever_context(|_| format!("writing {}", header_path.display()))?;
-                    eprintln!("Wrote {}", header_path.display());
-
-                    let types_path = output.join("witffi_types.h");
-                    std::fs::write(&types_path, witffi_rust::WITFFI_TYPES_HEADER)
-                        .with_whatever_context(|_| format!("writing {}", types_path.display()))?;
-                    eprintln!("Wrote {}", types_path.display());
-                }
+    let mut watches = Vec::new();
+    let mut checks = Vec::new();
+    let mut builds = Vec::new();
+    let mut compats = Vec::new();
+    let mut apis = Vec::new();
+    for command in configured_commands(args, &argv)? {
+        match command {
+            Commands::Generate(args) => generate(args, false)?,
+            Commands::Watch(args) => watches.push(args),
+            Commands::Check(args) => checks.push(args),
+            Commands::Build(args) => builds.push(args),
+            Commands::Compat(args) => compats.push(args),
+            Commands::Api(args) => apis.push(args),
+            Commands::New(_) => unreachable!("new is not configured"),
+        }
+    }
+    if !watches.is_empty() {
+        watch::run(watches)?;
+    }
+    if !checks.is_empty() {
+        check::run(checks)?;
+    }
+    if !builds.is_empty() {
+        build::run(builds)?;
+    }
+    if !compats.is_empty() {
+        compat::run(compats)?;
+    }
+    if !apis.is_empty() {
+        api::run(apis)?;
+    }
 
-                Language::Swift => {
-                    // Swift needs C headers as well as Swift bindings.
-                    let rust_config = witffi_rust::generate::RustConfig {
-                        c_prefix: c_prefix.clone(),
-                        c_type_prefix: c_type_prefix.clone(),
-                        kotlin_package: None,
-                        library_name: None,
-                    };
-                    let rust_generator =
-                        witffi_rust::RustGenerator::new(&resolve, world_id, rust_config);
-
-                    let c_header = rust_generator
-                        .generate_c_header()
-                        .whatever_context("generating C header")?;
-                    let header_path = output.join("ffi.h");
-                    std::fs::write(&header_path, &c_header)
-                        .with_whatever_context(|_| format!("writing {}", header_path.display()))?;
-                    eprintln!("Wrote {}", header_path.display());
-
-                    let types_path = output.join("witffi_types.h");
-                    std::fs::write(&types_path, witffi_rust::WITFFI_TYPES_HEADER)
-                        .with_whatever_context(|_| format!("writing {}", types_path.display()))?;
-                    eprintln!("Wrote {}", types_path.display());
-
-                    let swift_config = witffi_swift::generate::SwiftConfig {
-                        c_prefix,
-                        c_type_prefix,
-                    };
-                    let swift_generator =
-                        witffi_swift::SwiftGenerator::new(&resolve, world_id, swift_config);
-
-                    let swift_code = swift_generator
-                        .generate()
-                        .whatever_context("generating Swift code")?;
-                    let swift_path = output.join("Bindings.swift");
-                    std::fs::write(&swift_path, &swift_code)
-                        .with_whatever_context(|_| format!("writing {}", swift_path.display()))?;
-                    eprintln!("Wrote {}", swift_path.display());
-
-                    let module_map = swift_generator
-                        .generate_module_map()
-                        .whatever_context("generating module map")?;
-                    let map_path = output.join("module.modulemap");
-                    std::fs::write(&map_path, &module_map)
-                        .with_whatever_context(|_| format!("writing {}", map_path.display()))?;
-                    eprintln!("Wrote {}", map_path.display());
-                }
+    Ok(())
+}
 
-                Language::Kotlin => {
-                    let kotlin_config = witffi_kotlin::generate::KotlinConfig {
-                        kotlin_package,
-                        lib_name: lib_name.unwrap_or_else(|| "witffi".to_string()),
-                    };
-                    let kotlin_generator =
-                        witffi_kotlin::KotlinGenerator::new(&resolve, world_id, kotlin_config);
-
-                    let kotlin_code = kotlin_generator
-                        .generate()
-                        .whatever_context("generating Kotlin code")?;
-                    let kotlin_path = output.join("Bindings.kt");
-                    std::fs::write(&kotlin_path, &kotlin_code)
-                        .with_whatever_context(|_| format!("writing {}", kotlin_path.display()))?;
-                    eprintln!("Wrote {}", kotlin_path.display());
+/// The command of the command line `argv`, once per language to generate,
+/// with the options of `witffi.toml` and those implied by `go generate`
+/// added before its own. `args` are the options `argv` parsed to.
+fn configured_commands(args: &GenerateArgs, argv: &[OsString]) -> Result<Vec<Commands>> {
+    let go_generate = go_generate_args();
+    let config = match config::locate(args.config.as_deref()) {
+        Some(config_path) => {
+            let text = std::fs::read_to_string(&config_path)
+                .with_whatever_context(|_| format!("reading {}", config_path.display()))?;
+            let table: toml::Table = text
+                .parse()
+                .with_whatever_context(|_| format!("parsing {}", config_path.display()))?;
+            config::Config::new(table, &config_path)
+        }
+        None => config::Config::default(),
+    };
+
+    let lang_name = |lang: &Language| {
+        lang.to_possible_value()
+            .map(|value| value.get_name().to_string())
+    };
+    let language_names: Vec<String> = Language::value_variants()
+        .iter()
+        .filter_map(lang_name)
+        .collect();
+    let languages: Vec<&str> = language_names.iter().map(String::as_str).collect();
+    // Without --lang, every language with a table is generated, or only Go
+    // under `go generate`
+    let langs: Vec<Option<String>> = match &args.lang {
+        Some(lang) => vec![lang_name(lang)],
+        None if !go_generate.is_empty() => vec![lang_name(&Language::Go)],
+        None if config.languages(&languages).is_empty() => vec![None],
+        None => config
+            .languages(&languages)
+            .into_iter()
+            .map(|lang| Some(lang.to_string()))
+            .collect(),
+    };
+
+    let command = Cli::command();
+    let generate_command = command
+        .find_subcommand("generate")
+        .whatever_context("finding the generate subcommand")?;
+    // The command line is parsed again after the file's options and those
+    // implied by `go generate`, so that it overrides them
+    let (subcommand, cli_args) = argv.split_at(argv.len().min(2));
+    let mut commands = Vec::new();
+    for lang in langs {
+        let file_args = match config.args(generate_command, &languages, lang.as_deref()) {
+            Ok(file_args) => file_args,
+            Err(message) => whatever!("{message}"),
+        };
+        let argv = subcommand
+            .iter()
+            .cloned()
+            .chain(file_args.into_iter().map(OsString::from))
+            .chain(go_generate.iter().map(OsString::from))
+            .chain(cli_args.iter().cloned());
+        commands.push(Cli::parse_from(argv).command);
+    }
+    Ok(commands)
+}
+
+/// The options implied by running under `go generate`, which runs a
+/// `//go:generate` directive's command in the directory of its Go package
+/// and names the package in `$GOPACKAGE`: regenerate that package's Go
+/// bindings in place. Empty outside `go generate`.
+fn go_generate_args() -> Vec<String> {
+    go_package_args(std::env::var_os("GOPACKAGE").as_deref())
+}
+
+/// The options [`go_generate_args`] implies for the `$GOPACKAGE` `package`.
+fn go_package_args(package: Option<&OsStr>) -> Vec<String> {
+    let Some(package) = package else {
+        return Vec::new();
+    };
+    vec![
+        "--lang=go".to_string(),
+        "--output=.".to_string(),
+        format!("--go-package={}", package.to_string_lossy()),
+    ]
+}
+
+/// Format Go code with `gofmt`, so that the output is what `go fmt` would
+/// leave it as.
+fn gofmt(code: &str) -> Result<String> {
+    use std::io::Write;
+    use std::process::{Command, Stdio};
+
+    let mut child = Command::new("gofmt")
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .whatever_context("running gofmt (pass --go-no-fmt to skip formatting)")?;
+    // gofmt reads all of its input before writing any output
+    let Some(mut stdin) = child.stdin.take() else {
+        whatever!("gofmt has no stdin to write to");
+    };
+    stdin
+        .write_all(code.as_bytes())
+        .whatever_context("writing to gofmt")?;
+    // Closing stdin ends the input
+    drop(stdin);
+    let output = child.wait_with_output().whatever_context("running gofmt")?;
+    if !output.status.success() {
+        whatever!(
+            "gofmt rejected the generated code: {}",
+            String::from_utf8_lossy(&output.stderr).trim()
+        );
+    }
+    String::from_utf8(output.stdout).whatever_context("reading the output of gofmt")
+}
+
+/// The Go import path of `dir`: the module path in the `go.mod` of `dir`
+/// or its nearest parent that has one, followed by the directories below
+/// it.
+fn go_module_path(dir: &Path) -> Option<String> {
+    let dir = std::path::absolute(dir).ok()?;
+    let (root, go_mod) = dir.ancestors().find_map(|root| {
+        let go_mod = std::fs::read_to_string(root.join("go.mod")).ok()?;
+        Some((root, go_mod))
+    })?;
+    let module = go_mod
+        .lines()
+        .find_map(|line| line.trim().strip_prefix("module "))?
+        .trim()
+        .trim_matches('"');
+    let mut path = module.to_string();
+    for component in dir.strip_prefix(root).ok()?.components() {
+        if let std::path::Component::Normal(name) = component {
+            path.push('/');
+            path.push_str(&name.to_string_lossy());
+        }
+    }
+    Some(path)
+}
+
+/// The path of the directory `to` from the directory `from`, with `/`
+/// separators (e.g. `../../wit`), or None if either cannot be made
+/// absolute. Neither needs to exist.
+fn relative_path(from: &Path, to: &Path) -> Option<String> {
+    let absolute = |path: &Path| -> Option<PathBuf> {
+        let mut normal = PathBuf::new();
+        for component in std::path::absolute(path).ok()?.components() {
+            match component {
+                std::path::Component::CurDir => {}
+                std::path::Component::ParentDir => {
+                    normal.pop();
                 }
+                component => normal.push(component),
+            }
+        }
+        Some(normal)
+    };
+    let from = absolute(from)?;
+    let to = absolute(to)?;
+    let common = from
+        .components()
+        .zip(to.components())
+        .take_while(|(a, b)| a == b)
+        .count();
+    let parts: Vec<String> =
+        std::iter::repeat_n("..".to_string(), from.components().count() - common)
+            .chain(
+                to.components()
+                    .skip(common)
+                    .map(|component| component.as_os_str().to_string_lossy().into_owned()),
+            )
+            .collect();
+    if parts.is_empty() {
+        Some(".".to_string())
+    } else {
+        Some(parts.join("/"))
+    }
+}
+
+/// Write a generated file, reporting it unless `quiet`.
+fn write_generated(path: &Path, contents: impl AsRef<[u8]>, quiet: bool) -> Result<()> {
+    std::fs::write(path, contents)
+        .with_whatever_context(|_| format!("writing {}", path.display()))?;
+    if !quiet {
+        eprintln!("Wrote {}", path.display());
+    }
+    Ok(())
+}
+
+/// Generate bindings as `args` asks, listing the files written unless
+/// `quiet`.
+fn generate(args: GenerateArgs, quiet: bool) -> Result<()> {
+    let GenerateArgs {
+        config: _,
+        wit,
+        compose,
+        lang,
+        output,
+        c_prefix,
+        c_type_prefix,
+        kotlin_package,
+        lib_name,
+        go_package,
+        go_file_prefix,
+        go_no_fmt,
+        go_backend,
+        batch,
+        skip,
+        only,
+        exclude,
+        into_variants,
+        columnar,
+        alloc_accounting,
+        alloc_canaries,
+        lifecycle,
+        log_bridge,
+        go_resource_cleanup,
+        go_resource_cleanup_override,
+        go_generic_options,
+        go_multi_value_results,
+        go_named_results,
+        go_stream_iterators,
+        go_context,
+        go_context_function,
+        go_timeout,
+        go_dispatch_thread,
+        go_blocking,
+        go_single_threaded,
+        go_no_buffer_pool,
+        go_pin_bytes,
+        go_arena_chunk_size,
+        go_borrowed_string,
+        go_list_view,
+        go_zero_copy,
+        go_list_seq,
+        go_cgo_annotations,
+        go_cgo_unannotated,
+        go_intern_strings,
+        go_cgocheck_tests,
+        go_foreign_panics,
+        go_error_chains,
+        go_call_hooks,
+        go_otel_spans,
+        go_pprof_labels,
+        go_metrics,
+        go_http_handlers,
+        go_call_dump,
+        go_call_dump_max_len,
+        go_call_dump_redact,
+        go_handle_table,
+        go_init,
+        go_type_mapping,
+        go_custom_type,
+        go_initialisms,
+        go_initialism,
+        go_name,
+        go_function_name,
+        go_keyword_suffix,
+        go_subpackages,
+        go_import_path,
+        go_threads,
+        go_deprecation_warnings,
+        go_source_comments,
+        go_line_directives,
+        post_generate,
+        incremental,
+        final_output,
+        world,
+        all_worlds,
+    } = args;
+    let Some(wit) = wit else {
+        whatever!(
+            "no WIT given: pass --wit or set `wit` in {}",
+            config::FILE_NAME
+        );
+    };
+    let Some(lang) = lang else {
+        whatever!(
+            "no language given: pass --lang or add a language table to {}",
+            config::FILE_NAME
+        );
+    };
+    let Some(output) = output else {
+        whatever!(
+            "no output directory given: pass --output or set `output` in {}",
+            config::FILE_NAME
+        );
+    };
+
+    // Subpackages import the generated package by its import path
+    let go_import_path = match go_import_path {
+        None if go_subpackages => Some(go_module_path(&output).with_whatever_context(|| {
+            format!(
+                "finding the Go import path of {}: pass --go-import-path, or create a go.mod",
+                output.display()
+            )
+        })?),
+        go_import_path => go_import_path,
+    };
+
+    let (mut resolve, pkg_id) = witffi_core::load_wit_package(&wit)
+        .with_whatever_context(|_| format!("loading WIT from {}", wit.display()))?;
+    // With --all-worlds, the hooks get the directory holding every world
+    let hook_dir = output.clone();
+
+    // Each world is generated into its own subdirectory with --all-worlds
+    let targets: Vec<(WorldId, PathBuf)> = if all_worlds {
+        resolve.packages[pkg_id]
+            .worlds
+            .iter()
+            .map(|(name, world_id)| (*world_id, output.join(name)))
+            .collect()
+    } else {
+        let world_id = witffi_core::select_world(&resolve, pkg_id, world.as_deref())
+            .with_whatever_context(|_| format!("selecting a world from {}", wit.display()))?;
+        let world_id = match compose.is_empty() {
+            true => world_id,
+            false => witffi_core::compose::compose_worlds(&mut resolve, world_id, &compose)
+                .whatever_context("composing the worlds")?,
+        };
+        vec![(world_id, output)]
+    };
+
+    // The incremental caches of the Go output directories, saved last
+    let mut caches = Vec::new();
+    for (world_id, output) in targets {
+        // With --all-worlds, each world filters the interfaces it exports,
+        // named in full so that those it does not export are no error
+        let (only, exclude) = if all_worlds {
+            let mut interfaces: Vec<String> = witffi_core::exported_functions(&resolve, world_id)
+                .into_iter()
+                .map(|ef| ef.interface_name)
+                .filter(|name| !name.is_empty())
+                .collect();
+            interfaces.dedup();
+            interfaces.retain(|name| {
+                (!only.is_empty() && !only.contains(name)) || exclude.contains(name)
+            });
+            (Vec::new(), interfaces)
+        } else {
+            (only.clone(), exclude.clone())
+        };
+        witffi_core::filter_interfaces(&mut resolve, world_id, &only, &exclude)
+            .with_whatever_context(|_| {
+                format!(
+                    "filtering the interfaces of world `{}`",
+                    resolve.worlds[world_id].name
+                )
+            })?;
+
+        // With --all-worlds, each world also skips and batches the functions
+        // it exports
+        let exported: Vec<String> = witffi_core::exported_functions(&resolve, world_id)
+            .iter()
+            .map(|ef| ef.qualified_name(&resolve))
+            .collect();
+        let skip: Vec<String> = skip
+            .iter()
+            .filter(|function| !all_worlds || exported.contains(function))
+            .cloned()
+            .collect();
+        witffi_core::skip_functions(&mut resolve, world_id, &skip).with_whatever_context(|_| {
+            format!(
+                "skipping functions of world `{}`",
+                resolve.worlds[world_id].name
+            )
+        })?;
+        let batch: Vec<String> = batch
+            .iter()
+            .filter(|function| !all_worlds || exported.contains(function))
+            .cloned()
+            .collect();
+        witffi_core::add_batch_functions(&mut resolve, world_id, &batch).with_whatever_context(
+            |_| {
+                format!(
+                    "adding batched functions to world `{}`",
+                    resolve.worlds[world_id].name
+                )
+            },
+        )?;
+        witffi_core::check_name_collisions(&resolve, world_id).with_whatever_context(|_| {
+            format!(
+                "checking generated names for world `{}`",
+                resolve.worlds[world_id].name
+            )
+        })?;
+        witffi_core::check_imported_functions(&resolve, world_id).with_whatever_context(|_| {
+            format!(
+                "checking imported functions of world `{}`",
+                resolve.worlds[world_id].name
+            )
+        })?;
+        witffi_core::check_async_functions(&resolve, world_id).with_whatever_context(|_| {
+            format!(
+                "checking async functions of world `{}`",
+                resolve.worlds[world_id].name
+            )
+        })?;
+        witffi_core::check_streams(&resolve, world_id).with_whatever_context(|_| {
+            format!(
+                "checking streams of world `{}`",
+                resolve.worlds[world_id].name
+            )
+        })?;
+        witffi_core::check_futures(&resolve, world_id).with_whatever_context(|_| {
+            format!(
+                "checking futures of world `{}`",
+                resolve.worlds[world_id].name
+            )
+        })?;
+        witffi_core::check_columnar(&resolve, &columnar)
+            .whatever_context("checking columnar records")?;
+
+        // Every exported symbol is namespaced by the prefix
+        let c_prefix = c_prefix.clone().unwrap_or_else(|| {
+            let package = &resolve.packages[pkg_id].name;
+            let version = package.version.as_ref().map(ToString::to_string);
+            let prefix = names::to_c_prefix(&package.namespace, &package.name, version.as_deref());
+            match all_worlds {
+                false => prefix,
+                true => names::to_c_func(&prefix, &resolve.worlds[world_id].name),
+            }
+        });
+
+        std::fs::create_dir_all(&output)
+            .with_whatever_context(|_| format!("creating output directory {}", output.display()))?;
+
+        match lang {
+            Language::Rust => {
+                let rust_config = witffi_rust::generate::RustConfig {
+                    c_prefix: c_prefix.clone(),
+                    c_type_prefix: c_type_prefix.clone(),
+                    kotlin_package: kotlin_package.clone(),
+                    library_name: lib_name.clone(),
+                    into_variants,
+                    columnar: columnar.iter().cloned().collect(),
+                    alloc_accounting,
+                    alloc_canaries,
+                    lifecycle,
+                    log_bridge,
+                };
+                let rust_generator =
+                    witffi_rust::RustGenerator::new(&resolve, world_id, rust_config);
+
+                let rust_code = rust_generator
+                    .generate()
+                    .whatever_context("generating Rust code")?;
+                let rust_path = output.join("ffi.rs");
+                write_generated(&rust_path, &rust_code, quiet)?;
+
+                let c_header = rust_generator
+                    .generate_c_header()
+                    .whatever_context("generating C header")?;
+                let header_path = output.join("ffi.h");
+                write_generated(&header_path, &c_header, quiet)?;
+
+                let types_path = output.join("witffi_types.h");
+                write_generated(&types_path, witffi_rust::WITFFI_TYPES_HEADER, quiet)?;
+            }
 
-                Language::Go => {
-                    let go_config = witffi_go::generate::GoConfig {
-                        c_prefix: c_prefix.clone(),
-                        c_type_prefix: c_type_prefix.clone(),
-                        go_package: None,
-                        lib_name: lib_name.unwrap_or_else(|| "witffi".to_string()),
+            Language::Swift => {
+                // Swift needs C headers as well as Swift bindings.
+                let rust_config = witffi_rust::generate::RustConfig {
+                    c_prefix: c_prefix.clone(),
+                    c_type_prefix: c_type_prefix.clone(),
+                    kotlin_package: None,
+                    library_name: None,
+                    into_variants,
+                    columnar: columnar.iter().cloned().collect(),
+                    alloc_accounting,
+                    alloc_canaries,
+                    lifecycle,
+                    log_bridge,
+                };
+                let rust_generator =
+                    witffi_rust::RustGenerator::new(&resolve, world_id, rust_config);
+
+                let c_header = rust_generator
+                    .generate_c_header()
+                    .whatever_context("generating C header")?;
+                let header_path = output.join("ffi.h");
+                write_generated(&header_path, &c_header, quiet)?;
+
+                let types_path = output.join("witffi_types.h");
+                write_generated(&types_path, witffi_rust::WITFFI_TYPES_HEADER, quiet)?;
+
+                let swift_config = witffi_swift::generate::SwiftConfig {
+                    c_prefix: c_prefix.clone(),
+                    c_type_prefix: c_type_prefix.clone(),
+                };
+                let swift_generator =
+                    witffi_swift::SwiftGenerator::new(&resolve, world_id, swift_config);
+
+                let swift_code = swift_generator
+                    .generate()
+                    .whatever_context("generating Swift code")?;
+                let swift_path = output.join("Bindings.swift");
+                write_generated(&swift_path, &swift_code, quiet)?;
+
+                let module_map = swift_generator
+                    .generate_module_map()
+                    .whatever_context("generating module map")?;
+                let map_path = output.join("module.modulemap");
+                write_generated(&map_path, &module_map, quiet)?;
+            }
+
+            Language::Kotlin => {
+                let kotlin_config = witffi_kotlin::generate::KotlinConfig {
+                    kotlin_package: kotlin_package.clone(),
+                    lib_name: lib_name.clone().unwrap_or_else(|| "witffi".to_string()),
+                };
+                let kotlin_generator =
+                    witffi_kotlin::KotlinGenerator::new(&resolve, world_id, kotlin_config);
+
+                let kotlin_code = kotlin_generator
+                    .generate()
+                    .whatever_context("generating Kotlin code")?;
+                let kotlin_path = output.join("Bindings.kt");
+                write_generated(&kotlin_path, &kotlin_code, quiet)?;
+            }
+
+            Language::Go => {
+                // Generating into an existing package keeps its name
+                let go_package = embed::package_name(&output, go_package.as_deref())?;
+                let go_config = witffi_go::generate::GoConfig {
+                    c_prefix: c_prefix.clone(),
+                    c_type_prefix: c_type_prefix.clone(),
+                    go_package,
+                    lib_name: lib_name.clone().unwrap_or_else(|| "witffi".to_string()),
+                    backend: go_backend.into(),
+                    resource_cleanup: go_resource_cleanup.into(),
+                    resource_cleanup_overrides: go_resource_cleanup_override
+                        .iter()
+                        .map(|(resource, strategy)| (resource.clone(), (*strategy).into()))
+                        .collect(),
+                    generic_options: go_generic_options,
+                    multi_value_results: go_multi_value_results,
+                    named_results: go_named_results.iter().cloned().collect(),
+                    stream_iterators: go_stream_iterators,
+                    context_params: go_context,
+                    context_functions: go_context_function.iter().cloned().collect(),
+                    timeouts: go_timeout.iter().cloned().collect(),
+                    dispatch_thread: go_dispatch_thread,
+                    blocking: go_blocking.iter().cloned().collect(),
+                    single_threaded: go_single_threaded.iter().cloned().collect(),
+                    into_variants,
+                    columnar: columnar.iter().cloned().collect(),
+                    buffer_pool: !go_no_buffer_pool,
+                    pin_bytes: go_pin_bytes,
+                    arena_chunk_size: go_arena_chunk_size.map(|size| size as usize),
+                    borrowed_strings: go_borrowed_string.iter().cloned().collect(),
+                    list_views: go_list_view.iter().cloned().collect(),
+                    zero_copy: go_zero_copy.iter().cloned().collect(),
+                    list_seqs: go_list_seq.iter().cloned().collect(),
+                    cgo_annotations: go_cgo_annotations,
+                    cgo_unannotated: go_cgo_unannotated.iter().cloned().collect(),
+                    intern_strings: go_intern_strings,
+                    alloc_accounting,
+                    alloc_canaries,
+                    lifecycle,
+                    log_bridge,
+                    cgocheck_tests: go_cgocheck_tests,
+                    foreign_panics: go_foreign_panics,
+                    error_chains: go_error_chains,
+                    call_hooks: go_call_hooks,
+                    otel_spans: go_otel_spans,
+                    pprof_labels: go_pprof_labels,
+                    metrics: go_metrics,
+                    http_handlers: go_http_handlers,
+                    call_dump: go_call_dump,
+                    call_dump_max_len: go_call_dump_max_len,
+                    call_dump_redact: go_call_dump_redact.iter().cloned().collect(),
+                    handle_table: go_handle_table,
+                    init_mode: go_init.into(),
+                    type_mappings: go_type_mapping
+                        .iter()
+                        .map(|(wit_type, mapping)| (wit_type.clone(), (*mapping).into()))
+                        .chain(go_custom_type.iter().map(|(wit_type, custom)| {
+                            let custom = custom.clone();
+                            (
+                                wit_type.clone(),
+                                witffi_go::generate::GoTypeMapping::Custom(custom),
+                            )
+                        }))
+                        .collect(),
+                    naming: GoNaming {
+                        initialisms: if go_initialisms { GO_INITIALISMS } else { &[] }
+                            .iter()
+                            .map(|word| word.to_string())
+                            .chain(go_initialism.iter().map(|word| word.to_lowercase()))
+                            .collect(),
+                        overrides: go_name.iter().cloned().collect(),
+                        keyword_suffix: go_keyword_suffix.clone(),
+                    },
+                    function_names: go_function_name.iter().cloned().collect(),
+                    subpackages: go_subpackages,
+                    // With --all-worlds, each world's package is a directory below
+                    import_path: go_import_path.as_ref().map(|path| {
+                        if all_worlds {
+                            format!("{path}/{}", resolve.worlds[world_id].name)
+                        } else {
+                            path.clone()
+                        }
+                    }),
+                    // Plugins are Rust code, given by programs using witffi-go
+                    plugins: Vec::new(),
+                    threads: go_threads,
+                    deprecation_warnings: go_deprecation_warnings,
+                    source_locations: if go_source_comments || go_line_directives {
+                        witffi_core::source_map::wit_locations(&wit)
+                    } else {
+                        Default::default()
+                    },
+                    // Relative directives are read from the Go file's directory
+                    line_directives: if go_line_directives {
+                        let wit_dir = if wit.is_dir() {
+                            wit.as_path()
+                        } else {
+                            wit.parent().unwrap_or(Path::new("."))
+                        };
+                        let from = match &final_output {
+                            Some(dir) if all_worlds => dir.join(&resolve.worlds[world_id].name),
+                            Some(dir) => dir.clone(),
+                            None => output.clone(),
+                        };
+                        Some(relative_path(&from, wit_dir).with_whatever_context(|| {
+                            format!("finding {} from {}", wit_dir.display(), from.display())
+                        })?)
+                    } else {
+                        None
+                    },
+                };
+                let go_generator = witffi_go::GoGenerator::new(&resolve, world_id, go_config);
+
+                let go_code = go_generator
+                    .generate()
+                    .whatever_context("generating Go code")?;
+                let go_file = ("bindings.go".to_string(), go_code);
+
+                let feature_files = go_generator
+                    .generate_feature_files()
+                    .whatever_context("generating feature-gated Go code")?;
+                let accounting_files = go_generator
+                    .generate_alloc_accounting_files()
+                    .whatever_context("generating allocation accounting Go code")?;
+                let cgocheck_test = go_generator
+                    .generate_cgocheck_test()
+                    .whatever_context("generating cgocheck test")?;
+                let metrics_file = go_generator
+                    .generate_metrics_file()
+                    .whatever_context("generating Go metrics")?;
+                let call_dump_files = go_generator
+                    .generate_call_dump_files()
+                    .whatever_context("generating call dump Go code")?;
+                let handle_table_files = go_generator
+                    .generate_handle_table_files()
+                    .whatever_context("generating handle table Go code")?;
+                let subpackages = go_generator
+                    .generate_subpackages()
+                    .whatever_context("generating Go subpackages")?;
+                let http_handlers_file = go_generator
+                    .generate_http_handlers_file()
+                    .whatever_context("generating Go HTTP handlers")?;
+                let proto_file = go_generator
+                    .generate_proto_file()
+                    .whatever_context("generating the gRPC proto file")?;
+                let mut cache = incremental.then(|| incremental::Cache::load(&output));
+                let mut unchanged = 0;
+                for (file_name, code) in [go_file]
+                    .into_iter()
+                    .chain(feature_files)
+                    .chain(accounting_files)
+                    .chain(cgocheck_test)
+                    .chain(metrics_file)
+                    .chain(http_handlers_file)
+                    .chain(call_dump_files)
+                    .chain(handle_table_files)
+                    .chain(subpackages)
+                    .chain(proto_file)
+                {
+                    let prefix = go_file_prefix.as_deref().unwrap_or_default();
+                    let file_name = embed::prefixed(&file_name, prefix);
+                    let path = output.join(&file_name);
+                    // The proto file is no Go
+                    let formatted = !go_no_fmt && file_name.ends_with(".go");
+                    let code_hash = incremental::code_hash(&code, formatted);
+                    if let Some(cache) = &mut cache {
+                        cache.record(&file_name, code_hash);
+                        if cache.is_fresh(&file_name, code_hash) {
+                            unchanged += 1;
+                            continue;
+                        }
+                    }
+                    let code = if formatted {
+                        gofmt(&code).with_whatever_context(|_| format!("formatting {file_name}"))?
+                    } else {
+                        code
                     };
-                    let go_generator = witffi_go::GoGenerator::new(&resolve, world_id, go_config);
-
-                    let go_code = go_generator
-                        .generate()
-                        .whatever_context("generating Go code")?;
-                    let go_path = output.join("bindings.go");
-                    std::fs::write(&go_path, &go_code)
-                        .with_whatever_context(|_| format!("writing {}", go_path.display()))?;
-                    eprintln!("Wrote {}", go_path.display());
+                    embed::check_overwrite(&path)?;
+                    if let Some(dir) = path.parent() {
+                        std::fs::create_dir_all(dir).with_whatever_context(|_| {
+                            format!("creating output directory {}", dir.display())
+                        })?;
+                    }
+                    write_generated(&path, &code, quiet)?;
+                }
+                if let Some(cache) = cache {
+                    if !quiet && unchanged > 0 {
+                        eprintln!(
+                            "Left {unchanged} unchanged Go file(s) in {}",
+                            output.display()
+                        );
+                    }
+                    caches.push(cache);
                 }
             }
         }
     }
 
+    run_post_generate(&post_generate, &hook_dir, quiet)?;
+    // Files are recorded as the post-generate commands left them, so that
+    // commands rewriting them do not leave them stale for the next run
+    for cache in &caches {
+        cache.save()?;
+    }
     Ok(())
 }
+
+/// Run each of the `post_generate` commands with `output` as its last
+/// argument, reporting them unless `quiet`.
+fn run_post_generate(commands: &[String], output: &Path, quiet: bool) -> Result<()> {
+    for command in commands {
+        let mut words = command.split_whitespace();
+        let Some(program) = words.next() else {
+            whatever!("empty post-generate command");
+        };
+        if !quiet {
+            eprintln!("Running {command} {}", output.display());
+        }
+        let status = std::process::Command::new(program)
+            .args(words)
+            .arg(output)
+            .status()
+            .with_whatever_context(|_| format!("running post-generate command `{command}`"))?;
+        if !status.success() {
+            whatever!("post-generate command `{command}` failed ({status})");
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_go_package_args() {
+        assert!(go_package_args(None).is_empty());
+        assert_eq!(
+            go_package_args(Some("parser".as_ref())),
+            ["--lang=go", "--output=.", "--go-package=parser"]
+        );
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_post_generate() {
+        let dir = std::env::temp_dir().join(format!("witffi-post-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        // Logs its arguments, the output dir last, to `log` in the output dir
+        let script = dir.join("log.sh");
+        std::fs::write(&script, "echo \"$@\" >> \"$2/log\"\n").unwrap();
+        let log = |name: &str| format!("sh {} {name}", script.display());
+
+        run_post_generate(&[log("first"), log("second")], &dir, true).unwrap();
+        assert_eq!(
+            std::fs::read_to_string(dir.join("log")).unwrap(),
+            format!("first {0}\nsecond {0}\n", dir.display())
+        );
+
+        std::fs::remove_file(dir.join("log")).unwrap();
+        let err = run_post_generate(&["false".to_string(), log("after")], &dir, true).unwrap_err();
+        assert!(
+            err.to_string()
+                .contains("post-generate command `false` failed"),
+            "{err}"
+        );
+        assert!(
+            !dir.join("log").exists(),
+            "commands after a failed one should not run"
+        );
+
+        assert!(run_post_generate(&[" ".to_string()], &dir, true).is_err());
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+}
@@ -0,0 +1,167 @@
+//! `witffi new`, scaffolding a Rust library with Go bindings.
+//!
+//! The project is laid out like the eip681 example: a Rust crate built as a
+//! staticlib, its WIT under `wit/`, a `witffi.toml` generating the Rust
+//! scaffolding into `src/` and the Go bindings into `go/`, a `Makefile`
+//! tying the builds together and tests on both sides. The files come from
+//! the templates under `templates/new`, and the bindings are generated
+//! before the command returns, so the project builds straight away.
+
+use std::ffi::OsString;
+use std::path::{Path, PathBuf};
+
+use clap::Parser;
+use snafu::prelude::*;
+use witffi_core::names;
+
+use crate::{Cli, Commands, NewArgs, Result};
+
+/// The project files, as paths relative to the project directory and their
+/// templates.
+const TEMPLATES: &[(&str, &str)] = &[
+    ("witffi.toml", include_str!("../templates/new/witffi.toml")),
+    ("Cargo.toml", include_str!("../templates/new/crate.toml")),
+    ("src/lib.rs", include_str!("../templates/new/lib.rs")),
+    (
+        "wit/{{name}}.wit",
+        include_str!("../templates/new/world.wit"),
+    ),
+    ("go/go.mod", include_str!("../templates/new/go.mod")),
+    (
+        "go/smoke_test.go",
+        include_str!("../templates/new/smoke_test.go"),
+    ),
+    ("Makefile", include_str!("../templates/new/Makefile")),
+    (".gitignore", include_str!("../templates/new/gitignore")),
+];
+
+/// The C headers the Rust generation writes into `src/`, which cgo also
+/// needs beside the Go bindings.
+const HEADERS: &[&str] = &["ffi.h", "witffi_types.h"];
+
+/// Create the project `args` describes and generate its bindings.
+pub fn run(args: &NewArgs) -> Result<()> {
+    check_name(&args.name).map_err(|message| snafu::FromString::without_source(message))?;
+    let dir = args
+        .path
+        .clone()
+        .unwrap_or_else(|| PathBuf::from(&args.name));
+    let occupied = std::fs::read_dir(&dir).is_ok_and(|mut entries| entries.next().is_some());
+    if occupied {
+        whatever!("{} already exists and is not empty", dir.display());
+    }
+    let go_module = args
+        .go_module
+        .clone()
+        .unwrap_or_else(|| format!("example.com/{}", args.name));
+
+    for (path, contents) in files(&args.name, &go_module) {
+        let path = dir.join(path);
+        if let Some(parent) = path.parent() {
+            std::fs::create_dir_all(parent)
+                .with_whatever_context(|_| format!("creating {}", parent.display()))?;
+        }
+        crate::write_generated(&path, contents, false)?;
+    }
+
+    // Generate every language of the new witffi.toml, as `witffi generate`
+    // run from the project would
+    let argv: Vec<OsString> = ["witffi", "generate", "--config"]
+        .into_iter()
+        .map(OsString::from)
+        .chain([dir.join(crate::config::FILE_NAME).into_os_string()])
+        .collect();
+    let Commands::Generate(generate) = Cli::parse_from(&argv).command else {
+        unreachable!("parsed a generate command");
+    };
+    for command in crate::configured_commands(&generate, &argv)? {
+        if let Commands::Generate(args) = command {
+            crate::generate(args, false)?;
+        }
+    }
+    copy_headers(&dir)?;
+
+    eprintln!(
+        "Created {}: run `make` there to build it and run its tests",
+        dir.display()
+    );
+    Ok(())
+}
+
+/// Check that `name` can name the crate, the WIT world and the Go package:
+/// kebab-case words of lowercase letters and digits, each starting with a
+/// letter.
+fn check_name(name: &str) -> Result<(), String> {
+    let valid = !name.is_empty()
+        && name.split('-').all(|word| {
+            word.starts_with(|c: char| c.is_ascii_lowercase())
+                && word
+                    .chars()
+                    .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit())
+        });
+    if !valid {
+        return Err(format!(
+            "`{name}` is not a valid project name: use kebab-case words of lowercase letters \
+             and digits, each starting with a letter (e.g. `my-lib`)"
+        ));
+    }
+    Ok(())
+}
+
+/// The files of the project `name`, as paths relative to the project
+/// directory and their contents.
+fn files(name: &str, go_module: &str) -> Vec<(String, String)> {
+    let fill = |template: &str| {
+        template
+            .replace("{{name}}", name)
+            .replace("{{crate}}", &names::to_rust_ident(name))
+            .replace("{{trait}}", &names::to_rust_type(name))
+            .replace("{{go_package}}", &names::to_go_package(name))
+            .replace("{{go_module}}", go_module)
+    };
+    TEMPLATES
+        .iter()
+        .map(|(path, template)| (fill(path), fill(template)))
+        .collect()
+}
+
+/// Copy the C headers generated into `src/` next to the Go bindings, as
+/// `make generate` does.
+fn copy_headers(dir: &Path) -> Result<()> {
+    for header in HEADERS {
+        let (from, to) = (dir.join("src").join(header), dir.join("go").join(header));
+        std::fs::copy(&from, &to)
+            .with_whatever_context(|_| format!("copying {} to {}", from.display(), to.display()))?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_files() {
+        assert!(check_name("my-lib2").is_ok());
+        assert!(check_name("My_Lib").is_err());
+        assert!(check_name("my--lib").is_err());
+
+        let files = files("my-lib", "example.com/my-lib");
+        let file = |path: &str| {
+            files
+                .iter()
+                .find(|(p, _)| p == path)
+                .map(|(_, contents)| contents.as_str())
+                .unwrap_or_else(|| panic!("no {path} in the project"))
+        };
+        assert!(file("wit/my-lib.wit").contains("world my-lib {"));
+        assert!(file("src/lib.rs").contains("impl MyLib for Impl {"));
+        assert!(file("witffi.toml").contains("c-prefix = \"my_lib\""));
+        assert!(file("go/smoke_test.go").starts_with("package mylib\n"));
+        assert_eq!(file("go/go.mod"), "module example.com/my-lib\n\ngo 1.22\n");
+        assert!(
+            files.iter().all(|(_, contents)| !contents.contains("{{")),
+            "every placeholder should be filled in"
+        );
+    }
+}
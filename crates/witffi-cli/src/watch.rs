@@ -0,0 +1,190 @@
+//! `witffi watch`, regenerating bindings whenever their inputs change.
+//!
+//! Changes are found by polling the modification times of the watched
+//! files: the WIT (with the `deps/` beside a single WIT file) and any
+//! `--watch` paths. Generation errors are reported and watching goes on, so
+//! a half-edited WIT file does not end the session.
+
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use std::time::SystemTime;
+
+use crate::api::go_api;
+use crate::{Language, Result, WatchArgs};
+
+/// Directories never watched, which builds and version control rewrite.
+const SKIPPED_DIRS: &[&str] = &["target", ".git"];
+
+/// The modification times of the watched files.
+type Snapshot = BTreeMap<PathBuf, SystemTime>;
+
+/// Generate the bindings of every language in `watches`, then regenerate
+/// them on every change until interrupted.
+pub fn run(watches: Vec<WatchArgs>) -> Result<()> {
+    // Every language comes from the same command line, so shares its
+    // watch options
+    let Some(first) = watches.first() else {
+        return Ok(());
+    };
+    let (interval, cargo_build) = (first.interval, first.cargo_build);
+    let mut paths: Vec<PathBuf> = watches.iter().flat_map(inputs).collect();
+    paths.sort();
+    paths.dedup();
+
+    regenerate(&watches, cargo_build);
+    let mut before = snapshot(&paths);
+    let watched: Vec<String> = paths.iter().map(|p| p.display().to_string()).collect();
+    eprintln!("Watching {} for changes", watched.join(", "));
+    loop {
+        std::thread::sleep(interval);
+        let after = snapshot(&paths);
+        if after == before {
+            continue;
+        }
+        for path in changed(&before, &after) {
+            eprintln!("Changed {}", path.display());
+        }
+        regenerate(&watches, cargo_build);
+        // Taken again after generating, so that generated files under a
+        // watched path do not count as a change
+        before = snapshot(&paths);
+    }
+}
+
+/// The paths to watch for one language.
+fn inputs(watch: &WatchArgs) -> Vec<PathBuf> {
+    let mut paths = watch.paths.clone();
+    if let Some(wit) = &watch.generate.wit {
+        paths.push(wit.clone());
+        if wit.is_file() {
+            let dir = wit.parent().unwrap_or(Path::new(""));
+            paths.push(dir.join("deps"));
+        }
+    }
+    paths
+}
+
+/// Generate every language again, printing how the Go API changed, then
+/// run `cargo build` if asked to. Failures are printed rather than
+/// returned.
+fn regenerate(watches: &[WatchArgs], cargo_build: bool) {
+    for watch in watches {
+        let go_files = go_bindings(watch);
+        let old_apis: Vec<Option<Vec<String>>> =
+            go_files.iter().map(|path| read_go_api(path)).collect();
+        if let Err(err) = crate::generate(watch.generate.clone(), false) {
+            eprintln!("Error: {}", snafu::Report::from_error(err));
+            continue;
+        }
+        for (path, old_api) in go_files.iter().zip(old_apis) {
+            // Freshly created bindings have no API to compare with
+            let (Some(old_api), Some(new_api)) = (old_api, read_go_api(path)) else {
+                continue;
+            };
+            print_api_diff(path, &old_api, &new_api);
+        }
+    }
+
+    if cargo_build {
+        match std::process::Command::new("cargo").arg("build").status() {
+            Ok(status) if status.success() => {}
+            Ok(status) => eprintln!("cargo build failed ({status})"),
+            Err(err) => eprintln!("Error: running cargo build: {err}"),
+        }
+    }
+}
+
+/// The main Go files (`bindings.go`, with any `--go-file-prefix`) generated
+/// for `watch`, if it generates Go.
+fn go_bindings(watch: &WatchArgs) -> Vec<PathBuf> {
+    let args = &watch.generate;
+    let (Some(Language::Go), Some(output)) = (args.lang, &args.output) else {
+        return Vec::new();
+    };
+    let file_name = format!(
+        "{}bindings.go",
+        args.go_file_prefix.as_deref().unwrap_or_default()
+    );
+    if !args.all_worlds {
+        return vec![output.join(file_name)];
+    }
+    // One package per world
+    let Ok(entries) = std::fs::read_dir(output) else {
+        return Vec::new();
+    };
+    let mut paths: Vec<PathBuf> = entries
+        .filter_map(|entry| entry.ok())
+        .map(|entry| entry.path().join(&file_name))
+        .collect();
+    paths.sort();
+    paths
+}
+
+/// The Go API of the file at `path`, if there is one.
+fn read_go_api(path: &Path) -> Option<Vec<String>> {
+    std::fs::read_to_string(path).ok().map(|code| go_api(&code))
+}
+
+/// Print the declarations removed from and added to the Go API of `path`.
+fn print_api_diff(path: &Path, old_api: &[String], new_api: &[String]) {
+    let removed: Vec<&String> = old_api.iter().filter(|d| !new_api.contains(d)).collect();
+    let added: Vec<&String> = new_api.iter().filter(|d| !old_api.contains(d)).collect();
+    if removed.is_empty() && added.is_empty() {
+        eprintln!("Go API of {} unchanged", path.display());
+        return;
+    }
+    eprintln!("Go API of {}:", path.display());
+    for declaration in removed {
+        eprintln!("  - {declaration}");
+    }
+    for declaration in added {
+        eprintln!("  + {declaration}");
+    }
+}
+
+/// The modification times of the files under `paths`. Missing paths are
+/// skipped, so that deleting a watched file counts as a change.
+fn snapshot(paths: &[PathBuf]) -> Snapshot {
+    let mut snapshot = Snapshot::new();
+    for path in paths {
+        scan(path, &mut snapshot);
+    }
+    snapshot
+}
+
+/// Record the modification time of `path`, or of every file under it.
+fn scan(path: &Path, snapshot: &mut Snapshot) {
+    let Ok(metadata) = std::fs::metadata(path) else {
+        return;
+    };
+    if metadata.is_file() {
+        if let Ok(modified) = metadata.modified() {
+            snapshot.insert(path.to_path_buf(), modified);
+        }
+        return;
+    }
+    let Ok(entries) = std::fs::read_dir(path) else {
+        return;
+    };
+    for entry in entries.filter_map(|entry| entry.ok()) {
+        let skipped = entry
+            .file_name()
+            .to_str()
+            .is_some_and(|name| SKIPPED_DIRS.contains(&name));
+        if !skipped {
+            scan(&entry.path(), snapshot);
+        }
+    }
+}
+
+/// The paths that were added, removed or modified between two snapshots.
+fn changed<'a>(before: &'a Snapshot, after: &'a Snapshot) -> Vec<&'a PathBuf> {
+    let mut paths: Vec<&PathBuf> = after
+        .iter()
+        .filter(|(path, modified)| before.get(*path) != Some(modified))
+        .map(|(path, _)| path)
+        .chain(before.keys().filter(|path| !after.contains_key(*path)))
+        .collect();
+    paths.sort();
+    paths
+}
@@ -0,0 +1,32 @@
+//! The {{name}} library, called from Go through bindings generated by
+//! `witffi`.
+//!
+//! `witffi generate` writes `src/ffi.rs` from `wit/{{name}}.wit`: the
+//! `{{trait}}` trait, with one method per exported WIT function, and the
+//! `witffi_register_ffi!` macro stamping out the `extern "C"` functions the
+//! Go bindings call.
+#![allow(non_camel_case_types, non_snake_case, unused_unsafe)]
+
+mod ffi;
+use ffi::*;
+
+/// The implementation of the exported WIT functions.
+struct Impl;
+
+impl {{trait}} for Impl {
+    fn greeter_greet(name: &str) -> String {
+        format!("Hello, {name}!")
+    }
+}
+
+witffi_register_ffi!(Impl);
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_greet() {
+        assert_eq!(<Impl as {{trait}}>::greeter_greet("Rust"), "Hello, Rust!");
+    }
+}
@@ -0,0 +1,10 @@
+package {{go_package}}
+
+import "testing"
+
+// TestGreet calls into the Rust library through the generated bindings.
+func TestGreet(t *testing.T) {
+	if got := GreeterGreet("Go"); got != "Hello, Go!" {
+		t.Fatalf("GreeterGreet(%q) = %q, want %q", "Go", got, "Hello, Go!")
+	}
+}
@@ -0,0 +1,216 @@
+//! The canonical ABI of the functions a library exports as WebAssembly.
+//!
+//! Built for `wasm32-wasip1`, a library exports each function as the core
+//! module of a component would: under its component export name (e.g.
+//! `test:hashing/hasher#hash`), its parameters flattened into core Wasm
+//! values, and a result too big for one core value written to a return area
+//! in linear memory, whose address the function returns. The caller reads
+//! the result from there and then calls the `cabi_post_` function of the
+//! export, which frees what the result points to.
+//!
+//! The values crossing this way are the plain ones (see
+//! [`plain_signature`]): numbers, bools, strings, `list<u8>` and
+//! `result<T, string>` of them. [`wasm_signature`] classifies a function,
+//! and the rest of this module lays its values out.
+
+use wit_parser::{Resolve, Type, WorldId, WorldItem, WorldKey};
+
+use crate::{
+    ExportedFunction, ImportResult, ImportSignature, ImportValue, plain_signature,
+    qualified_interface_names,
+};
+
+/// The most core parameters a function may take before the canonical ABI
+/// passes them through memory instead, which witffi does not support.
+pub const MAX_FLAT_PARAMS: usize = 16;
+
+/// The size of the largest return area a supported function writes: a
+/// `result<u64, string>`.
+pub const RETURN_AREA_SIZE: u32 = 16;
+
+/// A core WebAssembly value type.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CoreType {
+    I32,
+    I64,
+    F32,
+    F64,
+}
+
+impl CoreType {
+    /// The name of the type, as both WebAssembly and Rust spell it.
+    pub fn name(self) -> &'static str {
+        match self {
+            CoreType::I32 => "i32",
+            CoreType::I64 => "i64",
+            CoreType::F32 => "f32",
+            CoreType::F64 => "f64",
+        }
+    }
+}
+
+/// The shape of `ef` as a WebAssembly export, or None if it is not a
+/// [`plain_signature`] or takes more than [`MAX_FLAT_PARAMS`] core
+/// parameters.
+pub fn wasm_signature(resolve: &Resolve, ef: &ExportedFunction) -> Option<ImportSignature> {
+    let sig = plain_signature(resolve, ef)?;
+    let flat: usize = sig.params.iter().map(|(_, value)| flat(*value).len()).sum();
+    (flat <= MAX_FLAT_PARAMS).then_some(sig)
+}
+
+/// The name `ef` is exported under: `<interface id>#<function>` for
+/// functions of an interface (e.g. `test:hashing/hasher#hash`, or
+/// `hasher#hash` for one the world defines inline), and the bare function
+/// name for those of the world.
+pub fn export_name(resolve: &Resolve, world_id: WorldId, ef: &ExportedFunction) -> String {
+    let world = &resolve.worlds[world_id];
+    let qualified = qualified_interface_names(resolve, world.exports.keys());
+    for (key, item) in &world.exports {
+        if !matches!(item, WorldItem::Interface { .. }) {
+            continue;
+        }
+        let name = match key {
+            WorldKey::Name(name) => name,
+            WorldKey::Interface(id) => &qualified[id],
+        };
+        if *name == ef.interface_name {
+            return format!("{}#{}", resolve.name_world_key(key), ef.function.name);
+        }
+    }
+    ef.function.name.clone()
+}
+
+/// The name of the function freeing what the result of the export `name`
+/// points to.
+pub fn post_return_name(name: &str) -> String {
+    format!("cabi_post_{name}")
+}
+
+/// The core values `value` is flattened into.
+pub fn flat(value: ImportValue) -> Vec<CoreType> {
+    match value {
+        ImportValue::Scalar(Type::U64 | Type::S64) => vec![CoreType::I64],
+        ImportValue::Scalar(Type::F32) => vec![CoreType::F32],
+        ImportValue::Scalar(Type::F64) => vec![CoreType::F64],
+        ImportValue::Scalar(_) | ImportValue::Own(_) | ImportValue::Borrow(_) => {
+            vec![CoreType::I32]
+        }
+        // A pointer and a length
+        ImportValue::String | ImportValue::Bytes => vec![CoreType::I32, CoreType::I32],
+    }
+}
+
+/// The size and alignment of `value` in linear memory.
+pub fn size_align(value: ImportValue) -> (u32, u32) {
+    match value {
+        ImportValue::Scalar(Type::Bool | Type::U8 | Type::S8) => (1, 1),
+        ImportValue::Scalar(Type::U16 | Type::S16) => (2, 2),
+        ImportValue::Scalar(Type::U64 | Type::S64 | Type::F64) => (8, 8),
+        ImportValue::Scalar(_) | ImportValue::Own(_) | ImportValue::Borrow(_) => (4, 4),
+        ImportValue::String | ImportValue::Bytes => (8, 4),
+    }
+}
+
+/// Whether a function returning `result` writes it to a return area and
+/// returns its address, rather than returning it as a core value.
+pub fn returns_pointer(result: ImportResult) -> bool {
+    match result {
+        ImportResult::None => false,
+        ImportResult::Value(value) => flat(value).len() > 1,
+        // The discriminant and a payload
+        ImportResult::Fallible(_) => true,
+    }
+}
+
+/// The offset of the payload of a `result<T, string>` in its return area,
+/// behind the one-byte discriminant (0 for ok, 1 for an error): the ok
+/// value, if there is one, or the error string.
+pub fn payload_offset(ok: Option<ImportValue>) -> u32 {
+    let (_, string_align) = size_align(ImportValue::String);
+    ok.map_or(string_align, |ok| size_align(ok).1.max(string_align))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_canonical_layout() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "hashing.wit",
+                r#"
+package test:hashing;
+
+interface hasher {
+    resource state {
+        update: func(data: list<u8>);
+    }
+
+    hash: func(data: list<u8>, rounds: u32) -> list<u8>;
+    count: func() -> result<u64, string>;
+    wide: func(a: u64, b: u64, c: u64, d: u64, e: u64, f: u64, g: u64, h: u64, i: string, j: string, k: string, l: string, m: u8) -> u8;
+}
+
+world hashing {
+    export hasher;
+    export version: func() -> string;
+}
+"#,
+            )
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+        let funcs = crate::exported_functions(&resolve, world_id);
+        let find = |name: &str| {
+            funcs
+                .iter()
+                .find(|ef| ef.function.name == name)
+                .expect("the world exports the function")
+        };
+
+        assert_eq!(
+            export_name(&resolve, world_id, find("hash")),
+            "test:hashing/hasher#hash"
+        );
+        assert_eq!(export_name(&resolve, world_id, find("version")), "version");
+        assert_eq!(
+            post_return_name("test:hashing/hasher#hash"),
+            "cabi_post_test:hashing/hasher#hash"
+        );
+
+        let hash = wasm_signature(&resolve, find("hash")).expect("hash is supported");
+        assert_eq!(hash.result, ImportResult::Value(ImportValue::Bytes));
+        assert!(returns_pointer(hash.result));
+        assert_eq!(
+            flat(hash.params[1].1),
+            [CoreType::I32],
+            "a u32 should be passed as an i32"
+        );
+
+        let count = wasm_signature(&resolve, find("count")).expect("count is supported");
+        assert_eq!(
+            count.result,
+            ImportResult::Fallible(Some(ImportValue::Scalar(Type::U64)))
+        );
+        assert_eq!(payload_offset(Some(ImportValue::Scalar(Type::U64))), 8);
+        assert_eq!(payload_offset(Some(ImportValue::Scalar(Type::U8))), 4);
+        assert_eq!(payload_offset(None), 4);
+        assert!(
+            payload_offset(Some(ImportValue::Scalar(Type::U64))) + 8 <= RETURN_AREA_SIZE,
+            "the largest result should fit the return area"
+        );
+
+        assert!(
+            wasm_signature(&resolve, find("wide")).is_none(),
+            "17 core parameters should be too many"
+        );
+        assert!(
+            funcs
+                .iter()
+                .filter(|ef| ef.resource().is_some())
+                .all(|ef| wasm_signature(&resolve, ef).is_none()),
+            "resource functions should not be supported"
+        );
+    }
+}
@@ -0,0 +1,517 @@
+//! Changes between two versions of a WIT world, and which of them break
+//! code built against the older one.
+//!
+//! The bindings mirror the WIT closely: a record becomes a C struct laid
+//! out field by field, an enum's cases are numbered in order and a flag is
+//! a bit at its position. So besides removed functions and changed
+//! signatures, a field added to a record or a case moved within an enum
+//! breaks callers built against the old version, even where the WIT
+//! still reads compatibly. [`compare_worlds`] lists every change with that
+//! in mind, for library authors to version their FFI surface by.
+
+use std::collections::HashMap;
+
+use wit_parser::{Handle, Resolve, Type, TypeDefKind, TypeId, TypeOwner, WorldId};
+
+use crate::{exported_functions, imported_functions};
+
+/// Whether a [`Change`] breaks code built against the old version.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Severity {
+    /// Code built against the old version no longer builds or misreads
+    /// values: a major version bump.
+    Breaking,
+    /// Code built against the old version keeps working: a minor version
+    /// bump.
+    Compatible,
+}
+
+/// A change to an item of a world.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Change {
+    /// Whether the change is breaking.
+    pub severity: Severity,
+
+    /// The item changed: a function by direction and qualified name (e.g.
+    /// "export parser.parse"), or a type as `{interface}.{type}`.
+    pub item: String,
+
+    /// What changed (e.g. "removed", or "cases reordered").
+    pub description: String,
+}
+
+impl std::fmt::Display for Change {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}: {}", self.item, self.description)
+    }
+}
+
+/// Every change from the world `old_world` of `old` to the world
+/// `new_world` of `new`: to the types of the world's interfaces and to the
+/// functions it exports and imports. Breaking changes come first, each
+/// group sorted by item.
+pub fn compare_worlds(
+    old: &Resolve,
+    old_world: WorldId,
+    new: &Resolve,
+    new_world: WorldId,
+) -> Vec<Change> {
+    let mut changes = Vec::new();
+    compare_types(&mut changes, old, old_world, new, new_world);
+    compare_functions(&mut changes, old, old_world, new, new_world);
+    changes.sort_by(|a, b| (a.severity, &a.item).cmp(&(b.severity, &b.item)));
+    changes
+}
+
+fn breaking(item: &str, description: impl Into<String>) -> Change {
+    Change {
+        severity: Severity::Breaking,
+        item: item.to_string(),
+        description: description.into(),
+    }
+}
+
+fn compatible(item: &str, description: impl Into<String>) -> Change {
+    Change {
+        severity: Severity::Compatible,
+        item: item.to_string(),
+        description: description.into(),
+    }
+}
+
+// ---- Functions ----
+
+/// The signatures of the functions of a world, by direction and qualified
+/// name (e.g. "export parser.parse").
+fn signatures(resolve: &Resolve, world_id: WorldId) -> Vec<(String, String)> {
+    let exports = exported_functions(resolve, world_id)
+        .into_iter()
+        .map(|ef| ("export", ef));
+    let imports = imported_functions(resolve, world_id)
+        .into_iter()
+        .map(|ef| ("import", ef));
+    exports
+        .chain(imports)
+        .map(|(direction, ef)| {
+            let params: Vec<String> = ef
+                .function
+                .params
+                .iter()
+                .map(|param| format!("{}: {}", param.name, wit_type(resolve, &param.ty)))
+                .collect();
+            let async_marker = if ef.is_async() { "async " } else { "" };
+            let mut signature = format!("{async_marker}func({})", params.join(", "));
+            if let Some(result) = &ef.function.result {
+                signature.push_str(&format!(" -> {}", wit_type(resolve, result)));
+            }
+            (
+                format!("{direction} {}", ef.qualified_name(resolve)),
+                signature,
+            )
+        })
+        .collect()
+}
+
+fn compare_functions(
+    changes: &mut Vec<Change>,
+    old: &Resolve,
+    old_world: WorldId,
+    new: &Resolve,
+    new_world: WorldId,
+) {
+    let old_signatures = signatures(old, old_world);
+    let new_signatures: HashMap<String, String> = signatures(new, new_world).into_iter().collect();
+    for (item, old_signature) in &old_signatures {
+        match new_signatures.get(item) {
+            None => changes.push(breaking(item, "removed")),
+            Some(new_signature) if new_signature != old_signature => changes.push(breaking(
+                item,
+                format!("signature changed from `{old_signature}` to `{new_signature}`"),
+            )),
+            Some(_) => {}
+        }
+    }
+    for item in new_signatures.keys() {
+        if !old_signatures.iter().any(|(old_item, _)| old_item == item) {
+            // A new import is one more function the foreign side must
+            // implement
+            match item.starts_with("import ") {
+                true => changes.push(breaking(item, "added, and must be implemented")),
+                false => changes.push(compatible(item, "added")),
+            }
+        }
+    }
+}
+
+// ---- Types ----
+
+/// The named types of a world's interfaces and of the world itself, by
+/// `{interface}.{type}` (or the type name alone, for world types).
+fn named_types(resolve: &Resolve, world_id: WorldId) -> Vec<(String, TypeId)> {
+    let world = &resolve.worlds[world_id];
+    let mut types = Vec::new();
+    for item in world.imports.values().chain(world.exports.values()) {
+        let wit_parser::WorldItem::Interface { id, .. } = item else {
+            continue;
+        };
+        let iface = &resolve.interfaces[*id];
+        let iface_name = iface.name.as_deref().unwrap_or("anonymous");
+        for (name, type_id) in &iface.types {
+            let key = format!("{iface_name}.{name}");
+            if !types.iter().any(|(seen, _)| *seen == key) {
+                types.push((key, *type_id));
+            }
+        }
+    }
+    for (type_id, typedef) in resolve.types.iter() {
+        if let (TypeOwner::World(owner), Some(name)) = (typedef.owner, &typedef.name) {
+            if owner == world_id {
+                types.push((name.clone(), type_id));
+            }
+        }
+    }
+    types
+}
+
+fn compare_types(
+    changes: &mut Vec<Change>,
+    old: &Resolve,
+    old_world: WorldId,
+    new: &Resolve,
+    new_world: WorldId,
+) {
+    let old_types = named_types(old, old_world);
+    let new_types: HashMap<String, TypeId> = named_types(new, new_world).into_iter().collect();
+    for (item, old_id) in &old_types {
+        match new_types.get(item) {
+            None => changes.push(breaking(item, "removed")),
+            Some(new_id) => compare_type(changes, item, old, *old_id, new, *new_id),
+        }
+    }
+    for item in new_types.keys() {
+        if !old_types.iter().any(|(old_item, _)| old_item == item) {
+            changes.push(compatible(item, "added"));
+        }
+    }
+}
+
+/// Compare the definitions of the type `item`.
+fn compare_type(
+    changes: &mut Vec<Change>,
+    item: &str,
+    old: &Resolve,
+    old_id: TypeId,
+    new: &Resolve,
+    new_id: TypeId,
+) {
+    let (old_kind, new_kind) = (&old.types[old_id].kind, &new.types[new_id].kind);
+    match (old_kind, new_kind) {
+        (TypeDefKind::Record(old_record), TypeDefKind::Record(new_record)) => {
+            let fields = |resolve: &Resolve, record: &wit_parser::Record| {
+                record
+                    .fields
+                    .iter()
+                    .map(|field| (field.name.clone(), wit_type(resolve, &field.ty)))
+                    .collect::<Vec<_>>()
+            };
+            // Any new field changes the layout of the C struct
+            let (old_fields, new_fields) = (fields(old, old_record), fields(new, new_record));
+            compare_members(changes, item, "field", &old_fields, &new_fields, false);
+        }
+        (TypeDefKind::Variant(old_variant), TypeDefKind::Variant(new_variant)) => {
+            let cases = |resolve: &Resolve, variant: &wit_parser::Variant| {
+                variant
+                    .cases
+                    .iter()
+                    .map(|case| {
+                        let payload = case.ty.as_ref().map(|ty| wit_type(resolve, ty));
+                        (case.name.clone(), payload.unwrap_or_default())
+                    })
+                    .collect::<Vec<_>>()
+            };
+            let (old_cases, new_cases) = (cases(old, old_variant), cases(new, new_variant));
+            compare_members(changes, item, "case", &old_cases, &new_cases, true);
+        }
+        (TypeDefKind::Enum(old_enum), TypeDefKind::Enum(new_enum)) => {
+            let cases = |e: &wit_parser::Enum| {
+                e.cases
+                    .iter()
+                    .map(|case| (case.name.clone(), String::new()))
+                    .collect::<Vec<_>>()
+            };
+            compare_members(
+                changes,
+                item,
+                "case",
+                &cases(old_enum),
+                &cases(new_enum),
+                true,
+            );
+        }
+        (TypeDefKind::Flags(old_flags), TypeDefKind::Flags(new_flags)) => {
+            let names = |flags: &wit_parser::Flags| {
+                flags
+                    .flags
+                    .iter()
+                    .map(|flag| (flag.name.clone(), String::new()))
+                    .collect::<Vec<_>>()
+            };
+            compare_members(
+                changes,
+                item,
+                "flag",
+                &names(old_flags),
+                &names(new_flags),
+                true,
+            );
+            let (old_bits, new_bits) = (
+                flags_bits(old_flags.flags.len()),
+                flags_bits(new_flags.flags.len()),
+            );
+            if old_bits != new_bits {
+                changes.push(breaking(
+                    item,
+                    format!("now held in {new_bits} bits instead of {old_bits}"),
+                ));
+            }
+        }
+        (TypeDefKind::Resource, TypeDefKind::Resource) => {
+            // Resources are compared through their functions
+        }
+        _ => {
+            let (old_definition, new_definition) =
+                (wit_kind(old, old_kind), wit_kind(new, new_kind));
+            if old_definition != new_definition {
+                changes.push(breaking(
+                    item,
+                    format!("changed from `{old_definition}` to `{new_definition}`"),
+                ));
+            }
+        }
+    }
+}
+
+/// Compare the members (fields, cases or flags) of a type, each given by
+/// name and payload type, whose positions the ABI depends on. Removing,
+/// changing or reordering members is breaking. With `appendable`, members
+/// added after the existing ones are compatible, as the others keep their
+/// positions; otherwise any addition is breaking.
+fn compare_members(
+    changes: &mut Vec<Change>,
+    item: &str,
+    member: &str,
+    old: &[(String, String)],
+    new: &[(String, String)],
+    appendable: bool,
+) {
+    let find = |members: &[(String, String)], name: &str| {
+        members.iter().position(|(member, _)| member == name)
+    };
+    for (name, payload) in old {
+        match find(new, name) {
+            None => changes.push(breaking(item, format!("{member} `{name}` removed"))),
+            Some(position) if new[position].1 != *payload => changes.push(breaking(
+                item,
+                format!(
+                    "{member} `{name}` changed from `{payload}` to `{}`",
+                    new[position].1
+                ),
+            )),
+            Some(_) => {}
+        }
+    }
+
+    // The members both versions have, in the order of each
+    let kept_old: Vec<&str> = old
+        .iter()
+        .map(|(name, _)| name.as_str())
+        .filter(|name| find(new, name).is_some())
+        .collect();
+    let kept_new: Vec<&str> = new
+        .iter()
+        .map(|(name, _)| name.as_str())
+        .filter(|name| find(old, name).is_some())
+        .collect();
+    if kept_old != kept_new {
+        changes.push(breaking(item, format!("{member}s reordered")));
+    }
+
+    let last_kept = new.iter().rposition(|(name, _)| find(old, name).is_some());
+    for (position, (name, _)) in new.iter().enumerate() {
+        if find(old, name).is_some() {
+            continue;
+        }
+        let appended = last_kept.is_none_or(|last_kept| position > last_kept);
+        match (appendable, appended) {
+            (true, true) => changes.push(compatible(item, format!("{member} `{name}` added"))),
+            (true, false) => changes.push(breaking(
+                item,
+                format!("{member} `{name}` added before existing {member}s, moving them"),
+            )),
+            (false, _) => changes.push(breaking(
+                item,
+                format!("{member} `{name}` added, changing the layout"),
+            )),
+        }
+    }
+}
+
+/// The bits of the integer holding flags with `count` flags, as the C
+/// header declares it.
+fn flags_bits(count: usize) -> usize {
+    match count {
+        0..=8 => 8,
+        9..=16 => 16,
+        17..=32 => 32,
+        _ => 64,
+    }
+}
+
+/// `ty` in WIT syntax, with named types by name.
+fn wit_type(resolve: &Resolve, ty: &Type) -> String {
+    match ty {
+        Type::Bool => "bool".to_string(),
+        Type::U8 => "u8".to_string(),
+        Type::U16 => "u16".to_string(),
+        Type::U32 => "u32".to_string(),
+        Type::U64 => "u64".to_string(),
+        Type::S8 => "s8".to_string(),
+        Type::S16 => "s16".to_string(),
+        Type::S32 => "s32".to_string(),
+        Type::S64 => "s64".to_string(),
+        Type::F32 => "f32".to_string(),
+        Type::F64 => "f64".to_string(),
+        Type::Char => "char".to_string(),
+        Type::String => "string".to_string(),
+        Type::ErrorContext => "error-context".to_string(),
+        Type::Id(id) => {
+            let typedef = &resolve.types[*id];
+            match &typedef.name {
+                Some(name) => name.clone(),
+                None => wit_kind(resolve, &typedef.kind),
+            }
+        }
+    }
+}
+
+/// The definition of a type of the given kind in WIT syntax, for the kinds
+/// written inline.
+fn wit_kind(resolve: &Resolve, kind: &TypeDefKind) -> String {
+    let describe = |ty: &Type| wit_type(resolve, ty);
+    let describe_opt = |ty: &Option<Type>| ty.as_ref().map_or("_".to_string(), describe);
+    match kind {
+        TypeDefKind::List(inner) => format!("list<{}>", describe(inner)),
+        TypeDefKind::FixedLengthList(inner, len) => format!("list<{}, {len}>", describe(inner)),
+        TypeDefKind::Option(inner) => format!("option<{}>", describe(inner)),
+        TypeDefKind::Result(r) => {
+            format!("result<{}, {}>", describe_opt(&r.ok), describe_opt(&r.err))
+        }
+        TypeDefKind::Tuple(tuple) => {
+            let types: Vec<String> = tuple.types.iter().map(describe).collect();
+            format!("tuple<{}>", types.join(", "))
+        }
+        TypeDefKind::Handle(Handle::Own(resource_id)) => {
+            format!("own<{}>", describe(&Type::Id(*resource_id)))
+        }
+        TypeDefKind::Handle(Handle::Borrow(resource_id)) => {
+            format!("borrow<{}>", describe(&Type::Id(*resource_id)))
+        }
+        TypeDefKind::Stream(item) => format!("stream<{}>", describe_opt(item)),
+        TypeDefKind::Future(value) => format!("future<{}>", describe_opt(value)),
+        TypeDefKind::Type(aliased) => describe(aliased),
+        TypeDefKind::Record(_) => "record".to_string(),
+        TypeDefKind::Variant(_) => "variant".to_string(),
+        TypeDefKind::Enum(_) => "enum".to_string(),
+        TypeDefKind::Flags(_) => "flags".to_string(),
+        TypeDefKind::Resource => "resource".to_string(),
+        _ => "unknown".to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn world(source: &str) -> (Resolve, WorldId) {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("shop.wit", source)
+            .expect("failed to parse shop WIT");
+        let world_id = resolve.packages[pkg_id].worlds["shop"];
+        (resolve, world_id)
+    }
+
+    #[test]
+    fn test_compare_worlds() {
+        let (old, old_world) = world(
+            r#"
+                package test:shop;
+
+                interface orders {
+                    enum status { pending, paid, shipped }
+                    flags options { gift, express }
+                    record order { id: u64, state: status }
+
+                    place: func(id: u64) -> order;
+                    cancel: func(id: u64);
+                    lookup: func(id: u64) -> option<order>;
+                }
+
+                world shop {
+                    export orders;
+                }
+            "#,
+        );
+        let (new, new_world) = world(
+            r#"
+                package test:shop;
+
+                interface orders {
+                    enum status { pending, shipped, paid, refunded }
+                    flags options { gift, express, insured }
+                    record order { id: u64, state: status, note: string }
+
+                    place: func(id: u64) -> order;
+                    lookup: func(id: string) -> option<order>;
+                    history: func() -> list<order>;
+                }
+
+                world shop {
+                    export orders;
+                }
+            "#,
+        );
+
+        let changes: Vec<(Severity, String)> = compare_worlds(&old, old_world, &new, new_world)
+            .into_iter()
+            .map(|change| (change.severity, change.to_string()))
+            .collect();
+        let expected = [
+            (Severity::Breaking, "export orders.cancel: removed"),
+            (
+                Severity::Breaking,
+                "export orders.lookup: signature changed from `func(id: u64) -> option<order>` \
+                 to `func(id: string) -> option<order>`",
+            ),
+            (
+                Severity::Breaking,
+                "orders.order: field `note` added, changing the layout",
+            ),
+            (Severity::Breaking, "orders.status: cases reordered"),
+            (Severity::Compatible, "export orders.history: added"),
+            (Severity::Compatible, "orders.options: flag `insured` added"),
+            (Severity::Compatible, "orders.status: case `refunded` added"),
+        ];
+        assert_eq!(
+            changes,
+            expected
+                .iter()
+                .map(|(severity, change)| (*severity, change.to_string()))
+                .collect::<Vec<_>>()
+        );
+        assert!(
+            compare_worlds(&old, old_world, &old, old_world).is_empty(),
+            "a world should not differ from itself"
+        );
+    }
+}
@@ -0,0 +1,201 @@
+//! Composition of the worlds of several WIT packages or components into
+//! one.
+//!
+//! Components whose worlds import what the others export can be linked
+//! into one, as `wasm-tools compose` does. [`compose_worlds`] gives the
+//! world such a composition has: every export of every world, and only
+//! the imports none of them exports. Bindings generated for it are one API
+//! over the composition, implemented by one library.
+
+use std::path::PathBuf;
+
+use snafu::prelude::*;
+use wit_parser::{PackageId, Resolve, WorldId, WorldItem};
+
+use crate::{ComposePackageSnafu, ComposeSnafu, Error, MergePackageSnafu};
+
+/// The name of the composed world, defined in the package of the first.
+pub const WORLD_NAME: &str = "composition";
+
+/// The namespace and name of the package wit-parser decodes a component's
+/// WIT into.
+const COMPONENT_PACKAGE: (&str, &str) = ("root", "component");
+
+/// Merge the WIT packages or compiled components at `paths` into `resolve`
+/// and compose their main worlds with `world_id`, returning the composed
+/// world.
+///
+/// Packages the inputs share (a dependency of one that is the main package
+/// of another, say) are merged, so that an interface one world imports and
+/// another exports is the same interface. The composed world exports what
+/// any of the worlds does, and imports what they import but none exports.
+///
+/// # Errors
+///
+/// Returns an error if an input cannot be loaded, if its packages conflict
+/// with those already resolved, if two worlds export the same item, or if
+/// the package of `world_id` already defines a world named [`WORLD_NAME`].
+pub fn compose_worlds(
+    resolve: &mut Resolve,
+    world_id: WorldId,
+    paths: &[PathBuf],
+) -> Result<WorldId, Error> {
+    let mut worlds = vec![world_id];
+    for path in paths {
+        let (mut other, pkg_id) = crate::load_wit_package(path)?;
+        let other_world = crate::select_world(&other, pkg_id, None)?;
+        rename_component_package(resolve, &mut other, pkg_id);
+        let package = other.packages[pkg_id].name.clone();
+        let world_name = other.worlds[other_world].name.clone();
+        resolve
+            .merge(other)
+            .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
+            .context(MergePackageSnafu { path })?;
+        let pkg_id = resolve.package_names[&package];
+        let world = resolve.packages[pkg_id].worlds[&world_name];
+        // The same world given twice is composed once
+        if !worlds.contains(&world) {
+            worlds.push(world);
+        }
+    }
+
+    let mut exports = resolve.worlds[world_id].exports.clone();
+    exports.clear();
+    for id in &worlds {
+        for (key, item) in &resolve.worlds[*id].exports {
+            ensure!(
+                !exports.contains_key(key),
+                ComposeSnafu {
+                    reason: format!(
+                        "`{}` is exported by world `{}` and an earlier one",
+                        resolve.name_world_key(key),
+                        resolve.worlds[*id].name
+                    ),
+                }
+            );
+            exports.insert(key.clone(), item.clone());
+        }
+    }
+    let mut imports = resolve.worlds[world_id].imports.clone();
+    imports.clear();
+    for id in &worlds {
+        for (key, item) in &resolve.worlds[*id].imports {
+            // An import another world exports is linked to that export;
+            // types are imported whoever defines them
+            if exports.contains_key(key) && !matches!(item, WorldItem::Type(_)) {
+                continue;
+            }
+            if !imports.contains_key(key) {
+                imports.insert(key.clone(), item.clone());
+            }
+        }
+    }
+
+    let names: Vec<String> = worlds
+        .iter()
+        .map(|id| format!("`{}`", resolve.worlds[*id].name))
+        .collect();
+    let mut world = resolve.worlds[world_id].clone();
+    world.name = WORLD_NAME.to_string();
+    world.docs.contents = Some(format!("The composition of worlds {}.", names.join(", ")));
+    world.imports = imports;
+    world.exports = exports;
+    let pkg_id = world.package.context(ComposePackageSnafu {
+        world: &resolve.worlds[world_id].name,
+    })?;
+    ensure!(
+        !resolve.packages[pkg_id].worlds.contains_key(WORLD_NAME),
+        ComposeSnafu {
+            reason: format!("the package already defines a world named `{WORLD_NAME}`"),
+        }
+    );
+    let composed = resolve.worlds.alloc(world);
+    resolve.packages[pkg_id]
+        .worlds
+        .insert(WORLD_NAME.to_string(), composed);
+    Ok(composed)
+}
+
+/// Rename the package of `other`'s component, if `pkg_id` is one, to a
+/// name `resolve` does not have yet. Every component decodes into the same
+/// package, and distinct components must not be merged as one.
+fn rename_component_package(resolve: &Resolve, other: &mut Resolve, pkg_id: PackageId) {
+    let name = &other.packages[pkg_id].name;
+    if (name.namespace.as_str(), name.name.as_str()) != COMPONENT_PACKAGE {
+        return;
+    }
+    let old = name.clone();
+    let mut new = old.clone();
+    for n in 1.. {
+        new.name = format!("{}{n}", COMPONENT_PACKAGE.1);
+        if !resolve.package_names.contains_key(&new) {
+            break;
+        }
+    }
+    other.package_names.shift_remove(&old);
+    other.package_names.insert(new.clone(), pkg_id);
+    other.packages[pkg_id].name = new;
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_compose_worlds() {
+        let root = std::env::temp_dir().join(format!("witffi-compose-{}", std::process::id()));
+        let store = "package test:store;\n\ninterface kv {\n    get: func(key: string) -> \
+                     string;\n}\n\nworld store {\n    export kv;\n}\n";
+        for dir in ["store", "cache/deps", "mirror/deps"] {
+            std::fs::create_dir_all(root.join(dir)).expect("failed to create WIT directory");
+        }
+        let write = |path: &str, text: &str| {
+            std::fs::write(root.join(path), text).expect("failed to write WIT");
+        };
+        write("store/store.wit", store);
+        write("cache/deps/store.wit", store);
+        write(
+            "cache/cache.wit",
+            "package test:cache;\n\ninterface warm {\n    warm: func(keys: list<string>) -> \
+             u32;\n}\n\nworld cache {\n    import test:store/kv;\n    export warm;\n}\n",
+        );
+        write("mirror/deps/store.wit", store);
+        write(
+            "mirror/mirror.wit",
+            "package test:mirror;\n\nworld mirror {\n    export test:store/kv;\n}\n",
+        );
+
+        let (mut resolve, pkg_id) =
+            crate::load_wit_package(&root.join("store/store.wit")).expect("failed to load store");
+        let store_world = crate::select_world(&resolve, pkg_id, None).expect("store has a world");
+        let composed = compose_worlds(&mut resolve, store_world, &[root.join("cache/cache.wit")])
+            .expect("the worlds should compose");
+
+        let world = &resolve.worlds[composed];
+        assert_eq!(world.name, WORLD_NAME);
+        let exports: Vec<String> = world
+            .exports
+            .keys()
+            .map(|key| resolve.name_world_key(key))
+            .collect();
+        assert_eq!(exports, ["test:store/kv", "test:cache/warm"]);
+        assert!(
+            world.imports.is_empty(),
+            "the import of kv should be linked to its export"
+        );
+        assert_eq!(
+            resolve.packages[pkg_id].worlds.get(WORLD_NAME),
+            Some(&composed)
+        );
+
+        let err = compose_worlds(&mut resolve, store_world, &[root.join("mirror/mirror.wit")])
+            .expect_err("kv should be exported twice");
+        assert_eq!(
+            err.to_string(),
+            "cannot compose the worlds: `test:store/kv` is exported by world `mirror` and an \
+             earlier one"
+        );
+
+        std::fs::remove_dir_all(&root).ok();
+    }
+}
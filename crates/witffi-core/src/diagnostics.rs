@@ -0,0 +1,310 @@
+//! Diagnostics for invalid WIT: where the problem is, and what was likely
+//! meant.
+//!
+//! wit-parser reports the position of a syntax or resolution error inside
+//! the message of one of the errors it chains, behind layers of context
+//! naming the package being parsed. [`diagnose`] digs the message and its
+//! `file:line:column` out of the chain and, when a name does not resolve
+//! (e.g. a typo in the type of a record field), suggests the closest name
+//! the WIT defines or builds in.
+
+use std::path::{Path, PathBuf};
+
+/// The types WIT builds in, which a misspelt type may have meant.
+const BUILTIN_TYPES: &[&str] = &[
+    "bool",
+    "s8",
+    "s16",
+    "s32",
+    "s64",
+    "u8",
+    "u16",
+    "u32",
+    "u64",
+    "f32",
+    "f64",
+    "char",
+    "string",
+    "list",
+    "option",
+    "result",
+    "tuple",
+    "borrow",
+    "own",
+    "future",
+    "stream",
+    "error-context",
+];
+
+/// The keywords introducing a named WIT item.
+const DEFINITION_KEYWORDS: &[&str] = &[
+    "record",
+    "variant",
+    "enum",
+    "flags",
+    "resource",
+    "type",
+    "interface",
+    "world",
+];
+
+/// An error in WIT source.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Diagnostic {
+    /// What is wrong (e.g. "type `strng` does not exist").
+    pub message: String,
+
+    /// Where it is wrong, when wit-parser says.
+    pub location: Option<Location>,
+
+    /// The offending source line with a marker under the error, as
+    /// wit-parser renders it. Empty when there is none.
+    pub snippet: String,
+
+    /// The name that was probably meant, for a name that does not resolve.
+    pub suggestion: Option<String>,
+}
+
+/// A position in a WIT file.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Location {
+    /// The WIT file.
+    pub path: PathBuf,
+
+    /// The 1-based line.
+    pub line: usize,
+
+    /// The 1-based column.
+    pub column: usize,
+}
+
+impl std::fmt::Display for Diagnostic {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        if let Some(location) = &self.location {
+            write!(
+                f,
+                "{}:{}:{}: ",
+                location.path.display(),
+                location.line,
+                location.column
+            )?;
+        }
+        write!(f, "{}", self.message)?;
+        if !self.snippet.is_empty() {
+            write!(f, "\n{}", self.snippet)?;
+        }
+        if let Some(suggestion) = &self.suggestion {
+            write!(f, "\nhelp: did you mean `{suggestion}`?")?;
+        }
+        Ok(())
+    }
+}
+
+/// The diagnostic for `error`, raised loading the WIT at `wit` (a file or a
+/// package directory), or None if no error of its chain gives a position.
+///
+/// Suggestions are drawn from the names defined by the WIT files of `wit`
+/// and its `deps/`, and from WIT's built-in types.
+pub fn diagnose(error: &(dyn std::error::Error + 'static), wit: &Path) -> Option<Diagnostic> {
+    let mut source = Some(error);
+    let mut diagnostic = None;
+    while let Some(error) = source {
+        diagnostic = diagnostic.or_else(|| parse_message(&error.to_string()));
+        source = error.source();
+    }
+    let mut diagnostic = diagnostic?;
+
+    if let Some(name) = unresolved_name(&diagnostic.message) {
+        let mut sources: Vec<String> = wit_files(wit)
+            .iter()
+            .filter_map(|path| std::fs::read_to_string(path).ok())
+            .collect();
+        // The failing file may be a dependency outside of `wit`
+        if let Some(location) = &diagnostic.location {
+            sources.extend(std::fs::read_to_string(&location.path).ok());
+        }
+        let names = sources.iter().flat_map(|source| defined_names(source));
+        let builtins = BUILTIN_TYPES.iter().map(|name| name.to_string());
+        diagnostic.suggestion = closest(name, builtins.chain(names));
+    }
+    Some(diagnostic)
+}
+
+/// The diagnostic in a wit-parser error message, which gives the position
+/// on a `--> file:line:column` line below the message, followed by the
+/// source line.
+fn parse_message(text: &str) -> Option<Diagnostic> {
+    let mut lines = text.lines();
+    let message = lines.next()?.trim().to_string();
+    let location = lines.next()?.trim().strip_prefix("-->")?.trim();
+    let mut parts = location.rsplitn(3, ':');
+    let column = parts.next()?.parse().ok()?;
+    let line = parts.next()?.parse().ok()?;
+    let path = PathBuf::from(parts.next()?);
+    Some(Diagnostic {
+        message,
+        location: Some(Location { path, line, column }),
+        snippet: lines.collect::<Vec<_>>().join("\n"),
+        suggestion: None,
+    })
+}
+
+/// The name a resolution error complains of (e.g. `strng` in "type `strng`
+/// does not exist").
+fn unresolved_name(message: &str) -> Option<&str> {
+    let unresolved = ["does not exist", "is not defined", "not found"]
+        .iter()
+        .any(|phrase| message.contains(phrase));
+    if !unresolved {
+        return None;
+    }
+    let (_, rest) = message.split_once('`')?;
+    let (name, _) = rest.split_once('`')?;
+    Some(name.trim_start_matches('%'))
+}
+
+/// The names `source` defines: types, interfaces and worlds, and the names
+/// it brings in with `use`.
+fn defined_names(source: &str) -> Vec<String> {
+    let tokens: Vec<&str> = source
+        .lines()
+        .map(|line| line.split("//").next().unwrap_or_default())
+        .flat_map(|line| line.split(|c: char| !(c.is_alphanumeric() || c == '-' || c == '%')))
+        .filter(|token| !token.is_empty())
+        .collect();
+    let mut names: Vec<String> = tokens
+        .windows(2)
+        .filter(|pair| DEFINITION_KEYWORDS.contains(&pair[0]))
+        .map(|pair| pair[1].trim_start_matches('%').to_string())
+        .collect();
+    // `use iface.{a, b as c}` brings `a` and `c` into scope
+    for (_, rest) in source
+        .match_indices(".{")
+        .map(|(i, _)| source.split_at(i + 2))
+    {
+        let Some((list, _)) = rest.split_once('}') else {
+            continue;
+        };
+        names.extend(list.split(',').filter_map(|item| {
+            let name = item.split_whitespace().last()?;
+            Some(name.trim_start_matches('%').to_string())
+        }));
+    }
+    names
+}
+
+/// The `.wit` files of the WIT at `wit`: the file itself or the files of the
+/// directory, and those of the `deps/` beside them.
+pub(crate) fn wit_files(wit: &Path) -> Vec<PathBuf> {
+    // A directory's `deps/` is found walking it
+    let (mut files, mut dirs) = if wit.is_dir() {
+        (Vec::new(), vec![wit.to_path_buf()])
+    } else {
+        let dir = wit.parent().unwrap_or(Path::new("."));
+        (vec![wit.to_path_buf()], vec![dir.join("deps")])
+    };
+    while let Some(dir) = dirs.pop() {
+        let Ok(entries) = std::fs::read_dir(&dir) else {
+            continue;
+        };
+        for path in entries.filter_map(|entry| entry.ok().map(|entry| entry.path())) {
+            if path.is_dir() {
+                dirs.push(path);
+            } else if path.extension().is_some_and(|ext| ext == "wit") {
+                files.push(path);
+            }
+        }
+    }
+    // In a stable order, so that ties between suggestions are too
+    files.sort();
+    files
+}
+
+/// The candidate closest to `name` by edit distance, if it is close enough
+/// to be a likely typo: a third of the name's length, and at least one
+/// edit.
+fn closest<S: AsRef<str>>(name: &str, candidates: impl IntoIterator<Item = S>) -> Option<String> {
+    let max_distance = (name.chars().count() / 3).max(1);
+    candidates
+        .into_iter()
+        .filter(|candidate| candidate.as_ref() != name)
+        .map(|candidate| (edit_distance(name, candidate.as_ref()), candidate))
+        .filter(|(distance, _)| *distance <= max_distance)
+        // The first of the closest, so that built-in types win ties
+        .min_by_key(|(distance, _)| *distance)
+        .map(|(_, candidate)| candidate.as_ref().to_string())
+}
+
+/// The Levenshtein distance between `a` and `b`.
+fn edit_distance(a: &str, b: &str) -> usize {
+    let b: Vec<char> = b.chars().collect();
+    let mut row: Vec<usize> = (0..=b.len()).collect();
+    for (i, ca) in a.chars().enumerate() {
+        let mut diagonal = row[0];
+        row[0] = i + 1;
+        for (j, cb) in b.iter().enumerate() {
+            let substitution = diagonal + usize::from(ca != *cb);
+            diagonal = row[j + 1];
+            row[j + 1] = substitution.min(row[j] + 1).min(diagonal + 1);
+        }
+    }
+    row[b.len()]
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_diagnostics() {
+        let diagnostic = parse_message(
+            "type `strng` does not exist\n     --> wit/shop.wit:5:15\n      |\n    5 |         \
+             name: strng,\n      |               ^----",
+        )
+        .expect("the message gives a position");
+        assert_eq!(diagnostic.message, "type `strng` does not exist");
+        assert_eq!(
+            diagnostic.location,
+            Some(Location {
+                path: PathBuf::from("wit/shop.wit"),
+                line: 5,
+                column: 15,
+            })
+        );
+        assert!(diagnostic.snippet.contains("name: strng,"));
+        assert_eq!(parse_message("failed to parse package: wit"), None);
+
+        assert_eq!(
+            unresolved_name(&diagnostic.message),
+            Some("strng"),
+            "the unresolved name should be found"
+        );
+        assert_eq!(unresolved_name("expected `}`, found `,`"), None);
+
+        let names = defined_names(
+            "interface shop {\n    use types.{amount, sku as item-id};\n    // record ghost {}\n    \
+             record line-item { id: item-id }\n}\n",
+        );
+        assert_eq!(names, ["shop", "line-item", "amount", "item-id"]);
+
+        assert_eq!(edit_distance("strng", "string"), 1);
+        assert_eq!(edit_distance("kitten", "sitting"), 3);
+        assert_eq!(
+            closest("strng", BUILTIN_TYPES.iter().copied()),
+            Some("string".to_string())
+        );
+        assert_eq!(
+            closest("line-itme", names.iter()),
+            Some("line-item".to_string())
+        );
+        assert_eq!(closest("wallet", names.iter()), None);
+
+        let shown = Diagnostic {
+            suggestion: Some("string".to_string()),
+            ..diagnostic
+        }
+        .to_string();
+        assert!(shown.starts_with("wit/shop.wit:5:15: type `strng` does not exist\n"));
+        assert!(shown.ends_with("\nhelp: did you mean `string`?"));
+    }
+}
@@ -0,0 +1,125 @@
+//! The protocol between bindings and a Rust library running in a sidecar
+//! process of its own.
+//!
+//! The sidecar reads requests on its stdin and writes responses on its
+//! stdout, one at a time, each in a frame: a little-endian `u32` length,
+//! then that many bytes. Once started, it first writes a frame holding the
+//! little-endian `u64` [`abi_hash`](crate::abi_hash) of its world, for the
+//! bindings to check against their own.
+//!
+//! A request is the little-endian `u32` [`function_id`] of the function
+//! called, then its arguments. A response is a status byte, then:
+//!
+//! - after [`STATUS_OK`], the result, if the function returns one (for a
+//!   `result<T, string>`, its ok value)
+//! - after [`STATUS_ERR`], the error string of a `result<T, string>`
+//! - after [`STATUS_PANIC`], the message of the panic the call raised
+//!
+//! Values are the plain ones (see [`plain_signature`]). A number is
+//! little-endian at its width, a bool one byte (0 or 1), and a string or
+//! `list<u8>` a little-endian `u32` length then its bytes.
+
+use wit_parser::{Resolve, Type, WorldId};
+
+use crate::{ExportedFunction, ImportSignature, exported_functions, plain_signature};
+
+/// The status of a response to a call that returned.
+pub const STATUS_OK: u8 = 0;
+
+/// The status of a response to a call that returned an error.
+pub const STATUS_ERR: u8 = 1;
+
+/// The status of a response to a call that panicked.
+pub const STATUS_PANIC: u8 = 2;
+
+/// The shape of `ef` in requests and responses, or None if it passes
+/// anything but plain values.
+pub fn ipc_signature(resolve: &Resolve, ef: &ExportedFunction) -> Option<ImportSignature> {
+    plain_signature(resolve, ef)
+}
+
+/// The id requests call `ef` by: its position among the functions
+/// `world_id` exports, which the [`abi_hash`](crate::abi_hash) both sides
+/// compare covers. None if `world_id` does not export `ef`.
+pub fn function_id(resolve: &Resolve, world_id: WorldId, ef: &ExportedFunction) -> Option<u32> {
+    let name = ef.qualified_name(resolve);
+    exported_functions(resolve, world_id)
+        .iter()
+        .position(|other| other.qualified_name(resolve) == name)
+        .map(|id| id as u32)
+}
+
+/// The size of a number or bool of `ty` on the wire.
+pub fn scalar_size(ty: Type) -> usize {
+    match ty {
+        Type::Bool | Type::U8 | Type::S8 => 1,
+        Type::U16 | Type::S16 => 2,
+        Type::U64 | Type::S64 | Type::F64 => 8,
+        _ => 4,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{ImportResult, ImportValue};
+
+    #[test]
+    fn test_ipc_functions() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "hashing.wit",
+                r#"
+package test:hashing;
+
+interface hasher {
+    resource state {
+        update: func(data: list<u8>);
+    }
+
+    hash: func(data: list<u8>, rounds: u32) -> list<u8>;
+    describe: func(name: string) -> result<string, string>;
+}
+
+world hashing {
+    export hasher;
+}
+"#,
+            )
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+        let funcs = exported_functions(&resolve, world_id);
+        let find = |name: &str| {
+            funcs
+                .iter()
+                .find(|ef| ef.function.name == name)
+                .expect("the world exports the function")
+        };
+
+        let hash = find("hash");
+        assert_eq!(
+            funcs[function_id(&resolve, world_id, hash).expect("hash is exported") as usize]
+                .function
+                .name,
+            "hash"
+        );
+        let describe = ipc_signature(&resolve, find("describe")).expect("describe is served");
+        assert_eq!(
+            describe.result,
+            ImportResult::Fallible(Some(ImportValue::String))
+        );
+        assert!(
+            funcs
+                .iter()
+                .filter(|ef| ef.resource().is_some())
+                .all(|ef| ipc_signature(&resolve, ef).is_none()),
+            "resource functions should not be served"
+        );
+
+        assert_eq!(scalar_size(Type::Bool), 1);
+        assert_eq!(scalar_size(Type::S16), 2);
+        assert_eq!(scalar_size(Type::F32), 4);
+        assert_eq!(scalar_size(Type::U64), 8);
+    }
+}
@@ -3,18 +3,40 @@
 //! Core WIT parsing and FFI type mapping for the `witffi` code generator.
 //!
 //! This crate provides:
-//! - WIT file loading and resolution via [`load_wit`]
+//! - WIT file loading and resolution via [`load_wit`], with [`diagnostics`]
+//!   pointing at the file, line and column of invalid WIT, and decoding of
+//!   the WIT embedded in a compiled component
 //! - Name conversion utilities for mapping WIT kebab-case identifiers
 //!   to language-specific naming conventions
 //! - Type analysis helpers for determining FFI characteristics of WIT types
+//! - The [`source_map`] of where each WIT item is defined, for generated
+//!   code to point back to
+//! - The [`canonical`] ABI of functions exported from a WebAssembly build
+//! - The [`compose`]d world of several WIT packages or components linked
+//!   into one
+//! - The [`ipc`] protocol of a library running in a sidecar process
+//! - The gRPC service definition ([`proto`]) of a world
 
+pub mod canonical;
+pub mod compat;
+pub mod compose;
+pub mod diagnostics;
+pub mod ipc;
 pub mod names;
+pub mod proto;
+pub mod source_map;
 
+use std::collections::{HashMap, HashSet};
 use std::path::{Path, PathBuf};
 
+use heck::{ToPascalCase, ToSnakeCase};
 use snafu::prelude::*;
 pub use wit_parser;
-use wit_parser::{Resolve, UnresolvedPackageGroup, WorldId};
+use wit_parser::decoding::DecodedWasm;
+use wit_parser::{
+    FunctionKind, Handle, InterfaceId, PackageId, Resolve, Stability, Type, TypeDefKind, TypeId,
+    TypeOwner, UnresolvedPackageGroup, WorldId,
+};
 
 /// Errors that can occur when loading and resolving WIT definitions.
 #[derive(Debug, Snafu)]
@@ -39,61 +61,401 @@ pub enum Error {
         source: Box<dyn std::error::Error + Send + Sync>,
     },
 
-    /// The WIT package did not contain exactly one world.
+    /// Failed to read a WebAssembly binary.
+    #[snafu(display("failed to read WebAssembly binary: {}", path.display()))]
+    ReadWasm {
+        source: std::io::Error,
+        path: PathBuf,
+    },
+
+    /// A WebAssembly binary is not a component or WIT package, or its WIT
+    /// could not be decoded.
+    #[snafu(display("failed to decode the WIT of WebAssembly binary: {}", path.display()))]
+    DecodeWasm {
+        source: Box<dyn std::error::Error + Send + Sync>,
+        path: PathBuf,
+    },
+
+    /// The world of a decoded component is not placed in a package.
+    #[snafu(display("the world of component {} belongs to no package", path.display()))]
+    ComponentPackage { path: PathBuf },
+
+    /// Failed to merge the packages of a WIT package or component into
+    /// those already resolved.
+    #[snafu(display("failed to merge the WIT of {} with the rest", path.display()))]
+    MergePackage {
+        source: Box<dyn std::error::Error + Send + Sync>,
+        path: PathBuf,
+    },
+
+    /// The worlds of several packages or components cannot be composed.
+    #[snafu(display("cannot compose the worlds: {reason}"))]
+    Compose { reason: String },
+
+    /// A world being composed is not placed in a package.
+    #[snafu(display("cannot compose world `{world}`: it belongs to no package"))]
+    ComposePackage { world: String },
+
+    /// The world of a gRPC service is not placed in a package, which names
+    /// the proto package.
+    #[snafu(display("cannot name the proto package of world `{world}`: it belongs to no package"))]
+    ProtoPackage { world: String },
+
+    /// The WIT is invalid, at a known position.
+    #[snafu(display("{diagnostic}"))]
+    InvalidWit { diagnostic: diagnostics::Diagnostic },
+
+    /// Failed to read a `deps/` directory.
+    #[snafu(display("failed to read WIT dependencies: {}", path.display()))]
+    ReadDeps {
+        source: std::io::Error,
+        path: PathBuf,
+    },
+
+    /// The WIT package did not contain exactly one world, and none was
+    /// selected by name.
     #[snafu(display("expected exactly 1 world in WIT package, found {count}"))]
     WorldCount { count: usize },
+
+    /// The selected world is not defined in the WIT package.
+    #[snafu(display("no world named `{name}` in WIT package (found: {available})"))]
+    UnknownWorld { name: String, available: String },
+
+    /// Two distinct WIT types would be generated under the same name.
+    #[snafu(display(
+        "type `{name}` is defined by both {first} and {second}; generated type names are not \
+         qualified by interface, so rename one of them"
+    ))]
+    TypeNameCollision {
+        name: String,
+        first: String,
+        second: String,
+    },
+
+    /// Two exported functions would be generated under the same name.
+    #[snafu(display(
+        "exported functions {first} and {second} would both be generated as `{name}`"
+    ))]
+    FunctionNameCollision {
+        name: String,
+        first: String,
+        second: String,
+    },
+
+    /// An imported function uses a type that cannot cross the C ABI into a
+    /// foreign implementation.
+    #[snafu(display(
+        "imported function `{function}` {position} is {ty}, but imports only support numbers, \
+         bools, strings, `list<u8>`, resource handles and `result<T, string>` results of those"
+    ))]
+    UnsupportedImport {
+        function: String,
+        position: String,
+        ty: String,
+    },
+
+    /// An imported function passes a resource that no exported function
+    /// uses, so there is no Rust implementation behind its handles.
+    #[snafu(display(
+        "imported function `{function}` passes resource `{resource}`, but only resources that \
+         exported functions also pass can cross into imports"
+    ))]
+    ImportedResource { function: String, resource: String },
+
+    /// An imported function is `async`, which imports do not support.
+    #[snafu(display("imported function `{function}` is async, but imports must be synchronous"))]
+    AsyncImport { function: String },
+
+    /// An async exported function takes a borrowed handle, which would have
+    /// to outlive the call that lent it.
+    #[snafu(display(
+        "async function `{function}` borrows a resource in {position}, but async calls copy \\
+         their arguments and cannot hold borrows; take an owned handle instead"
+    ))]
+    AsyncBorrow { function: String, position: String },
+
+    /// A function uses `stream<T>` somewhere other than as its whole result
+    /// or a whole `stream<u8>` parameter.
+    #[snafu(display(
+        "function `{function}` {reason}, but streams are only supported as the whole result of \
+         a synchronous function, or as a whole `stream<u8>` parameter of one returning neither \
+         a stream nor a future"
+    ))]
+    UnsupportedStream { function: String, reason: String },
+
+    /// A function returning a stream takes a borrowed handle, which would
+    /// have to outlive the call that lent it.
+    #[snafu(display(
+        "function `{function}` returns a stream but borrows a resource in {position}; streams \
+         copy their arguments and cannot hold borrows, so take an owned handle instead"
+    ))]
+    StreamBorrow { function: String, position: String },
+
+    /// A function uses `future<T>` somewhere other than as its whole result.
+    #[snafu(display(
+        "function `{function}` {reason}, but futures are only supported as the whole result of \
+         a synchronous function"
+    ))]
+    UnsupportedFuture { function: String, reason: String },
+
+    /// A function returning a future takes a borrowed handle, which would
+    /// have to outlive the call that lent it.
+    #[snafu(display(
+        "function `{function}` returns a future but borrows a resource in {position}; futures \
+         copy their arguments and cannot hold borrows, so take an owned handle instead"
+    ))]
+    FutureBorrow { function: String, position: String },
+
+    /// A batched form was requested for a function that cannot have one.
+    #[snafu(display("cannot batch `{function}`: {reason}"))]
+    Batch { function: String, reason: String },
+
+    /// A function was to be skipped that the world does not export.
+    #[snafu(display("cannot skip `{function}`: the world exports no such function"))]
+    Skip { function: String },
+
+    /// An interface was to be included or excluded that the world does not
+    /// export.
+    #[snafu(display("cannot filter `{interface}`: the world exports no such interface"))]
+    Filter { interface: String },
+
+    /// A record was marked for column-wise transfer that cannot be.
+    #[snafu(display("cannot transfer `{record}` column by column: {reason}"))]
+    Columnar { record: String, reason: String },
 }
 
 /// Load and resolve WIT definitions from a directory or single file.
 ///
 /// Returns the [`Resolve`] containing all resolved types and the
-/// [`WorldId`] of the (single) world defined in the package.
+/// [`WorldId`] of the (single) world defined in the main package.
 ///
 /// # Errors
 ///
 /// Returns an error if:
 /// - The path does not exist or is not readable
 /// - The WIT files contain syntax errors
-/// - There is not exactly one world defined
+/// - There is not exactly one world defined in the main package
 pub fn load_wit(path: &Path) -> Result<(Resolve, WorldId), Error> {
-    let mut resolve = Resolve::default();
+    let (resolve, pkg_id) = load_wit_package(path)?;
+    let world_id = select_world(&resolve, pkg_id, None)?;
+    Ok((resolve, world_id))
+}
 
-    if path.is_dir() {
-        resolve
+/// Load and resolve WIT definitions from a directory or single file,
+/// returning the main package rather than a single world.
+///
+/// Packages in a `deps/` directory (inside the directory, or next to the
+/// file) are resolved first, so the main package can `use` their types.
+///
+/// Items gated by `@unstable` features are kept, so that generators can
+/// gate them in turn (see [`ExportedFunction::feature`]).
+///
+/// A file starting with the WebAssembly magic number is a compiled
+/// component, or a WIT package encoded as WebAssembly, and the WIT embedded
+/// in it is decoded instead. A component's package holds one world, with
+/// the component's imports and exports.
+///
+/// # Errors
+///
+/// Returns an error if the path does not exist or is not readable, the
+/// WIT files contain syntax errors, or a WebAssembly binary holds no WIT.
+/// Errors whose position wit-parser gives are reported as
+/// [`Error::InvalidWit`], with the file, line and column, and the name
+/// probably meant when one does not resolve.
+pub fn load_wit_package(path: &Path) -> Result<(Resolve, PackageId), Error> {
+    resolve_wit_package(path).map_err(|error| with_diagnostic(error, path))
+}
+
+/// Load and resolve the WIT at `path`, as [`load_wit_package`] does, with
+/// wit-parser's errors as they are.
+fn resolve_wit_package(path: &Path) -> Result<(Resolve, PackageId), Error> {
+    let mut resolve = Resolve {
+        all_features: true,
+        ..Resolve::default()
+    };
+
+    if is_wasm(path) {
+        return decode_wasm(path);
+    }
+    let pkg_id = if path.is_dir() {
+        // `push_dir` resolves the directory's own `deps/` as well
+        let (pkg_id, _) = resolve
             .push_dir(path)
             .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
             .context(LoadDirSnafu { path })?;
-        let worlds: Vec<WorldId> = resolve.worlds.iter().map(|(id, _)| id).collect();
-        ensure!(
-            worlds.len() == 1,
-            WorldCountSnafu {
-                count: worlds.len()
-            }
-        );
-        return Ok((resolve, worlds[0]));
-    }
+        pkg_id
+    } else {
+        let deps = path.parent().map(|dir| dir.join("deps"));
+        if let Some(deps) = deps.filter(|deps| deps.is_dir()) {
+            push_deps_dir(&mut resolve, &deps)?;
+        }
 
-    let group = UnresolvedPackageGroup::parse_file(path)
-        .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
-        .context(ParseFileSnafu { path })?;
+        let group = UnresolvedPackageGroup::parse_file(path)
+            .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
+            .context(ParseFileSnafu { path })?;
+
+        resolve
+            .push_group(group)
+            .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
+            .context(ResolvePackageSnafu)?
+    };
+
+    Ok((resolve, pkg_id))
+}
+
+/// Whether `path` is a file starting with the WebAssembly magic number.
+fn is_wasm(path: &Path) -> bool {
+    use std::io::Read;
+
+    let mut magic = [0; 4];
+    std::fs::File::open(path)
+        .and_then(|mut file| file.read_exact(&mut magic))
+        .is_ok_and(|()| magic == *b"\0asm")
+}
 
-    let pkg_id = resolve
-        .push_group(group)
+/// Decode the WIT embedded in the component or WIT package at `path`.
+fn decode_wasm(path: &Path) -> Result<(Resolve, PackageId), Error> {
+    let bytes = std::fs::read(path).context(ReadWasmSnafu { path })?;
+    let decoded = wit_parser::decoding::decode(&bytes)
         .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
-        .context(ResolvePackageSnafu)?;
+        .context(DecodeWasmSnafu { path })?;
+    match decoded {
+        DecodedWasm::WitPackage(resolve, pkg_id) => Ok((resolve, pkg_id)),
+        DecodedWasm::Component(resolve, world_id) => {
+            let pkg_id = resolve.worlds[world_id]
+                .package
+                .context(ComponentPackageSnafu { path })?;
+            Ok((resolve, pkg_id))
+        }
+    }
+}
+
+/// `error`, raised loading the WIT at `path`, as an [`Error::InvalidWit`]
+/// if wit-parser gives the position of the problem.
+fn with_diagnostic(error: Error, path: &Path) -> Error {
+    let (Error::LoadDir { source, .. }
+    | Error::ParseFile { source, .. }
+    | Error::ResolvePackage { source }) = &error
+    else {
+        return error;
+    };
+    match diagnostics::diagnose(source.as_ref(), path) {
+        Some(diagnostic) => InvalidWitSnafu { diagnostic }.build(),
+        None => error,
+    }
+}
 
-    let pkg_data = &resolve.packages[pkg_id];
-    let worlds: Vec<WorldId> = pkg_data.worlds.values().copied().collect();
+/// Select a world of a package by name, or its main world when `name` is
+/// `None`.
+///
+/// The main world is the package's only world or, when worlds are composed
+/// with `include`, the one world holding every item of the others.
+///
+/// # Errors
+///
+/// Returns an error if no world has the given name, or if no name is given
+/// and the package has no single main world.
+pub fn select_world(
+    resolve: &Resolve,
+    pkg_id: PackageId,
+    name: Option<&str>,
+) -> Result<WorldId, Error> {
+    let worlds = &resolve.packages[pkg_id].worlds;
+    if let Some(name) = name {
+        return worlds.get(name).copied().with_context(|| {
+            let mut available: Vec<&str> = worlds.keys().map(String::as_str).collect();
+            available.sort_unstable();
+            UnknownWorldSnafu {
+                name,
+                available: available.join(", "),
+            }
+        });
+    }
+    let worlds: Vec<WorldId> = worlds.values().copied().collect();
+    let main: Vec<WorldId> = worlds
+        .iter()
+        .copied()
+        .filter(|id| {
+            worlds
+                .iter()
+                .all(|other| world_covers(resolve, *id, *other))
+        })
+        .collect();
     ensure!(
-        worlds.len() == 1,
+        main.len() == 1,
         WorldCountSnafu {
             count: worlds.len()
         }
     );
-    Ok((resolve, worlds[0]))
+    Ok(main[0])
+}
+
+/// Whether `world` imports and exports everything `other` does, as a world
+/// does after its `include`s are expanded.
+fn world_covers(resolve: &Resolve, world: WorldId, other: WorldId) -> bool {
+    let (world, other) = (&resolve.worlds[world], &resolve.worlds[other]);
+    other
+        .imports
+        .keys()
+        .all(|key| world.imports.contains_key(key))
+        && other
+            .exports
+            .keys()
+            .all(|key| world.exports.contains_key(key))
+}
+
+/// Resolve every package in a `deps/` directory, each given as a package
+/// directory or a single `.wit` file.
+///
+/// Packages are pushed once the packages they `use` are resolved; a
+/// dependency that never resolves is reported by the first package
+/// needing it.
+fn push_deps_dir(resolve: &mut Resolve, deps: &Path) -> Result<(), Error> {
+    let mut paths = Vec::new();
+    for entry in std::fs::read_dir(deps).context(ReadDepsSnafu { path: deps })? {
+        paths.push(entry.context(ReadDepsSnafu { path: deps })?.path());
+    }
+    paths.sort();
+
+    let mut pending = Vec::new();
+    for path in paths {
+        let group = if path.is_dir() {
+            UnresolvedPackageGroup::parse_dir(&path)
+                .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
+                .context(LoadDirSnafu { path: &path })?
+        } else if path.extension().is_some_and(|ext| ext == "wit") {
+            UnresolvedPackageGroup::parse_file(&path)
+                .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
+                .context(ParseFileSnafu { path: &path })?
+        } else {
+            continue;
+        };
+        pending.push(group);
+    }
+
+    while !pending.is_empty() {
+        let ready = pending
+            .iter()
+            .position(|group| {
+                group
+                    .main
+                    .foreign_deps
+                    .keys()
+                    .all(|name| resolve.package_names.contains_key(name))
+            })
+            .unwrap_or(0);
+        resolve
+            .push_group(pending.remove(ready))
+            .map_err(|e| -> Box<dyn std::error::Error + Send + Sync> { e.into() })
+            .context(ResolvePackageSnafu)?;
+    }
+
+    Ok(())
 }
 
 /// Describes a single exported function from a WIT world, fully qualified.
+///
+/// Imported functions (see [`imported_functions`]) are described the same way.
 #[derive(Debug, Clone)]
 pub struct ExportedFunction {
     /// The interface name this function belongs to (e.g. "parser").
@@ -102,29 +464,840 @@ pub struct ExportedFunction {
     pub function_name: String,
     /// The WIT function definition.
     pub function: wit_parser::Function,
+    /// The `@unstable` feature gating this function, directly or through
+    /// its interface.
+    pub feature: Option<String>,
+    /// The version the function was deprecated in by a `@deprecated` gate,
+    /// directly or through its interface.
+    pub deprecated: Option<String>,
+}
+
+impl ExportedFunction {
+    /// The resource this function is attached to, if it is a constructor,
+    /// method, or static function.
+    pub fn resource(&self) -> Option<TypeId> {
+        match self.function.kind {
+            FunctionKind::Constructor(id)
+            | FunctionKind::Method(id)
+            | FunctionKind::AsyncMethod(id)
+            | FunctionKind::Static(id)
+            | FunctionKind::AsyncStatic(id) => Some(id),
+            FunctionKind::Freestanding | FunctionKind::AsyncFreestanding => None,
+        }
+    }
+
+    /// Whether this function is a resource constructor.
+    pub fn is_constructor(&self) -> bool {
+        matches!(self.function.kind, FunctionKind::Constructor(_))
+    }
+
+    /// Whether this function is a resource method (takes `self` as its first
+    /// parameter).
+    pub fn is_method(&self) -> bool {
+        matches!(
+            self.function.kind,
+            FunctionKind::Method(_) | FunctionKind::AsyncMethod(_)
+        )
+    }
+
+    /// Whether this function is `async`, returning its result through a
+    /// completion callback rather than directly.
+    pub fn is_async(&self) -> bool {
+        matches!(
+            self.function.kind,
+            FunctionKind::AsyncFreestanding
+                | FunctionKind::AsyncMethod(_)
+                | FunctionKind::AsyncStatic(_)
+        )
+    }
+
+    /// The element type of a `stream<T>` result, following aliases.
+    ///
+    /// Such functions return a producer the caller pulls elements from, one
+    /// at a time, until it is exhausted or dropped.
+    pub fn stream_item(&self, resolve: &Resolve) -> Option<Type> {
+        let Some(Type::Id(result_id)) = &self.function.result else {
+            return None;
+        };
+        match &resolve.types[dealias(resolve, *result_id)].kind {
+            TypeDefKind::Stream(item) => *item,
+            _ => None,
+        }
+    }
+
+    /// Whether any parameter is a whole `stream<u8>` (see [`is_byte_stream`]),
+    /// which the implementation reads from while the call runs.
+    pub fn takes_byte_streams(&self, resolve: &Resolve) -> bool {
+        self.function
+            .params
+            .iter()
+            .any(|p| is_byte_stream(resolve, &p.ty))
+    }
+
+    /// The value type of a `future<T>` result, following aliases.
+    ///
+    /// Such functions start the work and return a handle the caller awaits
+    /// or cancels, like an async function returning `T`.
+    pub fn future_value(&self, resolve: &Resolve) -> Option<Type> {
+        let Some(Type::Id(result_id)) = &self.function.result else {
+            return None;
+        };
+        match &resolve.types[dealias(resolve, *result_id)].kind {
+            TypeDefKind::Future(value) => *value,
+            _ => None,
+        }
+    }
+
+    /// For a function returning `future<T>`, the same function returning
+    /// `T`, which is what awaiting the future produces.
+    pub fn awaited(&self, resolve: &Resolve) -> Option<ExportedFunction> {
+        let value = self.future_value(resolve)?;
+        let mut awaited = self.clone();
+        awaited.function.result = Some(value);
+        Some(awaited)
+    }
+
+    /// Whether callers await this function's result: it is async or returns
+    /// a `future<T>`.
+    pub fn is_awaited(&self, resolve: &Resolve) -> bool {
+        self.is_async() || self.future_value(resolve).is_some()
+    }
+
+    /// Whether the implementation takes owned arguments, since the work it
+    /// starts (an async future, a stream or a `future<T>`) outlives the call.
+    pub fn owns_arguments(&self, resolve: &Resolve) -> bool {
+        self.is_awaited(resolve) || self.stream_item(resolve).is_some()
+    }
+
+    /// For a function added by [`add_batch_functions`], the function it
+    /// batches, found among `funcs`.
+    ///
+    /// A function is recognised by shape: it is named like the batched one
+    /// with a `-batch` suffix, takes a list of each of its parameters, and
+    /// returns a list of its results.
+    pub fn batch_of<'a>(
+        &self,
+        resolve: &Resolve,
+        funcs: &'a [ExportedFunction],
+    ) -> Option<&'a ExportedFunction> {
+        let base_name = self.function_name.strip_suffix("-batch")?;
+        let base = funcs.iter().find(|ef| {
+            ef.interface_name == self.interface_name
+                && ef.function_name == base_name
+                && ef.resource().is_none()
+        })?;
+        let list_of = |list: &Type, elem: &Type| match list {
+            Type::Id(id) => resolve.types[*id].kind == TypeDefKind::List(*elem),
+            _ => false,
+        };
+        let params_match = self.function.params.len() == base.function.params.len()
+            && self
+                .function
+                .params
+                .iter()
+                .zip(&base.function.params)
+                .all(|(p, base)| p.name == base.name && list_of(&p.ty, &base.ty));
+        let result_matches = match (&self.function.result, &base.function.result) {
+            (None, None) => true,
+            (Some(list), Some(elem)) => list_of(list, elem),
+            _ => false,
+        };
+        (params_match && result_matches).then_some(base)
+    }
+
+    /// The function name without any `[method]resource.` style prefix.
+    ///
+    /// Constructors are reported as `new`.
+    pub fn item_name(&self) -> &str {
+        if self.is_constructor() {
+            "new"
+        } else {
+            self.function.item_name()
+        }
+    }
+
+    /// The name configuration refers to this function by: `{interface}.{function}`,
+    /// or `{interface}.{resource}.{function}` for resource functions (e.g.
+    /// "types.counter.get"). World-level functions are named without an
+    /// interface.
+    pub fn qualified_name(&self, resolve: &Resolve) -> String {
+        let item = match self.resource() {
+            Some(resource_id) => format!(
+                "{}.{}",
+                resolve.types[resource_id]
+                    .name
+                    .as_deref()
+                    .unwrap_or("anonymous"),
+                self.item_name()
+            ),
+            None => self.function_name.clone(),
+        };
+        if self.interface_name.is_empty() {
+            item
+        } else {
+            format!("{}.{item}", self.interface_name)
+        }
+    }
+
+    /// The error type of a `result<T, E>` return when `E` is a record, variant
+    /// or enum, following aliases.
+    ///
+    /// Such errors are passed back to the caller as a typed payload through a
+    /// trailing `err_out` parameter rather than only as an error message.
+    pub fn typed_error(&self, resolve: &Resolve) -> Option<TypeId> {
+        let Some(Type::Id(result_id)) = &self.function.result else {
+            return None;
+        };
+        let TypeDefKind::Result(result) = &resolve.types[dealias(resolve, *result_id)].kind else {
+            return None;
+        };
+        let Some(Type::Id(err_id)) = &result.err else {
+            return None;
+        };
+        let err_id = dealias(resolve, *err_id);
+        match &resolve.types[err_id].kind {
+            TypeDefKind::Record(_) | TypeDefKind::Variant(_) | TypeDefKind::Enum(_) => Some(err_id),
+            _ => None,
+        }
+    }
+
+    /// The error payload of a `result<T, E>` return that is handed back
+    /// through `err_out`: a [`typed_error`](Self::typed_error), or an
+    /// `error-context`, which keeps its debug message and identity.
+    pub fn error_payload(&self, resolve: &Resolve) -> Option<Type> {
+        if let Some(err_id) = self.typed_error(resolve) {
+            return Some(Type::Id(err_id));
+        }
+        let Some(Type::Id(result_id)) = &self.function.result else {
+            return None;
+        };
+        let TypeDefKind::Result(result) = &resolve.types[dealias(resolve, *result_id)].kind else {
+            return None;
+        };
+        is_error_context(resolve, result.err.as_ref()?).then_some(Type::ErrorContext)
+    }
+
+    /// The `string` or `list<u8>` this function returns, directly or as the
+    /// ok value of a `result<T, E>` without an error payload, when it can
+    /// also be called writing its result into a caller-supplied buffer.
+    ///
+    /// Only synchronous freestanding functions taking no `stream<u8>` have
+    /// such an `_into` variant.
+    pub fn into_result(&self, resolve: &Resolve) -> Option<Type> {
+        if self.resource().is_some()
+            || self.is_async()
+            || self.takes_byte_streams(resolve)
+            || self.error_payload(resolve).is_some()
+        {
+            return None;
+        }
+        let is_bytes = |ty: &Type| match ty {
+            Type::String => true,
+            Type::Id(id) => matches!(
+                resolve.types[dealias(resolve, *id)].kind,
+                TypeDefKind::List(Type::U8) | TypeDefKind::Type(Type::String)
+            ),
+            _ => false,
+        };
+        let result = self.function.result?;
+        if is_bytes(&result) {
+            return Some(result);
+        }
+        let Type::Id(result_id) = result else {
+            return None;
+        };
+        match &resolve.types[dealias(resolve, result_id)].kind {
+            TypeDefKind::Result(r) => r.ok.filter(is_bytes),
+            _ => None,
+        }
+    }
+
+    /// The record of the `list<record>` this function returns, directly or
+    /// as the ok value of a `result<T, E>` without an error payload, when
+    /// `columnar` names it and it is transferred column by column (see
+    /// [`check_columnar`]).
+    ///
+    /// Only synchronous freestanding functions taking no `stream<u8>` return
+    /// columns, through a `_columns` variant.
+    pub fn columns_result(&self, resolve: &Resolve, columnar: &HashSet<String>) -> Option<TypeId> {
+        if self.resource().is_some()
+            || self.is_async()
+            || self.takes_byte_streams(resolve)
+            || self.error_payload(resolve).is_some()
+        {
+            return None;
+        }
+        let columns = |ty: &Type| {
+            let Type::Id(id) = ty else { return None };
+            let TypeDefKind::List(Type::Id(elem)) = resolve.types[dealias(resolve, *id)].kind
+            else {
+                return None;
+            };
+            let record = dealias(resolve, elem);
+            let name = resolve.types[record].name.as_ref()?;
+            (columnar.contains(name) && is_columnar_record(resolve, record)).then_some(record)
+        };
+        let result = self.function.result?;
+        if let Some(record) = columns(&result) {
+            return Some(record);
+        }
+        let Type::Id(result_id) = result else {
+            return None;
+        };
+        match &resolve.types[dealias(resolve, result_id)].kind {
+            TypeDefKind::Result(r) => r.ok.as_ref().and_then(columns),
+            _ => None,
+        }
+    }
+
+    /// Whether an `error-context` appears anywhere in the parameters or
+    /// result, including inside streams and futures.
+    pub fn uses_error_context(&self, resolve: &Resolve) -> bool {
+        let roots: Vec<Type> = self
+            .function
+            .params
+            .iter()
+            .map(|p| p.ty)
+            .chain(self.function.result)
+            .collect();
+        roots.contains(&Type::ErrorContext)
+            || reachable_type_ids(resolve, roots)
+                .into_iter()
+                .any(|id| child_types(&resolve.types[id].kind).contains(&Type::ErrorContext))
+    }
+
+    /// The C-ABI symbol name for this function.
+    ///
+    /// Freestanding functions are named `{prefix}_{interface}_{function}`.
+    /// Resource functions are named `{prefix}_{interface}_{resource}_{function}`,
+    /// with constructors using `new` as the function name.
+    pub fn c_func_name(&self, resolve: &Resolve, c_prefix: &str) -> String {
+        match self.resource() {
+            Some(resource_id) => format!(
+                "{}_{}",
+                resource_c_prefix(resolve, c_prefix, resource_id),
+                self.item_name().to_snake_case()
+            ),
+            None if self.interface_name.is_empty() => {
+                names::to_c_func(c_prefix, &self.function_name)
+            }
+            None => names::to_c_func(
+                c_prefix,
+                &format!("{}-{}", self.interface_name, self.function_name),
+            ),
+        }
+    }
+
+    /// The C-ABI symbol name an imported function is implemented under by
+    /// the foreign caller, `{prefix}_import_{interface}_{function}`.
+    pub fn c_import_name(&self, c_prefix: &str) -> String {
+        if self.interface_name.is_empty() {
+            names::to_c_func(c_prefix, &format!("import-{}", self.function_name))
+        } else {
+            names::to_c_func(
+                c_prefix,
+                &format!("import-{}-{}", self.interface_name, self.function_name),
+            )
+        }
+    }
+}
+
+/// Follow `type foo = bar` aliases until reaching a non-alias type definition.
+///
+/// Resources imported with `use` appear as aliases of the original resource;
+/// this returns the defining type so that names and symbols stay consistent.
+pub fn dealias(resolve: &Resolve, mut id: TypeId) -> TypeId {
+    while let TypeDefKind::Type(Type::Id(inner)) = &resolve.types[id].kind {
+        id = *inner;
+    }
+    id
+}
+
+/// The C symbol prefix shared by all functions of a resource, e.g.
+/// `zcash_eip681_types_counter`.
+///
+/// The resource's drop function is named `{resource_c_prefix}_drop`.
+pub fn resource_c_prefix(resolve: &Resolve, c_prefix: &str, resource_id: TypeId) -> String {
+    let typedef = &resolve.types[dealias(resolve, resource_id)];
+    let resource_name = typedef.name.as_deref().unwrap_or("resource");
+    let owner_name = match typedef.owner {
+        TypeOwner::Interface(id) => resolve.interfaces[id].name.clone(),
+        TypeOwner::World(id) => Some(resolve.worlds[id].name.clone()),
+        TypeOwner::None => None,
+    };
+    match owner_name {
+        Some(owner) => names::to_c_func(c_prefix, &format!("{owner}-{resource_name}")),
+        None => names::to_c_func(c_prefix, resource_name),
+    }
+}
+
+/// A PascalCase name describing the shape of a type, used to name the
+/// C structs and helpers generated for anonymous types such as tuples
+/// (e.g. `tuple<string, u64>` becomes `Tuple2StringU64`).
+pub fn type_shape_name(resolve: &Resolve, ty: &Type) -> String {
+    match ty {
+        Type::Bool => "Bool".to_string(),
+        Type::U8 => "U8".to_string(),
+        Type::U16 => "U16".to_string(),
+        Type::U32 => "U32".to_string(),
+        Type::U64 => "U64".to_string(),
+        Type::S8 => "S8".to_string(),
+        Type::S16 => "S16".to_string(),
+        Type::S32 => "S32".to_string(),
+        Type::S64 => "S64".to_string(),
+        Type::F32 => "F32".to_string(),
+        Type::F64 => "F64".to_string(),
+        Type::Char => "Char".to_string(),
+        Type::String => "String".to_string(),
+        Type::ErrorContext => "ErrorContext".to_string(),
+        Type::Id(id) => {
+            let typedef = &resolve.types[*id];
+            match &typedef.name {
+                Some(name) => name.to_pascal_case(),
+                None => kind_shape_name(resolve, &typedef.kind),
+            }
+        }
+    }
+}
+
+/// The shape name of an anonymous typedef of the given kind.
+fn kind_shape_name(resolve: &Resolve, kind: &TypeDefKind) -> String {
+    match kind {
+        TypeDefKind::List(inner) => format!("List{}", type_shape_name(resolve, inner)),
+        TypeDefKind::Option(inner) => format!("Option{}", type_shape_name(resolve, inner)),
+        TypeDefKind::Result(r) => {
+            let shape = |t: &Option<Type>| {
+                t.as_ref()
+                    .map(|t| type_shape_name(resolve, t))
+                    .unwrap_or_else(|| "Unit".to_string())
+            };
+            format!("Result{}{}", shape(&r.ok), shape(&r.err))
+        }
+        TypeDefKind::Tuple(tuple) => tuple_shape_name(resolve, tuple),
+        TypeDefKind::FixedLengthList(inner, len) => fixed_list_shape_name(resolve, inner, *len),
+        TypeDefKind::Handle(Handle::Own(resource_id)) => format!(
+            "Own{}",
+            type_shape_name(resolve, &Type::Id(dealias(resolve, *resource_id)))
+        ),
+        TypeDefKind::Handle(Handle::Borrow(resource_id)) => format!(
+            "Borrow{}",
+            type_shape_name(resolve, &Type::Id(dealias(resolve, *resource_id)))
+        ),
+        TypeDefKind::Type(aliased) => type_shape_name(resolve, aliased),
+        _ => "Anonymous".to_string(),
+    }
+}
+
+/// The shape name of a tuple, e.g. `Tuple2StringU64` for `tuple<string, u64>`.
+pub fn tuple_shape_name(resolve: &Resolve, tuple: &wit_parser::Tuple) -> String {
+    let mut name = format!("Tuple{}", tuple.types.len());
+    for ty in &tuple.types {
+        name.push_str(&type_shape_name(resolve, ty));
+    }
+    name
+}
+
+/// The shape name of a fixed-length list, e.g. `Array4U8` for `list<u8, 4>`.
+pub fn fixed_list_shape_name(resolve: &Resolve, elem: &Type, len: u32) -> String {
+    format!("Array{len}{}", type_shape_name(resolve, elem))
+}
+
+/// The shape name of a typedef that is generated once per shape rather than
+/// per name (tuples, fixed-length lists, options, results and lists of
+/// anything but bytes), or `None` for other kinds.
+pub fn structural_shape_name(resolve: &Resolve, kind: &TypeDefKind) -> Option<String> {
+    match kind {
+        TypeDefKind::List(Type::U8) => None,
+        TypeDefKind::List(_)
+        | TypeDefKind::Option(_)
+        | TypeDefKind::Result(_)
+        | TypeDefKind::Tuple(_)
+        | TypeDefKind::FixedLengthList(..) => Some(kind_shape_name(resolve, kind)),
+        _ => None,
+    }
+}
+
+/// A 128-bit integer type.
+///
+/// WIT has no 128-bit primitives, so by convention a type alias named `u128`
+/// or `s128` for `tuple<u64, u64>` is treated as one. The tuple holds the low
+/// 64 bits first and the high 64 bits second (two's complement for `s128`).
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum WideInt {
+    /// An unsigned 128-bit integer (`u128`).
+    U128,
+    /// A signed 128-bit integer (`s128`).
+    S128,
+}
+
+impl WideInt {
+    /// The WIT alias name this integer is recognised by.
+    pub fn wit_name(self) -> &'static str {
+        match self {
+            WideInt::U128 => "u128",
+            WideInt::S128 => "s128",
+        }
+    }
+
+    /// Whether the integer is signed.
+    pub fn is_signed(self) -> bool {
+        self == WideInt::S128
+    }
+}
+
+/// Recognise a 128-bit integer alias (see [`WideInt`]), following aliases.
+pub fn wide_int(resolve: &Resolve, ty: &Type) -> Option<WideInt> {
+    let &Type::Id(mut id) = ty else {
+        return None;
+    };
+    let mut found = None;
+    while let TypeDefKind::Type(inner) = &resolve.types[id].kind {
+        match resolve.types[id].name.as_deref() {
+            Some("u128") => found = found.or(Some(WideInt::U128)),
+            Some("s128") => found = found.or(Some(WideInt::S128)),
+            _ => {}
+        }
+        match inner {
+            Type::Id(inner_id) => id = *inner_id,
+            _ => return None,
+        }
+    }
+    let typedef = &resolve.types[id];
+    match &typedef.kind {
+        TypeDefKind::Tuple(tuple) if tuple.types == [Type::U64, Type::U64] => {
+            found.or(match typedef.name.as_deref() {
+                Some("u128") => Some(WideInt::U128),
+                Some("s128") => Some(WideInt::S128),
+                _ => None,
+            })
+        }
+        _ => None,
+    }
+}
+
+/// Whether `ty` is a `stream<u8>`, following aliases.
+///
+/// Byte streams map onto the host language's reader and writer interfaces
+/// and are read in chunks rather than one element at a time.
+pub fn is_byte_stream(resolve: &Resolve, ty: &Type) -> bool {
+    let Type::Id(id) = ty else {
+        return false;
+    };
+    matches!(
+        resolve.types[dealias(resolve, *id)].kind,
+        TypeDefKind::Stream(Some(Type::U8))
+    )
+}
+
+/// Whether `ty` is an `error-context`, following aliases.
+pub fn is_error_context(resolve: &Resolve, ty: &Type) -> bool {
+    match ty {
+        Type::ErrorContext => true,
+        Type::Id(id) => matches!(
+            resolve.types[dealias(resolve, *id)].kind,
+            TypeDefKind::Type(Type::ErrorContext)
+        ),
+        _ => false,
+    }
+}
+
+/// The fields of a variant case payload that is an inline tuple, e.g.
+/// `rect(tuple<u32, u32>)`.
+///
+/// Such payloads get a dedicated payload struct holding the elements as
+/// `f0..fN`, rather than a `value` holding the shared tuple struct. Named
+/// tuples and 128-bit integers keep a single `value`.
+pub fn payload_fields<'a>(resolve: &'a Resolve, ty: &Type) -> Option<&'a [Type]> {
+    let Type::Id(id) = ty else {
+        return None;
+    };
+    let typedef = &resolve.types[*id];
+    match &typedef.kind {
+        TypeDefKind::Tuple(tuple) if typedef.name.is_none() => Some(&tuple.types),
+        _ => None,
+    }
+}
+
+/// Shape names of the results that need a `repr(C)` struct because they are
+/// nested inside another type, passed as a parameter or returned through an
+/// alias.
+///
+/// Results returned directly by a function are instead decomposed into a
+/// nullable return value plus the last error, so they get no struct.
+/// `reachable` holds the typedefs reachable from the world's exports.
+pub fn nested_result_shapes(
+    resolve: &Resolve,
+    world_id: WorldId,
+    reachable: &[TypeId],
+) -> HashSet<String> {
+    let mut shapes = HashSet::new();
+    let mut note = |ty: &Type| {
+        if let Type::Id(id) = ty {
+            let kind = &resolve.types[dealias(resolve, *id)].kind;
+            if matches!(kind, TypeDefKind::Result(_)) {
+                shapes.extend(structural_shape_name(resolve, kind));
+            }
+        }
+    };
+
+    for id in reachable {
+        match &resolve.types[*id].kind {
+            TypeDefKind::Record(record) => record.fields.iter().for_each(|f| note(&f.ty)),
+            TypeDefKind::Variant(variant) => variant
+                .cases
+                .iter()
+                .filter_map(|c| c.ty.as_ref())
+                .for_each(&mut note),
+            TypeDefKind::Tuple(tuple) => tuple.types.iter().for_each(&mut note),
+            TypeDefKind::List(ty)
+            | TypeDefKind::Option(ty)
+            | TypeDefKind::FixedLengthList(ty, _) => note(ty),
+            TypeDefKind::Result(r) => r.ok.iter().chain(&r.err).for_each(&mut note),
+            _ => {}
+        }
+    }
+    for ef in exported_functions(resolve, world_id) {
+        ef.function.params.iter().for_each(|p| note(&p.ty));
+        match &ef.function.result {
+            Some(Type::Id(id)) if matches!(resolve.types[*id].kind, TypeDefKind::Result(_)) => {}
+            Some(ty) => note(ty),
+            None => {}
+        }
+    }
+
+    shapes
+}
+
+/// The feature named by an `@unstable(feature = ...)` gate, if any.
+/// `@since` items are stable and have none.
+pub fn unstable_feature(stability: &Stability) -> Option<&str> {
+    match stability {
+        Stability::Unstable { feature, .. } => Some(feature),
+        _ => None,
+    }
+}
+
+/// The version named by a `@deprecated(version = ...)` gate, if any.
+pub fn deprecated_version(stability: &Stability) -> Option<String> {
+    match stability {
+        Stability::Stable { deprecated, .. } | Stability::Unstable { deprecated, .. } => {
+            deprecated.as_ref().map(ToString::to_string)
+        }
+        _ => None,
+    }
+}
+
+/// The names the given interfaces (world import or export keys) are
+/// generated under, keyed by interface.
+///
+/// Interfaces from different packages sharing a name (e.g. two `types`)
+/// are qualified by their package name (`http-types`), or by namespace and
+/// package name (`wasi-http-types`) if that still collides.
+fn qualified_interface_names<'a>(
+    resolve: &Resolve,
+    keys: impl IntoIterator<Item = &'a wit_parser::WorldKey>,
+) -> HashMap<InterfaceId, String> {
+    let ids: Vec<InterfaceId> = keys
+        .into_iter()
+        .filter_map(|key| match key {
+            wit_parser::WorldKey::Interface(id) => Some(*id),
+            wit_parser::WorldKey::Name(_) => None,
+        })
+        .collect();
+
+    let mut names: HashMap<InterfaceId, String> = ids
+        .iter()
+        .map(|id| (*id, qualified_interface_name(resolve, *id, 0)))
+        .collect();
+    for level in 1..=2 {
+        let colliding: Vec<InterfaceId> = ids
+            .iter()
+            .copied()
+            .filter(|id| {
+                ids.iter()
+                    .any(|other| other != id && names[other] == names[id])
+            })
+            .collect();
+        for id in colliding {
+            names.insert(id, qualified_interface_name(resolve, id, level));
+        }
+    }
+    names
+}
+
+/// An interface's name, prefixed at `level` 1 with its package name and at
+/// `level` 2 with its package namespace too.
+fn qualified_interface_name(resolve: &Resolve, id: InterfaceId, level: usize) -> String {
+    let iface = &resolve.interfaces[id];
+    let name = iface
+        .name
+        .clone()
+        .unwrap_or_else(|| format!("interface-{}", id.index()));
+    let Some(pkg) = iface.package.map(|pkg| &resolve.packages[pkg].name) else {
+        return name;
+    };
+    match level {
+        0 => name,
+        1 => format!("{}-{name}", pkg.name),
+        _ => format!("{}-{}-{name}", pkg.namespace, pkg.name),
+    }
+}
+
+/// Check that no two distinct types or exported functions of a world would
+/// be generated under the same name, which the generators can't express.
+///
+/// Types are generated under their own (unqualified) names, so two packages
+/// defining e.g. a `request` record collide. Aliases created by `use` name
+/// the type they alias and don't count.
+///
+/// # Errors
+///
+/// Returns an error naming both definitions of the first name that
+/// collides.
+pub fn check_name_collisions(resolve: &Resolve, world_id: WorldId) -> Result<(), Error> {
+    let mut roots = vec![];
+    for item in resolve.worlds[world_id].exports.values() {
+        if let wit_parser::WorldItem::Interface { id, .. } = item {
+            roots.extend(
+                resolve.interfaces[*id]
+                    .types
+                    .values()
+                    .map(|id| Type::Id(*id)),
+            );
+            for func in resolve.interfaces[*id].functions.values() {
+                roots.extend(func.params.iter().map(|p| p.ty));
+                roots.extend(func.result);
+            }
+        }
+    }
+    let mut types: Vec<TypeId> = Vec::new();
+    for id in reachable_type_ids(resolve, roots) {
+        let typedef = &resolve.types[id];
+        let Some(name) = &typedef.name else { continue };
+        // A `use` alias carries the name of the type it aliases
+        let is_use = matches!(
+            typedef.kind,
+            TypeDefKind::Type(Type::Id(target)) if resolve.types[target].name.as_ref() == Some(name)
+        );
+        if !is_use {
+            types.push(id);
+        }
+    }
+    types.sort_by_key(|id| id.index());
+
+    let describe = |id: TypeId| match resolve.types[id].owner {
+        TypeOwner::Interface(iface) => match resolve.id_of(iface) {
+            Some(iface) => format!("`{iface}`"),
+            None => "an anonymous interface".to_string(),
+        },
+        TypeOwner::World(world) => format!("world `{}`", resolve.worlds[world].name),
+        TypeOwner::None => "an unowned definition".to_string(),
+    };
+    for (i, id) in types.iter().enumerate() {
+        let name = resolve.types[*id].name.as_deref().unwrap_or_default();
+        if let Some(other) = types[..i]
+            .iter()
+            .find(|other| resolve.types[**other].name.as_deref() == Some(name))
+        {
+            return TypeNameCollisionSnafu {
+                name,
+                first: describe(*other),
+                second: describe(*id),
+            }
+            .fail();
+        }
+    }
+
+    let mut functions: Vec<(String, String)> = Vec::new();
+    for ef in exported_functions(resolve, world_id) {
+        let name = if ef.interface_name.is_empty() {
+            ef.function_name.to_snake_case()
+        } else {
+            format!("{}-{}", ef.interface_name, ef.function_name).to_snake_case()
+        };
+        let qualified = format!("`{}.{}`", ef.interface_name, ef.function_name);
+        if let Some((_, first)) = functions.iter().find(|(other, _)| *other == name) {
+            return FunctionNameCollisionSnafu {
+                name,
+                first: first.clone(),
+                second: qualified,
+            }
+            .fail();
+        }
+        functions.push((name, qualified));
+    }
+
+    Ok(())
+}
+
+/// Every type definition reachable from `roots`, through fields, cases,
+/// element types, aliases and handles.
+fn reachable_type_ids(resolve: &Resolve, mut visit: Vec<Type>) -> Vec<TypeId> {
+    let mut seen = HashSet::new();
+    let mut reached = Vec::new();
+    while let Some(ty) = visit.pop() {
+        let Type::Id(id) = ty else { continue };
+        if !seen.insert(id) {
+            continue;
+        }
+        reached.push(id);
+        visit.extend(child_types(&resolve.types[id].kind));
+    }
+    reached
+}
+
+/// The types a type definition directly refers to.
+fn child_types(kind: &TypeDefKind) -> Vec<Type> {
+    match kind {
+        TypeDefKind::Record(record) => record.fields.iter().map(|f| f.ty).collect(),
+        TypeDefKind::Variant(variant) => variant.cases.iter().filter_map(|c| c.ty).collect(),
+        TypeDefKind::Tuple(tuple) => tuple.types.clone(),
+        TypeDefKind::List(ty)
+        | TypeDefKind::Option(ty)
+        | TypeDefKind::FixedLengthList(ty, _)
+        | TypeDefKind::Type(ty) => vec![*ty],
+        TypeDefKind::Result(result) => result.ok.iter().chain(&result.err).copied().collect(),
+        TypeDefKind::Handle(Handle::Own(id) | Handle::Borrow(id)) => vec![Type::Id(*id)],
+        TypeDefKind::Stream(ty) | TypeDefKind::Future(ty) => ty.iter().copied().collect(),
+        _ => Vec::new(),
+    }
 }
 
 /// Extract all exported functions from a world.
+///
+/// An interface exported more than once (e.g. by several included worlds)
+/// contributes its functions once.
 pub fn exported_functions(resolve: &Resolve, world_id: WorldId) -> Vec<ExportedFunction> {
     let world = &resolve.worlds[world_id];
     let mut result = Vec::new();
+    let mut seen = HashSet::new();
+    let qualified = qualified_interface_names(resolve, world.exports.keys());
 
     for (key, item) in &world.exports {
         match item {
-            wit_parser::WorldItem::Interface { id, .. } => {
+            wit_parser::WorldItem::Interface { id, stability } => {
+                if !seen.insert(*id) {
+                    continue;
+                }
                 let iface = &resolve.interfaces[*id];
                 let iface_name = match key {
                     wit_parser::WorldKey::Name(n) => n.clone(),
-                    wit_parser::WorldKey::Interface(id) => resolve.interfaces[*id]
-                        .name
-                        .clone()
-                        .unwrap_or_else(|| format!("interface-{}", id.index())),
+                    wit_parser::WorldKey::Interface(id) => qualified[id].clone(),
                 };
+                let iface_feature =
+                    unstable_feature(stability).or_else(|| unstable_feature(&iface.stability));
+                let iface_deprecated =
+                    deprecated_version(stability).or_else(|| deprecated_version(&iface.stability));
                 for (_name, func) in &iface.functions {
                     result.push(ExportedFunction {
                         interface_name: iface_name.clone(),
                         function_name: func.name.clone(),
                         function: func.clone(),
+                        feature: unstable_feature(&func.stability)
+                            .or(iface_feature)
+                            .map(str::to_string),
+                        deprecated: deprecated_version(&func.stability)
+                            .or_else(|| iface_deprecated.clone()),
                     });
                 }
             }
@@ -133,6 +1306,8 @@ pub fn exported_functions(resolve: &Resolve, world_id: WorldId) -> Vec<ExportedF
                     interface_name: String::new(),
                     function_name: func.name.clone(),
                     function: func.clone(),
+                    feature: unstable_feature(&func.stability).map(str::to_string),
+                    deprecated: deprecated_version(&func.stability),
                 });
             }
             wit_parser::WorldItem::Type { .. } => {
@@ -144,6 +1319,865 @@ pub fn exported_functions(resolve: &Resolve, world_id: WorldId) -> Vec<ExportedF
     result
 }
 
+/// Extract all functions imported by a world, to be implemented by the
+/// foreign caller and called from Rust.
+///
+/// Interfaces imported only for their types (e.g. through `use`) contribute
+/// nothing. Resource functions are not supported as imports and are skipped;
+/// async functions are kept so that [`import_signature`] can reject them.
+pub fn imported_functions(resolve: &Resolve, world_id: WorldId) -> Vec<ExportedFunction> {
+    let world = &resolve.worlds[world_id];
+    let mut result = Vec::new();
+    let qualified = qualified_interface_names(resolve, world.imports.keys());
+
+    for (key, item) in &world.imports {
+        let (iface_name, funcs): (String, Vec<&wit_parser::Function>) = match item {
+            wit_parser::WorldItem::Interface { id, .. } => {
+                let iface_name = match key {
+                    wit_parser::WorldKey::Name(n) => n.clone(),
+                    wit_parser::WorldKey::Interface(id) => qualified[id].clone(),
+                };
+                (
+                    iface_name,
+                    resolve.interfaces[*id].functions.values().collect(),
+                )
+            }
+            wit_parser::WorldItem::Function(func) => (String::new(), vec![func]),
+            wit_parser::WorldItem::Type { .. } => continue,
+        };
+        for func in funcs {
+            if !matches!(
+                func.kind,
+                FunctionKind::Freestanding | FunctionKind::AsyncFreestanding
+            ) {
+                continue;
+            }
+            result.push(ExportedFunction {
+                interface_name: iface_name.clone(),
+                function_name: func.name.clone(),
+                function: func.clone(),
+                feature: unstable_feature(&func.stability).map(str::to_string),
+                deprecated: deprecated_version(&func.stability),
+            });
+        }
+    }
+
+    result
+}
+
+/// A hash identifying the C ABI of a world: the signature of every exported
+/// and imported function, and the shape of every type they pass.
+///
+/// The Rust library and the foreign bindings each embed the hash of the
+/// world they were generated from and compare the two when loaded, so that
+/// bindings generated from another version of the WIT fail with a clear
+/// message instead of misreading struct layouts. Docs and parameter names,
+/// which do not affect the ABI, are left out.
+pub fn abi_hash(resolve: &Resolve, world_id: WorldId) -> u64 {
+    let mut signatures = String::new();
+    let funcs = exported_functions(resolve, world_id)
+        .into_iter()
+        .map(|ef| ("export", ef))
+        .chain(
+            imported_functions(resolve, world_id)
+                .into_iter()
+                .map(|ef| ("import", ef)),
+        );
+    for (direction, ef) in funcs {
+        let async_marker = if ef.is_async() { "async " } else { "" };
+        signatures.push_str(&format!(
+            "{direction} {}#{}: {async_marker}func(",
+            ef.interface_name, ef.function.name
+        ));
+        for (i, param) in ef.function.params.iter().enumerate() {
+            if i > 0 {
+                signatures.push_str(", ");
+            }
+            signatures.push_str(&abi_type_description(resolve, &param.ty));
+        }
+        signatures.push(')');
+        if let Some(result) = &ef.function.result {
+            signatures.push_str(" -> ");
+            signatures.push_str(&abi_type_description(resolve, result));
+        }
+        signatures.push('\n');
+    }
+
+    // FNV-1a, which is stable across Rust versions and platforms
+    signatures
+        .bytes()
+        .fold(0xcbf2_9ce4_8422_2325, |hash, byte| {
+            (hash ^ u64::from(byte)).wrapping_mul(0x0100_0000_01b3)
+        })
+}
+
+/// The structure of a type as it affects the ABI, for [`abi_hash`]. Named
+/// types keep their names, which select special cases such as 128-bit
+/// integers, and resources are described by name alone.
+fn abi_type_description(resolve: &Resolve, ty: &Type) -> String {
+    let Type::Id(id) = ty else {
+        return type_shape_name(resolve, ty);
+    };
+    let typedef = &resolve.types[*id];
+    let describe = |ty: &Type| abi_type_description(resolve, ty);
+    let describe_opt = |ty: &Option<Type>| ty.as_ref().map_or("_".to_string(), describe);
+    let structure = match &typedef.kind {
+        TypeDefKind::Record(record) => {
+            let fields: Vec<String> = record
+                .fields
+                .iter()
+                .map(|f| format!("{}: {}", f.name, describe(&f.ty)))
+                .collect();
+            format!("record {{ {} }}", fields.join(", "))
+        }
+        TypeDefKind::Variant(variant) => {
+            let cases: Vec<String> = variant
+                .cases
+                .iter()
+                .map(|c| match &c.ty {
+                    Some(ty) => format!("{}({})", c.name, describe(ty)),
+                    None => c.name.clone(),
+                })
+                .collect();
+            format!("variant {{ {} }}", cases.join(", "))
+        }
+        TypeDefKind::Enum(e) => {
+            let cases: Vec<&str> = e.cases.iter().map(|c| c.name.as_str()).collect();
+            format!("enum {{ {} }}", cases.join(", "))
+        }
+        TypeDefKind::Flags(flags) => {
+            let flags: Vec<&str> = flags.flags.iter().map(|f| f.name.as_str()).collect();
+            format!("flags {{ {} }}", flags.join(", "))
+        }
+        TypeDefKind::Tuple(tuple) => {
+            let types: Vec<String> = tuple.types.iter().map(describe).collect();
+            format!("tuple<{}>", types.join(", "))
+        }
+        TypeDefKind::List(inner) => format!("list<{}>", describe(inner)),
+        TypeDefKind::FixedLengthList(inner, len) => format!("list<{}, {len}>", describe(inner)),
+        TypeDefKind::Option(inner) => format!("option<{}>", describe(inner)),
+        TypeDefKind::Result(r) => {
+            format!("result<{}, {}>", describe_opt(&r.ok), describe_opt(&r.err))
+        }
+        TypeDefKind::Handle(Handle::Own(resource_id)) => {
+            format!(
+                "own<{}>",
+                describe(&Type::Id(dealias(resolve, *resource_id)))
+            )
+        }
+        TypeDefKind::Handle(Handle::Borrow(resource_id)) => {
+            format!(
+                "borrow<{}>",
+                describe(&Type::Id(dealias(resolve, *resource_id)))
+            )
+        }
+        TypeDefKind::Resource => "resource".to_string(),
+        TypeDefKind::Stream(item) => format!("stream<{}>", describe_opt(item)),
+        TypeDefKind::Future(value) => format!("future<{}>", describe_opt(value)),
+        TypeDefKind::Type(aliased) => describe(aliased),
+        _ => "unknown".to_string(),
+    };
+    match &typedef.name {
+        Some(name) if matches!(typedef.kind, TypeDefKind::Resource) => format!("resource {name}"),
+        Some(name) => format!("{name} = {structure}"),
+        None => structure,
+    }
+}
+
+/// How a value of an imported function crosses the C ABI.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ImportValue {
+    /// A bool or number, passed by value. Aliases are resolved, so this is
+    /// always a primitive type.
+    Scalar(Type),
+    /// A string, passed as an `FfiByteSlice` into the implementation and
+    /// returned as a `malloc`ed `FfiByteBuffer`.
+    String,
+    /// A `list<u8>`, passed like a string.
+    Bytes,
+    /// An `own<R>` handle to a resource implemented in Rust, passed as an
+    /// opaque pointer. Ownership moves to the receiving side.
+    Own(TypeId),
+    /// A `borrow<R>` handle to a resource implemented in Rust, valid only
+    /// for the duration of the call. Borrows are shared, so the receiver
+    /// may pass it back into exported functions while the caller holds it.
+    Borrow(TypeId),
+}
+
+/// How the result of an imported function crosses the C ABI.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ImportResult {
+    /// No result.
+    None,
+    /// A value returned directly.
+    Value(ImportValue),
+    /// A `result<T, string>`: the implementation returns `false` on error,
+    /// writing the ok value to a trailing `ok_out` pointer (if `T` is
+    /// present) and the error message to `err_out`.
+    Fallible(Option<ImportValue>),
+}
+
+/// The C-ABI shape of an imported function.
+#[derive(Debug, Clone)]
+pub struct ImportSignature {
+    /// The parameters, by WIT name.
+    pub params: Vec<(String, ImportValue)>,
+    /// The result.
+    pub result: ImportResult,
+}
+
+/// Classify how `ty` crosses the C ABI into an import implementation, or
+/// `None` if imports do not support it.
+pub fn import_value(resolve: &Resolve, ty: &Type) -> Option<ImportValue> {
+    match ty {
+        Type::Bool
+        | Type::U8
+        | Type::U16
+        | Type::U32
+        | Type::U64
+        | Type::S8
+        | Type::S16
+        | Type::S32
+        | Type::S64
+        | Type::F32
+        | Type::F64 => Some(ImportValue::Scalar(*ty)),
+        Type::String => Some(ImportValue::String),
+        Type::Char | Type::ErrorContext => None,
+        Type::Id(id) => match &resolve.types[*id].kind {
+            TypeDefKind::Type(inner) => import_value(resolve, inner),
+            TypeDefKind::List(Type::U8) => Some(ImportValue::Bytes),
+            TypeDefKind::Handle(Handle::Own(id)) => Some(ImportValue::Own(dealias(resolve, *id))),
+            TypeDefKind::Handle(Handle::Borrow(id)) => {
+                Some(ImportValue::Borrow(dealias(resolve, *id)))
+            }
+            _ => None,
+        },
+    }
+}
+
+/// The C-ABI shape of an imported function.
+///
+/// # Errors
+///
+/// Returns [`Error::AsyncImport`] for async functions, and
+/// [`Error::UnsupportedImport`] if a parameter or the result uses a type
+/// imports do not support.
+pub fn import_signature(
+    resolve: &Resolve,
+    ef: &ExportedFunction,
+) -> Result<ImportSignature, Error> {
+    ensure!(
+        !ef.is_async(),
+        AsyncImportSnafu {
+            function: format!("{}.{}", ef.interface_name, ef.function_name)
+                .trim_start_matches('.')
+                .to_string(),
+        }
+    );
+    let unsupported = |position: String, ty: &Type| {
+        let ty = match ty {
+            Type::Id(id) => match &resolve.types[*id].name {
+                Some(name) => format!("`{name}`"),
+                None => format!("`{}`", type_shape_name(resolve, ty)),
+            },
+            _ => format!("`{}`", type_shape_name(resolve, ty).to_snake_case()),
+        };
+        UnsupportedImportSnafu {
+            function: format!("{}.{}", ef.interface_name, ef.function_name)
+                .trim_start_matches('.')
+                .to_string(),
+            position,
+            ty,
+        }
+        .build()
+    };
+
+    let mut params = Vec::new();
+    for param in &ef.function.params {
+        let value = import_value(resolve, &param.ty)
+            .ok_or_else(|| unsupported(format!("parameter `{}`", param.name), &param.ty))?;
+        params.push((param.name.clone(), value));
+    }
+
+    let result = match &ef.function.result {
+        None => ImportResult::None,
+        Some(ty) => match import_value(resolve, ty) {
+            Some(value) => ImportResult::Value(value),
+            None => {
+                let result = match ty {
+                    Type::Id(id) => match &resolve.types[dealias(resolve, *id)].kind {
+                        TypeDefKind::Result(result) => Some(result),
+                        _ => None,
+                    },
+                    _ => None,
+                };
+                let Some(result) = result.filter(|r| {
+                    r.err
+                        .is_some_and(|e| import_value(resolve, &e) == Some(ImportValue::String))
+                }) else {
+                    return Err(unsupported("result".to_string(), ty));
+                };
+                let ok = match &result.ok {
+                    Some(ok) => Some(
+                        import_value(resolve, ok)
+                            .ok_or_else(|| unsupported("ok result".to_string(), ok))?,
+                    ),
+                    None => None,
+                };
+                ImportResult::Fallible(ok)
+            }
+        },
+    };
+
+    Ok(ImportSignature { params, result })
+}
+
+/// The shape of `ef` when it passes plain values only: numbers, bools,
+/// strings, `list<u8>` and `result<T, string>` of them, as the backends
+/// calling a library outside of the C ABI do. None for resource and async
+/// functions, and those passing anything else.
+pub fn plain_signature(resolve: &Resolve, ef: &ExportedFunction) -> Option<ImportSignature> {
+    if ef.resource().is_some() {
+        return None;
+    }
+    let sig = import_signature(resolve, ef).ok()?;
+    let handle =
+        |value: &ImportValue| matches!(value, ImportValue::Own(_) | ImportValue::Borrow(_));
+    let result_handle = match sig.result {
+        ImportResult::None | ImportResult::Fallible(None) => false,
+        ImportResult::Value(value) | ImportResult::Fallible(Some(value)) => handle(&value),
+    };
+    let param_handle = sig.params.iter().any(|(_, value)| handle(value));
+    (!result_handle && !param_handle).then_some(sig)
+}
+
+/// Check that every imported function can be implemented across the C ABI.
+///
+/// Resources may only cross into imports if exported functions pass them
+/// too, since their handles point at the Rust implementation.
+///
+/// # Errors
+///
+/// Returns [`Error::AsyncImport`] for the first async imported function,
+/// [`Error::UnsupportedImport`] for the first using a type imports do not
+/// support, or [`Error::ImportedResource`] for
+/// the first passing a resource that no exported function does.
+pub fn check_imported_functions(resolve: &Resolve, world_id: WorldId) -> Result<(), Error> {
+    let mut roots = Vec::new();
+    for ef in exported_functions(resolve, world_id) {
+        roots.extend(ef.function.params.iter().map(|p| p.ty));
+        roots.extend(ef.function.result);
+    }
+    let exported: HashSet<TypeId> = reachable_type_ids(resolve, roots).into_iter().collect();
+
+    for ef in imported_functions(resolve, world_id) {
+        let sig = import_signature(resolve, &ef)?;
+        let returned = match sig.result {
+            ImportResult::Value(value) | ImportResult::Fallible(Some(value)) => Some(value),
+            ImportResult::None | ImportResult::Fallible(None) => None,
+        };
+        for value in sig.params.iter().map(|(_, value)| *value).chain(returned) {
+            let (ImportValue::Own(id) | ImportValue::Borrow(id)) = value else {
+                continue;
+            };
+            if !exported.contains(&id) {
+                return ImportedResourceSnafu {
+                    function: format!("{}.{}", ef.interface_name, ef.function_name)
+                        .trim_start_matches('.')
+                        .to_string(),
+                    resource: resolve.types[id].name.as_deref().unwrap_or("anonymous"),
+                }
+                .fail();
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Check that every async exported function can be called across the C ABI.
+///
+/// Async functions copy their arguments before returning to the caller, so
+/// the future that runs them owns everything it uses. A borrowed handle
+/// cannot be copied, so async functions (including methods, which borrow
+/// `self`) may not take one.
+///
+/// # Errors
+///
+/// Returns [`Error::AsyncBorrow`] for the first async function with a
+/// borrowed handle among its parameters.
+pub fn check_async_functions(resolve: &Resolve, world_id: WorldId) -> Result<(), Error> {
+    for ef in exported_functions(resolve, world_id) {
+        if !ef.is_async() {
+            continue;
+        }
+        for param in &ef.function.params {
+            let borrows = reachable_type_ids(resolve, vec![param.ty])
+                .into_iter()
+                .any(|id| {
+                    matches!(
+                        resolve.types[id].kind,
+                        TypeDefKind::Handle(Handle::Borrow(_))
+                    )
+                });
+            ensure!(
+                !borrows,
+                AsyncBorrowSnafu {
+                    function: format!("{}.{}", ef.interface_name, ef.function_name)
+                        .trim_start_matches('.')
+                        .to_string(),
+                    position: if ef.is_method() && param.name == "self" {
+                        "`self`".to_string()
+                    } else {
+                        format!("parameter `{}`", param.name)
+                    },
+                }
+            );
+        }
+    }
+    Ok(())
+}
+
+/// A type that may only be the whole result of a synchronous exported
+/// function.
+#[derive(Clone, Copy)]
+enum WholeResult {
+    Stream,
+    Future,
+}
+
+/// How a function misuses a [`WholeResult`] type.
+enum WholeResultMisuse {
+    Unsupported(String),
+    Borrow(String),
+}
+
+impl WholeResult {
+    fn noun(self) -> &'static str {
+        match self {
+            WholeResult::Stream => "stream",
+            WholeResult::Future => "future",
+        }
+    }
+
+    /// The payload of `kind`, if it is of this type.
+    fn payload(self, kind: &TypeDefKind) -> Option<Option<Type>> {
+        match (self, kind) {
+            (WholeResult::Stream, TypeDefKind::Stream(ty))
+            | (WholeResult::Future, TypeDefKind::Future(ty)) => Some(*ty),
+            _ => None,
+        }
+    }
+
+    /// Find the first exported function that misuses this type, along with
+    /// its name.
+    fn find_misuse(
+        self,
+        resolve: &Resolve,
+        world_id: WorldId,
+    ) -> Option<(String, WholeResultMisuse)> {
+        let noun = self.noun();
+        let contains = |ty: Type, kinds: &[WholeResult]| {
+            reachable_type_ids(resolve, vec![ty]).into_iter().any(|id| {
+                kinds
+                    .iter()
+                    .any(|kind| kind.payload(&resolve.types[id].kind).is_some())
+            })
+        };
+        for ef in exported_functions(resolve, world_id) {
+            let function = format!("{}.{}", ef.interface_name, ef.function_name)
+                .trim_start_matches('.')
+                .to_string();
+            let unsupported =
+                |reason: String| Some((function.clone(), WholeResultMisuse::Unsupported(reason)));
+            for param in &ef.function.params {
+                // The implementation reads whole byte streams during the
+                // call, so they cannot be handed to work that outlives it.
+                if matches!(self, WholeResult::Stream) && is_byte_stream(resolve, &param.ty) {
+                    if ef.owns_arguments(resolve) {
+                        return unsupported(format!(
+                            "takes a stream in parameter `{}` but outlives the call",
+                            param.name
+                        ));
+                    }
+                    continue;
+                }
+                if contains(param.ty, &[self]) {
+                    return unsupported(format!("takes a {noun} in parameter `{}`", param.name));
+                }
+            }
+            let Some(result) = ef.function.result else {
+                continue;
+            };
+            let whole = match result {
+                Type::Id(id) => self.payload(&resolve.types[dealias(resolve, id)].kind),
+                _ => None,
+            };
+            match whole {
+                Some(None) => {
+                    return unsupported(format!("returns a {noun} without a payload type"));
+                }
+                Some(Some(payload))
+                    if contains(payload, &[WholeResult::Stream, WholeResult::Future]) =>
+                {
+                    return unsupported(format!("returns a {noun} of streams or futures"));
+                }
+                Some(Some(_)) => {}
+                None if contains(result, &[self]) => {
+                    return unsupported(format!("returns a {noun} inside another type"));
+                }
+                None => continue,
+            }
+            if ef.is_async() {
+                return unsupported(format!("is async and returns a {noun}"));
+            }
+            for param in &ef.function.params {
+                let borrows = reachable_type_ids(resolve, vec![param.ty])
+                    .into_iter()
+                    .any(|id| {
+                        matches!(
+                            resolve.types[id].kind,
+                            TypeDefKind::Handle(Handle::Borrow(_))
+                        )
+                    });
+                if borrows {
+                    let position = if ef.is_method() && param.name == "self" {
+                        "`self`".to_string()
+                    } else {
+                        format!("parameter `{}`", param.name)
+                    };
+                    return Some((function, WholeResultMisuse::Borrow(position)));
+                }
+            }
+        }
+        None
+    }
+}
+
+/// Check that every exported function uses `stream<T>` only in ways the
+/// generated bindings support.
+///
+/// A stream may only be the whole result of a synchronous function, and its
+/// elements may not be streams or futures themselves. Like async functions,
+/// functions returning streams copy their arguments, so they may not borrow
+/// resources. A whole `stream<u8>` may also be a parameter, as long as the
+/// function neither is async nor returns a stream or future.
+///
+/// # Errors
+///
+/// Returns [`Error::UnsupportedStream`] for the first function using a stream
+/// elsewhere, or [`Error::StreamBorrow`] for the first returning a stream
+/// while borrowing a resource.
+pub fn check_streams(resolve: &Resolve, world_id: WorldId) -> Result<(), Error> {
+    match WholeResult::Stream.find_misuse(resolve, world_id) {
+        None => Ok(()),
+        Some((function, WholeResultMisuse::Unsupported(reason))) => {
+            UnsupportedStreamSnafu { function, reason }.fail()
+        }
+        Some((function, WholeResultMisuse::Borrow(position))) => {
+            StreamBorrowSnafu { function, position }.fail()
+        }
+    }
+}
+
+/// Check that every exported function uses `future<T>` only in ways the
+/// generated bindings support.
+///
+/// The same rules as for streams apply (see [`check_streams`]): a future may
+/// only be the whole result of a synchronous function that takes no borrows.
+///
+/// # Errors
+///
+/// Returns [`Error::UnsupportedFuture`] for the first function using a future
+/// elsewhere, or [`Error::FutureBorrow`] for the first returning a future
+/// while borrowing a resource.
+pub fn check_futures(resolve: &Resolve, world_id: WorldId) -> Result<(), Error> {
+    match WholeResult::Future.find_misuse(resolve, world_id) {
+        None => Ok(()),
+        Some((function, WholeResultMisuse::Unsupported(reason))) => {
+            UnsupportedFutureSnafu { function, reason }.fail()
+        }
+        Some((function, WholeResultMisuse::Borrow(position))) => {
+            FutureBorrowSnafu { function, position }.fail()
+        }
+    }
+}
+
+/// Whether `record_id` is a record whose fields are all numbers or bools,
+/// which can be transferred column by column: one array per field.
+pub fn is_columnar_record(resolve: &Resolve, record_id: TypeId) -> bool {
+    let TypeDefKind::Record(record) = &resolve.types[record_id].kind else {
+        return false;
+    };
+    record.fields.iter().all(|field| {
+        let ty = match field.ty {
+            Type::Id(id) => match resolve.types[dealias(resolve, id)].kind {
+                TypeDefKind::Type(aliased) => aliased,
+                _ => return false,
+            },
+            ty => ty,
+        };
+        matches!(
+            ty,
+            Type::Bool
+                | Type::U8
+                | Type::U16
+                | Type::U32
+                | Type::U64
+                | Type::S8
+                | Type::S16
+                | Type::S32
+                | Type::S64
+                | Type::F32
+                | Type::F64
+        )
+    })
+}
+
+/// Check that each of `records`, by WIT name (e.g. "point"), is a record
+/// that can be transferred column by column.
+///
+/// A `list<record>` result of such a record is returned as one array per
+/// field, e.g. `x: u32, y: u32` as an array of each, which the caller reads
+/// without chasing per-element pointers.
+///
+/// # Errors
+///
+/// Returns [`Error::Columnar`] for a name that is not a record, or a record
+/// with a field other than a number or bool.
+pub fn check_columnar(resolve: &Resolve, records: &[String]) -> Result<(), Error> {
+    for record in records {
+        let found: Vec<TypeId> = resolve
+            .types
+            .iter()
+            .filter(|(_, typedef)| {
+                typedef.name.as_deref() == Some(record.as_str())
+                    && matches!(typedef.kind, TypeDefKind::Record(_))
+            })
+            .map(|(id, _)| id)
+            .collect();
+        let reason = if found.is_empty() {
+            "there is no such record"
+        } else if !found.iter().all(|id| is_columnar_record(resolve, *id)) {
+            "its fields are not all numbers or bools"
+        } else {
+            continue;
+        };
+        return ColumnarSnafu {
+            record: record.clone(),
+            reason,
+        }
+        .fail();
+    }
+    Ok(())
+}
+
+/// Add a batched form of each named exported function to the world, so
+/// callers can make many calls while crossing the FFI boundary once.
+///
+/// Functions are named by qualified name (see
+/// [`ExportedFunction::qualified_name`]). The batched form of `parse:
+/// func(input: string) -> result<u32, string>` is `parse-batch: func(input:
+/// list<string>) -> list<result<u32, string>>`, defined beside it; with
+/// several parameters, it takes a list of each and calls the function once
+/// per position of the shortest. Adding a batched form that already exists
+/// does nothing, so a shared [`Resolve`] can be prepared for several worlds.
+///
+/// # Errors
+///
+/// Returns [`Error::Batch`] for a function that is not an exported
+/// synchronous freestanding function taking parameters other than resource
+/// handles and `stream<u8>`, and returning neither a stream nor a future, or
+/// whose batched name is already taken.
+pub fn add_batch_functions(
+    resolve: &mut Resolve,
+    world_id: WorldId,
+    functions: &[String],
+) -> Result<(), Error> {
+    for function in functions {
+        let funcs = exported_functions(resolve, world_id);
+        let fail = |reason: &str| {
+            BatchSnafu {
+                function: function.clone(),
+                reason,
+            }
+            .fail()
+        };
+        let Some(ef) = funcs
+            .iter()
+            .find(|ef| ef.qualified_name(resolve) == *function)
+        else {
+            return fail("the world exports no such function");
+        };
+        if ef.resource().is_some() {
+            return fail("only freestanding functions can be batched");
+        } else if ef.is_async() {
+            return fail("it is async");
+        } else if ef.stream_item(resolve).is_some() || ef.future_value(resolve).is_some() {
+            return fail("it returns a stream or a future");
+        } else if ef.function.params.is_empty() {
+            return fail("it takes no parameters to batch");
+        } else if ef.function.params.iter().any(|p| {
+            is_byte_stream(resolve, &p.ty)
+                || matches!(p.ty, Type::Id(id) if matches!(
+                    resolve.types[dealias(resolve, id)].kind,
+                    TypeDefKind::Handle(_)
+                ))
+        }) {
+            return fail("it takes a resource handle or a `stream<u8>`");
+        }
+
+        let batch_name = format!("{}-batch", ef.function_name);
+        if let Some(existing) = funcs
+            .iter()
+            .find(|f| f.interface_name == ef.interface_name && f.function_name == batch_name)
+        {
+            if existing.batch_of(resolve, &funcs).is_some() {
+                continue;
+            }
+            return fail(&format!("`{batch_name}` is already defined"));
+        }
+
+        let mut list_of = |elem: Type| {
+            Type::Id(resolve.types.alloc(wit_parser::TypeDef {
+                name: None,
+                kind: TypeDefKind::List(elem),
+                owner: TypeOwner::None,
+                docs: wit_parser::Docs::default(),
+                stability: Stability::Unknown,
+            }))
+        };
+        let mut batch = ef.function.clone();
+        batch.name = batch_name.clone();
+        for param in &mut batch.params {
+            param.ty = list_of(param.ty);
+        }
+        batch.result = batch.result.map(&mut list_of);
+        batch.docs.contents = Some(format!(
+            "Batched form of `{}`, calling it once per element of its list arguments\n\
+             in a single call across the FFI boundary.",
+            ef.function_name
+        ));
+
+        match exported_interface(resolve, world_id, &ef.interface_name) {
+            Some(iface) => {
+                resolve.interfaces[iface]
+                    .functions
+                    .insert(batch_name, batch);
+            }
+            None => {
+                resolve.worlds[world_id].exports.insert(
+                    wit_parser::WorldKey::Name(batch_name),
+                    wit_parser::WorldItem::Function(batch),
+                );
+            }
+        }
+    }
+    Ok(())
+}
+
+/// The interface `world_id` exports under `name`, as
+/// [`ExportedFunction::interface_name`] names it.
+fn exported_interface(resolve: &Resolve, world_id: WorldId, name: &str) -> Option<InterfaceId> {
+    let world = &resolve.worlds[world_id];
+    let qualified = qualified_interface_names(resolve, world.exports.keys());
+    world
+        .exports
+        .iter()
+        .find_map(|(key, item)| match (key, item) {
+            (wit_parser::WorldKey::Name(key), wit_parser::WorldItem::Interface { id, .. })
+                if key == name =>
+            {
+                Some(*id)
+            }
+            (wit_parser::WorldKey::Interface(id), wit_parser::WorldItem::Interface { .. })
+                if qualified[id] == name =>
+            {
+                Some(*id)
+            }
+            _ => None,
+        })
+}
+
+/// Leave the exported `functions` of `world_id`, given by qualified name
+/// (e.g. "parser.parse" or "types.counter.get"), out of the generated
+/// bindings of every language, by removing them from the world or their
+/// interface. The types they use stay.
+///
+/// # Errors
+///
+/// Returns [`Error::Skip`] for a function the world does not export.
+pub fn skip_functions(
+    resolve: &mut Resolve,
+    world_id: WorldId,
+    functions: &[String],
+) -> Result<(), Error> {
+    for function in functions {
+        let funcs = exported_functions(resolve, world_id);
+        let ef = funcs
+            .iter()
+            .find(|ef| ef.qualified_name(resolve) == *function)
+            .context(SkipSnafu { function })?;
+        match exported_interface(resolve, world_id, &ef.interface_name) {
+            Some(iface) => {
+                resolve.interfaces[iface]
+                    .functions
+                    .shift_remove(&ef.function.name);
+            }
+            None => {
+                resolve.worlds[world_id]
+                    .exports
+                    .shift_remove(&wit_parser::WorldKey::Name(ef.function.name.clone()));
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Narrow the interfaces `world_id` exports to those named in `only`,
+/// unless it is empty, less those named in `exclude`, so that the bindings
+/// of every language leave the others out. Interfaces are named as
+/// [`ExportedFunction::interface_name`] names them (e.g. "parser"). The
+/// functions and types the world exports itself, and its imports, stay.
+///
+/// # Errors
+///
+/// Returns [`Error::Filter`] for a name the world exports no interface
+/// under.
+pub fn filter_interfaces(
+    resolve: &mut Resolve,
+    world_id: WorldId,
+    only: &[String],
+    exclude: &[String],
+) -> Result<(), Error> {
+    let world = &resolve.worlds[world_id];
+    let qualified = qualified_interface_names(resolve, world.exports.keys());
+    let interfaces: Vec<(wit_parser::WorldKey, String)> = world
+        .exports
+        .iter()
+        .filter_map(|(key, item)| match (key, item) {
+            (wit_parser::WorldKey::Name(name), wit_parser::WorldItem::Interface { .. }) => {
+                Some((key.clone(), name.clone()))
+            }
+            (wit_parser::WorldKey::Interface(id), wit_parser::WorldItem::Interface { .. }) => {
+                Some((key.clone(), qualified[id].clone()))
+            }
+            _ => None,
+        })
+        .collect();
+    for interface in only.iter().chain(exclude) {
+        ensure!(
+            interfaces.iter().any(|(_, name)| name == interface),
+            FilterSnafu { interface }
+        );
+    }
+    for (key, name) in interfaces {
+        if (!only.is_empty() && !only.contains(&name)) || exclude.contains(&name) {
+            resolve.worlds[world_id].exports.shift_remove(&key);
+        }
+    }
+    Ok(())
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -163,4 +2197,1179 @@ mod tests {
         assert_eq!(funcs[1].interface_name, "functions");
         assert_eq!(funcs[1].function_name, "u256-to-string");
     }
+
+    #[test]
+    fn test_load_wit_with_deps() {
+        let root = std::env::temp_dir().join(format!("witffi-deps-{}", std::process::id()));
+        let deps = root.join("deps");
+        std::fs::create_dir_all(deps.join("units")).expect("failed to create deps");
+        std::fs::write(
+            deps.join("units/units.wit"),
+            "package test:units;\n\ninterface measures {\n    type meters = f64;\n}\n",
+        )
+        .expect("failed to write units.wit");
+        // Sorts before the `units` package it depends on, and has a world of
+        // its own
+        std::fs::write(
+            deps.join("common.wit"),
+            r#"
+                package test:common;
+
+                interface money {
+                    use test:units/measures.{meters};
+
+                    record amount {
+                        value: u64,
+                        distance: meters,
+                    }
+                }
+
+                world standalone {
+                    import money;
+                }
+            "#,
+        )
+        .expect("failed to write common.wit");
+        std::fs::write(
+            root.join("shop.wit"),
+            r#"
+                package test:shop;
+
+                interface prices {
+                    use test:common/money.{amount};
+
+                    price: func(sku: string) -> amount;
+                }
+
+                interface refunds {
+                    use test:common/money.{amount};
+
+                    refund: func(total: amount) -> bool;
+                }
+
+                world shop {
+                    export prices;
+                    export refunds;
+                }
+            "#,
+        )
+        .expect("failed to write shop.wit");
+
+        for path in [root.clone(), root.join("shop.wit")] {
+            let (resolve, world_id) = load_wit(&path).expect("failed to load WIT with deps");
+            assert_eq!(resolve.worlds[world_id].name, "shop");
+
+            let funcs = exported_functions(&resolve, world_id);
+            assert_eq!(funcs.len(), 2);
+            let Some(Type::Id(price)) = funcs[0].function.result else {
+                panic!("price should return a named type");
+            };
+            let Type::Id(total) = funcs[1].function.params[0].ty else {
+                panic!("refund should take a named type");
+            };
+            // Both interfaces share the dependency's record
+            assert_eq!(dealias(&resolve, price), dealias(&resolve, total));
+        }
+
+        std::fs::remove_dir_all(&root).ok();
+    }
+
+    #[test]
+    fn test_load_wit_diagnostics() {
+        let root = std::env::temp_dir().join(format!("witffi-diagnostics-{}", std::process::id()));
+        std::fs::create_dir_all(&root).expect("failed to create WIT directory");
+        let wit = root.join("shop.wit");
+        std::fs::write(
+            &wit,
+            "package test:shop;\n\ninterface prices {\n    record line-item {\n        \
+             sku: strng,\n    }\n\n    total: func(items: list<line-itme>) -> u64;\n}\n",
+        )
+        .expect("failed to write shop.wit");
+
+        let error = load_wit_package(&wit).expect_err("the WIT should not resolve");
+        let Error::InvalidWit { diagnostic } = &error else {
+            panic!("expected a diagnostic, got {error:?}");
+        };
+        let location = diagnostic
+            .location
+            .as_ref()
+            .expect("the diagnostic should be located");
+        assert_eq!(location.path.file_name(), wit.file_name());
+        assert_eq!(location.line, 5, "the field's line should be reported");
+        assert!(diagnostic.message.contains("`strng`"));
+        assert_eq!(diagnostic.suggestion.as_deref(), Some("string"));
+
+        std::fs::write(
+            &wit,
+            "package test:shop;\n\ninterface prices {\n    record line-item {\n        \
+             sku: string,\n    }\n\n    total: func(items: list<line-itme>) -> u64;\n}\n",
+        )
+        .expect("failed to write shop.wit");
+        let error = load_wit_package(&wit).expect_err("the WIT should not resolve");
+        assert!(
+            error.to_string().contains(":8:")
+                && error.to_string().contains("did you mean `line-item`?"),
+            "types the WIT defines should be suggested: {error}"
+        );
+
+        std::fs::remove_dir_all(&root).ok();
+    }
+
+    #[test]
+    fn test_load_wasm() {
+        let root = std::env::temp_dir().join(format!("witffi-wasm-{}", std::process::id()));
+        std::fs::create_dir_all(&root).expect("failed to create WIT directory");
+        let wasm = root.join("broken.wasm");
+        // The magic number and version of a core module, then garbage
+        std::fs::write(&wasm, b"\0asm\x01\0\0\0\xff\xff").expect("failed to write broken.wasm");
+        assert!(is_wasm(&wasm));
+
+        let error = load_wit_package(&wasm).expect_err("the binary holds no WIT");
+        assert!(
+            matches!(error, Error::DecodeWasm { .. }),
+            "binaries should be decoded, not parsed as WIT: {error:?}"
+        );
+
+        let wit = root.join("empty.wit");
+        std::fs::write(&wit, "package test:empty;\n").expect("failed to write empty.wit");
+        assert!(
+            !is_wasm(&wit),
+            "WIT text should not be taken for WebAssembly"
+        );
+        assert!(
+            !is_wasm(&root),
+            "directories should not be taken for WebAssembly"
+        );
+
+        std::fs::remove_dir_all(&root).ok();
+    }
+
+    #[test]
+    fn test_decode_wasm() {
+        let root = std::env::temp_dir().join(format!("witffi-decode-{}", std::process::id()));
+        std::fs::create_dir_all(&root).expect("failed to create WIT directory");
+        let wit = root.join("greet.wit");
+        std::fs::write(
+            &wit,
+            "package test:greet;\n\ninterface api {\n    greet: func(name: string) -> string;\n}\n\n\
+             world greeter {\n    export api;\n}\n",
+        )
+        .expect("failed to write greet.wit");
+        let (resolve, pkg_id) = load_wit_package(&wit).expect("failed to load greet.wit");
+        let world_id = select_world(&resolve, pkg_id, None).expect("failed to select greeter");
+
+        // A WIT package encoded as WebAssembly decodes to the same package
+        let package = root.join("greet.wasm");
+        let bytes = wit_component::encode(&resolve, pkg_id).expect("failed to encode the package");
+        std::fs::write(&package, bytes).expect("failed to write greet.wasm");
+        let (decoded, decoded_pkg) =
+            load_wit_package(&package).expect("failed to decode greet.wasm");
+        assert_eq!(decoded.packages[decoded_pkg].name.to_string(), "test:greet");
+        let decoded_world = select_world(&decoded, decoded_pkg, Some("greeter"))
+            .expect("the package should keep its world");
+        let names: Vec<String> = exported_functions(&decoded, decoded_world)
+            .iter()
+            .map(|ef| ef.qualified_name(&decoded))
+            .collect();
+        assert_eq!(names, ["api.greet"]);
+
+        // A component targeting the world decodes to a world of its own,
+        // `root`, with the component's exports
+        let mut module =
+            wit_component::dummy_module(&resolve, world_id, wit_parser::ManglingAndAbi::Standard32);
+        wit_component::embed_component_metadata(
+            &mut module,
+            &resolve,
+            world_id,
+            wit_component::StringEncoding::UTF8,
+        )
+        .expect("failed to embed the world");
+        let bytes = wit_component::ComponentEncoder::default()
+            .module(&module)
+            .expect("failed to read the module")
+            .validate(true)
+            .encode()
+            .expect("failed to encode the component");
+        let component = root.join("greeter.wasm");
+        std::fs::write(&component, bytes).expect("failed to write greeter.wasm");
+        let (decoded, decoded_pkg) =
+            load_wit_package(&component).expect("failed to decode greeter.wasm");
+        let decoded_world =
+            select_world(&decoded, decoded_pkg, None).expect("the component should have a world");
+        assert_eq!(decoded.worlds[decoded_world].name, "root");
+        let names: Vec<String> = exported_functions(&decoded, decoded_world)
+            .iter()
+            .map(|ef| ef.qualified_name(&decoded))
+            .collect();
+        assert_eq!(names, ["api.greet"]);
+
+        std::fs::remove_dir_all(&root).ok();
+    }
+
+    #[test]
+    fn test_select_world() {
+        let source = r#"
+            package test:worlds;
+
+            interface api {
+                ping: func() -> bool;
+            }
+
+            world client {
+                import api;
+            }
+
+            world server {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("worlds.wit", source)
+            .expect("failed to parse worlds WIT");
+
+        let server = select_world(&resolve, pkg_id, Some("server")).expect("missing server world");
+        assert_eq!(resolve.worlds[server].name, "server");
+        assert_eq!(exported_functions(&resolve, server).len(), 1);
+
+        let err = select_world(&resolve, pkg_id, Some("proxy")).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "no world named `proxy` in WIT package (found: client, server)"
+        );
+        assert!(matches!(
+            select_world(&resolve, pkg_id, None),
+            Err(Error::WorldCount { count: 2 })
+        ));
+    }
+
+    #[test]
+    fn test_select_composed_world() {
+        let source = r#"
+            package test:compose;
+
+            interface accounts {
+                balance: func(id: u64) -> u64;
+            }
+
+            interface transfers {
+                send: func(from: u64, to: u64, amount: u64) -> bool;
+            }
+
+            interface audit {
+                log: func() -> list<string>;
+            }
+
+            world reader {
+                export accounts;
+            }
+
+            world writer {
+                export accounts;
+                export transfers;
+            }
+
+            world wallet {
+                include reader;
+                include writer;
+                export audit;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("compose.wit", source)
+            .expect("failed to parse composed WIT");
+
+        let world_id = select_world(&resolve, pkg_id, None).expect("missing main world");
+        assert_eq!(resolve.worlds[world_id].name, "wallet");
+
+        // Interfaces shared by both included worlds appear once
+        let mut funcs: Vec<String> = exported_functions(&resolve, world_id)
+            .iter()
+            .map(|ef| format!("{}.{}", ef.interface_name, ef.function_name))
+            .collect();
+        funcs.sort();
+        assert_eq!(
+            funcs,
+            vec!["accounts.balance", "audit.log", "transfers.send"]
+        );
+    }
+
+    #[test]
+    fn test_unstable_features() {
+        let source = r#"
+            package test:gates@1.0.0;
+
+            interface math {
+                @since(version = 1.0.0)
+                add: func(a: u32, b: u32) -> u32;
+
+                @unstable(feature = fast-math)
+                fma: func(a: f64, b: f64, c: f64) -> f64;
+            }
+
+            @unstable(feature = stats)
+            interface stats {
+                mean: func(values: list<f64>) -> f64;
+            }
+
+            world gates {
+                export math;
+                @unstable(feature = stats)
+                export stats;
+            }
+        "#;
+        let mut resolve = Resolve {
+            all_features: true,
+            ..Resolve::default()
+        };
+        let pkg_id = resolve
+            .push_str("gates.wit", source)
+            .expect("failed to parse gated WIT");
+        let world_id = resolve.packages[pkg_id].worlds["gates"];
+
+        let features: Vec<(String, Option<String>)> = exported_functions(&resolve, world_id)
+            .into_iter()
+            .map(|ef| (ef.function_name, ef.feature))
+            .collect();
+        assert_eq!(
+            features,
+            vec![
+                ("add".to_string(), None),
+                ("fma".to_string(), Some("fast-math".to_string())),
+                ("mean".to_string(), Some("stats".to_string())),
+            ]
+        );
+    }
+
+    /// Push `test:alpha` and `test:beta`, each with a `types` interface, then
+    /// a world exporting both.
+    fn push_colliding_packages(resolve: &mut Resolve, beta_types: &str) -> WorldId {
+        resolve
+            .push_str(
+                "alpha.wit",
+                r#"
+                    package test:alpha;
+
+                    interface types {
+                        record request {
+                            url: string,
+                        }
+
+                        get: func(req: request) -> u32;
+                    }
+                "#,
+            )
+            .expect("failed to parse alpha WIT");
+        resolve
+            .push_str(
+                "beta.wit",
+                &format!("package test:beta;\n\ninterface types {{\n{beta_types}\n}}\n"),
+            )
+            .expect("failed to parse beta WIT");
+        let pkg_id = resolve
+            .push_str(
+                "app.wit",
+                r#"
+                    package test:app;
+
+                    world app {
+                        export test:alpha/types;
+                        export test:beta/types;
+                    }
+                "#,
+            )
+            .expect("failed to parse app WIT");
+        resolve.packages[pkg_id].worlds["app"]
+    }
+
+    #[test]
+    fn test_qualified_interface_names() {
+        let mut resolve = Resolve::default();
+        let world_id = push_colliding_packages(
+            &mut resolve,
+            "record reply {\n    code: u32,\n}\n\nget: func(id: u32) -> reply;",
+        );
+
+        let funcs: Vec<String> = exported_functions(&resolve, world_id)
+            .iter()
+            .map(|ef| format!("{}.{}", ef.interface_name, ef.function_name))
+            .collect();
+        assert_eq!(funcs, vec!["alpha-types.get", "beta-types.get"]);
+        assert_eq!(
+            exported_functions(&resolve, world_id)[1].c_func_name(&resolve, "witffi"),
+            "witffi_beta_types_get"
+        );
+        check_name_collisions(&resolve, world_id).expect("qualified names should not collide");
+    }
+
+    #[test]
+    fn test_type_name_collisions() {
+        let mut resolve = Resolve::default();
+        let world_id = push_colliding_packages(
+            &mut resolve,
+            "record request {\n    id: u32,\n}\n\nput: func(req: request) -> bool;",
+        );
+
+        let err = check_name_collisions(&resolve, world_id).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "type `request` is defined by both `test:alpha/types` and `test:beta/types`; \
+             generated type names are not qualified by interface, so rename one of them"
+        );
+    }
+
+    #[test]
+    fn test_resource_function_c_names() {
+        let source = r#"
+            package test:counter;
+
+            interface types {
+                resource counter {
+                    constructor(start: u64);
+                    get: func() -> u64;
+                    from-string: static func(s: string) -> counter;
+                }
+
+                make-counter: func() -> counter;
+            }
+
+            world counter {
+                export types;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("counter.wit", source)
+            .expect("failed to parse counter WIT");
+        let world_id = resolve.packages[pkg_id].worlds["counter"];
+
+        let funcs = exported_functions(&resolve, world_id);
+        let c_names: Vec<String> = funcs
+            .iter()
+            .map(|ef| ef.c_func_name(&resolve, "witffi"))
+            .collect();
+        assert_eq!(
+            c_names,
+            vec![
+                "witffi_types_counter_new",
+                "witffi_types_counter_get",
+                "witffi_types_counter_from_string",
+                "witffi_types_make_counter",
+            ]
+        );
+
+        assert!(funcs[0].is_constructor());
+        assert!(funcs[1].is_method());
+        assert_eq!(funcs[2].item_name(), "from-string");
+        assert!(funcs[3].resource().is_none());
+
+        let qualified: Vec<String> = funcs.iter().map(|ef| ef.qualified_name(&resolve)).collect();
+        assert_eq!(
+            qualified,
+            vec![
+                "types.counter.new",
+                "types.counter.get",
+                "types.counter.from-string",
+                "types.make-counter",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_wide_int_aliases() {
+        let source = r#"
+            package test:wide;
+
+            interface types {
+                type u128 = tuple<u64, u64>;
+                type s128 = tuple<u64, u64>;
+                type balance = u128;
+                type pair = tuple<u64, u64>;
+                type u256 = list<u8>;
+            }
+
+            world wide {
+                export types;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        resolve
+            .push_str("wide.wit", source)
+            .expect("failed to parse wide WIT");
+        let iface = resolve
+            .interfaces
+            .iter()
+            .find(|(_, i)| i.name.as_deref() == Some("types"))
+            .map(|(_, i)| i)
+            .unwrap();
+        let lookup = |name: &str| Type::Id(iface.types[name]);
+
+        assert_eq!(wide_int(&resolve, &lookup("u128")), Some(WideInt::U128));
+        assert_eq!(wide_int(&resolve, &lookup("s128")), Some(WideInt::S128));
+        assert_eq!(wide_int(&resolve, &lookup("balance")), Some(WideInt::U128));
+        assert_eq!(wide_int(&resolve, &lookup("pair")), None);
+        assert_eq!(wide_int(&resolve, &lookup("u256")), None);
+        assert_eq!(wide_int(&resolve, &Type::U64), None);
+    }
+
+    #[test]
+    fn test_payload_fields() {
+        let source = r#"
+            package test:payloads;
+
+            interface shapes {
+                type u128 = tuple<u64, u64>;
+                type pair = tuple<u32, u32>;
+
+                variant shape {
+                    rect(tuple<u8, u64, string>),
+                    pair(pair),
+                    wide(u128),
+                    circle(f32),
+                }
+            }
+
+            world payloads {
+                export shapes;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        resolve
+            .push_str("payloads.wit", source)
+            .expect("failed to parse payloads WIT");
+        let iface = resolve
+            .interfaces
+            .iter()
+            .find(|(_, i)| i.name.as_deref() == Some("shapes"))
+            .map(|(_, i)| i)
+            .unwrap();
+        let TypeDefKind::Variant(variant) = &resolve.types[iface.types["shape"]].kind else {
+            panic!("shape should be a variant");
+        };
+        let fields: Vec<Option<usize>> = variant
+            .cases
+            .iter()
+            .map(|case| payload_fields(&resolve, case.ty.as_ref().unwrap()).map(<[Type]>::len))
+            .collect();
+        assert_eq!(fields, vec![Some(3), None, None, None]);
+    }
+
+    #[test]
+    fn test_tuple_shape_names() {
+        let source = r#"
+            package test:tuples;
+
+            interface shapes {
+                record point {
+                    x: s32,
+                    y: s32,
+                }
+
+                pair: func() -> tuple<string, u64>;
+                nested: func() -> tuple<point, option<list<u8>>, tuple<bool, char>>;
+            }
+
+            world tuples {
+                export shapes;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tuples.wit", source)
+            .expect("failed to parse tuples WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tuples"];
+
+        let funcs = exported_functions(&resolve, world_id);
+        let shapes: Vec<String> = funcs
+            .iter()
+            .map(|ef| type_shape_name(&resolve, ef.function.result.as_ref().unwrap()))
+            .collect();
+        assert_eq!(
+            shapes,
+            vec!["Tuple2StringU64", "Tuple3PointOptionListU8Tuple2BoolChar"]
+        );
+    }
+
+    #[test]
+    fn test_fixed_list_shape_names() {
+        let source = r#"
+            package test:arrays;
+
+            interface shapes {
+                digest: func() -> list<u8, 32>;
+                grid: func() -> list<list<s32, 3>, 3>;
+                pairs: func() -> list<tuple<string, u64>, 2>;
+            }
+
+            world arrays {
+                export shapes;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("arrays.wit", source)
+            .expect("failed to parse arrays WIT");
+        let world_id = resolve.packages[pkg_id].worlds["arrays"];
+
+        let funcs = exported_functions(&resolve, world_id);
+        let shapes: Vec<String> = funcs
+            .iter()
+            .map(|ef| type_shape_name(&resolve, ef.function.result.as_ref().unwrap()))
+            .collect();
+        assert_eq!(
+            shapes,
+            vec!["Array32U8", "Array3Array3S32", "Array2Tuple2StringU64"]
+        );
+    }
+
+    #[test]
+    fn test_imported_functions() {
+        let source = r#"
+            package test:host;
+
+            interface host {
+                record point {
+                    x: u32,
+                }
+
+                read-file: func(path: string) -> result<string, string>;
+                log: func(message: string);
+                checksum: func(data: list<u8>) -> u32;
+            }
+
+            interface geometry {
+                use host.{point};
+
+                translate: func(p: point) -> point;
+            }
+
+            interface parser {
+                parse: func(input: string) -> u32;
+            }
+
+            world app {
+                import host;
+                export parser;
+            }
+
+            world shapes {
+                import geometry;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("host.wit", source)
+            .expect("failed to parse host WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
+
+        let imports = imported_functions(&resolve, world_id);
+        let names: Vec<String> = imports
+            .iter()
+            .map(|ef| format!("{}.{}", ef.interface_name, ef.function_name))
+            .collect();
+        assert_eq!(names, vec!["host.read-file", "host.log", "host.checksum"]);
+        assert_eq!(
+            imports[0].c_import_name("witffi"),
+            "witffi_import_host_read_file"
+        );
+
+        let read_file = import_signature(&resolve, &imports[0]).expect("read-file is supported");
+        assert_eq!(
+            read_file.params,
+            vec![("path".to_string(), ImportValue::String)]
+        );
+        assert_eq!(
+            read_file.result,
+            ImportResult::Fallible(Some(ImportValue::String))
+        );
+        let checksum = import_signature(&resolve, &imports[2]).expect("checksum is supported");
+        assert_eq!(checksum.params[0].1, ImportValue::Bytes);
+        assert_eq!(
+            checksum.result,
+            ImportResult::Value(ImportValue::Scalar(Type::U32))
+        );
+        check_imported_functions(&resolve, world_id).expect("app imports are supported");
+
+        // `host` is imported implicitly for `point`; its functions are fine
+        let shapes = resolve.packages[pkg_id].worlds["shapes"];
+        let err = check_imported_functions(&resolve, shapes).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "imported function `geometry.translate` parameter `p` is `point`, but imports only \
+             support numbers, bools, strings, `list<u8>`, resource handles and `result<T, string>` \
+             results of those"
+        );
+    }
+
+    #[test]
+    fn test_imported_resources() {
+        let source = r#"
+            package test:bidi;
+
+            interface types {
+                resource counter;
+            }
+
+            interface host {
+                use types.{counter};
+
+                /// Inspect a counter owned by Rust.
+                inspect: func(c: borrow<counter>) -> u64;
+                adopt: func(c: counter);
+                spawn: func() -> result<counter, string>;
+            }
+
+            interface api {
+                use types.{counter};
+
+                make-counter: func(start: u64) -> counter;
+                tally: func(c: borrow<counter>) -> u64;
+            }
+
+            world app {
+                import host;
+                export api;
+            }
+
+            world lonely {
+                import host;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("bidi.wit", source)
+            .expect("failed to parse bidi WIT");
+        let app = resolve.packages[pkg_id].worlds["app"];
+
+        let imports = imported_functions(&resolve, app);
+        let inspect = import_signature(&resolve, &imports[0]).expect("borrows are supported");
+        let ImportValue::Borrow(counter) = inspect.params[0].1 else {
+            panic!("expected a borrow, got {:?}", inspect.params[0].1);
+        };
+        assert_eq!(resolve.types[counter].name.as_deref(), Some("counter"));
+        assert!(
+            matches!(resolve.types[counter].kind, TypeDefKind::Resource),
+            "handles should point at the resource, not the `use` alias"
+        );
+        let spawn = import_signature(&resolve, &imports[2]).expect("owned results are supported");
+        assert_eq!(
+            spawn.result,
+            ImportResult::Fallible(Some(ImportValue::Own(counter)))
+        );
+        check_imported_functions(&resolve, app).expect("api passes counters too");
+
+        let lonely = resolve.packages[pkg_id].worlds["lonely"];
+        let err = check_imported_functions(&resolve, lonely).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "imported function `host.inspect` passes resource `counter`, but only resources that \
+             exported functions also pass can cross into imports"
+        );
+    }
+
+    #[test]
+    fn test_async_functions() {
+        let source = r#"
+            package test:tasks;
+
+            interface api {
+                fetch: async func(url: string) -> result<string, string>;
+            }
+
+            interface jobs {
+                resource job {
+                    wait: async func() -> u64;
+                }
+            }
+
+            interface host {
+                poll: async func() -> u64;
+            }
+
+            world tasks {
+                export api;
+            }
+
+            world waiting {
+                export jobs;
+            }
+
+            world polling {
+                import host;
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tasks.wit", source)
+            .expect("failed to parse tasks WIT");
+        let worlds = &resolve.packages[pkg_id].worlds;
+
+        let funcs = exported_functions(&resolve, worlds["tasks"]);
+        assert!(funcs[0].is_async(), "`fetch` should be async");
+        check_async_functions(&resolve, worlds["tasks"]).expect("owned arguments are supported");
+
+        let err = check_async_functions(&resolve, worlds["waiting"]).unwrap_err();
+        assert!(
+            matches!(&err, Error::AsyncBorrow { position, .. } if position == "`self`"),
+            "async methods borrow `self`, got: {err}"
+        );
+
+        let err = check_imported_functions(&resolve, worlds["polling"]).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "imported function `host.poll` is async, but imports must be synchronous"
+        );
+    }
+
+    #[test]
+    fn test_stream_and_future_results() {
+        let source = r#"
+            package test:jobs;
+
+            interface api {
+                resource job;
+                type failure = error-context;
+
+                tail: func(path: string) -> stream<string>;
+                fetch: func(url: string) -> future<result<string, string>>;
+                upload: func(name: string, data: stream<u8>) -> u64;
+                watch: func(name: string) -> future<result<u32, failure>>;
+            }
+
+            interface nested {
+                batches: func() -> list<future<u32>>;
+            }
+
+            interface borrowing {
+                resource job;
+
+                watch: func(job: borrow<job>) -> future<u32>;
+            }
+
+            world jobs {
+                export api;
+            }
+
+            world nesting {
+                export nested;
+            }
+
+            world lending {
+                export borrowing;
+            }
+
+            interface piping {
+                pipe: func(data: stream<u8>) -> stream<u8>;
+            }
+
+            world pipes {
+                export piping;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("jobs.wit", source)
+            .expect("failed to parse jobs WIT");
+        let worlds = &resolve.packages[pkg_id].worlds;
+
+        let funcs = exported_functions(&resolve, worlds["jobs"]);
+        let tail = funcs.iter().find(|ef| ef.function_name == "tail").unwrap();
+        assert_eq!(tail.stream_item(&resolve), Some(Type::String));
+        let fetch = funcs.iter().find(|ef| ef.function_name == "fetch").unwrap();
+        let awaited = fetch.awaited(&resolve).expect("`fetch` returns a future");
+        assert!(
+            awaited.typed_error(&resolve).is_none() && awaited.function.result.is_some(),
+            "awaiting `fetch` should produce its result"
+        );
+        assert!(
+            fetch.owns_arguments(&resolve),
+            "futures should own their arguments"
+        );
+        let watch = funcs.iter().find(|ef| ef.function_name == "watch").unwrap();
+        let awaited = watch.awaited(&resolve).unwrap();
+        assert_eq!(
+            awaited.error_payload(&resolve),
+            Some(Type::ErrorContext),
+            "an error-context error should be handed back as a payload"
+        );
+        assert!(
+            watch.uses_error_context(&resolve) && !fetch.uses_error_context(&resolve),
+            "only `watch` uses an error-context"
+        );
+        let upload = funcs
+            .iter()
+            .find(|ef| ef.function_name == "upload")
+            .unwrap();
+        assert!(
+            upload.takes_byte_streams(&resolve) && !tail.takes_byte_streams(&resolve),
+            "only `upload` takes a byte stream"
+        );
+        check_streams(&resolve, worlds["jobs"]).expect("whole stream results are supported");
+        check_futures(&resolve, worlds["jobs"]).expect("whole future results are supported");
+
+        let err = check_futures(&resolve, worlds["nesting"]).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "function `nested.batches` returns a future inside another type, but futures are \
+             only supported as the whole result of a synchronous function"
+        );
+
+        let err = check_futures(&resolve, worlds["lending"]).unwrap_err();
+        assert!(
+            matches!(&err, Error::FutureBorrow { position, .. } if position == "parameter `job`"),
+            "futures may not borrow, got: {err}"
+        );
+
+        let err = check_streams(&resolve, worlds["pipes"]).unwrap_err();
+        assert!(
+            err.to_string().starts_with(
+                "function `piping.pipe` takes a stream in parameter `data` but outlives the call"
+            ),
+            "byte stream parameters must not outlive the call, got: {err}"
+        );
+    }
+
+    #[test]
+    fn test_add_batch_functions() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "uris.wit",
+                r#"
+                    package test:uris;
+
+                    interface parser {
+                        parse: func(input: string) -> result<u32, string>;
+                        join: func(base: string, path: string) -> string;
+                        ticks: func() -> stream<u32>;
+                    }
+
+                    world uris {
+                        export parser;
+                        export log: func(line: string);
+                    }
+                "#,
+            )
+            .expect("failed to parse uris WIT");
+        let world_id = resolve.packages[pkg_id].worlds["uris"];
+
+        let batched = ["parser.parse".to_string(), "log".to_string()];
+        add_batch_functions(&mut resolve, world_id, &batched).expect("batchable functions");
+        // Preparing the same world again changes nothing
+        add_batch_functions(&mut resolve, world_id, &batched).expect("already batched");
+
+        let funcs = exported_functions(&resolve, world_id);
+        let names: Vec<String> = funcs.iter().map(|ef| ef.qualified_name(&resolve)).collect();
+        assert_eq!(
+            names,
+            [
+                "parser.parse",
+                "parser.join",
+                "parser.ticks",
+                "parser.parse-batch",
+                "log",
+                "log-batch"
+            ]
+        );
+        let parse_batch = &funcs[3];
+        assert_eq!(
+            parse_batch
+                .batch_of(&resolve, &funcs)
+                .map(|ef| ef.function_name.as_str()),
+            Some("parse")
+        );
+        assert_eq!(
+            type_shape_name(&resolve, &parse_batch.function.result.unwrap()),
+            "ListResultU32String"
+        );
+        assert!(funcs[0].batch_of(&resolve, &funcs).is_none());
+
+        for (function, reason) in [
+            ("parser.ticks", "it returns a stream or a future"),
+            ("parser.missing", "the world exports no such function"),
+        ] {
+            let err =
+                add_batch_functions(&mut resolve, world_id, &[function.to_string()]).unwrap_err();
+            assert_eq!(
+                err.to_string(),
+                format!("cannot batch `{function}`: {reason}")
+            );
+        }
+    }
+
+    #[test]
+    fn test_skip_functions() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "uris.wit",
+                r#"
+                    package test:uris;
+
+                    interface parser {
+                        resource uri {
+                            constructor(text: string);
+                            host: func() -> string;
+                        }
+                        parse: func(input: string) -> result<u32, string>;
+                    }
+
+                    world uris {
+                        export parser;
+                        export log: func(line: string);
+                    }
+                "#,
+            )
+            .expect("failed to parse uris WIT");
+        let world_id = resolve.packages[pkg_id].worlds["uris"];
+
+        let skipped = ["parser.uri.host".to_string(), "log".to_string()];
+        skip_functions(&mut resolve, world_id, &skipped).expect("exported functions");
+        let names: Vec<String> = exported_functions(&resolve, world_id)
+            .iter()
+            .map(|ef| ef.qualified_name(&resolve))
+            .collect();
+        assert_eq!(names, ["parser.uri.new", "parser.parse"]);
+
+        let err = skip_functions(&mut resolve, world_id, &skipped).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "cannot skip `parser.uri.host`: the world exports no such function"
+        );
+    }
+
+    #[test]
+    fn test_filter_interfaces() {
+        let source = r#"
+            package test:big;
+
+            interface parser {
+                parse: func(input: string) -> u32;
+            }
+
+            interface render {
+                page: func(id: u32) -> string;
+            }
+
+            interface admin {
+                reset: func();
+            }
+
+            world big {
+                export parser;
+                export render;
+                export admin;
+                export version: func() -> string;
+            }
+        "#;
+        let load = || {
+            let mut resolve = Resolve::default();
+            let pkg_id = resolve
+                .push_str("big.wit", source)
+                .expect("failed to parse big WIT");
+            let world_id = resolve.packages[pkg_id].worlds["big"];
+            (resolve, world_id)
+        };
+        let names = |resolve: &Resolve, world_id| -> Vec<String> {
+            exported_functions(resolve, world_id)
+                .iter()
+                .map(|ef| ef.qualified_name(resolve))
+                .collect()
+        };
+
+        let (mut resolve, world_id) = load();
+        let only = ["parser".to_string(), "render".to_string()];
+        filter_interfaces(&mut resolve, world_id, &only, &[]).expect("exported interfaces");
+        assert_eq!(
+            names(&resolve, world_id),
+            ["parser.parse", "render.page", "version"]
+        );
+
+        let (mut resolve, world_id) = load();
+        filter_interfaces(&mut resolve, world_id, &[], &["admin".to_string()])
+            .expect("exported interfaces");
+        assert_eq!(
+            names(&resolve, world_id),
+            ["parser.parse", "render.page", "version"]
+        );
+
+        let err =
+            filter_interfaces(&mut resolve, world_id, &[], &["admin".to_string()]).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "cannot filter `admin`: the world exports no such interface"
+        );
+    }
+
+    #[test]
+    fn test_abi_hash() {
+        let hash = |source: &str| {
+            let mut resolve = Resolve::default();
+            let pkg_id = resolve
+                .push_str("shapes.wit", source)
+                .expect("failed to parse shapes WIT");
+            let world_id = resolve.packages[pkg_id].worlds["shapes"];
+            abi_hash(&resolve, world_id)
+        };
+        let base = hash(
+            r#"
+            package test:shapes;
+
+            interface geometry {
+                record point {
+                    x: u32,
+                    y: u32,
+                }
+
+                translate: func(p: point, dx: u32) -> point;
+            }
+
+            world shapes {
+                export geometry;
+            }
+        "#,
+        );
+        let documented = hash(
+            r#"
+            package test:shapes;
+
+            interface geometry {
+                /// A point on the plane.
+                record point {
+                    x: u32,
+                    y: u32,
+                }
+
+                /// Move a point to the right.
+                translate: func(q: point, offset: u32) -> point;
+            }
+
+            world shapes {
+                export geometry;
+            }
+        "#,
+        );
+        let widened = hash(
+            r#"
+            package test:shapes;
+
+            interface geometry {
+                record point {
+                    x: u64,
+                    y: u32,
+                }
+
+                translate: func(p: point, dx: u32) -> point;
+            }
+
+            world shapes {
+                export geometry;
+            }
+        "#,
+        );
+        assert_eq!(base, documented, "docs and parameter names are not ABI");
+        assert_ne!(base, widened, "field types are ABI");
+    }
 }
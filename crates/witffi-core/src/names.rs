@@ -13,6 +13,12 @@
 //! - Kotlin functions/properties: `camelCase` (e.g. `transactionRequest`)
 //! - Go types: `PascalCase` (exported) (e.g. `TransactionRequest`)
 //! - Go functions: `PascalCase` (exported) (e.g. `TransactionRequest`)
+//!
+//! Go names can also be shaped by a [`GoNaming`], which writes initialisms
+//! in all caps (e.g. `ChainID`), takes explicit names for some WIT names and
+//! chooses how reserved words are escaped.
+
+use std::collections::{HashMap, HashSet};
 
 use heck::{ToLowerCamelCase, ToPascalCase, ToShoutySnakeCase, ToSnakeCase};
 
@@ -46,6 +52,21 @@ pub fn to_c_enum_variant(prefix: &str, name: &str) -> String {
     )
 }
 
+/// Derive a C symbol prefix from a WIT package id (e.g. `zcash:eip681@0.1.0`
+/// becomes `zcash_eip681_v0_1_0`), so that the symbols of bindings generated
+/// from different packages do not collide in one program.
+pub fn to_c_prefix(namespace: &str, name: &str, version: Option<&str>) -> String {
+    let mut prefix = format!("{}_{}", namespace.to_snake_case(), name.to_snake_case());
+    if let Some(version) = version {
+        prefix.push_str("_v");
+        prefix.extend(version.chars().map(|c| match c.is_ascii_alphanumeric() {
+            true => c.to_ascii_lowercase(),
+            false => '_',
+        }));
+    }
+    prefix
+}
+
 /// Convert a WIT kebab-case identifier to Swift PascalCase (for types).
 pub fn to_swift_type(name: &str) -> String {
     name.to_pascal_case()
@@ -86,6 +107,14 @@ pub fn to_go_field(name: &str) -> String {
     escape_go_keyword(&pascal)
 }
 
+/// Convert a WIT kebab-case identifier to a Go package name, which is
+/// lowercase without separators (e.g. `wallet-client` becomes
+/// `walletclient`).
+pub fn to_go_package(name: &str) -> String {
+    let package = name.to_snake_case().replace('_', "");
+    escape_go_keyword(&package)
+}
+
 /// Convert a WIT kebab-case identifier to Go camelCase (unexported identifiers).
 ///
 /// Used for marker interface names, conversion function parameters, and
@@ -95,6 +124,148 @@ pub fn to_go_ident(name: &str) -> String {
     escape_go_keyword(&camel)
 }
 
+/// Go's common initialisms, as listed by `golint`.
+pub const GO_INITIALISMS: &[&str] = &[
+    "acl", "api", "ascii", "cpu", "css", "dns", "eof", "guid", "html", "http", "https", "id", "ip",
+    "json", "lhs", "qps", "ram", "rhs", "rpc", "sla", "smtp", "sql", "ssh", "tcp", "tls", "ttl",
+    "udp", "ui", "uid", "uuid", "uri", "url", "utf8", "vm", "xml", "xmpp", "xsrf", "xss",
+];
+
+/// How WIT identifiers become Go names.
+///
+/// The default converts names exactly like [`to_go_type`] and
+/// [`to_go_ident`].
+#[derive(Debug, Clone)]
+pub struct GoNaming {
+    /// Words written in all caps wherever they appear in a name, in
+    /// lowercase (e.g. "id" turns `chain-id` into `ChainID`, or `chainID`
+    /// unexported). A word ending in digits also matches without them, so
+    /// "eip" turns `eip681` into `EIP681`.
+    pub initialisms: HashSet<String>,
+
+    /// Go names to use for WIT names, keyed by WIT name (e.g. "chain-id" =
+    /// "ChainId"). They apply wherever the WIT name is converted, including
+    /// as part of a longer Go name such as a variant case's. Unexported
+    /// identifiers lower the override's leading capitals (`chainId`).
+    pub overrides: HashMap<String, String>,
+
+    /// Appended to names that are Go keywords or predeclared identifiers
+    /// (e.g. `type` becomes `type_`).
+    pub keyword_suffix: String,
+}
+
+impl Default for GoNaming {
+    fn default() -> Self {
+        Self {
+            initialisms: HashSet::new(),
+            overrides: HashMap::new(),
+            keyword_suffix: "_".to_string(),
+        }
+    }
+}
+
+impl GoNaming {
+    /// Convert a WIT identifier to a Go exported type name.
+    pub fn to_go_type(&self, name: &str) -> String {
+        self.escape(self.pascal(name))
+    }
+
+    /// Convert a WIT identifier to a Go exported function name. Names
+    /// joined with `_` (e.g. `parser_parse`) are converted part by part.
+    pub fn to_go_func(&self, name: &str) -> String {
+        self.escape(self.pascal(name))
+    }
+
+    /// Convert a WIT identifier to a Go exported struct field name.
+    pub fn to_go_field(&self, name: &str) -> String {
+        self.escape(self.pascal(name))
+    }
+
+    /// Convert a WIT identifier to a Go unexported identifier, such as a
+    /// parameter name.
+    pub fn to_go_ident(&self, name: &str) -> String {
+        let mut parts = name.split('_');
+        let mut camel = match parts.next() {
+            Some(first) => match self.overrides.get(first) {
+                Some(go) => lower_leading_capitals(go),
+                None => {
+                    let snake = first.to_snake_case();
+                    let mut words = snake.split('_');
+                    let head = words.next().unwrap_or_default().to_string();
+                    head + &words.map(|word| self.word(word)).collect::<String>()
+                }
+            },
+            None => String::new(),
+        };
+        for part in parts {
+            camel.push_str(&self.part(part));
+        }
+        self.escape(camel)
+    }
+
+    /// The PascalCase Go name of `name`, part by part.
+    fn pascal(&self, name: &str) -> String {
+        name.split('_').map(|part| self.part(part)).collect()
+    }
+
+    /// The PascalCase Go name of one WIT name.
+    fn part(&self, part: &str) -> String {
+        if let Some(go) = self.overrides.get(part) {
+            return go.clone();
+        }
+        part.to_snake_case()
+            .split('_')
+            .map(|word| self.word(word))
+            .collect()
+    }
+
+    /// A lowercase word, capitalized, or in all caps if it is an initialism.
+    fn word(&self, word: &str) -> String {
+        let letters = word.trim_end_matches(|c: char| c.is_ascii_digit());
+        if self.initialisms.contains(word) || self.initialisms.contains(letters) {
+            return word.to_uppercase();
+        }
+        let mut chars = word.chars();
+        match chars.next() {
+            Some(first) => first.to_uppercase().chain(chars).collect(),
+            None => String::new(),
+        }
+    }
+
+    /// Append the keyword suffix to `name` if it is reserved in Go.
+    fn escape(&self, name: String) -> String {
+        if escape_go_keyword(&name) == name {
+            name
+        } else {
+            name + &self.keyword_suffix
+        }
+    }
+}
+
+/// Lower the leading capitals of a Go name, leaving the last of them raised
+/// when it starts a word (e.g. `URLParser` becomes `urlParser`, and
+/// `ChainID` `chainID`).
+fn lower_leading_capitals(name: &str) -> String {
+    let chars: Vec<char> = name.chars().collect();
+    let run = chars.iter().take_while(|c| c.is_uppercase()).count();
+    let lowered = if run > 1 && chars.get(run).is_some_and(|c| c.is_lowercase()) {
+        run - 1
+    } else {
+        run
+    };
+    chars
+        .iter()
+        .enumerate()
+        .map(|(i, c)| {
+            if i < lowered {
+                c.to_ascii_lowercase()
+            } else {
+                *c
+            }
+        })
+        .collect()
+}
+
 /// Escape Go reserved keywords and predeclared identifiers by appending `_`.
 fn escape_go_keyword(name: &str) -> String {
     match name {
@@ -193,6 +364,10 @@ mod tests {
             to_c_enum_variant("TRANSACTION_REQUEST", "native"),
             "TRANSACTION_REQUEST_NATIVE"
         );
+        assert_eq!(to_c_prefix("zcash", "eip681", None), "zcash_eip681");
+        let prefix = to_c_prefix("my-org", "http-client", Some("0.2.0-rc.1"));
+        assert_eq!(prefix, "my_org_http_client_v0_2_0_rc_1");
+        assert_eq!(to_c_func(&prefix, "get"), format!("{prefix}_get"));
     }
 
     #[test]
@@ -243,5 +418,32 @@ mod tests {
         assert_eq!(to_go_ident("error"), "error_");
         // Non-keywords pass through
         assert_eq!(to_go_ident("foo-bar"), "fooBar");
+        // Package names
+        assert_eq!(to_go_package("eip681"), "eip681");
+        assert_eq!(to_go_package("wallet-client"), "walletclient");
+    }
+    #[test]
+    fn test_go_naming() {
+        let naming = GoNaming {
+            initialisms: GO_INITIALISMS.iter().map(|s| s.to_string()).collect(),
+            overrides: HashMap::from([("eip681".to_string(), "EIP681".to_string())]),
+            keyword_suffix: "Value".to_string(),
+        };
+        assert_eq!(naming.to_go_field("chain-id"), "ChainID");
+        assert_eq!(naming.to_go_type("request-uri"), "RequestURI");
+        assert_eq!(naming.to_go_type("utf8-string"), "UTF8String");
+        assert_eq!(naming.to_go_ident("chain-id"), "chainID");
+        assert_eq!(naming.to_go_ident("id-kind"), "idKind");
+        // Overrides, also within joined names
+        assert_eq!(naming.to_go_func("eip681_parse"), "EIP681Parse");
+        assert_eq!(naming.to_go_ident("eip681"), "eip681");
+        // Keyword escaping
+        assert_eq!(naming.to_go_ident("type"), "typeValue");
+        // The default matches the plain conversion
+        let naming = GoNaming::default();
+        for name in ["chain-id", "u256", "a-b-cd", "transaction-request", "type"] {
+            assert_eq!(naming.to_go_type(name), to_go_type(name));
+            assert_eq!(naming.to_go_ident(name), to_go_ident(name));
+        }
     }
 }
@@ -0,0 +1,227 @@
+//! The gRPC service of a world, for callers reaching a library over the
+//! network.
+//!
+//! [`generate_proto`] defines the service in proto3: one RPC per function
+//! passing plain values (see [`plain_signature`]), taking a request message
+//! with a field per parameter and returning a response message whose
+//! `value` field is the result, if there is one. The error of a
+//! `result<T, string>` is returned as a status of code
+//! [`ERROR_CODE`] with the string as its message, and a call that panics
+//! fails with [`PANIC_CODE`].
+
+use heck::{ToPascalCase, ToSnakeCase};
+use snafu::prelude::*;
+use wit_parser::{Resolve, Type, WorldId};
+
+use crate::{
+    Error, ExportedFunction, ImportResult, ImportValue, ProtoPackageSnafu, exported_functions,
+    plain_signature,
+};
+
+/// The status code of a call whose function returned an error: `UNKNOWN`.
+pub const ERROR_CODE: i32 = 2;
+
+/// The status code of a call that panicked: `INTERNAL`.
+pub const PANIC_CODE: i32 = 13;
+
+/// The proto package of the service: the WIT package's namespace and name,
+/// and its version if it has one (e.g. `zcash.eip681.v0_1_0`).
+///
+/// # Errors
+///
+/// Returns an error if the world belongs to no package.
+pub fn package_name(resolve: &Resolve, world_id: WorldId) -> Result<String, Error> {
+    let world = &resolve.worlds[world_id];
+    let package = world
+        .package
+        .context(ProtoPackageSnafu { world: &world.name })?;
+    let name = &resolve.packages[package].name;
+    let mut parts = vec![name.namespace.to_snake_case(), name.name.to_snake_case()];
+    if let Some(version) = &name.version {
+        parts.push(format!("v{}", version.to_string().to_snake_case()));
+    }
+    Ok(parts.join("."))
+}
+
+/// The name of the service: the world's, in PascalCase.
+pub fn service_name(resolve: &Resolve, world_id: WorldId) -> String {
+    resolve.worlds[world_id].name.to_pascal_case()
+}
+
+/// The name of the RPC calling `ef`: its interface's name and its own, in
+/// PascalCase (e.g. `HasherHash`).
+pub fn rpc_name(ef: &ExportedFunction) -> String {
+    if ef.interface_name.is_empty() {
+        ef.function_name.to_pascal_case()
+    } else {
+        format!("{}-{}", ef.interface_name, ef.function_name).to_pascal_case()
+    }
+}
+
+/// The path gRPC calls `ef` by (e.g. `/test.hashing.Hashing/HasherHash`),
+/// in the proto package `package`.
+pub fn method_path(
+    resolve: &Resolve,
+    world_id: WorldId,
+    package: &str,
+    ef: &ExportedFunction,
+) -> String {
+    format!(
+        "/{package}.{}/{}",
+        service_name(resolve, world_id),
+        rpc_name(ef)
+    )
+}
+
+/// The name of the field of a parameter named `name`.
+pub fn field_name(name: &str) -> String {
+    name.to_snake_case()
+}
+
+/// The proto type of `value`. Integers narrower than 32 bits widen to
+/// 32.
+pub fn field_type(value: ImportValue) -> &'static str {
+    match value {
+        ImportValue::Scalar(Type::Bool) => "bool",
+        ImportValue::Scalar(Type::U8 | Type::U16 | Type::U32) => "uint32",
+        ImportValue::Scalar(Type::S8 | Type::S16 | Type::S32) => "int32",
+        ImportValue::Scalar(Type::U64) => "uint64",
+        ImportValue::Scalar(Type::S64) => "int64",
+        ImportValue::Scalar(Type::F32) => "float",
+        ImportValue::Scalar(Type::F64) => "double",
+        ImportValue::String => "string",
+        // Rejected by `plain_signature`, but for `list<u8>`
+        _ => "bytes",
+    }
+}
+
+/// The proto3 definition of the service of `world_id`, for the functions
+/// of it passing plain values.
+///
+/// # Errors
+///
+/// Returns an error if the world belongs to no package.
+pub fn generate_proto(resolve: &Resolve, world_id: WorldId) -> Result<String, Error> {
+    let package = package_name(resolve, world_id)?;
+    let service = service_name(resolve, world_id);
+    let funcs: Vec<_> = exported_functions(resolve, world_id)
+        .into_iter()
+        .filter_map(|ef| plain_signature(resolve, &ef).map(|sig| (ef, sig)))
+        .collect();
+
+    let mut out = String::new();
+    out.push_str("// Code generated by witffi. DO NOT EDIT.\n\n");
+    out.push_str("syntax = \"proto3\";\n\n");
+    out.push_str(&format!("package {package};\n\n"));
+    out.push_str(&format!(
+        "// The functions of world `{}`. A function returning an error fails with\n",
+        resolve.worlds[world_id].name
+    ));
+    out.push_str("// status UNKNOWN and the error as message, and one that panics with\n");
+    out.push_str("// INTERNAL.\n");
+    out.push_str(&format!("service {service} {{\n"));
+    for (ef, _) in &funcs {
+        let rpc = rpc_name(ef);
+        if let Some(docs) = &ef.function.docs.contents {
+            for line in docs.lines() {
+                out.push_str(format!("  // {line}").trim_end());
+                out.push('\n');
+            }
+        }
+        out.push_str(&format!(
+            "  rpc {rpc}({rpc}Request) returns ({rpc}Response);\n"
+        ));
+    }
+    out.push_str("}\n");
+
+    for (ef, sig) in &funcs {
+        let rpc = rpc_name(ef);
+        out.push_str(&format!("\nmessage {rpc}Request {{\n"));
+        for (number, (name, value)) in sig.params.iter().enumerate() {
+            out.push_str(&format!(
+                "  {} {} = {};\n",
+                field_type(*value),
+                field_name(name),
+                number + 1
+            ));
+        }
+        out.push_str("}\n");
+        out.push_str(&format!("\nmessage {rpc}Response {{\n"));
+        if let ImportResult::Value(value) | ImportResult::Fallible(Some(value)) = sig.result {
+            out.push_str(&format!("  {} value = 1;\n", field_type(value)));
+        }
+        out.push_str("}\n");
+    }
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_generate_proto() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "hashing.wit",
+                r#"
+package test:hashing@0.2.0;
+
+interface hasher {
+    resource state {
+        update: func(data: list<u8>);
+    }
+
+    /// Hash `data` over and over.
+    hash: func(data: list<u8>, rounds: u8) -> list<u8>;
+    describe: func(full-name: string) -> result<_, string>;
+}
+
+world hashing {
+    export hasher;
+    export version: func() -> f64;
+}
+"#,
+            )
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+        let funcs = exported_functions(&resolve, world_id);
+        let hash = funcs
+            .iter()
+            .find(|ef| ef.function.name == "hash")
+            .expect("the world exports hash");
+
+        let package = package_name(&resolve, world_id).expect("the world has a package");
+        assert_eq!(package, "test.hashing.v0_2_0");
+        assert_eq!(
+            method_path(&resolve, world_id, &package, hash),
+            "/test.hashing.v0_2_0.Hashing/HasherHash"
+        );
+
+        let proto = generate_proto(&resolve, world_id).expect("failed to generate the proto");
+        assert!(
+            proto.contains(
+                "service Hashing {\n  // Hash `data` over and over.\n  rpc HasherHash(HasherHashRequest) \
+                 returns (HasherHashResponse);\n"
+            ) && proto.contains("  rpc Version(VersionRequest) returns (VersionResponse);\n"),
+            "every plain function should have an RPC"
+        );
+        assert!(
+            proto.contains(
+                "message HasherHashRequest {\n  bytes data = 1;\n  uint32 rounds = 2;\n}\n"
+            ) && proto.contains("message HasherHashResponse {\n  bytes value = 1;\n}\n"),
+            "parameters and results should be fields of the messages"
+        );
+        assert!(
+            proto.contains("message HasherDescribeRequest {\n  string full_name = 1;\n}\n")
+                && proto.contains("message HasherDescribeResponse {\n}\n")
+                && proto.contains("message VersionResponse {\n  double value = 1;\n}\n"),
+            "errors should not be fields of the response"
+        );
+        assert!(
+            !proto.contains("Update"),
+            "resource functions should not be served"
+        );
+    }
+}
@@ -0,0 +1,203 @@
+//! Where the items of a WIT package are defined, for generated code to point
+//! back to.
+//!
+//! wit-parser keeps no source positions on the items it resolves, so
+//! [`wit_locations`] scans the WIT files for the definitions themselves:
+//! every type, function, resource method and constructor, by the qualified
+//! name configuration uses (e.g. `shop.item` or `types.counter.get`).
+
+use std::collections::HashMap;
+use std::path::Path;
+
+/// The keywords introducing a named type.
+const TYPE_KEYWORDS: &[&str] = &["record", "variant", "enum", "flags", "resource", "type"];
+
+/// Where a WIT item is defined.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SourceLocation {
+    /// The WIT file, relative to the WIT directory (e.g. `parser.wit` or
+    /// `deps/types/types.wit`), with `/` separators.
+    pub file: String,
+
+    /// The 1-based line of the item's name.
+    pub line: usize,
+}
+
+impl std::fmt::Display for SourceLocation {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}:{}", self.file, self.line)
+    }
+}
+
+/// The definitions in the WIT at `wit` (a file or a package directory) and
+/// its `deps/`, by qualified name: `{interface}.{item}`, or
+/// `{interface}.{resource}.{function}` for resource functions (the
+/// constructor being `new`), and the bare name of items defined in a world.
+///
+/// Files that cannot be read are skipped. A name defined by several files
+/// (e.g. an interface of a dependency sharing a name with one of the
+/// package) is located in the first.
+pub fn wit_locations(wit: &Path) -> HashMap<String, SourceLocation> {
+    let root = if wit.is_dir() {
+        wit
+    } else {
+        wit.parent().unwrap_or(Path::new(""))
+    };
+    let mut locations = HashMap::new();
+    for path in crate::diagnostics::wit_files(wit) {
+        let Ok(source) = std::fs::read_to_string(&path) else {
+            continue;
+        };
+        let relative = path.strip_prefix(root).unwrap_or(&path);
+        let file = relative
+            .components()
+            .map(|component| component.as_os_str().to_string_lossy())
+            .collect::<Vec<_>>()
+            .join("/");
+        for (name, line) in definitions(&source) {
+            locations.entry(name).or_insert_with(|| SourceLocation {
+                file: file.clone(),
+                line,
+            });
+        }
+    }
+    locations
+}
+
+/// The qualified names `source` defines, with the line of each.
+fn definitions(source: &str) -> Vec<(String, usize)> {
+    let mut definitions = Vec::new();
+    // The interfaces and resources the braces being read open, or None for
+    // worlds and type bodies, which do not qualify names
+    let mut scopes: Vec<Option<String>> = Vec::new();
+    // The scope the next `{` opens
+    let mut opening: Option<String> = None;
+    let qualified = |scopes: &[Option<String>], name: &str| {
+        scopes
+            .iter()
+            .flatten()
+            .map(String::as_str)
+            .chain([name])
+            .collect::<Vec<_>>()
+            .join(".")
+    };
+
+    for (index, line) in source.lines().enumerate() {
+        let code = line.split("//").next().unwrap_or_default();
+        let tokens = tokens(code);
+        for (i, token) in tokens.iter().enumerate() {
+            let next = tokens.get(i + 1).copied().unwrap_or_default();
+            let name = next.trim_start_matches('%');
+            match *token {
+                "{" => scopes.push(opening.take()),
+                "}" => {
+                    scopes.pop();
+                }
+                ";" => opening = None,
+                "interface" if is_name(next) => opening = Some(name.to_string()),
+                "world" if is_name(next) => opening = None,
+                keyword if TYPE_KEYWORDS.contains(&keyword) && is_name(next) => {
+                    definitions.push((qualified(&scopes, name), index + 1));
+                    if keyword == "resource" {
+                        opening = Some(name.to_string());
+                    }
+                }
+                "constructor" if next == "(" => {
+                    definitions.push((qualified(&scopes, "new"), index + 1));
+                }
+                item if is_name(item) && next == ":" => {
+                    let item = item.trim_start_matches('%');
+                    let kind = tokens[i + 2..]
+                        .iter()
+                        .find(|token| !matches!(**token, "static" | "async"))
+                        .copied();
+                    match kind {
+                        Some("func") => definitions.push((qualified(&scopes, item), index + 1)),
+                        // An interface defined inline in a world
+                        Some("interface") => opening = Some(item.to_string()),
+                        _ => {}
+                    }
+                }
+                _ => {}
+            }
+        }
+    }
+    definitions
+}
+
+/// The identifiers and punctuation of a line of WIT.
+fn tokens(code: &str) -> Vec<&str> {
+    let is_ident = |c: char| c.is_alphanumeric() || c == '-' || c == '_' || c == '%';
+    let mut tokens = Vec::new();
+    let mut rest = code.trim_start();
+    while let Some(c) = rest.chars().next() {
+        let len = if is_ident(c) {
+            rest.find(|c: char| !is_ident(c)).unwrap_or(rest.len())
+        } else {
+            c.len_utf8()
+        };
+        tokens.push(&rest[..len]);
+        rest = rest[len..].trim_start();
+    }
+    tokens
+}
+
+/// Whether `token` is a WIT identifier.
+fn is_name(token: &str) -> bool {
+    token.starts_with(|c: char| c.is_alphabetic() || c == '%')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_definitions() {
+        let source = "\
+package test:shop;
+
+interface types {
+    /// A priced item.
+    record item {
+        price: u32,
+    }
+
+    resource counter {
+        constructor(start: u32);
+        get: func() -> u32;
+        %reset: static func();
+    }
+    resource token;
+}
+
+world store {
+    use types.{item};
+    export find: func(name: string) -> item;
+    export orders: interface {
+        place: async func(item: item);
+    }
+}
+";
+        assert_eq!(
+            definitions(source),
+            [
+                ("types.item".to_string(), 5),
+                ("types.counter".to_string(), 9),
+                ("types.counter.new".to_string(), 10),
+                ("types.counter.get".to_string(), 11),
+                ("types.counter.reset".to_string(), 12),
+                ("types.token".to_string(), 14),
+                ("find".to_string(), 19),
+                ("orders.place".to_string(), 21),
+            ]
+        );
+        assert_eq!(
+            SourceLocation {
+                file: "shop.wit".to_string(),
+                line: 5,
+            }
+            .to_string(),
+            "shop.wit:5"
+        );
+    }
+}
@@ -0,0 +1,85 @@
+//! A Go generator plugin returning a company-wide error type.
+//!
+//! Every error an exported function returns is wrapped in a `*corperr.Error`
+//! naming the WIT function, so callers handle the library's errors like any
+//! other of the company's services. Run it as a small generator in place of
+//! `witffi generate --lang go`:
+//!
+//! ```sh
+//! cargo run -p witffi-go --example error_plugin -- wit/eip681.wit > bindings.go
+//! ```
+//!
+//! The bindings then import `example.com/corp/corperr`, a package like:
+//!
+//! ```go
+//! package corperr
+//!
+//! type Error struct {
+//!     Op  string
+//!     Err error
+//! }
+//!
+//! func (e *Error) Error() string { return e.Op + ": " + e.Err.Error() }
+//! func (e *Error) Unwrap() error { return e.Err }
+//! ```
+
+use std::path::PathBuf;
+use std::sync::Arc;
+
+use witffi_core::names;
+use witffi_go::generate::GoConfig;
+use witffi_go::{GoFunction, GoGenerator, GoPlugin};
+
+/// The Go package of the company's error type.
+const CORPERR: &str = "example.com/corp/corperr";
+
+/// Wraps the errors of every exported function in a `*corperr.Error`.
+#[derive(Debug)]
+struct CorpErrors;
+
+impl GoPlugin for CorpErrors {
+    fn imports(&self) -> Vec<String> {
+        vec![CORPERR.to_string()]
+    }
+
+    fn wrap_error(&self, function: &GoFunction, err: &str) -> Option<String> {
+        Some(format!(
+            "&corperr.Error{{Op: \"{}\", Err: {err}}}",
+            function.wit_name
+        ))
+    }
+
+    fn rewrite_function(&self, function: &GoFunction, code: String) -> String {
+        // Document the wrapping on each wrapper returning errors
+        if !code.contains("corperr.Error{") {
+            return code;
+        }
+        let signature = format!("\nfunc {}(", function.go_name);
+        code.replacen(
+            &signature,
+            &format!("\n// Errors are returned as *corperr.Error.{signature}"),
+            1,
+        )
+    }
+}
+
+fn main() -> Result<(), Box<dyn std::error::Error>> {
+    let Some(wit) = std::env::args_os().nth(1).map(PathBuf::from) else {
+        return Err("usage: error_plugin <wit> [world]".into());
+    };
+    let world = std::env::args().nth(2);
+
+    let (resolve, pkg_id) = witffi_core::load_wit_package(&wit)?;
+    let world_id = witffi_core::select_world(&resolve, pkg_id, world.as_deref())?;
+    // The symbol prefix `witffi generate` derives for the Rust side
+    let package = &resolve.packages[pkg_id].name;
+    let version = package.version.as_ref().map(ToString::to_string);
+    let config = GoConfig {
+        c_prefix: names::to_c_prefix(&package.namespace, &package.name, version.as_deref()),
+        plugins: vec![Arc::new(CorpErrors)],
+        ..GoConfig::default()
+    };
+    let code = GoGenerator::new(&resolve, world_id, config).generate()?;
+    print!("{code}");
+    Ok(())
+}
@@ -5,18 +5,54 @@
 //! 2. Helper functions for `FfiByteBuffer`/`FfiByteSlice` marshalling
 //! 3. Go structs for WIT records
 //! 4. Go interfaces + concrete types for WIT variants
-//! 5. Go typed constants for WIT enums and flags
-//! 6. Conversion functions (C struct -> Go type)
-//! 7. Public API functions that call the C-ABI layer
+//! 5. Go typed constants for WIT enums and flags, and generic `TupleOfN` structs
+//! 6. Go handle wrappers for WIT resources
+//! 7. Conversion functions (C struct -> Go type)
+//! 8. Public API functions (and resource methods) that call the C-ABI layer
 
-use std::collections::HashSet;
+use std::collections::{HashMap, HashSet};
 use std::fmt::Write;
+use std::sync::Arc;
+use std::time::Duration;
 
 use heck::ToSnakeCase;
 use snafu::prelude::*;
-use wit_parser::{Resolve, Type, TypeDefKind, TypeId, WorldId};
+use wit_parser::{
+    Field, Handle, InterfaceId, Resolve, Type, TypeDef, TypeDefKind, TypeId, TypeOwner, WorldId,
+};
 
-use witffi_core::{ExportedFunction, exported_functions, names};
+use witffi_core::{
+    ExportedFunction, ImportResult, ImportSignature, ImportValue, WideInt, canonical,
+    deprecated_version, exported_functions, import_signature, imported_functions, ipc, names,
+    proto, source_map::SourceLocation,
+};
+
+use crate::plugin::{GoFunction, GoPlugin};
+
+/// The standard library packages `generate_imports` may import, which the
+/// files of `@unstable` features import those they use from.
+const STD_IMPORTS: &[&str] = &[
+    "context",
+    "encoding/binary",
+    "errors",
+    "fmt",
+    "io",
+    "iter",
+    "log/slog",
+    "math/big",
+    "os",
+    "runtime",
+    "runtime/cgo",
+    "runtime/debug",
+    "runtime/pprof",
+    "sort",
+    "strings",
+    "sync",
+    "sync/atomic",
+    "time",
+    "unicode/utf8",
+    "unsafe",
+];
 
 /// Errors that can occur during Go code generation.
 #[derive(Debug, Snafu)]
@@ -24,6 +60,63 @@ pub enum Error {
     /// A write to the output buffer failed.
     #[snafu(display("code generation write error"))]
     Write { source: std::fmt::Error },
+
+    /// A timeout was configured for a function the world does not export,
+    /// or whose wrapper returns before the call finishes.
+    #[snafu(display(
+        "cannot time out `{function}`: {reason}; timeouts apply to exported functions that \
+         return neither a stream nor a future and take no `stream<u8>`"
+    ))]
+    Timeout { function: String, reason: String },
+
+    /// A function was marked blocking that the world does not export, or
+    /// while every call already runs on the dispatcher thread.
+    #[snafu(display("cannot run `{function}` on the blocking pool: {reason}"))]
+    Blocking { function: String, reason: String },
+
+    /// An interface or resource was marked single-threaded that the world
+    /// does not export.
+    #[snafu(display(
+        "cannot make `{name}` single-threaded: the world exports no such interface or resource"
+    ))]
+    SingleThreaded { name: String },
+
+    /// A function was configured to borrow its string result that the
+    /// world does not export, or whose wrapper cannot return one.
+    #[snafu(display("cannot borrow the result of `{function}`: {reason}"))]
+    BorrowedString { function: String, reason: String },
+
+    /// A function was configured to return a list view that the world does
+    /// not export, or whose wrapper cannot return one.
+    #[snafu(display("cannot return a list view from `{function}`: {reason}"))]
+    ListView { function: String, reason: String },
+
+    /// A function was configured to get an `iter.Seq` variant that the
+    /// world does not export, or whose wrapper cannot have one.
+    #[snafu(display("cannot add an iter.Seq variant of `{function}`: {reason}"))]
+    ListSeq { function: String, reason: String },
+
+    /// A function was exempted from cgo annotations that the world does not
+    /// export.
+    #[snafu(display("cannot leave `{function}` unannotated: the world exports no such function"))]
+    CgoUnannotated { function: String },
+
+    /// Bindings through a backend other than cgo were asked for a function
+    /// or an option only cgo bindings support.
+    #[snafu(display("cannot generate {backend} bindings: {reason}; use the cgo backend"))]
+    Backend {
+        backend: &'static str,
+        reason: String,
+    },
+
+    /// Subpackages were asked for without the import path of the generated
+    /// package, which they import.
+    #[snafu(display("Go subpackages need the import path of the generated package"))]
+    MissingImportPath,
+
+    /// The gRPC service the bindings call cannot be defined.
+    #[snafu(display("cannot define the gRPC service"))]
+    Proto { source: witffi_core::Error },
 }
 
 /// Configuration for the Go generator.
@@ -38,8 +131,314 @@ pub struct GoConfig {
     /// Override the Go package name. If `None`, derived from the WIT world name.
     pub go_package: Option<String>,
 
-    /// Library name for CGo LDFLAGS (e.g. "eip681_ffi").
+    /// Library name for CGo LDFLAGS (e.g. "eip681_ffi"), of the library
+    /// the purego backend loads at run time, or of the module the wazero
+    /// and wasmtime backends embed.
     pub lib_name: String,
+
+    /// How the bindings call into the Rust library.
+    pub backend: GoBackend,
+
+    /// How resource handles are released when the Go wrapper is garbage
+    /// collected without an explicit `Close`.
+    pub resource_cleanup: ResourceCleanup,
+
+    /// Per-resource overrides of `resource_cleanup`, keyed by WIT resource
+    /// name (e.g. "counter").
+    pub resource_cleanup_overrides: HashMap<String, ResourceCleanup>,
+
+    /// Map `option<T>` to a generated generic `Option[T]` instead of `*T`.
+    ///
+    /// Pointers make an empty slice indistinguishable from an absent one
+    /// and force a heap allocation per present value.
+    pub generic_options: bool,
+
+    /// Return tuple results (including the ok value of `result<tuple<..>, E>`)
+    /// as multiple Go return values, e.g. `(string, uint64, error)`, instead
+    /// of a single `TupleOfN` value.
+    pub multi_value_results: bool,
+
+    /// Records, by WIT name (e.g. "parse-result"), that are flattened into
+    /// named Go return values wherever they are a function result, e.g.
+    /// `(value uint64, remainder string, err error)`. The record type is
+    /// still generated.
+    pub named_results: HashSet<String>,
+
+    /// Give generated `Stream[T]` values an `All` method returning an
+    /// `iter.Seq[T]`, which needs Go 1.23+.
+    pub stream_iterators: bool,
+
+    /// Prepend a `ctx context.Context` parameter to every exported function.
+    /// While a call runs, Rust sees it as cancelled (through
+    /// `witffi_types::is_cancelled`) once `ctx` is done, so long-running
+    /// implementations can return early.
+    pub context_params: bool,
+
+    /// Functions given the `ctx context.Context` parameter of
+    /// `context_params` without it applying to every function, keyed by
+    /// qualified WIT name (e.g. "indexer.rebuild").
+    pub context_functions: HashSet<String>,
+
+    /// Deadlines for individual exported functions, keyed by qualified WIT
+    /// name (e.g. "parser.parse" or "types.counter.get"). Rust sees a call
+    /// as cancelled once its deadline passes; a call that can fail also
+    /// returns `ErrTimeout` right away, leaving the Rust call to finish on
+    /// its own.
+    pub timeouts: HashMap<String, Duration>,
+
+    /// Run every call into Rust on one dedicated OS thread, through a
+    /// dispatcher goroutine locked to it, for Rust libraries that must only
+    /// be called from a single thread. Callers are unaffected, apart from
+    /// calls being serialized.
+    pub dispatch_thread: bool,
+
+    /// Exported functions known to block for long periods, keyed by
+    /// qualified WIT name (e.g. "db.query"). Calls to them run on a bounded
+    /// pool of worker threads, so many concurrent blocked calls don't each
+    /// hold an OS thread of their own.
+    pub blocking: HashSet<String>,
+
+    /// Exported interfaces (e.g. "parser") and resources (e.g.
+    /// "types.counter") whose Rust implementation is not safe to call from
+    /// several threads at once. Calls into each are serialized by a lock,
+    /// which a resource's handles are also dropped under. A call reentering
+    /// Rust from an import, on the thread holding the lock, passes through.
+    pub single_threaded: HashSet<String>,
+
+    /// Give each wrapper of a function returning a string or `list<u8>` an
+    /// `Into` variant (e.g. `ParserParseInto(input string, dst []byte) (int,
+    /// error)`) that writes the result into a caller-supplied buffer. The C
+    /// library must export the matching `_into` functions.
+    pub into_variants: bool,
+
+    /// Reuse the scratch memory that calls stage arguments and error
+    /// messages in through a `sync.Pool`, instead of allocating it afresh
+    /// on every call.
+    pub buffer_pool: bool,
+
+    /// Pass `list<u8>` values nested in list and optional arguments to Rust
+    /// in place, pinned with a `runtime.Pinner` until the call returns,
+    /// instead of copying them into C memory. Needs Go 1.21+, and the Rust
+    /// side must not keep the pointers past the call.
+    pub pin_bytes: bool,
+
+    /// Stage lowered arguments in chunks of C memory of this many bytes,
+    /// freed together once a call returns, instead of allocating and freeing
+    /// each separately. The bindings then export `ReadArenaStats` for tuning
+    /// the size. Rust lifts arguments into values it owns, so only the Go
+    /// side stages them.
+    pub arena_chunk_size: Option<usize>,
+
+    /// Functions whose string result is borrowed rather than copied, keyed
+    /// by qualified WIT name (e.g. "document.render"). Their `string`
+    /// result, or the string ok value of a `result`, is returned as a
+    /// `BorrowedString` pointing at Rust-owned memory. It stays valid until
+    /// released, explicitly or by a cleanup once the `BorrowedString` is
+    /// unreachable.
+    pub borrowed_strings: HashSet<String>,
+
+    /// Functions whose list result is viewed in place rather than lifted,
+    /// keyed by qualified WIT name (e.g. "ledger.entries"). The list, such
+    /// as a huge `list<record>`, is returned as a `*ListView[T]` left in
+    /// Rust memory, lifting elements one at a time as `At` reads them,
+    /// instead of as a Go slice.
+    pub list_views: HashSet<String>,
+
+    /// Functions whose result is left in Rust memory, keyed by qualified
+    /// WIT name (e.g. "archive.read-all"): added to `list_views` for a
+    /// list result and to `borrowed_strings` otherwise, which only a string
+    /// result may be.
+    pub zero_copy: HashSet<String>,
+
+    /// Freestanding functions given a `Seq` variant of their list-returning
+    /// wrapper, keyed by qualified WIT name (e.g. "math.squares"). The
+    /// variant returns an `iter.Seq[T]` (Go 1.23+), lifting elements as the
+    /// range reaches them, so a caller stopping early skips lifting the
+    /// rest.
+    pub list_seqs: HashSet<String>,
+
+    /// Records, by WIT name, whose `list<record>` results are transferred
+    /// column by column: Rust hands back one array per field, which the
+    /// bindings gather into the same Go slice. Must match the Rust side's
+    /// `columnar` setting.
+    pub columnar: HashSet<String>,
+
+    /// Mark the C functions the bindings call `#cgo noescape` and
+    /// `#cgo nocallback` (Go 1.24+) where that is known to hold, so Go
+    /// values passed by pointer stay off the heap and calls skip the
+    /// callback bookkeeping. That is the error and free helpers, and
+    /// synchronous exported functions taking no `stream<u8>` and returning
+    /// neither a stream nor a future, as long as the world imports nothing
+    /// from Go that Rust could call back into.
+    pub cgo_annotations: bool,
+
+    /// Functions `cgo_annotations` leaves unannotated, keyed by qualified
+    /// WIT name (e.g. "buffer.retain"), such as those whose Rust
+    /// implementation keeps a pointer it is passed.
+    pub cgo_unannotated: HashSet<String>,
+
+    /// Intern lifted strings of at most this many bytes: each distinct value
+    /// is copied into Go memory once, and repeated results (e.g. symbols or
+    /// network names) share it instead of allocating again. The table keeps
+    /// up to 4096 strings for the life of the process; once full, further
+    /// strings are copied as usual.
+    pub intern_strings: Option<usize>,
+
+    /// Free the values Rust boxed for the bindings (e.g. options and
+    /// results) through `freeRustBox`, and generate the build-tagged files
+    /// from [`GoGenerator::generate_alloc_accounting_files`] defining it.
+    /// Built with `-tags witffidebug`, the bindings then free through the
+    /// library's `_free_box` so it can account for the free, and gain
+    /// `AllocStats` and `CheckNoLeaks`. Must match the Rust side's
+    /// `alloc_accounting` setting.
+    pub alloc_accounting: bool,
+
+    /// Free the values Rust boxed for the bindings through the library's
+    /// `_free_box` rather than C `free`, as needed when the library's
+    /// global allocator is the canary-stamping debug allocator. Must match
+    /// the Rust side's `alloc_canaries` setting.
+    pub alloc_canaries: bool,
+
+    /// Generate a test, from [`GoGenerator::generate_cgocheck_test`], that
+    /// lowers a sample value of every list shape passed to Rust, for running
+    /// under `GOEXPERIMENT=cgocheck2` (Go 1.21+; `GODEBUG=cgocheck=2` before).
+    /// Arguments only point at Go memory holding no Go pointers, which cgo
+    /// permits, when passed by value; anything nested is copied into C
+    /// memory or pinned, and the test panics if a lowering ever stores an
+    /// unpinned Go pointer there.
+    pub cgocheck_tests: bool,
+
+    /// Surface a panic in the Rust implementation as an `*ErrForeignPanic`
+    /// carrying the panic message and, in debug builds of the library, its
+    /// backtrace: wrapped in the error of calls returning one, and raised as
+    /// a Go panic by the others, which check for one after every call.
+    pub foreign_panics: bool,
+
+    /// Rebuild error messages serialized by `witffi_types::error_chain` as
+    /// chains of wrapped `*ErrorChain` errors, one per Rust error source.
+    pub error_chains: bool,
+
+    /// Generate `Init(opts ...InitOption)` and `Shutdown()`, setting up and
+    /// tearing down the Rust library's global state through the world
+    /// trait's `init_library` and `shutdown_library`. A world with imports
+    /// takes its `Imports` first, as `Init(imports, opts...)`. Must match
+    /// the Rust side's `lifecycle`.
+    pub lifecycle: bool,
+
+    /// Generate `SetLogger(*slog.Logger)`, forwarding the Rust library's
+    /// `log` records to a Go logger. Must match the Rust side's
+    /// `log_bridge`.
+    pub log_bridge: bool,
+
+    /// Generate `SetCallHooks(CallHooks)`, registering hooks that every
+    /// exported function's wrapper calls before and after the call, for
+    /// metrics, tracing or debugging.
+    pub call_hooks: bool,
+
+    /// Start an OpenTelemetry span for every call of an exported function,
+    /// named after it and recording the error it returns, with the global
+    /// tracer provider. The generated package then depends on
+    /// `go.opentelemetry.io/otel`.
+    pub otel_spans: bool,
+
+    /// Run every call of an exported function under a `runtime/pprof`
+    /// label naming it (`wit.function`, e.g. "parser.parse"), so CPU
+    /// profiles attribute time spent in cgo to the binding rather than to
+    /// `runtime.cgocall`. The labels extend those of the call's context
+    /// with `context_params`; otherwise the caller's goroutine labels are
+    /// cleared once the call returns, as `pprof.Do` restores them from
+    /// `context.Background()`. Calls moved onto another goroutine by
+    /// `dispatch_thread` or `blocking` run unlabelled.
+    pub pprof_labels: bool,
+
+    /// Also generate `metrics.go`, whose `NewMetrics` registers call,
+    /// error and latency metrics of every exported function with a
+    /// Prometheus registry, recorded through `CallHooks`. Implies
+    /// `call_hooks`. The generated package then depends on
+    /// `github.com/prometheus/client_golang`.
+    pub metrics: bool,
+
+    /// Also generate `http_handlers.go`, whose `NewHTTPHandler` serves the
+    /// exported functions as JSON endpoints, for debugging the bindings
+    /// with curl or internal tools. Record fields are then tagged with
+    /// their WIT names, and enums marshal as theirs.
+    pub http_handlers: bool,
+
+    /// Also generate `call_dump_debug.go` and `call_dump.go`: built with the
+    /// `witffidump` tag, every wrapper logs its arguments and results to
+    /// `slog` at Debug level; without it the dump compiles away.
+    pub call_dump: bool,
+
+    /// Length in bytes past which dumped values are truncated.
+    pub call_dump_max_len: usize,
+
+    /// Values left out of call dumps, as `{function}.{param}`, or
+    /// `{function}.return` for the results, where `{function}` is the
+    /// qualified name (e.g. "wallet.sign.key").
+    pub call_dump_redact: HashSet<String>,
+
+    /// Track every open resource handle, listed by `LiveHandles` with its
+    /// type and age, and `CheckNoLiveHandles` for tests. Also generates
+    /// `handles_debug.go` and `handles.go`: built with the `witffidebug`
+    /// tag, each handle also records the stack it was created on.
+    pub handle_table: bool,
+
+    /// When the bindings set up the Rust library: checking its ABI hash,
+    /// starting the dispatcher thread and enabling panic backtraces.
+    pub init_mode: GoInitMode,
+
+    /// Non-default Go representations for WIT types, keyed by WIT type name
+    /// (e.g. "u128"). A mapping applies to the named type and to aliases of
+    /// it. `Time` and `Duration` may also be keyed by `<record>.<field>`
+    /// (e.g. "event.created-at") to map a single 64-bit integer field.
+    pub type_mappings: HashMap<String, GoTypeMapping>,
+
+    /// How WIT names become Go names: initialisms, explicit names and
+    /// keyword escaping.
+    pub naming: names::GoNaming,
+
+    /// The Go names of individual exported functions, keyed by qualified
+    /// WIT name (e.g. "parser.parse" to "ParseURI"), replacing the names
+    /// `naming` would give them.
+    pub function_names: HashMap<String, String>,
+
+    /// Also generate a subpackage per exported interface (e.g. `parser`),
+    /// re-exporting the interface's types and functions from the generated
+    /// package under names without the interface prefix (`parser.Parse`
+    /// for `ParserParse`). Callers can then import only the interfaces they
+    /// use. Needs `import_path`.
+    pub subpackages: bool,
+
+    /// The Go import path of the generated package (e.g.
+    /// "example.com/wallet/eip681"), which subpackages import.
+    pub import_path: Option<String>,
+
+    /// Plugins customizing the generated code, called in order.
+    pub plugins: Vec<Arc<dyn GoPlugin>>,
+
+    /// The number of threads generating the wrappers and conversion
+    /// functions, or 0 for one per available core. The output is the same
+    /// whatever the number.
+    pub threads: usize,
+
+    /// Where each WIT item is defined, by qualified name (see
+    /// [`witffi_core::source_map::wit_locations`]), noted in the doc
+    /// comments of the Go declarations generated for it as
+    /// `// source: parser.wit:42`. Empty for no notes.
+    pub source_locations: HashMap<String, SourceLocation>,
+
+    /// The WIT directory as a path from the output directory (e.g.
+    /// `../wit`), with which every wrapper of a located function is preceded
+    /// by a `//line` directive, so that compiler errors and stack traces
+    /// report positions in it at the function's WIT definition. None for no
+    /// directives.
+    pub line_directives: Option<String>,
+
+    /// Log a warning through `slog`, once per function, the first time a
+    /// deprecated function is called. Deprecated functions and types are
+    /// marked with a `Deprecated:` paragraph in their doc comments either
+    /// way.
+    pub deprecation_warnings: bool,
 }
 
 impl Default for GoConfig {
@@ -49,10 +448,189 @@ impl Default for GoConfig {
             c_type_prefix: "Ffi".to_string(),
             go_package: None,
             lib_name: "witffi".to_string(),
+            backend: GoBackend::Cgo,
+            resource_cleanup: ResourceCleanup::Manual,
+            resource_cleanup_overrides: HashMap::new(),
+            generic_options: false,
+            multi_value_results: false,
+            named_results: HashSet::new(),
+            stream_iterators: false,
+            context_params: false,
+            context_functions: HashSet::new(),
+            timeouts: HashMap::new(),
+            dispatch_thread: false,
+            blocking: HashSet::new(),
+            single_threaded: HashSet::new(),
+            into_variants: false,
+            buffer_pool: true,
+            pin_bytes: false,
+            arena_chunk_size: None,
+            borrowed_strings: HashSet::new(),
+            list_views: HashSet::new(),
+            zero_copy: HashSet::new(),
+            list_seqs: HashSet::new(),
+            columnar: HashSet::new(),
+            cgo_annotations: false,
+            cgo_unannotated: HashSet::new(),
+            intern_strings: None,
+            alloc_accounting: false,
+            alloc_canaries: false,
+            cgocheck_tests: false,
+            foreign_panics: false,
+            error_chains: false,
+            lifecycle: false,
+            log_bridge: false,
+            call_hooks: false,
+            otel_spans: false,
+            pprof_labels: false,
+            metrics: false,
+            http_handlers: false,
+            call_dump: false,
+            call_dump_max_len: 256,
+            call_dump_redact: HashSet::new(),
+            handle_table: false,
+            init_mode: GoInitMode::Eager,
+            type_mappings: HashMap::new(),
+            naming: names::GoNaming::default(),
+            function_names: HashMap::new(),
+            subpackages: false,
+            import_path: None,
+            plugins: Vec::new(),
+            threads: 0,
+            deprecation_warnings: false,
+            source_locations: HashMap::new(),
+            line_directives: None,
         }
     }
 }
 
+/// Cleanup strategy for generated resource handles.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ResourceCleanup {
+    /// Handles are only released by an explicit `Close`.
+    Manual,
+    /// Register a `runtime.AddCleanup` (Go 1.24+) that drops the handle once
+    /// the wrapper becomes unreachable.
+    AddCleanup,
+    /// Register a `runtime.SetFinalizer` that calls `Close`, for toolchains
+    /// older than Go 1.24.
+    Finalizer,
+}
+
+/// When generated Go bindings set up the Rust library.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum GoInitMode {
+    /// Set up in the package's `init` functions, when it is imported.
+    Eager,
+    /// Set up on the first call into Rust, guarded by a `sync.Once`, for
+    /// deployments that must not do work or spawn threads at import time.
+    /// `Init`, with `lifecycle`, stays an explicit call either way.
+    Lazy,
+}
+
+/// How generated Go bindings call into the Rust library.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum GoBackend {
+    /// Through cgo, linking the library when the package is built.
+    Cgo,
+    /// Through `github.com/ebitengine/purego`, loading the library with
+    /// `dlopen` on the first call, for builds that cannot enable cgo. Only
+    /// freestanding synchronous functions passing numbers, bools, strings
+    /// and `list<u8>` are supported, with the API the cgo backend gives
+    /// them. Strings and lists cross as C structs passed by value, which
+    /// purego supports only on macOS, so the bindings are built only for
+    /// `darwin/amd64` and `darwin/arm64`.
+    Purego,
+    /// Through [wazero](https://wazero.io), running the library built for
+    /// `wasm32-wasip1` (with `witffi_register_wasm!`) from a `<lib_name>.wasm`
+    /// embedded in the package, for pure-Go builds that sandbox it. Values
+    /// are lifted and lowered through the canonical ABI in the module's
+    /// memory, and calls are serialized. The functions supported are those
+    /// of purego, with errors only as strings.
+    Wazero,
+    /// Through [wasmtime-go](https://github.com/bytecodealliance/wasmtime-go),
+    /// running the same embedded module as [`Wazero`](Self::Wazero) with
+    /// wasmtime's compiler, for near-native speed. wasmtime-go links
+    /// wasmtime with cgo itself; the library is still sandboxed.
+    Wasmtime,
+    /// Through a sidecar process running the library (with
+    /// `witffi_register_sidecar!`), started on the first call, for services
+    /// that must survive a crash in it. Calls cross the process's stdin and
+    /// stdout as the [`ipc`] protocol encodes them, and are serialized. The
+    /// functions supported are those of wazero.
+    Sidecar,
+    /// Through a gRPC service serving the library (with
+    /// `witffi_register_grpc!`), for services that must not run native code
+    /// at all. The service is defined by the proto file
+    /// [`generate_proto_file`](GoGenerator::generate_proto_file) gives, and
+    /// the bindings encode its messages themselves, needing no generated
+    /// Go. The functions supported are those of the sidecar.
+    Grpc,
+}
+
+/// A non-default Go representation for a WIT type.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum GoTypeMapping {
+    /// Surface a 128-bit integer (`u128`/`s128`), or an alias of `list<u8>`
+    /// holding big-endian bytes (e.g. `u256`), as an unsigned `*big.Int`.
+    BigInt,
+    /// Surface a `list<tuple<K, V>>` as `map[K]V`. Keys must be strings,
+    /// numbers, chars or enums; entries are lowered sorted by key.
+    Map,
+    /// Surface a 64-bit integer holding nanoseconds since the Unix epoch,
+    /// or a wasi-clocks style `datetime` record (`seconds: u64`,
+    /// `nanoseconds: u32`), as a UTC `time.Time`.
+    Time,
+    /// Surface a 64-bit integer holding nanoseconds (e.g. the wasi-clocks
+    /// `duration`) as a `time.Duration`.
+    Duration,
+    /// Surface a type alias as a user-chosen Go type, converted by
+    /// user-supplied functions.
+    Custom(GoCustomMapping),
+}
+
+/// A user-supplied Go representation for a WIT type alias.
+///
+/// The conversion functions must be defined elsewhere in the generated
+/// package and convert from (`lift`) and to (`lower`) the Go type the alias
+/// would otherwise have, e.g. `func BytesToDecimal([]byte) decimal.Decimal`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct GoCustomMapping {
+    /// The Go type, e.g. `decimal.Decimal`.
+    pub go_type: String,
+    /// The function lifting the default representation into `go_type`.
+    pub lift: String,
+    /// The function lowering `go_type` into the default representation.
+    pub lower: String,
+    /// An import path needed by `go_type`, if any.
+    pub import: Option<String>,
+}
+
+/// How a mapped type alias converts to and from the Go type of the type it
+/// aliases.
+struct MappedConversion {
+    go_type: String,
+    lift: String,
+    lower: String,
+    inner: Type,
+    /// `inner` is the mapped typedef itself (e.g. `type u256 = list<u8>`),
+    /// to be converted by its structure rather than through the mapping.
+    bare: bool,
+}
+
+/// How a value crosses into the library without cgo, through purego or
+/// WebAssembly.
+#[derive(Debug, Clone, Copy)]
+enum PlainValue {
+    /// A number or bool, passed as the Go type named.
+    Scalar(&'static str),
+    /// A `string`, lent as an `ffiByteSlice` and returned as an
+    /// `ffiByteBuffer`.
+    String,
+    /// A `list<u8>`, passed as a `string` is.
+    Bytes,
+}
+
 /// Generates Go bindings from a resolved WIT world.
 pub struct GoGenerator<'a> {
     resolve: &'a Resolve,
@@ -69,22 +647,306 @@ impl<'a> GoGenerator<'a> {
     /// * `world_id` — The world to generate bindings for
     /// * `config` — Generator configuration
     pub fn new(resolve: &'a Resolve, world_id: WorldId, config: GoConfig) -> Self {
-        Self {
+        let mut generator = Self {
             resolve,
             world_id,
             config,
+        };
+        // Zero-copy functions take the form fitting their result, and those
+        // that fit neither fail the borrowed string check
+        let zero_copy = std::mem::take(&mut generator.config.zero_copy);
+        let funcs = generator.exported_functions();
+        for function in zero_copy {
+            let returns_list = funcs
+                .iter()
+                .find(|ef| ef.qualified_name(resolve) == function)
+                .is_some_and(|ef| {
+                    let returned = match generator.decompose_result(&ef.function.result) {
+                        Some((ok_ty, _)) => ok_ty,
+                        None => ef.function.result,
+                    };
+                    returned.is_some_and(|ty| generator.viewable_list(&ty).is_some())
+                });
+            if returns_list {
+                generator.config.list_views.insert(function);
+            } else {
+                generator.config.borrowed_strings.insert(function);
+            }
         }
+        generator
     }
 
     /// Generate all Go bindings code as a single string.
     ///
     /// # Errors
     ///
-    /// Returns an error if writing to the output buffer fails.
+    /// Returns an error if a configured timeout names a function that
+    /// cannot time out (see [`GoConfig::timeouts`]), if a backend other
+    /// than cgo cannot bind a function or an option (see [`GoBackend`]), or
+    /// if writing to the output buffer fails.
     pub fn generate(&self) -> Result<String, Error> {
+        if self.config.backend != GoBackend::Cgo {
+            self.check_backend()?;
+            let mut out = String::new();
+            match self.config.backend {
+                GoBackend::Wazero | GoBackend::Wasmtime => self.generate_wasm(&mut out),
+                GoBackend::Sidecar => self.generate_sidecar(&mut out),
+                GoBackend::Grpc => {
+                    let package =
+                        proto::package_name(self.resolve, self.world_id).context(ProtoSnafu)?;
+                    self.generate_grpc(&mut out, &package)
+                }
+                _ => self.generate_purego(&mut out),
+            }
+            .context(WriteSnafu)?;
+            return Ok(Self::restore_line_directives(out, "bindings.go"));
+        }
+        self.check_timeouts()?;
+        self.check_blocking()?;
+        self.check_single_threaded()?;
+        self.check_borrowed_strings()?;
+        self.check_list_views()?;
+        self.check_list_seqs()?;
+        self.check_cgo_unannotated()?;
         let mut out = String::new();
         self.generate_inner(&mut out).context(WriteSnafu)?;
-        Ok(out)
+        Ok(Self::restore_line_directives(out, "bindings.go"))
+    }
+
+    /// Generate one file per `@unstable` feature, holding the API functions
+    /// gated by it, as `(file name, code)` pairs. The main file from
+    /// [`generate`](Self::generate) leaves these functions out.
+    ///
+    /// Each file is guarded by a `witffi_feature_<feature>` build tag, so
+    /// consumers opt in with e.g. `go build -tags witffi_feature_fast_math`.
+    /// Types, resources and import trampolines stay in the main file, ungated
+    /// even when `@unstable` themselves.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if writing to the output buffer fails.
+    pub fn generate_feature_files(&self) -> Result<Vec<(String, String)>, Error> {
+        let mut features: Vec<(String, Vec<ExportedFunction>)> = Vec::new();
+        for ef in self.exported_functions() {
+            let Some(feature) = ef.feature.clone() else {
+                continue;
+            };
+            match features.iter_mut().find(|(f, _)| *f == feature) {
+                Some((_, funcs)) => funcs.push(ef),
+                None => features.push((feature, vec![ef])),
+            }
+        }
+
+        self.parallel_map(&features, |(feature, funcs)| {
+            let mut out = String::new();
+            match self.config.backend {
+                GoBackend::Cgo => self.generate_feature_file(&mut out, feature, funcs),
+                GoBackend::Purego => self.generate_purego_feature_file(&mut out, feature, funcs),
+                GoBackend::Wazero | GoBackend::Wasmtime => {
+                    self.generate_wasm_feature_file(&mut out, feature, funcs)
+                }
+                GoBackend::Sidecar => self.generate_sidecar_feature_file(&mut out, feature, funcs),
+                GoBackend::Grpc => {
+                    let package =
+                        proto::package_name(self.resolve, self.world_id).context(ProtoSnafu)?;
+                    self.generate_grpc_feature_file(&mut out, &package, feature, funcs)
+                }
+            }
+            .context(WriteSnafu)?;
+            // Keep the feature away from the end of the name, where Go
+            // would read `_linux` or `_test` as a build constraint
+            let file_name = format!("feature_{}_bindings.go", feature.to_snake_case());
+            let out = Self::restore_line_directives(out, &file_name);
+            Ok((file_name, out))
+        })
+        .into_iter()
+        .collect()
+    }
+
+    /// Generate the files defining `freeRustBox` when
+    /// [`GoConfig::alloc_accounting`] is set, as `(file name, code)` pairs:
+    /// one built with the `witffidebug` tag, which frees through the library
+    /// and adds `AllocStats` and `CheckNoLeaks`, and one built without it,
+    /// which frees directly. Empty otherwise.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if writing to the output buffer fails.
+    pub fn generate_alloc_accounting_files(&self) -> Result<Vec<(String, String)>, Error> {
+        if !self.config.alloc_accounting {
+            return Ok(Vec::new());
+        }
+        let mut debug = String::new();
+        self.generate_alloc_accounting_debug_file(&mut debug)
+            .context(WriteSnafu)?;
+        let mut release = String::new();
+        self.generate_alloc_accounting_release_file(&mut release)
+            .context(WriteSnafu)?;
+        Ok(vec![
+            ("alloc_accounting_debug.go".to_string(), debug),
+            ("alloc_accounting.go".to_string(), release),
+        ])
+    }
+
+    /// Generate the files defining `dumpCall` when [`GoConfig::call_dump`]
+    /// is set, as `(file name, code)` pairs: one built with the `witffidump`
+    /// tag, which logs every call, and one built without it, which compiles
+    /// the dump away. Empty otherwise.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if writing to the output buffer fails.
+    pub fn generate_call_dump_files(&self) -> Result<Vec<(String, String)>, Error> {
+        if !self.config.call_dump {
+            return Ok(Vec::new());
+        }
+        let mut debug = String::new();
+        self.generate_call_dump_debug_file(&mut debug)
+            .context(WriteSnafu)?;
+        let mut release = String::new();
+        self.generate_call_dump_release_file(&mut release)
+            .context(WriteSnafu)?;
+        Ok(vec![
+            ("call_dump_debug.go".to_string(), debug),
+            ("call_dump.go".to_string(), release),
+        ])
+    }
+
+    /// Generate the files defining `handleStacks` when
+    /// [`GoConfig::handle_table`] is set, as `(file name, code)` pairs: one
+    /// built with the `witffidebug` tag, which records where each handle was
+    /// created, and one built without it. Empty otherwise.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if writing to the output buffer fails.
+    pub fn generate_handle_table_files(&self) -> Result<Vec<(String, String)>, Error> {
+        if !self.config.handle_table {
+            return Ok(Vec::new());
+        }
+        let file = |debug: bool| -> Result<String, Error> {
+            let mut out = String::new();
+            self.generate_handle_stacks_file(&mut out, debug)
+                .context(WriteSnafu)?;
+            Ok(out)
+        };
+        Ok(vec![
+            ("handles_debug.go".to_string(), file(true)?),
+            ("handles.go".to_string(), file(false)?),
+        ])
+    }
+
+    /// The proto file defining the service the gRPC bindings call, as a
+    /// `(file name, definition)` pair when [`GoConfig::backend`] is
+    /// [`GoBackend::Grpc`]. `None` otherwise.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if the world belongs to no package, which names the
+    /// proto package.
+    pub fn generate_proto_file(&self) -> Result<Option<(String, String)>, Error> {
+        if self.config.backend != GoBackend::Grpc {
+            return Ok(None);
+        }
+        let world = &self.resolve.worlds[self.world_id];
+        let proto = proto::generate_proto(self.resolve, self.world_id).context(ProtoSnafu)?;
+        Ok(Some((
+            format!("{}.proto", world.name.to_snake_case()),
+            proto,
+        )))
+    }
+
+    /// Generate the test lowering every list shape when
+    /// [`GoConfig::cgocheck_tests`] is set, as a `(file name, code)` pair.
+    /// `None` otherwise, or if no parameter is lowered into C memory.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if writing to the output buffer fails.
+    pub fn generate_cgocheck_test(&self) -> Result<Option<(String, String)>, Error> {
+        if !self.config.cgocheck_tests || !self.uses_c_allocs() {
+            return Ok(None);
+        }
+        let mut out = String::new();
+        self.generate_cgocheck_test_file(&mut out)
+            .context(WriteSnafu)?;
+        Ok(Some(("cgocheck_test.go".to_string(), out)))
+    }
+
+    /// Generate `metrics.go` when [`GoConfig::metrics`] is set, as a
+    /// `(file name, code)` pair. `None` otherwise.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if writing to the output buffer fails.
+    pub fn generate_metrics_file(&self) -> Result<Option<(String, String)>, Error> {
+        if !self.config.metrics {
+            return Ok(None);
+        }
+        let mut out = String::new();
+        self.generate_metrics(&mut out).context(WriteSnafu)?;
+        Ok(Some(("metrics.go".to_string(), out)))
+    }
+
+    /// Generate `http_handlers.go` when [`GoConfig::http_handlers`] is set,
+    /// as a `(file name, code)` pair. `None` otherwise.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if writing to the output buffer fails.
+    pub fn generate_http_handlers_file(&self) -> Result<Option<(String, String)>, Error> {
+        if !self.config.http_handlers {
+            return Ok(None);
+        }
+        let mut out = String::new();
+        self.generate_http_handlers(&mut out).context(WriteSnafu)?;
+        Ok(Some(("http_handlers.go".to_string(), out)))
+    }
+
+    /// Generate one subpackage per exported interface when
+    /// [`GoConfig::subpackages`] is set, as `(path, code)` pairs whose paths
+    /// are relative to the output directory (e.g. `parser/bindings.go`).
+    /// Empty otherwise.
+    ///
+    /// # Errors
+    ///
+    /// Returns an error if [`GoConfig::import_path`] is not set, or if
+    /// writing to the output buffer fails.
+    pub fn generate_subpackages(&self) -> Result<Vec<(String, String)>, Error> {
+        if !self.config.subpackages {
+            return Ok(Vec::new());
+        }
+        let import_path = self
+            .config
+            .import_path
+            .as_deref()
+            .context(MissingImportPathSnafu)?;
+        let world = &self.resolve.worlds[self.world_id];
+        let interfaces: Vec<(InterfaceId, String)> = world
+            .exports
+            .values()
+            .filter_map(|item| match item {
+                wit_parser::WorldItem::Interface { id, .. } => {
+                    let name = self.resolve.interfaces[*id].name.as_deref()?;
+                    Some((*id, names::to_go_package(name)))
+                }
+                _ => None,
+            })
+            .collect();
+        let generated = self.parallel_map(&interfaces, |(id, package)| {
+            let mut out = String::new();
+            self.generate_subpackage(&mut out, *id, package, import_path)
+                .map(|()| (format!("{package}/bindings.go"), out))
+        });
+        let mut files = Vec::new();
+        for file in generated {
+            let (file_name, out) = file.context(WriteSnafu)?;
+            if !out.is_empty() {
+                files.push((file_name, out));
+            }
+        }
+        files.sort();
+        Ok(files)
     }
 
     fn generate_inner(&self, out: &mut String) -> std::fmt::Result {
@@ -99,1175 +961,18406 @@ impl<'a> GoGenerator<'a> {
         self.generate_conversion_functions(out)?;
         writeln!(out)?;
         self.generate_api(out)?;
+        self.generate_imported_functions(out)?;
+        if self.config.lifecycle {
+            self.generate_lifecycle(out)?;
+        }
+        if self.config.log_bridge {
+            self.generate_log_bridge(out)?;
+        }
+        if self.warns_deprecation() {
+            self.generate_deprecation_warnings(out)?;
+        }
+        for plugin in &self.config.plugins {
+            let declarations = plugin.declarations();
+            if !declarations.is_empty() {
+                writeln!(out)?;
+                writeln!(out, "{}", declarations.trim_end())?;
+            }
+        }
 
         Ok(())
     }
 
-    // ---- Package name derivation ----
-
-    /// Get the Go package name, either from config or derived from the world name.
-    fn package_name(&self) -> String {
-        if let Some(ref pkg) = self.config.go_package {
-            pkg.clone()
-        } else {
-            let world = &self.resolve.worlds[self.world_id];
-            world.name.clone()
-        }
-    }
+    // ---- Feature-gated files ----
 
-    /// Get the C function prefix in snake_case form.
-    fn c_func_prefix(&self) -> String {
-        self.config.c_prefix.to_snake_case()
+    /// The build tag opting in to an `@unstable` feature.
+    fn feature_build_tag(feature: &str) -> String {
+        format!("witffi_feature_{}", feature.to_snake_case())
     }
 
-    // ---- Doc comment helper ----
-
-    /// Write a Go doc comment, handling multi-line content.
-    fn write_doc_comment(out: &mut String, docs: &str, indent: &str) -> std::fmt::Result {
-        for line in docs.trim_end().lines() {
-            if line.is_empty() {
-                writeln!(out, "{indent}//")?;
-            } else {
-                writeln!(out, "{indent}// {line}")?;
-            }
+    fn generate_feature_file(
+        &self,
+        out: &mut String,
+        feature: &str,
+        funcs: &[ExportedFunction],
+    ) -> std::fmt::Result {
+        let mut api = String::new();
+        writeln!(api, "// ---- Public API (feature `{feature}`) ----")?;
+        for ef in funcs {
+            self.generate_api_function(&mut api, ef)?;
         }
-        Ok(())
-    }
-
-    // ---- Header generation ----
 
-    fn generate_header(&self, out: &mut String) -> std::fmt::Result {
         writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
         writeln!(out)?;
+        writeln!(out, "//go:build {}", Self::feature_build_tag(feature))?;
+        writeln!(out)?;
         writeln!(out, "package {}", self.package_name())?;
-
-        Ok(())
-    }
-
-    // ---- CGo preamble ----
-
-    fn generate_cgo_preamble(&self, out: &mut String) -> std::fmt::Result {
         writeln!(out)?;
         writeln!(out, "/*")?;
-        writeln!(out, "#cgo LDFLAGS: -l{}", self.config.lib_name)?;
         writeln!(out, "#include \"witffi_types.h\"")?;
         writeln!(out, "#include \"ffi.h\"")?;
         writeln!(out, "#include <stdlib.h>")?;
-        // import "C" MUST immediately follow closing */ (CGo requirement)
         writeln!(out, "*/")?;
         writeln!(out, "import \"C\"")?;
 
+        // Only import what the gated functions use, since Go rejects unused
+        // imports
+        let std_imports: Vec<&str> = STD_IMPORTS
+            .iter()
+            .copied()
+            .filter(|import| Self::uses_package(&api, import))
+            .collect();
+        let custom_imports: Vec<String> = self
+            .custom_imports()
+            .into_iter()
+            .filter(|import| Self::uses_package(&api, import))
+            .collect();
+        if !std_imports.is_empty() || !custom_imports.is_empty() {
+            writeln!(out)?;
+            writeln!(out, "import (")?;
+            for import in &std_imports {
+                writeln!(out, "\t\"{import}\"")?;
+            }
+            if !std_imports.is_empty() && !custom_imports.is_empty() {
+                writeln!(out)?;
+            }
+            for import in &custom_imports {
+                writeln!(out, "\t\"{import}\"")?;
+            }
+            writeln!(out, ")")?;
+        }
+        writeln!(out)?;
+        out.push_str(&api);
+
         Ok(())
     }
 
-    // ---- Go imports ----
+    /// Whether Go code refers to the package imported from `import`, i.e.
+    /// names `<package>.` outside of a longer identifier.
+    fn uses_package(code: &str, import: &str) -> bool {
+        let package = import.rsplit('/').next().unwrap_or(import);
+        let qualifier = format!("{package}.");
+        code.match_indices(&qualifier).any(|(i, _)| {
+            !code[..i]
+                .chars()
+                .next_back()
+                .is_some_and(|c| c.is_alphanumeric() || c == '_' || c == '.')
+        })
+    }
 
-    fn generate_imports(&self, out: &mut String) -> std::fmt::Result {
-        let funcs = exported_functions(self.resolve, self.world_id);
-        let has_result_funcs = funcs
-            .iter()
-            .any(|ef| self.decompose_result(&ef.function.result).is_some());
-        let has_variants = self
-            .collect_reachable_types()
-            .iter()
-            .any(|id| matches!(self.resolve.types[*id].kind, TypeDefKind::Variant(_)));
-        let needs_fmt = has_result_funcs || has_variants;
+    // ---- Backends without cgo ----
 
-        writeln!(out)?;
-        writeln!(out, "import (")?;
-        if needs_fmt {
-            writeln!(out, "\t\"fmt\"")?;
+    /// Check that bindings can be generated for a backend other than cgo:
+    /// that no option needing cgo is set, and that every exported function
+    /// can be bound.
+    fn check_backend(&self) -> Result<(), Error> {
+        let backend = match self.config.backend {
+            GoBackend::Cgo => return Ok(()),
+            GoBackend::Purego => "purego",
+            GoBackend::Wazero => "wazero",
+            GoBackend::Wasmtime => "wasmtime",
+            GoBackend::Sidecar => "sidecar",
+            GoBackend::Grpc => "grpc",
+        };
+        let config = &self.config;
+        let options = [
+            (
+                config.context_params || !config.context_functions.is_empty(),
+                "context parameters",
+            ),
+            (!config.timeouts.is_empty(), "timeouts"),
+            (config.dispatch_thread, "the dispatcher thread"),
+            (!config.blocking.is_empty(), "the blocking pool"),
+            (
+                !config.single_threaded.is_empty(),
+                "single-threaded interfaces",
+            ),
+            (config.into_variants, "Into variants"),
+            (
+                !config.borrowed_strings.is_empty() || !config.list_views.is_empty(),
+                "zero-copy results",
+            ),
+            (!config.list_seqs.is_empty(), "iter.Seq variants"),
+            (!config.columnar.is_empty(), "columnar results"),
+            (config.intern_strings.is_some(), "string interning"),
+            (config.alloc_accounting, "allocation accounting"),
+            (config.cgocheck_tests, "cgocheck tests"),
+            (config.foreign_panics, "foreign panics"),
+            (config.error_chains, "error chains"),
+            (config.lifecycle, "lifecycle hooks"),
+            (config.log_bridge, "the log bridge"),
+            (self.call_hooks(), "call hooks"),
+            (config.otel_spans, "OpenTelemetry spans"),
+            (config.pprof_labels, "pprof labels"),
+            (config.call_dump, "call dumps"),
+            (config.handle_table, "the handle table"),
+            (config.subpackages, "subpackages"),
+            (!config.plugins.is_empty(), "plugins"),
+            (!self.imports().is_empty(), "imported functions"),
+        ];
+        if let Some((_, option)) = options.iter().find(|(set, _)| *set) {
+            return BackendSnafu {
+                backend,
+                reason: format!("cgo is needed for {option}"),
+            }
+            .fail();
+        }
+        for ef in self.exported_functions() {
+            let problem = match self.config.backend {
+                GoBackend::Wazero | GoBackend::Wasmtime => self.wasm_unsupported(&ef),
+                GoBackend::Sidecar | GoBackend::Grpc => self.ipc_unsupported(&ef),
+                _ => self.cgo_only(&ef),
+            };
+            if let Some(problem) = problem {
+                return BackendSnafu {
+                    backend,
+                    reason: format!("`{}` {problem}", ef.qualified_name(self.resolve)),
+                }
+                .fail();
+            }
         }
-        writeln!(out, "\t\"unsafe\"")?;
-        writeln!(out, ")")?;
-
         Ok(())
     }
 
-    // ---- Helpers ----
+    /// Why `ef` can only be bound through cgo, if it can.
+    fn cgo_only(&self, ef: &ExportedFunction) -> Option<String> {
+        if ef.resource().is_some() {
+            return Some("is a resource function".to_string());
+        }
+        if ef.is_async() {
+            return Some("is async".to_string());
+        }
+        if ef.error_payload(self.resolve).is_some() {
+            return Some("returns a typed error".to_string());
+        }
+        if let Some(param) = ef
+            .function
+            .params
+            .iter()
+            .find(|p| self.plain_value(&p.ty).is_none())
+        {
+            return Some(format!(
+                "takes `{}`, of a type only cgo can pass",
+                param.name
+            ));
+        }
+        let returned = match self.decompose_result(&ef.function.result) {
+            Some((ok_ty, _)) => ok_ty,
+            None => ef.function.result,
+        };
+        returned
+            .filter(|ty| self.plain_value(ty).is_none())
+            .map(|_| "returns a type only cgo can pass".to_string())
+    }
 
-    fn generate_helpers(&self, out: &mut String) -> std::fmt::Result {
-        let prefix = self.c_func_prefix();
+    /// Why `ef` cannot be called in another process (by the sidecar and gRPC
+    /// backends), if it cannot.
+    fn ipc_unsupported(&self, ef: &ExportedFunction) -> Option<String> {
+        if let Some(problem) = self.cgo_only(ef) {
+            return Some(problem);
+        }
+        let string_error = match self.decompose_result(&ef.function.result) {
+            Some((_, err)) => err.is_some_and(|err| *self.resolve_to_leaf(&err) == Type::String),
+            None => true,
+        };
+        (!string_error).then(|| "returns an error other than a string".to_string())
+    }
 
-        writeln!(out, "// ---- Helpers ----")?;
-        writeln!(out)?;
+    /// Why `ef` cannot be called as a WebAssembly export, if it cannot.
+    fn wasm_unsupported(&self, ef: &ExportedFunction) -> Option<String> {
+        if let Some(problem) = self.ipc_unsupported(ef) {
+            return Some(problem);
+        }
+        canonical::wasm_signature(self.resolve, ef)
+            .is_none()
+            .then(|| {
+                format!(
+                    "takes more than {} core Wasm values",
+                    canonical::MAX_FLAT_PARAMS
+                )
+            })
+    }
 
-        // ffiByteBufferToString
+    /// How a value of `ty` crosses into the library without cgo, or None
+    /// for the types only cgo bindings pass.
+    fn plain_value(&self, ty: &Type) -> Option<PlainValue> {
+        if self.type_mapping(ty).is_some()
+            || self.mapped_conversion(ty).is_some()
+            || self.wide_int(ty).is_some()
+        {
+            return None;
+        }
+        let value = match self.resolve_to_leaf(ty) {
+            Type::Bool => PlainValue::Scalar("bool"),
+            Type::U8 => PlainValue::Scalar("uint8"),
+            Type::U16 => PlainValue::Scalar("uint16"),
+            Type::U32 => PlainValue::Scalar("uint32"),
+            Type::U64 => PlainValue::Scalar("uint64"),
+            Type::S8 => PlainValue::Scalar("int8"),
+            Type::S16 => PlainValue::Scalar("int16"),
+            Type::S32 => PlainValue::Scalar("int32"),
+            Type::S64 => PlainValue::Scalar("int64"),
+            Type::F32 => PlainValue::Scalar("float32"),
+            Type::F64 => PlainValue::Scalar("float64"),
+            Type::String => PlainValue::String,
+            Type::Id(id) if matches!(self.resolve.types[*id].kind, TypeDefKind::List(Type::U8)) => {
+                PlainValue::Bytes
+            }
+            _ => return None,
+        };
+        Some(value)
+    }
+
+    // ---- purego backend ----
+
+    /// The build constraint of purego bindings: strings and `list<u8>` cross
+    /// as `FfiByteSlice` and `FfiByteBuffer` structs passed and returned by
+    /// value, which purego supports only on these platforms.
+    const PUREGO_PLATFORMS: &'static str = "darwin && (amd64 || arm64)";
+
+    /// The Go type of the C function `ef` is bound to through purego.
+    fn purego_signature(&self, ef: &ExportedFunction) -> String {
+        let params: Vec<&str> = ef
+            .function
+            .params
+            .iter()
+            .filter_map(|p| self.plain_value(&p.ty))
+            .map(|value| match value {
+                PlainValue::Scalar(go) => go,
+                PlainValue::String | PlainValue::Bytes => "ffiByteSlice",
+            })
+            .collect();
+        // Results with an ok value are boxed, null on error
+        let result = match self.decompose_result(&ef.function.result) {
+            Some((Some(_), _)) => "unsafe.Pointer",
+            Some((None, _)) => "bool",
+            None => match ef.function.result.and_then(|ty| self.plain_value(&ty)) {
+                Some(PlainValue::Scalar(go)) => go,
+                Some(PlainValue::String | PlainValue::Bytes) => "ffiByteBuffer",
+                None => "",
+            },
+        };
+        format!("func({}) {result}", params.join(", "))
+            .trim_end()
+            .to_string()
+    }
+
+    /// The Go expression lowering the Go value `expr` of `ty` into the
+    /// argument of a purego call.
+    fn purego_lower(&self, ty: &Type, expr: &str) -> String {
+        let go_ty = self.type_to_go(ty);
+        match self.plain_value(ty) {
+            Some(PlainValue::Scalar(go)) if go_ty != go => format!("{go}({expr})"),
+            Some(PlainValue::String) if go_ty != "string" => {
+                format!("goStringToFfiByteSlice(string({expr}))")
+            }
+            Some(PlainValue::String) => format!("goStringToFfiByteSlice({expr})"),
+            Some(PlainValue::Bytes) => format!("goBytesToFfiByteSlice({expr})"),
+            _ => expr.to_string(),
+        }
+    }
+
+    /// The Go expression lifting `expr`, the C representation of a value
+    /// of `ty` returned through purego, into the Go type of `ty`.
+    fn purego_lift(&self, ty: &Type, expr: &str) -> String {
+        let go_ty = self.type_to_go(ty);
+        let (lifted, base) = match self.plain_value(ty) {
+            Some(PlainValue::Scalar(go)) => (expr.to_string(), go),
+            Some(PlainValue::String) => (format!("ffiByteBufferToString({expr})"), "string"),
+            Some(PlainValue::Bytes) => (format!("ffiByteBufferToBytes({expr})"), "[]byte"),
+            None => return expr.to_string(),
+        };
+        if go_ty == base {
+            lifted
+        } else {
+            format!("{go_ty}({lifted})")
+        }
+    }
+
+    /// Generate the purego bindings: the loading of the library, the
+    /// helpers, the types and a wrapper per exported function.
+    fn generate_purego(&self, out: &mut String) -> std::fmt::Result {
+        let funcs = self.exported_functions();
+        // Feature-gated functions go in their own files, as with cgo
+        let funcs: Vec<&ExportedFunction> =
+            funcs.iter().filter(|ef| ef.feature.is_none()).collect();
+
+        let mut code = String::new();
+        self.generate_purego_loading(&mut code, &funcs)?;
+        writeln!(code)?;
+        self.generate_purego_helpers(&mut code)?;
+        writeln!(code)?;
+        self.generate_types(&mut code)?;
+        writeln!(code)?;
+        writeln!(code, "// ---- Public API ----")?;
+        self.write_each(&mut code, &funcs, |out, ef| {
+            self.generate_purego_function(out, ef)
+        })?;
+        if self.warns_deprecation() {
+            self.generate_deprecation_warnings(&mut code)?;
+        }
+
+        self.generate_constrained_header(out, Some(Self::PUREGO_PLATFORMS))?;
+        writeln!(out)?;
+        Self::write_purego_imports(out, &code)?;
+        writeln!(out)?;
+        out.push_str(&code);
+        Ok(())
+    }
+
+    /// Generate the file of the functions gated by `feature` for the
+    /// purego backend, which binds them alongside those of the main file.
+    fn generate_purego_feature_file(
+        &self,
+        out: &mut String,
+        feature: &str,
+        funcs: &[ExportedFunction],
+    ) -> std::fmt::Result {
+        let mut code = String::new();
+        writeln!(code, "func init() {{")?;
+        writeln!(code, "\tlibFuncs = append(libFuncs,")?;
+        for ef in funcs {
+            let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+            writeln!(code, "\t\tlibFunc{{&{c_func_name}, \"{c_func_name}\"}},")?;
+        }
+        writeln!(code, "\t)")?;
+        writeln!(code, "}}")?;
+        writeln!(code)?;
+        writeln!(code, "var (")?;
+        for ef in funcs {
+            let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+            writeln!(code, "\t{c_func_name} {}", self.purego_signature(ef))?;
+        }
+        writeln!(code, ")")?;
+        writeln!(code)?;
+        writeln!(code, "// ---- Public API (feature `{feature}`) ----")?;
+        for ef in funcs {
+            self.generate_purego_function(&mut code, ef)?;
+        }
+
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
         writeln!(
             out,
-            "func ffiByteBufferToString(buf C.FfiByteBuffer) string {{"
+            "//go:build {} && {}",
+            Self::feature_build_tag(feature),
+            Self::PUREGO_PLATFORMS
         )?;
-        writeln!(out, "\tif buf.ptr == nil || buf.len == 0 {{")?;
-        writeln!(out, "\t\tC.{prefix}_free_byte_buffer(buf)")?;
-        writeln!(out, "\t\treturn \"\"")?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        Self::write_purego_imports(out, &code)?;
+        writeln!(out)?;
+        out.push_str(&code);
+        Ok(())
+    }
+
+    /// Write the imports purego bindings `code` uses, since Go rejects
+    /// unused imports.
+    fn write_purego_imports(out: &mut String, code: &str) -> std::fmt::Result {
+        let std_imports: Vec<&str> = ["fmt", "log/slog", "os", "runtime", "sync", "unsafe"]
+            .into_iter()
+            .filter(|import| Self::uses_package(code, import))
+            .collect();
+        writeln!(out, "import (")?;
+        for import in &std_imports {
+            writeln!(out, "\t\"{import}\"")?;
+        }
+        if Self::uses_package(code, "purego") {
+            if !std_imports.is_empty() {
+                writeln!(out)?;
+            }
+            writeln!(out, "\t\"github.com/ebitengine/purego\"")?;
+        }
+        writeln!(out, ")")
+    }
+
+    /// Generate the C representations purego calls pass, the functions of
+    /// the library and `Load`, which loads and binds them.
+    fn generate_purego_loading(
+        &self,
+        out: &mut String,
+        funcs: &[&ExportedFunction],
+    ) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+        let lib_name = &self.config.lib_name;
+        let library_env = format!("{}_LIBRARY", prefix.to_uppercase());
+        let free_box = if self.config.alloc_canaries {
+            format!("{prefix}_free_box")
+        } else {
+            "libcFree".to_string()
+        };
+
+        writeln!(out, "// ---- Library loading ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ffiByteSlice mirrors the C FfiByteSlice: a string or list<u8> lent to"
+        )?;
+        writeln!(out, "// Rust for the duration of a call.")?;
+        writeln!(out, "type ffiByteSlice struct {{")?;
+        writeln!(out, "\tptr *byte")?;
+        writeln!(out, "\tlen uintptr")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ffiByteBuffer mirrors the C FfiByteBuffer: a string or list<u8> Rust"
+        )?;
+        writeln!(out, "// hands over, freed with {prefix}_free_byte_buffer.")?;
+        writeln!(out, "type ffiByteBuffer struct {{")?;
+        writeln!(out, "\tptr *byte")?;
+        writeln!(out, "\tlen uintptr")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// libFunc is a function of the Rust library, bound to fptr when the"
+        )?;
+        writeln!(out, "// library is loaded.")?;
+        writeln!(out, "type libFunc struct {{")?;
+        writeln!(out, "\tfptr any")?;
+        writeln!(out, "\tname string")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+
+        // The library's own functions, then those wrapped
+        let mut bound: Vec<(String, String)> = vec![
+            (format!("{prefix}_abi_hash"), "func() uint64".to_string()),
+            (
+                format!("{prefix}_last_error_length"),
+                "func() int32".to_string(),
+            ),
+            (
+                format!("{prefix}_error_message_utf8"),
+                "func(*byte, int32) int32".to_string(),
+            ),
+            (
+                format!("{prefix}_free_byte_buffer"),
+                "func(ffiByteBuffer)".to_string(),
+            ),
+        ];
+        if self.config.alloc_canaries {
+            bound.push((
+                format!("{prefix}_free_box"),
+                "func(unsafe.Pointer)".to_string(),
+            ));
+        }
+        bound.extend(funcs.iter().map(|ef| {
+            (
+                ef.c_func_name(self.resolve, &self.config.c_prefix),
+                self.purego_signature(ef),
+            )
+        }));
+
+        writeln!(
+            out,
+            "// libFuncs are bound when the library is loaded. The files of gated"
+        )?;
+        writeln!(out, "// features add the functions they wrap.")?;
+        writeln!(out, "var libFuncs = []libFunc{{")?;
+        for (name, _) in &bound {
+            writeln!(out, "\t{{&{name}, \"{name}\"}},")?;
+        }
+        if !self.config.alloc_canaries {
+            writeln!(
+                out,
+                "\t// Boxes are freed by the C library's free, a dependency of the library"
+            )?;
+            writeln!(out, "\t{{&libcFree, \"free\"}},")?;
+        }
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "var (")?;
+        for (name, signature) in &bound {
+            writeln!(out, "\t{name} {signature}")?;
+        }
+        if !self.config.alloc_canaries {
+            writeln!(out, "\tlibcFree func(unsafe.Pointer)")?;
+        }
+        writeln!(out, ")")?;
+        writeln!(out)?;
+
+        writeln!(
+            out,
+            "// abiHash identifies the WIT world these bindings were generated from,"
+        )?;
+        writeln!(
+            out,
+            "// which the Rust library must have been generated from too."
+        )?;
+        writeln!(
+            out,
+            "const abiHash = {:#018x}",
+            witffi_core::abi_hash(self.resolve, self.world_id)
+        )?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// libraryEnv names the environment variable giving the path of the Rust"
+        )?;
+        writeln!(out, "// library, when Load is not called.")?;
+        writeln!(out, "const libraryEnv = \"{library_env}\"")?;
+        writeln!(out)?;
+        writeln!(out, "var (")?;
+        writeln!(out, "\tloadOnce sync.Once")?;
+        writeln!(out, "\tloadErr  error")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Load loads the Rust library from path and binds its functions. It must"
+        )?;
+        writeln!(
+            out,
+            "// be called before any other function of the package, and returns the"
+        )?;
+        writeln!(out, "// error of the first load on later calls.")?;
+        writeln!(out, "//")?;
+        writeln!(
+            out,
+            "// Without it, the first call into the library loads it from the path in"
+        )?;
+        writeln!(
+            out,
+            "// the {library_env} environment variable, or else finds lib{lib_name}.dylib"
+        )?;
+        writeln!(
+            out,
+            "// on the dynamic linker's search path, and panics if it cannot."
+        )?;
+        writeln!(out, "func Load(path string) error {{")?;
+        writeln!(out, "\tloadOnce.Do(func() {{ loadErr = load(path) }})")?;
+        writeln!(out, "\treturn loadErr")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ensureLoaded loads the Rust library from its default path on the first"
+        )?;
+        writeln!(out, "// call into it, unless Load did.")?;
+        writeln!(out, "func ensureLoaded() {{")?;
+        writeln!(out, "\tloadOnce.Do(func() {{")?;
+        writeln!(out, "\t\tpath := os.Getenv(libraryEnv)")?;
+        writeln!(out, "\t\tif path == \"\" {{")?;
+        writeln!(out, "\t\t\tpath = \"lib{lib_name}.dylib\"")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tloadErr = load(path)")?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "\tif loadErr != nil {{")?;
+        writeln!(out, "\t\tpanic(loadErr)")?;
         writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func load(path string) (err error) {{")?;
         writeln!(
             out,
-            "\ts := C.GoStringN((*C.char)(unsafe.Pointer(buf.ptr)), C.int(buf.len))"
+            "\tlib, err := purego.Dlopen(path, purego.RTLD_NOW|purego.RTLD_GLOBAL)"
         )?;
-        writeln!(out, "\tC.{prefix}_free_byte_buffer(buf)")?;
-        writeln!(out, "\treturn s")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"witffi: loading the Rust library: %w\", err)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\t// Binding a missing function panics")?;
+        writeln!(out, "\tdefer func() {{")?;
+        writeln!(out, "\t\tif r := recover(); r != nil {{")?;
+        writeln!(
+            out,
+            "\t\t\terr = fmt.Errorf(\"witffi: binding the Rust library %s: %v\", path, r)"
+        )?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}()")?;
+        writeln!(out, "\tfor _, f := range libFuncs {{")?;
+        writeln!(out, "\t\tpurego.RegisterLibFunc(f.fptr, lib, f.name)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif {prefix}_abi_hash() != abiHash {{")?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"witffi: bindings out of date: the Rust library was generated from a different version of the WIT world; re-run witffi and rebuild\")"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn nil")?;
         writeln!(out, "}}")?;
         writeln!(out)?;
+        writeln!(
+            out,
+            "// freeRustBox frees a value Rust boxed for the bindings."
+        )?;
+        writeln!(out, "func freeRustBox(ptr unsafe.Pointer) {{")?;
+        writeln!(out, "\t{free_box}(ptr)")?;
+        writeln!(out, "}}")?;
 
-        // ffiByteBufferToBytes
+        Ok(())
+    }
+
+    /// Generate the helpers purego wrappers convert values and read errors
+    /// with, named as their cgo counterparts.
+    fn generate_purego_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out, "// ---- Helpers ----")?;
+        writeln!(out)?;
         writeln!(
             out,
-            "func ffiByteBufferToBytes(buf C.FfiByteBuffer) []byte {{"
+            "// goStringToFfiByteSlice lends s to Rust, which must be kept alive until"
+        )?;
+        writeln!(out, "// the call returns.")?;
+        writeln!(out, "func goStringToFfiByteSlice(s string) ffiByteSlice {{")?;
+        writeln!(
+            out,
+            "\treturn ffiByteSlice{{ptr: unsafe.StringData(s), len: uintptr(len(s))}}"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// goBytesToFfiByteSlice lends b to Rust, which must be kept alive until"
+        )?;
+        writeln!(out, "// the call returns.")?;
+        writeln!(out, "func goBytesToFfiByteSlice(b []byte) ffiByteSlice {{")?;
+        writeln!(
+            out,
+            "\treturn ffiByteSlice{{ptr: unsafe.SliceData(b), len: uintptr(len(b))}}"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func ffiByteBufferToString(buf ffiByteBuffer) string {{"
         )?;
         writeln!(out, "\tif buf.ptr == nil || buf.len == 0 {{")?;
-        writeln!(out, "\t\tC.{prefix}_free_byte_buffer(buf)")?;
+        writeln!(out, "\t\t{prefix}_free_byte_buffer(buf)")?;
+        writeln!(out, "\t\treturn \"\"")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\ts := string(unsafe.Slice(buf.ptr, buf.len))")?;
+        writeln!(out, "\t{prefix}_free_byte_buffer(buf)")?;
+        writeln!(out, "\treturn s")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func ffiByteBufferToBytes(buf ffiByteBuffer) []byte {{"
+        )?;
+        writeln!(out, "\tif buf.ptr == nil || buf.len == 0 {{")?;
+        writeln!(out, "\t\t{prefix}_free_byte_buffer(buf)")?;
         writeln!(out, "\t\treturn nil")?;
         writeln!(out, "\t}}")?;
         writeln!(
             out,
-            "\tb := C.GoBytes(unsafe.Pointer(buf.ptr), C.int(buf.len))"
+            "\tb := append([]byte(nil), unsafe.Slice(buf.ptr, buf.len)...)"
         )?;
-        writeln!(out, "\tC.{prefix}_free_byte_buffer(buf)")?;
+        writeln!(out, "\t{prefix}_free_byte_buffer(buf)")?;
         writeln!(out, "\treturn b")?;
         writeln!(out, "}}")?;
         writeln!(out)?;
-
-        // readLastError
         writeln!(out, "func readLastError() string {{")?;
-        writeln!(out, "\tlength := C.{prefix}_last_error_length()")?;
+        writeln!(out, "\tlength := {prefix}_last_error_length()")?;
         writeln!(out, "\tif length <= 0 {{")?;
         writeln!(out, "\t\treturn \"unknown error\"")?;
         writeln!(out, "\t}}")?;
         writeln!(out, "\tbuf := make([]byte, length)")?;
-        writeln!(
-            out,
-            "\tC.{prefix}_error_message_utf8((*C.char)(unsafe.Pointer(&buf[0])), length)"
-        )?;
+        writeln!(out, "\t{prefix}_error_message_utf8(&buf[0], length)")?;
         writeln!(out, "\treturn string(buf[:length-1])")?;
         writeln!(out, "}}")?;
 
         Ok(())
     }
 
-    // ---- Reachable types ----
-
-    /// Collect all type IDs reachable from the world's exports,
-    /// following type aliases and nested type references.
-    /// Returns types in dependency order (dependencies before dependents).
-    fn collect_reachable_types(&self) -> Vec<TypeId> {
-        let mut visited = HashSet::new();
-        let mut order = Vec::new();
+    /// Write the start of the wrapper of `ef` for a backend other than
+    /// cgo, with the signature and docs of its cgo wrapper, up to the
+    /// deprecation warning. Returns whether a `//line` directive was
+    /// written, to be restored after the closing brace.
+    fn write_plain_function_start(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        param_names: &[String],
+    ) -> Result<bool, std::fmt::Error> {
+        let go_func_name = self.go_func_name(ef);
+        let result_decomposed = self.decompose_result(&ef.function.result);
+        let go_params: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .zip(param_names)
+            .map(|(p, name)| format!("{name} {}", self.type_to_go(&p.ty)))
+            .collect();
+        let go_return = match &result_decomposed {
+            Some((Some(ok_ty), _)) => format!(" ({}, error)", self.type_to_go(ok_ty)),
+            Some((None, _)) => " error".to_string(),
+            None => ef
+                .function
+                .result
+                .map(|ty| format!(" {}", self.type_to_go(&ty)))
+                .unwrap_or_default(),
+        };
 
-        let world = &self.resolve.worlds[self.world_id];
-        for (_key, item) in &world.exports {
-            if let wit_parser::WorldItem::Interface { id, .. } = item {
-                let iface = &self.resolve.interfaces[*id];
-                for (_name, type_id) in &iface.types {
-                    self.visit_type_id(*type_id, &mut visited, &mut order);
-                }
-                for (_fname, func) in &iface.functions {
-                    for p in &func.params {
-                        self.visit_type(&p.ty, &mut visited, &mut order);
-                    }
-                    if let Some(ty) = &func.result {
-                        self.visit_type(ty, &mut visited, &mut order);
-                    }
-                }
-            }
+        writeln!(out)?;
+        if let Some(docs) = &ef.function.docs.contents {
+            Self::write_decl_doc_comment(out, &go_func_name, docs, true)?;
         }
-
-        order
+        self.write_decl_notes(
+            out,
+            &ef.qualified_name(self.resolve),
+            ef.deprecated.as_deref(),
+            ef.function.docs.contents.is_some(),
+        )?;
+        let line_directive = self.config.line_directives.as_ref().zip(
+            self.config
+                .source_locations
+                .get(&ef.qualified_name(self.resolve)),
+        );
+        if let Some((dir, location)) = line_directive {
+            writeln!(out, "//line {dir}/{location}")?;
+        }
+        writeln!(
+            out,
+            "func {go_func_name}({}){go_return} {{",
+            go_params.join(", ")
+        )?;
+        self.write_deprecation_warning(out, ef)?;
+        Ok(line_directive.is_some())
     }
 
-    fn visit_type_id(
+    /// Generate the wrapper of `ef` calling it through purego, with the
+    /// signature and docs of its cgo wrapper.
+    fn generate_purego_function(
         &self,
-        type_id: TypeId,
-        visited: &mut HashSet<TypeId>,
-        order: &mut Vec<TypeId>,
-    ) {
-        if !visited.insert(type_id) {
-            return;
-        }
-
-        let typedef = &self.resolve.types[type_id];
+        out: &mut String,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        let result_decomposed = self.decompose_result(&ef.function.result);
+        let param_names: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| self.config.naming.to_go_ident(&p.name))
+            .collect();
+        let line_directive = self.write_plain_function_start(out, ef, &param_names)?;
+        writeln!(out, "\tensureLoaded()")?;
 
-        match &typedef.kind {
-            TypeDefKind::Record(record) => {
-                for field in &record.fields {
-                    self.visit_type(&field.ty, visited, order);
-                }
+        let args: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .zip(&param_names)
+            .map(|(p, name)| self.purego_lower(&p.ty, name))
+            .collect();
+        let call = format!("{c_func_name}({})", args.join(", "));
+        // Go memory lent to Rust must outlive the call
+        let mut keep_alive = String::new();
+        for (p, name) in ef.function.params.iter().zip(&param_names) {
+            if matches!(
+                self.plain_value(&p.ty),
+                Some(PlainValue::String | PlainValue::Bytes)
+            ) {
+                writeln!(keep_alive, "\truntime.KeepAlive({name})")?;
             }
-            TypeDefKind::Variant(variant) => {
-                for case in &variant.cases {
-                    if let Some(ty) = &case.ty {
-                        self.visit_type(ty, visited, order);
-                    }
-                }
+        }
+
+        match &result_decomposed {
+            Some((Some(ok_ty), _)) => {
+                let boxed = match self.plain_value(ok_ty) {
+                    Some(PlainValue::Scalar(go)) => go,
+                    _ => "ffiByteBuffer",
+                };
+                let zeros = self
+                    .error_return_zeros(ef, &result_decomposed)
+                    .unwrap_or_default();
+                writeln!(out, "\tresultPtr := {call}")?;
+                out.push_str(&keep_alive);
+                writeln!(out, "\tif resultPtr == nil {{")?;
+                writeln!(out, "\t\treturn {zeros}{}", self.last_error(&c_func_name))?;
+                writeln!(out, "\t}}")?;
+                let lifted = self.purego_lift(ok_ty, &format!("*(*{boxed})(resultPtr)"));
+                writeln!(out, "\tresult := {lifted}")?;
+                writeln!(out, "\tfreeRustBox(resultPtr)")?;
+                writeln!(out, "\treturn result, nil")?;
             }
-            TypeDefKind::List(ty) | TypeDefKind::Option(ty) => {
-                self.visit_type(ty, visited, order);
+            Some((None, _)) => {
+                writeln!(out, "\tsuccess := {call}")?;
+                out.push_str(&keep_alive);
+                writeln!(out, "\tif !success {{")?;
+                writeln!(out, "\t\treturn {}", self.last_error(&c_func_name))?;
+                writeln!(out, "\t}}")?;
+                writeln!(out, "\treturn nil")?;
             }
-            TypeDefKind::Result(r) => {
-                if let Some(ok) = &r.ok {
-                    self.visit_type(ok, visited, order);
+            None => match &ef.function.result {
+                Some(ret_ty) if keep_alive.is_empty() => {
+                    writeln!(out, "\treturn {}", self.purego_lift(ret_ty, &call))?;
                 }
-                if let Some(err) = &r.err {
-                    self.visit_type(err, visited, order);
+                Some(ret_ty) => {
+                    writeln!(out, "\tresult := {call}")?;
+                    out.push_str(&keep_alive);
+                    writeln!(out, "\treturn {}", self.purego_lift(ret_ty, "result"))?;
                 }
-            }
-            TypeDefKind::Tuple(tuple) => {
-                for ty in &tuple.types {
-                    self.visit_type(ty, visited, order);
+                None => {
+                    writeln!(out, "\t{call}")?;
+                    out.push_str(&keep_alive);
                 }
-            }
-            TypeDefKind::Type(ty) => {
-                self.visit_type(ty, visited, order);
-            }
-            _ => {}
+            },
+        }
+        writeln!(out, "}}")?;
+        if line_directive {
+            writeln!(out, "{}", Self::LINE_RESTORE)?;
         }
 
-        order.push(type_id);
+        Ok(())
     }
 
-    fn visit_type(&self, ty: &Type, visited: &mut HashSet<TypeId>, order: &mut Vec<TypeId>) {
-        if let Type::Id(id) = ty {
-            self.visit_type_id(*id, visited, order);
-        }
-    }
+    // ---- WebAssembly backends ----
 
-    // ---- Result decomposition ----
+    /// The Go module of wasmtime-go the wasmtime bindings import.
+    const WASMTIME_MODULE: &'static str = "github.com/bytecodealliance/wasmtime-go/v25";
 
-    /// Check if a function's return type is `result<T, E>` at the top level.
-    fn decompose_result(&self, result: &Option<Type>) -> Option<(Option<Type>, Option<Type>)> {
-        match result {
-            Some(Type::Id(id)) => {
-                let typedef = &self.resolve.types[*id];
-                if let TypeDefKind::Result(r) = &typedef.kind {
-                    Some((r.ok, r.err))
-                } else {
-                    None
-                }
-            }
-            _ => None,
+    /// Generate the bindings running the library as WebAssembly: the
+    /// embedded module and its instantiation by the backend's runtime, the
+    /// helpers, the types and a wrapper per exported function.
+    fn generate_wasm(&self, out: &mut String) -> std::fmt::Result {
+        let funcs = self.exported_functions();
+        // Feature-gated functions go in their own files, as with cgo
+        let funcs: Vec<&ExportedFunction> =
+            funcs.iter().filter(|ef| ef.feature.is_none()).collect();
+
+        let mut code = String::new();
+        self.generate_wasm_module(&mut code)?;
+        writeln!(code)?;
+        self.generate_types(&mut code)?;
+        writeln!(code)?;
+        writeln!(code, "// ---- Public API ----")?;
+        self.write_each(&mut code, &funcs, |out, ef| {
+            self.generate_wasm_function(out, ef)
+        })?;
+        if self.warns_deprecation() {
+            self.generate_deprecation_warnings(&mut code)?;
         }
-    }
 
-    // ---- Go type mapping ----
+        self.generate_header(out)?;
+        writeln!(out)?;
+        Self::write_wasm_imports(out, &code)?;
+        writeln!(out)?;
+        out.push_str(&code);
+        Ok(())
+    }
 
-    /// Map a WIT type to its idiomatic Go representation.
-    fn type_to_go(&self, ty: &Type) -> String {
-        match ty {
-            Type::Bool => "bool".to_string(),
-            Type::U8 => "uint8".to_string(),
-            Type::U16 => "uint16".to_string(),
-            Type::U32 => "uint32".to_string(),
-            Type::U64 => "uint64".to_string(),
-            Type::S8 => "int8".to_string(),
-            Type::S16 => "int16".to_string(),
-            Type::S32 => "int32".to_string(),
-            Type::S64 => "int64".to_string(),
-            Type::F32 => "float32".to_string(),
-            Type::F64 => "float64".to_string(),
-            Type::Char => "rune".to_string(),
-            Type::String => "string".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => "[]byte".to_string(),
-                    TypeDefKind::List(inner) => {
-                        format!("[]{}", self.type_to_go(inner))
-                    }
-                    TypeDefKind::Option(inner) => {
-                        format!("*{}", self.type_to_go(inner))
-                    }
-                    TypeDefKind::Type(aliased) => self.type_to_go(aliased),
-                    _ => {
-                        let name = typedef.name.as_deref().unwrap_or("Anonymous");
-                        names::to_go_type(name)
-                    }
-                }
-            }
-            Type::ErrorContext => "string".to_string(),
+    /// Generate the file of the functions gated by `feature` for the
+    /// WebAssembly backends, which calls the module of the main file.
+    fn generate_wasm_feature_file(
+        &self,
+        out: &mut String,
+        feature: &str,
+        funcs: &[ExportedFunction],
+    ) -> std::fmt::Result {
+        let mut code = String::new();
+        writeln!(code, "// ---- Public API (feature `{feature}`) ----")?;
+        for ef in funcs {
+            self.generate_wasm_function(&mut code, ef)?;
         }
+
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        writeln!(out, "//go:build {}", Self::feature_build_tag(feature))?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        Self::write_wasm_imports(out, &code)?;
+        writeln!(out)?;
+        out.push_str(&code);
+        Ok(())
     }
 
-    /// Map a WIT type to its CGo C type representation.
-    fn type_to_cgo(&self, ty: &Type) -> String {
-        match ty {
-            Type::Bool => "C.bool".to_string(),
-            Type::U8 => "C.uint8_t".to_string(),
-            Type::U16 => "C.uint16_t".to_string(),
-            Type::U32 => "C.uint32_t".to_string(),
-            Type::U64 => "C.uint64_t".to_string(),
-            Type::S8 => "C.int8_t".to_string(),
-            Type::S16 => "C.int16_t".to_string(),
-            Type::S32 => "C.int32_t".to_string(),
-            Type::S64 => "C.int64_t".to_string(),
-            Type::F32 => "C.float".to_string(),
-            Type::F64 => "C.double".to_string(),
-            Type::Char => "C.uint32_t".to_string(),
-            Type::String => "C.FfiByteBuffer".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(_) => "C.FfiByteBuffer".to_string(),
-                    TypeDefKind::Option(inner) => {
-                        format!("*{}", self.type_to_cgo(inner))
-                    }
-                    TypeDefKind::Type(aliased) => self.type_to_cgo(aliased),
-                    _ => {
-                        let name = typedef.name.as_deref().unwrap_or("Anonymous");
-                        format!("C.{}", names::to_c_type(&self.config.c_type_prefix, name))
-                    }
-                }
+    /// Write the imports WebAssembly bindings `code` uses, since Go rejects
+    /// unused imports.
+    fn write_wasm_imports(out: &mut String, code: &str) -> std::fmt::Result {
+        let std_imports: Vec<&str> = ["context", "embed", "fmt", "log/slog", "math", "os", "sync"]
+            .into_iter()
+            .filter(|import| match *import {
+                // Imported for `//go:embed` alone
+                "embed" => code.contains("//go:embed "),
+                _ => Self::uses_package(code, import),
+            })
+            .collect();
+        let runtime_imports: Vec<&str> = [
+            "github.com/tetratelabs/wazero",
+            "github.com/tetratelabs/wazero/api",
+            "github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1",
+        ]
+        .into_iter()
+        .filter(|import| Self::uses_package(code, import))
+        // Named `wasmtime`, not by the major version ending its path
+        .chain(Self::uses_package(code, "wasmtime").then_some(Self::WASMTIME_MODULE))
+        .collect();
+        writeln!(out, "import (")?;
+        for import in &std_imports {
+            match *import {
+                "embed" => writeln!(out, "\t_ \"embed\"")?,
+                _ => writeln!(out, "\t\"{import}\"")?,
             }
-            Type::ErrorContext => "C.uint32_t".to_string(),
         }
+        if !std_imports.is_empty() && !runtime_imports.is_empty() {
+            writeln!(out)?;
+        }
+        for import in &runtime_imports {
+            writeln!(out, "\t\"{import}\"")?;
+        }
+        writeln!(out, ")")
     }
 
-    // ---- Type generation ----
-
-    fn generate_types(&self, out: &mut String) -> std::fmt::Result {
-        writeln!(out, "// ---- Types ----")?;
+    /// Generate the embedded module, its instantiation on the first call
+    /// and the helpers wrappers call it and convert values with.
+    fn generate_wasm_module(&self, out: &mut String) -> std::fmt::Result {
+        let lib_name = &self.config.lib_name;
 
-        let reachable = self.collect_reachable_types();
-        for type_id in &reachable {
-            self.generate_type_def(out, *type_id)?;
+        writeln!(out, "// ---- WebAssembly module ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmBinary is the Rust library built for wasm32-wasip1, which `witffi"
+        )?;
+        writeln!(out, "// build` places beside the bindings.")?;
+        writeln!(out, "//")?;
+        writeln!(out, "//go:embed {lib_name}.wasm")?;
+        writeln!(out, "var wasmBinary []byte")?;
+        writeln!(out)?;
+        match self.config.backend {
+            GoBackend::Wasmtime => Self::generate_wasmtime_runtime(out)?,
+            _ => Self::generate_wazero_runtime(out)?,
         }
+        writeln!(out)?;
+        writeln!(out, "// ---- Helpers ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmLowerString copies s into the module's memory, for the library to"
+        )?;
+        writeln!(out, "// own, returning its pointer and length.")?;
+        writeln!(out, "func wasmLowerString(s string) (uint64, uint64) {{")?;
+        writeln!(out, "\treturn wasmLowerBytes([]byte(s))")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmLowerBytes copies b into the module's memory, for the library to"
+        )?;
+        writeln!(out, "// own, returning its pointer and length.")?;
+        writeln!(out, "func wasmLowerBytes(b []byte) (uint64, uint64) {{")?;
+        writeln!(out, "\tif len(b) == 0 {{")?;
+        writeln!(out, "\t\treturn 1, 0")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tptr := uint32(wasmCall(\"cabi_realloc\", 0, 0, 1, uint64(len(b))))"
+        )?;
+        writeln!(out, "\twasmWrite(ptr, b)")?;
+        writeln!(out, "\treturn uint64(ptr), uint64(len(b))")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// wasmBool lowers b to its core value.")?;
+        writeln!(out, "func wasmBool(b bool) uint64 {{")?;
+        writeln!(out, "\tif b {{")?;
+        writeln!(out, "\t\treturn 1")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn 0")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmLoad reads the little-endian value of size bytes at offset."
+        )?;
+        writeln!(out, "func wasmLoad(offset, size uint32) uint64 {{")?;
+        writeln!(out, "\tvar v uint64")?;
+        writeln!(out, "\tfor i, b := range wasmRead(offset, size) {{")?;
+        writeln!(out, "\t\tv |= uint64(b) << (8 * i)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn v")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmReadString copies the string whose pointer and length are at offset."
+        )?;
+        writeln!(out, "func wasmReadString(offset uint32) string {{")?;
+        writeln!(
+            out,
+            "\tptr, length := uint32(wasmLoad(offset, 4)), uint32(wasmLoad(offset+4, 4))"
+        )?;
+        writeln!(out, "\treturn string(wasmRead(ptr, length))")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmReadBytes copies the bytes whose pointer and length are at offset."
+        )?;
+        writeln!(out, "func wasmReadBytes(offset uint32) []byte {{")?;
+        writeln!(
+            out,
+            "\tptr, length := uint32(wasmLoad(offset, 4)), uint32(wasmLoad(offset+4, 4))"
+        )?;
+        writeln!(out, "\tif length == 0 {{")?;
+        writeln!(out, "\t\treturn nil")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\treturn append([]byte(nil), wasmRead(ptr, length)...)"
+        )?;
+        writeln!(out, "}}")?;
 
         Ok(())
     }
 
-    fn generate_type_def(&self, out: &mut String, type_id: TypeId) -> std::fmt::Result {
-        let typedef = &self.resolve.types[type_id];
-        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
-
-        match &typedef.kind {
-            TypeDefKind::Record(record) => {
-                let go_name = names::to_go_type(wit_name);
-                writeln!(out)?;
-                if let Some(docs) = &typedef.docs.contents {
-                    Self::write_doc_comment(out, docs, "")?;
-                }
-                writeln!(out, "type {go_name} struct {{")?;
-                for field in &record.fields {
-                    let field_name = names::to_go_field(&field.name);
-                    let field_type = self.type_to_go(&field.ty);
-                    if let Some(docs) = &field.docs.contents {
-                        Self::write_doc_comment(out, docs, "\t")?;
-                    }
-                    writeln!(out, "\t{field_name} {field_type}")?;
-                }
-                writeln!(out, "}}")?;
-            }
-
-            TypeDefKind::Variant(variant) => {
-                let go_name = names::to_go_type(wit_name);
-                let marker_name = names::to_go_ident(wit_name);
-                let marker_iface = format!("{marker_name}Variant");
-                let marker_method = format!("is{go_name}");
-
-                writeln!(out)?;
-                // Unexported marker interface
-                writeln!(out, "type {marker_iface} interface {{")?;
-                writeln!(out, "\t{marker_method}()")?;
-                writeln!(out, "}}")?;
-                writeln!(out)?;
-
-                // Public type alias
-                if let Some(docs) = &typedef.docs.contents {
-                    Self::write_doc_comment(out, docs, "")?;
-                }
-                writeln!(out, "type {go_name} = {marker_iface}")?;
-
-                // Concrete types for each variant case
-                for case in &variant.cases {
-                    let case_name = format!("{}{}", go_name, names::to_go_type(&case.name));
-                    writeln!(out)?;
-                    if let Some(docs) = &case.docs.contents {
-                        Self::write_doc_comment(out, docs, "")?;
-                    }
-                    if let Some(ty) = &case.ty {
-                        let payload_type = self.type_to_go(ty);
-                        writeln!(out, "type {case_name} struct {{ Value {payload_type} }}")?;
-                    } else {
-                        writeln!(out, "type {case_name} struct{{}}")?;
+    /// Generate the wazero instantiation of the module and the functions
+    /// the helpers reach it through: `wasmCall`, `wasmRead` and
+    /// `wasmWrite`.
+    fn generate_wazero_runtime(out: &mut String) -> std::fmt::Result {
+        writeln!(out, "var (")?;
+        writeln!(out, "\twasmOnce   sync.Once")?;
+        writeln!(out, "\twasmModule api.Module")?;
+        writeln!(out, "\twasmErr    error")?;
+        writeln!(
+            out,
+            "\t// wasmMu serializes calls, which share the module's return area"
+        )?;
+        writeln!(out, "\twasmMu sync.Mutex")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmInstance returns the module, instantiating the embedded library on"
+        )?;
+        writeln!(
+            out,
+            "// the first call. It panics if the library cannot be instantiated."
+        )?;
+        writeln!(out, "func wasmInstance() api.Module {{")?;
+        writeln!(out, "\twasmOnce.Do(func() {{")?;
+        writeln!(out, "\t\tctx := context.Background()")?;
+        writeln!(out, "\t\tr := wazero.NewRuntime(ctx)")?;
+        writeln!(
+            out,
+            "\t\tif _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {{"
+        )?;
+        writeln!(out, "\t\t\twasmErr = err")?;
+        writeln!(out, "\t\t\treturn")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tconfig := wazero.NewModuleConfig().")?;
+        writeln!(out, "\t\t\tWithStartFunctions(\"_initialize\").")?;
+        writeln!(out, "\t\t\tWithStdout(os.Stdout).")?;
+        writeln!(out, "\t\t\tWithStderr(os.Stderr)")?;
+        writeln!(
+            out,
+            "\t\twasmModule, wasmErr = r.InstantiateWithConfig(ctx, wasmBinary, config)"
+        )?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "\tif wasmErr != nil {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Errorf(\"witffi: instantiating the Rust library: %w\", wasmErr))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn wasmModule")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmCall calls the library's export name, returning its result if it"
+        )?;
+        writeln!(
+            out,
+            "// has one. It panics if the call traps, as a Rust panic does."
+        )?;
+        writeln!(
+            out,
+            "func wasmCall(name string, params ...uint64) uint64 {{"
+        )?;
+        writeln!(out, "\tfn := wasmInstance().ExportedFunction(name)")?;
+        writeln!(out, "\tif fn == nil {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Errorf(\"witffi: the Rust library does not export %s; is it registered with witffi_register_wasm!?\", name))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tresults, err := fn.Call(context.Background(), params...)"
+        )?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Errorf(\"witffi: calling %s: %w\", name, err))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif len(results) == 0 {{")?;
+        writeln!(out, "\t\treturn 0")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn results[0]")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmRead returns the size bytes of the module's memory at offset, which"
+        )?;
+        writeln!(out, "// the next call may overwrite.")?;
+        writeln!(out, "func wasmRead(offset, size uint32) []byte {{")?;
+        writeln!(out, "\tb, ok := wasmInstance().Memory().Read(offset, size)")?;
+        writeln!(out, "\tif !ok {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Sprintf(\"witffi: the Rust library returned memory out of range: %#x\", offset))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn b")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmWrite copies b into the module's memory at offset."
+        )?;
+        writeln!(out, "func wasmWrite(offset uint32, b []byte) {{")?;
+        writeln!(out, "\tif !wasmInstance().Memory().Write(offset, b) {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Sprintf(\"witffi: the Rust library allocated memory out of range: %#x\", offset))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")
+    }
+
+    /// Generate the wasmtime instantiation of the module and the functions
+    /// the helpers reach it through, as [`generate_wazero_runtime`] does.
+    /// wasmtime-go passes typed values, so `wasmCall` converts the core
+    /// values the wrappers pass as `uint64`s by the export's signature.
+    ///
+    /// [`generate_wazero_runtime`]: Self::generate_wazero_runtime
+    fn generate_wasmtime_runtime(out: &mut String) -> std::fmt::Result {
+        writeln!(out, "var (")?;
+        writeln!(out, "\twasmOnce   sync.Once")?;
+        writeln!(out, "\twasmStore  *wasmtime.Store")?;
+        writeln!(out, "\twasmModule *wasmtime.Instance")?;
+        writeln!(out, "\twasmMemory *wasmtime.Memory")?;
+        writeln!(out, "\twasmErr    error")?;
+        writeln!(
+            out,
+            "\t// wasmMu serializes calls, which share the module's return area and"
+        )?;
+        writeln!(out, "\t// its store, which is not safe for concurrent use")?;
+        writeln!(out, "\twasmMu sync.Mutex")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmInstance returns the module, instantiating the embedded library on"
+        )?;
+        writeln!(
+            out,
+            "// the first call. It panics if the library cannot be instantiated."
+        )?;
+        writeln!(out, "func wasmInstance() *wasmtime.Instance {{")?;
+        writeln!(out, "\twasmOnce.Do(func() {{")?;
+        writeln!(out, "\t\tengine := wasmtime.NewEngine()")?;
+        writeln!(
+            out,
+            "\t\tmodule, err := wasmtime.NewModule(engine, wasmBinary)"
+        )?;
+        writeln!(out, "\t\tif err != nil {{")?;
+        writeln!(out, "\t\t\twasmErr = err")?;
+        writeln!(out, "\t\t\treturn")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tlinker := wasmtime.NewLinker(engine)")?;
+        writeln!(out, "\t\tif err := linker.DefineWasi(); err != nil {{")?;
+        writeln!(out, "\t\t\twasmErr = err")?;
+        writeln!(out, "\t\t\treturn")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\twasi := wasmtime.NewWasiConfig()")?;
+        writeln!(out, "\t\twasi.InheritStdout()")?;
+        writeln!(out, "\t\twasi.InheritStderr()")?;
+        writeln!(out, "\t\twasmStore = wasmtime.NewStore(engine)")?;
+        writeln!(out, "\t\twasmStore.SetWasi(wasi)")?;
+        writeln!(
+            out,
+            "\t\tif wasmModule, wasmErr = linker.Instantiate(wasmStore, module); wasmErr != nil {{"
+        )?;
+        writeln!(out, "\t\t\treturn")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(
+            out,
+            "\t\tif init := wasmModule.GetFunc(wasmStore, \"_initialize\"); init != nil {{"
+        )?;
+        writeln!(
+            out,
+            "\t\t\tif _, wasmErr = init.Call(wasmStore); wasmErr != nil {{"
+        )?;
+        writeln!(out, "\t\t\t\treturn")?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(
+            out,
+            "\t\tmemory := wasmModule.GetExport(wasmStore, \"memory\")"
+        )?;
+        writeln!(out, "\t\tif memory == nil || memory.Memory() == nil {{")?;
+        writeln!(
+            out,
+            "\t\t\twasmErr = fmt.Errorf(\"the module exports no memory\")"
+        )?;
+        writeln!(out, "\t\t\treturn")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\twasmMemory = memory.Memory()")?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "\tif wasmErr != nil {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Errorf(\"witffi: instantiating the Rust library: %w\", wasmErr))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn wasmModule")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmCall calls the library's export name, returning its result if it"
+        )?;
+        writeln!(
+            out,
+            "// has one. It panics if the call traps, as a Rust panic does."
+        )?;
+        writeln!(
+            out,
+            "func wasmCall(name string, params ...uint64) uint64 {{"
+        )?;
+        writeln!(out, "\tfn := wasmInstance().GetFunc(wasmStore, name)")?;
+        writeln!(out, "\tif fn == nil {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Errorf(\"witffi: the Rust library does not export %s; is it registered with witffi_register_wasm!?\", name))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\ttypes := fn.Type(wasmStore).Params()")?;
+        writeln!(out, "\tif len(types) != len(params) {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Errorf(\"witffi: %s takes %d values, not %d\", name, len(types), len(params)))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\targs := make([]any, len(params))")?;
+        writeln!(out, "\tfor i, ty := range types {{")?;
+        writeln!(out, "\t\tswitch ty.Kind() {{")?;
+        writeln!(out, "\t\tcase wasmtime.KindI32:")?;
+        writeln!(out, "\t\t\targs[i] = int32(uint32(params[i]))")?;
+        writeln!(out, "\t\tcase wasmtime.KindI64:")?;
+        writeln!(out, "\t\t\targs[i] = int64(params[i])")?;
+        writeln!(out, "\t\tcase wasmtime.KindF32:")?;
+        writeln!(
+            out,
+            "\t\t\targs[i] = math.Float32frombits(uint32(params[i]))"
+        )?;
+        writeln!(out, "\t\tdefault:")?;
+        writeln!(out, "\t\t\targs[i] = math.Float64frombits(params[i])")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tresult, err := fn.Call(wasmStore, args...)")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Errorf(\"witffi: calling %s: %w\", name, err))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tswitch result := result.(type) {{")?;
+        writeln!(out, "\tcase int32:")?;
+        writeln!(out, "\t\treturn uint64(uint32(result))")?;
+        writeln!(out, "\tcase int64:")?;
+        writeln!(out, "\t\treturn uint64(result)")?;
+        writeln!(out, "\tcase float32:")?;
+        writeln!(out, "\t\treturn uint64(math.Float32bits(result))")?;
+        writeln!(out, "\tcase float64:")?;
+        writeln!(out, "\t\treturn math.Float64bits(result)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn 0")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmRead returns the size bytes of the module's memory at offset, which"
+        )?;
+        writeln!(out, "// the next call may overwrite.")?;
+        writeln!(out, "func wasmRead(offset, size uint32) []byte {{")?;
+        writeln!(out, "\twasmInstance()")?;
+        writeln!(out, "\tdata := wasmMemory.UnsafeData(wasmStore)")?;
+        writeln!(
+            out,
+            "\tif uint64(offset)+uint64(size) > uint64(len(data)) {{"
+        )?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Sprintf(\"witffi: the Rust library returned memory out of range: %#x\", offset))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn data[offset : offset+size]")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// wasmWrite copies b into the module's memory at offset."
+        )?;
+        writeln!(out, "func wasmWrite(offset uint32, b []byte) {{")?;
+        writeln!(out, "\twasmInstance()")?;
+        writeln!(out, "\tdata := wasmMemory.UnsafeData(wasmStore)")?;
+        writeln!(
+            out,
+            "\tif uint64(offset)+uint64(len(b)) > uint64(len(data)) {{"
+        )?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Sprintf(\"witffi: the Rust library allocated memory out of range: %#x\", offset))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tcopy(data[offset:], b)")?;
+        writeln!(out, "}}")
+    }
+
+    /// Generate the wrapper of `ef` calling its export of the module, with
+    /// the signature and docs of its cgo wrapper.
+    fn generate_wasm_function(&self, out: &mut String, ef: &ExportedFunction) -> std::fmt::Result {
+        // Unsupported functions are rejected by `check_backend`
+        let Some(sig) = canonical::wasm_signature(self.resolve, ef) else {
+            return Ok(());
+        };
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        let export_name = canonical::export_name(self.resolve, self.world_id, ef);
+        let result_decomposed = self.decompose_result(&ef.function.result);
+        let param_names: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| self.config.naming.to_go_ident(&p.name))
+            .collect();
+        let line_directive = self.write_plain_function_start(out, ef, &param_names)?;
+        writeln!(out, "\twasmMu.Lock()")?;
+        writeln!(out, "\tdefer wasmMu.Unlock()")?;
+
+        let mut args = vec![format!("\"{export_name}\"")];
+        for ((p, name), (_, value)) in ef.function.params.iter().zip(&param_names).zip(&sig.params)
+        {
+            let lower = match value {
+                ImportValue::String => "wasmLowerString",
+                ImportValue::Bytes => "wasmLowerBytes",
+                _ => {
+                    args.push(self.wasm_lower(&p.ty, name));
+                    continue;
+                }
+            };
+            let arg = if *value == ImportValue::String && self.type_to_go(&p.ty) != "string" {
+                format!("string({name})")
+            } else {
+                name.clone()
+            };
+            writeln!(out, "\t{name}Ptr, {name}Len := {lower}({arg})")?;
+            args.push(format!("{name}Ptr"));
+            args.push(format!("{name}Len"));
+        }
+        let call = format!("wasmCall({})", args.join(", "));
+        if canonical::returns_pointer(sig.result) {
+            writeln!(out, "\tresultPtr := uint32({call})")?;
+            writeln!(
+                out,
+                "\tdefer wasmCall(\"{}\", uint64(resultPtr))",
+                canonical::post_return_name(&export_name)
+            )?;
+        }
+
+        match (sig.result, &ef.function.result) {
+            (ImportResult::Value(ImportValue::Scalar(_)), Some(ret_ty)) => {
+                writeln!(out, "\treturn {}", self.wasm_lift(ret_ty, &call))?;
+            }
+            (ImportResult::Value(value), Some(ret_ty)) => {
+                writeln!(
+                    out,
+                    "\treturn {}",
+                    self.wasm_read(ret_ty, value, "resultPtr")
+                )?;
+            }
+            (ImportResult::Fallible(ok), _) => {
+                let offset = canonical::payload_offset(ok);
+                let zeros = self
+                    .error_return_zeros(ef, &result_decomposed)
+                    .unwrap_or_default();
+                writeln!(out, "\tif wasmLoad(resultPtr, 1) != 0 {{")?;
+                writeln!(
+                    out,
+                    "\t\treturn {zeros}fmt.Errorf(\"{c_func_name} failed: %s\", wasmReadString(resultPtr+{offset}))"
+                )?;
+                writeln!(out, "\t}}")?;
+                match (ok, result_decomposed.and_then(|(ok_ty, _)| ok_ty)) {
+                    (Some(value), Some(ok_ty)) => writeln!(
+                        out,
+                        "\treturn {}, nil",
+                        self.wasm_read(&ok_ty, value, &format!("resultPtr+{offset}"))
+                    )?,
+                    _ => writeln!(out, "\treturn nil")?,
+                }
+            }
+            _ => writeln!(out, "\t{call}")?,
+        }
+        writeln!(out, "}}")?;
+        if line_directive {
+            writeln!(out, "{}", Self::LINE_RESTORE)?;
+        }
+
+        Ok(())
+    }
+
+    /// The Go expression lowering `expr`, a Go scalar of `ty`, into its
+    /// core Wasm value.
+    fn wasm_lower(&self, ty: &Type, expr: &str) -> String {
+        let Some(PlainValue::Scalar(go)) = self.plain_value(ty) else {
+            return expr.to_string();
+        };
+        let value = if self.type_to_go(ty) == go {
+            expr.to_string()
+        } else {
+            format!("{go}({expr})")
+        };
+        match go {
+            "bool" => format!("wasmBool({value})"),
+            "int8" | "int16" | "int32" => format!("uint64(uint32({value}))"),
+            "float32" => format!("uint64(math.Float32bits({value}))"),
+            "float64" => format!("math.Float64bits({value})"),
+            "uint64" => value,
+            _ => format!("uint64({value})"),
+        }
+    }
+
+    /// The Go expression lifting `expr`, the core Wasm value of a scalar of
+    /// `ty` as a `uint64`, into the Go type of `ty`.
+    fn wasm_lift(&self, ty: &Type, expr: &str) -> String {
+        let Some(PlainValue::Scalar(go)) = self.plain_value(ty) else {
+            return expr.to_string();
+        };
+        let lifted = match go {
+            "bool" => format!("uint32({expr}) != 0"),
+            "float32" => format!("math.Float32frombits(uint32({expr}))"),
+            "float64" => format!("math.Float64frombits({expr})"),
+            "uint64" => expr.to_string(),
+            _ => format!("{go}({expr})"),
+        };
+        let go_ty = self.type_to_go(ty);
+        if go_ty == go {
+            lifted
+        } else {
+            format!("{go_ty}({lifted})")
+        }
+    }
+
+    /// The Go expression reading `value`, of `ty`, from the module's memory
+    /// at `at`, into the Go type of `ty`.
+    fn wasm_read(&self, ty: &Type, value: ImportValue, at: &str) -> String {
+        let (read, base) = match value {
+            ImportValue::String => (format!("wasmReadString({at})"), "string"),
+            ImportValue::Bytes => (format!("wasmReadBytes({at})"), "[]byte"),
+            _ => {
+                let (size, _) = canonical::size_align(value);
+                return self.wasm_lift(ty, &format!("wasmLoad({at}, {size})"));
+            }
+        };
+        let go_ty = self.type_to_go(ty);
+        if go_ty == base {
+            read
+        } else {
+            format!("{go_ty}({read})")
+        }
+    }
+
+    // ---- Sidecar backend ----
+
+    /// Generate the bindings running the library in a sidecar process: its
+    /// starting and the protocol calls cross it by (see [`ipc`]), the
+    /// types and a wrapper per exported function.
+    fn generate_sidecar(&self, out: &mut String) -> std::fmt::Result {
+        let funcs = self.exported_functions();
+        // Feature-gated functions go in their own files, as with cgo
+        let funcs: Vec<&ExportedFunction> =
+            funcs.iter().filter(|ef| ef.feature.is_none()).collect();
+
+        let mut code = String::new();
+        self.generate_sidecar_process(&mut code)?;
+        writeln!(code)?;
+        self.generate_types(&mut code)?;
+        writeln!(code)?;
+        writeln!(code, "// ---- Public API ----")?;
+        self.write_each(&mut code, &funcs, |out, ef| {
+            self.generate_sidecar_function(out, ef)
+        })?;
+        if self.warns_deprecation() {
+            self.generate_deprecation_warnings(&mut code)?;
+        }
+
+        self.generate_header(out)?;
+        writeln!(out)?;
+        Self::write_sidecar_imports(out, &code)?;
+        writeln!(out)?;
+        out.push_str(&code);
+        Ok(())
+    }
+
+    /// Generate the file of the functions gated by `feature` for the
+    /// sidecar backend, which calls the sidecar of the main file.
+    fn generate_sidecar_feature_file(
+        &self,
+        out: &mut String,
+        feature: &str,
+        funcs: &[ExportedFunction],
+    ) -> std::fmt::Result {
+        let mut code = String::new();
+        writeln!(code, "// ---- Public API (feature `{feature}`) ----")?;
+        for ef in funcs {
+            self.generate_sidecar_function(&mut code, ef)?;
+        }
+
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        writeln!(out, "//go:build {}", Self::feature_build_tag(feature))?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        Self::write_sidecar_imports(out, &code)?;
+        writeln!(out)?;
+        out.push_str(&code);
+        Ok(())
+    }
+
+    /// Write the imports sidecar bindings `code` uses, since Go rejects
+    /// unused imports.
+    fn write_sidecar_imports(out: &mut String, code: &str) -> std::fmt::Result {
+        writeln!(out, "import (")?;
+        for import in [
+            "encoding/binary",
+            "fmt",
+            "io",
+            "log/slog",
+            "math",
+            "os",
+            "os/exec",
+            "sync",
+        ] {
+            if Self::uses_package(code, import) {
+                writeln!(out, "\t\"{import}\"")?;
+            }
+        }
+        writeln!(out, ")")
+    }
+
+    /// Generate `Start` and `Stop`, the starting of the sidecar on the
+    /// first call, and the helpers wrappers call it and encode values with.
+    fn generate_sidecar_process(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+        let sidecar_env = format!("{}_SIDECAR", prefix.to_uppercase());
+        let sidecar_name = format!("{}-sidecar", self.config.lib_name);
+
+        writeln!(out, "// ---- Sidecar process ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// abiHash identifies the WIT world these bindings were generated from,"
+        )?;
+        writeln!(
+            out,
+            "// which the sidecar must have been generated from too."
+        )?;
+        writeln!(
+            out,
+            "const abiHash = {:#018x}",
+            witffi_core::abi_hash(self.resolve, self.world_id)
+        )?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarEnv names the environment variable giving the path of the"
+        )?;
+        writeln!(out, "// sidecar, when Start is not called.")?;
+        writeln!(out, "const sidecarEnv = \"{sidecar_env}\"")?;
+        writeln!(out)?;
+        writeln!(out, "var (")?;
+        writeln!(
+            out,
+            "\t// sidecarMu serializes calls, which share the sidecar's pipes"
+        )?;
+        writeln!(out, "\tsidecarMu   sync.Mutex")?;
+        writeln!(out, "\tsidecarPath string")?;
+        writeln!(out, "\tsidecarArgv []string")?;
+        writeln!(out, "\tsidecarCmd  *exec.Cmd")?;
+        writeln!(out, "\tsidecarIn   io.WriteCloser")?;
+        writeln!(out, "\tsidecarOut  io.Reader")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Start starts the sidecar the Rust library runs in from path, with args,"
+        )?;
+        writeln!(
+            out,
+            "// stopping any running, and returns an error if it cannot be started or"
+        )?;
+        writeln!(
+            out,
+            "// was generated from a different WIT world. Later starts, after the"
+        )?;
+        writeln!(out, "// sidecar exits, run the same binary.")?;
+        writeln!(out, "//")?;
+        writeln!(
+            out,
+            "// Without it, the first call into the library starts the binary at the"
+        )?;
+        writeln!(
+            out,
+            "// path in the {sidecar_env} environment variable, or else finds"
+        )?;
+        writeln!(out, "// {sidecar_name} on the PATH.")?;
+        writeln!(out, "func Start(path string, args ...string) error {{")?;
+        writeln!(out, "\tsidecarMu.Lock()")?;
+        writeln!(out, "\tdefer sidecarMu.Unlock()")?;
+        writeln!(out, "\tsidecarStop()")?;
+        writeln!(out, "\tsidecarPath, sidecarArgv = path, args")?;
+        writeln!(out, "\treturn sidecarStart()")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Stop stops the sidecar, if it runs, returning the error of its exit."
+        )?;
+        writeln!(out, "// The next call into the library starts it again.")?;
+        writeln!(out, "func Stop() error {{")?;
+        writeln!(out, "\tsidecarMu.Lock()")?;
+        writeln!(out, "\tdefer sidecarMu.Unlock()")?;
+        writeln!(out, "\treturn sidecarStop()")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarStart starts the sidecar and checks the ABI hash it greets with."
+        )?;
+        writeln!(out, "func sidecarStart() error {{")?;
+        writeln!(out, "\tpath := sidecarPath")?;
+        writeln!(out, "\tif path == \"\" {{")?;
+        writeln!(out, "\t\tpath = os.Getenv(sidecarEnv)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif path == \"\" {{")?;
+        writeln!(out, "\t\tpath = \"{sidecar_name}\"")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tcmd := exec.Command(path, sidecarArgv...)")?;
+        writeln!(out, "\t// Panic messages and logs pass through")?;
+        writeln!(out, "\tcmd.Stderr = os.Stderr")?;
+        writeln!(out, "\tin, err := cmd.StdinPipe()")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"witffi: starting the sidecar %s: %w\", path, err)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tstdout, err := cmd.StdoutPipe()")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"witffi: starting the sidecar %s: %w\", path, err)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif err := cmd.Start(); err != nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"witffi: starting the sidecar %s: %w\", path, err)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tsidecarCmd, sidecarIn, sidecarOut = cmd, in, stdout")?;
+        writeln!(out, "\thash, err := sidecarReadFrame()")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(out, "\t\tsidecarStop()")?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"witffi: starting the sidecar %s: %w\", path, err)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tif len(hash) != 8 || binary.LittleEndian.Uint64(hash) != abiHash {{"
+        )?;
+        writeln!(out, "\t\tsidecarStop()")?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"witffi: bindings out of date: the sidecar %s was generated from a different version of the WIT world; re-run witffi and rebuild\", path)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarStop closes the sidecar's stdin, which ends it, and waits for it"
+        )?;
+        writeln!(out, "// to exit.")?;
+        writeln!(out, "func sidecarStop() error {{")?;
+        writeln!(out, "\tif sidecarCmd == nil {{")?;
+        writeln!(out, "\t\treturn nil")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tsidecarIn.Close()")?;
+        writeln!(out, "\terr := sidecarCmd.Wait()")?;
+        writeln!(out, "\tsidecarCmd, sidecarIn, sidecarOut = nil, nil, nil")?;
+        writeln!(out, "\treturn err")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarCall calls the function with the given id, named name, with the"
+        )?;
+        writeln!(
+            out,
+            "// encoded args, starting the sidecar if it is not running, and returns the"
+        )?;
+        writeln!(
+            out,
+            "// encoded result. It returns an error if the function returns one, if it"
+        )?;
+        writeln!(
+            out,
+            "// panics, or if the sidecar cannot be started or exits during the call,"
+        )?;
+        writeln!(out, "// in which case the next call starts it again.")?;
+        writeln!(
+            out,
+            "func sidecarCall(id uint32, name string, args []byte) ([]byte, error) {{"
+        )?;
+        writeln!(out, "\tsidecarMu.Lock()")?;
+        writeln!(out, "\tdefer sidecarMu.Unlock()")?;
+        writeln!(out, "\tif sidecarCmd == nil {{")?;
+        writeln!(out, "\t\tif err := sidecarStart(); err != nil {{")?;
+        writeln!(out, "\t\t\treturn nil, err")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\trequest := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(args)), id)"
+        )?;
+        writeln!(
+            out,
+            "\tif err := sidecarWriteFrame(append(request, args...)); err != nil {{"
+        )?;
+        writeln!(out, "\t\treturn nil, sidecarExited(name, err)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tresponse, err := sidecarReadFrame()")?;
+        writeln!(out, "\tif err == nil && len(response) == 0 {{")?;
+        writeln!(out, "\t\terr = io.ErrUnexpectedEOF")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(out, "\t\treturn nil, sidecarExited(name, err)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tswitch response[0] {{")?;
+        writeln!(out, "\tcase {}:", ipc::STATUS_OK)?;
+        writeln!(out, "\t\treturn response[1:], nil")?;
+        writeln!(out, "\tcase {}:", ipc::STATUS_ERR)?;
+        writeln!(
+            out,
+            "\t\treturn nil, fmt.Errorf(\"%s failed: %s\", name, sidecarString(response[1:]))"
+        )?;
+        writeln!(out, "\tdefault:")?;
+        writeln!(
+            out,
+            "\t\treturn nil, fmt.Errorf(\"witffi: %s panicked: %s\", name, sidecarString(response[1:]))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarExited stops the sidecar after err broke a call to name, for the"
+        )?;
+        writeln!(
+            out,
+            "// next call to start it again, and returns the error to report."
+        )?;
+        writeln!(out, "func sidecarExited(name string, err error) error {{")?;
+        writeln!(out, "\tif exit := sidecarStop(); exit != nil {{")?;
+        writeln!(out, "\t\terr = exit")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\treturn fmt.Errorf(\"witffi: the sidecar exited during %s: %w\", name, err)"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// ---- Helpers ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarWriteFrame writes frame to the sidecar behind its length."
+        )?;
+        writeln!(out, "func sidecarWriteFrame(frame []byte) error {{")?;
+        writeln!(
+            out,
+            "\tb := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(frame)), uint32(len(frame)))"
+        )?;
+        writeln!(out, "\t_, err := sidecarIn.Write(append(b, frame...))")?;
+        writeln!(out, "\treturn err")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarReadFrame reads the next frame the sidecar writes."
+        )?;
+        writeln!(out, "func sidecarReadFrame() ([]byte, error) {{")?;
+        writeln!(out, "\tvar length [4]byte")?;
+        writeln!(
+            out,
+            "\tif _, err := io.ReadFull(sidecarOut, length[:]); err != nil {{"
+        )?;
+        writeln!(out, "\t\treturn nil, err")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tframe := make([]byte, binary.LittleEndian.Uint32(length[:]))"
+        )?;
+        writeln!(
+            out,
+            "\tif _, err := io.ReadFull(sidecarOut, frame); err != nil {{"
+        )?;
+        writeln!(out, "\t\treturn nil, err")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn frame, nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarAppendBool appends b to an encoding as one byte."
+        )?;
+        writeln!(out, "func sidecarAppendBool(args []byte, b bool) []byte {{")?;
+        writeln!(out, "\tif b {{")?;
+        writeln!(out, "\t\treturn append(args, 1)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn append(args, 0)")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarAppendString appends s to an encoding behind its length."
+        )?;
+        writeln!(
+            out,
+            "func sidecarAppendString(args []byte, s string) []byte {{"
+        )?;
+        writeln!(
+            out,
+            "\targs = binary.LittleEndian.AppendUint32(args, uint32(len(s)))"
+        )?;
+        writeln!(out, "\treturn append(args, s...)")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarAppendBytes appends b to an encoding behind its length."
+        )?;
+        writeln!(
+            out,
+            "func sidecarAppendBytes(args []byte, b []byte) []byte {{"
+        )?;
+        writeln!(
+            out,
+            "\targs = binary.LittleEndian.AppendUint32(args, uint32(len(b)))"
+        )?;
+        writeln!(out, "\treturn append(args, b...)")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarString decodes the string at the start of an encoding."
+        )?;
+        writeln!(out, "func sidecarString(b []byte) string {{")?;
+        writeln!(out, "\tlength := binary.LittleEndian.Uint32(b)")?;
+        writeln!(out, "\treturn string(b[4 : 4+length])")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// sidecarBytes decodes the bytes at the start of an encoding."
+        )?;
+        writeln!(out, "func sidecarBytes(b []byte) []byte {{")?;
+        writeln!(out, "\tlength := binary.LittleEndian.Uint32(b)")?;
+        writeln!(out, "\tif length == 0 {{")?;
+        writeln!(out, "\t\treturn nil")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn b[4 : 4+length : 4+length]")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the wrapper of `ef` calling it in the sidecar, with the
+    /// signature and docs of its cgo wrapper. A function that cannot return
+    /// an error panics with it.
+    fn generate_sidecar_function(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        // Functions are bound from the world's exports, which all have an id
+        let Some(id) = ipc::function_id(self.resolve, self.world_id, ef) else {
+            return Ok(());
+        };
+        let result_decomposed = self.decompose_result(&ef.function.result);
+        let param_names: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| self.config.naming.to_go_ident(&p.name))
+            .collect();
+        let line_directive = self.write_plain_function_start(out, ef, &param_names)?;
+
+        let mut args = "nil";
+        for (i, (p, name)) in ef.function.params.iter().zip(&param_names).enumerate() {
+            let (assign, to) = if i == 0 { (":=", "nil") } else { ("=", "args") };
+            writeln!(
+                out,
+                "\targs {assign} {}",
+                self.sidecar_append(&p.ty, to, name)
+            )?;
+            args = "args";
+        }
+        let call = format!("sidecarCall({id}, \"{c_func_name}\", {args})");
+
+        match &result_decomposed {
+            Some((ok_ty, _)) => {
+                let zeros = self
+                    .error_return_zeros(ef, &result_decomposed)
+                    .unwrap_or_default();
+                let payload = if ok_ty.is_some() { "payload" } else { "_" };
+                writeln!(out, "\t{payload}, err := {call}")?;
+                writeln!(out, "\tif err != nil {{")?;
+                writeln!(out, "\t\treturn {zeros}err")?;
+                writeln!(out, "\t}}")?;
+                match ok_ty {
+                    Some(ok_ty) => writeln!(
+                        out,
+                        "\treturn {}, nil",
+                        self.sidecar_decode(ok_ty, "payload")
+                    )?,
+                    None => writeln!(out, "\treturn nil")?,
+                }
+            }
+            None => {
+                let payload = if ef.function.result.is_some() {
+                    "payload"
+                } else {
+                    "_"
+                };
+                writeln!(out, "\t{payload}, err := {call}")?;
+                writeln!(out, "\tif err != nil {{")?;
+                writeln!(out, "\t\tpanic(err)")?;
+                writeln!(out, "\t}}")?;
+                if let Some(ret_ty) = &ef.function.result {
+                    writeln!(out, "\treturn {}", self.sidecar_decode(ret_ty, "payload"))?;
+                }
+            }
+        }
+        writeln!(out, "}}")?;
+        if line_directive {
+            writeln!(out, "{}", Self::LINE_RESTORE)?;
+        }
+
+        Ok(())
+    }
+
+    /// The Go expression appending `expr`, a Go value of `ty`, to the
+    /// encoding `to`.
+    fn sidecar_append(&self, ty: &Type, to: &str, expr: &str) -> String {
+        let go_ty = self.type_to_go(ty);
+        let convert = |base: &str| {
+            if go_ty == base {
+                expr.to_string()
+            } else {
+                format!("{base}({expr})")
+            }
+        };
+        match self.plain_value(ty) {
+            Some(PlainValue::Scalar("bool")) => {
+                format!("sidecarAppendBool({to}, {})", convert("bool"))
+            }
+            Some(PlainValue::Scalar("uint8")) => format!("append({to}, {})", convert("uint8")),
+            Some(PlainValue::Scalar("int8")) => format!("append({to}, byte({expr}))"),
+            Some(PlainValue::Scalar("float32")) => format!(
+                "binary.LittleEndian.AppendUint32({to}, math.Float32bits({}))",
+                convert("float32")
+            ),
+            Some(PlainValue::Scalar("float64")) => format!(
+                "binary.LittleEndian.AppendUint64({to}, math.Float64bits({}))",
+                convert("float64")
+            ),
+            Some(PlainValue::Scalar(go)) => {
+                let bits = go.trim_start_matches(|c: char| c.is_alphabetic());
+                format!(
+                    "binary.LittleEndian.AppendUint{bits}({to}, {})",
+                    convert(&format!("uint{bits}"))
+                )
+            }
+            Some(PlainValue::String) => format!("sidecarAppendString({to}, {})", convert("string")),
+            Some(PlainValue::Bytes) => format!("sidecarAppendBytes({to}, {expr})"),
+            // Rejected by `check_backend`
+            None => to.to_string(),
+        }
+    }
+
+    /// The Go expression decoding a value of `ty` from the start of the
+    /// encoding `from`, into the Go type of `ty`.
+    fn sidecar_decode(&self, ty: &Type, from: &str) -> String {
+        let (decoded, base) = match self.plain_value(ty) {
+            Some(PlainValue::Scalar("bool")) => (format!("{from}[0] != 0"), "bool"),
+            Some(PlainValue::Scalar("uint8")) => (format!("{from}[0]"), "uint8"),
+            Some(PlainValue::Scalar("int8")) => (format!("int8({from}[0])"), "int8"),
+            Some(PlainValue::Scalar("float32")) => (
+                format!("math.Float32frombits(binary.LittleEndian.Uint32({from}))"),
+                "float32",
+            ),
+            Some(PlainValue::Scalar("float64")) => (
+                format!("math.Float64frombits(binary.LittleEndian.Uint64({from}))"),
+                "float64",
+            ),
+            Some(PlainValue::Scalar(go)) => {
+                let bits = go.trim_start_matches(|c: char| c.is_alphabetic());
+                let read = format!("binary.LittleEndian.Uint{bits}({from})");
+                if go.starts_with("int") {
+                    (format!("{go}({read})"), go)
+                } else {
+                    (read, go)
+                }
+            }
+            Some(PlainValue::String) => (format!("sidecarString({from})"), "string"),
+            Some(PlainValue::Bytes) => (format!("sidecarBytes({from})"), "[]byte"),
+            // Rejected by `check_backend`
+            None => return from.to_string(),
+        };
+        let go_ty = self.type_to_go(ty);
+        if go_ty == base {
+            decoded
+        } else {
+            format!("{go_ty}({decoded})")
+        }
+    }
+
+    // ---- gRPC backend ----
+
+    /// Generate the bindings calling the library's gRPC service: the
+    /// connection and the encoding of messages, the types and a wrapper per
+    /// exported function. `package` is the proto package of the service.
+    fn generate_grpc(&self, out: &mut String, package: &str) -> std::fmt::Result {
+        let funcs = self.exported_functions();
+        // Feature-gated functions go in their own files, as with cgo
+        let funcs: Vec<&ExportedFunction> =
+            funcs.iter().filter(|ef| ef.feature.is_none()).collect();
+
+        let mut code = String::new();
+        self.generate_grpc_client(&mut code)?;
+        writeln!(code)?;
+        self.generate_types(&mut code)?;
+        writeln!(code)?;
+        writeln!(code, "// ---- Public API ----")?;
+        self.write_each(&mut code, &funcs, |out, ef| {
+            self.generate_grpc_function(out, package, ef)
+        })?;
+        if self.warns_deprecation() {
+            self.generate_deprecation_warnings(&mut code)?;
+        }
+
+        self.generate_header(out)?;
+        writeln!(out)?;
+        Self::write_grpc_imports(out, &code)?;
+        writeln!(out)?;
+        out.push_str(&code);
+        Ok(())
+    }
+
+    /// Generate the file of the functions gated by `feature` for the gRPC
+    /// backend, which calls through the connection of the main file.
+    fn generate_grpc_feature_file(
+        &self,
+        out: &mut String,
+        package: &str,
+        feature: &str,
+        funcs: &[ExportedFunction],
+    ) -> std::fmt::Result {
+        let mut code = String::new();
+        writeln!(code, "// ---- Public API (feature `{feature}`) ----")?;
+        for ef in funcs {
+            self.generate_grpc_function(&mut code, package, ef)?;
+        }
+
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        writeln!(out, "//go:build {}", Self::feature_build_tag(feature))?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        Self::write_grpc_imports(out, &code)?;
+        writeln!(out)?;
+        out.push_str(&code);
+        Ok(())
+    }
+
+    /// Write the imports gRPC bindings `code` uses, since Go rejects unused
+    /// imports.
+    fn write_grpc_imports(out: &mut String, code: &str) -> std::fmt::Result {
+        let std_imports: Vec<&str> = ["context", "fmt", "log/slog", "math", "sync"]
+            .into_iter()
+            .filter(|import| Self::uses_package(code, import))
+            .collect();
+        let grpc_imports: Vec<&str> = [
+            "google.golang.org/grpc",
+            "google.golang.org/grpc/codes",
+            "google.golang.org/grpc/status",
+            "google.golang.org/protobuf/encoding/protowire",
+        ]
+        .into_iter()
+        .filter(|import| Self::uses_package(code, import))
+        .collect();
+        writeln!(out, "import (")?;
+        for import in &std_imports {
+            writeln!(out, "\t\"{import}\"")?;
+        }
+        if !std_imports.is_empty() && !grpc_imports.is_empty() {
+            writeln!(out)?;
+        }
+        for import in &grpc_imports {
+            writeln!(out, "\t\"{import}\"")?;
+        }
+        writeln!(out, ")")
+    }
+
+    /// Generate `Dial` and `UseConn`, the codec passing messages the
+    /// bindings encode themselves, and the helpers wrappers call the
+    /// service and encode values with.
+    fn generate_grpc_client(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// ---- gRPC client ----")?;
+        writeln!(out)?;
+        writeln!(out, "var (")?;
+        writeln!(out, "\tgrpcMu   sync.Mutex")?;
+        writeln!(out, "\tgrpcConn grpc.ClientConnInterface")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Dial connects the bindings to the gRPC service of the Rust library at"
+        )?;
+        writeln!(
+            out,
+            "// target, with opts, which must give the connection's credentials (e.g."
+        )?;
+        writeln!(
+            out,
+            "// grpc.WithTransportCredentials). It, or UseConn, must be called before"
+        )?;
+        writeln!(out, "// any other function of the package.")?;
+        writeln!(
+            out,
+            "func Dial(target string, opts ...grpc.DialOption) error {{"
+        )?;
+        writeln!(out, "\tconn, err := grpc.NewClient(target, opts...)")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"witffi: connecting to %s: %w\", target, err)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tUseConn(conn)")?;
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// UseConn makes the bindings call the gRPC service through conn, a"
+        )?;
+        writeln!(
+            out,
+            "// connection set up by the caller (with interceptors setting deadlines,"
+        )?;
+        writeln!(out, "// say).")?;
+        writeln!(out, "func UseConn(conn grpc.ClientConnInterface) {{")?;
+        writeln!(out, "\tgrpcMu.Lock()")?;
+        writeln!(out, "\tdefer grpcMu.Unlock()")?;
+        writeln!(out, "\tgrpcConn = conn")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// grpcCodec passes the messages the bindings encode themselves in the"
+        )?;
+        writeln!(
+            out,
+            "// protobuf wire format, under the name of the protobuf codec."
+        )?;
+        writeln!(out, "type grpcCodec struct{{}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func (grpcCodec) Marshal(v any) ([]byte, error) {{ return *v.(*[]byte), nil }}"
+        )?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func (grpcCodec) Unmarshal(data []byte, v any) error {{"
+        )?;
+        writeln!(out, "\t*v.(*[]byte) = append([]byte(nil), data...)")?;
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func (grpcCodec) Name() string {{ return \"proto\" }}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// grpcCall calls the RPC at method, of the function named name, with the"
+        )?;
+        writeln!(
+            out,
+            "// encoded request, and returns the value of the response: as a number for"
+        )?;
+        writeln!(
+            out,
+            "// a varint or fixed-width field, or as bytes. It returns an error if the"
+        )?;
+        writeln!(
+            out,
+            "// function returns one, or if the call fails, as it does when the"
+        )?;
+        writeln!(out, "// function panics.")?;
+        writeln!(
+            out,
+            "func grpcCall(method, name string, request []byte) (uint64, []byte, error) {{"
+        )?;
+        writeln!(out, "\tgrpcMu.Lock()")?;
+        writeln!(out, "\tconn := grpcConn")?;
+        writeln!(out, "\tgrpcMu.Unlock()")?;
+        writeln!(out, "\tif conn == nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn 0, nil, fmt.Errorf(\"witffi: calling %s: no gRPC connection; call Dial or UseConn first\", name)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tvar response []byte")?;
+        writeln!(
+            out,
+            "\terr := conn.Invoke(context.Background(), method, &request, &response, grpc.ForceCodec(grpcCodec{{}}))"
+        )?;
+        writeln!(out, "\tswitch status.Code(err) {{")?;
+        writeln!(out, "\tcase codes.OK:")?;
+        writeln!(
+            out,
+            "\t// The status the service fails a function returning an error with"
+        )?;
+        writeln!(out, "\tcase codes.Unknown:")?;
+        writeln!(
+            out,
+            "\t\treturn 0, nil, fmt.Errorf(\"%s failed: %s\", name, status.Convert(err).Message())"
+        )?;
+        writeln!(out, "\tdefault:")?;
+        writeln!(
+            out,
+            "\t\treturn 0, nil, fmt.Errorf(\"witffi: calling %s: %w\", name, err)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tnumber, value, err := grpcValue(response)")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn 0, nil, fmt.Errorf(\"witffi: decoding the response of %s: %w\", name, err)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn number, value, nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// ---- Helpers ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// grpcValue decodes the value field of the response message b. A missing"
+        )?;
+        writeln!(
+            out,
+            "// field, which proto3 leaves out when it holds its zero value, decodes as"
+        )?;
+        writeln!(out, "// zero.")?;
+        writeln!(out, "func grpcValue(b []byte) (uint64, []byte, error) {{")?;
+        writeln!(out, "\tvar number uint64")?;
+        writeln!(out, "\tvar value []byte")?;
+        writeln!(out, "\tfor len(b) > 0 {{")?;
+        writeln!(out, "\t\tnum, typ, n := protowire.ConsumeTag(b)")?;
+        writeln!(out, "\t\tif n < 0 {{")?;
+        writeln!(out, "\t\t\treturn 0, nil, protowire.ParseError(n)")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tb = b[n:]")?;
+        writeln!(out, "\t\tswitch {{")?;
+        writeln!(out, "\t\tcase num != 1:")?;
+        writeln!(out, "\t\t\tn = protowire.ConsumeFieldValue(num, typ, b)")?;
+        writeln!(out, "\t\tcase typ == protowire.VarintType:")?;
+        writeln!(out, "\t\t\tnumber, n = protowire.ConsumeVarint(b)")?;
+        writeln!(out, "\t\tcase typ == protowire.Fixed32Type:")?;
+        writeln!(out, "\t\t\tvar v uint32")?;
+        writeln!(out, "\t\t\tv, n = protowire.ConsumeFixed32(b)")?;
+        writeln!(out, "\t\t\tnumber = uint64(v)")?;
+        writeln!(out, "\t\tcase typ == protowire.Fixed64Type:")?;
+        writeln!(out, "\t\t\tnumber, n = protowire.ConsumeFixed64(b)")?;
+        writeln!(out, "\t\tdefault:")?;
+        writeln!(out, "\t\t\tvalue, n = protowire.ConsumeBytes(b)")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tif n < 0 {{")?;
+        writeln!(out, "\t\t\treturn 0, nil, protowire.ParseError(n)")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tb = b[n:]")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn number, value, nil")?;
+        writeln!(out, "}}")?;
+        for (kind, go, wire, append) in [
+            ("Varint", "uint64", "VarintType", "AppendVarint"),
+            ("Fixed32", "uint32", "Fixed32Type", "AppendFixed32"),
+            ("Fixed64", "uint64", "Fixed64Type", "AppendFixed64"),
+            ("String", "string", "BytesType", "AppendString"),
+            ("Bytes", "[]byte", "BytesType", "AppendBytes"),
+        ] {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// grpcAppend{kind} appends field num of the {} v to the message b.",
+                kind.to_lowercase()
+            )?;
+            writeln!(
+                out,
+                "func grpcAppend{kind}(b []byte, num protowire.Number, v {go}) []byte {{"
+            )?;
+            writeln!(out, "\tb = protowire.AppendTag(b, num, protowire.{wire})")?;
+            writeln!(out, "\treturn protowire.{append}(b, v)")?;
+            writeln!(out, "}}")?;
+        }
+
+        Ok(())
+    }
+
+    /// Generate the wrapper of `ef` calling its RPC, with the signature and
+    /// docs of its cgo wrapper, in the proto package `package`. A function
+    /// that cannot return an error panics with it.
+    fn generate_grpc_function(
+        &self,
+        out: &mut String,
+        package: &str,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        let method = proto::method_path(self.resolve, self.world_id, package, ef);
+        let result_decomposed = self.decompose_result(&ef.function.result);
+        let param_names: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| self.config.naming.to_go_ident(&p.name))
+            .collect();
+        let line_directive = self.write_plain_function_start(out, ef, &param_names)?;
+
+        let mut request = "nil";
+        for (i, (p, name)) in ef.function.params.iter().zip(&param_names).enumerate() {
+            let (assign, to) = if i == 0 {
+                (":=", "nil")
+            } else {
+                ("=", "request")
+            };
+            writeln!(
+                out,
+                "\trequest {assign} {}",
+                self.grpc_append(&p.ty, to, i + 1, name)
+            )?;
+            request = "request";
+        }
+        let call = format!("grpcCall(\"{method}\", \"{c_func_name}\", {request})");
+        // The value the result is decoded from, if there is one
+        let returned = match &result_decomposed {
+            Some((ok_ty, _)) => *ok_ty,
+            None => ef.function.result,
+        };
+        let results = match returned.and_then(|ty| self.plain_value(&ty)) {
+            Some(PlainValue::Scalar(_)) => "number, _, err",
+            Some(PlainValue::String | PlainValue::Bytes) => "_, value, err",
+            None => "_, _, err",
+        };
+        writeln!(out, "\t{results} := {call}")?;
+        writeln!(out, "\tif err != nil {{")?;
+        match &result_decomposed {
+            Some(_) => {
+                let zeros = self
+                    .error_return_zeros(ef, &result_decomposed)
+                    .unwrap_or_default();
+                writeln!(out, "\t\treturn {zeros}err")?;
+                writeln!(out, "\t}}")?;
+                match returned {
+                    Some(ty) => writeln!(out, "\treturn {}, nil", self.grpc_decode(&ty))?,
+                    None => writeln!(out, "\treturn nil")?,
+                }
+            }
+            None => {
+                writeln!(out, "\t\tpanic(err)")?;
+                writeln!(out, "\t}}")?;
+                if let Some(ty) = returned {
+                    writeln!(out, "\treturn {}", self.grpc_decode(&ty))?;
+                }
+            }
+        }
+        writeln!(out, "}}")?;
+        if line_directive {
+            writeln!(out, "{}", Self::LINE_RESTORE)?;
+        }
+
+        Ok(())
+    }
+
+    /// The Go expression appending `expr`, a Go value of `ty`, to the
+    /// message `to` as field `num`.
+    fn grpc_append(&self, ty: &Type, to: &str, num: usize, expr: &str) -> String {
+        let go_ty = self.type_to_go(ty);
+        let convert = |base: &str| {
+            if go_ty == base {
+                expr.to_string()
+            } else {
+                format!("{base}({expr})")
+            }
+        };
+        match self.plain_value(ty) {
+            Some(PlainValue::Scalar("bool")) => format!(
+                "grpcAppendVarint({to}, {num}, protowire.EncodeBool({}))",
+                convert("bool")
+            ),
+            Some(PlainValue::Scalar("float32")) => format!(
+                "grpcAppendFixed32({to}, {num}, math.Float32bits({}))",
+                convert("float32")
+            ),
+            Some(PlainValue::Scalar("float64")) => format!(
+                "grpcAppendFixed64({to}, {num}, math.Float64bits({}))",
+                convert("float64")
+            ),
+            // Signed integers are sign-extended, as proto's int32 and int64
+            // are
+            Some(PlainValue::Scalar(_)) => {
+                format!("grpcAppendVarint({to}, {num}, {})", convert("uint64"))
+            }
+            Some(PlainValue::String) => {
+                format!("grpcAppendString({to}, {num}, {})", convert("string"))
+            }
+            Some(PlainValue::Bytes) => format!("grpcAppendBytes({to}, {num}, {expr})"),
+            // Rejected by `check_backend`
+            None => to.to_string(),
+        }
+    }
+
+    /// The Go expression decoding a value of `ty` from `number` or `value`,
+    /// as `grpcCall` returns them, into the Go type of `ty`.
+    fn grpc_decode(&self, ty: &Type) -> String {
+        let (decoded, base) = match self.plain_value(ty) {
+            Some(PlainValue::Scalar("bool")) => ("number != 0".to_string(), "bool"),
+            Some(PlainValue::Scalar("float32")) => (
+                "math.Float32frombits(uint32(number))".to_string(),
+                "float32",
+            ),
+            Some(PlainValue::Scalar("float64")) => {
+                ("math.Float64frombits(number)".to_string(), "float64")
+            }
+            Some(PlainValue::Scalar("uint64")) => ("number".to_string(), "uint64"),
+            Some(PlainValue::Scalar(go)) => (format!("{go}(number)"), go),
+            Some(PlainValue::String) => ("string(value)".to_string(), "string"),
+            Some(PlainValue::Bytes) => ("value".to_string(), "[]byte"),
+            // Rejected by `check_backend`
+            None => return "number".to_string(),
+        };
+        let go_ty = self.type_to_go(ty);
+        if go_ty == base {
+            decoded
+        } else {
+            format!("{go_ty}({decoded})")
+        }
+    }
+
+    // ---- Allocation accounting files ----
+
+    /// The build tag switching on allocation accounting and the creation
+    /// stacks of live handles.
+    const DEBUG_TAG: &'static str = "witffidebug";
+
+    /// Free a value Rust boxed for the bindings, through `freeRustBox` when
+    /// allocation accounting may be on, and through the library when its
+    /// allocator is not C's.
+    fn free_rust_box(&self, ptr: &str) -> String {
+        if self.config.alloc_accounting {
+            format!("freeRustBox(unsafe.Pointer({ptr}))")
+        } else if self.config.alloc_canaries {
+            format!("C.{}_free_box(unsafe.Pointer({ptr}))", self.c_func_prefix())
+        } else {
+            format!("C.free(unsafe.Pointer({ptr}))")
+        }
+    }
+
+    fn generate_alloc_accounting_debug_file(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        writeln!(out, "//go:build {}", Self::DEBUG_TAG)?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        writeln!(out, "/*")?;
+        writeln!(out, "#include \"witffi_types.h\"")?;
+        writeln!(out, "#include \"ffi.h\"")?;
+        writeln!(out, "*/")?;
+        writeln!(out, "import \"C\"")?;
+        writeln!(out)?;
+        writeln!(out, "import (")?;
+        writeln!(out, "\t\"strconv\"")?;
+        writeln!(out, "\t\"strings\"")?;
+        writeln!(out, "\t\"testing\"")?;
+        writeln!(out, "\t\"unsafe\"")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// freeRustBox frees a value Rust boxed for the bindings, through the"
+        )?;
+        writeln!(out, "// library so that it can account for the free.")?;
+        writeln!(out, "func freeRustBox(p unsafe.Pointer) {{")?;
+        writeln!(out, "\tC.{prefix}_free_box(p)")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// AllocStat counts the values of one type the library has handed across"
+        )?;
+        writeln!(out, "// the FFI, and how many of them were freed again.")?;
+        writeln!(out, "type AllocStat struct {{")?;
+        writeln!(out, "\tKind   string")?;
+        writeln!(out, "\tAllocs uint64")?;
+        writeln!(out, "\tFrees  uint64")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Live returns the number of allocations not yet freed."
+        )?;
+        writeln!(out, "func (s AllocStat) Live() uint64 {{")?;
+        writeln!(out, "\tif s.Frees > s.Allocs {{")?;
+        writeln!(out, "\t\treturn 0")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn s.Allocs - s.Frees")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// AllocStats returns the library's allocation counts, one entry per type."
+        )?;
+        writeln!(
+            out,
+            "// The library only keeps them when built with the witffi-types"
+        )?;
+        writeln!(
+            out,
+            "// alloc-accounting feature; otherwise the result is empty."
+        )?;
+        writeln!(out, "func AllocStats() []AllocStat {{")?;
+        writeln!(
+            out,
+            "\ttext := ffiByteBufferToString(C.{prefix}_alloc_stats())"
+        )?;
+        writeln!(out, "\tvar stats []AllocStat")?;
+        writeln!(
+            out,
+            "\tfor _, line := range strings.Split(text, \"\\n\") {{"
+        )?;
+        writeln!(out, "\t\tfields := strings.Split(line, \"\\t\")")?;
+        writeln!(out, "\t\tif len(fields) != 3 {{")?;
+        writeln!(out, "\t\t\tcontinue")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tallocs, _ := strconv.ParseUint(fields[1], 10, 64)")?;
+        writeln!(out, "\t\tfrees, _ := strconv.ParseUint(fields[2], 10, 64)")?;
+        writeln!(
+            out,
+            "\t\tstats = append(stats, AllocStat{{Kind: fields[0], Allocs: allocs, Frees: frees}})"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn stats")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// CheckNoLeaks fails t when it ends if any type has more live allocations"
+        )?;
+        writeln!(
+            out,
+            "// than when CheckNoLeaks was called. Tests using it must not run in"
+        )?;
+        writeln!(out, "// parallel with other tests calling the library.")?;
+        writeln!(out, "func CheckNoLeaks(t testing.TB) {{")?;
+        writeln!(out, "\tt.Helper()")?;
+        writeln!(out, "\tbefore := liveAllocs()")?;
+        writeln!(out, "\tt.Cleanup(func() {{")?;
+        writeln!(out, "\t\tfor _, stat := range AllocStats() {{")?;
+        writeln!(
+            out,
+            "\t\t\tif live := stat.Live(); live > before[stat.Kind] {{"
+        )?;
+        writeln!(
+            out,
+            "\t\t\t\tt.Errorf(\"%d %s allocation(s) leaked\", live-before[stat.Kind], stat.Kind)"
+        )?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func liveAllocs() map[string]uint64 {{")?;
+        writeln!(out, "\tlive := make(map[string]uint64)")?;
+        writeln!(out, "\tfor _, stat := range AllocStats() {{")?;
+        writeln!(out, "\t\tlive[stat.Kind] = stat.Live()")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn live")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    fn generate_alloc_accounting_release_file(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        writeln!(out, "//go:build !{}", Self::DEBUG_TAG)?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        writeln!(out, "/*")?;
+        if self.config.alloc_canaries {
+            writeln!(out, "#include \"witffi_types.h\"")?;
+            writeln!(out, "#include \"ffi.h\"")?;
+        } else {
+            writeln!(out, "#include <stdlib.h>")?;
+        }
+        writeln!(out, "*/")?;
+        writeln!(out, "import \"C\"")?;
+        writeln!(out)?;
+        writeln!(out, "import \"unsafe\"")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// freeRustBox frees a value Rust boxed for the bindings."
+        )?;
+        writeln!(out, "func freeRustBox(p unsafe.Pointer) {{")?;
+        if self.config.alloc_canaries {
+            writeln!(out, "\tC.{}_free_box(p)", self.c_func_prefix())?;
+        } else {
+            writeln!(out, "\tC.free(p)")?;
+        }
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    // ---- Call dump files ----
+
+    /// The build tag switching on call dumps.
+    const CALL_DUMP_TAG: &'static str = "witffidump";
+
+    fn generate_call_dump_debug_file(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        writeln!(out, "//go:build {}", Self::CALL_DUMP_TAG)?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        writeln!(out, "import (")?;
+        writeln!(out, "\t\"context\"")?;
+        writeln!(out, "\t\"fmt\"")?;
+        writeln!(out, "\t\"log/slog\"")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// callDumpEnabled is whether wrappers call dumpCall, which the {} build",
+            Self::CALL_DUMP_TAG
+        )?;
+        writeln!(out, "// tag switches on.")?;
+        writeln!(out, "const callDumpEnabled = true")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// callDumpMaxLen is the length in bytes past which dumped values are"
+        )?;
+        writeln!(out, "// truncated.")?;
+        writeln!(
+            out,
+            "const callDumpMaxLen = {}",
+            self.config.call_dump_max_len
+        )?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// dumpValue formats a dumped value, truncated to callDumpMaxLen bytes."
+        )?;
+        writeln!(out, "func dumpValue(value any) string {{")?;
+        writeln!(out, "\ttext := fmt.Sprintf(\"%+v\", value)")?;
+        writeln!(out, "\tif len(text) > callDumpMaxLen {{")?;
+        writeln!(
+            out,
+            "\t\ttext = fmt.Sprintf(\"%s... (%d bytes)\", text[:callDumpMaxLen], len(text))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn text")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// dumpCall logs the call to name, with its arguments and results, to the"
+        )?;
+        writeln!(out, "// default slog logger at Debug level.")?;
+        writeln!(
+            out,
+            "func dumpCall(name string, params []string, args []any, resultNames []string, results []any) {{"
+        )?;
+        writeln!(out, "\tctx := context.Background()")?;
+        writeln!(out, "\tif !slog.Default().Enabled(ctx, slog.LevelDebug) {{")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\targAttrs := make([]any, len(args))")?;
+        writeln!(out, "\tfor i, arg := range args {{")?;
+        writeln!(
+            out,
+            "\t\targAttrs[i] = slog.String(params[i], dumpValue(arg))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tresultAttrs := make([]any, len(results))")?;
+        writeln!(out, "\tfor i, result := range results {{")?;
+        writeln!(
+            out,
+            "\t\tresultAttrs[i] = slog.String(resultNames[i], dumpValue(result))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tslog.Default().LogAttrs(ctx, slog.LevelDebug, \"witffi call\", slog.String(\"function\", name), slog.Group(\"args\", argAttrs...), slog.Group(\"results\", resultAttrs...))"
+        )?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    fn generate_call_dump_release_file(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        writeln!(out, "//go:build !{}", Self::CALL_DUMP_TAG)?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// callDumpEnabled is whether wrappers call dumpCall, which the {} build",
+            Self::CALL_DUMP_TAG
+        )?;
+        writeln!(out, "// tag switches on.")?;
+        writeln!(out, "const callDumpEnabled = false")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func dumpCall(name string, params []string, args []any, resultNames []string, results []any) {{}}"
+        )?;
+
+        Ok(())
+    }
+
+    // ---- Handle table files ----
+
+    fn generate_handle_stacks_file(&self, out: &mut String, debug: bool) -> std::fmt::Result {
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        let negation = if debug { "" } else { "!" };
+        writeln!(out, "//go:build {negation}{}", Self::DEBUG_TAG)?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// handleStacks is whether live handles record the stack they were created"
+        )?;
+        writeln!(
+            out,
+            "// on, which the {} build tag switches on.",
+            Self::DEBUG_TAG
+        )?;
+        writeln!(out, "const handleStacks = {debug}")?;
+
+        Ok(())
+    }
+
+    // ---- cgocheck tests ----
+
+    fn generate_cgocheck_test_file(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        writeln!(out, "package {}", self.package_name())?;
+        writeln!(out)?;
+        writeln!(out, "import \"testing\"")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// TestCgocheckLowering lowers a sample value of every list shape passed to"
+        )?;
+        writeln!(
+            out,
+            "// Rust. Run it with GOEXPERIMENT=cgocheck2, which panics as soon as a Go"
+        )?;
+        writeln!(out, "// pointer that is not pinned is stored in C memory.")?;
+        writeln!(out, "func TestCgocheckLowering(t *testing.T) {{")?;
+        for (shape, type_id) in self.lowered_shapes() {
+            let TypeDefKind::List(elem) = &self.resolve.types[type_id].kind else {
+                continue;
+            };
+            let go_ty = self.type_to_go_structural(&Type::Id(type_id));
+            writeln!(out, "\tt.Run(\"{shape}\", func(t *testing.T) {{")?;
+            writeln!(out, "\t\tallocs := newCAllocs()")?;
+            writeln!(out, "\t\tdefer allocs.free()")?;
+            writeln!(
+                out,
+                "\t\t_ = lower{shape}({go_ty}{{{}}}, allocs)",
+                self.cgocheck_sample(elem)
+            )?;
+            writeln!(out, "\t}})")?;
+        }
+        writeln!(out, "}}")?;
+        if !self.config.generic_options {
+            writeln!(out)?;
+            writeln!(out, "func cgocheckPtr[T any](v T) *T {{")?;
+            writeln!(out, "\treturn &v")?;
+            writeln!(out, "}}")?;
+        }
+
+        Ok(())
+    }
+
+    /// A Go expression for a sample value of `ty`, non-empty wherever the
+    /// lowering could store a pointer.
+    fn cgocheck_sample(&self, ty: &Type) -> String {
+        if self.mapped_conversion(ty).is_some()
+            || self.wide_int(ty).is_some()
+            || self.map_entry(ty).is_some()
+        {
+            return self.cgocheck_zero(ty);
+        }
+        match ty {
+            Type::Bool => "true".to_string(),
+            Type::Char => "'w'".to_string(),
+            Type::String => "\"witffi\"".to_string(),
+            Type::U8
+            | Type::U16
+            | Type::U32
+            | Type::U64
+            | Type::S8
+            | Type::S16
+            | Type::S32
+            | Type::S64
+            | Type::F32
+            | Type::F64 => "1".to_string(),
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::List(Type::U8) => "[]byte(\"witffi\")".to_string(),
+                TypeDefKind::List(elem) | TypeDefKind::FixedLengthList(elem, _) => {
+                    format!("{}{{{}}}", self.type_to_go(ty), self.cgocheck_sample(elem))
+                }
+                TypeDefKind::Option(inner) => {
+                    let wrap = if self.config.generic_options {
+                        "Some"
+                    } else {
+                        "cgocheckPtr"
+                    };
+                    format!(
+                        "{wrap}[{}]({})",
+                        self.type_to_go(inner),
+                        self.cgocheck_sample(inner)
+                    )
+                }
+                TypeDefKind::Tuple(tuple) => {
+                    let fields: Vec<String> = tuple
+                        .types
+                        .iter()
+                        .enumerate()
+                        .map(|(i, elem)| format!("F{i}: {}", self.cgocheck_sample(elem)))
+                        .collect();
+                    format!("{}{{{}}}", self.type_to_go(ty), fields.join(", "))
+                }
+                TypeDefKind::Type(aliased) => self.cgocheck_sample(aliased),
+                _ => self.cgocheck_zero(ty),
+            },
+            _ => self.cgocheck_zero(ty),
+        }
+    }
+
+    /// A Go expression for the zero value of `ty`, with pointers allocated.
+    fn cgocheck_zero(&self, ty: &Type) -> String {
+        let go_ty = self.type_to_go(ty);
+        match go_ty.strip_prefix('*') {
+            Some(pointee) => format!("new({pointee})"),
+            None => format!("*new({go_ty})"),
+        }
+    }
+
+    // ---- Package name derivation ----
+
+    /// Get the Go package name, either from config or derived from the world name.
+    fn package_name(&self) -> String {
+        if let Some(ref pkg) = self.config.go_package {
+            pkg.clone()
+        } else {
+            let world = &self.resolve.worlds[self.world_id];
+            names::to_go_package(&world.name)
+        }
+    }
+
+    /// Get the C function prefix in snake_case form.
+    fn c_func_prefix(&self) -> String {
+        self.config.c_prefix.to_snake_case()
+    }
+
+    // ---- Doc comment helper ----
+
+    /// Write a Go doc comment, handling multi-line content.
+    fn write_doc_comment(out: &mut String, docs: &str, indent: &str) -> std::fmt::Result {
+        for line in Self::go_doc_lines(docs) {
+            if line.is_empty() {
+                writeln!(out, "{indent}//")?;
+            } else if line.starts_with('\t') {
+                writeln!(out, "{indent}//{line}")?;
+            } else {
+                writeln!(out, "{indent}// {line}")?;
+            }
+        }
+        Ok(())
+    }
+
+    /// Write the doc comment of the Go declaration `name`, reworded to open
+    /// with the name as Go doc comments do (see [`Self::named_docs`]).
+    fn write_decl_doc_comment(
+        out: &mut String,
+        name: &str,
+        docs: &str,
+        func: bool,
+    ) -> std::fmt::Result {
+        Self::write_doc_comment(out, &Self::named_docs(name, docs, func), "")
+    }
+
+    /// Write the doc comment of the Go type `name` declared for `typedef`:
+    /// its WIT docs and, if it is deprecated, a `Deprecated:` paragraph.
+    fn write_type_doc_comment(
+        &self,
+        out: &mut String,
+        name: &str,
+        typedef: &TypeDef,
+    ) -> std::fmt::Result {
+        if let Some(docs) = &typedef.docs.contents {
+            Self::write_decl_doc_comment(out, name, docs, false)?;
+        }
+        self.write_decl_notes(
+            out,
+            &self.type_item_name(typedef),
+            deprecated_version(&typedef.stability).as_deref(),
+            typedef.docs.contents.is_some(),
+        )
+    }
+
+    /// The qualified name of the WIT type `typedef` (e.g. `shop.item`), as
+    /// [`witffi_core::source_map::wit_locations`] keys it.
+    fn type_item_name(&self, typedef: &TypeDef) -> String {
+        let name = typedef.name.as_deref().unwrap_or("anonymous");
+        let iface = match typedef.owner {
+            TypeOwner::Interface(id) => self.resolve.interfaces[id].name.as_deref(),
+            _ => None,
+        };
+        match iface {
+            Some(iface) => format!("{iface}.{name}"),
+            None => name.to_string(),
+        }
+    }
+
+    /// End the doc comment of the Go declaration generated for the WIT item
+    /// `item` (a qualified name, e.g. `shop.find`) with where the item is
+    /// defined, if [`GoConfig::source_locations`] says, and with a
+    /// `Deprecated:` paragraph if it was `deprecated`, set off from the
+    /// `documented` lines before them.
+    fn write_decl_notes(
+        &self,
+        out: &mut String,
+        item: &str,
+        deprecated: Option<&str>,
+        documented: bool,
+    ) -> std::fmt::Result {
+        let mut documented = documented;
+        if let Some(location) = self.config.source_locations.get(item) {
+            if documented {
+                writeln!(out, "//")?;
+            }
+            writeln!(out, "// source: {location}")?;
+            documented = true;
+        }
+        Self::write_deprecation(out, deprecated, documented)
+    }
+
+    /// The line ending the code a `//line` directive reports at a WIT
+    /// definition, replaced by [`restore_line_directives`] once the lines
+    /// of the file are known.
+    ///
+    /// [`restore_line_directives`]: Self::restore_line_directives
+    const LINE_RESTORE: &'static str = "//line witffi:restore";
+
+    /// Replace each [`LINE_RESTORE`](Self::LINE_RESTORE) marker in the code
+    /// of the Go file `file` with a `//line` directive reporting the lines
+    /// after it at their own position in the file again.
+    fn restore_line_directives(code: String, file: &str) -> String {
+        if !code.contains(Self::LINE_RESTORE) {
+            return code;
+        }
+        code.lines()
+            .enumerate()
+            .map(|(index, line)| {
+                if line == Self::LINE_RESTORE {
+                    // The directive gives the line of the line after it
+                    format!("//line {file}:{}\n", index + 2)
+                } else {
+                    format!("{line}\n")
+                }
+            })
+            .collect()
+    }
+
+    /// Write the `Deprecated:` paragraph ending the doc comment of a Go
+    /// declaration whose WIT item was deprecated in `version`, if any,
+    /// set off from the `documented` lines before it.
+    fn write_deprecation(
+        out: &mut String,
+        version: Option<&str>,
+        documented: bool,
+    ) -> std::fmt::Result {
+        let Some(version) = version else {
+            return Ok(());
+        };
+        if documented {
+            writeln!(out, "//")?;
+        }
+        writeln!(
+            out,
+            "// Deprecated: deprecated in version {version} of the WIT package."
+        )
+    }
+
+    /// Begin the body of a wrapper of `ef` with the call warning of its
+    /// deprecation, if it is deprecated and
+    /// [`GoConfig::deprecation_warnings`] is set.
+    fn write_deprecation_warning(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        let Some(version) = ef
+            .deprecated
+            .as_deref()
+            .filter(|_| self.config.deprecation_warnings)
+        else {
+            return Ok(());
+        };
+        writeln!(
+            out,
+            "\twarnDeprecated({:?}, {version:?})",
+            ef.qualified_name(self.resolve)
+        )
+    }
+
+    /// Whether any wrapper warns of its deprecation when called.
+    fn warns_deprecation(&self) -> bool {
+        self.config.deprecation_warnings
+            && self
+                .exported_functions()
+                .iter()
+                .any(|ef| ef.deprecated.is_some())
+    }
+
+    /// Reword WIT docs to open with the name of the Go declaration they
+    /// document: `A chain identifier.` on a type becomes `ChainId is a
+    /// chain identifier.`, and `Parse a URI.` on a function becomes `Parse
+    /// parses a URI.`. Docs that already open with the name, or with
+    /// neither an article nor (for functions) a verb, are kept as written.
+    fn named_docs(name: &str, docs: &str, func: bool) -> String {
+        let Some((first, rest)) = docs.split_once(' ') else {
+            return docs.to_string();
+        };
+        let lower = first.to_lowercase();
+        // A function named after a verb may open with it as an imperative
+        let imperative_name = func
+            && rest
+                .split_once(' ')
+                .is_some_and(|(next, _)| matches!(next, "a" | "an" | "the"));
+        if first == name && !imperative_name {
+            docs.to_string()
+        } else if matches!(first, "A" | "An" | "The") {
+            let verb = if func { "returns" } else { "is" };
+            format!("{name} {verb} {lower} {rest}")
+        } else if func && first == "Whether" {
+            format!("{name} reports whether {rest}")
+        } else if func
+            && first.len() > 1
+            && first.starts_with(|c: char| c.is_ascii_uppercase())
+            && first[1..].chars().all(|c| c.is_ascii_lowercase())
+            // A participle, as in `Batched form of ...`, is no imperative
+            && (!lower.ends_with("ed") || lower.ends_with("eed"))
+            && !matches!(
+                first,
+                "If" | "When" | "For" | "This" | "Note" | "Only" | "See"
+            )
+        {
+            // An imperative verb, as in Rust docs: `Parse` becomes `parses`
+            let verb = if lower.ends_with('s') && !lower.ends_with("ss") {
+                lower
+            } else if ["ss", "sh", "ch", "x", "z", "o"]
+                .iter()
+                .any(|end| lower.ends_with(end))
+            {
+                format!("{lower}es")
+            } else if lower.ends_with('y') && !lower.ends_with(['a', 'e', 'o', 'u', 'y']) {
+                format!("{}ies", &lower[..lower.len() - 1])
+            } else {
+                format!("{lower}s")
+            };
+            format!("{name} {verb} {rest}")
+        } else {
+            docs.to_string()
+        }
+    }
+
+    /// The lines of a Go doc comment (without `//`) holding WIT docs, with
+    /// their Markdown rewritten into Go doc syntax as gofmt lays it out:
+    /// code blocks are tab-indented and list items indented, each set off
+    /// from the text after them (code blocks also before) by a blank line.
+    fn go_doc_lines(docs: &str) -> Vec<String> {
+        #[derive(Clone, Copy, PartialEq)]
+        enum Kind {
+            Blank,
+            Text,
+            Item,
+            Code,
+        }
+
+        let mut lines: Vec<(Kind, String)> = Vec::new();
+        let mut push = |kind: Kind, line: String| {
+            let last = lines.last().map_or(Kind::Blank, |(kind, _)| *kind);
+            let separated = match kind {
+                Kind::Code => matches!(last, Kind::Text | Kind::Item),
+                Kind::Text => matches!(last, Kind::Item | Kind::Code),
+                Kind::Item => last == Kind::Code,
+                Kind::Blank => false,
+            };
+            if separated {
+                lines.push((Kind::Blank, String::new()));
+            }
+            lines.push((kind, line));
+        };
+        let mut in_code = false;
+        let mut in_item = false;
+        for line in docs.trim_end().lines() {
+            let trimmed = line.trim_start();
+            if trimmed.starts_with("```") {
+                in_code = !in_code;
+                continue;
+            }
+            if in_code {
+                push(Kind::Code, format!("\t{line}"));
+                continue;
+            }
+            let numbered = trimmed
+                .split_once(". ")
+                .filter(|(n, _)| !n.is_empty() && n.chars().all(|c| c.is_ascii_digit()));
+            if trimmed.is_empty() {
+                in_item = false;
+                push(Kind::Blank, String::new());
+            } else if let Some(item) = trimmed
+                .strip_prefix("- ")
+                .or_else(|| trimmed.strip_prefix("* "))
+            {
+                in_item = true;
+                push(Kind::Item, format!("  - {item}"));
+            } else if let Some((number, item)) = numbered {
+                in_item = true;
+                push(Kind::Item, format!("{:>3} {item}", format!("{number}.")));
+            } else if in_item && line.starts_with(' ') {
+                push(Kind::Item, format!("    {trimmed}"));
+            } else if line.starts_with([' ', '\t']) {
+                // An indented code block
+                let code = line.strip_prefix("    ").unwrap_or(trimmed);
+                push(Kind::Code, format!("\t{code}"));
+            } else {
+                in_item = false;
+                push(Kind::Text, line.to_string());
+            }
+        }
+        lines.into_iter().map(|(_, line)| line).collect()
+    }
+
+    // ---- Header generation ----
+
+    fn generate_header(&self, out: &mut String) -> std::fmt::Result {
+        self.generate_constrained_header(out, None)
+    }
+
+    /// Generate the header of a file built only where the build
+    /// `constraint` holds, if any.
+    fn generate_constrained_header(
+        &self,
+        out: &mut String,
+        constraint: Option<&str>,
+    ) -> std::fmt::Result {
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        if let Some(constraint) = constraint {
+            writeln!(out, "//go:build {constraint}")?;
+            writeln!(out)?;
+        }
+        if let Some(docs) = self.package_docs() {
+            Self::write_doc_comment(out, &docs, "")?;
+        }
+        writeln!(out, "package {}", self.package_name())?;
+
+        Ok(())
+    }
+
+    /// The package doc comment: the docs of the WIT package and world,
+    /// then those of each exported interface under a heading. `None` when
+    /// none of them is documented.
+    fn package_docs(&self) -> Option<String> {
+        let world = &self.resolve.worlds[self.world_id];
+        let mut sections: Vec<String> = world
+            .package
+            .and_then(|pkg| self.resolve.packages[pkg].docs.contents.clone())
+            .into_iter()
+            .chain(world.docs.contents.clone())
+            .collect();
+        let mut interfaces: Vec<(String, String)> = world
+            .exports
+            .values()
+            .filter_map(|item| match item {
+                wit_parser::WorldItem::Interface { id, .. } => {
+                    let iface = &self.resolve.interfaces[*id];
+                    let name = self.config.naming.to_go_type(iface.name.as_deref()?);
+                    Some((name, iface.docs.contents.clone()?))
+                }
+                _ => None,
+            })
+            .collect();
+        interfaces.sort();
+        for (name, docs) in interfaces {
+            sections.push(format!("# {name}\n\n{}", docs.trim_end()));
+        }
+        if sections.is_empty() {
+            return None;
+        }
+        sections.insert(
+            0,
+            format!(
+                "Package {} holds the Go bindings of the {} WIT world.",
+                self.package_name(),
+                world.name
+            ),
+        );
+        Some(sections.join("\n\n"))
+    }
+
+    // ---- CGo preamble ----
+
+    fn generate_cgo_preamble(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(out, "/*")?;
+        writeln!(out, "#cgo LDFLAGS: -l{}", self.config.lib_name)?;
+        for c_func in self.cgo_annotated() {
+            writeln!(out, "#cgo noescape {c_func}")?;
+            writeln!(out, "#cgo nocallback {c_func}")?;
+        }
+        writeln!(out, "#include \"witffi_types.h\"")?;
+        writeln!(out, "#include \"ffi.h\"")?;
+        writeln!(out, "#include <stdlib.h>")?;
+        if self.config.dispatch_thread || !self.config.single_threaded.is_empty() {
+            writeln!(out, "#include <pthread.h>")?;
+        }
+        if self.config.lifecycle {
+            writeln!(out, "#include <unistd.h>")?;
+        }
+        if !self.config.single_threaded.is_empty() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "static uintptr_t current_thread(void) {{ return (uintptr_t)pthread_self(); }}"
+            )?;
+        }
+        if !self.config.blocking.is_empty() {
+            writeln!(out)?;
+            writeln!(out, "static __thread int blocking_worker;")?;
+            writeln!(
+                out,
+                "static void enter_blocking_worker(void) {{ blocking_worker = 1; }}"
+            )?;
+            writeln!(
+                out,
+                "static int on_blocking_worker(void) {{ return blocking_worker; }}"
+            )?;
+        }
+        if self.uses_async() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "extern void {}_async_complete(uintptr_t user_data, void *task);",
+                self.c_func_prefix()
+            )?;
+        }
+        if self.config.log_bridge {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "extern void {}_log_record(uint8_t level, FfiByteSlice target, FfiByteSlice message, FfiByteSlice fields);",
+                self.c_func_prefix()
+            )?;
+        }
+        if self.uses_byte_sources() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "extern intptr_t {}_byte_source_read(uintptr_t user_data, uint8_t *buf, size_t len);",
+                self.c_func_prefix()
+            )?;
+        }
+        // import "C" MUST immediately follow closing */ (CGo requirement)
+        writeln!(out, "*/")?;
+        writeln!(out, "import \"C\"")?;
+
+        Ok(())
+    }
+
+    // ---- Go imports ----
+
+    fn generate_imports(&self, out: &mut String) -> std::fmt::Result {
+        let funcs = self.exported_functions();
+        // Feature-gated functions import what they need in their own files
+        let has_result_funcs = funcs.iter().filter(|ef| ef.feature.is_none()).any(|ef| {
+            // Awaited results are converted in the same way
+            let result = ef.future_value(self.resolve).or(ef.function.result);
+            self.decompose_result(&result).is_some()
+        });
+        let has_variants_or_enums = self.collect_reachable_types().iter().any(|id| {
+            matches!(
+                self.resolve.types[*id].kind,
+                TypeDefKind::Variant(_) | TypeDefKind::Enum(_)
+            )
+        });
+        let uses_chars = self.uses_chars();
+        let uses_wide_ints = self.uses_wide_ints();
+        let uses_big_ints = self.uses_big_ints();
+        let uses_maps = self.uses_maps();
+        let uses_time = !self.time_conversions().is_empty();
+        let uses_streams = self.uses_streams();
+        let uses_futures = self.uses_futures();
+        let uses_byte_readers = self.uses_byte_readers();
+        let uses_byte_sources = self.uses_byte_sources();
+        let has_into_funcs = funcs
+            .iter()
+            .filter(|ef| ef.feature.is_none())
+            .any(|ef| self.has_into_variant(ef));
+        let needs_fmt = has_result_funcs
+            || has_into_funcs
+            || has_variants_or_enums
+            || uses_chars
+            || uses_streams
+            || uses_byte_readers
+            || self.config.foreign_panics
+            || self.config.error_chains
+            || self.config.lifecycle
+            || self.config.log_bridge
+            || !self.imports().is_empty();
+        let needs_runtime = self
+            .collect_reachable_types()
+            .iter()
+            .filter(|id| matches!(self.resolve.types[**id].kind, TypeDefKind::Resource))
+            .any(|id| self.resource_cleanup(*id) != ResourceCleanup::Manual);
+
+        // Timed calls need the context helpers even when all are feature-gated
+        let uses_timeouts = !self.config.timeouts.is_empty();
+        let uses_timed_calls = funcs
+            .iter()
+            .filter(|ef| ef.feature.is_none())
+            .any(|ef| self.timeout(ef).is_some());
+        // The blocking pool lives here even when all its users are gated
+        let uses_blocking = !self.config.blocking.is_empty();
+        let uses_borrowed_strings = !self.config.borrowed_strings.is_empty();
+        let uses_arena = self.config.arena_chunk_size.is_some() && self.uses_c_allocs();
+
+        let mut imports = vec!["unsafe"];
+        if uses_futures
+            || self.uses_context_params()
+            || uses_timeouts
+            || self.config.log_bridge
+            || self.config.otel_spans
+            || self.config.pprof_labels
+        {
+            imports.push("context");
+        }
+        if uses_wide_ints {
+            imports.push("encoding/binary");
+        }
+        if uses_timeouts || self.uses_resources() {
+            imports.push("errors");
+        }
+        if needs_fmt {
+            imports.push("fmt");
+        }
+        if uses_byte_readers || uses_byte_sources || self.config.lifecycle {
+            imports.push("io");
+        }
+        if (uses_streams && self.config.stream_iterators) || !self.config.list_seqs.is_empty() {
+            imports.push("iter");
+        }
+        if self.config.log_bridge || self.warns_deprecation() {
+            imports.push("log/slog");
+        }
+        if uses_big_ints {
+            imports.push("math/big");
+        }
+        if self.config.lifecycle {
+            imports.push("os");
+        }
+        let pins_threads = has_into_funcs
+            || funcs
+                .iter()
+                .filter(|ef| ef.feature.is_none())
+                .any(|ef| self.reads_last_error(ef));
+        if needs_runtime
+            || pins_threads
+            || !self.config.single_threaded.is_empty()
+            || self.uses_context_params()
+            || uses_timeouts
+            || self.config.dispatch_thread
+            || uses_blocking
+            || (self.config.pin_bytes && self.uses_c_allocs())
+            || uses_borrowed_strings
+        {
+            imports.push("runtime");
+        }
+        if self.uses_async() || uses_byte_sources {
+            imports.push("runtime/cgo");
+        }
+        if self.config.handle_table {
+            imports.push("runtime/debug");
+        }
+        if self.config.pprof_labels {
+            imports.push("runtime/pprof");
+        }
+        if uses_maps || self.config.handle_table {
+            imports.push("sort");
+        }
+        if self.config.error_chains || self.config.log_bridge {
+            imports.push("strings");
+        }
+        if uses_streams
+            || uses_futures
+            || uses_blocking
+            || !self.config.single_threaded.is_empty()
+            || self.config.buffer_pool
+            || self.config.intern_strings.is_some()
+            || self.config.lifecycle
+            || self.config.init_mode == GoInitMode::Lazy
+            || self.config.handle_table
+            || self.warns_deprecation()
+        {
+            imports.push("sync");
+        }
+        if uses_borrowed_strings
+            || uses_arena
+            || self.config.log_bridge
+            || self.call_hooks()
+            || self.uses_resources()
+            || !self.config.single_threaded.is_empty()
+        {
+            imports.push("sync/atomic");
+        }
+        if uses_time || uses_timed_calls || self.call_hooks() || self.config.handle_table {
+            imports.push("time");
+        }
+        if uses_chars {
+            imports.push("unicode/utf8");
+        }
+        writeln!(out)?;
+        writeln!(out, "import (")?;
+        for import in STD_IMPORTS.iter().filter(|import| imports.contains(import)) {
+            writeln!(out, "\t\"{import}\"")?;
+        }
+        let custom_imports = self.custom_imports();
+        if !custom_imports.is_empty() {
+            writeln!(out)?;
+            for import in custom_imports {
+                writeln!(out, "\t\"{import}\"")?;
+            }
+        }
+        writeln!(out, ")")?;
+
+        Ok(())
+    }
+
+    // ---- Helpers ----
+
+    /// Generate the table of interned strings and `internString`, which
+    /// `ffiByteBufferToString` lifts strings of at most `max_len` bytes
+    /// through.
+    fn generate_intern_helpers(&self, out: &mut String, max_len: usize) -> std::fmt::Result {
+        writeln!(
+            out,
+            "// Strings of at most internedStringMaxLen bytes are interned, up to"
+        )?;
+        writeln!(out, "// internedStringLimit distinct values.")?;
+        writeln!(out, "const (")?;
+        writeln!(out, "\tinternedStringMaxLen = {max_len}")?;
+        writeln!(out, "\tinternedStringLimit  = 4096")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// internedStrings holds one copy of each interned string, keyed by its"
+        )?;
+        writeln!(out, "// content.")?;
+        writeln!(out, "var internedStrings = struct {{")?;
+        writeln!(out, "\tsync.Mutex")?;
+        writeln!(out, "\tm map[string]string")?;
+        writeln!(out, "}}{{m: make(map[string]string)}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// internString returns the interned copy of b, interning it first if"
+        )?;
+        writeln!(
+            out,
+            "// there is room. b may point at Rust memory, and is not retained."
+        )?;
+        writeln!(out, "func internString(b []byte) string {{")?;
+        writeln!(out, "\tinternedStrings.Lock()")?;
+        writeln!(out, "\tdefer internedStrings.Unlock()")?;
+        writeln!(
+            out,
+            "\t// Indexing with string(b) looks up the content without copying it"
+        )?;
+        writeln!(out, "\tif s, ok := internedStrings.m[string(b)]; ok {{")?;
+        writeln!(out, "\t\treturn s")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\ts := string(b)")?;
+        writeln!(out, "\tif len(internedStrings.m) < internedStringLimit {{")?;
+        writeln!(out, "\t\tinternedStrings.m[s] = s")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn s")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    fn generate_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out, "// ---- Helpers ----")?;
+        writeln!(out)?;
+
+        // ffiByteBufferToString
+        writeln!(
+            out,
+            "func ffiByteBufferToString(buf C.FfiByteBuffer) string {{"
+        )?;
+        writeln!(out, "\tif buf.ptr == nil || buf.len == 0 {{")?;
+        writeln!(out, "\t\tC.{prefix}_free_byte_buffer(buf)")?;
+        writeln!(out, "\t\treturn \"\"")?;
+        writeln!(out, "\t}}")?;
+        if self.config.intern_strings.is_some() {
+            writeln!(out, "\tif int(buf.len) <= internedStringMaxLen {{")?;
+            writeln!(
+                out,
+                "\t\ts := internString(unsafe.Slice((*byte)(unsafe.Pointer(buf.ptr)), int(buf.len)))"
+            )?;
+            writeln!(out, "\t\tC.{prefix}_free_byte_buffer(buf)")?;
+            writeln!(out, "\t\treturn s")?;
+            writeln!(out, "\t}}")?;
+        }
+        writeln!(
+            out,
+            "\ts := C.GoStringN((*C.char)(unsafe.Pointer(buf.ptr)), C.int(buf.len))"
+        )?;
+        writeln!(out, "\tC.{prefix}_free_byte_buffer(buf)")?;
+        writeln!(out, "\treturn s")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+
+        if let Some(max_len) = self.config.intern_strings {
+            self.generate_intern_helpers(out, max_len)?;
+        }
+
+        // ffiByteBufferToBytes
+        writeln!(
+            out,
+            "func ffiByteBufferToBytes(buf C.FfiByteBuffer) []byte {{"
+        )?;
+        writeln!(out, "\tif buf.ptr == nil || buf.len == 0 {{")?;
+        writeln!(out, "\t\tC.{prefix}_free_byte_buffer(buf)")?;
+        writeln!(out, "\t\treturn nil")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tb := C.GoBytes(unsafe.Pointer(buf.ptr), C.int(buf.len))"
+        )?;
+        writeln!(out, "\tC.{prefix}_free_byte_buffer(buf)")?;
+        writeln!(out, "\treturn b")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+
+        if self.config.buffer_pool {
+            writeln!(
+                out,
+                "// scratchPool holds byte buffers reused to stage error messages read"
+            )?;
+            writeln!(out, "// from Rust.")?;
+            writeln!(
+                out,
+                "var scratchPool = sync.Pool{{New: func() any {{ return new([]byte) }}}}"
+            )?;
+            writeln!(out)?;
+        }
+
+        // readLastError
+        writeln!(out, "func readLastError() string {{")?;
+        writeln!(out, "\tlength := C.{prefix}_last_error_length()")?;
+        writeln!(out, "\tif length <= 0 {{")?;
+        writeln!(out, "\t\treturn \"unknown error\"")?;
+        writeln!(out, "\t}}")?;
+        if self.config.buffer_pool {
+            writeln!(out, "\tscratch := scratchPool.Get().(*[]byte)")?;
+            writeln!(out, "\tdefer scratchPool.Put(scratch)")?;
+            writeln!(out, "\tif cap(*scratch) < int(length) {{")?;
+            writeln!(out, "\t\t*scratch = make([]byte, length)")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\tbuf := (*scratch)[:length]")?;
+        } else {
+            writeln!(out, "\tbuf := make([]byte, length)")?;
+        }
+        writeln!(
+            out,
+            "\tC.{prefix}_error_message_utf8((*C.char)(unsafe.Pointer(&buf[0])), length)"
+        )?;
+        writeln!(out, "\treturn string(buf[:length-1])")?;
+        writeln!(out, "}}")?;
+
+        // Mismatched struct layouts would otherwise misread memory
+        // silently, so a stale library fails loudly before any call
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// abiHash identifies the WIT world these bindings were generated from,"
+        )?;
+        writeln!(
+            out,
+            "// which the Rust library must have been generated from too."
+        )?;
+        writeln!(
+            out,
+            "const abiHash = {:#018x}",
+            witffi_core::abi_hash(self.resolve, self.world_id)
+        )?;
+        writeln!(out)?;
+        writeln!(out, "{}", self.setup_func("checkABI"))?;
+        writeln!(out, "\tif uint64(C.{prefix}_abi_hash()) != abiHash {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(\"witffi: bindings out of date: the Rust library was generated from a different version of the WIT world; re-run witffi and rebuild\")"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        if self.config.init_mode == GoInitMode::Lazy {
+            writeln!(out)?;
+            self.generate_lazy_setup(out)?;
+        }
+
+        if self.config.error_chains {
+            writeln!(out)?;
+            self.generate_error_chain_helpers(out)?;
+        }
+        if self.config.foreign_panics {
+            writeln!(out)?;
+            self.generate_foreign_panic_helpers(out)?;
+        }
+        if self.config.foreign_panics || self.config.error_chains {
+            writeln!(out)?;
+            self.generate_last_error(out)?;
+        }
+        if self.call_hooks() {
+            writeln!(out)?;
+            self.generate_call_hook_helpers(out)?;
+        }
+        if self.config.handle_table {
+            writeln!(out)?;
+            self.generate_handle_table(out)?;
+        }
+        if self.config.otel_spans {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// otelTracer starts the spans of calls into the Rust library, with the"
+            )?;
+            writeln!(out, "// global tracer provider.")?;
+            writeln!(
+                out,
+                "var otelTracer = otel.Tracer(\"{}\")",
+                self.package_name()
+            )?;
+        }
+
+        if self.uses_chars() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ffiCharToRune lifts a WIT char, rejecting values that are not Unicode"
+            )?;
+            writeln!(out, "// scalar values (such as surrogates).")?;
+            writeln!(out, "func ffiCharToRune(c C.uint32_t) (rune, error) {{")?;
+            writeln!(out, "\tr := rune(c)")?;
+            writeln!(out, "\tif !utf8.ValidRune(r) {{")?;
+            writeln!(
+                out,
+                "\t\treturn utf8.RuneError, fmt.Errorf(\"invalid char %#x: not a Unicode scalar value\", uint32(c))"
+            )?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\treturn r, nil")?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// invalidChar carries the error of a char nested in a lifted value out"
+            )?;
+            writeln!(out, "// of its conversion, to the liftChars around it.")?;
+            writeln!(out, "type invalidChar struct{{ err error }}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// mustFfiCharToRune lifts a WIT char nested in a larger value, panicking"
+            )?;
+            writeln!(
+                out,
+                "// with invalidChar if it is not a Unicode scalar value."
+            )?;
+            writeln!(out, "func mustFfiCharToRune(c C.uint32_t) rune {{")?;
+            writeln!(out, "\tr, err := ffiCharToRune(c)")?;
+            writeln!(out, "\tif err != nil {{")?;
+            writeln!(out, "\t\tpanic(invalidChar{{err}})")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\treturn r")?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// liftChars runs lift, returning the error of an invalid char nested in"
+            )?;
+            writeln!(
+                out,
+                "// the value it lifts rather than panicking. The parts of that value"
+            )?;
+            writeln!(out, "// not yet lifted are leaked.")?;
+            writeln!(
+                out,
+                "func liftChars[T any](lift func() T) (value T, err error) {{"
+            )?;
+            writeln!(out, "\tdefer func() {{")?;
+            writeln!(out, "\t\tif r := recover(); r != nil {{")?;
+            writeln!(out, "\t\t\tc, ok := r.(invalidChar)")?;
+            writeln!(out, "\t\t\tif !ok {{")?;
+            writeln!(out, "\t\t\t\tpanic(r)")?;
+            writeln!(out, "\t\t\t}}")?;
+            writeln!(out, "\t\t\terr = c.err")?;
+            writeln!(out, "\t\t}}")?;
+            writeln!(out, "\t}}()")?;
+            writeln!(out, "\treturn lift(), nil")?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// lowerRune converts r to C, panicking if it is not a Unicode scalar value."
+            )?;
+            writeln!(out, "func lowerRune(r rune) C.uint32_t {{")?;
+            writeln!(out, "\tif !utf8.ValidRune(r) {{")?;
+            writeln!(
+                out,
+                "\t\tpanic(fmt.Sprintf(\"invalid char %#x: not a Unicode scalar value\", r))"
+            )?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\treturn C.uint32_t(r)")?;
+            writeln!(out, "}}")?;
+        }
+
+        if self.uses_wide_ints() {
+            self.generate_wide_int_helpers(out)?;
+        }
+
+        if self.uses_maps() {
+            self.generate_map_helpers(out)?;
+        }
+
+        if self
+            .collect_reachable_types()
+            .iter()
+            .any(|id| self.is_big_bytes(&Type::Id(*id)))
+        {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ffiBytesToBigInt lifts big-endian bytes into an unsigned *big.Int."
+            )?;
+            writeln!(out, "func ffiBytesToBigInt(b []byte) *big.Int {{")?;
+            writeln!(out, "\treturn new(big.Int).SetBytes(b)")?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ffiBigIntToBytes lowers n into its minimal big-endian bytes. The sign"
+            )?;
+            writeln!(out, "// is dropped, and nil is lowered as zero.")?;
+            writeln!(out, "func ffiBigIntToBytes(n *big.Int) []byte {{")?;
+            writeln!(out, "\tif n == nil {{")?;
+            writeln!(out, "\t\treturn nil")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\treturn n.Bytes()")?;
+            writeln!(out, "}}")?;
+        }
+
+        self.generate_time_helpers(out)?;
+
+        if self.uses_async() {
+            self.generate_async_helpers(out)?;
+        }
+
+        if self.uses_streams() {
+            self.generate_stream_helpers(out)?;
+        }
+
+        if self.uses_byte_readers() {
+            self.generate_byte_reader_helpers(out)?;
+        }
+
+        if self.uses_byte_sources() {
+            self.generate_byte_source_helpers(out)?;
+        }
+
+        if self.uses_futures() {
+            self.generate_future_helpers(out)?;
+        }
+
+        if self.uses_error_context() {
+            self.generate_error_context_helpers(out)?;
+        }
+
+        if self.uses_resources() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ErrClosed is returned by a call on a resource that was closed or handed to"
+            )?;
+            writeln!(out, "// Rust, or panicked with when the call cannot fail.")?;
+            writeln!(
+                out,
+                "var ErrClosed = errors.New(\"resource used after close\")"
+            )?;
+        }
+
+        if self.uses_context_params() || !self.config.timeouts.is_empty() {
+            self.generate_context_helpers(out)?;
+        }
+
+        if self.config.dispatch_thread {
+            self.generate_dispatch_helpers(out)?;
+        }
+
+        if !self.config.blocking.is_empty() {
+            self.generate_blocking_helpers(out)?;
+        }
+
+        if !self.config.single_threaded.is_empty() {
+            self.generate_single_threaded_helpers(out)?;
+        }
+
+        if !self.config.borrowed_strings.is_empty() {
+            self.generate_borrowed_string_helpers(out)?;
+        }
+
+        if !self.config.list_views.is_empty() || !self.config.list_seqs.is_empty() {
+            self.generate_list_view_helpers(out)?;
+        }
+
+        Ok(())
+    }
+
+    /// Generate `ListView[T]`, which lifts the elements of a list left in
+    /// Rust memory as they are read.
+    ///
+    /// Lifting an element frees what it owns in Rust, so each is lifted at
+    /// most once and kept for later reads; `Close` lifts (and so frees) any
+    /// element never read before freeing the list itself.
+    fn generate_list_view_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ListView is a list result left in memory owned by Rust, whose elements are"
+        )?;
+        writeln!(
+            out,
+            "// lifted into Go as At reads them rather than all at once. It must be closed"
+        )?;
+        writeln!(
+            out,
+            "// to free that memory, after which At panics; elements already read stay"
+        )?;
+        writeln!(out, "// valid. A ListView is not safe for concurrent use.")?;
+        writeln!(out, "type ListView[T any] struct {{")?;
+        writeln!(out, "\tn      int")?;
+        writeln!(out, "\tat     func(i int) T")?;
+        writeln!(out, "\towned  bool")?;
+        writeln!(out, "\tfree   func()")?;
+        writeln!(out, "\tread   map[int]T")?;
+        if !self.config.list_seqs.is_empty() {
+            writeln!(out, "\tranged int // leading elements lifted by All")?;
+        }
+        writeln!(out, "\tclosed bool")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func newListView[T any](n int, at func(i int) T, owned bool, free func()) *ListView[T] {{"
+        )?;
+        writeln!(
+            out,
+            "\treturn &ListView[T]{{n: n, at: at, owned: owned, free: free, read: make(map[int]T)}}"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// Len returns the number of elements in the list.")?;
+        writeln!(out, "func (v *ListView[T]) Len() int {{")?;
+        writeln!(out, "\treturn v.n")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// At returns the element at index i, which must be in [0, Len())."
+        )?;
+        writeln!(out, "func (v *ListView[T]) At(i int) T {{")?;
+        writeln!(out, "\tif v.closed {{")?;
+        writeln!(out, "\t\tpanic(\"ListView used after Close\")")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif e, ok := v.read[i]; ok {{")?;
+        writeln!(out, "\t\treturn e")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\te := v.at(i)")?;
+        writeln!(out, "\tv.read[i] = e")?;
+        writeln!(out, "\treturn e")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Close frees the list in Rust memory. Closing it again has no effect."
+        )?;
+        writeln!(out, "func (v *ListView[T]) Close() {{")?;
+        writeln!(out, "\tif v.closed {{")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tv.closed = true")?;
+        writeln!(
+            out,
+            "\t// Elements never read still own memory, which lifting them frees"
+        )?;
+        writeln!(out, "\tif v.owned {{")?;
+        if self.config.list_seqs.is_empty() {
+            writeln!(out, "\t\tfor i := 0; i < v.n; i++ {{")?;
+        } else {
+            writeln!(out, "\t\tfor i := v.ranged; i < v.n; i++ {{")?;
+        }
+        writeln!(out, "\t\t\tif _, ok := v.read[i]; !ok {{")?;
+        writeln!(out, "\t\t\t\tv.at(i)")?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tv.read = nil")?;
+        writeln!(out, "\tv.free()")?;
+        writeln!(out, "}}")?;
+
+        if !self.config.list_seqs.is_empty() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// All returns an iterator over the elements in order, lifting each as the"
+            )?;
+            writeln!(
+                out,
+                "// range reaches it rather than caching it like At, and closing v once the"
+            )?;
+            writeln!(
+                out,
+                "// range ends, early or not. It can be ranged over once."
+            )?;
+            writeln!(out, "func (v *ListView[T]) All() iter.Seq[T] {{")?;
+            writeln!(out, "\treturn func(yield func(T) bool) {{")?;
+            writeln!(out, "\t\tdefer v.Close()")?;
+            writeln!(out, "\t\tfor ; v.ranged < v.n; v.ranged++ {{")?;
+            writeln!(out, "\t\t\te, ok := v.read[v.ranged]")?;
+            writeln!(out, "\t\t\tif !ok {{")?;
+            writeln!(out, "\t\t\t\te = v.at(v.ranged)")?;
+            writeln!(out, "\t\t\t}}")?;
+            writeln!(out, "\t\t\tif !yield(e) {{")?;
+            writeln!(out, "\t\t\t\tv.ranged++")?;
+            writeln!(out, "\t\t\t\treturn")?;
+            writeln!(out, "\t\t\t}}")?;
+            writeln!(out, "\t\t}}")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "}}")?;
+        }
+
+        Ok(())
+    }
+
+    /// Generate `BorrowedString`, which wraps a string result in place, and
+    /// `borrowString`, which lifts one.
+    ///
+    /// The string points straight at the Rust buffer, so it must not
+    /// outlive the buffer: `String` panics once it was released, and the
+    /// cleanup only runs once the `BorrowedString` itself is unreachable.
+    fn generate_borrowed_string_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// BorrowedString is a string result lifted without copying: its bytes stay in"
+        )?;
+        writeln!(
+            out,
+            "// memory owned by Rust until Release is called, or until a cleanup releases"
+        )?;
+        writeln!(out, "// them once the BorrowedString is unreachable.")?;
+        writeln!(out, "//")?;
+        writeln!(
+            out,
+            "// A string returned by String must not be used after Release, nor after the"
+        )?;
+        writeln!(
+            out,
+            "// BorrowedString becomes unreachable. Use Clone for a copy that outlives it."
+        )?;
+        writeln!(out, "type BorrowedString struct {{")?;
+        writeln!(out, "\tb *borrowedBuffer")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "type borrowedBuffer struct {{")?;
+        writeln!(out, "\tbuf      C.FfiByteBuffer")?;
+        writeln!(out, "\treleased atomic.Bool")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func borrowString(buf C.FfiByteBuffer) BorrowedString {{"
+        )?;
+        writeln!(out, "\tb := &borrowedBuffer{{buf: buf}}")?;
+        writeln!(out, "\truntime.SetFinalizer(b, (*borrowedBuffer).release)")?;
+        writeln!(out, "\treturn BorrowedString{{b: b}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func (b *borrowedBuffer) release() {{")?;
+        writeln!(out, "\tif b.released.CompareAndSwap(false, true) {{")?;
+        writeln!(out, "\t\tC.{prefix}_free_byte_buffer(b.buf)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// String returns the borrowed string. It panics if the string was released."
+        )?;
+        writeln!(out, "func (s BorrowedString) String() string {{")?;
+        writeln!(out, "\tif s.b == nil {{")?;
+        writeln!(out, "\t\treturn \"\"")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif s.b.released.Load() {{")?;
+        writeln!(out, "\t\tpanic(\"BorrowedString used after Release\")")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif s.b.buf.ptr == nil || s.b.buf.len == 0 {{")?;
+        writeln!(out, "\t\treturn \"\"")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\treturn unsafe.String((*byte)(unsafe.Pointer(s.b.buf.ptr)), int(s.b.buf.len))"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Clone returns a copy of the string, which stays valid after Release."
+        )?;
+        writeln!(out, "func (s BorrowedString) Clone() string {{")?;
+        writeln!(out, "\tv := s.String()")?;
+        writeln!(out, "\tclone := string([]byte(v))")?;
+        writeln!(out, "\truntime.KeepAlive(s.b)")?;
+        writeln!(out, "\treturn clone")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Release frees the string's memory. Releasing it again has no effect."
+        )?;
+        writeln!(out, "func (s BorrowedString) Release() {{")?;
+        writeln!(out, "\tif s.b == nil {{")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\truntime.SetFinalizer(s.b, nil)")?;
+        writeln!(out, "\ts.b.release()")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the locks serializing calls into single-threaded
+    /// interfaces and resources.
+    ///
+    /// Rust calls imports on the thread the call holding a lock runs on, so
+    /// an import reentering Rust is recognised by its thread and runs
+    /// without waiting on the lock its own caller holds.
+    fn generate_single_threaded_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// callLock serializes calls into a single-threaded interface or resource."
+        )?;
+        writeln!(
+            out,
+            "// Calls reentering Rust from an import, on the thread already holding it,"
+        )?;
+        writeln!(out, "// run without waiting on it.")?;
+        writeln!(out, "type callLock struct {{")?;
+        writeln!(out, "\tmu sync.Mutex")?;
+        writeln!(
+            out,
+            "\t// holder is the OS thread holding mu, or zero if it is free."
+        )?;
+        writeln!(out, "\tholder atomic.Uintptr")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// lock takes l for the calling thread, returning the function releasing it."
+        )?;
+        writeln!(
+            out,
+            "// The goroutine stays on its thread meanwhile, so no other goroutine can be"
+        )?;
+        writeln!(out, "// mistaken for the holder.")?;
+        writeln!(out, "func (l *callLock) lock() func() {{")?;
+        writeln!(out, "\truntime.LockOSThread()")?;
+        writeln!(out, "\tthread := uintptr(C.current_thread())")?;
+        writeln!(out, "\tif l.holder.Load() == thread {{")?;
+        writeln!(out, "\t\treturn runtime.UnlockOSThread")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tl.mu.Lock()")?;
+        writeln!(out, "\tl.holder.Store(thread)")?;
+        writeln!(out, "\treturn func() {{")?;
+        writeln!(out, "\t\tl.holder.Store(0)")?;
+        writeln!(out, "\t\tl.mu.Unlock()")?;
+        writeln!(out, "\t\truntime.UnlockOSThread()")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        let funcs = self.exported_functions();
+        let mut names: Vec<&String> = self.config.single_threaded.iter().collect();
+        names.sort();
+        for name in names {
+            writeln!(out)?;
+            if funcs.iter().any(|ef| ef.interface_name == *name) {
+                let mutex = Self::interface_mutex_name(name);
+                writeln!(
+                    out,
+                    "// {mutex} serializes calls into the single-threaded `{name}` interface."
+                )?;
+                writeln!(out, "var {mutex} callLock")?;
+            } else {
+                let resource = name.rsplit('.').next().unwrap_or(name);
+                let mutex = Self::resource_mutex_name(resource);
+                writeln!(
+                    out,
+                    "// {mutex} serializes calls on the single-threaded {} resource.",
+                    self.config.naming.to_go_type(resource)
+                )?;
+                writeln!(out, "var {mutex} callLock")?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Generate the worker pool that calls to blocking functions run on,
+    /// `InitBlockingPool`, which sizes it, and `runBlocking`, which runs a
+    /// call there.
+    ///
+    /// A goroutine blocked in a cgo call holds its OS thread, so bounding
+    /// the workers bounds the threads these calls can hold. Workers mark
+    /// their thread in C, so an import reentering a blocking function runs
+    /// directly rather than waiting on a pool it may have exhausted.
+    fn generate_blocking_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// blockingCalls carries calls to functions that block for long periods to the"
+        )?;
+        writeln!(out, "// worker pool.")?;
+        writeln!(out, "var blockingCalls chan func()")?;
+        writeln!(out)?;
+        writeln!(out, "var blockingPoolOnce sync.Once")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// InitBlockingPool starts the pool of size workers that calls to blocking"
+        )?;
+        writeln!(
+            out,
+            "// functions run on, bounding the OS threads they hold at once. Only the first"
+        )?;
+        writeln!(
+            out,
+            "// call has an effect; without one, the pool starts with runtime.GOMAXPROCS(0)"
+        )?;
+        writeln!(out, "// workers on first use.")?;
+        writeln!(out, "func InitBlockingPool(size int) {{")?;
+        writeln!(out, "\tblockingPoolOnce.Do(func() {{")?;
+        writeln!(out, "\t\tblockingCalls = make(chan func())")?;
+        writeln!(out, "\t\tif size < 1 {{")?;
+        writeln!(out, "\t\t\tsize = 1")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tfor i := 0; i < size; i++ {{")?;
+        writeln!(out, "\t\t\tgo func() {{")?;
+        writeln!(out, "\t\t\t\truntime.LockOSThread()")?;
+        writeln!(out, "\t\t\t\tC.enter_blocking_worker()")?;
+        writeln!(out, "\t\t\t\tfor call := range blockingCalls {{")?;
+        writeln!(out, "\t\t\t\t\tcall()")?;
+        writeln!(out, "\t\t\t\t}}")?;
+        writeln!(out, "\t\t\t}}()")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// runBlocking runs call on a pool worker and waits for it to return,"
+        )?;
+        writeln!(
+            out,
+            "// re-panicking in the caller if it panics. Calls already on a worker, such as"
+        )?;
+        writeln!(out, "// an import reentering Rust, run directly.")?;
+        writeln!(out, "func runBlocking(call func()) {{")?;
+        writeln!(out, "\tif C.on_blocking_worker() != 0 {{")?;
+        writeln!(out, "\t\tcall()")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tInitBlockingPool(runtime.GOMAXPROCS(0))")?;
+        writeln!(out, "\tdone := make(chan struct{{}})")?;
+        writeln!(out, "\tvar panicked any")?;
+        writeln!(out, "\tblockingCalls <- func() {{")?;
+        writeln!(out, "\t\tdefer close(done)")?;
+        writeln!(out, "\t\tdefer func() {{ panicked = recover() }}()")?;
+        writeln!(out, "\t\tcall()")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\t<-done")?;
+        writeln!(out, "\tif panicked != nil {{")?;
+        writeln!(out, "\t\tpanic(panicked)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Whether any exported function is async or returns a future,
+    /// including feature-gated ones (whose files share these helpers).
+    fn uses_async(&self) -> bool {
+        self.exported_functions()
+            .iter()
+            .any(|ef| ef.is_awaited(self.resolve))
+    }
+
+    /// Whether any exported function returns a future, including
+    /// feature-gated ones.
+    fn uses_futures(&self) -> bool {
+        self.exported_functions()
+            .iter()
+            .any(|ef| ef.future_value(self.resolve).is_some())
+    }
+
+    /// Generate `awaitAsync` and the completion callback it hands to Rust.
+    ///
+    /// Async calls return as soon as Rust has started the future, so the
+    /// goroutine blocks on a channel rather than in C, freeing its thread
+    /// for other goroutines while the call runs.
+    fn generate_async_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// awaitAsync starts an async call and parks the calling goroutine, but not"
+        )?;
+        writeln!(
+            out,
+            "// its thread, until Rust reports that the call is done. It returns the task"
+        )?;
+        writeln!(out, "// to collect the result from.")?;
+        writeln!(
+            out,
+            "func awaitAsync(start func(complete C.FfiAsyncComplete, userData C.uintptr_t)) unsafe.Pointer {{"
+        )?;
+        writeln!(out, "\tdone := make(chan unsafe.Pointer, 1)")?;
+        writeln!(out, "\thandle := cgo.NewHandle(done)")?;
+        writeln!(out, "\tdefer handle.Delete()")?;
+        writeln!(
+            out,
+            "\tstart(C.FfiAsyncComplete(C.{prefix}_async_complete), C.uintptr_t(handle))"
+        )?;
+        writeln!(out, "\treturn <-done")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "//export {prefix}_async_complete")?;
+        writeln!(
+            out,
+            "func {prefix}_async_complete(userData C.uintptr_t, task unsafe.Pointer) {{"
+        )?;
+        writeln!(
+            out,
+            "\tcgo.Handle(userData).Value().(chan unsafe.Pointer) <- task"
+        )?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Whether any exported function returns a stream other than a
+    /// `stream<u8>`, including feature-gated ones.
+    fn uses_streams(&self) -> bool {
+        self.exported_functions().iter().any(|ef| {
+            ef.stream_item(self.resolve)
+                .is_some_and(|item| item != Type::U8)
+        })
+    }
+
+    /// Whether any exported function returns a `stream<u8>`, including
+    /// feature-gated ones.
+    fn uses_byte_readers(&self) -> bool {
+        self.exported_functions()
+            .iter()
+            .any(|ef| ef.stream_item(self.resolve) == Some(Type::U8))
+    }
+
+    /// Whether any exported function takes a `stream<u8>`, including
+    /// feature-gated ones.
+    fn uses_byte_sources(&self) -> bool {
+        self.exported_functions()
+            .iter()
+            .any(|ef| ef.takes_byte_streams(self.resolve))
+    }
+
+    /// Generate the `Stream[T]` type returned by stream functions.
+    ///
+    /// A goroutine pulls each value from Rust only once the previous one has
+    /// been received, so a slow reader holds the producer back. Each pull
+    /// blocks a thread in C while the Rust iterator waits for its next value.
+    fn generate_stream_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Stream is a stream of values produced by Rust. Values are received from C,"
+        )?;
+        writeln!(
+            out,
+            "// which is closed once the producer is exhausted, fails or is closed. A value"
+        )?;
+        writeln!(
+            out,
+            "// is only pulled from Rust once the previous one has been received."
+        )?;
+        writeln!(out, "type Stream[T any] struct {{")?;
+        writeln!(out, "\tC    <-chan T")?;
+        writeln!(out, "\tstop chan struct{{}}")?;
+        writeln!(out, "\tdone chan struct{{}}")?;
+        writeln!(out, "\tonce sync.Once")?;
+        writeln!(out, "\terr  error")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// newStream starts pulling values with next, which reports the end of the"
+        )?;
+        writeln!(
+            out,
+            "// stream with false, until the stream ends or is closed. drop releases the"
+        )?;
+        writeln!(out, "// producer.")?;
+        writeln!(
+            out,
+            "func newStream[T any](next func() (T, bool, error), drop func()) *Stream[T] {{"
+        )?;
+        writeln!(out, "\tvalues := make(chan T)")?;
+        writeln!(
+            out,
+            "\ts := &Stream[T]{{C: values, stop: make(chan struct{{}}), done: make(chan struct{{}})}}"
+        )?;
+        writeln!(out, "\tgo func() {{")?;
+        writeln!(out, "\t\tdefer close(s.done)")?;
+        writeln!(out, "\t\tdefer close(values)")?;
+        writeln!(out, "\t\tdefer {}", self.dispatched("drop"))?;
+        writeln!(out, "\t\tfor {{")?;
+        writeln!(out, "\t\t\tselect {{")?;
+        writeln!(out, "\t\t\tcase <-s.stop:")?;
+        writeln!(out, "\t\t\t\treturn")?;
+        writeln!(out, "\t\t\tdefault:")?;
+        writeln!(out, "\t\t\t}}")?;
+        if self.config.dispatch_thread {
+            writeln!(out, "\t\t\tvar value T")?;
+            writeln!(out, "\t\t\tvar ok bool")?;
+            writeln!(out, "\t\t\tvar err error")?;
+            writeln!(out, "\t\t\tdispatch(func() {{ value, ok, err = next() }})")?;
+        } else {
+            writeln!(out, "\t\t\tvalue, ok, err := next()")?;
+        }
+        writeln!(out, "\t\t\tif err != nil {{")?;
+        writeln!(out, "\t\t\t\ts.err = err")?;
+        writeln!(out, "\t\t\t\treturn")?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t\tif !ok {{")?;
+        writeln!(out, "\t\t\t\treturn")?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t\tselect {{")?;
+        writeln!(out, "\t\t\tcase values <- value:")?;
+        writeln!(out, "\t\t\tcase <-s.stop:")?;
+        writeln!(out, "\t\t\t\treturn")?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}()")?;
+        writeln!(out, "\treturn s")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// failedStream returns an already closed stream that failed with err."
+        )?;
+        writeln!(out, "func failedStream[T any](err error) *Stream[T] {{")?;
+        writeln!(out, "\tvalues := make(chan T)")?;
+        writeln!(out, "\tclose(values)")?;
+        writeln!(
+            out,
+            "\ts := &Stream[T]{{C: values, stop: make(chan struct{{}}), done: make(chan struct{{}}), err: err}}"
+        )?;
+        writeln!(out, "\tclose(s.done)")?;
+        writeln!(out, "\treturn s")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Err returns the error that ended the stream, if any, once C is closed."
+        )?;
+        writeln!(out, "func (s *Stream[T]) Err() error {{")?;
+        writeln!(out, "\t<-s.done")?;
+        writeln!(out, "\treturn s.err")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Close stops the producer, discarding values not yet received, and waits for"
+        )?;
+        writeln!(
+            out,
+            "// it to be released. It is safe to call Close more than once."
+        )?;
+        writeln!(out, "func (s *Stream[T]) Close() {{")?;
+        writeln!(out, "\ts.once.Do(func() {{ close(s.stop) }})")?;
+        writeln!(out, "\t<-s.done")?;
+        writeln!(out, "}}")?;
+
+        if self.config.stream_iterators {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// All returns an iterator over the values not yet received. The stream is"
+            )?;
+            writeln!(out, "// closed when the loop ends.")?;
+            writeln!(out, "func (s *Stream[T]) All() iter.Seq[T] {{")?;
+            writeln!(out, "\treturn func(yield func(T) bool) {{")?;
+            writeln!(out, "\t\tdefer s.Close()")?;
+            writeln!(out, "\t\tfor value := range s.C {{")?;
+            writeln!(out, "\t\t\tif !yield(value) {{")?;
+            writeln!(out, "\t\t\t\treturn")?;
+            writeln!(out, "\t\t\t}}")?;
+            writeln!(out, "\t\t}}")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "}}")?;
+        }
+
+        Ok(())
+    }
+
+    /// Generate the `byteReader` returned as an `io.ReadCloser` by functions
+    /// returning `stream<u8>`.
+    ///
+    /// Each `Read` fills the caller's buffer with a single call into C,
+    /// rather than pulling one byte at a time like a `Stream[uint8]` would.
+    fn generate_byte_reader_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// byteReader reads a stream<u8> produced by Rust. It is not safe for"
+        )?;
+        writeln!(out, "// concurrent use.")?;
+        writeln!(out, "type byteReader struct {{")?;
+        writeln!(out, "\tread   func(p []byte) (int, error)")?;
+        writeln!(out, "\tdrop   func()")?;
+        writeln!(out, "\tclosed bool")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// failedByteReader returns a reader whose reads fail with err."
+        )?;
+        writeln!(out, "func failedByteReader(err error) *byteReader {{")?;
+        writeln!(
+            out,
+            "\treturn &byteReader{{read: func([]byte) (int, error) {{ return 0, err }}, drop: func() {{}}}}"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func (r *byteReader) Read(p []byte) (int, error) {{")?;
+        writeln!(out, "\tif r.closed {{")?;
+        writeln!(out, "\t\treturn 0, io.ErrClosedPipe")?;
+        writeln!(out, "\t}}")?;
+        if self.config.dispatch_thread {
+            writeln!(out, "\tvar n int")?;
+            writeln!(out, "\tvar err error")?;
+            writeln!(out, "\tdispatch(func() {{ n, err = r.read(p) }})")?;
+            writeln!(out, "\treturn n, err")?;
+        } else {
+            writeln!(out, "\treturn r.read(p)")?;
+        }
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Close releases the producer. It is safe to call Close more than once."
+        )?;
+        writeln!(out, "func (r *byteReader) Close() error {{")?;
+        writeln!(out, "\tif !r.closed {{")?;
+        writeln!(out, "\t\tr.closed = true")?;
+        writeln!(out, "\t\t{}", self.dispatched("r.drop"))?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate `byteSource` and the read function it hands to Rust for
+    /// `stream<u8>` parameters.
+    ///
+    /// Rust reads the parameter through `{prefix}_byte_source_read` while the
+    /// call runs, on the calling goroutine's thread.
+    fn generate_byte_source_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// byteSource lends r to Rust as a stream<u8> parameter. The handle must be"
+        )?;
+        writeln!(
+            out,
+            "// deleted once the call the source is passed to has returned."
+        )?;
+        writeln!(
+            out,
+            "func byteSource(r io.Reader) (C.FfiByteSource, cgo.Handle) {{"
+        )?;
+        writeln!(out, "\thandle := cgo.NewHandle(r)")?;
+        writeln!(
+            out,
+            "\treturn C.FfiByteSource{{read: C.FfiByteRead(C.{prefix}_byte_source_read), user_data: C.uintptr_t(handle)}}, handle"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "//export {prefix}_byte_source_read")?;
+        writeln!(
+            out,
+            "func {prefix}_byte_source_read(userData C.uintptr_t, buf *C.uint8_t, length C.size_t) C.intptr_t {{"
+        )?;
+        writeln!(
+            out,
+            "\tp := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(length))"
+        )?;
+        writeln!(out, "\tr := cgo.Handle(userData).Value().(io.Reader)")?;
+        writeln!(out, "\tfor {{")?;
+        writeln!(out, "\t\tn, err := r.Read(p)")?;
+        writeln!(out, "\t\tswitch {{")?;
+        writeln!(out, "\t\tcase n > 0:")?;
+        writeln!(out, "\t\t\treturn C.intptr_t(n)")?;
+        writeln!(out, "\t\tcase err == io.EOF:")?;
+        writeln!(out, "\t\t\treturn 0")?;
+        writeln!(out, "\t\tcase err != nil:")?;
+        writeln!(out, "\t\t\treturn -1")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the `Future[T]` type returned by functions returning
+    /// `future<T>`.
+    ///
+    /// Like async calls, the call completes through `{prefix}_async_complete`,
+    /// so no thread waits in C while it runs. Cancelling drops the Rust
+    /// future, which then completes without a task.
+    fn generate_future_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Future is the eventual value of a call running in Rust. Waiting for it holds"
+        )?;
+        writeln!(out, "// no thread in C.")?;
+        writeln!(out, "type Future[T any] struct {{")?;
+        writeln!(out, "\tdone  chan struct{{}}")?;
+        writeln!(out, "\tvalue T")?;
+        writeln!(out, "\terr   error")?;
+        writeln!(out, "\tmu    sync.Mutex")?;
+        writeln!(out, "\tcall  unsafe.Pointer")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// newFuture starts a call with start, which returns the call to cancel, and"
+        )?;
+        writeln!(
+            out,
+            "// collects its value with finish once Rust reports that it is done."
+        )?;
+        writeln!(
+            out,
+            "func newFuture[T any](start func(complete C.FfiAsyncComplete, userData C.uintptr_t) unsafe.Pointer, finish func(task unsafe.Pointer) (T, error)) *Future[T] {{"
+        )?;
+        writeln!(out, "\ttasks := make(chan unsafe.Pointer, 1)")?;
+        writeln!(out, "\thandle := cgo.NewHandle(tasks)")?;
+        writeln!(out, "\tf := &Future[T]{{done: make(chan struct{{}})}}")?;
+        writeln!(
+            out,
+            "\tf.call = start(C.FfiAsyncComplete(C.{prefix}_async_complete), C.uintptr_t(handle))"
+        )?;
+        writeln!(out, "\tgo func() {{")?;
+        writeln!(out, "\t\ttask := <-tasks")?;
+        writeln!(out, "\t\thandle.Delete()")?;
+        writeln!(out, "\t\tf.mu.Lock()")?;
+        writeln!(
+            out,
+            "\t\t{}",
+            self.dispatched(&format!("func() {{ C.{prefix}_future_release(f.call) }}"))
+        )?;
+        writeln!(out, "\t\tf.call = nil")?;
+        writeln!(out, "\t\tf.mu.Unlock()")?;
+        writeln!(out, "\t\tif task == nil {{")?;
+        writeln!(out, "\t\t\tf.err = context.Canceled")?;
+        writeln!(out, "\t\t}} else {{")?;
+        writeln!(
+            out,
+            "\t\t\t{}",
+            self.dispatched("func() { f.value, f.err = finish(task) }")
+        )?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tclose(f.done)")?;
+        writeln!(out, "\t}}()")?;
+        writeln!(out, "\treturn f")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Done returns a channel that is closed once the call has finished, failed or"
+        )?;
+        writeln!(out, "// been canceled.")?;
+        writeln!(out, "func (f *Future[T]) Done() <-chan struct{{}} {{")?;
+        writeln!(out, "\treturn f.done")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Await waits for the value of the call. If ctx is done first, the call is"
+        )?;
+        writeln!(out, "// canceled and ctx's error returned.")?;
+        writeln!(
+            out,
+            "func (f *Future[T]) Await(ctx context.Context) (T, error) {{"
+        )?;
+        writeln!(out, "\tselect {{")?;
+        writeln!(out, "\tcase <-f.done:")?;
+        writeln!(out, "\t\treturn f.value, f.err")?;
+        writeln!(out, "\tcase <-ctx.Done():")?;
+        writeln!(out, "\t\tf.Cancel()")?;
+        writeln!(out, "\t\tvar zero T")?;
+        writeln!(out, "\t\treturn zero, ctx.Err()")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Cancel asks Rust to drop the call, which then fails with context.Canceled"
+        )?;
+        writeln!(
+            out,
+            "// unless it has already finished. It is safe to call Cancel more than once."
+        )?;
+        writeln!(out, "func (f *Future[T]) Cancel() {{")?;
+        writeln!(out, "\tf.mu.Lock()")?;
+        writeln!(out, "\tdefer f.mu.Unlock()")?;
+        writeln!(out, "\tif f.call != nil {{")?;
+        writeln!(
+            out,
+            "\t\t{}",
+            self.dispatched(&format!("func() {{ C.{prefix}_future_cancel(f.call) }}"))
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate `bindContext`, which binds a Rust cancellation token for a
+    /// `context.Context` to the calling thread for the duration of a call.
+    ///
+    /// Rust keeps the token in a thread-local, so the goroutine is locked to
+    /// its thread until the call returns.
+    fn generate_context_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// bindContext binds a cancellation token for ctx to the calling thread, so"
+        )?;
+        writeln!(
+            out,
+            "// that Rust sees the call made next as cancelled once ctx is done. The"
+        )?;
+        writeln!(
+            out,
+            "// returned function unbinds it and must be called once the call returns."
+        )?;
+        writeln!(
+            out,
+            "// Bindings nest, so a call reentering Rust from an import restores the outer"
+        )?;
+        writeln!(out, "// call's token when it returns.")?;
+        writeln!(out, "func bindContext(ctx context.Context) func() {{")?;
+        writeln!(out, "\tif ctx.Done() == nil {{")?;
+        writeln!(out, "\t\treturn func() {{}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\truntime.LockOSThread()")?;
+        writeln!(out, "\ttoken := C.{prefix}_cancel_token_new()")?;
+        writeln!(out, "\tC.{prefix}_cancel_token_enter(token)")?;
+        writeln!(out, "\tcancelled := make(chan struct{{}})")?;
+        writeln!(out, "\tstop := context.AfterFunc(ctx, func() {{")?;
+        writeln!(out, "\t\tC.{prefix}_cancel_token_cancel(token)")?;
+        writeln!(out, "\t\tclose(cancelled)")?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "\treturn func() {{")?;
+        writeln!(out, "\t\tC.{prefix}_cancel_token_exit()")?;
+        writeln!(out, "\t\tif !stop() {{")?;
+        writeln!(out, "\t\t\t<-cancelled")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tC.{prefix}_cancel_token_free(token)")?;
+        writeln!(out, "\t\truntime.UnlockOSThread()")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        if !self.config.timeouts.is_empty() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ErrTimeout is returned by a call that missed its configured deadline. The"
+            )?;
+            writeln!(
+                out,
+                "// call is cancelled and left to finish in the background."
+            )?;
+            writeln!(out, "var ErrTimeout = errors.New(\"call timed out\")")?;
+        }
+
+        Ok(())
+    }
+
+    /// Whether any exported function takes a `context.Context` parameter.
+    fn uses_context_params(&self) -> bool {
+        self.config.context_params || !self.config.context_functions.is_empty()
+    }
+
+    /// The name of the `context.Context` parameter prepended to `ef` with
+    /// `context_params` or `context_functions`, clear of the WIT parameter
+    /// names.
+    fn context_param(&self, ef: &ExportedFunction, param_names: &[String]) -> Option<&'static str> {
+        if !self.config.context_params
+            && !self
+                .config
+                .context_functions
+                .contains(&ef.qualified_name(self.resolve))
+        {
+            return None;
+        }
+        Some(if param_names.iter().any(|name| name == "ctx") {
+            "callCtx"
+        } else {
+            "ctx"
+        })
+    }
+
+    /// The opening line of a setup function run once before any call into
+    /// Rust: a package `init` function when eager, and `name`, which
+    /// `ensureSetup` calls, when lazy.
+    fn setup_func(&self, name: &str) -> String {
+        match self.config.init_mode {
+            GoInitMode::Eager => "func init() {".to_string(),
+            GoInitMode::Lazy => format!("func {name}() {{"),
+        }
+    }
+
+    /// Generate `ensureSetup`, which runs the setup functions the first time
+    /// it is called, for `GoInitMode::Lazy`.
+    fn generate_lazy_setup(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// setupOnce guards the setup of the Rust library.")?;
+        writeln!(out, "var setupOnce sync.Once")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ensureSetup sets up the Rust library on the first call into it rather"
+        )?;
+        writeln!(out, "// than when the package is imported.")?;
+        writeln!(out, "func ensureSetup() {{")?;
+        writeln!(out, "\tsetupOnce.Do(func() {{")?;
+        writeln!(out, "\t\tcheckABI()")?;
+        if self.config.dispatch_thread {
+            writeln!(out, "\t\tstartDispatcher()")?;
+        }
+        if self.config.foreign_panics {
+            writeln!(out, "\t\tcapturePanicBacktraces()")?;
+        }
+        writeln!(out, "\t}})")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the dispatcher goroutine, locked to the OS thread every call
+    /// into Rust runs on, and `dispatch`, which runs a call there.
+    ///
+    /// Rust calls imports on the dispatcher thread, so an import reentering
+    /// Rust is recognised by its thread and runs directly rather than
+    /// waiting on the dispatcher it is blocking.
+    fn generate_dispatch_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// dispatchCalls carries calls to the dispatcher goroutine, which is locked to"
+        )?;
+        writeln!(out, "// the one OS thread every call into Rust runs on.")?;
+        writeln!(out, "var dispatchCalls = make(chan func())")?;
+        writeln!(out)?;
+        writeln!(out, "// dispatchThread is the OS thread of the dispatcher.")?;
+        writeln!(out, "var dispatchThread C.pthread_t")?;
+        writeln!(out)?;
+        writeln!(out, "{}", self.setup_func("startDispatcher"))?;
+        writeln!(out, "\tstarted := make(chan struct{{}})")?;
+        writeln!(out, "\tgo func() {{")?;
+        writeln!(out, "\t\truntime.LockOSThread()")?;
+        writeln!(out, "\t\tdispatchThread = C.pthread_self()")?;
+        writeln!(out, "\t\tclose(started)")?;
+        writeln!(out, "\t\tfor call := range dispatchCalls {{")?;
+        writeln!(out, "\t\t\tcall()")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}()")?;
+        writeln!(out, "\t<-started")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// dispatch runs call on the dispatcher thread and waits for it to return,"
+        )?;
+        writeln!(
+            out,
+            "// re-panicking in the caller if it panics. Calls already on that thread, such"
+        )?;
+        writeln!(out, "// as an import reentering Rust, run directly.")?;
+        writeln!(out, "func dispatch(call func()) {{")?;
+        // The dispatcher must be running before a call is handed to it
+        if self.config.init_mode == GoInitMode::Lazy {
+            writeln!(out, "\tensureSetup()")?;
+        }
+        writeln!(
+            out,
+            "\tif C.pthread_equal(C.pthread_self(), dispatchThread) != 0 {{"
+        )?;
+        writeln!(out, "\t\tcall()")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tdone := make(chan struct{{}})")?;
+        writeln!(out, "\tvar panicked any")?;
+        writeln!(out, "\tdispatchCalls <- func() {{")?;
+        writeln!(out, "\t\tdefer close(done)")?;
+        writeln!(out, "\t\tdefer func() {{ panicked = recover() }}()")?;
+        writeln!(out, "\t\tcall()")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\t<-done")?;
+        writeln!(out, "\tif panicked != nil {{")?;
+        writeln!(out, "\t\tpanic(panicked)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Go code calling `call`, a Go expression of type `func()`, on the
+    /// dispatcher thread with `dispatch_thread`, and directly otherwise.
+    fn dispatched(&self, call: &str) -> String {
+        if self.config.dispatch_thread {
+            format!("dispatch({call})")
+        } else if let Some(body) = call
+            .strip_prefix("func() { ")
+            .and_then(|call| call.strip_suffix(" }"))
+        {
+            body.to_string()
+        } else {
+            format!("{call}()")
+        }
+    }
+
+    /// Whether any exported function uses an `error-context`, including
+    /// feature-gated ones.
+    fn uses_error_context(&self) -> bool {
+        self.exported_functions()
+            .iter()
+            .any(|ef| ef.uses_error_context(self.resolve))
+    }
+
+    /// Generate the `ErrorContext` type that `error-context` values lift
+    /// into, and its conversions.
+    ///
+    /// Error contexts keep the identity Rust gave them, so a context that
+    /// crosses the boundary and comes back still matches with `errors.Is`.
+    fn generate_error_context_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ErrorContext is a component-model error-context: a debug message with an"
+        )?;
+        writeln!(
+            out,
+            "// identity. Error contexts match by identity, so errors.Is matches a context"
+        )?;
+        writeln!(
+            out,
+            "// handed back from Rust but not a different one with the same message."
+        )?;
+        writeln!(out, "type ErrorContext struct {{")?;
+        writeln!(out, "\tid      uint64")?;
+        writeln!(out, "\tmessage string")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// NewErrorContext creates an error context with a fresh identity."
+        )?;
+        writeln!(out, "func NewErrorContext(message string) *ErrorContext {{")?;
+        writeln!(
+            out,
+            "\treturn &ErrorContext{{id: uint64(C.{prefix}_error_context_new_id()), message: message}}"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func (e *ErrorContext) Error() string {{")?;
+        writeln!(out, "\treturn e.DebugMessage()")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// DebugMessage returns the debug message, or \"\" for a nil context."
+        )?;
+        writeln!(out, "func (e *ErrorContext) DebugMessage() string {{")?;
+        writeln!(out, "\tif e == nil {{")?;
+        writeln!(out, "\t\treturn \"\"")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn e.message")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ID returns the identity of the context, or 0 for a nil context."
+        )?;
+        writeln!(out, "func (e *ErrorContext) ID() uint64 {{")?;
+        writeln!(out, "\tif e == nil {{")?;
+        writeln!(out, "\t\treturn 0")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn e.id")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Is reports whether target is an error context with the same identity."
+        )?;
+        writeln!(out, "func (e *ErrorContext) Is(target error) bool {{")?;
+        writeln!(out, "\tt, ok := target.(*ErrorContext)")?;
+        writeln!(out, "\treturn ok && t.ID() == e.ID()")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func liftErrorContext(c C.FfiErrorContext) *ErrorContext {{"
+        )?;
+        writeln!(
+            out,
+            "\treturn &ErrorContext{{id: uint64(c.id), message: ffiByteBufferToString(c.debug_message)}}"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// lowerErrorContext borrows e for a call, which must not retain it."
+        )?;
+        writeln!(
+            out,
+            "func lowerErrorContext(e *ErrorContext) C.FfiErrorContextInput {{"
+        )?;
+        writeln!(out, "\tmessage := e.DebugMessage()")?;
+        writeln!(out, "\treturn C.FfiErrorContextInput{{")?;
+        writeln!(out, "\t\tid: C.uint64_t(e.ID()),")?;
+        writeln!(out, "\t\tdebug_message: C.FfiByteSlice{{")?;
+        writeln!(
+            out,
+            "\t\t\tptr: (*C.uint8_t)(unsafe.Pointer(unsafe.StringData(message))),"
+        )?;
+        writeln!(out, "\t\t\tlen: C.uintptr_t(len(message)),")?;
+        writeln!(out, "\t\t}},")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the conversions between nanosecond counts or datetime
+    /// records and `time.Time`. Durations convert directly.
+    fn generate_time_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let conversions = self.time_conversions();
+        if conversions
+            .iter()
+            .any(|mapped| mapped.lift == "ffiNanosToTime")
+        {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ffiNanosToTime lifts nanoseconds since the Unix epoch into a UTC time.Time."
+            )?;
+            writeln!(
+                out,
+                "func ffiNanosToTime[T ~uint64 | ~int64](n T) time.Time {{"
+            )?;
+            writeln!(out, "\treturn time.Unix(0, int64(n)).UTC()")?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ffiTimeToNanos lowers t into nanoseconds since the Unix epoch. Times"
+            )?;
+            writeln!(out, "// outside the years 1678-2262 overflow.")?;
+            writeln!(out, "func ffiTimeToNanos(t time.Time) int64 {{")?;
+            writeln!(out, "\treturn t.UnixNano()")?;
+            writeln!(out, "}}")?;
+        }
+
+        for id in self.collect_reachable_types() {
+            let Some((seconds, nanoseconds)) = self.datetime_fields(id) else {
+                continue;
+            };
+            let ty = Type::Id(id);
+            if !conversions
+                .iter()
+                .any(|mapped| mapped.bare && mapped.inner == ty)
+            {
+                continue;
+            }
+            let wit_name = self.resolve.types[id]
+                .name
+                .as_deref()
+                .unwrap_or("anonymous");
+            let go_name = self.config.naming.to_go_type(wit_name);
+            let seconds_field = self.config.naming.to_go_field(&seconds.name);
+            let nanoseconds_field = self.config.naming.to_go_field(&nanoseconds.name);
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ffi{go_name}ToTime lifts a {go_name} into a UTC time.Time."
+            )?;
+            writeln!(out, "func ffi{go_name}ToTime(d {go_name}) time.Time {{")?;
+            writeln!(
+                out,
+                "\treturn time.Unix(int64(d.{seconds_field}), int64(d.{nanoseconds_field})).UTC()"
+            )?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(out, "// ffiTimeTo{go_name} lowers t into a {go_name}.")?;
+            writeln!(out, "func ffiTimeTo{go_name}(t time.Time) {go_name} {{")?;
+            writeln!(
+                out,
+                "\treturn {go_name}{{{seconds_field}: {}(t.Unix()), {nanoseconds_field}: {}(t.Nanosecond())}}",
+                self.type_to_go(&seconds.ty),
+                self.type_to_go(&nanoseconds.ty)
+            )?;
+            writeln!(out, "}}")?;
+        }
+
+        Ok(())
+    }
+
+    /// Generate the helpers converting between `map[K]V` and the
+    /// `list<tuple<K, V>>` it crosses the C ABI as.
+    fn generate_map_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ffiMapKey is satisfied by the Go types of WIT map keys."
+        )?;
+        writeln!(out, "type ffiMapKey interface {{")?;
+        writeln!(
+            out,
+            "\t~string | ~int8 | ~int16 | ~int32 | ~int64 | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~float32 | ~float64"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ffiPairsToMap collects lifted entries into a map; later entries win"
+        )?;
+        writeln!(out, "// over earlier ones with the same key.")?;
+        writeln!(
+            out,
+            "func ffiPairsToMap[K ffiMapKey, V any](pairs []TupleOf2[K, V]) map[K]V {{"
+        )?;
+        writeln!(out, "\tresult := make(map[K]V, len(pairs))")?;
+        writeln!(out, "\tfor _, p := range pairs {{")?;
+        writeln!(out, "\t\tresult[p.F0] = p.F1")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn result")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ffiMapToPairs lists a map's entries sorted by key, so that lowering"
+        )?;
+        writeln!(out, "// is deterministic.")?;
+        writeln!(
+            out,
+            "func ffiMapToPairs[K ffiMapKey, V any](m map[K]V) []TupleOf2[K, V] {{"
+        )?;
+        writeln!(out, "\tpairs := make([]TupleOf2[K, V], 0, len(m))")?;
+        writeln!(out, "\tfor k, v := range m {{")?;
+        writeln!(
+            out,
+            "\t\tpairs = append(pairs, TupleOf2[K, V]{{F0: k, F1: v}})"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tsort.Slice(pairs, func(i, j int) bool {{ return pairs[i].F0 < pairs[j].F0 }})"
+        )?;
+        writeln!(out, "\treturn pairs")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the 128-bit integer helpers.
+    ///
+    /// 128-bit integers cross the C ABI as a `(low, high)` pair of `u64`s;
+    /// in Go they are big-endian `[16]byte`s (two's complement for `s128`)
+    /// or, when mapped, `*big.Int`s.
+    fn generate_wide_int_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let c_ty = format!("C.{}Tuple2U64U64", self.config.c_type_prefix);
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ffiWideToBytes lifts a 128-bit integer into big-endian bytes."
+        )?;
+        writeln!(out, "func ffiWideToBytes(v {c_ty}) [16]byte {{")?;
+        writeln!(out, "\tvar b [16]byte")?;
+        writeln!(out, "\tbinary.BigEndian.PutUint64(b[:8], uint64(v.f1))")?;
+        writeln!(out, "\tbinary.BigEndian.PutUint64(b[8:], uint64(v.f0))")?;
+        writeln!(out, "\treturn b")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ffiBytesToWide lowers big-endian bytes into a 128-bit integer."
+        )?;
+        writeln!(out, "func ffiBytesToWide(b [16]byte) {c_ty} {{")?;
+        writeln!(out, "\treturn {c_ty}{{")?;
+        writeln!(out, "\t\tf0: C.uint64_t(binary.BigEndian.Uint64(b[8:])),")?;
+        writeln!(out, "\t\tf1: C.uint64_t(binary.BigEndian.Uint64(b[:8])),")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        if self.uses_big_ints() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "var wideModulus = new(big.Int).Lsh(big.NewInt(1), 128)"
+            )?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ffiWideToBigInt lifts a 128-bit integer, reading it as two's"
+            )?;
+            writeln!(out, "// complement when signed.")?;
+            writeln!(
+                out,
+                "func ffiWideToBigInt(v {c_ty}, signed bool) *big.Int {{"
+            )?;
+            writeln!(out, "\tb := ffiWideToBytes(v)")?;
+            writeln!(out, "\tn := new(big.Int).SetBytes(b[:])")?;
+            writeln!(out, "\tif signed && b[0]&0x80 != 0 {{")?;
+            writeln!(out, "\t\tn.Sub(n, wideModulus)")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\treturn n")?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// ffiBigIntToWide lowers n into a 128-bit integer. Values outside the"
+            )?;
+            writeln!(
+                out,
+                "// 128-bit range wrap around, and nil is lowered as zero."
+            )?;
+            writeln!(out, "func ffiBigIntToWide(n *big.Int) {c_ty} {{")?;
+            writeln!(out, "\tvar b [16]byte")?;
+            writeln!(out, "\tif n != nil {{")?;
+            writeln!(out, "\t\tnew(big.Int).Mod(n, wideModulus).FillBytes(b[:])")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\treturn ffiBytesToWide(b)")?;
+            writeln!(out, "}}")?;
+        }
+
+        Ok(())
+    }
+
+    /// Whether any reachable type is a 128-bit integer.
+    fn uses_wide_ints(&self) -> bool {
+        self.collect_reachable_types()
+            .iter()
+            .any(|id| self.wide_int(&Type::Id(*id)).is_some())
+    }
+
+    /// Whether any reachable 128-bit integer is mapped to `*big.Int`.
+    fn uses_big_ints(&self) -> bool {
+        self.collect_reachable_types()
+            .iter()
+            .any(|id| self.is_big_int(&Type::Id(*id)) || self.is_big_bytes(&Type::Id(*id)))
+    }
+
+    /// Whether a `list<u8>` alias is surfaced as `*big.Int`.
+    fn is_big_bytes(&self, ty: &Type) -> bool {
+        self.mapped_conversion(ty)
+            .is_some_and(|mapped| mapped.lift == "ffiBytesToBigInt")
+    }
+
+    /// The conversion for a type alias mapped to another Go type. Mappings
+    /// that only change the generated representation (128-bit integers and
+    /// maps) have none.
+    fn mapped_conversion(&self, ty: &Type) -> Option<MappedConversion> {
+        let mut ty = *ty;
+        while let Type::Id(id) = ty {
+            let typedef = &self.resolve.types[id];
+            let (inner, bare) = match &typedef.kind {
+                TypeDefKind::Type(aliased) => (*aliased, false),
+                _ => (ty, true),
+            };
+            let mapping = typedef
+                .name
+                .as_deref()
+                .and_then(|name| self.config.type_mappings.get(name));
+            if !bare && mapping.is_some() {
+                // Aliases of a mapped type (e.g. from `use`) share its mapping
+                if let Some(mapped) = self.mapped_conversion(&inner) {
+                    return Some(mapped);
+                }
+            }
+            match mapping {
+                Some(GoTypeMapping::Custom(custom)) => {
+                    return Some(MappedConversion {
+                        go_type: custom.go_type.clone(),
+                        lift: custom.lift.clone(),
+                        lower: custom.lower.clone(),
+                        inner,
+                        bare,
+                    });
+                }
+                Some(GoTypeMapping::BigInt) if self.wide_int(&ty).is_none() => {
+                    let is_bytes = matches!(
+                        self.resolve_to_leaf(&inner),
+                        Type::Id(leaf)
+                            if matches!(self.resolve.types[*leaf].kind, TypeDefKind::List(Type::U8))
+                    );
+                    return is_bytes.then(|| MappedConversion {
+                        go_type: "*big.Int".to_string(),
+                        lift: "ffiBytesToBigInt".to_string(),
+                        lower: "ffiBigIntToBytes".to_string(),
+                        inner,
+                        bare,
+                    });
+                }
+                Some(GoTypeMapping::Time) => return self.time_conversion(ty, inner, bare),
+                Some(GoTypeMapping::Duration) => {
+                    return self.is_nanos(&inner).then(|| MappedConversion {
+                        go_type: "time.Duration".to_string(),
+                        lift: "time.Duration".to_string(),
+                        lower: "int64".to_string(),
+                        inner,
+                        bare,
+                    });
+                }
+                Some(_) => return None,
+                None if !bare => ty = inner,
+                None => break,
+            }
+        }
+        None
+    }
+
+    /// The conversion for a type mapped to `time.Time`: 64-bit integers count
+    /// nanoseconds since the Unix epoch, and datetime records convert through
+    /// helpers named after the record.
+    fn time_conversion(&self, ty: Type, inner: Type, bare: bool) -> Option<MappedConversion> {
+        if self.is_nanos(&inner) {
+            return Some(MappedConversion {
+                go_type: "time.Time".to_string(),
+                lift: "ffiNanosToTime".to_string(),
+                lower: "ffiTimeToNanos".to_string(),
+                inner,
+                bare,
+            });
+        }
+        let Type::Id(id) = ty else {
+            return None;
+        };
+        self.datetime_fields(id)?;
+        let go_name = self
+            .config
+            .naming
+            .to_go_type(self.resolve.types[id].name.as_deref()?);
+        Some(MappedConversion {
+            go_type: "time.Time".to_string(),
+            lift: format!("ffi{go_name}ToTime"),
+            lower: format!("ffiTimeTo{go_name}"),
+            inner,
+            bare,
+        })
+    }
+
+    /// Whether a type is a 64-bit integer, which `Time` and `Duration`
+    /// mappings read as nanoseconds.
+    fn is_nanos(&self, ty: &Type) -> bool {
+        matches!(self.resolve_to_leaf(ty), Type::U64 | Type::S64)
+    }
+
+    /// The `seconds` and `nanoseconds` fields of a wasi-clocks style
+    /// `datetime` record, if `id` is one.
+    fn datetime_fields(&self, id: TypeId) -> Option<(&Field, &Field)> {
+        let TypeDefKind::Record(record) = &self.resolve.types[id].kind else {
+            return None;
+        };
+        match record.fields.as_slice() {
+            [seconds, nanoseconds]
+                if seconds.name == "seconds"
+                    && nanoseconds.name == "nanoseconds"
+                    && self.is_nanos(&seconds.ty)
+                    && *self.resolve_to_leaf(&nanoseconds.ty) == Type::U32 =>
+            {
+                Some((seconds, nanoseconds))
+            }
+            _ => None,
+        }
+    }
+
+    /// The conversion for a record field mapped on its own via a
+    /// `<record>.<field>` key. Only `Time` and `Duration` apply to fields.
+    fn field_conversion(&self, record_name: &str, field: &Field) -> Option<MappedConversion> {
+        let key = format!("{record_name}.{}", field.name);
+        let go_type = match self.config.type_mappings.get(&key)? {
+            GoTypeMapping::Time => "time.Time",
+            GoTypeMapping::Duration => "time.Duration",
+            _ => return None,
+        };
+        if !self.is_nanos(&field.ty) {
+            return None;
+        }
+        let (lift, lower) = match go_type {
+            "time.Time" => ("ffiNanosToTime", "ffiTimeToNanos"),
+            _ => ("time.Duration", "int64"),
+        };
+        Some(MappedConversion {
+            go_type: go_type.to_string(),
+            lift: lift.to_string(),
+            lower: lower.to_string(),
+            inner: field.ty,
+            bare: false,
+        })
+    }
+
+    /// Every conversion to a `time` type in use, from mapped types and
+    /// mapped record fields.
+    fn time_conversions(&self) -> Vec<MappedConversion> {
+        let mut conversions = Vec::new();
+        for id in self.collect_reachable_types() {
+            let typedef = &self.resolve.types[id];
+            if let TypeDefKind::Record(record) = &typedef.kind {
+                let record_name = typedef.name.as_deref().unwrap_or("anonymous");
+                conversions.extend(
+                    record
+                        .fields
+                        .iter()
+                        .filter_map(|field| self.field_conversion(record_name, field)),
+                );
+            }
+            conversions.extend(self.mapped_conversion(&Type::Id(id)));
+        }
+        conversions.retain(|mapped| mapped.go_type.starts_with("time."));
+        conversions
+    }
+
+    /// Import paths needed by custom-mapped types in use.
+    fn custom_imports(&self) -> Vec<String> {
+        let reachable = self.collect_reachable_types();
+        let mut imports: Vec<String> = self
+            .config
+            .type_mappings
+            .iter()
+            .filter(|(name, _)| {
+                reachable
+                    .iter()
+                    .any(|id| self.resolve.types[*id].name.as_deref() == Some(name.as_str()))
+            })
+            .filter_map(|(_, mapping)| match mapping {
+                GoTypeMapping::Custom(custom) => custom.import.clone(),
+                _ => None,
+            })
+            .collect();
+        if self.config.otel_spans {
+            imports.extend(
+                [
+                    "go.opentelemetry.io/otel",
+                    "go.opentelemetry.io/otel/attribute",
+                    "go.opentelemetry.io/otel/codes",
+                    "go.opentelemetry.io/otel/trace",
+                ]
+                .map(String::from),
+            );
+        }
+        imports.extend(
+            self.config
+                .plugins
+                .iter()
+                .flat_map(|plugin| plugin.imports()),
+        );
+        imports.sort();
+        imports.dedup();
+        imports
+    }
+
+    /// Recognise a 128-bit integer alias.
+    fn wide_int(&self, ty: &Type) -> Option<WideInt> {
+        witffi_core::wide_int(self.resolve, ty)
+    }
+
+    /// The configured mapping for a type, checking each name along its
+    /// alias chain so that aliases of a mapped type are mapped too.
+    fn type_mapping(&self, ty: &Type) -> Option<&GoTypeMapping> {
+        let mut ty = ty;
+        while let Type::Id(id) = ty {
+            let typedef = &self.resolve.types[*id];
+            if let Some(mapping) = typedef
+                .name
+                .as_deref()
+                .and_then(|name| self.config.type_mappings.get(name))
+            {
+                return Some(mapping);
+            }
+            match &typedef.kind {
+                TypeDefKind::Type(aliased) => ty = aliased,
+                _ => break,
+            }
+        }
+        None
+    }
+
+    /// The key and value types of a `list<tuple<K, V>>` surfaced as
+    /// `map[K]V`, if `ty` is one.
+    fn map_entry(&self, ty: &Type) -> Option<(&Type, &Type)> {
+        if self.type_mapping(ty) != Some(&GoTypeMapping::Map) {
+            return None;
+        }
+        let Type::Id(list_id) = self.resolve_to_leaf(ty) else {
+            return None;
+        };
+        let TypeDefKind::List(entry) = &self.resolve.types[*list_id].kind else {
+            return None;
+        };
+        let (key, value) = match self.tuple_elems(entry)? {
+            [key, value] => (key, value),
+            _ => return None,
+        };
+        let orderable = match self.resolve_to_leaf(key) {
+            Type::Bool | Type::ErrorContext => false,
+            Type::Id(id) => matches!(self.resolve.types[*id].kind, TypeDefKind::Enum(_)),
+            _ => true,
+        };
+        orderable.then_some((key, value))
+    }
+
+    /// The list typedef behind a type surfaced as a map.
+    fn map_list_id(&self, ty: &Type) -> TypeId {
+        match self.resolve_to_leaf(ty) {
+            Type::Id(id) => *id,
+            _ => unreachable!("maps are lists"),
+        }
+    }
+
+    /// Whether any reachable type is a resource.
+    fn uses_resources(&self) -> bool {
+        self.collect_reachable_types()
+            .iter()
+            .any(|id| matches!(self.resolve.types[*id].kind, TypeDefKind::Resource))
+    }
+
+    /// Whether any reachable type is surfaced as a map.
+    fn uses_maps(&self) -> bool {
+        self.collect_reachable_types()
+            .iter()
+            .any(|id| self.map_entry(&Type::Id(*id)).is_some())
+    }
+
+    /// Whether a 128-bit integer is surfaced as `*big.Int`.
+    fn is_big_int(&self, ty: &Type) -> bool {
+        self.wide_int(ty).is_some() && self.type_mapping(ty) == Some(&GoTypeMapping::BigInt)
+    }
+
+    /// Whether any exported function takes or returns a `char`, directly,
+    /// inside a tuple parameter or inside a reachable type.
+    fn uses_chars(&self) -> bool {
+        let nested = self.collect_reachable_types().iter().any(|id| {
+            let children: Vec<Type> = match &self.resolve.types[*id].kind {
+                TypeDefKind::Record(record) => record.fields.iter().map(|f| f.ty).collect(),
+                TypeDefKind::Variant(variant) => {
+                    variant.cases.iter().filter_map(|c| c.ty).collect()
+                }
+                TypeDefKind::Tuple(tuple) => tuple.types.clone(),
+                TypeDefKind::List(ty)
+                | TypeDefKind::FixedLengthList(ty, _)
+                | TypeDefKind::Option(ty) => vec![*ty],
+                TypeDefKind::Result(result) => {
+                    result.ok.iter().chain(&result.err).copied().collect()
+                }
+                TypeDefKind::Stream(ty) | TypeDefKind::Future(ty) => ty.iter().copied().collect(),
+                _ => Vec::new(),
+            };
+            children.iter().any(|ty| self.is_char(ty))
+        });
+        nested
+            || self.exported_functions().iter().any(|ef| {
+                let mut flat = Vec::new();
+                for p in &ef.function.params {
+                    self.flatten_param("", "", &p.ty, &mut flat);
+                }
+                let ok_ty = match self.decompose_result(&ef.function.result) {
+                    Some((ok_ty, _)) => ok_ty,
+                    None => ef.function.result,
+                };
+                flat.iter().any(|(_, _, ty)| self.is_char(ty))
+                    || ok_ty.is_some_and(|ty| self.is_char(&ty))
+            })
+    }
+
+    /// Check if a type is `char`, following aliases.
+    fn is_char(&self, ty: &Type) -> bool {
+        matches!(self.resolve_to_leaf(ty), Type::Char)
+    }
+
+    /// Whether lifting a value of `ty` lifts a `char` nested in it, e.g. in a
+    /// record field or list element, which may be invalid.
+    fn nests_char(&self, ty: &Type) -> bool {
+        let Type::Id(id) = self.resolve_to_leaf(ty) else {
+            return false;
+        };
+        let children: Vec<Type> = match &self.resolve.types[*id].kind {
+            TypeDefKind::Record(record) => record.fields.iter().map(|f| f.ty).collect(),
+            TypeDefKind::Variant(variant) => variant.cases.iter().filter_map(|c| c.ty).collect(),
+            TypeDefKind::Tuple(tuple) => tuple.types.clone(),
+            TypeDefKind::List(ty)
+            | TypeDefKind::FixedLengthList(ty, _)
+            | TypeDefKind::Option(ty) => vec![*ty],
+            TypeDefKind::Result(result) => result.ok.iter().chain(&result.err).copied().collect(),
+            _ => Vec::new(),
+        };
+        children
+            .iter()
+            .any(|ty| self.is_char(ty) || self.nests_char(ty))
+    }
+
+    /// Whether lifting a value of `ty` can fail on an invalid `char`, so
+    /// that the Go function returning it also returns an error.
+    fn lifts_char(&self, ty: &Type) -> bool {
+        self.is_char(ty) || self.nests_char(ty)
+    }
+
+    /// The call lifting `conversion` of a value of `ty` that nests a `char`,
+    /// yielding the value and the error of any invalid char in it.
+    fn lift_chars_expr(&self, ty: &Type, conversion: &str) -> String {
+        format!(
+            "liftChars(func() {} {{ return {conversion} }})",
+            self.type_to_go(ty)
+        )
+    }
+
+    // ---- Declaration order ----
+
+    /// The world's exported functions, sorted by qualified name, so that a
+    /// function keeps its place in the output wherever it moves in the WIT.
+    fn exported_functions(&self) -> Vec<ExportedFunction> {
+        let mut funcs = exported_functions(self.resolve, self.world_id);
+        funcs.sort_by_cached_key(|ef| ef.qualified_name(self.resolve));
+        funcs
+    }
+
+    // ---- Reachable types ----
+
+    /// Collect all type IDs reachable from the world's exports,
+    /// following type aliases and nested type references.
+    /// Returns types sorted by name, anonymous types by their shape (e.g.
+    /// `OptionU64`), so that the declarations of a type do not move when
+    /// the types around it change.
+    fn collect_reachable_types(&self) -> Vec<TypeId> {
+        let mut visited = HashSet::new();
+        let mut order = Vec::new();
+
+        let world = &self.resolve.worlds[self.world_id];
+        for (_key, item) in &world.exports {
+            if let wit_parser::WorldItem::Interface { id, .. } = item {
+                let iface = &self.resolve.interfaces[*id];
+                for (_name, type_id) in &iface.types {
+                    self.visit_type_id(*type_id, &mut visited, &mut order);
+                }
+                for (_fname, func) in &iface.functions {
+                    for p in &func.params {
+                        self.visit_type(&p.ty, &mut visited, &mut order);
+                    }
+                    if let Some(ty) = &func.result {
+                        self.visit_type(ty, &mut visited, &mut order);
+                    }
+                }
+            }
+        }
+
+        // Stable, so that types of the same shape stay in the order found
+        order.sort_by_cached_key(|id| witffi_core::type_shape_name(self.resolve, &Type::Id(*id)));
+        order
+    }
+
+    fn visit_type_id(
+        &self,
+        type_id: TypeId,
+        visited: &mut HashSet<TypeId>,
+        order: &mut Vec<TypeId>,
+    ) {
+        if !visited.insert(type_id) {
+            return;
+        }
+
+        let typedef = &self.resolve.types[type_id];
+
+        match &typedef.kind {
+            TypeDefKind::Record(record) => {
+                for field in &record.fields {
+                    self.visit_type(&field.ty, visited, order);
+                }
+            }
+            TypeDefKind::Variant(variant) => {
+                for case in &variant.cases {
+                    if let Some(ty) = &case.ty {
+                        self.visit_type(ty, visited, order);
+                    }
+                }
+            }
+            TypeDefKind::List(ty)
+            | TypeDefKind::Option(ty)
+            | TypeDefKind::FixedLengthList(ty, _)
+            | TypeDefKind::Stream(Some(ty))
+            | TypeDefKind::Future(Some(ty)) => {
+                self.visit_type(ty, visited, order);
+            }
+            TypeDefKind::Result(r) => {
+                if let Some(ok) = &r.ok {
+                    self.visit_type(ok, visited, order);
+                }
+                if let Some(err) = &r.err {
+                    self.visit_type(err, visited, order);
+                }
+            }
+            TypeDefKind::Tuple(tuple) => {
+                for ty in &tuple.types {
+                    self.visit_type(ty, visited, order);
+                }
+            }
+            TypeDefKind::Type(ty) => {
+                self.visit_type(ty, visited, order);
+            }
+            TypeDefKind::Handle(Handle::Own(id) | Handle::Borrow(id)) => {
+                self.visit_type_id(*id, visited, order);
+            }
+            _ => {}
+        }
+
+        // Structurally identical tuples and fixed-length lists share a single
+        // conversion function
+        if let Some(shape) = witffi_core::structural_shape_name(self.resolve, &typedef.kind) {
+            let seen = order.iter().any(|other| {
+                witffi_core::structural_shape_name(self.resolve, &self.resolve.types[*other].kind)
+                    .is_some_and(|s| s == shape)
+            });
+            if seen {
+                return;
+            }
+        }
+
+        order.push(type_id);
+    }
+
+    fn visit_type(&self, ty: &Type, visited: &mut HashSet<TypeId>, order: &mut Vec<TypeId>) {
+        if let Type::Id(id) = ty {
+            self.visit_type_id(*id, visited, order);
+        }
+    }
+
+    // ---- Result decomposition ----
+
+    /// Check if a function's return type is `result<T, E>` at the top level.
+    fn decompose_result(&self, result: &Option<Type>) -> Option<(Option<Type>, Option<Type>)> {
+        match result {
+            Some(Type::Id(id)) => {
+                let typedef = &self.resolve.types[*id];
+                if let TypeDefKind::Result(r) = &typedef.kind {
+                    Some((r.ok, r.err))
+                } else {
+                    None
+                }
+            }
+            _ => None,
+        }
+    }
+
+    // ---- Go type mapping ----
+
+    /// Map a WIT type to its idiomatic Go representation.
+    fn type_to_go(&self, ty: &Type) -> String {
+        self.go_alias_name(ty)
+            .unwrap_or_else(|| self.type_to_go_unnamed(ty))
+    }
+
+    /// The Go name declared for a named WIT alias (including named lists,
+    /// options, tuples and fixed-length lists), which signatures use in
+    /// place of the aliased type.
+    ///
+    /// 128-bit integers and aliases of results have no declaration of their
+    /// own and are spelled out instead.
+    fn go_alias_name(&self, ty: &Type) -> Option<String> {
+        let Type::Id(id) = ty else {
+            return None;
+        };
+        let typedef = &self.resolve.types[*id];
+        let name = typedef.name.as_deref()?;
+        let aliased = matches!(
+            typedef.kind,
+            TypeDefKind::Type(_)
+                | TypeDefKind::List(_)
+                | TypeDefKind::Option(_)
+                | TypeDefKind::Tuple(_)
+                | TypeDefKind::FixedLengthList(..)
+        );
+        let is_result = matches!(
+            self.resolve_to_leaf(ty),
+            Type::Id(leaf) if matches!(self.resolve.types[*leaf].kind, TypeDefKind::Result(_))
+        );
+        (aliased && !is_result && self.wide_int(ty).is_none())
+            .then(|| self.config.naming.to_go_type(name))
+    }
+
+    /// Whether an alias is declared as a distinct Go type (`type ChainId
+    /// uint64`) rather than a Go alias, so that values convert explicitly.
+    ///
+    /// Only aliases of numbers, bools and strings are, since their Go types
+    /// have no methods to lose and convert freely.
+    fn is_defined_alias(&self, ty: &Type) -> bool {
+        let Type::Id(id) = ty else {
+            return false;
+        };
+        let TypeDefKind::Type(inner) = &self.resolve.types[*id].kind else {
+            return false;
+        };
+        let scalar = matches!(
+            self.resolve_to_leaf(inner),
+            Type::Bool
+                | Type::U8
+                | Type::U16
+                | Type::U32
+                | Type::U64
+                | Type::S8
+                | Type::S16
+                | Type::S32
+                | Type::S64
+                | Type::F32
+                | Type::F64
+                | Type::String
+        );
+        scalar
+            && self.mapped_conversion(ty).is_none()
+            && self.go_alias_name(ty) != Some(self.type_to_go(inner))
+    }
+
+    /// The Go type of a WIT type without its alias name.
+    fn type_to_go_unnamed(&self, ty: &Type) -> String {
+        if let Some(mapped) = self.mapped_conversion(ty) {
+            return mapped.go_type;
+        }
+        if let Some((key, value)) = self.map_entry(ty) {
+            return format!("map[{}]{}", self.type_to_go(key), self.type_to_go(value));
+        }
+        self.type_to_go_structural(ty)
+    }
+
+    /// The Go representation of a type's structure, ignoring any type
+    /// mapping on the type itself. Shape helpers convert these values.
+    fn type_to_go_structural(&self, ty: &Type) -> String {
+        match ty {
+            Type::Bool => "bool".to_string(),
+            Type::U8 => "uint8".to_string(),
+            Type::U16 => "uint16".to_string(),
+            Type::U32 => "uint32".to_string(),
+            Type::U64 => "uint64".to_string(),
+            Type::S8 => "int8".to_string(),
+            Type::S16 => "int16".to_string(),
+            Type::S32 => "int32".to_string(),
+            Type::S64 => "int64".to_string(),
+            Type::F32 => "float32".to_string(),
+            Type::F64 => "float64".to_string(),
+            Type::Char => "rune".to_string(),
+            Type::String => "string".to_string(),
+            Type::Id(_) if self.is_big_int(ty) => "*big.Int".to_string(),
+            Type::Id(_) if self.wide_int(ty).is_some() => "[16]byte".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => "[]byte".to_string(),
+                    TypeDefKind::List(inner) => {
+                        format!("[]{}", self.type_to_go(inner))
+                    }
+                    TypeDefKind::Option(inner) if self.config.generic_options => {
+                        format!("Option[{}]", self.type_to_go(inner))
+                    }
+                    TypeDefKind::Option(inner) => {
+                        format!("*{}", self.type_to_go(inner))
+                    }
+                    TypeDefKind::FixedLengthList(inner, len) => {
+                        format!("[{len}]{}", self.type_to_go(inner))
+                    }
+                    TypeDefKind::Result(result) => {
+                        let side = |t: &Option<Type>| {
+                            t.as_ref()
+                                .map(|t| self.type_to_go(t))
+                                .unwrap_or_else(|| "struct{}".to_string())
+                        };
+                        format!("Result[{}, {}]", side(&result.ok), side(&result.err))
+                    }
+                    TypeDefKind::Type(aliased) => self.type_to_go(aliased),
+                    TypeDefKind::Tuple(tuple) => {
+                        let types: Vec<String> =
+                            tuple.types.iter().map(|t| self.type_to_go(t)).collect();
+                        format!("TupleOf{}[{}]", types.len(), types.join(", "))
+                    }
+                    TypeDefKind::Handle(Handle::Own(resource_id)) => {
+                        format!("*{}", self.resource_go_name(*resource_id))
+                    }
+                    TypeDefKind::Handle(Handle::Borrow(resource_id)) => {
+                        format!("{}Ref", self.resource_go_name(*resource_id))
+                    }
+                    TypeDefKind::Stream(Some(Type::U8)) => "io.ReadCloser".to_string(),
+                    TypeDefKind::Stream(Some(item)) => {
+                        format!("*Stream[{}]", self.type_to_go(item))
+                    }
+                    TypeDefKind::Future(Some(value)) => {
+                        format!("*Future[{}]", self.future_value_go(value))
+                    }
+                    _ => {
+                        let name = typedef.name.as_deref().unwrap_or("Anonymous");
+                        self.config.naming.to_go_type(name)
+                    }
+                }
+            }
+            Type::ErrorContext => "*ErrorContext".to_string(),
+        }
+    }
+
+    /// Map a WIT type to its CGo C type representation.
+    fn type_to_cgo(&self, ty: &Type) -> String {
+        match ty {
+            Type::Bool => "C.bool".to_string(),
+            Type::U8 => "C.uint8_t".to_string(),
+            Type::U16 => "C.uint16_t".to_string(),
+            Type::U32 => "C.uint32_t".to_string(),
+            Type::U64 => "C.uint64_t".to_string(),
+            Type::S8 => "C.int8_t".to_string(),
+            Type::S16 => "C.int16_t".to_string(),
+            Type::S32 => "C.int32_t".to_string(),
+            Type::S64 => "C.int64_t".to_string(),
+            Type::F32 => "C.float".to_string(),
+            Type::F64 => "C.double".to_string(),
+            Type::Char => "C.uint32_t".to_string(),
+            Type::String => "C.FfiByteBuffer".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => "C.FfiByteBuffer".to_string(),
+                    TypeDefKind::List(_) | TypeDefKind::Result(_) => {
+                        format!("C.{}{}", self.config.c_type_prefix, self.shape_name(*id))
+                    }
+                    TypeDefKind::Option(inner) => {
+                        format!("*{}", self.type_to_cgo(inner))
+                    }
+                    TypeDefKind::Type(aliased) => self.type_to_cgo(aliased),
+                    TypeDefKind::Tuple(tuple) => format!(
+                        "C.{}{}",
+                        self.config.c_type_prefix,
+                        witffi_core::tuple_shape_name(self.resolve, tuple)
+                    ),
+                    TypeDefKind::FixedLengthList(inner, len) => format!(
+                        "C.{}{}",
+                        self.config.c_type_prefix,
+                        witffi_core::fixed_list_shape_name(self.resolve, inner, *len)
+                    ),
+                    TypeDefKind::Handle(Handle::Own(resource_id) | Handle::Borrow(resource_id)) => {
+                        let resource_id = witffi_core::dealias(self.resolve, *resource_id);
+                        let name = self.resolve.types[resource_id]
+                            .name
+                            .as_deref()
+                            .unwrap_or("Anonymous");
+                        format!("*C.{}", names::to_c_type(&self.config.c_type_prefix, name))
+                    }
+                    _ => {
+                        let name = typedef.name.as_deref().unwrap_or("Anonymous");
+                        format!("C.{}", names::to_c_type(&self.config.c_type_prefix, name))
+                    }
+                }
+            }
+            Type::ErrorContext => "C.FfiErrorContext".to_string(),
+        }
+    }
+
+    /// Map a WIT type to the CGo type of its borrowed input representation,
+    /// as stored in the elements of a lowered list.
+    fn type_to_cgo_input(&self, ty: &Type) -> String {
+        match ty {
+            Type::String => "C.FfiByteSlice".to_string(),
+            Type::Id(_) if self.wide_int(ty).is_some() => self.type_to_cgo(ty),
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::List(Type::U8) => "C.FfiByteSlice".to_string(),
+                TypeDefKind::List(_) => {
+                    format!(
+                        "C.{}{}Slice",
+                        self.config.c_type_prefix,
+                        self.shape_name(*id)
+                    )
+                }
+                TypeDefKind::Option(inner) => format!("*{}", self.type_to_cgo_input(inner)),
+                TypeDefKind::Tuple(tuple)
+                    if tuple
+                        .types
+                        .iter()
+                        .any(|t| self.type_to_cgo_input(t) != self.type_to_cgo(t)) =>
+                {
+                    format!("{}Input", self.type_to_cgo(ty))
+                }
+                TypeDefKind::Type(aliased) => self.type_to_cgo_input(aliased),
+                _ => self.type_to_cgo(ty),
+            },
+            Type::ErrorContext => "C.FfiErrorContextInput".to_string(),
+            _ => self.type_to_cgo(ty),
+        }
+    }
+
+    /// The shape name of a typedef generated once per shape, e.g.
+    /// `ListPoint` for `list<point>`.
+    fn shape_name(&self, type_id: TypeId) -> String {
+        witffi_core::structural_shape_name(self.resolve, &self.resolve.types[type_id].kind)
+            .unwrap_or_else(|| "Anonymous".to_string())
+    }
+
+    /// Whether a result typedef is nested inside another type, and so is
+    /// lifted into the generic `Result[T, E]`.
+    fn is_nested_result(&self, type_id: TypeId, nested: &HashSet<String>) -> bool {
+        matches!(self.resolve.types[type_id].kind, TypeDefKind::Result(_))
+            && nested.contains(&self.shape_name(type_id))
+    }
+
+    // ---- Type generation ----
+
+    fn generate_types(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// ---- Types ----")?;
+
+        let reachable = self.collect_reachable_types();
+        self.generate_tuple_types(out, &reachable)?;
+        if self.config.generic_options
+            && reachable
+                .iter()
+                .any(|id| matches!(self.resolve.types[*id].kind, TypeDefKind::Option(_)))
+        {
+            self.generate_option_type(out)?;
+        }
+        let nested = witffi_core::nested_result_shapes(self.resolve, self.world_id, &reachable);
+        if reachable
+            .iter()
+            .any(|id| self.is_nested_result(*id, &nested))
+        {
+            self.generate_result_type(out)?;
+        }
+        for type_id in &reachable {
+            self.generate_type_def(out, *type_id)?;
+        }
+
+        Ok(())
+    }
+
+    /// Generate the generic `Option[T]` used for `option<T>` when
+    /// `generic_options` is enabled.
+    fn generate_option_type(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(out, "// Option holds a value of type T that may be absent.")?;
+        writeln!(out, "type Option[T any] struct {{")?;
+        writeln!(out, "\tvalue T")?;
+        writeln!(out, "\tok    bool")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// Some returns an Option holding v.")?;
+        writeln!(out, "func Some[T any](v T) Option[T] {{")?;
+        writeln!(out, "\treturn Option[T]{{value: v, ok: true}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// None returns an empty Option.")?;
+        writeln!(out, "func None[T any]() Option[T] {{")?;
+        writeln!(out, "\treturn Option[T]{{}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Get returns the held value and whether it is present."
+        )?;
+        writeln!(out, "func (o Option[T]) Get() (T, bool) {{")?;
+        writeln!(out, "\treturn o.value, o.ok")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// OrElse returns the held value, or fallback if the Option is empty."
+        )?;
+        writeln!(out, "func (o Option[T]) OrElse(fallback T) T {{")?;
+        writeln!(out, "\tif o.ok {{")?;
+        writeln!(out, "\t\treturn o.value")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn fallback")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the generic `Result[T, E]` used for results nested inside
+    /// other types. Top-level results are returned as `(T, error)` instead.
+    fn generate_result_type(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Result holds either the Ok or the Err value of a WIT result, as"
+        )?;
+        writeln!(out, "// selected by IsOk.")?;
+        writeln!(out, "type Result[T, E any] struct {{")?;
+        writeln!(out, "\tOk   T")?;
+        writeln!(out, "\tErr  E")?;
+        writeln!(out, "\tIsOk bool")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Whether a type is the typed error of some exported `result<T, E>`,
+    /// in which case its Go type implements `error`.
+    fn is_error_type(&self, type_id: TypeId) -> bool {
+        self.exported_functions()
+            .iter()
+            .any(|ef| ef.typed_error(self.resolve) == Some(type_id))
+    }
+
+    /// The name of the sentinel error for an enum error case: `Err{Case}`,
+    /// or `Err{Enum}{Case}` when another error enum has a case of the same
+    /// name.
+    fn enum_sentinel_name(&self, type_id: TypeId, case_name: &str) -> String {
+        let mut error_enums: Vec<TypeId> = self
+            .exported_functions()
+            .iter()
+            .filter_map(|ef| ef.typed_error(self.resolve))
+            .filter(|id| matches!(self.resolve.types[*id].kind, TypeDefKind::Enum(_)))
+            .collect();
+        error_enums.sort();
+        error_enums.dedup();
+        let shared = error_enums.iter().filter(|id| **id != type_id).any(|id| {
+            match &self.resolve.types[*id].kind {
+                TypeDefKind::Enum(e) => e.cases.iter().any(|c| c.name == case_name),
+                _ => false,
+            }
+        });
+        if shared {
+            let wit_name = self.resolve.types[type_id].name.as_deref().unwrap_or("");
+            format!(
+                "Err{}{}",
+                self.config.naming.to_go_type(wit_name),
+                self.config.naming.to_go_type(case_name)
+            )
+        } else {
+            format!("Err{}", self.config.naming.to_go_type(case_name))
+        }
+    }
+
+    /// Generate one generic `TupleOfN` struct for each tuple arity in use.
+    fn generate_tuple_types(&self, out: &mut String, reachable: &[TypeId]) -> std::fmt::Result {
+        let mut arities: Vec<usize> = reachable
+            .iter()
+            .filter_map(|id| match &self.resolve.types[*id].kind {
+                TypeDefKind::Tuple(tuple) => Some(tuple.types.len()),
+                _ => None,
+            })
+            .collect();
+        arities.sort_unstable();
+        arities.dedup();
+
+        for n in arities {
+            let params: Vec<String> = (0..n).map(|i| format!("T{i}")).collect();
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// TupleOf{n} holds the elements of a WIT tuple with {n} element(s)."
+            )?;
+            writeln!(out, "type TupleOf{n}[{} any] struct {{", params.join(", "))?;
+            for (i, param) in params.iter().enumerate() {
+                writeln!(out, "\tF{i} {param}")?;
+            }
+            writeln!(out, "}}")?;
+        }
+
+        Ok(())
+    }
+
+    fn generate_type_def(&self, out: &mut String, type_id: TypeId) -> std::fmt::Result {
+        let typedef = &self.resolve.types[type_id];
+        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+
+        match &typedef.kind {
+            TypeDefKind::Record(record) => {
+                let go_name = self.config.naming.to_go_type(wit_name);
+                writeln!(out)?;
+                self.write_type_doc_comment(out, &go_name, typedef)?;
+                writeln!(out, "type {go_name} struct {{")?;
+                for field in &record.fields {
+                    let field_name = self.config.naming.to_go_field(&field.name);
+                    let field_type = match self.field_conversion(wit_name, field) {
+                        Some(mapped) => mapped.go_type,
+                        None => self.type_to_go(&field.ty),
+                    };
+                    if let Some(docs) = &field.docs.contents {
+                        Self::write_doc_comment(out, docs, "\t")?;
+                    }
+                    if self.config.http_handlers {
+                        writeln!(out, "\t{field_name} {field_type} `json:\"{}\"`", field.name)?;
+                    } else {
+                        writeln!(out, "\t{field_name} {field_type}")?;
+                    }
+                }
+                writeln!(out, "}}")?;
+                if self.is_error_type(type_id) {
+                    writeln!(out)?;
+                    writeln!(out, "// Error implements the error interface.")?;
+                    writeln!(out, "func (e {go_name}) Error() string {{")?;
+                    writeln!(out, "\ttype plain {go_name}")?;
+                    writeln!(out, "\treturn fmt.Sprintf(\"{wit_name}: %+v\", plain(e))")?;
+                    writeln!(out, "}}")?;
+                }
+            }
+
+            TypeDefKind::Variant(variant) => {
+                let go_name = self.config.naming.to_go_type(wit_name);
+                let marker_name = names::to_go_ident(wit_name);
+                let marker_iface = format!("{marker_name}Variant");
+                let marker_method = format!("is{go_name}");
+
+                writeln!(out)?;
+                // Unexported marker interface
+                let is_error = self.is_error_type(type_id);
+                writeln!(out, "type {marker_iface} interface {{")?;
+                writeln!(out, "\t{marker_method}()")?;
+                if is_error {
+                    writeln!(out, "\terror")?;
+                }
+                writeln!(out, "}}")?;
+                writeln!(out)?;
+
+                // Public type alias
+                self.write_type_doc_comment(out, &go_name, typedef)?;
+                writeln!(out, "type {go_name} = {marker_iface}")?;
+
+                // Concrete types for each variant case
+                for case in &variant.cases {
+                    let case_name =
+                        format!("{}{}", go_name, self.config.naming.to_go_type(&case.name));
+                    writeln!(out)?;
+                    if let Some(docs) = &case.docs.contents {
+                        Self::write_decl_doc_comment(out, &case_name, docs, false)?;
+                    }
+                    if let Some(fields) = self.payload_fields(&case.ty) {
+                        writeln!(out, "type {case_name} struct {{")?;
+                        for (i, field_ty) in fields.iter().enumerate() {
+                            writeln!(out, "\tF{i} {}", self.type_to_go(field_ty))?;
+                        }
+                        writeln!(out, "}}")?;
+                    } else if let Some(ty) = &case.ty {
+                        let payload_type = self.type_to_go(ty);
+                        writeln!(out, "type {case_name} struct {{ Value {payload_type} }}")?;
+                    } else {
+                        writeln!(out, "type {case_name} struct{{}}")?;
+                    }
+                    writeln!(out, "func ({case_name}) {marker_method}() {{}}")?;
+                    if is_error {
+                        writeln!(out, "func (e {case_name}) Error() string {{")?;
+                        if let Some(fields) = self.payload_fields(&case.ty) {
+                            let verbs = vec!["%v"; fields.len()].join(", ");
+                            let args: Vec<String> =
+                                (0..fields.len()).map(|i| format!("e.F{i}")).collect();
+                            writeln!(
+                                out,
+                                "\treturn fmt.Sprintf(\"{wit_name} {}: ({verbs})\", {})",
+                                case.name,
+                                args.join(", ")
+                            )?;
+                        } else if case.ty.is_some() {
+                            writeln!(
+                                out,
+                                "\treturn fmt.Sprintf(\"{wit_name} {}: %v\", e.Value)",
+                                case.name
+                            )?;
+                        } else {
+                            writeln!(out, "\treturn \"{wit_name} {}\"", case.name)?;
+                        }
+                        writeln!(out, "}}")?;
+                    }
+                }
+            }
+
+            TypeDefKind::Enum(e) => {
+                let go_name = self.config.naming.to_go_type(wit_name);
+                writeln!(out)?;
+                self.write_type_doc_comment(out, &go_name, typedef)?;
+                writeln!(out, "type {go_name} uint32")?;
+                writeln!(out)?;
+                writeln!(out, "const (")?;
+                for (i, case) in e.cases.iter().enumerate() {
+                    let variant_name =
+                        format!("{}{}", go_name, self.config.naming.to_go_type(&case.name));
+                    if let Some(docs) = &case.docs.contents {
+                        Self::write_doc_comment(out, docs, "\t")?;
+                    }
+                    if i == 0 {
+                        writeln!(out, "\t{variant_name} {go_name} = iota")?;
+                    } else {
+                        writeln!(out, "\t{variant_name}")?;
+                    }
+                }
+                writeln!(out, ")")?;
+                self.generate_enum_methods(out, wit_name, e)?;
+                if self.is_error_type(type_id) {
+                    writeln!(out)?;
+                    writeln!(out, "// Error implements the error interface.")?;
+                    writeln!(out, "func (e {go_name}) Error() string {{")?;
+                    writeln!(out, "\treturn e.String()")?;
+                    writeln!(out, "}}")?;
+                    writeln!(out)?;
+                    writeln!(
+                        out,
+                        "// Sentinel errors for the cases of {go_name}, for use with errors.Is."
+                    )?;
+                    writeln!(out, "var (")?;
+                    for case in &e.cases {
+                        writeln!(
+                            out,
+                            "\t{} error = {go_name}{}",
+                            self.enum_sentinel_name(type_id, &case.name),
+                            self.config.naming.to_go_type(&case.name)
+                        )?;
+                    }
+                    writeln!(out, ")")?;
+                }
+            }
+
+            TypeDefKind::Flags(flags) => {
+                let go_name = self.config.naming.to_go_type(wit_name);
+                writeln!(out)?;
+                self.write_type_doc_comment(out, &go_name, typedef)?;
+                writeln!(out, "type {go_name} uint32")?;
+                writeln!(out)?;
+                writeln!(out, "const (")?;
+                for (i, flag) in flags.flags.iter().enumerate() {
+                    let flag_name =
+                        format!("{}{}", go_name, self.config.naming.to_go_type(&flag.name));
+                    if let Some(docs) = &flag.docs.contents {
+                        Self::write_doc_comment(out, docs, "\t")?;
+                    }
+                    if i == 0 {
+                        writeln!(out, "\t{flag_name} {go_name} = 1 << iota")?;
+                    } else {
+                        writeln!(out, "\t{flag_name}")?;
+                    }
+                }
+                writeln!(out, ")")?;
+                self.generate_flags_methods(out, wit_name, flags)?;
+            }
+
+            TypeDefKind::Type(inner) => {
+                let go_name = self.config.naming.to_go_type(wit_name);
+                let inner_ty = self.type_to_go(inner);
+                // Skip self-referential aliases (e.g. from `use`)
+                if go_name != inner_ty {
+                    let ty = Type::Id(type_id);
+                    writeln!(out)?;
+                    self.write_type_doc_comment(out, &go_name, typedef)?;
+                    if self.is_defined_alias(&ty) {
+                        writeln!(out, "type {go_name} {inner_ty}")?;
+                    } else if self.map_entry(&ty).is_some() || self.mapped_conversion(&ty).is_some()
+                    {
+                        writeln!(out, "type {go_name} = {}", self.type_to_go_unnamed(&ty))?;
+                    } else {
+                        writeln!(out, "type {go_name} = {inner_ty}")?;
+                    }
+                }
+            }
+
+            TypeDefKind::Resource => {
+                self.generate_resource_type(out, type_id)?;
+            }
+
+            TypeDefKind::List(_)
+            | TypeDefKind::Option(_)
+            | TypeDefKind::Tuple(_)
+            | TypeDefKind::FixedLengthList(..)
+                if self.go_alias_name(&Type::Id(type_id)).is_some() =>
+            {
+                let go_name = self.config.naming.to_go_type(wit_name);
+                writeln!(out)?;
+                self.write_type_doc_comment(out, &go_name, typedef)?;
+                writeln!(
+                    out,
+                    "type {go_name} = {}",
+                    self.type_to_go_unnamed(&Type::Id(type_id))
+                )?;
+            }
+
+            TypeDefKind::List(_)
+            | TypeDefKind::Option(_)
+            | TypeDefKind::Result(_)
+            | TypeDefKind::Tuple(_)
+            | TypeDefKind::Handle(_)
+            | TypeDefKind::Stream(_)
+            | TypeDefKind::Future(_) => {
+                // Handled inline when they appear as field/param types
+                // (tuples use the shared `TupleOfN` structs, streams and
+                // futures the generic `Stream[T]` and `Future[T]`)
+            }
+
+            other => {
+                writeln!(
+                    out,
+                    "// TODO: generate Go type for {wit_name} (kind: {})",
+                    other.as_str()
+                )?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Generate `String`, `ParseFoo` and `AllFooValues` for an enum type.
+    fn generate_enum_methods(
+        &self,
+        out: &mut String,
+        wit_name: &str,
+        e: &wit_parser::Enum,
+    ) -> std::fmt::Result {
+        let go_name = self.config.naming.to_go_type(wit_name);
+        let names_var = format!("{}Names", names::to_go_ident(wit_name));
+
+        writeln!(out)?;
+        writeln!(out, "var {names_var} = [...]string{{")?;
+        for case in &e.cases {
+            writeln!(out, "\t\"{}\",", case.name)?;
+        }
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// String returns the WIT name of the {go_name}.")?;
+        writeln!(out, "func (e {go_name}) String() string {{")?;
+        writeln!(out, "\tif int(e) < len({names_var}) {{")?;
+        writeln!(out, "\t\treturn {names_var}[e]")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn fmt.Sprintf(\"{go_name}(%d)\", uint32(e))")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Parse{go_name} returns the {go_name} with the given WIT name."
+        )?;
+        writeln!(out, "func Parse{go_name}(s string) ({go_name}, error) {{")?;
+        writeln!(out, "\tfor i, name := range {names_var} {{")?;
+        writeln!(out, "\t\tif name == s {{")?;
+        writeln!(out, "\t\t\treturn {go_name}(i), nil")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn 0, fmt.Errorf(\"invalid {go_name}: %q\", s)")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// All{go_name}Values returns every {go_name} in declaration order."
+        )?;
+        writeln!(out, "func All{go_name}Values() []{go_name} {{")?;
+        writeln!(out, "\treturn []{go_name}{{")?;
+        for case in &e.cases {
+            writeln!(
+                out,
+                "\t\t{go_name}{},",
+                self.config.naming.to_go_type(&case.name)
+            )?;
+        }
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the `Has`/`Set`/`Clear` helpers and `String` method for a
+    /// flags type.
+    fn generate_flags_methods(
+        &self,
+        out: &mut String,
+        wit_name: &str,
+        flags: &wit_parser::Flags,
+    ) -> std::fmt::Result {
+        let go_name = self.config.naming.to_go_type(wit_name);
+        let names_var = format!("{}FlagNames", names::to_go_ident(wit_name));
+
+        writeln!(out)?;
+        writeln!(out, "var {names_var} = [...]string{{")?;
+        for flag in &flags.flags {
+            writeln!(out, "\t\"{}\",", flag.name)?;
+        }
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Has reports whether all flags in other are set in f."
+        )?;
+        writeln!(out, "func (f {go_name}) Has(other {go_name}) bool {{")?;
+        writeln!(out, "\treturn f&other == other")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// Set sets the flags in other.")?;
+        writeln!(out, "func (f *{go_name}) Set(other {go_name}) {{")?;
+        writeln!(out, "\t*f |= other")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// Clear clears the flags in other.")?;
+        writeln!(out, "func (f *{go_name}) Clear(other {go_name}) {{")?;
+        writeln!(out, "\t*f &^= other")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// String returns the names of the set flags separated by \"|\", or \"0\" if none are set."
+        )?;
+        writeln!(out, "func (f {go_name}) String() string {{")?;
+        writeln!(out, "\ts := \"\"")?;
+        writeln!(out, "\tfor i, name := range {names_var} {{")?;
+        writeln!(out, "\t\tif f&(1<<i) == 0 {{")?;
+        writeln!(out, "\t\t\tcontinue")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\tif s != \"\" {{")?;
+        writeln!(out, "\t\t\ts += \"|\"")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\ts += name")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif s == \"\" {{")?;
+        writeln!(out, "\t\treturn \"0\"")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn s")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the Go handle wrapper for a resource.
+    ///
+    /// The wrapper owns an opaque pointer into the foreign library. `Close`
+    /// hands it back to the resource's `_drop` function.
+    fn generate_resource_type(&self, out: &mut String, type_id: TypeId) -> std::fmt::Result {
+        let typedef = &self.resolve.types[type_id];
+        let go_name = self.resource_go_name(type_id);
+        let c_name = names::to_c_type(
+            &self.config.c_type_prefix,
+            typedef.name.as_deref().unwrap_or("anonymous"),
+        );
+        let drop_func = format!(
+            "{}_drop",
+            witffi_core::resource_c_prefix(self.resolve, &self.config.c_prefix, type_id)
+        );
+        let ref_name = format!("{go_name}Ref");
+        let receiver = self.resource_receiver(type_id);
+        let cleanup = self.resource_cleanup(type_id);
+        let mutex = self.resource_mutex(type_id);
+
+        // Borrowed view: carries the handle but cannot release it, so
+        // `borrow<T>` parameters can never transfer or drop ownership.
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// {ref_name} is a borrowed {go_name}. It is only valid while the owning"
+        )?;
+        writeln!(
+            out,
+            "// {go_name} is open, and cannot be closed or passed where ownership is required."
+        )?;
+        writeln!(out, "type {ref_name} struct {{")?;
+        writeln!(out, "\thandle *C.{c_name}")?;
+        writeln!(
+            out,
+            "\t// closed is shared with the owning {go_name}, and nil for views Rust lends"
+        )?;
+        writeln!(out, "\t// for the length of a call.")?;
+        writeln!(out, "\tclosed *atomic.Bool")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// open reports whether {receiver}'s handle can still be passed to Rust."
+        )?;
+        writeln!(out, "func ({receiver} {ref_name}) open() bool {{")?;
+        writeln!(
+            out,
+            "\treturn {receiver}.handle != nil && ({receiver}.closed == nil || !{receiver}.closed.Load())"
+        )?;
+        writeln!(out, "}}")?;
+
+        writeln!(out)?;
+        if let Some(docs) = &typedef.docs.contents {
+            Self::write_decl_doc_comment(out, &go_name, docs, false)?;
+            writeln!(out, "//")?;
+        }
+        writeln!(
+            out,
+            "// {go_name} is a handle to a resource owned by the foreign library."
+        )?;
+        match cleanup {
+            ResourceCleanup::Manual => writeln!(out, "// Call Close to release it.")?,
+            ResourceCleanup::AddCleanup | ResourceCleanup::Finalizer => {
+                writeln!(
+                    out,
+                    "// Call Close to release it promptly; otherwise it is released once the"
+                )?;
+                writeln!(
+                    out,
+                    "// handle is garbage collected, possibly on another thread."
+                )?;
+            }
+        }
+        if let Some((_, scope)) = &mutex {
+            writeln!(
+                out,
+                "// It is single-threaded: calls on it are serialized with every other call"
+            )?;
+            writeln!(
+                out,
+                "// into the {scope}, and it is released under the same lock."
+            )?;
+        }
+        self.write_decl_notes(
+            out,
+            &self.type_item_name(typedef),
+            deprecated_version(&typedef.stability).as_deref(),
+            true,
+        )?;
+        writeln!(out, "type {go_name} struct {{")?;
+        writeln!(out, "\t{ref_name}")?;
+        if cleanup == ResourceCleanup::AddCleanup {
+            writeln!(out, "\tcleanup runtime.Cleanup")?;
+        }
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Borrow returns a borrowed view of {receiver}, valid until {receiver} is closed."
+        )?;
+        writeln!(out, "func ({receiver} *{go_name}) Borrow() {ref_name} {{")?;
+        writeln!(out, "\treturn {receiver}.{ref_name}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// claim marks {receiver} closed before its handle is released or handed to"
+        )?;
+        writeln!(
+            out,
+            "// Rust, reporting false if it already was, so the handle is given up once."
+        )?;
+        writeln!(out, "func ({receiver} *{go_name}) claim() bool {{")?;
+        writeln!(
+            out,
+            "\treturn {receiver} != nil && {receiver}.handle != nil && {receiver}.closed.CompareAndSwap(false, true)"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Close releases the underlying resource. It is safe to call Close more than"
+        )?;
+        writeln!(
+            out,
+            "// once, and from several goroutines; later calls on {receiver} or its borrowed"
+        )?;
+        writeln!(
+            out,
+            "// views fail with ErrClosed. Close must not race a call still using {receiver}."
+        )?;
+        writeln!(out, "func ({receiver} *{go_name}) Close() {{")?;
+        writeln!(out, "\tif !{receiver}.claim() {{")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        if let Some((mutex, _)) = &mutex {
+            writeln!(out, "\tdefer {mutex}.lock()()")?;
+        }
+        match cleanup {
+            ResourceCleanup::Manual => {}
+            ResourceCleanup::AddCleanup => writeln!(out, "\t{receiver}.cleanup.Stop()")?,
+            ResourceCleanup::Finalizer => writeln!(out, "\truntime.SetFinalizer({receiver}, nil)")?,
+        }
+        if let Some(untrack) = self.untrack_handle(&format!("{receiver}.handle")) {
+            writeln!(out, "\t{untrack}")?;
+        }
+        writeln!(
+            out,
+            "\t{}",
+            self.dispatched(&format!("func() {{ C.{drop_func}({receiver}.handle) }}"))
+        )?;
+        writeln!(out, "\t{receiver}.handle = nil")?;
+        writeln!(out, "}}")?;
+
+        // Owned handles returned from the foreign library are wrapped
+        // through a constructor, which also registers any GC cleanup.
+        writeln!(out)?;
+        writeln!(out, "func wrap{go_name}(handle *C.{c_name}) *{go_name} {{")?;
+        writeln!(
+            out,
+            "\t{receiver} := &{go_name}{{{ref_name}: {ref_name}{{handle: handle, closed: new(atomic.Bool)}}}}"
+        )?;
+        if self.config.handle_table {
+            writeln!(out, "\ttrackHandle(\"{go_name}\", unsafe.Pointer(handle))")?;
+        }
+        match cleanup {
+            ResourceCleanup::Manual => {}
+            ResourceCleanup::AddCleanup => {
+                let drop = self.dispatched(&format!("func() {{ C.{drop_func}(h) }}"));
+                let untrack = self.untrack_handle("h");
+                if mutex.is_some() || untrack.is_some() {
+                    writeln!(
+                        out,
+                        "\t{receiver}.cleanup = runtime.AddCleanup({receiver}, func(h *C.{c_name}) {{"
+                    )?;
+                    if let Some(untrack) = &untrack {
+                        writeln!(out, "\t\t{untrack}")?;
+                    }
+                    if let Some((mutex, _)) = &mutex {
+                        writeln!(out, "\t\tdefer {mutex}.lock()()")?;
+                    }
+                    writeln!(out, "\t\t{drop}")?;
+                    writeln!(out, "\t}}, handle)")?;
+                } else {
+                    writeln!(
+                        out,
+                        "\t{receiver}.cleanup = runtime.AddCleanup({receiver}, func(h *C.{c_name}) {{ {drop} }}, handle)"
+                    )?;
+                }
+            }
+            ResourceCleanup::Finalizer => writeln!(
+                out,
+                "\truntime.SetFinalizer({receiver}, (*{go_name}).Close)"
+            )?,
+        }
+        writeln!(out, "\treturn {receiver}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Go code striking `handle`, being released or handed back to Rust, off
+    /// the handle table, if `handle_table` is set.
+    fn untrack_handle(&self, handle: &str) -> Option<String> {
+        self.config
+            .handle_table
+            .then(|| format!("untrackHandle(unsafe.Pointer({handle}))"))
+    }
+
+    /// Generate the table of live resource handles, `LiveHandles` and
+    /// `CheckNoLiveHandles`.
+    fn generate_handle_table(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// HandleInfo describes an open resource handle.")?;
+        writeln!(out, "type HandleInfo struct {{")?;
+        writeln!(
+            out,
+            "\t// Type is the Go type of the resource (e.g. \"Counter\")."
+        )?;
+        writeln!(out, "\tType string")?;
+        writeln!(out, "\t// Created is when Rust handed the handle to Go.")?;
+        writeln!(out, "\tCreated time.Time")?;
+        writeln!(
+            out,
+            "\t// Stack is where the handle was created, in builds with the {} tag;",
+            Self::DEBUG_TAG
+        )?;
+        writeln!(out, "\t// empty otherwise.")?;
+        writeln!(out, "\tStack string")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// Age returns how long the handle has been open.")?;
+        writeln!(out, "func (h HandleInfo) Age() time.Duration {{")?;
+        writeln!(out, "\treturn time.Since(h.Created)")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// liveHandles maps every open resource handle to its HandleInfo."
+        )?;
+        writeln!(out, "var liveHandles sync.Map")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func trackHandle(kind string, handle unsafe.Pointer) {{"
+        )?;
+        writeln!(
+            out,
+            "\tinfo := HandleInfo{{Type: kind, Created: time.Now()}}"
+        )?;
+        writeln!(out, "\tif handleStacks {{")?;
+        writeln!(out, "\t\tinfo.Stack = string(debug.Stack())")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tliveHandles.Store(handle, info)")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func untrackHandle(handle unsafe.Pointer) {{")?;
+        writeln!(out, "\tliveHandles.Delete(handle)")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// LiveHandles returns every resource handle not yet closed, garbage collected"
+        )?;
+        writeln!(
+            out,
+            "// or handed back to Rust, oldest first. A count that keeps growing in a"
+        )?;
+        writeln!(out, "// long-running service points at a leak.")?;
+        writeln!(out, "func LiveHandles() []HandleInfo {{")?;
+        writeln!(out, "\tvar handles []HandleInfo")?;
+        writeln!(out, "\tliveHandles.Range(func(_, info any) bool {{")?;
+        writeln!(out, "\t\thandles = append(handles, info.(HandleInfo))")?;
+        writeln!(out, "\t\treturn true")?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "\tsort.Slice(handles, func(i, j int) bool {{")?;
+        writeln!(
+            out,
+            "\t\treturn handles[i].Created.Before(handles[j].Created)"
+        )?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "\treturn handles")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// CheckNoLiveHandles reports every open resource handle as an error of t,"
+        )?;
+        writeln!(
+            out,
+            "// such as a *testing.T at the end of a test that should have closed them all."
+        )?;
+        writeln!(out, "func CheckNoLiveHandles(t interface {{")?;
+        writeln!(out, "\tHelper()")?;
+        writeln!(out, "\tErrorf(format string, args ...any)")?;
+        writeln!(out, "}}) {{")?;
+        writeln!(out, "\tt.Helper()")?;
+        writeln!(out, "\tfor _, h := range LiveHandles() {{")?;
+        writeln!(
+            out,
+            "\t\tt.Errorf(\"%s handle still open after %s\\n%s\", h.Type, h.Age(), h.Stack)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// The cleanup strategy for a resource, honouring per-resource overrides.
+    fn resource_cleanup(&self, resource_id: TypeId) -> ResourceCleanup {
+        let resource_id = witffi_core::dealias(self.resolve, resource_id);
+        self.resolve.types[resource_id]
+            .name
+            .as_deref()
+            .and_then(|name| self.config.resource_cleanup_overrides.get(name))
+            .copied()
+            .unwrap_or(self.config.resource_cleanup)
+    }
+
+    /// The Go type name for a resource (following aliases such as `use`d resources).
+    fn resource_go_name(&self, resource_id: TypeId) -> String {
+        let resource_id = witffi_core::dealias(self.resolve, resource_id);
+        let name = self.resolve.types[resource_id]
+            .name
+            .as_deref()
+            .unwrap_or("anonymous");
+        self.config.naming.to_go_type(name)
+    }
+
+    /// The Go method receiver name for a resource, e.g. `c` for `Counter`.
+    fn resource_receiver(&self, resource_id: TypeId) -> String {
+        self.resource_go_name(resource_id)
+            .chars()
+            .next()
+            .map(|c| c.to_ascii_lowercase().to_string())
+            .unwrap_or_else(|| "r".to_string())
+    }
+
+    // ---- Conversion functions ----
+
+    fn generate_conversion_functions(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// ---- Conversion Functions ----")?;
+
+        let reachable = self.collect_reachable_types();
+        let nested = witffi_core::nested_result_shapes(self.resolve, self.world_id, &reachable);
+        let columnar = self.columnar_records();
+        self.write_each(out, &reachable, |out, type_id| {
+            let typedef = &self.resolve.types[*type_id];
+            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+
+            match &typedef.kind {
+                TypeDefKind::Record(record) => {
+                    self.generate_record_conversion(out, wit_name, record)?;
+                    if columnar.contains(type_id) {
+                        self.generate_columns_conversion(out, wit_name, record)?;
+                    }
+                }
+                TypeDefKind::Variant(variant) => {
+                    self.generate_variant_conversion(out, wit_name, variant)?;
+                }
+                TypeDefKind::Tuple(tuple) => {
+                    self.generate_tuple_conversion(out, *type_id, tuple)?;
+                }
+                TypeDefKind::FixedLengthList(elem, len) => {
+                    self.generate_array_conversion(out, *type_id, elem, *len)?;
+                }
+                TypeDefKind::List(elem) if *elem != Type::U8 => {
+                    self.generate_list_conversion(out, *type_id, elem)?;
+                }
+                TypeDefKind::Option(inner) => {
+                    self.generate_option_conversion(out, *type_id, inner)?;
+                }
+                TypeDefKind::Result(result) if self.is_nested_result(*type_id, &nested) => {
+                    self.generate_result_conversion(out, *type_id, result)?;
+                }
+                TypeDefKind::Enum(_) => {
+                    self.generate_enum_lowering(out, wit_name)?;
+                }
+                _ => {
+                    // Other type kinds don't need conversion functions
+                }
+            }
+            Ok(())
+        })?;
+
+        self.generate_lowering_functions(out)?;
+
+        Ok(())
+    }
+
+    /// Generate the lowering of an enum to C, which panics on values naming
+    /// none of its cases rather than handing them to Rust.
+    fn generate_enum_lowering(&self, out: &mut String, wit_name: &str) -> std::fmt::Result {
+        let go_name = self.config.naming.to_go_type(wit_name);
+        let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+        let names_var = format!("{}Names", names::to_go_ident(wit_name));
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// lower{go_name} converts e to C, panicking if it is not a case of {go_name}."
+        )?;
+        writeln!(out, "func lower{go_name}(e {go_name}) C.{c_name} {{")?;
+        writeln!(out, "\tif int(e) >= len({names_var}) {{")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Sprintf(\"invalid {go_name}: %d\", uint32(e)))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn C.{c_name}(e)")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// The call lowering `v` to C if `ty` is an enum, checking its range.
+    fn lower_enum_expr(&self, ty: &Type, v: &str) -> Option<String> {
+        let Type::Id(id) = self.resolve_to_leaf(ty) else {
+            return None;
+        };
+        let typedef = &self.resolve.types[*id];
+        let TypeDefKind::Enum(_) = typedef.kind else {
+            return None;
+        };
+        let name = typedef.name.as_deref().unwrap_or("anonymous");
+        Some(format!("lower{}({v})", self.config.naming.to_go_type(name)))
+    }
+
+    fn generate_record_conversion(
+        &self,
+        out: &mut String,
+        wit_name: &str,
+        record: &wit_parser::Record,
+    ) -> std::fmt::Result {
+        let go_name = self.config.naming.to_go_type(wit_name);
+        let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+
+        writeln!(out)?;
+        writeln!(out, "func convert{go_name}(ffi C.{c_name}) {go_name} {{")?;
+
+        // Separate fields into required (set in struct literal) and optional
+        // (set after construction)
+        let mut required_fields = Vec::new();
+        let mut optional_fields = Vec::new();
+
+        for field in &record.fields {
+            if self.is_option_type(&field.ty) {
+                optional_fields.push(field);
+            } else {
+                required_fields.push(field);
+            }
+        }
+
+        // Build the struct literal with required fields
+        writeln!(out, "\tresult := {go_name}{{")?;
+        for field in &required_fields {
+            let go_field = self.config.naming.to_go_field(&field.name);
+            let c_field = names::to_rust_ident(&field.name);
+            let mut conversion = self.convert_ffi_to_go(&field.ty, &format!("ffi.{c_field}"));
+            if let Some(mapped) = self.field_conversion(wit_name, field) {
+                conversion = format!("{}({conversion})", mapped.lift);
+            }
+            writeln!(out, "\t\t{go_field}: {conversion},")?;
+        }
+        writeln!(out, "\t}}")?;
+
+        // Handle optional fields
+        for field in &optional_fields {
+            let go_field = self.config.naming.to_go_field(&field.name);
+            let c_field = names::to_rust_ident(&field.name);
+            self.generate_optional_field_conversion(out, &go_field, &c_field, &field.ty)?;
+        }
+
+        writeln!(out, "\treturn result")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate `convert{Record}Columns`, which gathers the columns of a
+    /// `list<record>` transferred column by column into a Go slice and
+    /// frees them.
+    fn generate_columns_conversion(
+        &self,
+        out: &mut String,
+        wit_name: &str,
+        record: &wit_parser::Record,
+    ) -> std::fmt::Result {
+        let go_name = self.config.naming.to_go_type(wit_name);
+        let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+        let free_name =
+            names::to_c_func(&self.config.c_prefix, &format!("free-{wit_name}-columns"));
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "func convert{go_name}Columns(ffi C.{c_name}Columns) []{go_name} {{"
+        )?;
+        writeln!(out, "\tresult := make([]{go_name}, int(ffi.len))")?;
+        writeln!(out, "\tif len(result) > 0 {{")?;
+        for field in &record.fields {
+            let c_field = names::to_rust_ident(&field.name);
+            let column = names::to_go_ident(&format!("{}-column", field.name));
+            writeln!(
+                out,
+                "\t\t{column} := unsafe.Slice(ffi.{c_field}, len(result))"
+            )?;
+        }
+        writeln!(out, "\t\tfor i := range result {{")?;
+        writeln!(out, "\t\t\tresult[i] = {go_name}{{")?;
+        for field in &record.fields {
+            let go_field = self.config.naming.to_go_field(&field.name);
+            let column = names::to_go_ident(&format!("{}-column", field.name));
+            let mut conversion = self.convert_ffi_to_go(&field.ty, &format!("{column}[i]"));
+            if let Some(mapped) = self.field_conversion(wit_name, field) {
+                conversion = format!("{}({conversion})", mapped.lift);
+            }
+            writeln!(out, "\t\t\t\t{go_field}: {conversion},")?;
+        }
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tC.{free_name}(ffi)")?;
+        writeln!(out, "\treturn result")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    fn generate_tuple_conversion(
+        &self,
+        out: &mut String,
+        type_id: TypeId,
+        tuple: &wit_parser::Tuple,
+    ) -> std::fmt::Result {
+        let shape = witffi_core::tuple_shape_name(self.resolve, tuple);
+        let go_ty = self.type_to_go_structural(&Type::Id(type_id));
+        let c_name = format!("{}{shape}", self.config.c_type_prefix);
+
+        writeln!(out)?;
+        writeln!(out, "func convert{shape}(ffi C.{c_name}) {go_ty} {{")?;
+        writeln!(out, "\tresult := {go_ty}{{")?;
+        for (i, ty) in tuple.types.iter().enumerate() {
+            if !self.is_option_type(ty) {
+                let conversion = self.convert_ffi_to_go(ty, &format!("ffi.f{i}"));
+                writeln!(out, "\t\tF{i}: {conversion},")?;
+            }
+        }
+        writeln!(out, "\t}}")?;
+        for (i, ty) in tuple.types.iter().enumerate() {
+            if self.is_option_type(ty) {
+                self.generate_optional_field_conversion(
+                    out,
+                    &format!("F{i}"),
+                    &format!("f{i}"),
+                    ty,
+                )?;
+            }
+        }
+        writeln!(out, "\treturn result")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the conversion from a fixed-length list's inline C array to
+    /// a Go array.
+    fn generate_array_conversion(
+        &self,
+        out: &mut String,
+        type_id: TypeId,
+        elem: &Type,
+        len: u32,
+    ) -> std::fmt::Result {
+        let shape = witffi_core::fixed_list_shape_name(self.resolve, elem, len);
+        let go_ty = self.type_to_go_structural(&Type::Id(type_id));
+        let c_name = format!("{}{shape}", self.config.c_type_prefix);
+        let conversion = self.convert_ffi_to_go(elem, "ffi.elems[i]");
+
+        writeln!(out)?;
+        writeln!(out, "func convert{shape}(ffi C.{c_name}) {go_ty} {{")?;
+        writeln!(out, "\tvar result {go_ty}")?;
+        writeln!(out, "\tfor i := range result {{")?;
+        writeln!(out, "\t\tresult[i] = {conversion}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn result")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the conversion from a callee-allocated C list to a Go slice.
+    /// The list is freed once its elements have been converted.
+    fn generate_list_conversion(
+        &self,
+        out: &mut String,
+        type_id: TypeId,
+        elem: &Type,
+    ) -> std::fmt::Result {
+        let shape = self.shape_name(type_id);
+        let go_ty = self.type_to_go_structural(&Type::Id(type_id));
+        let c_name = format!("{}{shape}", self.config.c_type_prefix);
+        let conversion = self.convert_ffi_to_go(elem, "e");
+
+        writeln!(out)?;
+        writeln!(out, "func convert{shape}(ffi C.{c_name}) {go_ty} {{")?;
+        writeln!(out, "\telems := unsafe.Slice(ffi.ptr, ffi.len)")?;
+        writeln!(out, "\tresult := make({go_ty}, len(elems))")?;
+        writeln!(out, "\tfor i, e := range elems {{")?;
+        writeln!(out, "\t\tresult[i] = {conversion}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tC.{}_free_{}(ffi)",
+            self.c_func_prefix(),
+            shape.to_snake_case()
+        )?;
+        writeln!(out, "\treturn result")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the conversion from a nullable C pointer to a Go optional,
+    /// used where options are nested inside lists, options and results.
+    fn generate_option_conversion(
+        &self,
+        out: &mut String,
+        type_id: TypeId,
+        inner: &Type,
+    ) -> std::fmt::Result {
+        let shape = self.shape_name(type_id);
+        let go_ty = self.type_to_go_structural(&Type::Id(type_id));
+        let cgo_ty = self.type_to_cgo(&Type::Id(type_id));
+        let conversion = self.convert_ffi_to_go(inner, "*ffi");
+
+        writeln!(out)?;
+        writeln!(out, "func convert{shape}(ffi {cgo_ty}) {go_ty} {{")?;
+        writeln!(out, "\tif ffi == nil {{")?;
+        writeln!(out, "\t\treturn {}", self.none_expr(inner))?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tv := {conversion}")?;
+        writeln!(out, "\t{}", self.free_rust_box("ffi"))?;
+        writeln!(out, "\treturn {}", self.some_expr("v"))?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the conversion from a nested C result struct to `Result[T, E]`,
+    /// freeing whichever side was boxed.
+    fn generate_result_conversion(
+        &self,
+        out: &mut String,
+        type_id: TypeId,
+        result: &wit_parser::Result_,
+    ) -> std::fmt::Result {
+        let shape = self.shape_name(type_id);
+        let go_ty = self.type_to_go_structural(&Type::Id(type_id));
+        let c_name = format!("{}{shape}", self.config.c_type_prefix);
+
+        writeln!(out)?;
+        writeln!(out, "func convert{shape}(ffi C.{c_name}) {go_ty} {{")?;
+        writeln!(out, "\tresult := {go_ty}{{IsOk: bool(ffi.is_ok)}}")?;
+        for (go_field, c_field, ty) in [("Ok", "ok", &result.ok), ("Err", "err", &result.err)] {
+            let Some(ty) = ty else { continue };
+            let conversion = self.convert_ffi_to_go(ty, &format!("*ffi.{c_field}"));
+            writeln!(out, "\tif ffi.{c_field} != nil {{")?;
+            writeln!(out, "\t\tresult.{go_field} = {conversion}")?;
+            writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+            writeln!(out, "\t}}")?;
+        }
+        writeln!(out, "\treturn result")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    fn generate_variant_conversion(
+        &self,
+        out: &mut String,
+        wit_name: &str,
+        variant: &wit_parser::Variant,
+    ) -> std::fmt::Result {
+        let go_name = self.config.naming.to_go_type(wit_name);
+        let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+
+        writeln!(out)?;
+        writeln!(out, "func convert{go_name}(ffi C.{c_name}) {go_name} {{")?;
+        writeln!(out, "\tswitch ffi.tag {{")?;
+
+        for case in &variant.cases {
+            let c_tag = names::to_c_enum_variant(
+                &names::to_c_type(&self.config.c_type_prefix, wit_name),
+                &case.name,
+            );
+            let case_type_name =
+                format!("{}{}", go_name, self.config.naming.to_go_type(&case.name));
+            let c_field = names::to_rust_ident(&case.name);
+
+            writeln!(out, "\tcase C.{c_tag}:")?;
+            if let Some(fields) = self.payload_fields(&case.ty) {
+                let fields: Vec<String> = fields
+                    .iter()
+                    .enumerate()
+                    .map(|(i, field_ty)| {
+                        let access = format!("ffi.{c_field}.f{i}");
+                        format!("F{i}: {}", self.convert_ffi_to_go(field_ty, &access))
+                    })
+                    .collect();
+                writeln!(out, "\t\treturn {case_type_name}{{{}}}", fields.join(", "))?;
+            } else if let Some(ty) = &case.ty {
+                let payload = self.convert_variant_payload(ty, &c_field);
+                writeln!(out, "\t\treturn {case_type_name}{{Value: {payload}}}")?;
+            } else {
+                writeln!(out, "\t\treturn {case_type_name}{{}}")?;
+            }
+        }
+
+        writeln!(out, "\tdefault:")?;
+        writeln!(
+            out,
+            "\t\tpanic(fmt.Sprintf(\"unknown {go_name} tag: %d\", ffi.tag))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// The fields of a case payload that is an inline tuple, which the case
+    /// struct holds directly as `F0..FN` instead of a `Value`.
+    fn payload_fields(&self, ty: &Option<Type>) -> Option<&[Type]> {
+        witffi_core::payload_fields(self.resolve, ty.as_ref()?)
+    }
+
+    /// Generate a Go expression to convert a variant case payload.
+    ///
+    /// Payloads are boxed on the C side, so nested variants, records and
+    /// tuples convert recursively through their own conversion functions.
+    fn convert_variant_payload(&self, ty: &Type, c_field: &str) -> String {
+        self.convert_ffi_to_go(ty, &format!("ffi.{c_field}.value"))
+    }
+
+    /// Generate a Go expression to convert an FFI value to a Go value.
+    fn convert_ffi_to_go(&self, ty: &Type, access: &str) -> String {
+        match self.mapped_conversion(ty) {
+            Some(mapped) if mapped.bare => {
+                format!("{}({})", mapped.lift, self.convert_structural(ty, access))
+            }
+            Some(mapped) => {
+                let inner = self.convert_ffi_to_go(&mapped.inner, access);
+                format!("{}({inner})", mapped.lift)
+            }
+            None => self.convert_structural(ty, access),
+        }
+    }
+
+    /// Convert an FFI value by the structure of its type, ignoring any type
+    /// mapping on the type itself.
+    fn convert_structural(&self, ty: &Type, access: &str) -> String {
+        match ty {
+            Type::Bool => format!("bool({access})"),
+            Type::U8
+            | Type::U16
+            | Type::U32
+            | Type::U64
+            | Type::S8
+            | Type::S16
+            | Type::S32
+            | Type::S64
+            | Type::F32
+            | Type::F64 => {
+                let go_ty = self.type_to_go(ty);
+                format!("{go_ty}({access})")
+            }
+            Type::Char => format!("mustFfiCharToRune({access})"),
+            Type::String => format!("ffiByteBufferToString({access})"),
+            Type::Id(_) if self.is_big_int(ty) => {
+                let signed = self.wide_int(ty).is_some_and(WideInt::is_signed);
+                format!("ffiWideToBigInt({access}, {signed})")
+            }
+            Type::Id(_) if self.wide_int(ty).is_some() => format!("ffiWideToBytes({access})"),
+            Type::Id(_) if self.map_entry(ty).is_some() => format!(
+                "ffiPairsToMap(convert{}({access}))",
+                self.shape_name(self.map_list_id(ty))
+            ),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => {
+                        format!("ffiByteBufferToBytes({access})")
+                    }
+                    TypeDefKind::List(_) | TypeDefKind::Option(_) | TypeDefKind::Result(_) => {
+                        format!("convert{}({access})", self.shape_name(*id))
+                    }
+                    TypeDefKind::Type(aliased) if self.is_defined_alias(ty) => {
+                        let inner = self.convert_ffi_to_go(aliased, access);
+                        format!("{}({inner})", self.type_to_go(ty))
+                    }
+                    TypeDefKind::Type(aliased) => self.convert_ffi_to_go(aliased, access),
+                    TypeDefKind::Enum(_) | TypeDefKind::Flags(_) => {
+                        let name = typedef.name.as_deref().unwrap_or("anonymous");
+                        format!("{}({access})", self.config.naming.to_go_type(name))
+                    }
+                    TypeDefKind::Tuple(tuple) => format!(
+                        "convert{}({access})",
+                        witffi_core::tuple_shape_name(self.resolve, tuple)
+                    ),
+                    TypeDefKind::FixedLengthList(inner, len) => format!(
+                        "convert{}({access})",
+                        witffi_core::fixed_list_shape_name(self.resolve, inner, *len)
+                    ),
+                    TypeDefKind::Handle(Handle::Own(resource_id)) => {
+                        format!("wrap{}({access})", self.resource_go_name(*resource_id))
+                    }
+                    TypeDefKind::Handle(Handle::Borrow(resource_id)) => {
+                        format!(
+                            "{}Ref{{handle: {access}}}",
+                            self.resource_go_name(*resource_id)
+                        )
+                    }
+                    _ => {
+                        let name = typedef.name.as_deref().unwrap_or("anonymous");
+                        let go_name = self.config.naming.to_go_type(name);
+                        format!("convert{go_name}({access})")
+                    }
+                }
+            }
+            Type::ErrorContext => format!("liftErrorContext({access})"),
+        }
+    }
+
+    /// Generate code for converting an optional (nullable pointer) FFI field.
+    fn generate_optional_field_conversion(
+        &self,
+        out: &mut String,
+        go_field: &str,
+        c_field: &str,
+        ty: &Type,
+    ) -> std::fmt::Result {
+        // Unwrap the Option layer to get the inner type
+        let inner_ty = self.unwrap_option(ty);
+
+        writeln!(out, "\tif ffi.{c_field} != nil {{")?;
+
+        match inner_ty {
+            Type::Bool
+            | Type::U8
+            | Type::U16
+            | Type::U32
+            | Type::U64
+            | Type::S8
+            | Type::S16
+            | Type::S32
+            | Type::S64
+            | Type::F32
+            | Type::F64 => {
+                let go_ty = self.type_to_go(inner_ty);
+                writeln!(out, "\t\tv := {go_ty}(*ffi.{c_field})")?;
+                writeln!(out, "\t\tresult.{go_field} = {}", self.some_expr("v"))?;
+                writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+            }
+            Type::String => {
+                writeln!(out, "\t\tv := ffiByteBufferToString(*ffi.{c_field})")?;
+                writeln!(out, "\t\tresult.{go_field} = {}", self.some_expr("v"))?;
+                writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+            }
+            Type::Char => {
+                writeln!(out, "\t\tv := mustFfiCharToRune(*ffi.{c_field})")?;
+                writeln!(out, "\t\tresult.{go_field} = {}", self.some_expr("v"))?;
+                writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+            }
+            Type::Id(_)
+                if self.wide_int(inner_ty).is_some()
+                    || self.map_entry(inner_ty).is_some()
+                    || self.mapped_conversion(inner_ty).is_some()
+                    || self.is_defined_alias(inner_ty) =>
+            {
+                let conversion = self.convert_ffi_to_go(inner_ty, &format!("*ffi.{c_field}"));
+                writeln!(out, "\t\tv := {conversion}")?;
+                writeln!(out, "\t\tresult.{go_field} = {}", self.some_expr("v"))?;
+                writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+            }
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => {
+                        writeln!(out, "\t\tv := ffiByteBufferToBytes(*ffi.{c_field})")?;
+                        writeln!(out, "\t\tresult.{go_field} = {}", self.some_expr("v"))?;
+                        writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+                    }
+                    TypeDefKind::Type(aliased) => {
+                        // Follow the alias and recurse
+                        return self
+                            .generate_optional_field_conversion(out, go_field, c_field, aliased);
+                    }
+                    TypeDefKind::FixedLengthList(..)
+                    | TypeDefKind::List(_)
+                    | TypeDefKind::Option(_)
+                    | TypeDefKind::Result(_) => {
+                        let conversion =
+                            self.convert_ffi_to_go(inner_ty, &format!("*ffi.{c_field}"));
+                        writeln!(out, "\t\tv := {conversion}")?;
+                        writeln!(out, "\t\tresult.{go_field} = {}", self.some_expr("v"))?;
+                        writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+                    }
+                    _ => {
+                        let name = typedef.name.as_deref().unwrap_or("anonymous");
+                        let go_name = self.config.naming.to_go_type(name);
+                        writeln!(out, "\t\tv := convert{go_name}(*ffi.{c_field})")?;
+                        writeln!(out, "\t\tresult.{go_field} = {}", self.some_expr("v"))?;
+                        writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+                    }
+                }
+            }
+            _ => {
+                writeln!(out, "\t\tv := *ffi.{c_field}")?;
+                writeln!(out, "\t\tresult.{go_field} = {}", self.some_expr("v"))?;
+                writeln!(out, "\t\t{}", self.free_rust_box(&format!("ffi.{c_field}")))?;
+            }
+        }
+
+        writeln!(out, "\t}}")?;
+
+        Ok(())
+    }
+
+    /// A Go expression wrapping the present value `v` of an optional.
+    fn some_expr(&self, v: &str) -> String {
+        if self.config.generic_options {
+            format!("Some({v})")
+        } else {
+            format!("&{v}")
+        }
+    }
+
+    /// A Go expression for an absent optional with inner type `inner`.
+    fn none_expr(&self, inner: &Type) -> String {
+        if self.config.generic_options {
+            format!("None[{}]()", self.type_to_go(inner))
+        } else {
+            "nil".to_string()
+        }
+    }
+
+    /// Check if a type is `option<T>`.
+    fn is_option_type(&self, ty: &Type) -> bool {
+        if let Type::Id(id) = ty {
+            let typedef = &self.resolve.types[*id];
+            match &typedef.kind {
+                TypeDefKind::Option(_) => true,
+                TypeDefKind::Type(aliased) => self.is_option_type(aliased),
+                _ => false,
+            }
+        } else {
+            false
+        }
+    }
+
+    /// Unwrap an `option<T>` to get the inner type `T`.
+    /// If the type is not an option, returns the type itself.
+    fn unwrap_option<'b>(&'b self, ty: &'b Type) -> &'b Type {
+        if let Type::Id(id) = ty {
+            let typedef = &self.resolve.types[*id];
+            match &typedef.kind {
+                TypeDefKind::Option(inner) => inner,
+                TypeDefKind::Type(aliased) => self.unwrap_option(aliased),
+                _ => ty,
+            }
+        } else {
+            ty
+        }
+    }
+
+    /// Check if a type is a resource handle (`own<T>` or `borrow<T>`).
+    fn is_handle(&self, ty: &Type) -> bool {
+        match self.resolve_to_leaf(ty) {
+            Type::Id(id) => matches!(self.resolve.types[*id].kind, TypeDefKind::Handle(_)),
+            _ => false,
+        }
+    }
+
+    /// The resource behind an owned resource handle (`own<T>`), if `ty` is one.
+    fn own_handle_resource(&self, ty: &Type) -> Option<TypeId> {
+        match self.resolve_to_leaf(ty) {
+            Type::Id(id) => match self.resolve.types[*id].kind {
+                TypeDefKind::Handle(Handle::Own(resource_id)) => Some(resource_id),
+                _ => None,
+            },
+            _ => None,
+        }
+    }
+
+    // ---- Lowering functions ----
+
+    /// The lists and options nested in lists that some exported function
+    /// takes as a parameter, keyed by shape name.
+    fn lowered_shapes(&self) -> Vec<(String, TypeId)> {
+        let mut shapes = Vec::new();
+        for ef in self.exported_functions() {
+            for p in &ef.function.params {
+                self.collect_lowered_shapes(&p.ty, false, &mut shapes);
+            }
+        }
+        shapes
+    }
+
+    fn collect_lowered_shapes(&self, ty: &Type, nested: bool, shapes: &mut Vec<(String, TypeId)>) {
+        if self.wide_int(ty).is_some() {
+            return;
+        }
+        // Maps are lowered as the list behind them, which is the mapped
+        // type itself unless it is an alias
+        if self.map_entry(ty).is_some() && *ty != Type::Id(self.map_list_id(ty)) {
+            return self.collect_lowered_shapes(&Type::Id(self.map_list_id(ty)), nested, shapes);
+        }
+        let Type::Id(id) = ty else { return };
+        let add = |shapes: &mut Vec<(String, TypeId)>| {
+            let shape = self.shape_name(*id);
+            if !shapes.iter().any(|(s, _)| *s == shape) {
+                shapes.push((shape, *id));
+            }
+        };
+        match &self.resolve.types[*id].kind {
+            TypeDefKind::List(Type::U8) => {}
+            TypeDefKind::List(elem) => {
+                add(shapes);
+                self.collect_lowered_shapes(elem, true, shapes);
+            }
+            TypeDefKind::Option(inner) => {
+                // Top-level options are marshaled inline
+                if nested {
+                    add(shapes);
+                }
+                self.collect_lowered_shapes(inner, true, shapes);
+            }
+            TypeDefKind::Tuple(tuple) => {
+                // Top-level tuples are flattened into their elements
+                if nested {
+                    add(shapes);
+                }
+                for elem in &tuple.types {
+                    self.collect_lowered_shapes(elem, nested, shapes);
+                }
+            }
+            TypeDefKind::FixedLengthList(elem, _) | TypeDefKind::Type(elem) => {
+                self.collect_lowered_shapes(elem, nested, shapes);
+            }
+            _ => {}
+        }
+    }
+
+    /// A Go expression lowering the list element or option value `v` to its
+    /// borrowed C representation, or `None` if such elements can't be lowered.
+    fn lower_elem_expr(&self, ty: &Type, v: &str) -> Option<String> {
+        match self.mapped_conversion(ty) {
+            Some(mapped) if mapped.bare => {
+                self.lower_elem_structural(ty, &format!("{}({v})", mapped.lower))
+            }
+            Some(mapped) => self.lower_elem_expr(&mapped.inner, &format!("{}({v})", mapped.lower)),
+            None => self.lower_elem_structural(ty, v),
+        }
+    }
+
+    /// Lower a Go element by the structure of its type, ignoring any type
+    /// mapping on the type itself.
+    fn lower_elem_structural(&self, ty: &Type, v: &str) -> Option<String> {
+        if let Some(lower) = self.wide_lower_func(ty) {
+            return Some(format!("{lower}({v})"));
+        }
+        if self.map_entry(ty).is_some() {
+            let shape = self.shape_name(self.map_list_id(ty));
+            return Some(format!("lower{shape}(ffiMapToPairs({v}), allocs)"));
+        }
+        match ty {
+            Type::String => Some(format!("allocs.bytes([]byte({v}))")),
+            Type::Char => Some(format!("lowerRune({v})")),
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::List(Type::U8) if self.config.pin_bytes => {
+                    Some(format!("allocs.pin({v})"))
+                }
+                TypeDefKind::List(Type::U8) => Some(format!("allocs.bytes({v})")),
+                TypeDefKind::List(_) | TypeDefKind::Option(_) | TypeDefKind::Tuple(_) => {
+                    Some(format!("lower{}({v}, allocs)", self.shape_name(*id)))
+                }
+                TypeDefKind::Type(aliased) => self.lower_elem_expr(aliased, v),
+                TypeDefKind::Enum(_) => self.lower_enum_expr(ty, v),
+                TypeDefKind::Flags(_) => Some(format!("{}({v})", self.type_to_cgo(ty))),
+                _ => None,
+            },
+            Type::ErrorContext => Some(format!(
+                "C.FfiErrorContextInput{{id: C.uint64_t({v}.ID()), debug_message: allocs.bytes([]byte({v}.DebugMessage()))}}"
+            )),
+            _ => Some(format!("{}({v})", self.type_to_cgo(ty))),
+        }
+    }
+
+    /// Whether an optional parameter of type `ty` holds bytes passed to Rust
+    /// pinned in place (see `GoConfig::pin_bytes`).
+    fn pinned_option(&self, ty: &Type) -> bool {
+        if !self.config.pin_bytes || !self.is_option_type(ty) {
+            return false;
+        }
+        let inner = self
+            .mapped_conversion(self.unwrap_option(ty))
+            .map_or(*self.unwrap_option(ty), |m| m.inner);
+        matches!(
+            self.resolve_to_leaf(&inner),
+            Type::Id(id) if matches!(self.resolve.types[*id].kind, TypeDefKind::List(Type::U8))
+        )
+    }
+
+    /// Whether an optional parameter of type `ty` is lowered through
+    /// `cAllocs`, which is only otherwise used for lists.
+    fn option_uses_allocs(&self, ty: &Type) -> bool {
+        if self.pinned_option(ty) {
+            return true;
+        }
+        if self.config.arena_chunk_size.is_none() || !self.is_option_type(ty) {
+            return false;
+        }
+        let inner = self
+            .mapped_conversion(self.unwrap_option(ty))
+            .map_or(*self.unwrap_option(ty), |m| m.inner);
+        self.param_needs_marshaling(&inner) || *self.resolve_to_leaf(&inner) == Type::ErrorContext
+    }
+
+    /// Whether any exported function lowers its arguments through `cAllocs`.
+    fn uses_c_allocs(&self) -> bool {
+        !self.lowered_shapes().is_empty()
+            || self.exported_functions().iter().any(|ef| {
+                let mut flat_params = Vec::new();
+                for p in &ef.function.params {
+                    self.flatten_param("p", "p", &p.ty, &mut flat_params);
+                }
+                flat_params
+                    .iter()
+                    .any(|(_, _, ty)| self.option_uses_allocs(ty))
+            })
+    }
+
+    /// Generate `cAllocs`, which tracks the C memory and pins of one call.
+    ///
+    /// Each allocation is its own `malloc` unless `arena_chunk_size` is set,
+    /// in which case they are carved out of chunks of that size, so a call
+    /// usually frees a single chunk.
+    fn generate_c_allocs(&self, out: &mut String) -> std::fmt::Result {
+        let pin = self.config.pin_bytes;
+        let arena = self.config.arena_chunk_size;
+        // The tracked allocations, as a slice expression
+        let (ptrs, ptrs_index) = match (arena.is_some(), pin) {
+            (true, _) => ("a.chunks", "a.chunks"),
+            (false, true) => ("a.ptrs", "a.ptrs"),
+            (false, false) => ("*a", "(*a)"),
+        };
+
+        writeln!(out)?;
+        if arena.is_some() {
+            writeln!(
+                out,
+                "// cAllocs stages arguments for one call in chunks of C memory, which are all"
+            )?;
+            if pin {
+                writeln!(
+                    out,
+                    "// freed once the call returns, and pins Go byte slices until then."
+                )?;
+            } else {
+                writeln!(out, "// freed once the call returns.")?;
+            }
+            writeln!(out, "type cAllocs struct {{")?;
+            writeln!(out, "\tchunks []unsafe.Pointer")?;
+            writeln!(out, "\tnext   unsafe.Pointer")?;
+            writeln!(out, "\tleft   uintptr")?;
+            if pin {
+                writeln!(out, "\tpinner runtime.Pinner")?;
+            }
+            writeln!(out, "}}")?;
+        } else {
+            writeln!(
+                out,
+                "// cAllocs tracks C memory allocated while lowering arguments, which is"
+            )?;
+            if pin {
+                writeln!(
+                    out,
+                    "// released once the call returns, and the Go byte slices pinned until then."
+                )?;
+                writeln!(out, "type cAllocs struct {{")?;
+                writeln!(out, "\tptrs   []unsafe.Pointer")?;
+                writeln!(out, "\tpinner runtime.Pinner")?;
+                writeln!(out, "}}")?;
+            } else {
+                writeln!(out, "// released once the call returns.")?;
+                writeln!(out, "type cAllocs []unsafe.Pointer")?;
+            }
+        }
+        writeln!(out)?;
+        if self.config.buffer_pool {
+            writeln!(
+                out,
+                "// allocsPool recycles the lists tracking C allocations between calls."
+            )?;
+            writeln!(
+                out,
+                "var allocsPool = sync.Pool{{New: func() any {{ return new(cAllocs) }}}}"
+            )?;
+            writeln!(out)?;
+            writeln!(out, "func newCAllocs() *cAllocs {{")?;
+            writeln!(out, "\treturn allocsPool.Get().(*cAllocs)")?;
+            writeln!(out, "}}")?;
+        } else {
+            writeln!(out, "func newCAllocs() *cAllocs {{")?;
+            writeln!(out, "\treturn new(cAllocs)")?;
+            writeln!(out, "}}")?;
+        }
+        writeln!(out)?;
+        if let Some(chunk_size) = arena {
+            self.generate_arena_stats(out, chunk_size)?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "func (a *cAllocs) malloc(size uintptr) unsafe.Pointer {{"
+            )?;
+            writeln!(out, "\t// Keep every allocation aligned for any C type")?;
+            writeln!(out, "\tsize = (size + 15) &^ 15")?;
+            writeln!(out, "\tif size > a.left {{")?;
+            writeln!(out, "\t\tn := uintptr(arenaChunkSize)")?;
+            writeln!(out, "\t\tif size > n {{")?;
+            writeln!(out, "\t\t\tn = size")?;
+            writeln!(out, "\t\t}}")?;
+            writeln!(out, "\t\ta.next = C.malloc(C.size_t(n))")?;
+            writeln!(out, "\t\ta.chunks = append(a.chunks, a.next)")?;
+            writeln!(out, "\t\ta.left = n")?;
+            writeln!(out, "\t\tarenaChunks.Add(1)")?;
+            writeln!(out, "\t\tarenaChunkBytes.Add(uint64(n))")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\tp := a.next")?;
+            writeln!(out, "\ta.next = unsafe.Add(a.next, size)")?;
+            writeln!(out, "\ta.left -= size")?;
+            writeln!(out, "\tarenaStagedBytes.Add(uint64(size))")?;
+            writeln!(out, "\treturn p")?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(out, "func (a *cAllocs) bytes(b []byte) C.FfiByteSlice {{")?;
+            writeln!(out, "\tif len(b) == 0 {{")?;
+            writeln!(out, "\t\treturn C.FfiByteSlice{{}}")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\tp := a.malloc(uintptr(len(b)))")?;
+            writeln!(out, "\tcopy(unsafe.Slice((*byte)(p), len(b)), b)")?;
+        } else {
+            writeln!(
+                out,
+                "func (a *cAllocs) malloc(size uintptr) unsafe.Pointer {{"
+            )?;
+            writeln!(out, "\tp := C.malloc(C.size_t(size))")?;
+            writeln!(out, "\t{ptrs} = append({ptrs}, p)")?;
+            writeln!(out, "\treturn p")?;
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(out, "func (a *cAllocs) bytes(b []byte) C.FfiByteSlice {{")?;
+            writeln!(out, "\tp := C.CBytes(b)")?;
+            writeln!(out, "\t{ptrs} = append({ptrs}, p)")?;
+        }
+        writeln!(
+            out,
+            "\treturn C.FfiByteSlice{{ptr: (*C.uint8_t)(p), len: C.uintptr_t(len(b))}}"
+        )?;
+        writeln!(out, "}}")?;
+        if pin {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// pin passes b to Rust without copying it, pinned until the call returns."
+            )?;
+            writeln!(out, "func (a *cAllocs) pin(b []byte) C.FfiByteSlice {{")?;
+            writeln!(out, "\tif len(b) == 0 {{")?;
+            writeln!(out, "\t\treturn C.FfiByteSlice{{}}")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\tp := unsafe.SliceData(b)")?;
+            writeln!(out, "\ta.pinner.Pin(p)")?;
+            writeln!(
+                out,
+                "\treturn C.FfiByteSlice{{ptr: (*C.uint8_t)(unsafe.Pointer(p)), len: C.uintptr_t(len(b))}}"
+            )?;
+            writeln!(out, "}}")?;
+        }
+        writeln!(out)?;
+        writeln!(out, "func (a *cAllocs) free() {{")?;
+        if pin {
+            writeln!(out, "\ta.pinner.Unpin()")?;
+        }
+        if self.config.buffer_pool || arena.is_some() {
+            writeln!(out, "\tfor i, p := range {ptrs} {{")?;
+            writeln!(out, "\t\tC.free(p)")?;
+            writeln!(out, "\t\t{ptrs_index}[i] = nil")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\t{ptrs} = {ptrs_index}[:0]")?;
+        } else {
+            writeln!(out, "\tfor _, p := range {ptrs} {{")?;
+            writeln!(out, "\t\tC.free(p)")?;
+            writeln!(out, "\t}}")?;
+        }
+        if arena.is_some() {
+            writeln!(out, "\ta.next, a.left = nil, 0")?;
+            writeln!(out, "\tarenaCalls.Add(1)")?;
+        }
+        if self.config.buffer_pool {
+            writeln!(out, "\tallocsPool.Put(a)")?;
+        }
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the arena chunk size, the counters tracking arena use and
+    /// `ReadArenaStats`, which reports them.
+    fn generate_arena_stats(&self, out: &mut String, chunk_size: usize) -> std::fmt::Result {
+        writeln!(
+            out,
+            "// arenaChunkSize is the size of the chunks arguments are staged in; larger"
+        )?;
+        writeln!(out, "// arguments get a chunk of their own.")?;
+        writeln!(out, "const arenaChunkSize = {chunk_size}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "var arenaCalls, arenaChunks, arenaChunkBytes, arenaStagedBytes atomic.Uint64"
+        )?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ArenaStats reports how the arenas staging call arguments in C memory have"
+        )?;
+        writeln!(out, "// been used, for tuning their chunk size.")?;
+        writeln!(out, "type ArenaStats struct {{")?;
+        writeln!(
+            out,
+            "\t// Calls is the number of calls that staged arguments in an arena."
+        )?;
+        writeln!(out, "\tCalls uint64")?;
+        writeln!(
+            out,
+            "\t// Chunks is the number of chunks allocated. More chunks than calls means"
+        )?;
+        writeln!(out, "\t// arguments often outgrow a single chunk.")?;
+        writeln!(out, "\tChunks uint64")?;
+        writeln!(out, "\t// ChunkBytes is the total size of those chunks.")?;
+        writeln!(out, "\tChunkBytes uint64")?;
+        writeln!(
+            out,
+            "\t// StagedBytes is the total size of the arguments staged in them."
+        )?;
+        writeln!(out, "\tStagedBytes uint64")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ReadArenaStats returns the arena statistics gathered since the program started."
+        )?;
+        writeln!(out, "func ReadArenaStats() ArenaStats {{")?;
+        writeln!(out, "\treturn ArenaStats{{")?;
+        writeln!(out, "\t\tCalls:       arenaCalls.Load(),")?;
+        writeln!(out, "\t\tChunks:      arenaChunks.Load(),")?;
+        writeln!(out, "\t\tChunkBytes:  arenaChunkBytes.Load(),")?;
+        writeln!(out, "\t\tStagedBytes: arenaStagedBytes.Load(),")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the helpers lowering list (and nested option) parameters.
+    ///
+    /// cgo forbids passing Go memory that holds Go pointers, so elements are
+    /// copied into C memory tracked by `cAllocs` and released after the call.
+    /// With `pin_bytes`, byte slices are instead pinned where they are.
+    fn generate_lowering_functions(&self, out: &mut String) -> std::fmt::Result {
+        if !self.uses_c_allocs() {
+            return Ok(());
+        }
+        let shapes = self.lowered_shapes();
+        self.generate_c_allocs(out)?;
+
+        self.write_each(out, &shapes, |out, (shape, type_id)| {
+            let ty = Type::Id(*type_id);
+            let go_ty = self.type_to_go_structural(&ty);
+            let c_ty = self.type_to_cgo_input(&ty);
+            writeln!(out)?;
+            writeln!(
+                out,
+                "func lower{shape}(v {go_ty}, allocs *cAllocs) {c_ty} {{"
+            )?;
+            match &self.resolve.types[*type_id].kind {
+                TypeDefKind::List(elem) => {
+                    let elem_ty = self.type_to_cgo_input(elem);
+                    writeln!(out, "\tif len(v) == 0 {{")?;
+                    writeln!(out, "\t\treturn {c_ty}{{}}")?;
+                    writeln!(out, "\t}}")?;
+                    writeln!(out, "\tvar elem {elem_ty}")?;
+                    writeln!(
+                        out,
+                        "\tptr := (*{elem_ty})(allocs.malloc(unsafe.Sizeof(elem) * uintptr(len(v))))"
+                    )?;
+                    writeln!(out, "\telems := unsafe.Slice(ptr, len(v))")?;
+                    writeln!(out, "\tfor i, e := range v {{")?;
+                    match self.lower_elem_expr(elem, "e") {
+                        Some(lowered) => writeln!(out, "\t\telems[i] = {lowered}")?,
+                        None => {
+                            writeln!(out, "\t\t_, _ = i, e")?;
+                            writeln!(
+                                out,
+                                "\t\tpanic(\"lowering {} elements is not supported\")",
+                                self.type_to_go(elem)
+                            )?;
+                        }
+                    }
+                    writeln!(out, "\t}}")?;
+                    writeln!(out, "\treturn {c_ty}{{ptr: ptr, len: C.uintptr_t(len(v))}}")?;
+                }
+                TypeDefKind::Tuple(tuple) => {
+                    let lowered: Option<Vec<String>> = tuple
+                        .types
+                        .iter()
+                        .enumerate()
+                        .map(|(i, elem)| {
+                            self.lower_elem_expr(elem, &format!("v.F{i}"))
+                                .map(|e| format!("f{i}: {e}"))
+                        })
+                        .collect();
+                    match lowered {
+                        Some(fields) => writeln!(out, "\treturn {c_ty}{{{}}}", fields.join(", "))?,
+                        None => writeln!(
+                            out,
+                            "\tpanic(\"lowering {} values is not supported\")",
+                            go_ty
+                        )?,
+                    }
+                }
+                TypeDefKind::Option(inner) => {
+                    let inner_ty = self.type_to_cgo_input(inner);
+                    if self.config.generic_options {
+                        writeln!(out, "\tvalue, ok := v.Get()")?;
+                        writeln!(out, "\tif !ok {{")?;
+                        writeln!(out, "\t\treturn nil")?;
+                        writeln!(out, "\t}}")?;
+                    } else {
+                        writeln!(out, "\tif v == nil {{")?;
+                        writeln!(out, "\t\treturn nil")?;
+                        writeln!(out, "\t}}")?;
+                        writeln!(out, "\tvalue := *v")?;
+                    }
+                    match self.lower_elem_expr(inner, "value") {
+                        Some(lowered) => {
+                            writeln!(out, "\tvar elem {inner_ty}")?;
+                            writeln!(
+                                out,
+                                "\tptr := (*{inner_ty})(allocs.malloc(unsafe.Sizeof(elem)))"
+                            )?;
+                            writeln!(out, "\t*ptr = {lowered}")?;
+                            writeln!(out, "\treturn ptr")?;
+                        }
+                        None => {
+                            writeln!(out, "\t_ = value")?;
+                            writeln!(
+                                out,
+                                "\tpanic(\"lowering {} values is not supported\")",
+                                self.type_to_go(inner)
+                            )?;
+                        }
+                    }
+                }
+                _ => {}
+            }
+            writeln!(out, "}}")
+        })
+    }
+
+    // ---- Subpackages ----
+
+    /// Generate the subpackage of the exported interface `iface_id`, which
+    /// re-exports the interface's types, and its functions under names
+    /// without the interface prefix, from the generated package at
+    /// `import_path`. Functions are forwarded as variables, so that they
+    /// keep the wrapper's exact signature. Nothing is written for an
+    /// interface with nothing to re-export.
+    fn generate_subpackage(
+        &self,
+        out: &mut String,
+        iface_id: InterfaceId,
+        package: &str,
+        import_path: &str,
+    ) -> std::fmt::Result {
+        let iface = &self.resolve.interfaces[iface_id];
+        let iface_name = iface.name.as_deref().unwrap_or_default();
+        let common = self.package_name();
+
+        let mut body = String::new();
+        let mut types: Vec<(String, TypeId)> = iface
+            .types
+            .iter()
+            .map(|(name, id)| (self.config.naming.to_go_type(name), *id))
+            .collect();
+        types.sort();
+        for (go_name, type_id) in types {
+            let typedef = &self.resolve.types[type_id];
+            let declared = match &typedef.kind {
+                TypeDefKind::Record(_)
+                | TypeDefKind::Variant(_)
+                | TypeDefKind::Enum(_)
+                | TypeDefKind::Flags(_)
+                | TypeDefKind::Resource
+                | TypeDefKind::Type(_) => true,
+                TypeDefKind::List(_)
+                | TypeDefKind::Option(_)
+                | TypeDefKind::Tuple(_)
+                | TypeDefKind::FixedLengthList(..) => {
+                    self.go_alias_name(&Type::Id(type_id)).is_some()
+                }
+                _ => false,
+            };
+            if !declared {
+                continue;
+            }
+            writeln!(body)?;
+            self.write_type_doc_comment(&mut body, &go_name, typedef)?;
+            writeln!(body, "type {go_name} = {common}.{go_name}")?;
+
+            // The names declared along with the type
+            let case_name =
+                |case: &str| format!("{go_name}{}", self.config.naming.to_go_type(case));
+            match &typedef.kind {
+                TypeDefKind::Variant(variant) => {
+                    for case in &variant.cases {
+                        let case_name = case_name(&case.name);
+                        writeln!(body, "type {case_name} = {common}.{case_name}")?;
+                    }
+                }
+                TypeDefKind::Enum(e) => {
+                    writeln!(body)?;
+                    writeln!(body, "const (")?;
+                    for case in &e.cases {
+                        let case_name = case_name(&case.name);
+                        writeln!(body, "\t{case_name} = {common}.{case_name}")?;
+                    }
+                    writeln!(body, ")")?;
+                    if self.is_error_type(type_id) {
+                        writeln!(body)?;
+                        writeln!(body, "var (")?;
+                        for case in &e.cases {
+                            let sentinel = self.enum_sentinel_name(type_id, &case.name);
+                            writeln!(body, "\t{sentinel} = {common}.{sentinel}")?;
+                        }
+                        writeln!(body, ")")?;
+                    }
+                }
+                TypeDefKind::Flags(flags) => {
+                    writeln!(body)?;
+                    writeln!(body, "const (")?;
+                    for flag in &flags.flags {
+                        let flag_name = case_name(&flag.name);
+                        writeln!(body, "\t{flag_name} = {common}.{flag_name}")?;
+                    }
+                    writeln!(body, ")")?;
+                }
+                _ => {}
+            }
+        }
+
+        // Methods come along with their resource's type
+        for ef in self.exported_functions() {
+            if ef.interface_name != iface_name || ef.feature.is_some() || ef.is_method() {
+                continue;
+            }
+            let go_func_name = self.go_func_name(&ef);
+            // Resource functions are already named after their resource, and
+            // renamed functions are named as given
+            let renamed = self
+                .config
+                .function_names
+                .contains_key(&ef.qualified_name(self.resolve));
+            let name = if ef.resource().is_some() || renamed {
+                go_func_name.clone()
+            } else {
+                self.config.naming.to_go_func(&ef.function_name)
+            };
+            writeln!(body)?;
+            if let Some(docs) = &ef.function.docs.contents {
+                Self::write_decl_doc_comment(&mut body, &name, docs, true)?;
+            }
+            self.write_decl_notes(
+                &mut body,
+                &ef.qualified_name(self.resolve),
+                ef.deprecated.as_deref(),
+                ef.function.docs.contents.is_some(),
+            )?;
+            writeln!(body, "var {name} = {common}.{go_func_name}")?;
+            if self.has_into_variant(&ef) {
+                writeln!(body, "var {name}Into = {common}.{go_func_name}Into")?;
+            }
+            if self.has_seq_variant(&ef) {
+                writeln!(body, "var {name}Seq = {common}.{go_func_name}Seq")?;
+            }
+        }
+
+        // An interface with nothing to re-export gets no subpackage
+        if body.is_empty() {
+            return Ok(());
+        }
+
+        writeln!(out, "// Code generated by witffi. DO NOT EDIT.")?;
+        writeln!(out)?;
+        let mut docs = format!(
+            "Package {package} holds the Go bindings of the {iface_name} WIT interface, \
+             re-exported from package {common}."
+        );
+        if let Some(iface_docs) = &iface.docs.contents {
+            docs.push_str("\n\n");
+            docs.push_str(iface_docs.trim_end());
+        }
+        Self::write_doc_comment(out, &docs, "")?;
+        writeln!(out, "package {package}")?;
+        writeln!(out)?;
+        writeln!(out, "import {common} \"{import_path}\"")?;
+
+        out.push_str(&body);
+        Ok(())
+    }
+
+    // ---- Public API generation ----
+
+    fn generate_api(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "// ---- Public API ----")?;
+
+        let funcs = self.exported_functions();
+        // Feature-gated functions go in their own files (see
+        // `generate_feature_files`)
+        let funcs: Vec<&ExportedFunction> =
+            funcs.iter().filter(|ef| ef.feature.is_none()).collect();
+        self.write_each(out, &funcs, |out, ef| self.generate_api_function(out, ef))
+    }
+
+    // ---- Parallel generation ----
+
+    /// `generate` applied to each of `items`, on up to
+    /// [`GoConfig::threads`] threads. Each thread takes a contiguous run of
+    /// the items, and the results are returned in the order of `items`, so
+    /// that the output does not depend on how the work was scheduled.
+    /// Short lists are generated on the calling thread, where a thread
+    /// would cost more than it saves.
+    fn parallel_map<T: Sync, R: Send>(
+        &self,
+        items: &[T],
+        generate: impl Fn(&T) -> R + Sync,
+    ) -> Vec<R> {
+        /// The fewest items worth a thread of their own
+        const MIN_ITEMS_PER_THREAD: usize = 16;
+
+        let threads = match self.config.threads {
+            0 => std::thread::available_parallelism().map_or(1, std::num::NonZeroUsize::get),
+            threads => threads,
+        };
+        let chunk_size = items.len().div_ceil(threads).max(MIN_ITEMS_PER_THREAD);
+        if items.len() <= chunk_size {
+            return items.iter().map(generate).collect();
+        }
+        let generate = &generate;
+        std::thread::scope(|scope| {
+            let handles: Vec<_> = items
+                .chunks(chunk_size)
+                .map(|chunk| scope.spawn(move || chunk.iter().map(generate).collect::<Vec<R>>()))
+                .collect();
+            handles
+                .into_iter()
+                .flat_map(|handle| {
+                    handle
+                        .join()
+                        .unwrap_or_else(|panic| std::panic::resume_unwind(panic))
+                })
+                .collect()
+        })
+    }
+
+    /// Write the code `generate` writes for each of `items` into `out`, in
+    /// the order of `items`, generating it in parallel (see
+    /// `parallel_map`).
+    fn write_each<T: Sync>(
+        &self,
+        out: &mut String,
+        items: &[T],
+        generate: impl Fn(&mut String, &T) -> std::fmt::Result + Sync,
+    ) -> std::fmt::Result {
+        let codes = self.parallel_map(items, |item| {
+            let mut code = String::new();
+            generate(&mut code, item).map(|()| code)
+        });
+        for code in codes {
+            out.push_str(&code?);
+        }
+        Ok(())
+    }
+
+    /// The name of the Go wrapper of `ef`, from its interface and function
+    /// names. Resource functions are named after the resource instead:
+    /// constructors become `NewCounter`, statics `CounterFromString` and
+    /// methods hang off the handle type.
+    fn go_func_name(&self, ef: &ExportedFunction) -> String {
+        if let Some(name) = self
+            .config
+            .function_names
+            .get(&ef.qualified_name(self.resolve))
+        {
+            name.clone()
+        } else if let Some(resource_id) = ef.resource() {
+            let resource_go = self.resource_go_name(resource_id);
+            if ef.is_constructor() {
+                format!("New{resource_go}")
+            } else if ef.is_method() {
+                self.config.naming.to_go_func(ef.item_name())
+            } else {
+                format!(
+                    "{resource_go}{}",
+                    self.config.naming.to_go_func(ef.item_name())
+                )
+            }
+        } else if ef.interface_name.is_empty() {
+            self.config.naming.to_go_func(&ef.function_name)
+        } else {
+            // For multi-interface worlds, combine interface + function name
+            // e.g., "functions" + "u256-to-string" -> "FunctionsU256ToString"
+            // But for single-function interfaces, just use the function name
+            // for brevity (e.g., "parser" + "parse" -> "Parse")
+            self.config
+                .naming
+                .to_go_func(&format!("{}_{}", ef.interface_name, ef.function_name))
+        }
+    }
+
+    fn generate_api_function(&self, out: &mut String, ef: &ExportedFunction) -> std::fmt::Result {
+        let start = out.len();
+        self.generate_api_function_code(out, ef)?;
+        if !self.config.plugins.is_empty() {
+            let code = out.split_off(start);
+            let (wit_name, go_name) = (ef.qualified_name(self.resolve), self.go_func_name(ef));
+            let function = self.plugin_function(ef, &wit_name, &go_name);
+            let code = self.config.plugins.iter().fold(code, |code, plugin| {
+                plugin.rewrite_function(&function, code)
+            });
+            out.push_str(&code);
+        }
+        Ok(())
+    }
+
+    /// What plugins are told about `ef`, named `wit_name` in WIT and
+    /// `go_name` in Go.
+    fn plugin_function<'b>(
+        &self,
+        ef: &'b ExportedFunction,
+        wit_name: &'b str,
+        go_name: &'b str,
+    ) -> GoFunction<'b> {
+        GoFunction {
+            interface: &ef.interface_name,
+            wit_name,
+            go_name,
+        }
+    }
+
+    fn generate_api_function_code(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        let go_func_name = self.go_func_name(ef);
+
+        let result_decomposed = self.decompose_result(&ef.function.result);
+
+        // Methods take `self` as the receiver rather than as a parameter
+        let mut param_names: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| self.config.naming.to_go_ident(&p.name))
+            .collect();
+        let receiver = match ef.resource() {
+            Some(resource_id) if ef.is_method() => {
+                let mut receiver = self.resource_receiver(resource_id);
+                if param_names[1..].contains(&receiver) {
+                    receiver = "self".to_string();
+                }
+                param_names[0] = receiver.clone();
+                Some(format!(
+                    "({receiver} {}Ref)",
+                    self.resource_go_name(resource_id)
+                ))
+            }
+            _ => None,
+        };
+        let skip = usize::from(receiver.is_some());
+
+        // `stream<u8>` parameters are written to returned writers instead
+        let writers: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .zip(&param_names)
+            .filter(|(p, _)| witffi_core::is_byte_stream(self.resolve, &p.ty))
+            .map(|(_, name)| name.clone())
+            .collect();
+
+        // Build Go parameters
+        let go_params: Vec<String> = self
+            .context_param(ef, &param_names)
+            .map(|ctx| format!("{ctx} context.Context"))
+            .into_iter()
+            .chain(
+                ef.function
+                    .params
+                    .iter()
+                    .zip(&param_names)
+                    .skip(skip)
+                    .filter(|(p, _)| !witffi_core::is_byte_stream(self.resolve, &p.ty))
+                    .map(|(p, name)| {
+                        let ty = self.type_to_go(&p.ty);
+                        format!("{name} {ty}")
+                    }),
+            )
+            .collect();
+
+        // Build return type. Tuple results may be returned as multiple values,
+        // and `named_results` records as named ones.
+        let returned = match &result_decomposed {
+            Some((ok_ty, _)) => ok_ty.as_ref(),
+            None => ef.function.result.as_ref(),
+        };
+        let return_names = returned.and_then(|t| self.named_returns(t, &param_names));
+        let name_returns = |rets: Vec<String>| match &return_names {
+            Some(names) => names
+                .iter()
+                .zip(rets)
+                .map(|(name, ty)| format!("{name} {ty}"))
+                .collect(),
+            None => rets,
+        };
+        let go_return_types = |t: &Type| {
+            if self.borrows_string(ef, t) {
+                vec!["BorrowedString".to_string()]
+            } else if let Some(elem) = self.list_view(ef, t) {
+                vec![format!("*ListView[{}]", self.type_to_go(&elem))]
+            } else {
+                self.go_return_types(t)
+            }
+        };
+        let rets = if let Some((ok_ty, _)) = &result_decomposed {
+            let mut rets = ok_ty.as_ref().map(go_return_types).unwrap_or_default();
+            rets.push("error".to_string());
+            name_returns(rets)
+        } else {
+            let mut rets = ef
+                .function
+                .result
+                .as_ref()
+                .map(go_return_types)
+                .unwrap_or_default();
+            // A lifted char, even one nested in the result, may be invalid,
+            // so it is returned with an error
+            if ef.function.result.is_some_and(|t| self.lifts_char(&t)) {
+                rets.push("error".to_string());
+            }
+            name_returns(rets)
+        };
+        let call_return = if rets.len() > 1 {
+            format!("({})", rets.join(", "))
+        } else {
+            rets.join("")
+        };
+        let go_return = if writers.is_empty() {
+            call_return.clone()
+        } else {
+            let mut outer = vec!["io.WriteCloser".to_string(); writers.len()];
+            outer.push(format!("func() {call_return}").trim_end().to_string());
+            format!("({})", outer.join(", "))
+        };
+
+        // Emit function signature
+        writeln!(out)?;
+        if let Some(docs) = &ef.function.docs.contents {
+            Self::write_decl_doc_comment(out, &go_func_name, docs, true)?;
+        }
+        if !writers.is_empty() {
+            if ef.function.docs.contents.is_some() {
+                writeln!(out, "//")?;
+            }
+            let note = if let [writer] = writers.as_slice() {
+                format!(
+                    "The {writer} stream is written to the returned io.WriteCloser, which must be\nclosed to end it."
+                )
+            } else {
+                format!(
+                    "The {} and {} streams are written to the returned io.WriteClosers, in\norder, which must each be closed to end them.",
+                    writers[..writers.len() - 1].join(", "),
+                    writers[writers.len() - 1]
+                )
+            };
+            Self::write_doc_comment(
+                out,
+                &format!("{note}\nThe returned function waits for the call to finish."),
+                "",
+            )?;
+        }
+        if let Some((_, scope)) = self.call_mutex(ef) {
+            if ef.function.docs.contents.is_some() || !writers.is_empty() {
+                writeln!(out, "//")?;
+            }
+            Self::write_doc_comment(
+                out,
+                &format!(
+                    "Calls are serialized with every other call into the single-threaded\n{scope}."
+                ),
+                "",
+            )?;
+        }
+        let borrow_note = match rets.first().map(String::as_str) {
+            Some("BorrowedString") => {
+                Some("The result borrows memory owned by Rust; see BorrowedString.")
+            }
+            Some(ret) if ret.starts_with("*ListView[") => {
+                Some("The result must be closed to free its memory in Rust; see ListView.")
+            }
+            _ => None,
+        };
+        if let Some(note) = borrow_note {
+            if ef.function.docs.contents.is_some()
+                || !writers.is_empty()
+                || self.call_mutex(ef).is_some()
+            {
+                writeln!(out, "//")?;
+            }
+            Self::write_doc_comment(out, note, "")?;
+        }
+        self.write_decl_notes(
+            out,
+            &ef.qualified_name(self.resolve),
+            ef.deprecated.as_deref(),
+            ef.function.docs.contents.is_some()
+                || !writers.is_empty()
+                || self.call_mutex(ef).is_some()
+                || borrow_note.is_some(),
+        )?;
+        let return_clause = if go_return.is_empty() {
+            String::new()
+        } else {
+            format!(" {go_return}")
+        };
+        let receiver_clause = receiver
+            .as_ref()
+            .map(|r| format!("{r} "))
+            .unwrap_or_default();
+        // Positions in the wrapper are reported at its WIT definition
+        let line_directive = self.config.line_directives.as_ref().zip(
+            self.config
+                .source_locations
+                .get(&ef.qualified_name(self.resolve)),
+        );
+        if let Some((dir, location)) = line_directive {
+            writeln!(out, "//line {dir}/{location}")?;
+        }
+        writeln!(
+            out,
+            "func {receiver_clause}{go_func_name}({params}){return_clause} {{",
+            params = go_params.join(", ")
+        )?;
+        self.write_deprecation_warning(out, ef)?;
+
+        // Generate the function body
+        if let Some(timeout) = self.timeout(ef) {
+            self.generate_timed_call(
+                out,
+                ef,
+                &param_names,
+                &rets,
+                &c_func_name,
+                &result_decomposed,
+                timeout,
+            )?;
+        } else if writers.is_empty() {
+            self.generate_dispatched_body(
+                out,
+                ef,
+                &param_names,
+                &rets,
+                &c_func_name,
+                &result_decomposed,
+            )?;
+        } else {
+            self.generate_byte_stream_call(
+                out,
+                ef,
+                &param_names,
+                &writers,
+                &rets,
+                &c_func_name,
+                &result_decomposed,
+            )?;
+        }
+
+        writeln!(out, "}}")?;
+        if line_directive.is_some() {
+            writeln!(out, "{}", Self::LINE_RESTORE)?;
+        }
+
+        if self.has_into_variant(ef) {
+            self.generate_into_function(
+                out,
+                ef,
+                &go_func_name,
+                &go_params,
+                &param_names,
+                &c_func_name,
+            )?;
+        }
+        if receiver.is_none() && self.has_seq_variant(ef) {
+            self.generate_seq_function(
+                out,
+                ef,
+                &go_func_name,
+                &go_params,
+                &param_names,
+                &c_func_name,
+                &result_decomposed,
+            )?;
+        }
+
+        Ok(())
+    }
+
+    /// Generate `{go_func_name}Seq`, a variant of the wrapper of `ef` that
+    /// returns its list result as an `iter.Seq[T]` lifting elements on
+    /// demand, through a `ListView` drained by `All`.
+    #[allow(clippy::too_many_arguments)]
+    fn generate_seq_function(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        go_func_name: &str,
+        go_params: &[String],
+        param_names: &[String],
+        c_func_name: &str,
+        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
+    ) -> std::fmt::Result {
+        // The call is generated as if `ef` returned a list view
+        let mut config = self.config.clone();
+        config.list_views.insert(ef.qualified_name(self.resolve));
+        let viewing = GoGenerator::new(self.resolve, self.world_id, config);
+        let returned = match result_decomposed {
+            Some((ok_ty, _)) => *ok_ty,
+            None => ef.function.result,
+        };
+        let Some(elem) = returned.and_then(|ty| viewing.list_view(ef, &ty)) else {
+            return Ok(());
+        };
+        let elem_go = self.type_to_go(&elem);
+        let fallible = result_decomposed.is_some();
+        let mut rets = vec![format!("*ListView[{elem_go}]")];
+        if fallible {
+            rets.push("error".to_string());
+        }
+
+        let mut body = String::new();
+        match self.timeout(ef) {
+            Some(timeout) => viewing.generate_timed_call(
+                &mut body,
+                ef,
+                param_names,
+                &rets,
+                c_func_name,
+                result_decomposed,
+                timeout,
+            )?,
+            None => viewing.generate_dispatched_body(
+                &mut body,
+                ef,
+                param_names,
+                &rets,
+                c_func_name,
+                result_decomposed,
+            )?,
+        }
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// {go_func_name}Seq is like {go_func_name}, but returns an iterator lifting the"
+        )?;
+        writeln!(
+            out,
+            "// elements as the range reaches them. The result stays in Rust memory until"
+        )?;
+        writeln!(
+            out,
+            "// the iterator is ranged over, which frees it; it can be ranged over once."
+        )?;
+        Self::write_deprecation(out, ef.deprecated.as_deref(), true)?;
+        if fallible {
+            writeln!(
+                out,
+                "func {go_func_name}Seq({}) (iter.Seq[{elem_go}], error) {{",
+                go_params.join(", ")
+            )?;
+            self.write_deprecation_warning(out, ef)?;
+            writeln!(out, "\tview, err := {} {{", Self::func_type(&rets))?;
+        } else {
+            writeln!(
+                out,
+                "func {go_func_name}Seq({}) iter.Seq[{elem_go}] {{",
+                go_params.join(", ")
+            )?;
+            self.write_deprecation_warning(out, ef)?;
+            writeln!(out, "\tview := {} {{", Self::func_type(&rets))?;
+        }
+        Self::write_indented(out, &body)?;
+        writeln!(out, "\t}}()")?;
+        if fallible {
+            writeln!(out, "\tif err != nil {{")?;
+            writeln!(out, "\t\treturn nil, err")?;
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\treturn view.All(), nil")?;
+        } else {
+            writeln!(out, "\treturn view.All()")?;
+        }
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Whether the wrapper of `ef` gets a `Seq` variant.
+    fn has_seq_variant(&self, ef: &ExportedFunction) -> bool {
+        self.config
+            .list_seqs
+            .contains(&ef.qualified_name(self.resolve))
+    }
+
+    /// Check that every function configured to get a `Seq` variant is
+    /// exported, freestanding, synchronous and returns a list.
+    fn check_list_seqs(&self) -> Result<(), Error> {
+        let funcs = self.exported_functions();
+        let mut seqs: Vec<&String> = self.config.list_seqs.iter().collect();
+        seqs.sort();
+        let mut config = self.config.clone();
+        config.list_views.extend(seqs.iter().map(|s| s.to_string()));
+        let viewing = GoGenerator::new(self.resolve, self.world_id, config);
+        for function in seqs {
+            let Some(ef) = funcs
+                .iter()
+                .find(|ef| ef.qualified_name(self.resolve) == *function)
+            else {
+                return ListSeqSnafu {
+                    function: function.clone(),
+                    reason: "the world exports no such function",
+                }
+                .fail();
+            };
+            let returned = match self.decompose_result(&ef.function.result) {
+                Some((ok_ty, _)) => ok_ty,
+                None => ef.function.result,
+            };
+            let reason = if ef.resource().is_some() {
+                "only freestanding functions can have one"
+            } else if ef.is_async() || ef.takes_byte_streams(self.resolve) {
+                "only synchronous functions without byte stream parameters can have one"
+            } else if !returned.is_some_and(|ty| viewing.list_view(ef, &ty).is_some()) {
+                "it does not return a list (other than list<u8>)"
+            } else {
+                continue;
+            };
+            return ListSeqSnafu {
+                function: function.clone(),
+                reason,
+            }
+            .fail();
+        }
+        Ok(())
+    }
+
+    /// Generate `{go_func_name}Into`, a variant of the wrapper of `ef` that
+    /// writes its string or `[]byte` result into a caller-supplied buffer and
+    /// returns its length, through the C `_into` variant.
+    fn generate_into_function(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        go_func_name: &str,
+        go_params: &[String],
+        param_names: &[String],
+        c_func_name: &str,
+    ) -> std::fmt::Result {
+        let dst = if param_names.iter().any(|name| name == "dst") {
+            "dstBuf"
+        } else {
+            "dst"
+        };
+        let mut params = go_params.to_vec();
+        params.push(format!("{dst} []byte"));
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// {go_func_name}Into is like {go_func_name}, but writes the result into {dst} and"
+        )?;
+        writeln!(
+            out,
+            "// returns its length. If the result is longer than {dst}, nothing is written and"
+        )?;
+        writeln!(
+            out,
+            "// the call should be repeated with a buffer of at least the returned length."
+        )?;
+        Self::write_deprecation(out, ef.deprecated.as_deref(), true)?;
+        writeln!(
+            out,
+            "func {go_func_name}Into({}) (int, error) {{",
+            params.join(", ")
+        )?;
+        self.write_deprecation_warning(out, ef)?;
+        // The variant fails like a function returning `result<u64, E>`
+        let mut body = String::new();
+        self.generate_call_body(
+            &mut body,
+            ef,
+            param_names,
+            c_func_name,
+            &Some((Some(Type::U64), None)),
+            Some(dst),
+        )?;
+        self.write_hooked(
+            out,
+            ef,
+            param_names,
+            &["int".to_string(), "error".to_string()],
+            &body,
+        )?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the body of a wrapper taking `stream<u8>` parameters, which
+    /// runs the call on its own goroutine while the caller writes each stream
+    /// to a returned `io.WriteCloser`.
+    ///
+    /// Each stream is an `io.Pipe` whose reading end takes the parameter's
+    /// name, so the call reads it like any other parameter. The reading end
+    /// is closed once the call returns, failing any further writes.
+    /// `rets` are the values the call returns.
+    fn generate_byte_stream_call(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        param_names: &[String],
+        writers: &[String],
+        rets: &[String],
+        c_func_name: &str,
+        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
+    ) -> std::fmt::Result {
+        let ret_count = rets.len();
+        let call_type = match rets {
+            [] => "func()".to_string(),
+            [ret] => format!("func() {ret}"),
+            _ => format!("func() ({})", rets.join(", ")),
+        };
+
+        for writer in writers {
+            writeln!(out, "\t{writer}, {writer}Writer := io.Pipe()")?;
+        }
+        writeln!(out, "\tstreamCall := {call_type} {{")?;
+        let mut body = String::new();
+        self.generate_dispatched_body(
+            &mut body,
+            ef,
+            param_names,
+            rets,
+            c_func_name,
+            result_decomposed,
+        )?;
+        for line in body.lines() {
+            if line.is_empty() {
+                writeln!(out)?;
+            } else {
+                writeln!(out, "\t{line}")?;
+            }
+        }
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tstreamDone := make(chan struct{{}})")?;
+        if ret_count > 0 {
+            writeln!(out, "\tvar streamResult {call_type}")?;
+        }
+        writeln!(out, "\tgo func() {{")?;
+        writeln!(out, "\t\tdefer close(streamDone)")?;
+        for writer in writers {
+            writeln!(out, "\t\tdefer {writer}.Close()")?;
+        }
+        if ret_count == 0 {
+            writeln!(out, "\t\tstreamCall()")?;
+        } else {
+            let values: Vec<String> = (0..ret_count).map(|i| format!("r{i}")).collect();
+            let values = values.join(", ");
+            writeln!(out, "\t\t{values} := streamCall()")?;
+            writeln!(out, "\t\tstreamResult = {call_type} {{ return {values} }}")?;
+        }
+        writeln!(out, "\t}}()")?;
+        let writer_vars: Vec<String> = writers.iter().map(|w| format!("{w}Writer")).collect();
+        writeln!(out, "\treturn {}, {call_type} {{", writer_vars.join(", "))?;
+        writeln!(out, "\t\t<-streamDone")?;
+        if ret_count > 0 {
+            writeln!(out, "\t\treturn streamResult()")?;
+        }
+        writeln!(out, "\t}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the body of a wrapper with a deadline, past which Rust sees
+    /// the call as cancelled.
+    ///
+    /// A wrapper that can fail runs the call on its own goroutine and returns
+    /// `ErrTimeout` at the deadline, leaving the call to finish on its own.
+    /// Any other waits for the call, relying on Rust to return early.
+    #[allow(clippy::too_many_arguments)]
+    fn generate_timed_call(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        param_names: &[String],
+        rets: &[String],
+        c_func_name: &str,
+        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
+        timeout: Duration,
+    ) -> std::fmt::Result {
+        let ctx = self.context_param(ef, param_names);
+        writeln!(
+            out,
+            "\ttimedCtx, timedCancel := context.WithTimeout({}, {})",
+            ctx.unwrap_or("context.Background()"),
+            Self::go_duration(timeout)
+        )?;
+        writeln!(out, "\tdefer timedCancel()")?;
+        let mut body = String::new();
+        self.generate_dispatched_body(
+            &mut body,
+            ef,
+            param_names,
+            rets,
+            c_func_name,
+            result_decomposed,
+        )?;
+        let Some(zeros) = self.error_return_zeros(ef, result_decomposed) else {
+            out.push_str(&body);
+            return Ok(());
+        };
+
+        let call_type = Self::func_type(rets);
+        writeln!(out, "\ttimedCall := {call_type} {{")?;
+        Self::write_indented(out, &body)?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\ttimedDone := make(chan struct{{}})")?;
+        writeln!(out, "\tvar timedResult {call_type}")?;
+        writeln!(out, "\tgo func() {{")?;
+        writeln!(out, "\t\tdefer close(timedDone)")?;
+        let values: Vec<String> = (0..rets.len()).map(|i| format!("r{i}")).collect();
+        let values = values.join(", ");
+        writeln!(out, "\t\t{values} := timedCall()")?;
+        writeln!(out, "\t\ttimedResult = {call_type} {{ return {values} }}")?;
+        writeln!(out, "\t}}()")?;
+        writeln!(out, "\tselect {{")?;
+        writeln!(out, "\tcase <-timedDone:")?;
+        writeln!(out, "\t\treturn timedResult()")?;
+        writeln!(out, "\tcase <-timedCtx.Done():")?;
+        writeln!(out, "\t}}")?;
+        // The caller's own cancellation takes precedence over the deadline
+        if let Some(ctx) = ctx {
+            writeln!(out, "\tif err := {ctx}.Err(); err != nil {{")?;
+            writeln!(out, "\t\treturn {zeros}err")?;
+            writeln!(out, "\t}}")?;
+        }
+        writeln!(out, "\treturn {zeros}ErrTimeout")?;
+
+        Ok(())
+    }
+
+    /// Generate the body of a wrapper returning `rets`, run on the dispatcher
+    /// thread with `dispatch_thread`, or on the blocking pool for functions
+    /// marked blocking.
+    fn generate_dispatched_body(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        param_names: &[String],
+        rets: &[String],
+        c_func_name: &str,
+        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
+    ) -> std::fmt::Result {
+        let mut body = String::new();
+        self.generate_api_function_body(
+            &mut body,
+            ef,
+            param_names,
+            c_func_name,
+            result_decomposed,
+        )?;
+        self.write_hooked(out, ef, param_names, rets, &body)
+    }
+
+    /// Write `body`, run with `write_dispatched` under a pprof label with
+    /// `pprof_labels`, followed by a call dump with `call_dump`, between the
+    /// calls to the registered `CallHooks` with `call_hooks`, and in an
+    /// OpenTelemetry span with `otel_spans`.
+    fn write_hooked(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        param_names: &[String],
+        rets: &[String],
+        body: &str,
+    ) -> std::fmt::Result {
+        let wrapped = self.wrap_plugin_errors(ef, rets, body)?;
+        let body = wrapped.as_deref().unwrap_or(body);
+        if !self.config.pprof_labels
+            && !self.config.call_dump
+            && !self.call_hooks()
+            && !self.config.otel_spans
+        {
+            return self.write_dispatched(out, ef, rets, body);
+        }
+        let mut dispatched = String::new();
+        self.write_dispatched(&mut dispatched, ef, rets, body)?;
+
+        let name = ef.qualified_name(self.resolve);
+        let call_type = Self::func_type(rets);
+        let values: Vec<String> = (0..rets.len()).map(|i| format!("r{i}")).collect();
+        let values = values.join(", ");
+        let err = match rets.last() {
+            Some(ret) if ret.rsplit(' ').next() == Some("error") => {
+                Some(format!("r{}", rets.len() - 1))
+            }
+            _ => None,
+        };
+
+        let dispatched = if self.config.pprof_labels {
+            let mut labeled = String::new();
+            let ctx = self
+                .context_param(ef, param_names)
+                .unwrap_or("context.Background()");
+            let labels = format!("pprof.Labels(\"wit.function\", \"{name}\")");
+            writeln!(labeled, "\tlabeledCall := {call_type} {{")?;
+            Self::write_indented(&mut labeled, &dispatched)?;
+            writeln!(labeled, "\t}}")?;
+            // pprof.Do takes no results, so they are carried out in a closure
+            // like those of timed calls
+            if rets.is_empty() {
+                writeln!(
+                    labeled,
+                    "\tpprof.Do({ctx}, {labels}, func(context.Context) {{ labeledCall() }})"
+                )?;
+            } else {
+                writeln!(labeled, "\tvar labeledResult {call_type}")?;
+                writeln!(
+                    labeled,
+                    "\tpprof.Do({ctx}, {labels}, func(context.Context) {{"
+                )?;
+                writeln!(labeled, "\t\t{values} := labeledCall()")?;
+                writeln!(
+                    labeled,
+                    "\t\tlabeledResult = {call_type} {{ return {values} }}"
+                )?;
+                writeln!(labeled, "\t}})")?;
+                writeln!(labeled, "\treturn labeledResult()")?;
+            }
+            labeled
+        } else {
+            dispatched
+        };
+        let dispatched = if self.config.call_dump {
+            let mut dumped = String::new();
+            self.write_call_dump(&mut dumped, ef, param_names, rets, &dispatched)?;
+            dumped
+        } else {
+            dispatched
+        };
+        let hooked = if self.call_hooks() {
+            let mut hooked = String::new();
+            writeln!(hooked, "\thookedCall := {call_type} {{")?;
+            Self::write_indented(&mut hooked, &dispatched)?;
+            writeln!(hooked, "\t}}")?;
+            writeln!(
+                hooked,
+                "\tif activeHooks := callHooks.Load(); activeHooks != nil {{"
+            )?;
+            writeln!(
+                hooked,
+                "\t\t(*activeHooks).BeforeCall(\"{name}\", []any{{{}}})",
+                param_names.join(", ")
+            )?;
+            writeln!(hooked, "\t\thookStart := time.Now()")?;
+            if rets.is_empty() {
+                writeln!(hooked, "\t\thookedCall()")?;
+            } else {
+                writeln!(hooked, "\t\t{values} := hookedCall()")?;
+            }
+            writeln!(
+                hooked,
+                "\t\t(*activeHooks).AfterCall(\"{name}\", time.Since(hookStart), {})",
+                err.as_deref().unwrap_or("nil")
+            )?;
+            if rets.is_empty() {
+                writeln!(hooked, "\t\treturn")?;
+                writeln!(hooked, "\t}}")?;
+                writeln!(hooked, "\thookedCall()")?;
+            } else {
+                writeln!(hooked, "\t\treturn {values}")?;
+                writeln!(hooked, "\t}}")?;
+                writeln!(hooked, "\treturn hookedCall()")?;
+            }
+            hooked
+        } else {
+            dispatched
+        };
+        if !self.config.otel_spans {
+            out.push_str(&hooked);
+            return Ok(());
+        }
+
+        // The span covers the whole call, hooks included
+        let mut attributes = Vec::new();
+        if !ef.interface_name.is_empty() {
+            attributes.push(format!(
+                "attribute.String(\"wit.interface\", \"{}\")",
+                ef.interface_name
+            ));
+        }
+        let function = name
+            .strip_prefix(&format!("{}.", ef.interface_name))
+            .unwrap_or(&name);
+        attributes.push(format!(
+            "attribute.String(\"wit.function\", \"{function}\")"
+        ));
+        writeln!(out, "\ttracedCall := {call_type} {{")?;
+        Self::write_indented(out, &hooked)?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\t_, span := otelTracer.Start({}, \"{name}\", trace.WithAttributes({}))",
+            self.context_param(ef, param_names)
+                .unwrap_or("context.Background()"),
+            attributes.join(", ")
+        )?;
+        writeln!(out, "\tdefer span.End()")?;
+        match (&err, rets.is_empty()) {
+            (_, true) => writeln!(out, "\ttracedCall()")?,
+            (None, false) => writeln!(out, "\treturn tracedCall()")?,
+            (Some(err), false) => {
+                writeln!(out, "\t{values} := tracedCall()")?;
+                writeln!(out, "\tif {err} != nil {{")?;
+                writeln!(out, "\t\tspan.RecordError({err})")?;
+                writeln!(out, "\t\tspan.SetStatus(codes.Error, {err}.Error())")?;
+                writeln!(out, "\t}}")?;
+                writeln!(out, "\treturn {values}")?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// `body` with a non-nil error it returns passed through the
+    /// `wrap_error` hooks of the plugins, or None if no plugin wraps the
+    /// errors of `ef`.
+    fn wrap_plugin_errors(
+        &self,
+        ef: &ExportedFunction,
+        rets: &[String],
+        body: &str,
+    ) -> Result<Option<String>, std::fmt::Error> {
+        if rets.last().and_then(|ret| ret.rsplit(' ').next()) != Some("error") {
+            return Ok(None);
+        }
+        let err = format!("r{}", rets.len() - 1);
+        let (wit_name, go_name) = (ef.qualified_name(self.resolve), self.go_func_name(ef));
+        let function = self.plugin_function(ef, &wit_name, &go_name);
+        let wrapped = self
+            .config
+            .plugins
+            .iter()
+            .fold(err.clone(), |expr, plugin| {
+                plugin.wrap_error(&function, &expr).unwrap_or(expr)
+            });
+        if wrapped == err {
+            return Ok(None);
+        }
+
+        let values: Vec<String> = (0..rets.len()).map(|i| format!("r{i}")).collect();
+        let values = values.join(", ");
+        let mut out = String::new();
+        writeln!(out, "\tpluginCall := {} {{", Self::func_type(rets))?;
+        Self::write_indented(&mut out, body)?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\t{values} := pluginCall()")?;
+        writeln!(out, "\tif {err} != nil {{")?;
+        writeln!(out, "\t\t{err} = {wrapped}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn {values}")?;
+        Ok(Some(out))
+    }
+
+    /// Write `body` as a call dumped with `dumpCall` when `callDumpEnabled`
+    /// is true, leaving out the values named in `call_dump_redact`.
+    fn write_call_dump(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        param_names: &[String],
+        rets: &[String],
+        body: &str,
+    ) -> std::fmt::Result {
+        let name = ef.qualified_name(self.resolve);
+        let redacted = |value: &str| {
+            self.config
+                .call_dump_redact
+                .contains(&format!("{name}.{value}"))
+        };
+        let params: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| format!("\"{}\"", p.name))
+            .collect();
+        let args: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .zip(param_names)
+            .map(|(p, go_name)| {
+                if redacted(&p.name) {
+                    "\"<redacted>\"".to_string()
+                } else {
+                    go_name.clone()
+                }
+            })
+            .collect();
+        let values: Vec<String> = (0..rets.len()).map(|i| format!("r{i}")).collect();
+        let result_names: Vec<String> = rets
+            .iter()
+            .enumerate()
+            .map(|(i, ret)| match ret.split_once(' ') {
+                Some((name, _)) => format!("\"{name}\""),
+                None if ret == "error" => "\"err\"".to_string(),
+                None if rets.len() == 1 || (rets.len() == 2 && rets[1] == "error") => {
+                    "\"result\"".to_string()
+                }
+                None => format!("\"result{i}\""),
+            })
+            .collect();
+        let results: Vec<String> = if redacted("return") {
+            vec!["\"<redacted>\"".to_string(); rets.len()]
+        } else {
+            values.clone()
+        };
+
+        let call_type = Self::func_type(rets);
+        writeln!(out, "\tdumpedCall := {call_type} {{")?;
+        Self::write_indented(out, body)?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif !callDumpEnabled {{")?;
+        if rets.is_empty() {
+            writeln!(out, "\t\tdumpedCall()")?;
+            writeln!(out, "\t\treturn")?;
+        } else {
+            writeln!(out, "\t\treturn dumpedCall()")?;
+        }
+        writeln!(out, "\t}}")?;
+        if rets.is_empty() {
+            writeln!(out, "\tdumpedCall()")?;
+        } else {
+            writeln!(out, "\t{} := dumpedCall()", values.join(", "))?;
+        }
+        writeln!(
+            out,
+            "\tdumpCall(\"{name}\", []string{{{}}}, []any{{{}}}, []string{{{}}}, []any{{{}}})",
+            params.join(", "),
+            args.join(", "),
+            result_names.join(", "),
+            results.join(", ")
+        )?;
+        if !rets.is_empty() {
+            writeln!(out, "\treturn {}", values.join(", "))?;
+        }
+
+        Ok(())
+    }
+
+    /// Whether wrappers call the registered `CallHooks`, which metrics are
+    /// recorded through.
+    fn call_hooks(&self) -> bool {
+        self.config.call_hooks || self.config.metrics
+    }
+
+    /// Generate `Metrics`, the `CallHooks` recording Prometheus metrics of
+    /// every call, labelled by the function's WIT name.
+    fn generate_metrics(&self, out: &mut String) -> std::fmt::Result {
+        let namespace = self.package_name();
+        let functions: Vec<String> = self
+            .exported_functions()
+            .iter()
+            .filter(|ef| ef.feature.is_none())
+            .map(|ef| ef.qualified_name(self.resolve))
+            .collect();
+
+        self.generate_header(out)?;
+        writeln!(out)?;
+        writeln!(out, "import (")?;
+        writeln!(out, "\t\"time\"")?;
+        writeln!(out)?;
+        writeln!(out, "\t\"github.com/prometheus/client_golang/prometheus\"")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Metrics records the calls into the Rust library as Prometheus metrics: the"
+        )?;
+        writeln!(
+            out,
+            "// calls, errors and latency of each exported function, labelled by its WIT"
+        )?;
+        writeln!(
+            out,
+            "// name (e.g. \"parser.parse\"). Register it with SetCallHooks."
+        )?;
+        writeln!(out, "type Metrics struct {{")?;
+        writeln!(out, "\tcalls    *prometheus.CounterVec")?;
+        writeln!(out, "\terrors   *prometheus.CounterVec")?;
+        writeln!(out, "\tduration *prometheus.HistogramVec")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// metricsFunctions are the WIT names of the exported functions, whose series"
+        )?;
+        writeln!(
+            out,
+            "// NewMetrics creates up front so that they exist before the first call."
+        )?;
+        writeln!(out, "var metricsFunctions = []string{{")?;
+        for function in &functions {
+            writeln!(out, "\t\"{function}\",")?;
+        }
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// NewMetrics registers the metrics of calls into the Rust library with reg:"
+        )?;
+        writeln!(
+            out,
+            "// {namespace}_ffi_calls_total, {namespace}_ffi_errors_total and"
+        )?;
+        writeln!(
+            out,
+            "// {namespace}_ffi_call_duration_seconds, each with a function label."
+        )?;
+        writeln!(
+            out,
+            "func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {{"
+        )?;
+        let metrics = [
+            (
+                "calls",
+                "Counter",
+                "calls_total",
+                "Calls into the Rust library, by WIT function.",
+            ),
+            (
+                "errs",
+                "Counter",
+                "errors_total",
+                "Calls into the Rust library that returned an error, by WIT function.",
+            ),
+            (
+                "duration",
+                "Histogram",
+                "call_duration_seconds",
+                "Latency of calls into the Rust library, by WIT function.",
+            ),
+        ];
+        for (var, kind, name, help) in metrics {
+            writeln!(
+                out,
+                "\t{var} := prometheus.New{kind}Vec(prometheus.{kind}Opts{{"
+            )?;
+            writeln!(out, "\t\tNamespace: \"{namespace}\",")?;
+            writeln!(out, "\t\tSubsystem: \"ffi\",")?;
+            writeln!(out, "\t\tName:      \"{name}\",")?;
+            writeln!(out, "\t\tHelp:      \"{help}\",")?;
+            // From 1µs up to seconds, as most calls take microseconds
+            if kind == "Histogram" {
+                writeln!(
+                    out,
+                    "\t\tBuckets:   prometheus.ExponentialBuckets(1e-6, 4, 12),"
+                )?;
+            }
+            writeln!(out, "\t}}, []string{{\"function\"}})")?;
+        }
+        writeln!(
+            out,
+            "\tfor _, collector := range []prometheus.Collector{{calls, errs, duration}} {{"
+        )?;
+        writeln!(out, "\t\tif err := reg.Register(collector); err != nil {{")?;
+        writeln!(out, "\t\t\treturn nil, err")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tfor _, function := range metricsFunctions {{")?;
+        writeln!(out, "\t\tcalls.WithLabelValues(function)")?;
+        writeln!(out, "\t\terrs.WithLabelValues(function)")?;
+        writeln!(out, "\t\tduration.WithLabelValues(function)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\treturn &Metrics{{calls: calls, errors: errs, duration: duration}}, nil"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// BeforeCall implements CallHooks.")?;
+        writeln!(
+            out,
+            "func (m *Metrics) BeforeCall(name string, args []any) {{}}"
+        )?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// AfterCall implements CallHooks, recording the call to name."
+        )?;
+        writeln!(
+            out,
+            "func (m *Metrics) AfterCall(name string, dur time.Duration, err error) {{"
+        )?;
+        writeln!(out, "\tm.calls.WithLabelValues(name).Inc()")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(out, "\t\tm.errors.WithLabelValues(name).Inc()")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tm.duration.WithLabelValues(name).Observe(dur.Seconds())"
+        )?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    // ---- HTTP handlers ----
+
+    /// Whether `encoding/json` round-trips the Go values of `ty`: those
+    /// built of numbers, strings, records, enums, flags, lists, options,
+    /// tuples and results. Variants, whose Go type is an interface, and
+    /// handles, streams and mapped types are not.
+    fn http_json_type(&self, ty: &Type) -> bool {
+        match ty {
+            Type::ErrorContext => false,
+            Type::Id(_) if self.is_big_int(ty) || self.wide_int(ty).is_some() => true,
+            Type::Id(_) if self.mapped_conversion(ty).is_some() => false,
+            Type::Id(id) => {
+                if let Some((key, value)) = self.map_entry(ty) {
+                    // encoding/json keys objects by strings and integers only
+                    let key_ok = matches!(
+                        self.resolve_to_leaf(key),
+                        Type::U8
+                            | Type::U16
+                            | Type::U32
+                            | Type::U64
+                            | Type::S8
+                            | Type::S16
+                            | Type::S32
+                            | Type::S64
+                            | Type::String
+                    );
+                    return key_ok && self.http_json_type(value);
+                }
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::Record(record) => {
+                        let name = typedef.name.as_deref().unwrap_or("anonymous");
+                        record.fields.iter().all(|field| {
+                            self.field_conversion(name, field).is_none()
+                                && self.http_json_type(&field.ty)
+                        })
+                    }
+                    TypeDefKind::Enum(_) | TypeDefKind::Flags(_) => true,
+                    TypeDefKind::List(inner)
+                    | TypeDefKind::FixedLengthList(inner, _)
+                    | TypeDefKind::Type(inner) => self.http_json_type(inner),
+                    // Option[T] keeps its value unexported
+                    TypeDefKind::Option(inner) => {
+                        !self.config.generic_options && self.http_json_type(inner)
+                    }
+                    TypeDefKind::Tuple(tuple) => tuple.types.iter().all(|t| self.http_json_type(t)),
+                    TypeDefKind::Result(result) => [result.ok, result.err]
+                        .iter()
+                        .flatten()
+                        .all(|t| self.http_json_type(t)),
+                    _ => false,
+                }
+            }
+            _ => true,
+        }
+    }
+
+    /// Whether `ef` is served by `NewHTTPHandler`: a freestanding function
+    /// whose parameters and result `encoding/json` round-trips.
+    fn http_served(&self, ef: &ExportedFunction) -> bool {
+        if ef.resource().is_some() || ef.feature.is_some() {
+            return false;
+        }
+        let params = ef
+            .function
+            .params
+            .iter()
+            .all(|p| self.http_json_type(&p.ty));
+        let returned = match self.decompose_result(&ef.function.result) {
+            Some((ok_ty, _)) => ok_ty,
+            None => ef.function.result,
+        };
+        let result = returned.is_none_or(|ty| {
+            self.http_json_type(&ty)
+                && !self.borrows_string(ef, &ty)
+                && self.list_view(ef, &ty).is_none()
+        });
+        params && result
+    }
+
+    /// The path `NewHTTPHandler` serves `ef` at: its qualified WIT name
+    /// (e.g. `/parser.parse`).
+    fn http_path(&self, ef: &ExportedFunction) -> String {
+        format!("/{}", ef.qualified_name(self.resolve))
+    }
+
+    /// Generate `http_handlers.go`: `NewHTTPHandler`, serving each exported
+    /// function it can as a JSON endpoint, and the text marshaling of
+    /// enums, which JSON spells by their WIT names.
+    fn generate_http_handlers(&self, out: &mut String) -> std::fmt::Result {
+        let served: Vec<ExportedFunction> = self
+            .exported_functions()
+            .into_iter()
+            .filter(|ef| self.http_served(ef))
+            .collect();
+        let big = served.iter().any(|ef| {
+            ef.function
+                .params
+                .iter()
+                .any(|p| self.type_to_go(&p.ty).contains("big.Int"))
+        });
+
+        self.generate_header(out)?;
+        writeln!(out)?;
+        writeln!(out, "import (")?;
+        writeln!(out, "\t\"encoding/json\"")?;
+        writeln!(out, "\t\"errors\"")?;
+        writeln!(out, "\t\"fmt\"")?;
+        writeln!(out, "\t\"io\"")?;
+        if big {
+            writeln!(out, "\t\"math/big\"")?;
+        }
+        writeln!(out, "\t\"net/http\"")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// NewHTTPHandler returns a handler serving the exported functions as JSON"
+        )?;
+        writeln!(
+            out,
+            "// endpoints, for debugging the bindings with curl or internal tools. Each"
+        )?;
+        writeln!(
+            out,
+            "// function is called by a POST to its WIT name (e.g. /parser.parse) whose"
+        )?;
+        writeln!(
+            out,
+            "// body is an object of its parameters, by WIT name. The response is an"
+        )?;
+        writeln!(
+            out,
+            "// object holding the result as \"value\", or why the call failed as \"error\":"
+        )?;
+        writeln!(
+            out,
+            "// with status 400 for a request that does not decode, 422 for an error the"
+        )?;
+        writeln!(out, "// function returned and 500 for a panic.")?;
+        writeln!(out, "//")?;
+        writeln!(
+            out,
+            "// Functions taking or returning variants, resources or streams are not"
+        )?;
+        writeln!(out, "// served.")?;
+        writeln!(out, "func NewHTTPHandler() http.Handler {{")?;
+        writeln!(out, "\tmux := http.NewServeMux()")?;
+        for ef in &served {
+            writeln!(
+                out,
+                "\tmux.HandleFunc(\"POST {}\", handle{})",
+                self.http_path(ef),
+                self.go_func_name(ef)
+            )?;
+        }
+        writeln!(
+            out,
+            "\treturn http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {{"
+        )?;
+        writeln!(out, "\t\tdefer func() {{")?;
+        writeln!(out, "\t\t\tif p := recover(); p != nil {{")?;
+        // Left for net/http to abort the response with
+        writeln!(out, "\t\t\t\tif p == http.ErrAbortHandler {{")?;
+        writeln!(out, "\t\t\t\t\tpanic(p)")?;
+        writeln!(out, "\t\t\t\t}}")?;
+        writeln!(
+            out,
+            "\t\t\t\thttpRespond(w, http.StatusInternalServerError, httpResponse{{Error: fmt.Sprint(\"panic: \", p)}})"
+        )?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t}}()")?;
+        writeln!(out, "\t\tmux.ServeHTTP(w, r)")?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// httpResponse is the body of every response: the result of the call, or"
+        )?;
+        writeln!(out, "// why it failed.")?;
+        writeln!(out, "type httpResponse struct {{")?;
+        writeln!(out, "\tValue any    `json:\"value,omitempty\"`")?;
+        writeln!(out, "\tError string `json:\"error,omitempty\"`")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// httpDecode decodes the body of r into request, answering r with status"
+        )?;
+        writeln!(
+            out,
+            "// 400 if it does not decode. An empty body leaves request as it is."
+        )?;
+        writeln!(
+            out,
+            "func httpDecode(w http.ResponseWriter, r *http.Request, request any) bool {{"
+        )?;
+        writeln!(out, "\tdecoder := json.NewDecoder(r.Body)")?;
+        writeln!(out, "\tdecoder.DisallowUnknownFields()")?;
+        writeln!(
+            out,
+            "\tif err := decoder.Decode(request); err != nil && !errors.Is(err, io.EOF) {{"
+        )?;
+        writeln!(
+            out,
+            "\t\thttpRespond(w, http.StatusBadRequest, httpResponse{{Error: \"decoding the request: \" + err.Error()}})"
+        )?;
+        writeln!(out, "\t\treturn false")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn true")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// httpRespond answers with response, as JSON, and status. A response that"
+        )?;
+        writeln!(
+            out,
+            "// does not encode (holding a NaN, say) is answered with status 500."
+        )?;
+        writeln!(
+            out,
+            "func httpRespond(w http.ResponseWriter, status int, response httpResponse) {{"
+        )?;
+        writeln!(out, "\tbody, err := json.Marshal(response)")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(out, "\t\tstatus = http.StatusInternalServerError")?;
+        writeln!(
+            out,
+            "\t\tbody, _ = json.Marshal(httpResponse{{Error: \"encoding the response: \" + err.Error()}})"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tw.Header().Set(\"Content-Type\", \"application/json\")"
+        )?;
+        writeln!(out, "\tw.WriteHeader(status)")?;
+        writeln!(out, "\tw.Write(append(body, '\\n'))")?;
+        writeln!(out, "}}")?;
+
+        for ef in &served {
+            self.generate_http_handler(out, ef)?;
+        }
+
+        for type_id in self.collect_reachable_types() {
+            let typedef = &self.resolve.types[type_id];
+            if let (TypeDefKind::Enum(_), Some(wit_name)) = (&typedef.kind, &typedef.name) {
+                self.generate_enum_text_methods(out, wit_name)?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Generate the handler calling `ef` with the parameters a request
+    /// holds.
+    fn generate_http_handler(&self, out: &mut String, ef: &ExportedFunction) -> std::fmt::Result {
+        let go_func_name = self.go_func_name(ef);
+        let param_names: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| self.config.naming.to_go_ident(&p.name))
+            .collect();
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// handle{go_func_name} serves {go_func_name} at {}.",
+            self.http_path(ef)
+        )?;
+        writeln!(
+            out,
+            "func handle{go_func_name}(w http.ResponseWriter, r *http.Request) {{"
+        )?;
+        let mut args: Vec<String> = self
+            .context_param(ef, &param_names)
+            .map(|_| "r.Context()".to_string())
+            .into_iter()
+            .collect();
+        if !ef.function.params.is_empty() {
+            writeln!(out, "\tvar request struct {{")?;
+            for p in &ef.function.params {
+                let field = self.config.naming.to_go_field(&p.name);
+                writeln!(
+                    out,
+                    "\t\t{field} {} `json:\"{}\"`",
+                    self.type_to_go(&p.ty),
+                    p.name
+                )?;
+                args.push(format!("request.{field}"));
+            }
+            writeln!(out, "\t}}")?;
+            writeln!(out, "\tif !httpDecode(w, r, &request) {{")?;
+            writeln!(out, "\t\treturn")?;
+            writeln!(out, "\t}}")?;
+        }
+
+        let result_decomposed = self.decompose_result(&ef.function.result);
+        let returned = match &result_decomposed {
+            Some((ok_ty, _)) => *ok_ty,
+            None => ef.function.result,
+        };
+        let count = returned.map_or(0, |ty| {
+            self.result_values(&ty).map_or(1, |values| values.len())
+        });
+        let mut values: Vec<String> = match count {
+            0 => Vec::new(),
+            1 => vec!["value".to_string()],
+            n => (0..n).map(|i| format!("v{i}")).collect(),
+        };
+        let value = match values.as_slice() {
+            [] => String::new(),
+            [value] => format!("Value: {value}"),
+            values => format!("Value: []any{{{}}}", values.join(", ")),
+        };
+        // A lifted char, even a nested one, may be invalid, so it is
+        // returned with an error
+        let fallible = result_decomposed.is_some()
+            || ef.function.result.is_some_and(|ty| self.lifts_char(&ty));
+        if fallible {
+            values.push("err".to_string());
+        }
+        let call = format!("{go_func_name}({})", args.join(", "));
+        if values.is_empty() {
+            writeln!(out, "\t{call}")?;
+        } else {
+            writeln!(out, "\t{} := {call}", values.join(", "))?;
+        }
+        if fallible {
+            writeln!(out, "\tif err != nil {{")?;
+            writeln!(
+                out,
+                "\t\thttpRespond(w, http.StatusUnprocessableEntity, httpResponse{{Error: err.Error()}})"
+            )?;
+            writeln!(out, "\t\treturn")?;
+            writeln!(out, "\t}}")?;
+        }
+        writeln!(
+            out,
+            "\thttpRespond(w, http.StatusOK, httpResponse{{{value}}})"
+        )?;
+        writeln!(out, "}}")?;
+        Ok(())
+    }
+
+    /// Generate `MarshalText` and `UnmarshalText` for an enum, spelling it
+    /// by its WIT name.
+    fn generate_enum_text_methods(&self, out: &mut String, wit_name: &str) -> std::fmt::Result {
+        let go_name = self.config.naming.to_go_type(wit_name);
+        let names_var = format!("{}Names", names::to_go_ident(wit_name));
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// MarshalText returns the WIT name of the {go_name}, for encoding/json."
+        )?;
+        writeln!(out, "func (e {go_name}) MarshalText() ([]byte, error) {{")?;
+        writeln!(out, "\tif int(e) >= len({names_var}) {{")?;
+        writeln!(
+            out,
+            "\t\treturn nil, fmt.Errorf(\"invalid {go_name}: %d\", uint32(e))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn []byte({names_var}[e]), nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// UnmarshalText sets the {go_name} to the one with the given WIT name."
+        )?;
+        writeln!(
+            out,
+            "func (e *{go_name}) UnmarshalText(text []byte) error {{"
+        )?;
+        writeln!(out, "\tparsed, err := Parse{go_name}(string(text))")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(out, "\t\treturn err")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\t*e = parsed")?;
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+        Ok(())
+    }
+
+    /// Generate `CallHooks` and `SetCallHooks`, registering the hooks the
+    /// wrappers call around every call into Rust.
+    fn generate_call_hook_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(
+            out,
+            "// CallHooks observes every call into the Rust library, e.g. to record metrics"
+        )?;
+        writeln!(
+            out,
+            "// or traces. Its methods are called from the calling goroutine, so they may"
+        )?;
+        writeln!(out, "// be called concurrently.")?;
+        writeln!(out, "type CallHooks interface {{")?;
+        writeln!(
+            out,
+            "\t// BeforeCall is called before the call to the function name (e.g."
+        )?;
+        writeln!(
+            out,
+            "\t// \"parser.parse\") with its arguments, a method's receiver first."
+        )?;
+        writeln!(out, "\tBeforeCall(name string, args []any)")?;
+        writeln!(
+            out,
+            "\t// AfterCall is called once the call to name returned, after dur, with"
+        )?;
+        writeln!(
+            out,
+            "\t// the error it returned, or nil if it succeeded or cannot fail."
+        )?;
+        writeln!(
+            out,
+            "\tAfterCall(name string, dur time.Duration, err error)"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// callHooks holds the registered CallHooks, if any.")?;
+        writeln!(out, "var callHooks atomic.Pointer[CallHooks]")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// SetCallHooks registers hooks to call around every call into the Rust"
+        )?;
+        writeln!(
+            out,
+            "// library, replacing any registered before, or removes them if hooks is nil."
+        )?;
+        writeln!(out, "func SetCallHooks(hooks CallHooks) {{")?;
+        writeln!(out, "\tif hooks == nil {{")?;
+        writeln!(out, "\t\tcallHooks.Store(nil)")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tcallHooks.Store(&hooks)")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Write `body`, the body of a wrapper of `ef` returning `rets`, run on
+    /// the dispatcher thread or the blocking pool when either applies.
+    fn write_dispatched(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        rets: &[String],
+        body: &str,
+    ) -> std::fmt::Result {
+        let runner = if self.config.dispatch_thread {
+            "dispatch"
+        } else if self.blocking(ef) {
+            "runBlocking"
+        } else {
+            out.push_str(body);
+            return Ok(());
+        };
+
+        let call_type = Self::func_type(rets);
+        writeln!(out, "\tdispatchedCall := {call_type} {{")?;
+        Self::write_indented(out, body)?;
+        writeln!(out, "\t}}")?;
+        if rets.is_empty() {
+            writeln!(out, "\t{runner}(dispatchedCall)")?;
+            return Ok(());
+        }
+        let values: Vec<String> = (0..rets.len()).map(|i| format!("r{i}")).collect();
+        let values = values.join(", ");
+        writeln!(out, "\tvar dispatchedResult {call_type}")?;
+        writeln!(out, "\t{runner}(func() {{")?;
+        writeln!(out, "\t\t{values} := dispatchedCall()")?;
+        writeln!(
+            out,
+            "\t\tdispatchedResult = {call_type} {{ return {values} }}"
+        )?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "\treturn dispatchedResult()")?;
+
+        Ok(())
+    }
+
+    /// The Go type of a function without parameters returning `rets`.
+    fn func_type(rets: &[String]) -> String {
+        match rets {
+            [] => "func()".to_string(),
+            [ret] if !ret.contains(' ') => format!("func() {ret}"),
+            _ => format!("func() ({})", rets.join(", ")),
+        }
+    }
+
+    /// Write Go code indented one level further.
+    fn write_indented(out: &mut String, code: &str) -> std::fmt::Result {
+        for line in code.lines() {
+            if line.is_empty() {
+                writeln!(out)?;
+            } else {
+                writeln!(out, "\t{line}")?;
+            }
+        }
+        Ok(())
+    }
+
+    /// The mutex serializing calls on a resource, if it or its interface is
+    /// single-threaded, with the scope it covers for doc comments.
+    fn resource_mutex(&self, resource_id: TypeId) -> Option<(String, String)> {
+        let typedef = &self.resolve.types[witffi_core::dealias(self.resolve, resource_id)];
+        let interface = match typedef.owner {
+            TypeOwner::Interface(id) => self.resolve.interfaces[id].name.as_deref(),
+            _ => None,
+        };
+        if let Some(interface) = interface {
+            if self.config.single_threaded.contains(interface) {
+                return Some((
+                    Self::interface_mutex_name(interface),
+                    format!("`{interface}` interface"),
+                ));
+            }
+        }
+        let name = typedef.name.as_deref()?;
+        let qualified = match interface {
+            Some(interface) => format!("{interface}.{name}"),
+            None => name.to_string(),
+        };
+        self.config.single_threaded.contains(&qualified).then(|| {
+            (
+                Self::resource_mutex_name(name),
+                format!("{} resource", self.config.naming.to_go_type(name)),
+            )
+        })
+    }
+
+    /// The mutex serializing calls to `ef`, if it belongs to a
+    /// single-threaded interface or resource, with the scope it covers.
+    fn call_mutex(&self, ef: &ExportedFunction) -> Option<(String, String)> {
+        if let Some(resource_id) = ef.resource() {
+            return self.resource_mutex(resource_id);
+        }
+        let interface = &ef.interface_name;
+        (!interface.is_empty() && self.config.single_threaded.contains(interface)).then(|| {
+            (
+                Self::interface_mutex_name(interface),
+                format!("`{interface}` interface"),
+            )
+        })
+    }
+
+    /// The Go name of the mutex of a single-threaded interface.
+    fn interface_mutex_name(interface: &str) -> String {
+        names::to_go_ident(&format!("{interface}-interface-mu"))
+    }
+
+    /// The Go name of the mutex of a single-threaded resource.
+    fn resource_mutex_name(resource: &str) -> String {
+        names::to_go_ident(&format!("{resource}-mu"))
+    }
+
+    /// Whether the wrapper of `ef` gets an `Into` variant. Timed functions
+    /// don't, since the variant has no deadline of its own.
+    fn has_into_variant(&self, ef: &ExportedFunction) -> bool {
+        self.config.into_variants
+            && ef.into_result(self.resolve).is_some()
+            && self.timeout(ef).is_none()
+    }
+
+    /// Whether `ef` is marked as blocking for long periods.
+    fn blocking(&self, ef: &ExportedFunction) -> bool {
+        self.config
+            .blocking
+            .contains(&ef.qualified_name(self.resolve))
+    }
+
+    /// Whether the wrapper of `ef` reads the error or panic a failed call
+    /// leaves in Rust's thread-local storage, which only the thread that made
+    /// the call can see.
+    fn reads_last_error(&self, ef: &ExportedFunction) -> bool {
+        if ef.is_async() || ef.awaited(self.resolve).is_some() {
+            return false;
+        }
+        self.config.foreign_panics
+            || ef.stream_item(self.resolve).is_some()
+            || self.decompose_result(&ef.function.result).is_some()
+    }
+
+    /// The deadline configured for `ef`, if any.
+    fn timeout(&self, ef: &ExportedFunction) -> Option<Duration> {
+        self.config
+            .timeouts
+            .get(&ef.qualified_name(self.resolve))
+            .copied()
+    }
+
+    /// A Go `time.Duration` expression for `duration`, in the largest unit
+    /// that divides it.
+    fn go_duration(duration: Duration) -> String {
+        let nanos = duration.as_nanos();
+        let units = [
+            (1_000_000_000, "time.Second"),
+            (1_000_000, "time.Millisecond"),
+            (1_000, "time.Microsecond"),
+        ];
+        match units.iter().find(|(scale, _)| nanos % scale == 0) {
+            Some((scale, unit)) => format!("{} * {unit}", nanos / scale),
+            None => format!("time.Duration({nanos})"),
+        }
+    }
+
+    /// Check that every configured timeout names an exported function whose
+    /// wrapper waits for the call to finish.
+    fn check_timeouts(&self) -> Result<(), Error> {
+        let funcs = self.exported_functions();
+        let mut timed: Vec<&String> = self.config.timeouts.keys().collect();
+        timed.sort();
+        for function in timed {
+            let Some(ef) = funcs
+                .iter()
+                .find(|ef| ef.qualified_name(self.resolve) == *function)
+            else {
+                return TimeoutSnafu {
+                    function: function.clone(),
+                    reason: "the world exports no such function",
+                }
+                .fail();
+            };
+            let reason = if ef.stream_item(self.resolve).is_some() {
+                "it returns a stream"
+            } else if ef.future_value(self.resolve).is_some() {
+                "it returns a future"
+            } else if ef.takes_byte_streams(self.resolve) {
+                "it takes a `stream<u8>`"
+            } else {
+                continue;
+            };
+            return TimeoutSnafu {
+                function: function.clone(),
+                reason,
+            }
+            .fail();
+        }
+        Ok(())
+    }
+
+    /// Check that every function marked blocking is exported, and that calls
+    /// are not already confined to the dispatcher thread.
+    fn check_blocking(&self) -> Result<(), Error> {
+        let funcs = self.exported_functions();
+        let mut blocking: Vec<&String> = self.config.blocking.iter().collect();
+        blocking.sort();
+        for function in blocking {
+            let reason = if !funcs
+                .iter()
+                .any(|ef| ef.qualified_name(self.resolve) == *function)
+            {
+                "the world exports no such function"
+            } else if self.config.dispatch_thread {
+                "every call already runs on the dispatcher thread"
+            } else {
+                continue;
+            };
+            return BlockingSnafu {
+                function: function.clone(),
+                reason,
+            }
+            .fail();
+        }
+        Ok(())
+    }
+
+    /// The C functions the bindings call that neither keep the pointers
+    /// they are passed nor call back into Go, when `cgo_annotations` asks
+    /// for them to be marked as such.
+    fn cgo_annotated(&self) -> Vec<String> {
+        if !self.config.cgo_annotations {
+            return Vec::new();
+        }
+        let prefix = self.c_func_prefix();
+        let mut annotated = vec![
+            format!("{prefix}_free_byte_buffer"),
+            format!("{prefix}_last_error_length"),
+            format!("{prefix}_error_message_utf8"),
+        ];
+        // Any exported function may call an import implemented in Go
+        if !self.imports().is_empty() {
+            return annotated;
+        }
+        for ef in self.exported_functions() {
+            if ef.is_awaited(self.resolve)
+                || ef.takes_byte_streams(self.resolve)
+                || ef.stream_item(self.resolve).is_some()
+                || self
+                    .config
+                    .cgo_unannotated
+                    .contains(&ef.qualified_name(self.resolve))
+            {
+                continue;
+            }
+            // Only functions the wrappers call may be annotated
+            let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+            if self.columns_result(&ef).is_some() {
+                annotated.push(format!("{c_func_name}_columns"));
+            } else {
+                annotated.push(c_func_name.clone());
+            }
+            if self.has_into_variant(&ef) {
+                annotated.push(format!("{c_func_name}_into"));
+            }
+        }
+        annotated
+    }
+
+    /// Check that every function exempted from cgo annotations is exported.
+    fn check_cgo_unannotated(&self) -> Result<(), Error> {
+        let funcs = self.exported_functions();
+        let mut unannotated: Vec<&String> = self.config.cgo_unannotated.iter().collect();
+        unannotated.sort();
+        for function in unannotated {
+            let exported = funcs
+                .iter()
+                .any(|ef| ef.qualified_name(self.resolve) == *function);
+            ensure!(
+                exported,
+                CgoUnannotatedSnafu {
+                    function: function.clone()
+                }
+            );
+        }
+        Ok(())
+    }
+
+    /// Check that every interface and resource marked single-threaded is
+    /// exported.
+    fn check_single_threaded(&self) -> Result<(), Error> {
+        let funcs = self.exported_functions();
+        let mut single_threaded: Vec<&String> = self.config.single_threaded.iter().collect();
+        single_threaded.sort();
+        for name in single_threaded {
+            // A resource is named like its functions, less the item
+            let exported = funcs.iter().any(|ef| {
+                let qualified = ef.qualified_name(self.resolve);
+                (!ef.interface_name.is_empty() && ef.interface_name == *name)
+                    || (ef.resource().is_some()
+                        && qualified.rsplit_once('.').map(|(resource, _)| resource)
+                            == Some(name.as_str()))
+            });
+            ensure!(exported, SingleThreadedSnafu { name: name.clone() });
+        }
+        Ok(())
+    }
+
+    /// The element type of the list result `ty` of `ef`, if it is returned
+    /// as a `ListView`.
+    fn list_view(&self, ef: &ExportedFunction, ty: &Type) -> Option<Type> {
+        if !self
+            .config
+            .list_views
+            .contains(&ef.qualified_name(self.resolve))
+        {
+            return None;
+        }
+        self.viewable_list(ty)
+    }
+
+    /// The element type of `ty`, if it is a list a `ListView` can hold.
+    fn viewable_list(&self, ty: &Type) -> Option<Type> {
+        if self.mapped_conversion(ty).is_some() {
+            return None;
+        }
+        let list_id = self.lowered_list(ty)?;
+        match &self.resolve.types[list_id].kind {
+            TypeDefKind::List(elem) => Some(*elem),
+            _ => None,
+        }
+    }
+
+    /// Whether lifting a value of type `ty` frees memory it owns in Rust.
+    fn lift_frees(&self, ty: &Type) -> bool {
+        let Type::Id(id) = ty else {
+            return *ty == Type::String;
+        };
+        match &self.resolve.types[*id].kind {
+            TypeDefKind::Record(record) => record.fields.iter().any(|f| self.lift_frees(&f.ty)),
+            TypeDefKind::Tuple(tuple) => tuple.types.iter().any(|t| self.lift_frees(t)),
+            TypeDefKind::FixedLengthList(elem, _) | TypeDefKind::Type(elem) => {
+                self.lift_frees(elem)
+            }
+            TypeDefKind::Enum(_) | TypeDefKind::Flags(_) => false,
+            _ => true,
+        }
+    }
+
+    /// Check that every function configured to return a list view is
+    /// exported, synchronous and returns a list.
+    fn check_list_views(&self) -> Result<(), Error> {
+        let funcs = self.exported_functions();
+        let mut views: Vec<&String> = self.config.list_views.iter().collect();
+        views.sort();
+        for function in views {
+            let Some(ef) = funcs
+                .iter()
+                .find(|ef| ef.qualified_name(self.resolve) == *function)
+            else {
+                return ListViewSnafu {
+                    function: function.clone(),
+                    reason: "the world exports no such function",
+                }
+                .fail();
+            };
+            let returned = match self.decompose_result(&ef.function.result) {
+                Some((ok_ty, _)) => ok_ty,
+                None => ef.function.result,
+            };
+            let reason = if ef.is_async() || ef.takes_byte_streams(self.resolve) {
+                "only synchronous functions without byte stream parameters can return one"
+            } else if !returned.is_some_and(|ty| self.list_view(ef, &ty).is_some()) {
+                "it does not return a list (other than list<u8>)"
+            } else {
+                continue;
+            };
+            return ListViewSnafu {
+                function: function.clone(),
+                reason,
+            }
+            .fail();
+        }
+        Ok(())
+    }
+
+    /// Write a `newListView` call viewing the C list `list` of type `ty`,
+    /// whose elements are `elem`.
+    fn list_view_expr(&self, ty: &Type, elem: &Type, list: &str) -> String {
+        let Some(list_id) = self.lowered_list(ty) else {
+            return String::new();
+        };
+        let elem_go = self.type_to_go(elem);
+        let conversion = self.convert_ffi_to_go(elem, "elems[i]");
+        format!(
+            "newListView(len(elems), func(i int) {elem_go} {{ return {conversion} }}, {}, func() {{ C.{}_free_{}({list}) }})",
+            self.lift_frees(elem),
+            self.c_func_prefix(),
+            self.shape_name(list_id).to_snake_case()
+        )
+    }
+
+    /// The record of the `list<record>` result of `ef`, when `columnar`
+    /// transfers it column by column and no list view is asked for instead.
+    fn columns_result(&self, ef: &ExportedFunction) -> Option<TypeId> {
+        let returned = match self.decompose_result(&ef.function.result) {
+            Some((ok_ty, _)) => ok_ty,
+            None => ef.function.result,
+        }?;
+        if self.list_view(ef, &returned).is_some() || self.mapped_conversion(&returned).is_some() {
+            return None;
+        }
+        ef.columns_result(self.resolve, &self.config.columnar)
+    }
+
+    /// The records some exported function returns column by column.
+    fn columnar_records(&self) -> Vec<TypeId> {
+        let mut records = Vec::new();
+        for ef in self.exported_functions() {
+            if let Some(record_id) = self.columns_result(&ef)
+                && !records.contains(&record_id)
+            {
+                records.push(record_id);
+            }
+        }
+        records
+    }
+
+    /// Whether `ef` returns its result of type `ty` as a `BorrowedString`.
+    fn borrows_string(&self, ef: &ExportedFunction, ty: &Type) -> bool {
+        *self.resolve_to_leaf(ty) == Type::String
+            && self.type_to_go(ty) == "string"
+            && self
+                .config
+                .borrowed_strings
+                .contains(&ef.qualified_name(self.resolve))
+    }
+
+    /// Check that every function configured to borrow its result is
+    /// exported, synchronous and returns a string.
+    fn check_borrowed_strings(&self) -> Result<(), Error> {
+        let funcs = self.exported_functions();
+        let mut borrowed: Vec<&String> = self.config.borrowed_strings.iter().collect();
+        borrowed.sort();
+        for function in borrowed {
+            let Some(ef) = funcs
+                .iter()
+                .find(|ef| ef.qualified_name(self.resolve) == *function)
+            else {
+                return BorrowedStringSnafu {
+                    function: function.clone(),
+                    reason: "the world exports no such function",
+                }
+                .fail();
+            };
+            let returned = match self.decompose_result(&ef.function.result) {
+                Some((ok_ty, _)) => ok_ty,
+                None => ef.function.result,
+            };
+            let reason = if ef.is_async() || ef.takes_byte_streams(self.resolve) {
+                "only synchronous functions without byte stream parameters can borrow"
+            } else if !returned.is_some_and(|ty| self.borrows_string(ef, &ty)) {
+                "it does not return a string"
+            } else {
+                continue;
+            };
+            return BorrowedStringSnafu {
+                function: function.clone(),
+                reason,
+            }
+            .fail();
+        }
+        Ok(())
+    }
+
+    fn generate_api_function_body(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        param_names: &[String],
+        c_func_name: &str,
+        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
+    ) -> std::fmt::Result {
+        self.generate_call_body(out, ef, param_names, c_func_name, result_decomposed, None)
+    }
+
+    /// Generate the body of a wrapper of `ef`, or with `into_dst`, of its
+    /// `Into` variant writing the result into the caller's buffer `into_dst`.
+    fn generate_call_body(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        param_names: &[String],
+        c_func_name: &str,
+        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
+        into_dst: Option<&str>,
+    ) -> std::fmt::Result {
+        if self.config.init_mode == GoInitMode::Lazy {
+            writeln!(out, "\tensureSetup()")?;
+        }
+
+        // Rust may call back into any import, so all must be implemented
+        if !self.imports().is_empty() {
+            writeln!(out, "\tif err := importsRegistered(); err != nil {{")?;
+            match self.error_return_zeros(ef, result_decomposed) {
+                Some(zeros) => writeln!(out, "\t\treturn {zeros}err")?,
+                None => writeln!(out, "\t\tpanic(err)")?,
+            }
+            writeln!(out, "\t}}")?;
+        }
+
+        // A cancelled context fails the call up front when it can fail, and
+        // is otherwise left for Rust to notice. Timed calls see the context
+        // bounded by their deadline.
+        if let Some(ctx) = self.context_param(ef, param_names) {
+            if let Some(zeros) = self.error_return_zeros(ef, result_decomposed) {
+                writeln!(out, "\tif err := {ctx}.Err(); err != nil {{")?;
+                writeln!(out, "\t\treturn {zeros}err")?;
+                writeln!(out, "\t}}")?;
+            }
+        }
+        if let Some((mutex, _)) = self.call_mutex(ef) {
+            writeln!(out, "\tdefer {mutex}.lock()()")?;
+        }
+        if self.timeout(ef).is_some() {
+            writeln!(out, "\tdefer bindContext(timedCtx)()")?;
+        } else if let Some(ctx) = self.context_param(ef, param_names) {
+            writeln!(out, "\tdefer bindContext({ctx})()")?;
+        }
+
+        // The goroutine must not move to another thread before reading back
+        // the error of a failed call, unless it runs on the dispatcher or a
+        // pool worker, or the call lock already holds it on its thread
+        let pinned =
+            self.config.dispatch_thread || self.blocking(ef) || self.call_mutex(ef).is_some();
+        if !pinned && (into_dst.is_some() || self.reads_last_error(ef)) {
+            writeln!(out, "\truntime.LockOSThread()")?;
+            writeln!(out, "\tdefer runtime.UnlockOSThread()")?;
+        }
+
+        // Tuple parameters are passed to C element by element
+        let mut flat_params = Vec::new();
+        for (p, name) in ef.function.params.iter().zip(param_names) {
+            self.flatten_param(name, name, &p.ty, &mut flat_params);
+        }
+        let flat_params = self.lower_mapped_params(out, flat_params)?;
+
+        // Reject invalid chars up front, as an error when the function can
+        // report one and otherwise as a panic, like an invalid enum
+        let zeros = self.error_return_zeros(ef, result_decomposed);
+        for (expr, _, _) in flat_params.iter().filter(|(_, _, ty)| self.is_char(ty)) {
+            writeln!(out, "\tif !utf8.ValidRune({expr}) {{")?;
+            let err = format!("\"invalid char %#x: not a Unicode scalar value\", {expr}");
+            match &zeros {
+                Some(zeros) => writeln!(out, "\t\treturn {zeros}fmt.Errorf({err})")?,
+                None => writeln!(out, "\t\tpanic(fmt.Sprintf({err}))")?,
+            }
+            writeln!(out, "\t}}")?;
+        }
+
+        // Closed resources fail the call rather than hand Rust a dangling
+        // handle. Owned handles are claimed last, so that a failed check
+        // leaves them open.
+        let (owned, borrowed): (Vec<_>, Vec<_>) = flat_params
+            .iter()
+            .filter(|(_, _, ty)| self.is_handle(ty))
+            .partition(|(_, _, ty)| self.own_handle_resource(ty).is_some());
+        let checks: Vec<String> = borrowed
+            .iter()
+            .map(|(expr, _, _)| format!("!{expr}.open()"))
+            .chain(owned.iter().map(|(expr, _, _)| format!("!{expr}.claim()")))
+            .collect();
+        if !checks.is_empty() {
+            writeln!(out, "\tif {} {{", checks.join(" || "))?;
+            match self.error_return_zeros(ef, result_decomposed) {
+                Some(zeros) => writeln!(out, "\t\treturn {zeros}ErrClosed")?,
+                None => writeln!(out, "\t\tpanic(ErrClosed)")?,
+            }
+            writeln!(out, "\t}}")?;
+        }
+
+        // Lowered lists live in C memory until the call returns
+        if flat_params.iter().any(|(_, _, ty)| {
+            self.lowered_list(self.unwrap_option(ty)).is_some() || self.option_uses_allocs(ty)
+        }) {
+            writeln!(out, "\tallocs := newCAllocs()")?;
+            writeln!(out, "\tdefer allocs.free()")?;
+        }
+
+        // Marshal input parameters
+        for (expr, var, ty) in &flat_params {
+            self.generate_param_marshaling(out, expr, var, ty)?;
+        }
+
+        // Build C function call arguments
+        let c_args: Vec<String> = flat_params
+            .iter()
+            .map(|(expr, var, ty)| {
+                if self.is_option_type(ty) {
+                    format!("{var}Arg")
+                } else if witffi_core::is_byte_stream(self.resolve, ty) {
+                    format!("{var}Source")
+                } else if self.wide_int(ty).is_some() {
+                    format!("{var}Wide.f0, {var}Wide.f1")
+                } else if self.lowered_list(ty).is_some() {
+                    format!("{var}List")
+                } else if self.param_needs_marshaling(ty) {
+                    format!("{var}Slice")
+                } else if self.is_handle(ty) {
+                    format!("{expr}.handle")
+                } else if *self.resolve_to_leaf(ty) == Type::ErrorContext {
+                    format!("lowerErrorContext({expr})")
+                } else if let Some(lower) = self.lower_enum_expr(ty, expr) {
+                    lower
+                } else {
+                    let cgo_ty = self.type_to_cgo(ty);
+                    format!("{cgo_ty}({expr})")
+                }
+            })
+            .collect();
+        let mut c_args_str = c_args.join(", ");
+
+        // Async functions are started here and return their result when it
+        // is collected from the finished task. The locals are prefixed to stay
+        // clear of parameter names.
+        let mut call_func = c_func_name.to_string();
+        if ef.is_async() {
+            let mut start_args = c_args;
+            start_args.extend(["asyncComplete".to_string(), "asyncData".to_string()]);
+            writeln!(
+                out,
+                "\tasyncTask := awaitAsync(func(asyncComplete C.FfiAsyncComplete, asyncData C.uintptr_t) {{"
+            )?;
+            writeln!(out, "\t\tC.{c_func_name}({})", start_args.join(", "))?;
+            writeln!(out, "\t}})")?;
+            call_func.push_str("_finish");
+            c_args_str = "asyncTask".to_string();
+        }
+
+        // Ownership of `own<T>` arguments moves into the callee, so the Go
+        // wrappers are emptied as soon as the call returns.
+        let mut consumed = String::new();
+        for (expr, _, ty) in &flat_params {
+            if let Some(resource_id) = self.own_handle_resource(ty) {
+                if self.resource_cleanup(resource_id) == ResourceCleanup::AddCleanup {
+                    writeln!(consumed, "\t{expr}.cleanup.Stop()")?;
+                }
+                if let Some(untrack) = self.untrack_handle(&format!("{expr}.handle")) {
+                    writeln!(consumed, "\t{untrack}")?;
+                }
+                writeln!(consumed, "\t{expr}.handle = nil")?;
+            }
+        }
+
+        if let Some(record_id) = self.columns_result(ef) {
+            return self.generate_columns_return(
+                out,
+                c_func_name,
+                c_args_str,
+                &consumed,
+                record_id,
+                result_decomposed.is_some(),
+            );
+        }
+        if let Some(dst) = into_dst {
+            return self.generate_into_return(out, c_func_name, c_args_str, &consumed, dst);
+        }
+        if let Some(item) = ef.stream_item(self.resolve) {
+            return self.generate_stream_return(out, c_func_name, &c_args_str, &consumed, &item);
+        }
+        if let Some(awaited) = ef.awaited(self.resolve) {
+            return self.generate_future_return(out, &awaited, c_func_name, &c_args_str, &consumed);
+        }
+
+        self.generate_call_return(
+            out,
+            ef,
+            c_func_name,
+            &call_func,
+            c_args_str,
+            &consumed,
+            result_decomposed,
+        )
+    }
+
+    /// Call `{c_func_name}_into` with `c_args_str` and the caller's buffer
+    /// `dst`, returning the length of the result or the call's error.
+    fn generate_into_return(
+        &self,
+        out: &mut String,
+        c_func_name: &str,
+        mut c_args_str: String,
+        consumed: &str,
+        dst: &str,
+    ) -> std::fmt::Result {
+        writeln!(out, "\tvar {dst}Ptr *C.uint8_t")?;
+        writeln!(out, "\tif len({dst}) > 0 {{")?;
+        writeln!(
+            out,
+            "\t\t{dst}Ptr = (*C.uint8_t)(unsafe.Pointer(&{dst}[0]))"
+        )?;
+        writeln!(out, "\t}}")?;
+        if !c_args_str.is_empty() {
+            c_args_str.push_str(", ");
+        }
+        c_args_str.push_str(&format!("{dst}Ptr, C.size_t(len({dst}))"));
+        writeln!(out, "\tneeded := C.{c_func_name}_into({c_args_str})")?;
+        out.push_str(consumed);
+        writeln!(out, "\tif needed < 0 {{")?;
+        writeln!(
+            out,
+            "\t\treturn 0, {}",
+            self.last_error(&format!("{c_func_name}_into"))
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn int(needed), nil")?;
+
+        Ok(())
+    }
+
+    /// Call `{c_func_name}_columns` with `c_args_str` and gather the columns
+    /// it hands back into a slice of `record_id`, returning it with an
+    /// error if the function is `fallible`.
+    fn generate_columns_return(
+        &self,
+        out: &mut String,
+        c_func_name: &str,
+        mut c_args_str: String,
+        consumed: &str,
+        record_id: TypeId,
+        fallible: bool,
+    ) -> std::fmt::Result {
+        let wit_name = self.resolve.types[record_id]
+            .name
+            .as_deref()
+            .unwrap_or("anonymous");
+        let go_name = self.config.naming.to_go_type(wit_name);
+        let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+        writeln!(out, "\tvar columns C.{c_name}Columns")?;
+        if !c_args_str.is_empty() {
+            c_args_str.push_str(", ");
+        }
+        c_args_str.push_str("&columns");
+        writeln!(out, "\tsuccess := C.{c_func_name}_columns({c_args_str})")?;
+        out.push_str(consumed);
+        writeln!(out, "\tif !success {{")?;
+        if fallible {
+            self.generate_error_return(out, c_func_name, "nil, ", None)?;
+        } else {
+            writeln!(out, "\t\treturn nil")?;
+        }
+        writeln!(out, "\t}}")?;
+        if fallible {
+            writeln!(out, "\treturn convert{go_name}Columns(columns), nil")?;
+        } else {
+            writeln!(out, "\treturn convert{go_name}Columns(columns)")?;
+        }
+
+        Ok(())
+    }
+
+    /// Call `call_func` with `c_args_str` and convert its result, returning
+    /// like the Go wrapper of `ef` would. `consumed` empties the wrappers of
+    /// owned handles once the call has returned.
+    fn generate_call_return(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        c_func_name: &str,
+        call_func: &str,
+        mut c_args_str: String,
+        consumed: &str,
+        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
+    ) -> std::fmt::Result {
+        // Typed errors are handed back through a trailing `err_out` pointer
+        let typed_error = ef.error_payload(self.resolve);
+        if let Some(err_ty) = typed_error {
+            let err_cgo = self.type_to_cgo(&err_ty);
+            writeln!(out, "\tvar errPtr *{err_cgo}")?;
+            if !c_args_str.is_empty() {
+                c_args_str.push_str(", ");
+            }
+            c_args_str.push_str("&errPtr");
+        }
+
+        // Call C function and handle result
+        if let Some((ok_ty, _)) = result_decomposed {
+            if let Some(ok_type) = ok_ty.filter(|ty| self.is_handle(ty)) {
+                // result<own<T>, E> — returns the handle directly (null = error)
+                writeln!(out, "\tresultPtr := C.{call_func}({c_args_str})")?;
+                out.push_str(consumed);
+                writeln!(out, "\tif resultPtr == nil {{")?;
+                self.generate_error_return(out, c_func_name, "nil, ", typed_error)?;
+                writeln!(out, "\t}}")?;
+                let conversion = self.convert_ffi_to_go(&ok_type, "resultPtr");
+                writeln!(out, "\treturn {conversion}, nil")?;
+            } else if let Some(ok_type) = ok_ty {
+                // result<T, E> with a value — returns pointer (null = error)
+                writeln!(out, "\tresultPtr := C.{call_func}({c_args_str})")?;
+                out.push_str(consumed);
+                writeln!(out, "\tif resultPtr == nil {{")?;
+                let zeros = self
+                    .error_return_zeros(ef, result_decomposed)
+                    .unwrap_or_default();
+                self.generate_error_return(out, c_func_name, &zeros, typed_error)?;
+                writeln!(out, "\t}}")?;
+                if self.is_char(ok_type) {
+                    writeln!(out, "\tresult, err := ffiCharToRune(*resultPtr)")?;
+                    writeln!(out, "\t{}", self.free_rust_box("resultPtr"))?;
+                    writeln!(out, "\treturn result, err")?;
+                } else if self.borrows_string(ef, ok_type) {
+                    // The buffer is kept, so only its box is freed
+                    writeln!(out, "\tresult := borrowString(*resultPtr)")?;
+                    writeln!(out, "\t{}", self.free_rust_box("resultPtr"))?;
+                    writeln!(out, "\treturn result, nil")?;
+                } else if let Some(elem) = self.list_view(ef, ok_type) {
+                    // The list is kept, so only its box is freed
+                    writeln!(out, "\tlist := *resultPtr")?;
+                    writeln!(out, "\t{}", self.free_rust_box("resultPtr"))?;
+                    writeln!(out, "\telems := unsafe.Slice(list.ptr, list.len)")?;
+                    writeln!(
+                        out,
+                        "\treturn {}, nil",
+                        self.list_view_expr(ok_type, &elem, "list")
+                    )?;
+                } else {
+                    let conversion = self.convert_ffi_to_go(ok_type, "*resultPtr");
+                    let nests_char = self.nests_char(ok_type);
+                    if nests_char {
+                        let lift = self.lift_chars_expr(ok_type, &conversion);
+                        writeln!(out, "\tresult, err := {lift}")?;
+                    } else {
+                        writeln!(out, "\tresult := {conversion}")?;
+                    }
+                    // Free with type-specific free function
+                    let free_func = self.result_free_func(ok_type);
+                    if free_func == "free" {
+                        writeln!(out, "\t{}", self.free_rust_box("resultPtr"))?;
+                    } else {
+                        writeln!(out, "\tC.{free_func}(resultPtr)")?;
+                    }
+                    writeln!(
+                        out,
+                        "\treturn {}, {}",
+                        self.go_return_values(ok_type, "result"),
+                        if nests_char { "err" } else { "nil" }
+                    )?;
+                }
+            } else {
+                // result<_, E> with no ok value — returns bool
+                writeln!(out, "\tsuccess := C.{call_func}({c_args_str})")?;
+                out.push_str(consumed);
+                writeln!(out, "\tif !success {{")?;
+                self.generate_error_return(out, c_func_name, "", typed_error)?;
+                writeln!(out, "\t}}")?;
+                writeln!(out, "\treturn nil")?;
+            }
+        } else if let Some(ret_ty) = &ef.function.result {
+            // Non-result return type — direct conversion
+            writeln!(out, "\tresult := C.{call_func}({c_args_str})")?;
+            out.push_str(consumed);
+            if self.config.foreign_panics {
+                writeln!(out, "\tcheckForeignPanic(\"{c_func_name}\")")?;
+            }
+            let conversion = self.convert_ffi_to_go(ret_ty, "result");
+            if self.is_char(ret_ty) {
+                writeln!(out, "\treturn ffiCharToRune(result)")?;
+            } else if self.borrows_string(ef, ret_ty) {
+                writeln!(out, "\treturn borrowString(result)")?;
+            } else if let Some(elem) = self.list_view(ef, ret_ty) {
+                writeln!(out, "\telems := unsafe.Slice(result.ptr, result.len)")?;
+                writeln!(
+                    out,
+                    "\treturn {}",
+                    self.list_view_expr(ret_ty, &elem, "result")
+                )?;
+            } else if self.is_option_type(ret_ty) {
+                // option<T> — returns a nullable pointer
+                let inner = self.unwrap_option(ret_ty);
+                let lifts_char = self.lifts_char(inner);
+                let no_err = if lifts_char { ", nil" } else { "" };
+                writeln!(out, "\tif result == nil {{")?;
+                writeln!(out, "\t\treturn {}{no_err}", self.none_expr(inner))?;
+                writeln!(out, "\t}}")?;
+                let value = self.convert_ffi_to_go(inner, "*result");
+                if lifts_char {
+                    let lift = self.lift_chars_expr(inner, &value);
+                    writeln!(out, "\tvalue, err := {lift}")?;
+                    writeln!(out, "\t{}", self.free_rust_box("result"))?;
+                    writeln!(out, "\tif err != nil {{")?;
+                    writeln!(out, "\t\treturn {}, err", self.none_expr(inner))?;
+                    writeln!(out, "\t}}")?;
+                } else {
+                    writeln!(out, "\tvalue := {value}")?;
+                    writeln!(out, "\t{}", self.free_rust_box("result"))?;
+                }
+                writeln!(out, "\treturn {}{no_err}", self.some_expr("value"))?;
+            } else if self.result_values(ret_ty).is_some() {
+                if self.nests_char(ret_ty) {
+                    let lift = self.lift_chars_expr(ret_ty, &conversion);
+                    writeln!(out, "\tvalues, err := {lift}")?;
+                    let values = self.go_return_values(ret_ty, "values");
+                    writeln!(out, "\treturn {values}, err")?;
+                } else {
+                    writeln!(out, "\tvalues := {conversion}")?;
+                    writeln!(out, "\treturn {}", self.go_return_values(ret_ty, "values"))?;
+                }
+            } else if self.nests_char(ret_ty) {
+                writeln!(
+                    out,
+                    "\treturn {}",
+                    self.lift_chars_expr(ret_ty, &conversion)
+                )?;
+            } else {
+                writeln!(out, "\treturn {conversion}")?;
+            }
+        } else {
+            // Void return
+            writeln!(out, "\tC.{call_func}({c_args_str})")?;
+            out.push_str(consumed);
+            if self.config.foreign_panics {
+                writeln!(out, "\tcheckForeignPanic(\"{c_func_name}\")")?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Generate `ErrForeignPanic` and the helpers surfacing the panics the
+    /// Rust implementation caught.
+    fn generate_foreign_panic_helpers(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(
+            out,
+            "// ErrForeignPanic is the error reported when the Rust implementation panics"
+        )?;
+        writeln!(
+            out,
+            "// during a call, which the library catches instead of aborting the process."
+        )?;
+        writeln!(out, "type ErrForeignPanic struct {{")?;
+        writeln!(out, "\t// Message is the panic message.")?;
+        writeln!(out, "\tMessage string")?;
+        writeln!(
+            out,
+            "\t// Backtrace is where the panic was raised, in debug builds of the"
+        )?;
+        writeln!(out, "\t// library; empty otherwise.")?;
+        writeln!(out, "\tBacktrace string")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func (e *ErrForeignPanic) Error() string {{")?;
+        writeln!(out, "\treturn \"rust panic: \" + e.Message")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "{}", self.setup_func("capturePanicBacktraces"))?;
+        writeln!(out, "\tC.{prefix}_capture_panic_backtraces()")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// takeForeignPanic returns the panic behind the last error, if any."
+        )?;
+        writeln!(out, "func takeForeignPanic() *ErrForeignPanic {{")?;
+        writeln!(out, "\tvar backtrace C.FfiByteBuffer")?;
+        writeln!(out, "\tif !C.{prefix}_take_panic(&backtrace) {{")?;
+        writeln!(out, "\t\treturn nil")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\treturn &ErrForeignPanic{{Message: readLastError(), Backtrace: ffiByteBufferToString(backtrace)}}"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// checkForeignPanic raises a panic of the call to op as a Go panic, for"
+        )?;
+        writeln!(out, "// calls with no error to return it in.")?;
+        writeln!(out, "func checkForeignPanic(op string) {{")?;
+        writeln!(out, "\tif p := takeForeignPanic(); p != nil {{")?;
+        writeln!(out, "\t\tpanic(fmt.Errorf(\"%s failed: %w\", op, p))")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate `ErrorChain` and `readErrorChain`, which rebuilds the
+    /// sources `witffi_types::error_chain` serialized into the last error.
+    fn generate_error_chain_helpers(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(
+            out,
+            "// ErrorChain is one level of an error returned from Rust with its sources:"
+        )?;
+        writeln!(
+            out,
+            "// the message of that level, and the error that caused it. Use errors.As"
+        )?;
+        writeln!(out, "// and errors.Unwrap to walk the chain.")?;
+        writeln!(out, "type ErrorChain struct {{")?;
+        writeln!(out, "\tMessage string")?;
+        writeln!(
+            out,
+            "\t// Source is the error that caused this one, or nil at the root."
+        )?;
+        writeln!(out, "\tSource error")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "func (e *ErrorChain) Error() string {{")?;
+        writeln!(out, "\tif e.Source == nil {{")?;
+        writeln!(out, "\t\treturn e.Message")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn e.Message + \": \" + e.Source.Error()")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "// Unwrap returns the error that caused this one.")?;
+        writeln!(out, "func (e *ErrorChain) Unwrap() error {{")?;
+        writeln!(out, "\treturn e.Source")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// readErrorChain reads the last error, rebuilding the sources that"
+        )?;
+        writeln!(
+            out,
+            "// witffi_types::error_chain separated with record separators."
+        )?;
+        writeln!(out, "func readErrorChain() error {{")?;
+        writeln!(
+            out,
+            "\tmessages := strings.Split(readLastError(), \"\\x1e\")"
+        )?;
+        writeln!(out, "\tvar err error")?;
+        writeln!(out, "\tfor i := len(messages) - 1; i >= 0; i-- {{")?;
+        writeln!(
+            out,
+            "\t\terr = &ErrorChain{{Message: messages[i], Source: err}}"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn err")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate `lastError`, building the error a failed call left behind
+    /// from a caught panic or the (chained) last error.
+    fn generate_last_error(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(
+            out,
+            "// lastError returns the error a failed call to op left behind."
+        )?;
+        writeln!(out, "func lastError(op string) error {{")?;
+        if self.config.foreign_panics {
+            writeln!(out, "\tif p := takeForeignPanic(); p != nil {{")?;
+            writeln!(out, "\t\treturn fmt.Errorf(\"%s failed: %w\", op, p)")?;
+            writeln!(out, "\t}}")?;
+        }
+        if self.config.error_chains {
+            writeln!(
+                out,
+                "\treturn fmt.Errorf(\"%s failed: %w\", op, readErrorChain())"
+            )?;
+        } else {
+            writeln!(
+                out,
+                "\treturn fmt.Errorf(\"%s failed: %s\", op, readLastError())"
+            )?;
+        }
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// A Go expression for the error a failed call to `op` left behind.
+    fn last_error(&self, op: &str) -> String {
+        if self.config.foreign_panics || self.config.error_chains {
+            format!("lastError(\"{op}\")")
+        } else {
+            format!("fmt.Errorf(\"{op} failed: %s\", readLastError())")
+        }
+    }
+
+    /// Generate the start of a stream function's producer and the
+    /// `Stream[T]` pulling from it. Elements are returned like the ok value
+    /// of a `result<T, E>`, with null marking the end of the stream.
+    fn generate_stream_return(
+        &self,
+        out: &mut String,
+        c_func_name: &str,
+        c_args_str: &str,
+        consumed: &str,
+        item: &Type,
+    ) -> std::fmt::Result {
+        if *item == Type::U8 {
+            return self.generate_byte_stream_return(out, c_func_name, c_args_str, consumed);
+        }
+        let prefix = self.c_func_prefix();
+        let item_go = self.type_to_go(item);
+        let zero = self.go_zero_value(item);
+
+        writeln!(out, "\tstreamPtr := C.{c_func_name}({c_args_str})")?;
+        out.push_str(consumed);
+        writeln!(out, "\tif streamPtr == nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn failedStream[{item_go}]({})",
+            self.last_error(c_func_name)
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn newStream(func() ({item_go}, bool, error) {{")?;
+        writeln!(out, "\t\truntime.LockOSThread()")?;
+        writeln!(out, "\t\tdefer runtime.UnlockOSThread()")?;
+        writeln!(out, "\t\titemPtr := C.{c_func_name}_next(streamPtr)")?;
+        writeln!(out, "\t\tif itemPtr == nil {{")?;
+        writeln!(out, "\t\t\tif C.{prefix}_last_error_length() > 0 {{")?;
+        writeln!(
+            out,
+            "\t\t\t\treturn {zero}, false, {}",
+            self.last_error(c_func_name)
+        )?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t\treturn {zero}, false, nil")?;
+        writeln!(out, "\t\t}}")?;
+        if self.is_handle(item) {
+            let conversion = self.convert_ffi_to_go(item, "itemPtr");
+            writeln!(out, "\t\treturn {conversion}, true, nil")?;
+        } else if self.is_char(item) {
+            writeln!(out, "\t\titem, err := ffiCharToRune(*itemPtr)")?;
+            writeln!(out, "\t\t{}", self.free_rust_box("itemPtr"))?;
+            writeln!(out, "\t\treturn item, true, err")?;
+        } else {
+            let conversion = self.convert_ffi_to_go(item, "*itemPtr");
+            let nests_char = self.nests_char(item);
+            if nests_char {
+                let lift = self.lift_chars_expr(item, &conversion);
+                writeln!(out, "\t\titem, err := {lift}")?;
+            } else {
+                writeln!(out, "\t\titem := {conversion}")?;
+            }
+            let free_func = self.result_free_func(item);
+            if free_func == "free" {
+                writeln!(out, "\t\t{}", self.free_rust_box("itemPtr"))?;
+            } else {
+                writeln!(out, "\t\tC.{free_func}(itemPtr)")?;
+            }
+            let err = if nests_char { "err" } else { "nil" };
+            writeln!(out, "\t\treturn item, true, {err}")?;
+        }
+        writeln!(out, "\t}}, func() {{")?;
+        writeln!(out, "\t\tC.{c_func_name}_drop(streamPtr)")?;
+        writeln!(out, "\t}})")?;
+
+        Ok(())
+    }
+
+    /// Generate the start of a `stream<u8>` function's reader and the
+    /// `byteReader` reading from it, a buffer at a time.
+    fn generate_byte_stream_return(
+        &self,
+        out: &mut String,
+        c_func_name: &str,
+        c_args_str: &str,
+        consumed: &str,
+    ) -> std::fmt::Result {
+        writeln!(out, "\tstreamPtr := C.{c_func_name}({c_args_str})")?;
+        out.push_str(consumed);
+        writeln!(out, "\tif streamPtr == nil {{")?;
+        writeln!(
+            out,
+            "\t\treturn failedByteReader({})",
+            self.last_error(c_func_name)
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\treturn &byteReader{{read: func(p []byte) (int, error) {{"
+        )?;
+        writeln!(out, "\t\tif len(p) == 0 {{")?;
+        writeln!(out, "\t\t\treturn 0, nil")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\truntime.LockOSThread()")?;
+        writeln!(out, "\t\tdefer runtime.UnlockOSThread()")?;
+        writeln!(
+            out,
+            "\t\tn := C.{c_func_name}_read(streamPtr, (*C.uint8_t)(unsafe.Pointer(&p[0])), C.size_t(len(p)))"
+        )?;
+        writeln!(out, "\t\tswitch {{")?;
+        writeln!(out, "\t\tcase n < 0:")?;
+        writeln!(out, "\t\t\treturn 0, {}", self.last_error(c_func_name))?;
+        writeln!(out, "\t\tcase n == 0:")?;
+        writeln!(out, "\t\t\treturn 0, io.EOF")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t\treturn int(n), nil")?;
+        writeln!(out, "\t}}, drop: func() {{")?;
+        writeln!(out, "\t\tC.{c_func_name}_drop(streamPtr)")?;
+        writeln!(out, "\t}}}}")?;
+
+        Ok(())
+    }
+
+    /// The Go type `Future[T]` is instantiated with for a `future<T>`: the
+    /// ok value for results (errors are returned by `Await`), otherwise the
+    /// value itself.
+    fn future_value_go(&self, value: &Type) -> String {
+        match self.decompose_result(&Some(*value)) {
+            Some((Some(ok), _)) => self.type_to_go(&ok),
+            Some((None, _)) => "struct{}".to_string(),
+            None => self.type_to_go(value),
+        }
+    }
+
+    /// Generate the start of a call returning `future<T>` and the
+    /// `Future[T]` awaiting it. `awaited` is the function returning `T`,
+    /// whose result is collected with `{c_func_name}_finish` like that of an
+    /// async function.
+    fn generate_future_return(
+        &self,
+        out: &mut String,
+        awaited: &ExportedFunction,
+        c_func_name: &str,
+        c_args_str: &str,
+        consumed: &str,
+    ) -> std::fmt::Result {
+        // `ExportedFunction::awaited` sets the result to the future's value
+        let Some(value) = awaited.function.result else {
+            return Ok(());
+        };
+        let value_go = self.future_value_go(&value);
+        let awaited_decomposed = self.decompose_result(&awaited.function.result);
+
+        // The awaited value is a single `T`, so tuples and `named_results`
+        // records are not flattened into multiple values
+        let single = GoGenerator {
+            resolve: self.resolve,
+            world_id: self.world_id,
+            config: GoConfig {
+                multi_value_results: false,
+                named_results: HashSet::new(),
+                ..self.config.clone()
+            },
+        };
+        let mut finish = String::new();
+        single.generate_call_return(
+            &mut finish,
+            awaited,
+            c_func_name,
+            &format!("{c_func_name}_finish"),
+            "asyncTask".to_string(),
+            "",
+            &awaited_decomposed,
+        )?;
+
+        // The collected result is adapted to `(T, error)` if it lacks either
+        let (finish_return, adapter) = match &awaited_decomposed {
+            Some((Some(_), _)) => (format!("({value_go}, error)"), None),
+            Some((None, _)) => (
+                "error".to_string(),
+                Some("return struct{}{}, futureFinish(asyncTask)"),
+            ),
+            None if self.lifts_char(&value) => (format!("({value_go}, error)"), None),
+            None => (
+                value_go.clone(),
+                Some("return futureFinish(asyncTask), nil"),
+            ),
+        };
+        writeln!(
+            out,
+            "\tfutureFinish := func(asyncTask unsafe.Pointer) {finish_return} {{"
+        )?;
+        for line in finish.lines() {
+            writeln!(out, "\t{line}")?;
+        }
+        writeln!(out, "\t}}")?;
+
+        let mut start_args = c_args_str.to_string();
+        if !start_args.is_empty() {
+            start_args.push_str(", ");
+        }
+        start_args.push_str("asyncComplete, asyncData");
+        writeln!(
+            out,
+            "\tfutureHandle := newFuture(func(asyncComplete C.FfiAsyncComplete, asyncData C.uintptr_t) unsafe.Pointer {{"
+        )?;
+        writeln!(out, "\t\treturn C.{c_func_name}({start_args})")?;
+        match adapter {
+            None => writeln!(out, "\t}}, futureFinish)")?,
+            Some(adapter) => {
+                writeln!(
+                    out,
+                    "\t}}, func(asyncTask unsafe.Pointer) ({value_go}, error) {{"
+                )?;
+                writeln!(out, "\t\t{adapter}")?;
+                writeln!(out, "\t}})")?;
+            }
+        }
+        out.push_str(consumed);
+        writeln!(out, "\treturn futureHandle")?;
+
+        Ok(())
+    }
+
+    // ---- Imported functions ----
+
+    /// The imported functions with their C-ABI shapes. Unsupported imports
+    /// are rejected up front by `witffi_core::check_imported_functions`.
+    fn imports(&self) -> Vec<(ExportedFunction, ImportSignature)> {
+        let mut funcs = imported_functions(self.resolve, self.world_id);
+        funcs.sort_by_cached_key(|ef| ef.qualified_name(self.resolve));
+        funcs
+            .into_iter()
+            .filter_map(|ef| {
+                let sig = import_signature(self.resolve, &ef).ok()?;
+                Some((ef, sig))
+            })
+            .collect()
+    }
+
+    /// The Go type of an imported value in the implementation's interface.
+    fn import_go_type(&self, value: ImportValue) -> String {
+        match value {
+            ImportValue::Scalar(ty) => self.type_to_go(&ty),
+            ImportValue::String => "string".to_string(),
+            ImportValue::Bytes => "[]byte".to_string(),
+            ImportValue::Own(id) => format!("*{}", self.resource_go_name(id)),
+            ImportValue::Borrow(id) => format!("{}Ref", self.resource_go_name(id)),
+        }
+    }
+
+    /// The cgo type of an imported value as passed across the C ABI.
+    fn import_cgo_type(&self, value: ImportValue, input: bool) -> String {
+        match value {
+            ImportValue::Scalar(ty) => self.type_to_cgo(&ty),
+            ImportValue::String | ImportValue::Bytes if input => "C.FfiByteSlice".to_string(),
+            ImportValue::String | ImportValue::Bytes => "C.FfiByteBuffer".to_string(),
+            ImportValue::Own(id) | ImportValue::Borrow(id) => {
+                let name = self.resolve.types[id]
+                    .name
+                    .as_deref()
+                    .unwrap_or("Anonymous");
+                format!("*C.{}", names::to_c_type(&self.config.c_type_prefix, name))
+            }
+        }
+    }
+
+    /// Lower a Go value returned by an import implementation for Rust.
+    fn import_lower_expr(&self, value: ImportValue, expr: &str) -> String {
+        match value {
+            ImportValue::Scalar(ty) => format!("{}({expr})", self.type_to_cgo(&ty)),
+            ImportValue::String => format!("ffiMallocBytes([]byte({expr}))"),
+            ImportValue::Bytes => format!("ffiMallocBytes({expr})"),
+            ImportValue::Own(id) | ImportValue::Borrow(id) => {
+                format!("unwrap{}({expr})", self.resource_go_name(id))
+            }
+        }
+    }
+
+    /// The resources import implementations return to Rust, in order.
+    fn import_returned_resources(
+        &self,
+        imports: &[(ExportedFunction, ImportSignature)],
+    ) -> Vec<TypeId> {
+        let mut resources = Vec::new();
+        for (_, sig) in imports {
+            if let ImportResult::Value(ImportValue::Own(id))
+            | ImportResult::Fallible(Some(ImportValue::Own(id))) = sig.result
+            {
+                if !resources.contains(&id) {
+                    resources.push(id);
+                }
+            }
+        }
+        resources
+    }
+
+    /// The Go name of the interface implementing the imports of `iface` (or
+    /// of the world itself, for world-level function imports).
+    fn imports_interface_name(&self, iface: &str) -> String {
+        if iface.is_empty() {
+            let world = &self.resolve.worlds[self.world_id];
+            format!("{}Imports", self.config.naming.to_go_type(&world.name))
+        } else {
+            format!("{}Imports", self.config.naming.to_go_type(iface))
+        }
+    }
+
+    /// Generate the Go side of the functions the world imports: an interface
+    /// per imported interface for Go code to implement, a function
+    /// registering the implementation, and a cgo-exported trampoline per
+    /// function that Rust calls.
+    ///
+    /// Strings and bytes returned to Rust are copied into `malloc`ed memory,
+    /// which the Rust wrappers free.
+    fn generate_imported_functions(&self, out: &mut String) -> std::fmt::Result {
+        let imports = self.imports();
+        if imports.is_empty() {
+            return Ok(());
+        }
+
+        writeln!(out)?;
+        writeln!(out, "// ---- Imported functions ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// ffiMallocBytes copies b into C memory, which the Rust caller frees."
+        )?;
+        writeln!(out, "func ffiMallocBytes(b []byte) C.FfiByteBuffer {{")?;
+        writeln!(out, "\tif len(b) == 0 {{")?;
+        writeln!(out, "\t\treturn C.FfiByteBuffer{{}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\treturn C.FfiByteBuffer{{ptr: (*C.uint8_t)(C.CBytes(b)), len: C.size_t(len(b))}}"
+        )?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        // A panic must not unwind through the Rust frames calling the
+        // import, so Rust resumes it on its own side instead
+        let prefix = self.c_func_prefix();
+        writeln!(
+            out,
+            "// recoverImportPanic, deferred by every import, stops a panic in its Go"
+        )?;
+        writeln!(
+            out,
+            "// implementation from unwinding into Rust, and reports it to Rust, which"
+        )?;
+        writeln!(
+            out,
+            "// panics in turn. The import returns zero values meanwhile."
+        )?;
+        writeln!(out, "func recoverImportPanic() {{")?;
+        writeln!(out, "\tp := recover()")?;
+        writeln!(out, "\tif p == nil {{")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tmessage := fmt.Sprint(p)")?;
+        writeln!(out, "\tC.{prefix}_import_panicked(C.FfiByteSlice{{")?;
+        writeln!(
+            out,
+            "\t\tptr: (*C.uint8_t)(unsafe.Pointer(unsafe.StringData(message))),"
+        )?;
+        writeln!(out, "\t\tlen: C.uintptr_t(len(message)),")?;
+        writeln!(out, "\t}})")?;
+        writeln!(out, "}}")?;
+
+        // Resources returned to Rust give up their handle, like `own<T>`
+        // arguments of exported functions
+        for resource_id in self.import_returned_resources(&imports) {
+            let go_name = self.resource_go_name(resource_id);
+            let receiver = self.resource_receiver(resource_id);
+            let c_type = self.import_cgo_type(ImportValue::Own(resource_id), false);
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// unwrap{go_name} hands ownership of {receiver}'s handle to Rust, emptying {receiver}."
+            )?;
+            writeln!(
+                out,
+                "func unwrap{go_name}({receiver} *{go_name}) {c_type} {{"
+            )?;
+            writeln!(out, "\tif !{receiver}.claim() {{")?;
+            writeln!(
+                out,
+                "\t\tpanic(\"a nil or closed {go_name} cannot be returned to Rust\")"
+            )?;
+            writeln!(out, "\t}}")?;
+            if self.resource_cleanup(resource_id) == ResourceCleanup::AddCleanup {
+                writeln!(out, "\t{receiver}.cleanup.Stop()")?;
+            }
+            writeln!(out, "\thandle := {receiver}.handle")?;
+            if let Some(untrack) = self.untrack_handle("handle") {
+                writeln!(out, "\t{untrack}")?;
+            }
+            writeln!(out, "\t{receiver}.handle = nil")?;
+            writeln!(out, "\treturn handle")?;
+            writeln!(out, "}}")?;
+        }
+
+        let mut interfaces: Vec<&str> = Vec::new();
+        for (ef, _) in &imports {
+            if !interfaces.contains(&ef.interface_name.as_str()) {
+                interfaces.push(&ef.interface_name);
+            }
+        }
+        for &iface in &interfaces {
+            let type_name = self.imports_interface_name(iface);
+            let var_name = names::to_go_ident(&type_name);
+            let described = if iface.is_empty() {
+                format!("by the `{}` world", self.resolve.worlds[self.world_id].name)
+            } else {
+                format!("from the `{iface}` interface")
+            };
+            let funcs: Vec<_> = imports
+                .iter()
+                .filter(|(ef, _)| ef.interface_name == iface)
+                .collect();
+
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// {type_name} implements the functions imported {described},"
+            )?;
+            writeln!(out, "// which Rust calls into Go.")?;
+            writeln!(out, "//")?;
+            writeln!(
+                out,
+                "// Methods may call exported functions, reentering Rust on the same thread,"
+            )?;
+            writeln!(
+                out,
+                "// so they must not wait on anything the calling Go code holds meanwhile."
+            )?;
+            if funcs.iter().any(|(_, sig)| {
+                sig.params
+                    .iter()
+                    .any(|(_, value)| matches!(value, ImportValue::Borrow(_)))
+            }) {
+                writeln!(out, "//")?;
+                writeln!(
+                    out,
+                    "// Borrowed resources are only valid until the method returns. They may be"
+                )?;
+                writeln!(
+                    out,
+                    "// passed back into exported functions meanwhile, but must not be retained."
+                )?;
+            }
+            writeln!(out, "type {type_name} interface {{")?;
+            for (ef, sig) in &funcs {
+                if let Some(docs) = &ef.function.docs.contents {
+                    Self::write_doc_comment(out, docs, "\t")?;
+                }
+                let params: Vec<String> = sig
+                    .params
+                    .iter()
+                    .map(|(name, value)| {
+                        format!(
+                            "{} {}",
+                            self.config.naming.to_go_ident(name),
+                            self.import_go_type(*value)
+                        )
+                    })
+                    .collect();
+                let ret = match sig.result {
+                    ImportResult::None => String::new(),
+                    ImportResult::Value(value) => format!(" {}", self.import_go_type(value)),
+                    ImportResult::Fallible(Some(ok)) => {
+                        format!(" ({}, error)", self.import_go_type(ok))
+                    }
+                    ImportResult::Fallible(None) => " error".to_string(),
+                };
+                writeln!(
+                    out,
+                    "\t{}({}){ret}",
+                    self.config.naming.to_go_func(&ef.function_name),
+                    params.join(", ")
+                )?;
+            }
+            writeln!(out, "}}")?;
+            writeln!(out)?;
+            writeln!(out, "var {var_name} {type_name}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// Register{type_name} sets the implementation Rust calls for the"
+            )?;
+            writeln!(
+                out,
+                "// functions imported {described}. It must be called before Rust"
+            )?;
+            writeln!(
+                out,
+                "// calls any of them: until then, fallible functions fail and the others"
+            )?;
+            writeln!(out, "// panic.")?;
+            writeln!(out, "func Register{type_name}(impl {type_name}) {{")?;
+            writeln!(out, "\t{var_name} = impl")?;
+            writeln!(out, "}}")?;
+
+            for (ef, sig) in &funcs {
+                self.generate_import_trampoline(out, ef, sig, &type_name, &var_name)?;
+            }
+        }
+
+        self.generate_imports_init(out, &interfaces)
+    }
+
+    /// Generate `Init`, registering the implementation of every imported
+    /// interface at once, and `importsRegistered`, which exported functions
+    /// call first so that Rust never calls an import Go has not implemented.
+    fn generate_imports_init(&self, out: &mut String, interfaces: &[&str]) -> std::fmt::Result {
+        let world_name = &self.resolve.worlds[self.world_id].name;
+        let describe = |iface: &str| {
+            if iface.is_empty() {
+                format!("the functions imported by world `{world_name}`")
+            } else {
+                format!("imported interface `{iface}`")
+            }
+        };
+        let field_name = |iface: &str| {
+            if iface.is_empty() {
+                self.config.naming.to_go_type(world_name)
+            } else {
+                self.config.naming.to_go_type(iface)
+            }
+        };
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Imports holds the Go implementations of everything the world imports."
+        )?;
+        writeln!(out, "type Imports struct {{")?;
+        for &iface in interfaces {
+            writeln!(
+                out,
+                "\t{} {}",
+                field_name(iface),
+                self.imports_interface_name(iface)
+            )?;
+        }
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Init registers the Go implementations of every imported function, or"
+        )?;
+        writeln!(
+            out,
+            "// none of them if any is missing. It must be called before any exported"
+        )?;
+        writeln!(
+            out,
+            "// function, which otherwise fails (or panics, if it cannot return an"
+        )?;
+        writeln!(out, "// error) without calling into Rust.")?;
+        if self.config.lifecycle {
+            writeln!(
+                out,
+                "// It then sets up the Rust library's global state with opts, unless"
+            )?;
+            writeln!(out, "// Init already did since the last Shutdown.")?;
+            writeln!(
+                out,
+                "func Init(imports Imports, opts ...InitOption) error {{"
+            )?;
+        } else {
+            writeln!(out, "func Init(imports Imports) error {{")?;
+        }
+        for &iface in interfaces {
+            writeln!(out, "\tif imports.{} == nil {{", field_name(iface))?;
+            writeln!(
+                out,
+                "\t\treturn fmt.Errorf(\"Init: no implementation of {}\")",
+                describe(iface)
+            )?;
+            writeln!(out, "\t}}")?;
+        }
+        for &iface in interfaces {
+            writeln!(
+                out,
+                "\tRegister{}(imports.{})",
+                self.imports_interface_name(iface),
+                field_name(iface)
+            )?;
+        }
+        if self.config.lifecycle {
+            writeln!(out, "\treturn initLibrary(opts)")?;
+        } else {
+            writeln!(out, "\treturn nil")?;
+        }
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// importsRegistered reports the first import without an implementation."
+        )?;
+        writeln!(out, "func importsRegistered() error {{")?;
+        for &iface in interfaces {
+            let var_name = names::to_go_ident(&self.imports_interface_name(iface));
+            writeln!(out, "\tif {var_name} == nil {{")?;
+            writeln!(
+                out,
+                "\t\treturn fmt.Errorf(\"no implementation registered for {}; call Init first\")",
+                describe(iface)
+            )?;
+            writeln!(out, "\t}}")?;
+        }
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the helpers redirecting standard output and error into the
+    /// writers of `WithStdout` and `WithStderr`.
+    fn generate_output_redirects(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// outputRedirect is a standard stream redirected into a Go writer."
+        )?;
+        writeln!(out, "type outputRedirect struct {{")?;
+        writeln!(out, "\t// fd is the redirected file descriptor.")?;
+        writeln!(out, "\tfd C.int")?;
+        writeln!(
+            out,
+            "\t// goFile is os.Stdout or os.Stderr, and previous its value before."
+        )?;
+        writeln!(out, "\tgoFile   **os.File")?;
+        writeln!(out, "\tprevious *os.File")?;
+        writeln!(
+            out,
+            "\t// original is a copy of fd from before, which Go's output goes to."
+        )?;
+        writeln!(out, "\toriginal *os.File")?;
+        writeln!(
+            out,
+            "\t// done is closed once everything written to fd was copied."
+        )?;
+        writeln!(out, "\tdone chan struct{{}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// outputRedirects holds the streams Init redirected, until Shutdown."
+        )?;
+        writeln!(out, "var outputRedirects []*outputRedirect")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// redirectOutputs redirects the streams config has writers for."
+        )?;
+        writeln!(out, "func redirectOutputs(config *initConfig) error {{")?;
+        writeln!(
+            out,
+            "\tstreams := []struct {{\n\t\tfd     C.int\n\t\tgoFile **os.File\n\t\tw      io.Writer\n\t}}{{{{1, &os.Stdout, config.stdout}}, {{2, &os.Stderr, config.stderr}}}}"
+        )?;
+        writeln!(out, "\tfor _, stream := range streams {{")?;
+        writeln!(out, "\t\tif stream.w == nil {{")?;
+        writeln!(out, "\t\t\tcontinue")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(
+            out,
+            "\t\tredirect, err := redirectOutput(stream.fd, stream.goFile, stream.w)"
+        )?;
+        writeln!(out, "\t\tif err != nil {{")?;
+        writeln!(out, "\t\t\trestoreOutputs()")?;
+        writeln!(
+            out,
+            "\t\t\treturn fmt.Errorf(\"Init: redirecting output: %w\", err)"
+        )?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(
+            out,
+            "\t\toutputRedirects = append(outputRedirects, redirect)"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// redirectOutput points fd at a pipe copied into w, and goFile at a copy of"
+        )?;
+        writeln!(out, "// the original.")?;
+        writeln!(
+            out,
+            "func redirectOutput(fd C.int, goFile **os.File, w io.Writer) (*outputRedirect, error) {{"
+        )?;
+        writeln!(out, "\treader, writer, err := os.Pipe()")?;
+        writeln!(out, "\tif err != nil {{")?;
+        writeln!(out, "\t\treturn nil, err")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tdefer writer.Close()")?;
+        writeln!(out, "\tsaved, err := C.dup(fd)")?;
+        writeln!(out, "\tif saved < 0 {{")?;
+        writeln!(out, "\t\treader.Close()")?;
+        writeln!(out, "\t\treturn nil, err")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tif ret, err := C.dup2(C.int(writer.Fd()), fd); ret < 0 {{"
+        )?;
+        writeln!(out, "\t\tC.close(saved)")?;
+        writeln!(out, "\t\treader.Close()")?;
+        writeln!(out, "\t\treturn nil, err")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tredirect := &outputRedirect{{")?;
+        writeln!(out, "\t\tfd:       fd,")?;
+        writeln!(out, "\t\tgoFile:   goFile,")?;
+        writeln!(out, "\t\tprevious: *goFile,")?;
+        writeln!(
+            out,
+            "\t\toriginal: os.NewFile(uintptr(saved), (*goFile).Name()),"
+        )?;
+        writeln!(out, "\t\tdone:     make(chan struct{{}}),")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\t*goFile = redirect.original")?;
+        writeln!(out, "\tgo func() {{")?;
+        writeln!(out, "\t\tdefer close(redirect.done)")?;
+        writeln!(out, "\t\tio.Copy(w, reader)")?;
+        writeln!(out, "\t\treader.Close()")?;
+        writeln!(out, "\t}}()")?;
+        writeln!(out, "\treturn redirect, nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// restoreOutputs points the redirected streams back at the originals, once"
+        )?;
+        writeln!(
+            out,
+            "// everything written to them has been copied into their writers."
+        )?;
+        writeln!(out, "func restoreOutputs() {{")?;
+        writeln!(out, "\tfor _, redirect := range outputRedirects {{")?;
+        writeln!(
+            out,
+            "\t\t// Closing the pipe's last write end lets the copy finish"
+        )?;
+        writeln!(
+            out,
+            "\t\tC.dup2(C.int(redirect.original.Fd()), redirect.fd)"
+        )?;
+        writeln!(out, "\t\t*redirect.goFile = redirect.previous")?;
+        writeln!(out, "\t\tredirect.original.Close()")?;
+        writeln!(out, "\t\t<-redirect.done")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\toutputRedirects = nil")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate `warnDeprecated`, which the wrappers of deprecated functions
+    /// call to log their deprecation the first time they are called.
+    fn generate_deprecation_warnings(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out)?;
+        writeln!(out, "// ---- Deprecation warnings ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// warnedDeprecated holds the deprecated functions already warned of."
+        )?;
+        writeln!(out, "var warnedDeprecated sync.Map")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// warnDeprecated logs a warning through slog's default logger the first"
+        )?;
+        writeln!(
+            out,
+            "// time the function named by the WIT name fn, deprecated in version, is called."
+        )?;
+        writeln!(out, "func warnDeprecated(fn, version string) {{")?;
+        writeln!(
+            out,
+            "\tif _, warned := warnedDeprecated.LoadOrStore(fn, struct{{}}{{}}); warned {{"
+        )?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tslog.Warn(\"call to deprecated function\", \"function\", fn, \"deprecated\", version)"
+        )?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate `SetLogger` and the sink handing the Rust library's log
+    /// records to the `*slog.Logger` it sets.
+    fn generate_log_bridge(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+        let go_string = |name: &str| {
+            format!("C.GoStringN((*C.char)(unsafe.Pointer({name}.ptr)), C.int({name}.len))")
+        };
+
+        writeln!(out)?;
+        writeln!(out, "// ---- Logging ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// rustLogger is the logger receiving the Rust library's log records."
+        )?;
+        writeln!(out, "var rustLogger atomic.Pointer[slog.Logger]")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// rustLevels maps the Rust log levels, from error (1) through trace (5), to"
+        )?;
+        writeln!(out, "// slog levels.")?;
+        writeln!(
+            out,
+            "var rustLevels = [...]slog.Level{{slog.LevelError, slog.LevelWarn, slog.LevelInfo, slog.LevelDebug, slog.LevelDebug - 4}}"
+        )?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// SetLogger routes the Rust library's log records to logger, at the levels"
+        )?;
+        writeln!(
+            out,
+            "// logger has enabled when it is set, with each record's target and key-value"
+        )?;
+        writeln!(
+            out,
+            "// fields as attributes. A nil logger stops forwarding them. SetLogger fails"
+        )?;
+        writeln!(out, "// if the Rust library installed a logger of its own.")?;
+        writeln!(out, "func SetLogger(logger *slog.Logger) error {{")?;
+        if self.config.init_mode == GoInitMode::Lazy {
+            writeln!(out, "\tensureSetup()")?;
+        }
+        writeln!(out, "\trustLogger.Store(logger)")?;
+        writeln!(out, "\tmaxLevel := 0")?;
+        writeln!(out, "\tif logger != nil {{")?;
+        writeln!(out, "\t\tfor i, level := range rustLevels {{")?;
+        writeln!(
+            out,
+            "\t\t\tif logger.Enabled(context.Background(), level) {{"
+        )?;
+        writeln!(out, "\t\t\t\tmaxLevel = i + 1")?;
+        writeln!(out, "\t\t\t}}")?;
+        writeln!(out, "\t\t}}")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tif !C.{prefix}_set_log_sink(C.FfiLogSink(C.{prefix}_log_record), C.uint8_t(maxLevel)) {{"
+        )?;
+        writeln!(
+            out,
+            "\t\treturn fmt.Errorf(\"SetLogger: the Rust library installed a logger of its own\")"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(out, "//export {prefix}_log_record")?;
+        writeln!(
+            out,
+            "func {prefix}_log_record(level C.uint8_t, target, message, fields C.FfiByteSlice) {{"
+        )?;
+        writeln!(
+            out,
+            "\t// A panicking handler drops the record rather than unwind into Rust"
+        )?;
+        writeln!(out, "\tdefer func() {{ _ = recover() }}()")?;
+        writeln!(out, "\tlogger := rustLogger.Load()")?;
+        writeln!(
+            out,
+            "\tif logger == nil || level < 1 || int(level) > len(rustLevels) {{"
+        )?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tattrs := []slog.Attr{{slog.String(\"target\", {})}}",
+            go_string("target")
+        )?;
+        writeln!(
+            out,
+            "\t// Keys and values alternate, each followed by a NUL byte"
+        )?;
+        writeln!(
+            out,
+            "\tkv := strings.Split({}, \"\\x00\")",
+            go_string("fields")
+        )?;
+        writeln!(out, "\tfor i := 0; i+1 < len(kv); i += 2 {{")?;
+        writeln!(
+            out,
+            "\t\tattrs = append(attrs, slog.String(kv[i], kv[i+1]))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\tlogger.LogAttrs(context.Background(), rustLevels[level-1], {}, attrs...)",
+            go_string("message")
+        )?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate `Init` (unless the world imports functions, whose `Init`
+    /// takes the options too), `Shutdown` and the options passed to the
+    /// Rust library's `init_library`.
+    fn generate_lifecycle(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.c_func_prefix();
+
+        writeln!(out)?;
+        writeln!(out, "// ---- Library lifecycle ----")?;
+        writeln!(out)?;
+        writeln!(out, "// InitOption configures the Rust library in Init.")?;
+        writeln!(out, "type InitOption func(*initConfig)")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// initConfig collects the InitOptions passed to Init."
+        )?;
+        writeln!(out, "type initConfig struct {{")?;
+        writeln!(
+            out,
+            "\t// options holds the keys and values passed to Rust, alternately."
+        )?;
+        writeln!(out, "\toptions []string")?;
+        writeln!(
+            out,
+            "\t// stdout and stderr receive the library's output, if set."
+        )?;
+        writeln!(out, "\tstdout, stderr io.Writer")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// WithOption passes the option key=value to the Rust library's"
+        )?;
+        writeln!(out, "// init_library.")?;
+        writeln!(out, "func WithOption(key, value string) InitOption {{")?;
+        writeln!(out, "\treturn func(c *initConfig) {{")?;
+        writeln!(out, "\t\tc.options = append(c.options, key, value)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// WithStdout copies what the Rust library writes to standard output, such as"
+        )?;
+        writeln!(
+            out,
+            "// println! debugging, into w until Shutdown. Standard output is shared by the"
+        )?;
+        writeln!(
+            out,
+            "// whole process, so C code writing to it is captured too; os.Stdout is"
+        )?;
+        writeln!(
+            out,
+            "// pointed at a copy of the original meanwhile, so Go's own output is not."
+        )?;
+        writeln!(out, "func WithStdout(w io.Writer) InitOption {{")?;
+        writeln!(out, "\treturn func(c *initConfig) {{")?;
+        writeln!(out, "\t\tc.stdout = w")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// WithStderr is like WithStdout, for standard error and os.Stderr."
+        )?;
+        writeln!(out, "func WithStderr(w io.Writer) InitOption {{")?;
+        writeln!(out, "\treturn func(c *initConfig) {{")?;
+        writeln!(out, "\t\tc.stderr = w")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "}}")?;
+        self.generate_output_redirects(out)?;
+        writeln!(out)?;
+        writeln!(out, "var (")?;
+        writeln!(out, "\t// libraryMu serializes Init and Shutdown.")?;
+        writeln!(out, "\tlibraryMu sync.Mutex")?;
+        writeln!(
+            out,
+            "\t// libraryInitialized is whether Init succeeded since the last Shutdown."
+        )?;
+        writeln!(out, "\tlibraryInitialized bool")?;
+        writeln!(out, ")")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// initLibrary sets up the Rust library's global state with opts, unless"
+        )?;
+        writeln!(out, "// it already is.")?;
+        writeln!(out, "func initLibrary(opts []InitOption) error {{")?;
+        if self.config.init_mode == GoInitMode::Lazy {
+            writeln!(out, "\tensureSetup()")?;
+        }
+        writeln!(out, "\tlibraryMu.Lock()")?;
+        writeln!(out, "\tdefer libraryMu.Unlock()")?;
+        writeln!(out, "\tif libraryInitialized {{")?;
+        writeln!(out, "\t\treturn nil")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tvar config initConfig")?;
+        writeln!(out, "\tfor _, opt := range opts {{")?;
+        writeln!(out, "\t\topt(&config)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\t// Each key and value is followed by a NUL byte")?;
+        writeln!(out, "\tvar encoded []byte")?;
+        writeln!(out, "\tfor _, field := range config.options {{")?;
+        writeln!(out, "\t\tencoded = append(encoded, field...)")?;
+        writeln!(out, "\t\tencoded = append(encoded, 0)")?;
+        writeln!(out, "\t}}")?;
+        writeln!(
+            out,
+            "\toptions := C.FfiByteSlice{{len: C.uintptr_t(len(encoded))}}"
+        )?;
+        writeln!(out, "\tif len(encoded) > 0 {{")?;
+        writeln!(
+            out,
+            "\t\toptions.ptr = (*C.uint8_t)(unsafe.Pointer(&encoded[0]))"
+        )?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif err := redirectOutputs(&config); err != nil {{")?;
+        writeln!(out, "\t\treturn err")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tif !C.{prefix}_init(options) {{")?;
+        writeln!(
+            out,
+            "\t\terr := {}",
+            self.last_error(&format!("{prefix}_init"))
+        )?;
+        writeln!(out, "\t\trestoreOutputs()")?;
+        writeln!(out, "\t\treturn err")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tlibraryInitialized = true")?;
+        writeln!(out, "\treturn nil")?;
+        writeln!(out, "}}")?;
+
+        if self.imports().is_empty() {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "// Init sets up the Rust library's global state, such as statics and"
+            )?;
+            writeln!(
+                out,
+                "// thread pools, with opts. Calling it again before Shutdown does nothing."
+            )?;
+            writeln!(out, "func Init(opts ...InitOption) error {{")?;
+            writeln!(out, "\treturn initLibrary(opts)")?;
+            writeln!(out, "}}")?;
+        }
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "// Shutdown tears down the Rust library's global state, such as flushing"
+        )?;
+        writeln!(
+            out,
+            "// logs and dropping caches, before the process exits or between tests."
+        )?;
+        writeln!(
+            out,
+            "// Init may set it up again afterwards. Shutdown does nothing unless Init"
+        )?;
+        writeln!(out, "// succeeded since the last Shutdown.")?;
+        writeln!(out, "func Shutdown() {{")?;
+        writeln!(out, "\tlibraryMu.Lock()")?;
+        writeln!(out, "\tdefer libraryMu.Unlock()")?;
+        writeln!(out, "\tif !libraryInitialized {{")?;
+        writeln!(out, "\t\treturn")?;
+        writeln!(out, "\t}}")?;
+        writeln!(out, "\tC.{prefix}_shutdown()")?;
+        writeln!(out, "\trestoreOutputs()")?;
+        writeln!(out, "\tlibraryInitialized = false")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the cgo-exported function Rust calls for one import, which
+    /// forwards to the registered implementation.
+    fn generate_import_trampoline(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        sig: &ImportSignature,
+        type_name: &str,
+        var_name: &str,
+    ) -> std::fmt::Result {
+        const RESERVED: &[&str] = &["C", "err", "errOut", "okOut", "result", "unsafe"];
+        let c_name = ef.c_import_name(&self.config.c_prefix);
+        let param_names: Vec<String> = sig
+            .params
+            .iter()
+            .map(|(name, _)| {
+                let name = self.config.naming.to_go_ident(name);
+                if RESERVED.contains(&name.as_str()) || name == var_name {
+                    format!("{name}Arg")
+                } else {
+                    name
+                }
+            })
+            .collect();
+
+        let mut c_params: Vec<String> = sig
+            .params
+            .iter()
+            .zip(&param_names)
+            .map(|((_, value), name)| format!("{name} {}", self.import_cgo_type(*value, true)))
+            .collect();
+        let c_return = match sig.result {
+            ImportResult::None => String::new(),
+            ImportResult::Value(value) => format!(" {}", self.import_cgo_type(value, false)),
+            ImportResult::Fallible(ok) => {
+                if let Some(ok) = ok {
+                    c_params.push(format!("okOut *{}", self.import_cgo_type(ok, false)));
+                }
+                c_params.push("errOut *C.FfiByteBuffer".to_string());
+                " C.bool".to_string()
+            }
+        };
+        let args: Vec<String> = sig
+            .params
+            .iter()
+            .zip(&param_names)
+            .map(|((_, value), name)| match value {
+                ImportValue::Scalar(ty) => format!("{}({name})", self.type_to_go(ty)),
+                ImportValue::String => {
+                    format!("C.GoStringN((*C.char)(unsafe.Pointer({name}.ptr)), C.int({name}.len))")
+                }
+                ImportValue::Bytes => {
+                    format!("C.GoBytes(unsafe.Pointer({name}.ptr), C.int({name}.len))")
+                }
+                ImportValue::Own(id) => format!("wrap{}({name})", self.resource_go_name(*id)),
+                ImportValue::Borrow(id) => {
+                    format!("{}Ref{{handle: {name}}}", self.resource_go_name(*id))
+                }
+            })
+            .collect();
+        let call = format!(
+            "{var_name}.{}({})",
+            self.config.naming.to_go_func(&ef.function_name),
+            args.join(", ")
+        );
+
+        writeln!(out)?;
+        writeln!(out, "//export {c_name}")?;
+        writeln!(out, "func {c_name}({}){c_return} {{", c_params.join(", "))?;
+        writeln!(out, "\tdefer recoverImportPanic()")?;
+        writeln!(out, "\tif {var_name} == nil {{")?;
+        let unregistered = format!("Register{type_name} has not been called");
+        if matches!(sig.result, ImportResult::Fallible(_)) {
+            writeln!(
+                out,
+                "\t\t*errOut = ffiMallocBytes([]byte(\"{unregistered}\"))"
+            )?;
+            writeln!(out, "\t\treturn false")?;
+        } else {
+            writeln!(out, "\t\tpanic(\"{unregistered}\")")?;
+        }
+        writeln!(out, "\t}}")?;
+        match sig.result {
+            ImportResult::None => writeln!(out, "\t{call}")?,
+            ImportResult::Value(value) => {
+                writeln!(out, "\tresult := {call}")?;
+                writeln!(out, "\treturn {}", self.import_lower_expr(value, "result"))?;
+            }
+            ImportResult::Fallible(ok) => {
+                if ok.is_some() {
+                    writeln!(out, "\tresult, err := {call}")?;
+                } else {
+                    writeln!(out, "\terr := {call}")?;
+                }
+                writeln!(out, "\tif err != nil {{")?;
+                writeln!(out, "\t\t*errOut = ffiMallocBytes([]byte(err.Error()))")?;
+                writeln!(out, "\t\treturn false")?;
+                writeln!(out, "\t}}")?;
+                if let Some(ok) = ok {
+                    writeln!(out, "\t*okOut = {}", self.import_lower_expr(ok, "result"))?;
+                }
+                writeln!(out, "\treturn true")?;
+            }
+        }
+        writeln!(out, "}}")?;
+        Ok(())
+    }
+
+    /// Generate Go code to pass an optional parameter as a nullable pointer
+    /// (`{var}Arg`).
+    ///
+    /// String and byte elements are copied into C memory for the duration of
+    /// the call, since cgo forbids passing Go pointers to memory that itself
+    /// holds Go pointers.
+    fn generate_option_param_marshaling(
+        &self,
+        out: &mut String,
+        go_expr: &str,
+        var: &str,
+        ty: &Type,
+    ) -> std::fmt::Result {
+        let mapped = self.mapped_conversion(self.unwrap_option(ty));
+        let inner = &mapped.as_ref().map_or(*self.unwrap_option(ty), |m| m.inner);
+        let marshaled = self.param_needs_marshaling(inner);
+        let lowered_list = self.lowered_list(inner);
+        let error_context = *self.resolve_to_leaf(inner) == Type::ErrorContext;
+        let c_ty = if marshaled {
+            "C.FfiByteSlice".to_string()
+        } else if lowered_list.is_some() || error_context {
+            self.type_to_cgo_input(inner)
+        } else {
+            self.type_to_cgo(inner)
+        };
+
+        writeln!(out, "\tvar {var}Arg *{c_ty}")?;
+        if self.config.generic_options {
+            writeln!(out, "\tif {var}Value, ok := {go_expr}.Get(); ok {{")?;
+        } else {
+            writeln!(out, "\tif {go_expr} != nil {{")?;
+            writeln!(out, "\t\t{var}Value := *{go_expr}")?;
+        }
+        let value = match &mapped {
+            Some(mapped) => {
+                writeln!(out, "\t\t{var}Raw := {}({var}Value)", mapped.lower)?;
+                format!("{var}Raw")
+            }
+            None => format!("{var}Value"),
+        };
+        let arena = self.config.arena_chunk_size.is_some();
+        if self.pinned_option(ty) {
+            writeln!(out, "\t\t{var}C := allocs.pin({value})")?;
+        } else if marshaled && arena {
+            writeln!(out, "\t\t{var}C := allocs.bytes([]byte({value}))")?;
+        } else if error_context && arena {
+            writeln!(
+                out,
+                "\t\t{var}C := C.FfiErrorContextInput{{id: C.uint64_t({value}.ID()), debug_message: allocs.bytes([]byte({value}.DebugMessage()))}}"
+            )?;
+        } else if marshaled {
+            writeln!(out, "\t\t{var}Data := C.CBytes([]byte({value}))")?;
+            writeln!(out, "\t\tdefer C.free({var}Data)")?;
+            writeln!(out, "\t\t{var}C := C.FfiByteSlice{{")?;
+            writeln!(out, "\t\t\tptr: (*C.uint8_t)({var}Data),")?;
+            writeln!(out, "\t\t\tlen: C.uintptr_t(len({value})),")?;
+            writeln!(out, "\t\t}}")?;
+        } else if error_context {
+            // The value is passed by pointer, so its message is copied out of
+            // Go memory
+            writeln!(out, "\t\t{var}Message := {value}.DebugMessage()")?;
+            writeln!(out, "\t\t{var}Data := C.CBytes([]byte({var}Message))")?;
+            writeln!(out, "\t\tdefer C.free({var}Data)")?;
+            writeln!(out, "\t\t{var}C := C.FfiErrorContextInput{{")?;
+            writeln!(out, "\t\t\tid: C.uint64_t({value}.ID()),")?;
+            writeln!(out, "\t\t\tdebug_message: C.FfiByteSlice{{")?;
+            writeln!(out, "\t\t\t\tptr: (*C.uint8_t)({var}Data),")?;
+            writeln!(out, "\t\t\t\tlen: C.uintptr_t(len({var}Message)),")?;
+            writeln!(out, "\t\t\t}},")?;
+            writeln!(out, "\t\t}}")?;
+        } else if let Some(lower) = self.wide_lower_func(inner) {
+            writeln!(out, "\t\t{var}C := {lower}({value})")?;
+        } else if let Some(list_id) = lowered_list {
+            let value = if self.map_entry(inner).is_some() {
+                format!("ffiMapToPairs({value})")
+            } else {
+                value
+            };
+            writeln!(
+                out,
+                "\t\t{var}C := lower{}({value}, allocs)",
+                self.shape_name(list_id)
+            )?;
+        } else if let Some(lower) = self.lower_enum_expr(inner, &value) {
+            writeln!(out, "\t\t{var}C := {lower}")?;
+        } else if self.is_char(inner) {
+            writeln!(out, "\t\t{var}C := lowerRune({value})")?;
+        } else {
+            writeln!(out, "\t\t{var}C := {c_ty}({value})")?;
+        }
+        writeln!(out, "\t\t{var}Arg = &{var}C")?;
+        writeln!(out, "\t}}")?;
+
+        Ok(())
+    }
+
+    /// The zero values (with a trailing `", "`) that precede the error in a
+    /// failing return, or `None` if the Go function does not return an error.
+    fn error_return_zeros(
+        &self,
+        ef: &ExportedFunction,
+        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
+    ) -> Option<String> {
+        match result_decomposed {
+            Some((Some(ok_ty), _)) if self.is_handle(ok_ty) => Some("nil, ".to_string()),
+            Some((Some(ok_ty), _)) if self.borrows_string(ef, ok_ty) => {
+                Some("BorrowedString{}, ".to_string())
+            }
+            Some((Some(ok_ty), _)) if self.list_view(ef, ok_ty).is_some() => {
+                Some("nil, ".to_string())
+            }
+            Some((Some(ok_ty), _)) => Some(format!("{}, ", self.return_zeros(ok_ty))),
+            Some((None, _)) => Some(String::new()),
+            None => ef
+                .function
+                .result
+                .filter(|ty| self.lifts_char(ty))
+                .map(|ty| format!("{}, ", self.return_zeros(&ty))),
+        }
+    }
+
+    /// The zero values returned for a value of `ty`, one per return value.
+    fn return_zeros(&self, ty: &Type) -> String {
+        match self.result_values(ty) {
+            Some(values) => values
+                .iter()
+                .map(|(_, t)| self.go_zero_value(t))
+                .collect::<Vec<_>>()
+                .join(", "),
+            None => self.go_zero_value(ty),
+        }
+    }
+
+    /// Generate the failure return of a `result<T, E>` call.
+    ///
+    /// `zeros` holds the zero values preceding the error (with a trailing
+    /// `", "`). Typed errors are decoded from `errPtr` when the callee set it;
+    /// otherwise, e.g. after a panic, the last error message is used.
+    fn generate_error_return(
+        &self,
+        out: &mut String,
+        c_func_name: &str,
+        zeros: &str,
+        typed_error: Option<Type>,
+    ) -> std::fmt::Result {
+        if let Some(err_ty) = typed_error {
+            writeln!(out, "\t\tif errPtr != nil {{")?;
+            let conversion = self.convert_ffi_to_go(&err_ty, "*errPtr");
+            let nests_char = self.nests_char(&err_ty);
+            if nests_char {
+                let lift = self.lift_chars_expr(&err_ty, &conversion);
+                writeln!(out, "\t\t\terr, charErr := {lift}")?;
+            } else {
+                writeln!(out, "\t\t\terr := {conversion}")?;
+            }
+            // Enums and error contexts hold nothing left to free once lifted
+            let plain = match err_ty {
+                Type::Id(err_id) => matches!(self.resolve.types[err_id].kind, TypeDefKind::Enum(_)),
+                _ => true,
+            };
+            if plain {
+                writeln!(out, "\t\t\t{}", self.free_rust_box("errPtr"))?;
+            } else {
+                writeln!(out, "\t\t\tC.{}(errPtr)", self.result_free_func(&err_ty))?;
+            }
+            if nests_char {
+                writeln!(out, "\t\t\tif charErr != nil {{")?;
+                writeln!(out, "\t\t\t\treturn {zeros}charErr")?;
+                writeln!(out, "\t\t\t}}")?;
+            }
+            writeln!(out, "\t\t\treturn {zeros}err")?;
+            writeln!(out, "\t\t}}")?;
+        }
+        writeln!(out, "\t\treturn {zeros}{}", self.last_error(c_func_name))?;
+        Ok(())
+    }
+
+    /// The Go return types for a function result; with
+    /// `multi_value_results`, tuples expand to one return value per element,
+    /// and `named_results` records to one per field.
+    fn go_return_types(&self, ty: &Type) -> Vec<String> {
+        match self.result_values(ty) {
+            Some(values) => values.iter().map(|(_, t)| self.type_to_go(t)).collect(),
+            None => vec![self.type_to_go(ty)],
+        }
+    }
+
+    /// The Go return expression(s) for a converted result held in `var`.
+    fn go_return_values(&self, ty: &Type, var: &str) -> String {
+        match self.result_values(ty) {
+            Some(values) => values
+                .iter()
+                .map(|(field, _)| format!("{var}.{field}"))
+                .collect::<Vec<_>>()
+                .join(", "),
+            None => var.to_string(),
+        }
+    }
+
+    /// The Go struct fields and types of a result returned as multiple
+    /// values, if it is.
+    fn result_values(&self, ty: &Type) -> Option<Vec<(String, Type)>> {
+        if let Some(record) = self.named_result_record(ty) {
+            return Some(
+                record
+                    .fields
+                    .iter()
+                    .map(|f| (self.config.naming.to_go_field(&f.name), f.ty))
+                    .collect(),
+            );
+        }
+        if !self.config.multi_value_results {
+            return None;
+        }
+        let elems = self.tuple_elems(ty)?;
+        Some(
+            elems
+                .iter()
+                .enumerate()
+                .map(|(i, t)| (format!("F{i}"), *t))
+                .collect(),
+        )
+    }
+
+    /// The record behind `ty`, following aliases, if it is listed in
+    /// `named_results`.
+    fn named_result_record(&self, ty: &Type) -> Option<&wit_parser::Record> {
+        let Type::Id(id) = self.resolve_to_leaf(ty) else {
+            return None;
+        };
+        let typedef = &self.resolve.types[*id];
+        match &typedef.kind {
+            TypeDefKind::Record(record)
+                if typedef
+                    .name
+                    .as_ref()
+                    .is_some_and(|name| self.config.named_results.contains(name)) =>
+            {
+                Some(record)
+            }
+            _ => None,
+        }
+    }
+
+    /// The names of the Go return values for a `named_results` record
+    /// result, followed by `err` for the trailing error if there is one.
+    ///
+    /// Names are the record's field names, suffixed with `Value` where they
+    /// would clash with a parameter, a local of the generated body (locals
+    /// derived from a parameter start with its name) or an imported package.
+    fn named_returns(&self, ty: &Type, param_names: &[String]) -> Option<Vec<String>> {
+        const RESERVED: &[&str] = &[
+            "ctx",
+            "callCtx",
+            "context",
+            "err",
+            "errPtr",
+            "result",
+            "resultPtr",
+            "values",
+            "allocs",
+            "C",
+            "big",
+            "fmt",
+            "runtime",
+            "sort",
+            "time",
+            "unsafe",
+            "utf8",
+        ];
+        let record = self.named_result_record(ty)?;
+        let mut names: Vec<String> = record
+            .fields
+            .iter()
+            .map(|f| {
+                let name = self.config.naming.to_go_ident(&f.name);
+                let derived = |p: &String| {
+                    name.strip_prefix(p.as_str())
+                        .is_some_and(|rest| rest.is_empty() || rest.starts_with(char::is_uppercase))
+                };
+                if RESERVED.contains(&name.as_str()) || param_names.iter().any(derived) {
+                    format!("{name}Value")
+                } else {
+                    name
+                }
+            })
+            .collect();
+        names.push("err".to_string());
+        Some(names)
+    }
+
+    /// The element types of a tuple, following aliases. 128-bit integers are
+    /// not treated as tuples.
+    fn tuple_elems(&self, ty: &Type) -> Option<&[Type]> {
+        if self.wide_int(ty).is_some() {
+            return None;
+        }
+        match self.resolve_to_leaf(ty) {
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::Tuple(tuple) => Some(&tuple.types),
+                _ => None,
+            },
+            _ => None,
+        }
+    }
+
+    /// The element type and length of a fixed-length list, following aliases.
+    fn array_elems(&self, ty: &Type) -> Option<(&Type, u32)> {
+        match self.resolve_to_leaf(ty) {
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::FixedLengthList(elem, len) => Some((elem, *len)),
+                _ => None,
+            },
+            _ => None,
+        }
+    }
+
+    /// Flatten a parameter into `(go_expr, var_prefix, type)` leaves, mirroring
+    /// how the C ABI passes tuple and fixed-length list parameters element by
+    /// element.
+    fn flatten_param(
+        &self,
+        expr: &str,
+        var: &str,
+        ty: &Type,
+        out: &mut Vec<(String, String, Type)>,
+    ) {
+        if self.mapped_conversion(ty).is_some() {
+            // Lowered to the aliased type first (see `lower_mapped_params`)
+            out.push((expr.to_string(), var.to_string(), *ty));
+        } else {
+            self.flatten_structural(expr, var, ty, out);
+        }
+    }
+
+    /// Flatten a parameter by the structure of its type, ignoring any type
+    /// mapping on the type itself.
+    fn flatten_structural(
+        &self,
+        expr: &str,
+        var: &str,
+        ty: &Type,
+        out: &mut Vec<(String, String, Type)>,
+    ) {
+        if let Some(elems) = self.tuple_elems(ty) {
+            for (i, elem) in elems.iter().enumerate() {
+                self.flatten_param(&format!("{expr}.F{i}"), &format!("{var}F{i}"), elem, out);
+            }
+        } else if let Some((elem, len)) = self.array_elems(ty) {
+            for i in 0..len {
+                self.flatten_param(&format!("{expr}[{i}]"), &format!("{var}E{i}"), elem, out);
+            }
+        } else {
+            out.push((expr.to_string(), var.to_string(), *ty));
+        }
+    }
+
+    /// Lower custom-mapped parameters to the Go type they alias, via
+    /// `{var}Raw` locals, and flatten the results.
+    fn lower_mapped_params(
+        &self,
+        out: &mut String,
+        params: Vec<(String, String, Type)>,
+    ) -> Result<Vec<(String, String, Type)>, std::fmt::Error> {
+        let mut flat = Vec::new();
+        for (expr, var, ty) in params {
+            match self.mapped_conversion(&ty) {
+                Some(mapped) => {
+                    writeln!(out, "\t{var}Raw := {}({expr})", mapped.lower)?;
+                    let raw = format!("{var}Raw");
+                    let mut inner = Vec::new();
+                    if mapped.bare {
+                        self.flatten_structural(&raw, &var, &mapped.inner, &mut inner);
+                    } else {
+                        self.flatten_param(&raw, &var, &mapped.inner, &mut inner);
+                    }
+                    for leaf in inner {
+                        // A bare mapped type is already lowered to its structure
+                        if leaf.2 == ty {
+                            flat.push(leaf);
+                        } else {
+                            flat.extend(self.lower_mapped_params(out, vec![leaf])?);
+                        }
+                    }
+                }
+                None => flat.push((expr, var, ty)),
+            }
+        }
+        Ok(flat)
+    }
+
+    /// Generate Go code to marshal a parameter into an FfiByteSlice.
+    ///
+    /// `go_expr` is the Go value to marshal and `var` names the generated
+    /// `{var}Slice` local.
+    fn generate_param_marshaling(
+        &self,
+        out: &mut String,
+        go_expr: &str,
+        var: &str,
+        ty: &Type,
+    ) -> std::fmt::Result {
+        if self.is_option_type(ty) {
+            return self.generate_option_param_marshaling(out, go_expr, var, ty);
+        }
+        if witffi_core::is_byte_stream(self.resolve, ty) {
+            writeln!(out, "\t{var}Source, {var}Handle := byteSource({go_expr})")?;
+            writeln!(out, "\tdefer {var}Handle.Delete()")?;
+            return Ok(());
+        }
+        if let Some(lower) = self.wide_lower_func(ty) {
+            writeln!(out, "\t{var}Wide := {lower}({go_expr})")?;
+            return Ok(());
+        }
+        if let Some(list_id) = self.lowered_list(ty) {
+            let value = if self.map_entry(ty).is_some() {
+                format!("ffiMapToPairs({go_expr})")
+            } else {
+                go_expr.to_string()
+            };
+            writeln!(
+                out,
+                "\t{var}List := lower{}({value}, allocs)",
+                self.shape_name(list_id)
+            )?;
+            return Ok(());
+        }
+        if !self.param_needs_marshaling(ty) {
+            return Ok(());
+        }
+
+        match self.resolve_to_leaf(ty) {
+            Type::String => {
+                // Named string types convert back to `string` first
+                let data = if self.type_to_go(ty) == "string" {
+                    go_expr.to_string()
+                } else {
+                    format!("string({go_expr})")
+                };
+                writeln!(out, "\t{var}Slice := C.FfiByteSlice{{")?;
+                writeln!(
+                    out,
+                    "\t\tptr: (*C.uint8_t)(unsafe.Pointer(unsafe.StringData({data}))),",
+                )?;
+                writeln!(out, "\t\tlen: C.uintptr_t(len({go_expr})),")?;
+                writeln!(out, "\t}}")?;
+            }
+            _ => {
+                // list<u8> or other byte-slice types
+                writeln!(out, "\t{var}Slice := C.FfiByteSlice{{")?;
+                writeln!(
+                    out,
+                    "\t\tptr: (*C.uint8_t)(unsafe.Pointer(unsafe.SliceData({go_expr}))),",
+                )?;
+                writeln!(out, "\t\tlen: C.uintptr_t(len({go_expr})),")?;
+                writeln!(out, "\t}}")?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// The helper lowering a Go 128-bit integer, if `ty` is one.
+    fn wide_lower_func(&self, ty: &Type) -> Option<&'static str> {
+        self.wide_int(ty)?;
+        Some(if self.is_big_int(ty) {
+            "ffiBigIntToWide"
+        } else {
+            "ffiBytesToWide"
+        })
+    }
+
+    /// The list typedef behind a parameter lowered through `lower{Shape}`,
+    /// following aliases. Byte lists are marshaled as `FfiByteSlice` instead.
+    fn lowered_list(&self, ty: &Type) -> Option<TypeId> {
+        match self.resolve_to_leaf(ty) {
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::List(elem) if *elem != Type::U8 => Some(*id),
+                _ => None,
+            },
+            _ => None,
+        }
+    }
+
+    /// Check if a parameter type needs marshaling (String/[]byte → FfiByteSlice).
+    fn param_needs_marshaling(&self, ty: &Type) -> bool {
+        match ty {
+            Type::String => true,
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => true,
+                    TypeDefKind::Type(aliased) => self.param_needs_marshaling(aliased),
+                    _ => false,
+                }
+            }
+            _ => false,
+        }
+    }
+
+    /// Follow type aliases to the leaf type.
+    fn resolve_to_leaf<'b>(&'b self, ty: &'b Type) -> &'b Type {
+        if let Type::Id(id) = ty {
+            let typedef = &self.resolve.types[*id];
+            if let TypeDefKind::Type(aliased) = &typedef.kind {
+                return self.resolve_to_leaf(aliased);
+            }
+        }
+        ty
+    }
+
+    /// Get the Go zero value for a type (used in error returns).
+    fn go_zero_value(&self, ty: &Type) -> String {
+        if let Some(mapped) = self.mapped_conversion(ty) {
+            return if mapped.go_type.starts_with('*') {
+                "nil".to_string()
+            } else {
+                format!("*new({})", mapped.go_type)
+            };
+        }
+        match ty {
+            Type::Bool => "false".to_string(),
+            Type::U8
+            | Type::U16
+            | Type::U32
+            | Type::U64
+            | Type::S8
+            | Type::S16
+            | Type::S32
+            | Type::S64
+            | Type::F32
+            | Type::F64
+            | Type::Char => "0".to_string(),
+            Type::String => "\"\"".to_string(),
+            Type::Id(_) if self.is_big_int(ty) => "nil".to_string(),
+            Type::Id(_) if self.wide_int(ty).is_some() => "[16]byte{}".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(_) => "nil".to_string(),
+                    TypeDefKind::Option(inner) => self.none_expr(inner),
+                    TypeDefKind::Variant(_) => "nil".to_string(),
+                    TypeDefKind::Type(aliased) => self.go_zero_value(aliased),
+                    TypeDefKind::Record(_) => {
+                        let name = typedef.name.as_deref().unwrap_or("Anonymous");
+                        format!("{}{{}}", self.config.naming.to_go_type(name))
                     }
-                    writeln!(out, "func ({case_name}) {marker_method}() {{}}")?;
+                    TypeDefKind::Tuple(_)
+                    | TypeDefKind::FixedLengthList(..)
+                    | TypeDefKind::Result(_) => {
+                        format!("{}{{}}", self.type_to_go(ty))
+                    }
+                    TypeDefKind::Enum(_) | TypeDefKind::Flags(_) => "0".to_string(),
+                    _ => "nil".to_string(),
+                }
+            }
+            Type::ErrorContext => "nil".to_string(),
+        }
+    }
+
+    /// Get the C free function name for a result ok type.
+    fn result_free_func(&self, ty: &Type) -> String {
+        match ty {
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
+                        let name = typedef.name.as_deref().unwrap_or("anonymous");
+                        names::to_c_func(&self.config.c_prefix, &format!("free-{name}"))
+                    }
+                    TypeDefKind::Type(aliased) => self.result_free_func(aliased),
+                    TypeDefKind::Tuple(tuple) => format!(
+                        "{}_free_{}",
+                        self.c_func_prefix(),
+                        witffi_core::tuple_shape_name(self.resolve, tuple).to_snake_case()
+                    ),
+                    TypeDefKind::FixedLengthList(elem, len) => format!(
+                        "{}_free_{}",
+                        self.c_func_prefix(),
+                        witffi_core::fixed_list_shape_name(self.resolve, elem, *len)
+                            .to_snake_case()
+                    ),
+                    // Converting a list or option frees its contents, leaving
+                    // only the box around it
+                    TypeDefKind::List(elem) if *elem != Type::U8 => "free".to_string(),
+                    TypeDefKind::Option(_) => "free".to_string(),
+                    _ => format!("{}_free_byte_buffer", self.c_func_prefix()),
+                }
+            }
+            Type::String => format!("{}_free_byte_buffer", self.c_func_prefix()),
+            _ => "free".to_string(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::path::Path;
+
+    use super::*;
+
+    #[test]
+    fn test_generate_go_from_eip681() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) =
+            witffi_core::load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        let config = GoConfig {
+            c_prefix: "zcash_eip681".to_string(),
+            c_type_prefix: "Ffi".to_string(),
+            go_package: None,
+            lib_name: "eip681_ffi".to_string(),
+            ..GoConfig::default()
+        };
+
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        // Package declaration
+        assert!(
+            code.contains("package eip681"),
+            "missing package declaration"
+        );
+
+        // CGo preamble
+        assert!(
+            code.contains("#cgo LDFLAGS: -leip681_ffi"),
+            "missing cgo LDFLAGS"
+        );
+        assert!(code.contains("import \"C\""), "missing import C");
+
+        // Helpers
+        assert!(
+            code.contains("func ffiByteBufferToString"),
+            "missing ffiByteBufferToString"
+        );
+        assert!(
+            code.contains("func ffiByteBufferToBytes"),
+            "missing ffiByteBufferToBytes"
+        );
+        assert!(code.contains("func readLastError"), "missing readLastError");
+
+        // Types
+        assert!(
+            code.contains("type NativeRequest struct"),
+            "missing NativeRequest"
+        );
+        assert!(
+            code.contains("type Erc20Request struct"),
+            "missing Erc20Request"
+        );
+        assert!(
+            code.contains("type transactionRequestVariant interface"),
+            "missing variant interface"
+        );
+        assert!(
+            code.contains("type TransactionRequestNative struct"),
+            "missing TransactionRequestNative"
+        );
+        assert!(
+            code.contains("type TransactionRequest = transactionRequestVariant"),
+            "missing TransactionRequest alias"
+        );
+
+        // Conversion functions
+        assert!(
+            code.contains("func convertNativeRequest"),
+            "missing convertNativeRequest"
+        );
+        assert!(
+            code.contains("func convertTransactionRequest"),
+            "missing convertTransactionRequest"
+        );
+
+        // Public API — parser interface
+        assert!(
+            code.contains("func ParserParse("),
+            "missing ParserParse function"
+        );
+        assert!(
+            code.contains("(TransactionRequest, error)"),
+            "missing result return type"
+        );
+
+        // Public API — functions interface
+        assert!(
+            code.contains("func FunctionsU256ToString("),
+            "missing FunctionsU256ToString function"
+        );
+        assert!(
+            code.contains("C.zcash_eip681_functions_u256_to_string"),
+            "missing C call for u256_to_string"
+        );
+    }
+
+    #[test]
+    fn test_go_type_mapping() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) =
+            witffi_core::load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        let config = GoConfig::default();
+        let generator = GoGenerator::new(&resolve, world_id, config);
+
+        assert_eq!(generator.type_to_go(&Type::String), "string");
+        assert_eq!(generator.type_to_go(&Type::Bool), "bool");
+        assert_eq!(generator.type_to_go(&Type::U64), "uint64");
+        assert_eq!(generator.type_to_go(&Type::S32), "int32");
+        assert_eq!(generator.type_to_go(&Type::F64), "float64");
+    }
+
+    #[test]
+    fn test_go_package_name_derivation() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) =
+            witffi_core::load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        // Default: derived from world name
+        let config = GoConfig {
+            go_package: None,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        assert_eq!(generator.package_name(), "eip681");
+
+        // Override
+        let config2 = GoConfig {
+            go_package: Some("mypkg".to_string()),
+            ..GoConfig::default()
+        };
+        let generator2 = GoGenerator::new(&resolve, world_id, config2);
+        assert_eq!(generator2.package_name(), "mypkg");
+    }
+
+    const COUNTER_WIT: &str = r#"
+        package test:counter;
+
+        interface types {
+            /// A monotonically increasing counter.
+            resource counter {
+                constructor(start: u64);
+                get: func() -> u64;
+                increment: func(by: u64);
+                merge: static func(a: counter, b: borrow<counter>) -> counter;
+            }
+
+            try-counter: func(start: string) -> result<counter, string>;
+        }
+
+        world counter {
+            export types;
+        }
+    "#;
+
+    fn load_counter_wit() -> (Resolve, WorldId) {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("counter.wit", COUNTER_WIT)
+            .expect("failed to parse counter WIT");
+        let world_id = resolve.packages[pkg_id].worlds["counter"];
+        (resolve, world_id)
+    }
+
+    #[test]
+    fn test_generate_go_resources() {
+        let (resolve, world_id) = load_counter_wit();
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        // Handle wrapper
+        assert!(
+            code.contains("type Counter struct {"),
+            "missing Counter handle wrapper"
+        );
+        assert!(
+            code.contains("// Counter is a monotonically increasing counter."),
+            "missing resource doc comment"
+        );
+        assert!(
+            code.contains("func (c *Counter) Close() {"),
+            "missing Close method"
+        );
+        assert!(
+            code.contains("C.witffi_types_counter_drop(c.handle)"),
+            "Close should call the drop function"
+        );
+
+        // Constructor
+        assert!(
+            code.contains("func NewCounter(start uint64) *Counter {"),
+            "missing constructor"
+        );
+        assert!(
+            code.contains("return wrapCounter(result)"),
+            "constructor should wrap the returned handle"
+        );
+
+        // Methods use the handle as receiver
+        assert!(code.contains(") Get() uint64 {"), "missing Get method");
+        assert!(
+            code.contains("C.witffi_types_counter_get(c.handle)"),
+            "method should pass the receiver handle"
+        );
+        assert!(
+            code.contains(") Increment(by uint64) {"),
+            "missing Increment method"
+        );
+
+        // Statics, own and borrow arguments
+        assert!(
+            code.contains("func CounterMerge(a *Counter, b "),
+            "missing static function"
+        );
+        assert!(
+            code.contains("\ta.handle = nil"),
+            "own argument should be consumed"
+        );
+        assert!(
+            !code.contains("\tb.handle = nil"),
+            "borrow argument should not be consumed"
+        );
+
+        // result<own<T>, E>
+        assert!(
+            code.contains("func TypesTryCounter(start string) (*Counter, error) {"),
+            "missing result-returning resource function"
+        );
+        assert!(
+            code.contains(
+                "return nil, fmt.Errorf(\"witffi_types_try_counter failed: %s\", readLastError())"
+            ),
+            "result<own<T>> should return nil on error"
+        );
+        // The error is read back on the thread that made the call
+        assert!(
+            code.contains(
+                "func TypesTryCounter(start string) (*Counter, error) {\n\truntime.LockOSThread()\n\tdefer runtime.UnlockOSThread()\n"
+            ),
+            "fallible functions should hold their goroutine on its thread"
+        );
+        assert!(
+            code.contains("func NewCounter(start uint64) *Counter {\n\tresult"),
+            "infallible functions should not hold their goroutine on its thread"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_borrowed_handles() {
+        let (resolve, world_id) = load_counter_wit();
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        // Owned handles embed their borrowed view
+        assert!(
+            code.contains("type CounterRef struct {\n\thandle *C.FfiCounter\n"),
+            "missing CounterRef borrowed handle"
+        );
+        assert!(
+            code.contains("type Counter struct {\n\tCounterRef\n"),
+            "missing Counter owned handle wrapper"
+        );
+        assert!(
+            code.contains("func (c *Counter) Borrow() CounterRef {"),
+            "missing Borrow method"
+        );
+
+        // Methods borrow the handle as receiver
+        assert!(
+            code.contains("func (c CounterRef) Get() uint64 {"),
+            "Get should take a borrowed receiver"
+        );
+        assert!(
+            code.contains("func (c CounterRef) Increment(by uint64) {"),
+            "Increment should take a borrowed receiver"
+        );
+
+        // own<T> arguments take the owner, borrow<T> arguments the view
+        assert!(
+            code.contains("func CounterMerge(a *Counter, b CounterRef) *Counter {"),
+            "borrow argument should take a CounterRef"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_resource_cleanup() {
+        let (resolve, world_id) = load_counter_wit();
+
+        // Manual cleanup is the default and needs no runtime support
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+        assert!(
+            !code.contains("runtime.AddCleanup") && !code.contains("runtime.SetFinalizer"),
+            "manual cleanup should not register cleanups"
+        );
+
+        // AddCleanup registers a cleanup on every wrapped handle
+        let config = GoConfig {
+            resource_cleanup: ResourceCleanup::AddCleanup,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+        assert!(code.contains("\t\"runtime\""), "missing runtime import");
+        assert!(
+            code.contains("\tcleanup runtime.Cleanup"),
+            "missing cleanup field"
+        );
+        assert!(
+            code.contains(
+                "runtime.AddCleanup(c, func(h *C.FfiCounter) { C.witffi_types_counter_drop(h) }, handle)"
+            ),
+            "missing AddCleanup registration"
+        );
+        assert!(
+            code.contains("\tc.cleanup.Stop()"),
+            "Close should stop the cleanup"
+        );
+        assert!(
+            code.contains("return wrapCounter(result)"),
+            "returned handles should be wrapped"
+        );
+        assert!(
+            code.contains("\ta.cleanup.Stop()\n\ta.handle = nil"),
+            "consumed handles should stop their cleanup"
+        );
+
+        // SetFinalizer fallback
+        let config = GoConfig {
+            resource_cleanup: ResourceCleanup::Finalizer,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        assert!(
+            code.contains("runtime.SetFinalizer(c, (*Counter).Close)"),
+            "missing SetFinalizer registration"
+        );
+        assert!(
+            code.contains("runtime.SetFinalizer(c, nil)"),
+            "Close should clear the finalizer"
+        );
+
+        // Per-resource override back to manual
+        let config = GoConfig {
+            resource_cleanup: ResourceCleanup::AddCleanup,
+            resource_cleanup_overrides: HashMap::from([(
+                "counter".to_string(),
+                ResourceCleanup::Manual,
+            )]),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        assert!(
+            !code.contains("runtime.AddCleanup"),
+            "override should disable cleanup for counter"
+        );
+        assert!(
+            !code.contains("runtime.SetFinalizer"),
+            "override should not fall back to a finalizer"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_flags() {
+        let source = r#"
+            package test:flags;
+
+            interface files {
+                flags permissions {
+                    read,
+                    write,
+                    exec,
+                }
+
+                get-permissions: func(path: string) -> permissions;
+                set-permissions: func(path: string, perms: permissions);
+            }
+
+            world filesystem {
+                export files;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("flags.wit", source)
+            .expect("failed to parse flags WIT");
+        let world_id = resolve.packages[pkg_id].worlds["filesystem"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type Permissions uint32"),
+            "missing Permissions type"
+        );
+        assert!(
+            code.contains("\tPermissionsRead Permissions = 1 << iota"),
+            "missing bit constants"
+        );
+        assert!(
+            code.contains("func (f Permissions) Has(other Permissions) bool {"),
+            "missing Has helper"
+        );
+        assert!(
+            code.contains("func (f *Permissions) Set(other Permissions) {"),
+            "missing Set helper"
+        );
+        assert!(
+            code.contains("func (f *Permissions) Clear(other Permissions) {"),
+            "missing Clear helper"
+        );
+        assert!(
+            code.contains("func (f Permissions) String() string {"),
+            "missing Stringer"
+        );
+        assert!(
+            code.contains(
+                "var permissionsFlagNames = [...]string{\n\t\"read\",\n\t\"write\",\n\t\"exec\",\n}"
+            ),
+            "missing flag name table"
+        );
+
+        // Flags cross the C ABI as plain integers
+        assert!(
+            code.contains("return Permissions(result)"),
+            "flags results should convert directly"
+        );
+        assert!(
+            code.contains("C.FfiPermissions(perms)"),
+            "flags params should convert directly"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_enums() {
+        let source = r#"
+            package test:colors;
+
+            interface palette {
+                enum color {
+                    red,
+                    dark-green,
+                }
+
+                complement: func(c: color) -> color;
+            }
+
+            world colors {
+                export palette;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("colors.wit", source)
+            .expect("failed to parse colors WIT");
+        let world_id = resolve.packages[pkg_id].worlds["colors"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(code.contains("\t\"fmt\""), "enums need the fmt import");
+        assert!(
+            code.contains("\tColorRed Color = iota\n\tColorDarkGreen\n"),
+            "missing iota constants"
+        );
+        assert!(
+            code.contains("var colorNames = [...]string{\n\t\"red\",\n\t\"dark-green\",\n}"),
+            "missing name table"
+        );
+        assert!(
+            code.contains("func (e Color) String() string {"),
+            "missing Stringer"
+        );
+        assert!(
+            code.contains("func ParseColor(s string) (Color, error) {"),
+            "missing ParseColor"
+        );
+        assert!(
+            code.contains("func AllColorValues() []Color {"),
+            "missing AllColorValues"
+        );
+        assert!(
+            code.contains("return Color(result)"),
+            "enum results should convert directly"
+        );
+        assert!(
+            code.contains(
+                "func lowerColor(e Color) C.FfiColor {\n\tif int(e) >= len(colorNames) {\n\t\tpanic(fmt.Sprintf(\"invalid Color: %d\", uint32(e)))\n\t}"
+            ) && code.contains("C.witffi_palette_complement(lowerColor(c))"),
+            "enum params should be range-checked before reaching C"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_tuples() {
+        let source = r#"
+            package test:tuples;
+
+            interface stats {
+                record entry {
+                    key: string,
+                    range: tuple<u32, u32>,
+                }
+
+                lookup: func(key: string) -> tuple<string, u64>;
+                try-lookup: func(key: string) -> result<tuple<string, u64>, string>;
+                store: func(item: tuple<string, u64>) -> bool;
+                first: func() -> entry;
+            }
+
+            world tuples {
+                export stats;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tuples.wit", source)
+            .expect("failed to parse tuples WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tuples"];
+
+        let config = GoConfig {
+            multi_value_results: true,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert_eq!(
+            code.matches("type TupleOf2[T0, T1 any] struct {").count(),
+            1,
+            "one TupleOf2 per arity"
+        );
+        assert!(
+            code.contains("\tRange TupleOf2[uint32, uint32]"),
+            "record fields should use TupleOfN"
+        );
+        assert_eq!(
+            code.matches("func convertTuple2StringU64(").count(),
+            1,
+            "identical tuples should share one conversion"
+        );
+
+        // Tuple results become multiple return values
+        assert!(
+            code.contains("func StatsLookup(key string) (string, uint64) {"),
+            "tuple results should be multiple return values"
+        );
+        assert!(
+            code.contains("return values.F0, values.F1"),
+            "tuple results should be unpacked"
+        );
+        assert!(
+            code.contains("func StatsTryLookup(key string) (string, uint64, error) {"),
+            "result<tuple> should return the elements and an error"
+        );
+        assert!(
+            code.contains("return \"\", 0, fmt.Errorf("),
+            "result<tuple> errors should return zero elements"
+        );
+        assert!(
+            code.contains("C.witffi_free_tuple2_string_u64(resultPtr)"),
+            "result<tuple> should free the boxed tuple"
+        );
+
+        // Tuple params are flattened
+        assert!(
+            code.contains("func StatsStore(item TupleOf2[string, uint64]) bool {"),
+            "tuple params should use TupleOfN"
+        );
+        assert!(
+            code.contains("unsafe.StringData(item.F0)"),
+            "tuple string elements should be marshalled"
+        );
+        assert!(
+            code.contains("C.witffi_stats_store(itemF0Slice, C.uint64_t(item.F1))"),
+            "tuple params should be passed element-wise"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_nested_variants() {
+        let source = r#"
+            package test:nested;
+
+            interface exprs {
+                resource node {
+                    children: func() -> list<u8>;
+                }
+
+                variant leaf {
+                    number(s64),
+                    name(string),
+                }
+
+                variant tree {
+                    leaf(leaf),
+                    pair(tuple<leaf, leaf>),
+                    node(node),
+                    empty,
+                }
+
+                root: func() -> result<tree, string>;
+            }
+
+            world nested {
+                export exprs;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("nested.wit", source)
+            .expect("failed to parse nested WIT");
+        let world_id = resolve.packages[pkg_id].worlds["nested"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("return TreeLeaf{Value: convertLeaf(ffi.leaf.value)}"),
+            "nested variant payloads should convert recursively"
+        );
+        assert!(
+            code.contains(
+                "return TreePair{F0: convertLeaf(ffi.pair.f0), F1: convertLeaf(ffi.pair.f1)}"
+            ),
+            "tuple payloads should convert field by field"
+        );
+        assert!(
+            code.contains("F0: convertLeaf(ffi.f0),"),
+            "variants inside tuples should convert recursively"
+        );
+        assert!(
+            code.contains("return TreeNode{Value: wrapNode(ffi.node.value)}"),
+            "resource payloads should be wrapped"
+        );
+        assert!(
+            code.contains("C.witffi_free_tree(resultPtr)"),
+            "the outer variant should be freed after conversion"
+        );
+    }
+
+    const OPTIONS_WIT: &str = r#"
+        package test:options;
+
+        interface lookup {
+            record profile {
+                name: string,
+                avatar: option<list<u8>>,
+                age: option<u32>,
+            }
+
+            find: func(name: option<string>, limit: option<u32>) -> option<string>;
+            first: func() -> result<profile, string>;
+        }
+
+        world options {
+            export lookup;
+        }
+    "#;
+
+    fn generate_options(config: GoConfig) -> String {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("options.wit", OPTIONS_WIT)
+            .expect("failed to parse options WIT");
+        let world_id = resolve.packages[pkg_id].worlds["options"];
+
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+        code
+    }
+
+    #[test]
+    fn test_generate_go_pointer_options() {
+        let code = generate_options(GoConfig::default());
+
+        assert!(
+            !code.contains("type Option[T any]"),
+            "Option[T] should only be emitted when enabled"
+        );
+        assert!(
+            code.contains("\tAvatar *[]byte"),
+            "optional fields should be pointers by default"
+        );
+        assert!(
+            code.contains("\t\tv := ffiByteBufferToBytes(*ffi.avatar)\n\t\tresult.Avatar = &v"),
+            "optional byte fields should take the address of the value"
+        );
+        assert!(
+            code.contains("func LookupFind(name *string, limit *uint32) *string {"),
+            "optional params and results should be pointers"
+        );
+        assert!(
+            code.contains("\tvar nameArg *C.FfiByteSlice\n\tif name != nil {"),
+            "optional string params should be marshalled when present"
+        );
+        assert!(
+            code.contains("defer C.free(nameData)"),
+            "optional string params should be copied to C memory"
+        );
+        assert!(
+            code.contains("limitC := C.uint32_t(limitValue)"),
+            "optional primitive params should be converted"
+        );
+        assert!(
+            code.contains("C.witffi_lookup_find(nameArg, limitArg)"),
+            "optional params should be passed as nullable pointers"
+        );
+        assert!(
+            code.contains("\tif result == nil {\n\t\treturn nil\n\t}"),
+            "absent optional results should be nil"
+        );
+        assert!(
+            code.contains("\treturn &value"),
+            "present optional results should be pointers"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_generic_options() {
+        let code = generate_options(GoConfig {
+            generic_options: true,
+            ..GoConfig::default()
+        });
+
+        assert!(
+            code.contains("type Option[T any] struct {"),
+            "missing Option[T]"
+        );
+        for func in [
+            "func Some[T any](v T) Option[T] {",
+            "func None[T any]() Option[T] {",
+            "func (o Option[T]) Get() (T, bool) {",
+            "func (o Option[T]) OrElse(fallback T) T {",
+        ] {
+            assert!(code.contains(func), "missing {func}");
+        }
+        assert!(
+            code.contains("\tAvatar Option[[]byte]"),
+            "optional fields should use Option[T]"
+        );
+        assert!(
+            code.contains("result.Avatar = Some(v)"),
+            "present optional fields should be wrapped in Some"
+        );
+        assert!(
+            code.contains(
+                "func LookupFind(name Option[string], limit Option[uint32]) Option[string] {"
+            ),
+            "optional params and results should use Option[T]"
+        );
+        assert!(
+            code.contains("\tif nameValue, ok := name.Get(); ok {"),
+            "optional params should be unpacked with Get"
+        );
+        assert!(
+            code.contains("\t\treturn None[string]()"),
+            "absent optional results should be None"
+        );
+        assert!(
+            code.contains("\treturn Some(value)"),
+            "present optional results should be Some"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_typed_errors() {
+        let source = r#"
+            package test:errors;
+
+            interface parser {
+                variant parse-error {
+                    empty,
+                    invalid-char(u32),
+                }
+
+                record limit-error {
+                    max: u64,
+                }
+
+                enum io-error {
+                    closed,
+                    timeout,
+                }
+
+                parse: func(input: string) -> result<u64, parse-error>;
+                check: func(input: string) -> result<_, limit-error>;
+                read: func() -> result<string, io-error>;
+                describe: func(input: string) -> result<string, string>;
+            }
+
+            world errors {
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("errors.wit", source)
+            .expect("failed to parse errors WIT");
+        let world_id = resolve.packages[pkg_id].worlds["errors"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        // Error types implement `error`
+        assert!(
+            code.contains("type parseErrorVariant interface {\n\tisParseError()\n\terror\n}"),
+            "variant errors should embed error in the marker interface"
+        );
+        assert!(
+            code.contains("func (e ParseErrorInvalidChar) Error() string {"),
+            "variant cases should implement error"
+        );
+        assert!(
+            code.contains("return \"parse-error empty\""),
+            "payload-less cases should name the case"
+        );
+        assert!(
+            code.contains("func (e LimitError) Error() string {\n\ttype plain LimitError"),
+            "record errors should implement error"
+        );
+        assert!(
+            code.contains("func (e IoError) Error() string {\n\treturn e.String()"),
+            "enum errors should implement error"
+        );
+
+        // Typed errors are decoded from err_out
+        assert!(
+            code.contains("\tvar errPtr *C.FfiParseError\n"),
+            "typed errors should declare an err_out pointer"
+        );
+        assert!(
+            code.contains("C.witffi_parser_parse(inputSlice, &errPtr)"),
+            "typed errors should pass err_out"
+        );
+        assert!(
+            code.contains("\t\t\terr := convertParseError(*errPtr)\n\t\t\tC.witffi_free_parse_error(errPtr)\n\t\t\treturn 0, err"),
+            "variant errors should be converted and freed"
+        );
+        assert!(
+            code.contains("\t\t\tC.witffi_free_limit_error(errPtr)\n\t\t\treturn err"),
+            "result<_, E> should return the typed error alone"
+        );
+        assert!(
+            code.contains("\t\t\terr := IoError(*errPtr)\n\t\t\tC.free(unsafe.Pointer(errPtr))"),
+            "enum errors should be freed with C.free"
+        );
+        assert!(
+            code.contains("C.witffi_parser_describe(inputSlice)\n"),
+            "string errors should not pass err_out"
+        );
+
+        // Records and variants that are not errors are unchanged
+        assert_eq!(
+            code.matches(") Error() string {").count(),
+            4,
+            "only error types should implement error"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_chars() {
+        let source = r#"
+            package test:chars;
+
+            interface text {
+                first-char: func(s: string) -> char;
+                next-char: func(c: char) -> result<char, string>;
+                is-upper: func(c: char) -> bool;
+
+                record glyph {
+                    code: char,
+                    fallback: option<char>,
+                }
+
+                glyphs: func(s: string) -> list<glyph>;
+                accents: func(c: char) -> list<char>;
+                count: func(cs: list<char>) -> u32;
+
+                variant mark {
+                    letter(char),
+                    space,
+                }
+
+                mark-at: func(s: string, i: u32) -> result<mark, string>;
+            }
+
+            world chars {
+                export text;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("chars.wit", source)
+            .expect("failed to parse chars WIT");
+        let world_id = resolve.packages[pkg_id].worlds["chars"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\t\"unicode/utf8\""),
+            "missing unicode/utf8 import"
+        );
+        assert!(
+            code.contains("func ffiCharToRune(c C.uint32_t) (rune, error) {"),
+            "missing ffiCharToRune helper"
+        );
+        assert!(
+            code.contains("func TextFirstChar(s string) (rune, error) {"),
+            "char results should return an error"
+        );
+        assert!(
+            code.contains("\treturn ffiCharToRune(result)"),
+            "char results should be validated"
+        );
+        assert!(
+            code.contains("func TextNextChar(c rune) (rune, error) {"),
+            "result<char, E> should map to rune"
+        );
+        assert!(
+            code.contains("\tif !utf8.ValidRune(c) {\n\t\treturn 0, fmt.Errorf("),
+            "char params should be validated when errors can be returned"
+        );
+        assert!(
+            code.contains("\tresult, err := ffiCharToRune(*resultPtr)"),
+            "result<char, E> should validate the lifted char"
+        );
+        assert!(
+            code.contains(
+                "func TextIsUpper(c rune) bool {\n\tif !utf8.ValidRune(c) {\n\t\tpanic(fmt.Sprintf(\"invalid char %#x: not a Unicode scalar value\", c))\n\t}\n\tresult := C.witffi_text_is_upper(C.uint32_t(c))"
+            ),
+            "functions without errors should panic on invalid chars"
+        );
+
+        // Chars nested in records, options and lists are validated too
+        assert!(
+            code.contains("func mustFfiCharToRune(c C.uint32_t) rune {"),
+            "missing mustFfiCharToRune helper"
+        );
+        assert!(
+            code.contains("mustFfiCharToRune(ffi.code),"),
+            "record char fields should be validated"
+        );
+        assert!(
+            code.contains("v := mustFfiCharToRune(*ffi.fallback)"),
+            "optional char fields should be validated"
+        );
+        assert!(
+            code.contains("v := mustFfiCharToRune(*ffi)"),
+            "optional chars should be validated"
+        );
+        assert!(
+            code.contains("result[i] = mustFfiCharToRune(e)"),
+            "char list elements should be validated"
+        );
+        assert!(
+            code.contains("\t\tpanic(invalidChar{err})")
+                && code.contains("func liftChars[T any](lift func() T) (value T, err error) {"),
+            "invalid nested chars should be recovered as errors"
+        );
+        assert!(
+            code.contains("func TextGlyphs(s string) ([]Glyph, error) {")
+                && code.contains(
+                    "\treturn liftChars(func() []Glyph { return convertListGlyph(result) })"
+                ),
+            "results nesting chars should return an invalid one as an error"
+        );
+        assert!(
+            code.contains(
+                "\tresult, err := liftChars(func() Mark { return convertMark(*resultPtr) })\n\tC.witffi_free_mark(resultPtr)\n\treturn result, err"
+            ),
+            "result<T, E> nesting chars should return an invalid one as an error"
+        );
+        assert!(
+            !code.contains("rune(ffi") && !code.contains("= rune(e)"),
+            "no char should be lifted unchecked"
+        );
+        assert!(
+            code.contains("func lowerRune(r rune) C.uint32_t {"),
+            "missing lowerRune helper"
+        );
+        assert!(
+            code.contains("elems[i] = lowerRune(e)"),
+            "char list elements should be validated when lowered"
+        );
+    }
+
+    const WIDE_WIT: &str = r#"
+        package test:wide;
+
+        interface ledger {
+            type u128 = tuple<u64, u64>;
+            type s128 = tuple<u64, u64>;
+
+            record entry {
+                amount: u128,
+                fee: option<u128>,
+            }
+
+            balance: func(account: string) -> u128;
+            adjust: func(delta: s128) -> result<s128, string>;
+            first-entry: func() -> entry;
+        }
+
+        world wide {
+            export ledger;
+        }
+    "#;
+
+    fn generate_wide(config: GoConfig) -> String {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("wide.wit", WIDE_WIT)
+            .expect("failed to parse wide WIT");
+        let world_id = resolve.packages[pkg_id].worlds["wide"];
+
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+        code
+    }
+
+    #[test]
+    fn test_generate_go_wide_ints_as_bytes() {
+        let code = generate_wide(GoConfig::default());
+
+        assert!(
+            code.contains("\t\"encoding/binary\""),
+            "missing encoding/binary import"
+        );
+        assert!(
+            !code.contains("\"math/big\""),
+            "math/big should only be imported when mapped"
+        );
+        assert!(
+            code.contains("func ffiWideToBytes(v C.FfiTuple2U64U64) [16]byte {"),
+            "missing ffiWideToBytes helper"
+        );
+        assert!(
+            code.contains("func ffiBytesToWide(b [16]byte) C.FfiTuple2U64U64 {"),
+            "missing ffiBytesToWide helper"
+        );
+        assert!(
+            code.contains("\tAmount [16]byte"),
+            "u128 fields should be [16]byte"
+        );
+        assert!(
+            code.contains("\tFee *[16]byte"),
+            "optional u128 fields should be *[16]byte"
+        );
+        assert!(
+            code.contains("func LedgerBalance(account string) [16]byte {"),
+            "u128 results should be [16]byte"
+        );
+        assert!(
+            code.contains("\treturn ffiWideToBytes(result)"),
+            "u128 results should be lifted"
+        );
+        assert!(
+            code.contains("func LedgerAdjust(delta [16]byte) ([16]byte, error) {"),
+            "s128 should not be split like a tuple"
+        );
+        assert!(
+            code.contains("\tdeltaWide := ffiBytesToWide(delta)"),
+            "u128 params should be lowered"
+        );
+        assert!(
+            code.contains("C.witffi_ledger_adjust(deltaWide.f0, deltaWide.f1)"),
+            "u128 params should be passed as two words"
+        );
+        assert!(
+            code.contains("\t\treturn [16]byte{}, fmt.Errorf("),
+            "the zero value of a u128 should be [16]byte{{}}"
+        );
+        assert!(
+            code.contains("\t\tv := ffiWideToBytes(*ffi.fee)"),
+            "optional u128 fields should be lifted"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_wide_ints_as_big_ints() {
+        let code = generate_wide(GoConfig {
+            type_mappings: HashMap::from([
+                ("u128".to_string(), GoTypeMapping::BigInt),
+                ("s128".to_string(), GoTypeMapping::BigInt),
+            ]),
+            ..GoConfig::default()
+        });
+
+        assert!(code.contains("\t\"math/big\""), "missing math/big import");
+        assert!(
+            code.contains("func ffiWideToBigInt(v C.FfiTuple2U64U64, signed bool) *big.Int {"),
+            "missing ffiWideToBigInt helper"
+        );
+        assert!(
+            code.contains("func ffiBigIntToWide(n *big.Int) C.FfiTuple2U64U64 {"),
+            "missing ffiBigIntToWide helper"
+        );
+        assert!(
+            code.contains("\tAmount *big.Int"),
+            "mapped fields should be *big.Int"
+        );
+        assert!(
+            code.contains("func LedgerAdjust(delta *big.Int) (*big.Int, error) {"),
+            "mapped params and results should be *big.Int"
+        );
+        assert!(
+            code.contains("\tdeltaWide := ffiBigIntToWide(delta)"),
+            "mapped params should be lowered from big.Int"
+        );
+        assert!(
+            code.contains("\tresult := ffiWideToBigInt(*resultPtr, true)"),
+            "s128 results should be read as signed"
+        );
+        assert!(
+            code.contains("\treturn ffiWideToBigInt(result, false)"),
+            "u128 results should be read as unsigned"
+        );
+        assert!(
+            code.contains("\t\treturn nil, fmt.Errorf("),
+            "the zero value of a big.Int should be nil"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_fixed_lists() {
+        let source = r#"
+            package test:arrays;
+
+            interface hashing {
+                record block {
+                    hash: list<u8, 32>,
+                    parent: option<list<u8, 32>>,
+                }
+
+                digest: func(data: list<u8>) -> list<u8, 32>;
+                try-block: func(height: u64) -> result<block, string>;
+                try-digest: func(data: list<u8>) -> result<list<u8, 32>, string>;
+                mix: func(seed: list<u32, 3>) -> u32;
+            }
+
+            world arrays {
+                export hashing;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("arrays.wit", source)
+            .expect("failed to parse arrays WIT");
+        let world_id = resolve.packages[pkg_id].worlds["arrays"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\tHash [32]uint8"),
+            "fixed-length list fields should be arrays"
+        );
+        assert!(
+            code.contains("\tParent *[32]uint8"),
+            "optional fixed-length lists should be array pointers"
+        );
+        assert_eq!(
+            code.matches("func convertArray32U8(ffi C.FfiArray32U8) [32]uint8 {")
+                .count(),
+            1,
+            "identical fixed-length lists should share one conversion"
+        );
+        assert!(
+            code.contains("\t\tresult[i] = uint8(ffi.elems[i])"),
+            "elements should be converted in place"
+        );
+        assert!(
+            code.contains("\t\tv := convertArray32U8(*ffi.parent)"),
+            "optional fixed-length lists should be converted"
+        );
+        assert!(
+            code.contains("func HashingDigest(data []byte) [32]uint8 {"),
+            "fixed-length list results should be arrays"
+        );
+        assert!(
+            code.contains("\t\treturn [32]uint8{}, fmt.Errorf("),
+            "the zero value of a fixed-length list should be an empty array"
+        );
+        assert!(
+            code.contains("\tC.witffi_free_array32_u8(resultPtr)"),
+            "boxed fixed-length lists should be freed"
+        );
+        assert!(
+            code.contains("func HashingMix(seed [3]uint32) uint32 {"),
+            "fixed-length list params should be arrays"
+        );
+        assert!(
+            code.contains(
+                "C.witffi_hashing_mix(C.uint32_t(seed[0]), C.uint32_t(seed[1]), C.uint32_t(seed[2]))"
+            ),
+            "fixed-length list params should be passed element by element"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_nested_containers() {
+        let source = r#"
+            package test:nested;
+
+            interface geometry {
+                record point {
+                    x: s32,
+                    y: s32,
+                }
+
+                record mesh {
+                    faces: list<list<list<point>>>,
+                    labels: list<option<string>>,
+                }
+
+                build: func(rows: list<list<string>>) -> mesh;
+                validate: func(ids: list<u32>) -> list<result<u32, string>>;
+            }
+
+            world nested {
+                export geometry;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("nested.wit", source)
+            .expect("failed to parse nested WIT");
+        let world_id = resolve.packages[pkg_id].worlds["nested"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        // Lifting
+        assert!(
+            code.contains("\tFaces [][][]Point"),
+            "nested lists should surface as nested slices"
+        );
+        assert!(
+            code.contains(
+                "func convertListListListPoint(ffi C.FfiListListListPoint) [][][]Point {"
+            ),
+            "each list shape should get a conversion function"
+        );
+        assert!(
+            code.contains("\t\tresult[i] = convertListListPoint(e)"),
+            "nested lists should convert recursively"
+        );
+        assert!(
+            code.contains("\tC.witffi_free_list_list_list_point(ffi)"),
+            "converted lists should be freed"
+        );
+        assert!(
+            code.contains("\t\tresult[i] = convertOptionString(e)"),
+            "options nested in lists should convert through their own function"
+        );
+        assert!(
+            code.contains("type Result[T, E any] struct {"),
+            "nested results should emit the generic Result type"
+        );
+        assert!(
+            code.contains("func GeometryValidate(ids []uint32) []Result[uint32, string] {"),
+            "nested results should surface as Result values"
+        );
+        assert!(
+            code.contains("\tresult := Result[uint32, string]{IsOk: bool(ffi.is_ok)}"),
+            "nested results should record which side is set"
+        );
+
+        // Lowering
+        assert!(
+            code.contains("type cAllocs []unsafe.Pointer"),
+            "list parameters should emit the cAllocs helper"
+        );
+        assert!(
+            code.contains(
+                "func lowerListListString(v [][]string, allocs *cAllocs) C.FfiListListStringSlice {"
+            ),
+            "list parameters should get a lowering function"
+        );
+        assert!(
+            code.contains("\t\telems[i] = lowerListString(e, allocs)"),
+            "nested list parameters should lower recursively"
+        );
+        assert!(
+            code.contains("\t\telems[i] = allocs.bytes([]byte(e))"),
+            "strings should be copied into C memory"
+        );
+        assert!(
+            code.contains("\t\telems[i] = C.uint32_t(e)"),
+            "primitive elements should be converted in place"
+        );
+        assert!(
+            code.contains("\tallocs := newCAllocs()\n\tdefer allocs.free()"),
+            "lowered memory should be released after the call"
+        );
+        assert!(
+            code.contains("\trowsList := lowerListListString(rows, allocs)"),
+            "list parameters should be lowered before the call"
+        );
+        assert!(
+            code.contains("C.witffi_geometry_build(rowsList)"),
+            "lowered lists should be passed to C"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_map_mapping() {
+        let source = r#"
+            package test:catalog;
+
+            interface catalog {
+                type labels = list<tuple<string, u32>>;
+
+                record item {
+                    name: string,
+                    tags: labels,
+                }
+
+                index: func(entries: labels) -> labels;
+                tag: func(name: string) -> item;
+            }
+
+            world catalog-world {
+                export catalog;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("catalog.wit", source)
+            .expect("failed to parse catalog WIT");
+        let world_id = resolve.packages[pkg_id].worlds["catalog-world"];
+
+        let config = GoConfig {
+            type_mappings: HashMap::from([("labels".to_string(), GoTypeMapping::Map)]),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\t\"sort\""),
+            "map lowering should import sort"
+        );
+        assert!(
+            code.contains("type Labels = map[string]uint32"),
+            "mapped aliases should be declared as maps"
+        );
+        assert!(
+            code.contains("\tTags Labels"),
+            "map fields should use the alias"
+        );
+        assert!(
+            code.contains("func CatalogIndex(entries Labels) Labels {"),
+            "map parameters and results should use the alias"
+        );
+        assert!(
+            code.contains("Tags: ffiPairsToMap(convertListTuple2StringU32(ffi.tags)),"),
+            "map fields should be lifted from their entries"
+        );
+        assert!(
+            code.contains(
+                "func convertListTuple2StringU32(ffi C.FfiListTuple2StringU32) []TupleOf2[string, uint32] {"
+            ),
+            "entry lists should still convert to slices"
+        );
+        assert!(
+            code.contains(
+                "\tentriesList := lowerListTuple2StringU32(ffiMapToPairs(entries), allocs)"
+            ),
+            "map parameters should be lowered from sorted entries"
+        );
+        assert!(
+            code.contains(
+                "func lowerTuple2StringU32(v TupleOf2[string, uint32], allocs *cAllocs) C.FfiTuple2StringU32Input {"
+            ),
+            "entries holding strings should lower to input tuples"
+        );
+        assert!(
+            code.contains("\treturn C.FfiTuple2StringU32Input{f0: allocs.bytes([]byte(v.F0)), f1: C.uint32_t(v.F1)}"),
+            "entry fields should be lowered"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_custom_type_mappings() {
+        let source = r#"
+            package test:ledger;
+
+            interface ledger {
+                type u256 = list<u8>;
+                type amount = u64;
+
+                record transfer {
+                    value: u256,
+                    fee: option<u256>,
+                    memo: amount,
+                }
+
+                total: func(values: list<u256>) -> u256;
+                scaled: func(a: amount) -> result<amount, string>;
+                describe: func(t: transfer) -> string;
+            }
+
+            world ledger-world {
+                export ledger;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("ledger.wit", source)
+            .expect("failed to parse ledger WIT");
+        let world_id = resolve.packages[pkg_id].worlds["ledger-world"];
+
+        let config = GoConfig {
+            type_mappings: HashMap::from([
+                ("u256".to_string(), GoTypeMapping::BigInt),
+                (
+                    "amount".to_string(),
+                    GoTypeMapping::Custom(GoCustomMapping {
+                        go_type: "decimal.Decimal".to_string(),
+                        lift: "AmountFromUnits".to_string(),
+                        lower: "AmountToUnits".to_string(),
+                        import: Some("github.com/shopspring/decimal".to_string()),
+                    }),
+                ),
+            ]),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\t\"math/big\""),
+            "big.Int mappings should import math/big"
+        );
+        assert!(
+            code.contains("\t\"unsafe\"\n\n\t\"github.com/shopspring/decimal\"\n)"),
+            "custom imports should follow the standard library"
+        );
+        assert!(
+            code.contains("type U256 = *big.Int"),
+            "mapped aliases should use the mapped type"
+        );
+        assert!(
+            code.contains("\tValue U256"),
+            "mapped fields should use the alias"
+        );
+        assert!(
+            code.contains("Value: ffiBytesToBigInt(ffiByteBufferToBytes(ffi.value)),"),
+            "byte aliases should be lifted into big.Int"
+        );
+        assert!(
+            code.contains("\t\tv := ffiBytesToBigInt(ffiByteBufferToBytes(*ffi.fee))"),
+            "optional byte aliases should be lifted"
+        );
+        assert!(
+            code.contains("Memo: AmountFromUnits(uint64(ffi.memo)),"),
+            "custom types should be lifted by the user's function"
+        );
+        assert!(
+            code.contains("func LedgerTotal(values []U256) U256 {"),
+            "mapped types should appear in signatures"
+        );
+        assert!(
+            code.contains("\t\telems[i] = allocs.bytes(ffiBigIntToBytes(e))"),
+            "mapped list elements should be lowered"
+        );
+        assert!(
+            code.contains("func LedgerScaled(a Amount) (Amount, error) {"),
+            "custom types should appear in signatures"
+        );
+        assert!(
+            code.contains("\taRaw := AmountToUnits(a)"),
+            "custom params should be lowered by the user's function"
+        );
+        assert!(
+            code.contains("C.witffi_ledger_scaled(C.uint64_t(aRaw))"),
+            "lowered custom params should be passed to C"
+        );
+        assert!(
+            code.contains("return *new(decimal.Decimal), fmt.Errorf("),
+            "custom types should have zero values"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_named_aliases() {
+        let source = r#"
+            package test:chains;
+
+            interface chains {
+                /// A chain identifier.
+                type chain-id = u64;
+                type label = string;
+                type ids = list<chain-id>;
+
+                record network {
+                    id: chain-id,
+                    name: label,
+                    parent: option<chain-id>,
+                }
+
+                lookup: func(id: chain-id, name: label) -> network;
+                known: func() -> ids;
+                first: func(ids: ids) -> option<chain-id>;
+            }
+
+            world chains-world {
+                export chains;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("chains.wit", source)
+            .expect("failed to parse chains WIT");
+        let world_id = resolve.packages[pkg_id].worlds["chains-world"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        // Declarations
+        assert!(
+            code.contains("// ChainId is a chain identifier.\ntype ChainId uint64"),
+            "scalar aliases should be distinct named types"
+        );
+        assert!(
+            code.contains("type Label string"),
+            "string aliases should be named types"
+        );
+        assert!(
+            code.contains("type Ids = []ChainId"),
+            "list aliases should be Go aliases"
+        );
+        assert!(
+            code.contains("\tId ChainId"),
+            "fields should use the alias name"
+        );
+        assert!(
+            code.contains("\tParent *ChainId"),
+            "optional fields should use the alias name"
+        );
+
+        // Signatures
+        assert!(
+            code.contains("func ChainsLookup(id ChainId, name Label) Network {"),
+            "parameters should use alias names"
+        );
+        assert!(
+            code.contains("func ChainsKnown() Ids {"),
+            "results should use alias names"
+        );
+        assert!(
+            code.contains("func ChainsFirst(ids Ids) *ChainId {"),
+            "optional results should use alias names"
+        );
+
+        // Conversions
+        assert!(
+            code.contains("Id: ChainId(uint64(ffi.id)),"),
+            "named types should be converted explicitly"
+        );
+        assert!(
+            code.contains("Name: Label(ffiByteBufferToString(ffi.name)),"),
+            "named strings should be converted explicitly"
+        );
+        assert!(
+            code.contains("\t\tv := ChainId(uint64(*ffi.parent))"),
+            "optional named types should be converted explicitly"
+        );
+        assert!(
+            code.contains("\t\tresult[i] = ChainId(uint64(e))"),
+            "list elements should be converted to the named type"
+        );
+        assert!(
+            code.contains("unsafe.StringData(string(name))"),
+            "named strings should be lowered as strings"
+        );
+        assert!(
+            code.contains("C.uint64_t(id)"),
+            "named numbers should be lowered to their C type"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_tuple_results_as_structs() {
+        let source = r#"
+            package test:tuples;
+
+            interface stats {
+                lookup: func(key: string) -> tuple<string, u64>;
+                try-lookup: func(key: string) -> result<tuple<string, u64>, string>;
+            }
+
+            world tuples {
+                export stats;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tuples.wit", source)
+            .expect("failed to parse tuples WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tuples"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func StatsLookup(key string) TupleOf2[string, uint64] {"),
+            "tuple results should be a single TupleOfN by default"
+        );
+        assert!(
+            code.contains("\treturn convertTuple2StringU64(result)"),
+            "tuple results should be returned whole"
+        );
+        assert!(
+            code.contains("func StatsTryLookup(key string) (TupleOf2[string, uint64], error) {"),
+            "result<tuple> should return a TupleOfN and an error by default"
+        );
+        assert!(
+            code.contains("return TupleOf2[string, uint64]{}, fmt.Errorf("),
+            "result<tuple> errors should return a zero TupleOfN"
+        );
+        assert!(
+            !code.contains("values.F0"),
+            "tuples should not be split by default"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_multi_field_variant_payloads() {
+        let source = r#"
+            package test:shapes;
+
+            interface geometry {
+                variant shape {
+                    circle(f32),
+                    rect(tuple<u8, u64, string>),
+                    point(tuple<s16, s16>),
+                    none,
+                }
+
+                variant draw-error {
+                    out-of-bounds(tuple<s16, s16>),
+                    unknown,
+                }
+
+                largest: func() -> result<shape, draw-error>;
+            }
+
+            world shapes {
+                export geometry;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("shapes.wit", source)
+            .expect("failed to parse shapes WIT");
+        let world_id = resolve.packages[pkg_id].worlds["shapes"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type ShapeCircle struct { Value float32 }"),
+            "single payloads should keep the Value field"
+        );
+        assert!(
+            code.contains("type ShapeRect struct {\n\tF0 uint8\n\tF1 uint64\n\tF2 string\n}"),
+            "tuple payloads should become positional fields"
+        );
+        assert!(
+            code.contains("type ShapePoint struct {\n\tF0 int16\n\tF1 int16\n}"),
+            "missing two-field case struct"
+        );
+        assert!(
+            code.contains(
+                "return ShapeRect{F0: uint8(ffi.rect.f0), F1: uint64(ffi.rect.f1), F2: ffiByteBufferToString(ffi.rect.f2)}"
+            ),
+            "tuple payload fields should convert individually"
+        );
+        assert!(
+            code.contains("return fmt.Sprintf(\"draw-error out-of-bounds: (%v, %v)\", e.F0, e.F1)"),
+            "error cases should format every payload field"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_time_mappings() {
+        let source = r#"
+            package test:clocks;
+
+            interface events {
+                record datetime {
+                    seconds: u64,
+                    nanoseconds: u32,
+                }
+
+                type timestamp = u64;
+                type duration = u64;
+
+                record event {
+                    name: string,
+                    created-at: u64,
+                    elapsed: s64,
+                    at: datetime,
+                }
+
+                now: func() -> datetime;
+                since: func(start: timestamp) -> duration;
+                sleep: func(timeout: option<duration>) -> timestamp;
+                latest: func() -> event;
+            }
+
+            world clocks {
+                export events;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("clocks.wit", source)
+            .expect("failed to parse clocks WIT");
+        let world_id = resolve.packages[pkg_id].worlds["clocks"];
+
+        let config = GoConfig {
+            type_mappings: HashMap::from([
+                ("datetime".to_string(), GoTypeMapping::Time),
+                ("timestamp".to_string(), GoTypeMapping::Time),
+                ("duration".to_string(), GoTypeMapping::Duration),
+                ("event.created-at".to_string(), GoTypeMapping::Time),
+                ("event.elapsed".to_string(), GoTypeMapping::Duration),
+            ]),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(code.contains("\t\"time\"\n"), "missing time import");
+        assert!(
+            code.contains("type Timestamp = time.Time"),
+            "nanosecond aliases should become time.Time"
+        );
+        assert!(
+            code.contains("type Duration = time.Duration"),
+            "duration aliases should become time.Duration"
+        );
+        assert!(
+            code.contains("func ffiNanosToTime[T ~uint64 | ~int64](n T) time.Time {"),
+            "missing ffiNanosToTime helper"
+        );
+        assert!(
+            code.contains(
+                "\treturn Datetime{Seconds: uint64(t.Unix()), Nanoseconds: uint32(t.Nanosecond())}"
+            ),
+            "missing datetime lowering helper"
+        );
+        assert!(
+            code.contains("func EventsNow() time.Time {"),
+            "datetime records should surface as time.Time"
+        );
+        assert!(
+            code.contains("func EventsSince(start Timestamp) Duration {"),
+            "mapped params and results should use the time types"
+        );
+        assert!(
+            code.contains("\tstartRaw := ffiTimeToNanos(start)"),
+            "time params should lower to nanoseconds"
+        );
+        assert!(
+            code.contains("\t\ttimeoutRaw := int64(timeoutValue)"),
+            "optional durations should lower to nanoseconds"
+        );
+        assert!(
+            code.contains("\tCreatedAt time.Time\n\tElapsed time.Duration\n\tAt time.Time\n"),
+            "mapped record fields should use the time types"
+        );
+        assert!(
+            code.contains("\t\tCreatedAt: ffiNanosToTime(uint64(ffi.created_at)),"),
+            "mapped fields should lift from nanoseconds"
+        );
+        assert!(
+            code.contains("\t\tElapsed: time.Duration(int64(ffi.elapsed)),"),
+            "duration fields should convert directly"
+        );
+        assert!(
+            code.contains("\t\tAt: ffiDatetimeToTime(convertDatetime(ffi.at)),"),
+            "datetime fields should lift through the record"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_feature_files() {
+        let source = r#"
+            package test:gates@1.0.0;
+
+            interface math {
+                @since(version = 1.0.0)
+                add: func(a: u32, b: u32) -> u32;
+
+                @unstable(feature = fast-math)
+                fma: func(a: f64, b: f64, c: f64) -> result<f64, string>;
+
+                @unstable(feature = fast-math)
+                describe: func(x: f64) -> string;
+
+                @unstable(feature = fast-math)
+                squares: func(n: u32) -> list<u64>;
+            }
+
+            world gates {
+                export math;
+            }
+        "#;
+        let mut resolve = Resolve {
+            all_features: true,
+            ..Resolve::default()
+        };
+        let pkg_id = resolve
+            .push_str("gates.wit", source)
+            .expect("failed to parse gated WIT");
+        let world_id = resolve.packages[pkg_id].worlds["gates"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+        let files = generator
+            .generate_feature_files()
+            .expect("failed to generate feature files");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+        for (name, file) in &files {
+            eprintln!("--- {name} ---\n{file}\n--- End ---");
+        }
+
+        assert!(code.contains("func MathAdd(a uint32, b uint32) uint32 {"));
+        assert!(
+            !code.contains("func MathFma("),
+            "gated functions should not be in the main file"
+        );
+        assert!(
+            !code.contains("\t\"fmt\""),
+            "the main file should not import what only gated functions use"
+        );
+
+        assert_eq!(files.len(), 1, "functions should be grouped by feature");
+        let (name, file) = &files[0];
+        assert_eq!(name, "feature_fast_math_bindings.go");
+        assert!(
+            file.starts_with(
+                "// Code generated by witffi. DO NOT EDIT.\n\n//go:build witffi_feature_fast_math\n\npackage gates\n"
+            ),
+            "feature files should be guarded by a build tag"
+        );
+        assert!(
+            file.contains("import \"C\"\n\nimport (\n\t\"fmt\"\n\t\"runtime\"\n\t\"unsafe\"\n)\n"),
+            "feature files should import only what they use"
+        );
+        assert!(file.contains("func MathFma(a float64, b float64, c float64) (float64, error) {"));
+        assert!(file.contains("func MathDescribe(x float64) string {"));
+
+        // A gated `Seq` variant imports iter in its feature file
+        let config = GoConfig {
+            list_seqs: ["math.squares".to_string()].into_iter().collect(),
+            ..GoConfig::default()
+        };
+        let files = GoGenerator::new(&resolve, world_id, config)
+            .generate_feature_files()
+            .expect("failed to generate feature files");
+        let (_, file) = &files[0];
+        assert!(
+            file.contains("func MathSquaresSeq(n uint32) iter.Seq[uint64] {")
+                && file.contains("\t\"fmt\"\n\t\"iter\"\n"),
+            "gated Seq variants should import iter"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_named_results() {
+        let source = r#"
+            package test:parsing;
+
+            interface parser {
+                record parse-result {
+                    value: u64,
+                    remainder: string,
+                }
+
+                record span {
+                    input: string,
+                    %result: u32,
+                }
+
+                parse-number: func(input: string) -> result<parse-result, string>;
+                peek-number: func(input: string) -> parse-result;
+                locate: func(input: string) -> span;
+            }
+
+            world parsing {
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("parsing.wit", source)
+            .expect("failed to parse parsing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["parsing"];
+
+        let config = GoConfig {
+            named_results: ["parse-result", "span"]
+                .into_iter()
+                .map(String::from)
+                .collect(),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "func ParserParseNumber(input string) (value uint64, remainder string, err error) {"
+            ),
+            "result<record> should flatten into named values and an error"
+        );
+        assert!(
+            code.contains("return 0, \"\", fmt.Errorf("),
+            "errors should return the zero value of every field"
+        );
+        assert!(
+            code.contains("\treturn result.Value, result.Remainder, nil"),
+            "fields should be returned from the converted record"
+        );
+        assert!(
+            code.contains("func ParserPeekNumber(input string) (value uint64, remainder string) {"),
+            "plain record results should flatten into named values"
+        );
+        assert!(
+            code.contains("\treturn values.Value, values.Remainder\n"),
+            "plain record results should return each field"
+        );
+        assert!(
+            code.contains(
+                "func ParserLocate(input string) (inputValue string, resultValue uint32) {"
+            ),
+            "names clashing with parameters or locals should be suffixed"
+        );
+        assert!(
+            code.contains("type ParseResult struct {"),
+            "the record type should still be generated"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_imported_functions() {
+        let source = r#"
+            package test:host;
+
+            interface host {
+                /// Read a file on the caller's side.
+                read-file: func(path: string) -> result<string, string>;
+                log: func(message: string);
+                checksum: func(data: list<u8>) -> u32;
+                flush: func() -> result<_, string>;
+            }
+
+            interface parser {
+                parse: func(input: string) -> u32;
+            }
+
+            world app {
+                import host;
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("host.wit", source)
+            .expect("failed to parse host WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "type HostImports interface {\n\tChecksum(data []byte) uint32\n\tFlush() error\n\tLog(message string)\n\t// Read a file on the caller's side.\n\tReadFile(path string) (string, error)\n}"
+            ),
+            "imports should be implemented through a Go interface"
+        );
+        assert!(
+            code.contains("func RegisterHostImports(impl HostImports) {\n\thostImports = impl\n}"),
+            "the implementation should be registered"
+        );
+        assert!(
+            code.contains(
+                "//export witffi_import_host_read_file\nfunc witffi_import_host_read_file(path C.FfiByteSlice, okOut *C.FfiByteBuffer, errOut *C.FfiByteBuffer) C.bool {"
+            ),
+            "fallible imports should be exported with ok/err out-pointers"
+        );
+        assert!(
+            code.contains(
+                "\tresult, err := hostImports.ReadFile(C.GoStringN((*C.char)(unsafe.Pointer(path.ptr)), C.int(path.len)))"
+            ),
+            "string arguments should be copied into Go"
+        );
+        assert!(
+            code.contains("\t*okOut = ffiMallocBytes([]byte(result))"),
+            "returned strings should be copied into C memory"
+        );
+        assert!(
+            code.contains(
+                "\t\t*errOut = ffiMallocBytes([]byte(\"RegisterHostImports has not been called\"))"
+            ),
+            "fallible imports should fail before registration"
+        );
+        assert!(
+            code.contains("\t\tpanic(\"RegisterHostImports has not been called\")"),
+            "infallible imports should panic before registration"
+        );
+        assert!(
+            code.contains("func witffi_import_host_checksum(data C.FfiByteSlice) C.uint32_t {")
+                && code.contains("\treturn C.uint32_t(result)"),
+            "numbers should be returned directly"
+        );
+        assert!(
+            code.contains("\terr := hostImports.Flush()"),
+            "result<_, string> imports should only return an error"
+        );
+        assert!(
+            code.contains(
+                "// Methods may call exported functions, reentering Rust on the same thread,"
+            ),
+            "implementations should be told they may reenter Rust"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_bidirectional_init() {
+        let source = r#"
+            package test:bidi;
+
+            interface host {
+                read-file: func(path: string) -> result<string, string>;
+            }
+
+            interface clock {
+                now: func() -> u64;
+            }
+
+            interface parser {
+                parse: func(path: string) -> result<u32, string>;
+                count: func() -> u32;
+            }
+
+            world app {
+                import host;
+                import clock;
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("bidi.wit", source)
+            .expect("failed to parse bidi WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type Imports struct {\n\tClock ClockImports\n\tHost HostImports\n}"),
+            "Init should take every imported interface"
+        );
+        assert!(
+            code.contains(
+                "\tif imports.Host == nil {\n\t\treturn fmt.Errorf(\"Init: no implementation of imported interface `host`\")\n\t}\n\tRegisterClockImports(imports.Clock)\n\tRegisterHostImports(imports.Host)\n\treturn nil"
+            ),
+            "Init should validate every import before registering any"
+        );
+        assert!(
+            code.contains(
+                "func ParserParse(path string) (uint32, error) {\n\tif err := importsRegistered(); err != nil {\n\t\treturn 0, err\n\t}"
+            ),
+            "fallible exports should fail until every import is registered"
+        );
+        assert!(
+            code.contains(
+                "func ParserCount() uint32 {\n\tif err := importsRegistered(); err != nil {\n\t\tpanic(err)\n\t}"
+            ),
+            "infallible exports should panic until every import is registered"
+        );
+        assert!(code.contains("\t\"fmt\""), "Init errors need fmt");
+    }
+
+    #[test]
+    fn test_generate_go_imported_resources() {
+        let source = r#"
+            package test:bidi;
+
+            interface types {
+                resource counter;
+            }
+
+            interface host {
+                use types.{counter};
+
+                /// Inspect a counter owned by Rust.
+                inspect: func(c: borrow<counter>) -> u64;
+                adopt: func(c: counter);
+                spawn: func() -> result<counter, string>;
+            }
+
+            interface api {
+                use types.{counter};
+
+                make-counter: func(start: u64) -> counter;
+                tally: func(c: borrow<counter>) -> u64;
+            }
+
+            world app {
+                import host;
+                export api;
+            }
+
+            world lonely {
+                import host;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("bidi.wit", source)
+            .expect("failed to parse bidi WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "\tAdopt(c *Counter)\n\t// Inspect a counter owned by Rust.\n\tInspect(c CounterRef) uint64\n\tSpawn() (*Counter, error)\n"
+            ),
+            "borrows should be Refs and owned handles wrappers"
+        );
+        assert!(
+            code.contains("// Borrowed resources are only valid until the method returns."),
+            "the interface should document borrow lifetimes"
+        );
+        assert!(
+            code.contains("func witffi_import_host_inspect(c *C.FfiCounter) C.uint64_t {")
+                && code.contains("\tresult := hostImports.Inspect(CounterRef{handle: c})"),
+            "borrowed handles should be wrapped without taking ownership"
+        );
+        assert!(
+            code.contains("\thostImports.Adopt(wrapCounter(c))"),
+            "owned handles should be wrapped so Go can close them"
+        );
+        assert!(
+            code.contains(
+                "func witffi_import_host_spawn(okOut **C.FfiCounter, errOut *C.FfiByteBuffer) C.bool {"
+            ) && code.contains("\t*okOut = unwrapCounter(result)"),
+            "returned resources should hand their handle to Rust"
+        );
+        assert!(
+            code.contains("func unwrapCounter(c *Counter) *C.FfiCounter {\n\tif !c.claim() {")
+                && code.contains("\thandle := c.handle\n\tc.handle = nil\n\treturn handle"),
+            "unwrapping should empty the Go wrapper"
+        );
+        // A Go implementation can pass its borrow back into an export
+        assert!(
+            code.contains("func ApiTally(c CounterRef) uint64 {"),
+            "exports should accept the Ref handed to imports"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_async_functions() {
+        let source = r#"
+            package test:tasks;
+
+            interface types {
+                resource job;
+            }
+
+            interface api {
+                use types.{job};
+
+                /// Fetch a page.
+                fetch: async func(url: string, retries: option<u32>) -> result<string, string>;
+                sleep: async func(ms: u64);
+                label: async func(tags: tuple<string, u32>) -> string;
+                start: async func(id: u64) -> job;
+            }
+
+            world tasks {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tasks.wit", source)
+            .expect("failed to parse tasks WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tasks"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("extern void witffi_async_complete(uintptr_t user_data, void *task);"),
+            "the preamble should declare the completion callback"
+        );
+        assert!(
+            code.contains("\t\"runtime/cgo\"\n"),
+            "channels should be passed to Rust as cgo handles"
+        );
+        assert!(
+            code.contains("//export witffi_async_complete\nfunc witffi_async_complete(userData C.uintptr_t, task unsafe.Pointer) {"),
+            "the completion callback should be exported to Rust"
+        );
+        assert!(
+            code.contains("func ApiFetch(url string, retries *uint32) (string, error) {"),
+            "async functions should look synchronous to Go"
+        );
+        assert!(
+            code.contains(
+                "\tasyncTask := awaitAsync(func(asyncComplete C.FfiAsyncComplete, asyncData C.uintptr_t) {\n\t\tC.witffi_api_fetch(urlSlice, retriesArg, asyncComplete, asyncData)\n\t})\n\tresultPtr := C.witffi_api_fetch_finish(asyncTask)"
+            ),
+            "the goroutine should wait for the task before collecting the result"
+        );
+        assert!(
+            code.contains("fmt.Errorf(\"witffi_api_fetch failed: %s\", readLastError())"),
+            "errors should name the async function"
+        );
+        assert!(
+            code.contains("\tC.witffi_api_sleep_finish(asyncTask)"),
+            "void async functions should still be collected"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_stream_results() {
+        let source = r#"
+            package test:logs;
+
+            interface types {
+                resource cursor;
+
+                record entry {
+                    line: string,
+                    level: u8,
+                }
+            }
+
+            interface api {
+                use types.{cursor, entry};
+
+                /// Follow a log file.
+                tail: func(path: string) -> stream<entry>;
+                counts: func() -> stream<u32>;
+                cursors: func() -> stream<cursor>;
+            }
+
+            world logs {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("logs.wit", source)
+            .expect("failed to parse logs WIT");
+        let world_id = resolve.packages[pkg_id].worlds["logs"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type Stream[T any] struct {\n\tC    <-chan T"),
+            "streams should be received from a channel"
+        );
+        assert!(
+            code.contains("\t\t\tcase values <- value:\n\t\t\tcase <-s.stop:"),
+            "values should only be pulled once the previous one is received"
+        );
+        assert!(
+            code.contains("func (s *Stream[T]) Close() {"),
+            "streams should be closable"
+        );
+        assert!(
+            code.contains("func ApiTail(path string) *Stream[Entry] {"),
+            "stream functions should return a Stream"
+        );
+        assert!(
+            code.contains("\tstreamPtr := C.witffi_api_tail(pathSlice)"),
+            "the producer should be started with the arguments"
+        );
+        assert!(
+            code.contains("\t\titemPtr := C.witffi_api_tail_next(streamPtr)"),
+            "values should be pulled from the producer"
+        );
+        assert!(
+            code.contains("\t\tC.witffi_free_entry(itemPtr)\n\t\treturn item, true, nil"),
+            "pulled values should be freed once converted"
+        );
+        assert!(
+            code.contains("\t\tC.witffi_api_tail_drop(streamPtr)"),
+            "the producer should be dropped when the stream ends"
+        );
+        assert!(
+            code.contains("func ApiCursors() *Stream[*Cursor] {"),
+            "resources should be streamed as handles"
+        );
+        assert!(
+            !code.contains("iter.Seq"),
+            "iterators should only be generated when configured"
+        );
+
+        let config = GoConfig {
+            stream_iterators: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            code.contains("\t\"iter\"\n")
+                && code.contains("func (s *Stream[T]) All() iter.Seq[T] {"),
+            "streams should be iterable when configured"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_future_results() {
+        let source = r#"
+            package test:jobs;
+
+            interface api {
+                /// Render a report.
+                render: func(name: string) -> future<result<string, string>>;
+                count: func() -> future<u64>;
+                wipe: func() -> future<result<_, string>>;
+            }
+
+            world jobs {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("jobs.wit", source)
+            .expect("failed to parse jobs WIT");
+        let world_id = resolve.packages[pkg_id].worlds["jobs"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\t\"context\"\n") && code.contains("\t\"runtime/cgo\"\n"),
+            "futures should be awaited with a context and completed through cgo handles"
+        );
+        assert!(
+            code.contains("func (f *Future[T]) Await(ctx context.Context) (T, error) {")
+                && code.contains("func (f *Future[T]) Done() <-chan struct{} {")
+                && code.contains("func (f *Future[T]) Cancel() {"),
+            "futures should be awaitable, selectable and cancellable"
+        );
+        assert!(
+            code.contains("\t\tC.witffi_future_cancel(f.call)")
+                && code.contains("\t\tC.witffi_future_release(f.call)"),
+            "calls should be cancelled and released in Rust"
+        );
+        assert!(
+            code.contains("func ApiRender(name string) *Future[string] {"),
+            "future results should return a Future of the ok value"
+        );
+        assert!(
+            code.contains(
+                "\tfutureFinish := func(asyncTask unsafe.Pointer) (string, error) {\n\t\tresultPtr := C.witffi_api_render_finish(asyncTask)"
+            ),
+            "the value should be collected from the finished task"
+        );
+        assert!(
+            code.contains("\t\treturn C.witffi_api_render(nameSlice, asyncComplete, asyncData)\n\t}, futureFinish)"),
+            "the call should be started with the completion callback"
+        );
+        assert!(
+            code.contains("func ApiCount() *Future[uint64] {")
+                && code.contains("\t\treturn futureFinish(asyncTask), nil"),
+            "infallible values should be adapted to (T, error)"
+        );
+        assert!(
+            code.contains("func ApiWipe() *Future[struct{}] {")
+                && code.contains("\t\treturn struct{}{}, futureFinish(asyncTask)"),
+            "results without an ok value should await an empty struct"
+        );
+        assert!(
+            !code.contains("TODO: generate Go type"),
+            "future types should not need a type definition"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_byte_streams() {
+        let source = r#"
+            package test:files;
+
+            interface api {
+                /// Download a file.
+                download: func(url: string) -> stream<u8>;
+                /// Upload a file.
+                upload: func(name: string, data: stream<u8>) -> result<u64, string>;
+                discard: func(data: stream<u8>);
+            }
+
+            world files {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("files.wit", source)
+            .expect("failed to parse files WIT");
+        let world_id = resolve.packages[pkg_id].worlds["files"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func ApiDownload(url string) io.ReadCloser {"),
+            "byte stream results should be readers"
+        );
+        assert!(
+            code.contains(
+                "\t\tn := C.witffi_api_download_read(streamPtr, (*C.uint8_t)(unsafe.Pointer(&p[0])), C.size_t(len(p)))"
+            ),
+            "reads should fill the caller's buffer in one call"
+        );
+        assert!(
+            code.contains("\t\tC.witffi_api_download_drop(streamPtr)"),
+            "closing the reader should drop the producer"
+        );
+        assert!(
+            !code.contains("type Stream[T any]"),
+            "byte streams should not need the generic stream type"
+        );
+        assert!(
+            code.contains("func (r *byteReader) Close() error {"),
+            "readers should be closable"
+        );
+        assert!(
+            code.contains(
+                "func ApiUpload(name string) (io.WriteCloser, func() (uint64, error)) {\n\tdata, dataWriter := io.Pipe()\n\tstreamCall := func() (uint64, error) {"
+            ),
+            "byte stream parameters should be written to a returned writer"
+        );
+        assert!(
+            code.contains("// ApiUpload uploads a file.\n//\n// The data stream is written to the returned io.WriteCloser"),
+            "the writer should be documented after the WIT docs"
+        );
+        assert!(
+            code.contains(
+                "\t\tdataSource, dataHandle := byteSource(data)\n\t\tdefer dataHandle.Delete()"
+            ) && code.contains("C.witffi_api_upload(nameSlice, dataSource)"),
+            "the pipe should be lent to Rust for the call"
+        );
+        assert!(
+            code.contains("\t\tdefer data.Close()\n\t\tr0, r1 := streamCall()"),
+            "the pipe should be closed once the call returns"
+        );
+        assert!(
+            code.contains("func ApiDiscard() (io.WriteCloser, func()) {")
+                && code.contains("\t\tstreamCall()\n\t}()"),
+            "void calls should return a plain wait function"
+        );
+        assert!(
+            code.contains("//export witffi_byte_source_read")
+                && code.contains(
+                    "extern intptr_t witffi_byte_source_read(uintptr_t user_data, uint8_t *buf, size_t len);"
+                ),
+            "Rust should read byte streams through an exported function"
+        );
+        assert!(
+            code.contains("\t\"io\"\n") && code.contains("\t\"runtime/cgo\"\n"),
+            "byte streams should import io and runtime/cgo"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_error_contexts() {
+        let source = r#"
+            package test:contexts;
+
+            interface api {
+                type failure = error-context;
+
+                describe: func(ctx: error-context) -> string;
+                open: func(path: string) -> result<u32, error-context>;
+                watch: func(path: string) -> future<result<u32, failure>>;
+                first: func(ctxs: list<error-context>) -> option<error-context>;
+            }
+
+            world contexts {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("contexts.wit", source)
+            .expect("failed to parse contexts WIT");
+        let world_id = resolve.packages[pkg_id].worlds["contexts"];
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type ErrorContext struct {")
+                && code.contains("func (e *ErrorContext) Is(target error) bool {")
+                && code.contains("C.witffi_error_context_new_id()"),
+            "error contexts should be a Go error type with an identity"
+        );
+        assert!(
+            code.contains("func ApiDescribe(ctx *ErrorContext) string {")
+                && code.contains("C.witffi_api_describe(lowerErrorContext(ctx))"),
+            "error context parameters should be lowered"
+        );
+        assert!(
+            code.contains("func ApiOpen(path string) (uint32, error) {"),
+            "error-context errors should be returned as errors"
+        );
+        assert_eq!(
+            code.matches("\tvar errPtr *C.FfiErrorContext\n").count(),
+            2,
+            "error-context errors should be decoded from err_out, also when awaited"
+        );
+        assert!(
+            code.contains(
+                "\t\t\terr := liftErrorContext(*errPtr)\n\t\t\tC.free(unsafe.Pointer(errPtr))\n\t\t\treturn 0, err"
+            ),
+            "error-context errors should keep their message instead of the last error"
+        );
+        assert!(
+            code.contains("debug_message: allocs.bytes([]byte(e.DebugMessage()))"),
+            "error contexts in lists should be copied into C memory"
+        );
+        assert!(
+            !code.contains("C.uint32_t(ctx)"),
+            "error contexts should no longer be bare handles"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_context_params() {
+        let source = r#"
+            package test:cancel;
+
+            interface api {
+                parse: func(input: string) -> result<u32, string>;
+                count: func(input: string) -> u32;
+                tag: func(ctx: string) -> string;
+            }
+
+            world cancel {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("cancel.wit", source)
+            .expect("failed to parse cancel WIT");
+        let world_id = resolve.packages[pkg_id].worlds["cancel"];
+
+        let config = GoConfig {
+            context_params: true,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func ApiParse(ctx context.Context, input string) (uint32, error) {")
+                && code.contains("func ApiCount(ctx context.Context, input string) uint32 {"),
+            "exported functions should take a leading context"
+        );
+        assert!(
+            code.contains("\tif err := ctx.Err(); err != nil {\n\t\treturn 0, err\n\t}"),
+            "fallible functions should fail up front on a done context"
+        );
+        assert_eq!(
+            code.matches("\tdefer bindContext(ctx)()\n").count(),
+            2,
+            "every call should bind the context for Rust"
+        );
+        assert!(
+            code.contains("func ApiTag(callCtx context.Context, ctx string) string {")
+                && code.contains("\tdefer bindContext(callCtx)()\n"),
+            "the context should not clash with a WIT parameter named ctx"
+        );
+        assert!(
+            code.contains("func bindContext(ctx context.Context) func() {")
+                && code.contains("C.witffi_cancel_token_enter(token)")
+                && code.contains("C.witffi_cancel_token_cancel(token)")
+                && code.contains("\t\"context\"\n")
+                && code.contains("\t\"runtime\"\n"),
+            "the bindContext helper should be generated"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            plain.contains("func ApiParse(input string) (uint32, error) {")
+                && !plain.contains("bindContext"),
+            "contexts should be opt-in"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_timeouts() {
+        let source = r#"
+            package test:deadline;
+
+            interface api {
+                parse: func(input: string) -> result<u32, string>;
+                count: func(input: string) -> u32;
+                numbers: func() -> stream<u32>;
+            }
+
+            world deadline {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("deadline.wit", source)
+            .expect("failed to parse deadline WIT");
+        let world_id = resolve.packages[pkg_id].worlds["deadline"];
+
+        let config = GoConfig {
+            timeouts: HashMap::from([
+                ("api.parse".to_string(), Duration::from_millis(1500)),
+                ("api.count".to_string(), Duration::from_secs(2)),
+            ]),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "\ttimedCtx, timedCancel := context.WithTimeout(context.Background(), 1500 * time.Millisecond)"
+            ) && code.contains(
+                "\ttimedCtx, timedCancel := context.WithTimeout(context.Background(), 2 * time.Second)"
+            ),
+            "timed functions should derive a context with their deadline"
+        );
+        assert!(
+            code.contains("\ttimedCall := func() (uint32, error) {\n")
+                && code.contains("\t\tdefer bindContext(timedCtx)()\n")
+                && code.contains("\tcase <-timedCtx.Done():\n\t}\n\treturn 0, ErrTimeout\n"),
+            "fallible functions should return ErrTimeout while the call finishes"
+        );
+        assert!(
+            code.contains("func ApiCount(input string) uint32 {\n\ttimedCtx")
+                && code.contains("\tdefer timedCancel()\n\tdefer bindContext(timedCtx)()\n"),
+            "infallible functions should only cancel the call"
+        );
+        assert!(
+            code.contains("var ErrTimeout = errors.New(\"call timed out\")")
+                && code.contains("\t\"errors\"\n")
+                && code.contains("\t\"time\"\n"),
+            "ErrTimeout should be generated"
+        );
+
+        for (function, reason) in [
+            ("api.numbers", "it returns a stream"),
+            ("api.missing", "the world exports no such function"),
+        ] {
+            let config = GoConfig {
+                timeouts: HashMap::from([(function.to_string(), Duration::from_secs(1))]),
+                ..GoConfig::default()
+            };
+            let err = GoGenerator::new(&resolve, world_id, config)
+                .generate()
+                .expect_err("the timeout should be rejected");
+            assert!(
+                err.to_string().contains(reason),
+                "unexpected error for `{function}`: {err}"
+            );
+        }
+    }
+
+    #[test]
+    fn test_generate_go_dispatch_thread() {
+        let (resolve, world_id) = load_counter_wit();
+
+        let config = GoConfig {
+            dispatch_thread: true,
+            resource_cleanup: ResourceCleanup::AddCleanup,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("#include <pthread.h>")
+                && code
+                    .contains("\t\truntime.LockOSThread()\n\t\tdispatchThread = C.pthread_self()")
+                && code.contains("func dispatch(call func()) {")
+                && code.contains("\tif C.pthread_equal(C.pthread_self(), dispatchThread) != 0 {"),
+            "a dispatcher locked to one thread should be generated"
+        );
+        assert!(
+            code.contains("func TypesTryCounter(start string) (*Counter, error) {\n\tdispatchedCall := func() (*Counter, error) {\n")
+                && code.contains("\t\tr0, r1 := dispatchedCall()\n")
+                && code.contains("\treturn dispatchedResult()\n"),
+            "wrappers should run their call on the dispatcher"
+        );
+        assert!(
+            code.contains("\tdispatch(dispatchedCall)\n"),
+            "wrappers without results should be dispatched too"
+        );
+        assert!(
+            code.contains("\tdispatch(func() { C.witffi_types_counter_drop(c.handle) })")
+                && code.contains(
+                    "runtime.AddCleanup(c, func(h *C.FfiCounter) { dispatch(func() { C.witffi_types_counter_drop(h) }) }, handle)"
+                ),
+            "handles should be released on the dispatcher"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            !plain.contains("dispatch(")
+                && plain.contains("\tC.witffi_types_counter_drop(c.handle)\n"),
+            "the dispatcher should be opt-in"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_blocking() {
+        let source = r#"
+            package test:slow;
+
+            interface api {
+                wait: func(millis: u32) -> result<u32, string>;
+                sleep: func(millis: u32);
+                fast: func() -> u32;
+            }
+
+            world slow {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("slow.wit", source)
+            .expect("failed to parse slow WIT");
+        let world_id = resolve.packages[pkg_id].worlds["slow"];
+
+        let config = GoConfig {
+            blocking: HashSet::from(["api.wait".to_string(), "api.sleep".to_string()]),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("static __thread int blocking_worker;")
+                && code.contains("func InitBlockingPool(size int) {")
+                && code.contains("\t\t\t\tC.enter_blocking_worker()")
+                && code.contains("\tInitBlockingPool(runtime.GOMAXPROCS(0))")
+                && code.contains("\tif C.on_blocking_worker() != 0 {"),
+            "a bounded worker pool should be generated"
+        );
+        assert!(
+            code.contains("func ApiWait(millis uint32) (uint32, error) {\n\tdispatchedCall := func() (uint32, error) {\n")
+                && code.contains("\trunBlocking(func() {\n\t\tr0, r1 := dispatchedCall()\n")
+                && code.contains("\trunBlocking(dispatchedCall)\n"),
+            "blocking functions should run on the pool"
+        );
+        assert!(
+            code.contains("func ApiFast() uint32 {\n\tresult := C.witffi_api_fast()"),
+            "other functions should be called directly"
+        );
+
+        let config = GoConfig {
+            blocking: HashSet::from(["api.missing".to_string()]),
+            ..GoConfig::default()
+        };
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("unknown functions should be rejected");
+        assert!(err.to_string().contains("`api.missing`"), "{err}");
+
+        let config = GoConfig {
+            blocking: HashSet::from(["api.wait".to_string()]),
+            dispatch_thread: true,
+            ..GoConfig::default()
+        };
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("the dispatcher thread should rule out the pool");
+        assert!(err.to_string().contains("dispatcher thread"), "{err}");
+    }
+
+    #[test]
+    fn test_generate_go_single_threaded() {
+        let (resolve, world_id) = load_counter_wit();
+
+        let config = GoConfig {
+            single_threaded: HashSet::from(["types.counter".to_string()]),
+            resource_cleanup: ResourceCleanup::AddCleanup,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("var counterMu callLock")
+                && code.contains("// It is single-threaded: calls on it are serialized"),
+            "the resource should get a documented lock"
+        );
+        assert!(
+            code.contains("func (c CounterRef) Get() uint64 {\n\tdefer counterMu.lock()()\n"),
+            "methods should hold the resource lock"
+        );
+        assert!(
+            code.contains("\tdefer counterMu.lock()()\n\tc.cleanup.Stop()\n")
+                && code.contains("func(h *C.FfiCounter) {\n\t\tdefer counterMu.lock()()\n\t\tC.witffi_types_counter_drop(h)\n\t}, handle)"),
+            "handles should be dropped under the resource lock"
+        );
+        // Imports reentering Rust run on the holding thread, and pass through
+        assert!(
+            code.contains("static uintptr_t current_thread(void) { return (uintptr_t)pthread_self(); }")
+                && code.contains("\tthread := uintptr(C.current_thread())\n\tif l.holder.Load() == thread {\n\t\treturn runtime.UnlockOSThread\n\t}\n\tl.mu.Lock()\n"),
+            "the lock should let its holding thread through"
+        );
+        assert!(
+            !code.contains("lock()()\n\truntime.LockOSThread()"),
+            "the lock already holds the goroutine on its thread"
+        );
+        assert!(
+            !code.contains("func TypesTryCounter(start string) (*Counter, error) {\n\tcounterMu"),
+            "functions outside the resource should not lock it"
+        );
+
+        let config = GoConfig {
+            single_threaded: HashSet::from(["types".to_string()]),
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            code.contains("var typesInterfaceMu callLock")
+                && code.contains("// Calls are serialized with every other call into the single-threaded\n// `types` interface.\nfunc TypesTryCounter(start string) (*Counter, error) {\n\tdefer typesInterfaceMu.lock()()\n")
+                && code.contains("func (c CounterRef) Get() uint64 {\n\tdefer typesInterfaceMu.lock()()\n"),
+            "the whole interface should share one lock"
+        );
+
+        let config = GoConfig {
+            single_threaded: HashSet::from(["types.missing".to_string()]),
+            ..GoConfig::default()
+        };
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("unknown resources should be rejected");
+        assert!(err.to_string().contains("`types.missing`"), "{err}");
+    }
+
+    #[test]
+    fn test_generate_go_batch_functions() {
+        let source = r#"
+            package test:uris;
+
+            interface parser {
+                parse: func(input: string) -> result<u32, string>;
+            }
+
+            world uris {
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("uris.wit", source)
+            .expect("failed to parse uris WIT");
+        let world_id = resolve.packages[pkg_id].worlds["uris"];
+        witffi_core::add_batch_functions(&mut resolve, world_id, &["parser.parse".to_string()])
+            .expect("failed to add batch functions");
+
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "// Batched form of `parse`, calling it once per element of its list arguments\n// in a single call across the FFI boundary.\nfunc ParserParseBatch(input []string) []Result[uint32, string] {"
+            ),
+            "the batched form should take and return slices"
+        );
+        assert!(
+            code.contains("C.witffi_parser_parse_batch(inputList)"),
+            "the batch should be passed to C in one call"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_into_variants() {
+        let source = r#"
+            package test:uris;
+
+            interface parser {
+                normalize: func(input: string) -> result<string, string>;
+                encode: func(input: string) -> list<u8>;
+                count: func(input: string) -> u32;
+            }
+
+            world uris {
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("uris.wit", source)
+            .expect("failed to parse uris WIT");
+        let world_id = resolve.packages[pkg_id].worlds["uris"];
+
+        let config = GoConfig {
+            into_variants: true,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func ParserNormalizeInto(input string, dst []byte) (int, error) {")
+                && code.contains("func ParserEncodeInto(input string, dst []byte) (int, error) {")
+                && !code.contains("ParserCountInto"),
+            "string and byte results should get an Into variant"
+        );
+        assert!(
+            code.contains("\t\tdstPtr = (*C.uint8_t)(unsafe.Pointer(&dst[0]))\n")
+                && code.contains(
+                    "\tneeded := C.witffi_parser_normalize_into(inputSlice, dstPtr, C.size_t(len(dst)))\n"
+                )
+                && code.contains("\treturn int(needed), nil\n"),
+            "the variant should pass the caller's buffer and return the needed length"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(!plain.contains("Into("), "Into variants should be opt-in");
+    }
+
+    #[test]
+    fn test_generate_go_buffer_pool() {
+        let source = r#"
+            package test:rows;
+
+            interface table {
+                insert: func(rows: list<list<string>>) -> result<u32, string>;
+            }
+
+            world rows {
+                export table;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("rows.wit", source)
+            .expect("failed to parse rows WIT");
+        let world_id = resolve.packages[pkg_id].worlds["rows"];
+
+        let code = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("var allocsPool = sync.Pool{New: func() any { return new(cAllocs) }}")
+                && code.contains("\t*a = (*a)[:0]\n\tallocsPool.Put(a)\n"),
+            "allocation lists should be recycled through a pool"
+        );
+        assert!(
+            code.contains(
+                "\tscratch := scratchPool.Get().(*[]byte)\n\tdefer scratchPool.Put(scratch)\n"
+            ),
+            "error messages should be staged in pooled buffers"
+        );
+        assert!(code.contains("\tallocs := newCAllocs()\n\tdefer allocs.free()\n"));
+
+        let config = GoConfig {
+            buffer_pool: false,
+            ..GoConfig::default()
+        };
+        let unpooled = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            !unpooled.contains("Pool") && !unpooled.contains("\t\"sync\""),
+            "disabling the pool should leave no pools behind"
+        );
+        assert!(unpooled.contains("\tbuf := make([]byte, length)\n"));
+        assert!(unpooled.contains("func newCAllocs() *cAllocs {\n\treturn new(cAllocs)\n}"));
+    }
+
+    #[test]
+    fn test_generate_go_pin_bytes() {
+        let source = r#"
+            package test:blobs;
+
+            interface store {
+                put-all: func(blobs: list<list<u8>>) -> u32;
+                put-maybe: func(blob: option<list<u8>>, label: option<string>) -> u32;
+            }
+
+            world blobs {
+                export store;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("blobs.wit", source)
+            .expect("failed to parse blobs WIT");
+        let world_id = resolve.packages[pkg_id].worlds["blobs"];
+
+        let config = GoConfig {
+            pin_bytes: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "type cAllocs struct {\n\tptrs   []unsafe.Pointer\n\tpinner runtime.Pinner\n}"
+            ) && code.contains("\t\"runtime\"\n"),
+            "allocations should carry a pinner"
+        );
+        assert!(
+            code.contains("\tp := unsafe.SliceData(b)\n\ta.pinner.Pin(p)\n")
+                && code.contains("func (a *cAllocs) free() {\n\ta.pinner.Unpin()\n"),
+            "byte slices should stay pinned until the call returns"
+        );
+        assert!(
+            code.contains("\t\telems[i] = allocs.pin(e)"),
+            "nested byte lists should be pinned rather than copied"
+        );
+        assert!(
+            code.contains("\t\tblobC := allocs.pin(blobValue)")
+                && code.contains("\t\tlabelData := C.CBytes([]byte(labelValue))"),
+            "optional byte lists should be pinned, while strings are still copied"
+        );
+
+        let copied = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            !copied.contains("Pin") && copied.contains("\t\telems[i] = allocs.bytes(e)"),
+            "byte slices should be copied unless pinning is enabled"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_borrowed_strings() {
+        let source = r#"
+            package test:docs;
+
+            interface render {
+                page: func(id: u32) -> string;
+                summary: func(id: u32) -> result<string, string>;
+                count: func() -> u32;
+            }
+
+            world docs {
+                export render;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("docs.wit", source)
+            .expect("failed to parse docs WIT");
+        let world_id = resolve.packages[pkg_id].worlds["docs"];
+
+        let config = GoConfig {
+            borrowed_strings: ["render.page", "render.summary"]
+                .into_iter()
+                .map(String::from)
+                .collect(),
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type BorrowedString struct {")
+                && code.contains(
+                    "\treturn unsafe.String((*byte)(unsafe.Pointer(s.b.buf.ptr)), int(s.b.buf.len))"
+                )
+                && code.contains("\t\tpanic(\"BorrowedString used after Release\")"),
+            "borrowed strings should wrap the Rust buffer and refuse use after release"
+        );
+        assert!(
+            code.contains("func RenderPage(id uint32) BorrowedString {")
+                && code.contains("\treturn borrowString(result)\n"),
+            "direct string results should be borrowed"
+        );
+        assert!(
+            code.contains("func RenderSummary(id uint32) (BorrowedString, error) {")
+                && code.contains("\t\treturn BorrowedString{}, fmt.Errorf(")
+                && code.contains(
+                    "\tresult := borrowString(*resultPtr)\n\tC.free(unsafe.Pointer(resultPtr))\n\treturn result, nil\n"
+                ),
+            "string ok values should be borrowed, freeing only their box"
+        );
+
+        let config = GoConfig {
+            borrowed_strings: ["render.count".to_string()].into_iter().collect(),
+            ..GoConfig::default()
+        };
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("a non-string result cannot be borrowed");
+        assert_eq!(
+            err.to_string(),
+            "cannot borrow the result of `render.count`: it does not return a string"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_list_views() {
+        let source = r#"
+            package test:ledger;
+
+            interface ledger {
+                record entry {
+                    account: string,
+                    amount: s64,
+                }
+
+                record point {
+                    x: u32,
+                    y: u32,
+                }
+
+                entries: func() -> list<entry>;
+                points: func(limit: u32) -> result<list<point>, string>;
+                total: func() -> s64;
+            }
+
+            world books {
+                export ledger;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("ledger.wit", source)
+            .expect("failed to parse ledger WIT");
+        let world_id = resolve.packages[pkg_id].worlds["books"];
+
+        let config = GoConfig {
+            list_views: ["ledger.entries", "ledger.points"]
+                .into_iter()
+                .map(String::from)
+                .collect(),
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type ListView[T any] struct {")
+                && code.contains("func (v *ListView[T]) At(i int) T {")
+                && code.contains("func (v *ListView[T]) Close() {"),
+            "list views should be generated"
+        );
+        assert!(
+            code.contains("func LedgerEntries() *ListView[Entry] {")
+                && code.contains(
+                    "\treturn newListView(len(elems), func(i int) Entry { return convertEntry(elems[i]) }, true, func() { C.witffi_free_list_entry(result) })"
+                ),
+            "records owning strings should be lifted on demand and drained on close"
+        );
+        assert!(
+            code.contains("func LedgerPoints(limit uint32) (*ListView[Point], error) {")
+                && code.contains("\tlist := *resultPtr\n\tC.free(unsafe.Pointer(resultPtr))\n")
+                && code.contains(
+                    "func(i int) Point { return convertPoint(elems[i]) }, false, func() { C.witffi_free_list_point(list) }), nil"
+                ),
+            "plain records need no draining, and only the result's box is freed"
+        );
+
+        let config = GoConfig {
+            list_views: ["ledger.total".to_string()].into_iter().collect(),
+            ..GoConfig::default()
+        };
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("a non-list result cannot be viewed");
+        assert_eq!(
+            err.to_string(),
+            "cannot return a list view from `ledger.total`: it does not return a list (other than list<u8>)"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_arena() {
+        let source = r#"
+            package test:tags;
+
+            interface tags {
+                tag-all: func(names: list<string>, note: option<string>) -> u32;
+            }
+
+            world tagging {
+                export tags;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tags.wit", source)
+            .expect("failed to parse tags WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tagging"];
+
+        let config = GoConfig {
+            arena_chunk_size: Some(8192),
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("const arenaChunkSize = 8192")
+                && code.contains("\t\ta.next = C.malloc(C.size_t(n))\n")
+                && code.contains("\ta.next = unsafe.Add(a.next, size)\n"),
+            "allocations should be carved out of arena chunks"
+        );
+        assert!(
+            code.contains("\tcopy(unsafe.Slice((*byte)(p), len(b)), b)\n")
+                && !code.contains("C.CBytes("),
+            "strings should be staged in the arena, including optional ones"
+        );
+        assert!(
+            code.contains("\t\tnoteC := allocs.bytes([]byte(noteValue))"),
+            "optional strings should be staged through the call's arena"
+        );
+        assert!(
+            code.contains("func ReadArenaStats() ArenaStats {")
+                && code.contains("\t\"sync/atomic\"\n"),
+            "arena use should be reported"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(!plain.contains("arena"), "arenas should be opt-in");
+    }
+
+    #[test]
+    fn test_generate_go_columnar_lists() {
+        let source = r#"
+            package test:geo;
+
+            interface shapes {
+                record point {
+                    x: f64,
+                    y: f64,
+                    visible: bool,
+                }
+
+                outline: func(sides: u32) -> result<list<point>, string>;
+                corners: func() -> list<point>;
+            }
+
+            world geo {
+                export shapes;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("geo.wit", source)
+            .expect("failed to parse geo WIT");
+        let world_id = resolve.packages[pkg_id].worlds["geo"];
+
+        let config = GoConfig {
+            columnar: ["point".to_string()].into_iter().collect(),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func ShapesOutline(sides uint32) ([]Point, error) {")
+                && code.contains("func ShapesCorners() []Point {"),
+            "the wrappers should still return slices"
+        );
+        assert!(
+            code.contains("\tvar columns C.FfiPointColumns\n")
+                && code.contains(
+                    "\tsuccess := C.witffi_shapes_outline_columns(C.uint32_t(sides), &columns)\n"
+                )
+                && code.contains("\treturn convertPointColumns(columns), nil\n"),
+            "list<point> results should be fetched column by column"
+        );
+        assert!(
+            code.contains("func convertPointColumns(ffi C.FfiPointColumns) []Point {")
+                && code.contains("\t\txColumn := unsafe.Slice(ffi.x, len(result))\n")
+                && code.contains("\t\t\t\tVisible: bool(visibleColumn[i]),\n")
+                && code.contains("\tC.witffi_free_point_columns(ffi)\n"),
+            "the columns should be gathered into the slice and freed"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(!plain.contains("Columns"), "columns should be opt-in");
+    }
+
+    #[test]
+    fn test_generate_go_cgo_annotations() {
+        let source = r#"
+            package test:uris;
+
+            interface parser {
+                normalize: func(input: string) -> result<string, string>;
+                scan: func(input: string) -> stream<u32>;
+                fetch: async func(url: string) -> string;
+                keep: func(input: list<u8>);
+            }
+
+            world uris {
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("uris.wit", source)
+            .expect("failed to parse uris WIT");
+        let world_id = resolve.packages[pkg_id].worlds["uris"];
+
+        let config = GoConfig {
+            cgo_annotations: true,
+            cgo_unannotated: ["parser.keep".to_string()].into_iter().collect(),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "#cgo noescape witffi_error_message_utf8\n#cgo nocallback witffi_error_message_utf8\n"
+            ) && code.contains(
+                "#cgo noescape witffi_parser_normalize\n#cgo nocallback witffi_parser_normalize\n"
+            ),
+            "helpers and synchronous functions should be annotated"
+        );
+        assert!(
+            !code.contains("noescape witffi_parser_scan")
+                && !code.contains("noescape witffi_parser_fetch"),
+            "streams and async functions should not be annotated"
+        );
+        assert!(
+            !code.contains("noescape witffi_parser_keep"),
+            "exempted functions should not be annotated"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            !plain.contains("#cgo noescape"),
+            "annotations should be opt-in"
+        );
+
+        let config = GoConfig {
+            cgo_unannotated: ["parser.missing".to_string()].into_iter().collect(),
+            ..GoConfig::default()
+        };
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("an unknown function should be rejected");
+        assert!(
+            err.to_string().contains("parser.missing"),
+            "the error should name the function"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_intern_strings() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) =
+            witffi_core::load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        let config = GoConfig {
+            intern_strings: Some(32),
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\tinternedStringMaxLen = 32\n"),
+            "the length limit should be configurable"
+        );
+        assert!(
+            code.contains("\tif int(buf.len) <= internedStringMaxLen {\n\t\ts := internString(unsafe.Slice((*byte)(unsafe.Pointer(buf.ptr)), int(buf.len)))\n"),
+            "short strings should be lifted through the table"
+        );
+        assert!(
+            code.contains("\tif s, ok := internedStrings.m[string(b)]; ok {\n\t\treturn s\n\t}\n"),
+            "repeated strings should share the interned copy"
+        );
+        assert!(code.contains("\t\"sync\"\n"), "the table needs a mutex");
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            !plain.contains("internString"),
+            "interning should be opt-in"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_list_seqs() {
+        let source = r#"
+            package test:ledger;
+
+            interface ledger {
+                record entry {
+                    memo: string,
+                    amount: u64,
+                }
+
+                entries: func(account: string) -> result<list<entry>, string>;
+                totals: func() -> list<u64>;
+            }
+
+            world books {
+                export ledger;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("ledger.wit", source)
+            .expect("failed to parse ledger WIT");
+        let world_id = resolve.packages[pkg_id].worlds["books"];
+
+        let config = GoConfig {
+            list_seqs: ["ledger.entries", "ledger.totals"]
+                .into_iter()
+                .map(String::from)
+                .collect(),
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func LedgerEntries(account string) ([]Entry, error) {")
+                && code.contains(
+                    "func LedgerEntriesSeq(account string) (iter.Seq[Entry], error) {\n\tview, err := func() (*ListView[Entry], error) {\n"
+                )
+                && code.contains("func LedgerTotalsSeq() iter.Seq[uint64] {"),
+            "list results should get a Seq variant next to the plain wrapper"
+        );
+        assert!(
+            code.contains("\treturn view.All(), nil\n") && code.contains("\treturn view.All()\n"),
+            "the variant should range over a list view"
+        );
+        assert!(
+            code.contains("func (v *ListView[T]) All() iter.Seq[T] {")
+                && code.contains("\t\tfor i := v.ranged; i < v.n; i++ {\n"),
+            "elements the range skipped should still be freed"
+        );
+        assert!(code.contains("\t\"iter\"\n"), "iter should be imported");
+
+        let config = GoConfig {
+            list_seqs: ["ledger.missing".to_string()].into_iter().collect(),
+            ..GoConfig::default()
+        };
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("an unknown function should be rejected");
+        assert!(
+            err.to_string().contains("ledger.missing"),
+            "the error should name the function"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_alloc_accounting() {
+        let source = r#"
+            package test:shapes;
+
+            interface shapes {
+                record rect {
+                    w: f64,
+                    h: f64,
                 }
+
+                area: func(input: string) -> result<f64, string>;
+                find: func(id: u32) -> option<rect>;
+            }
+
+            world geo {
+                export shapes;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("shapes.wit", source)
+            .expect("failed to parse shapes WIT");
+        let world_id = resolve.packages[pkg_id].worlds["geo"];
+
+        let config = GoConfig {
+            alloc_accounting: true,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        let files = generator
+            .generate_alloc_accounting_files()
+            .expect("failed to generate accounting files");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("freeRustBox(unsafe.Pointer(resultPtr))")
+                && code.contains("freeRustBox(unsafe.Pointer(result))")
+                && !code.contains("C.free(unsafe.Pointer(result"),
+            "Rust boxes should be freed through freeRustBox"
+        );
+
+        let [(debug_name, debug), (release_name, release)] = files.as_slice() else {
+            panic!("expected a debug and a release file");
+        };
+        eprintln!("--- {debug_name} ---\n{debug}\n--- {release_name} ---\n{release}");
+        assert!(
+            debug.contains("//go:build witffidebug\n")
+                && debug
+                    .contains("func freeRustBox(p unsafe.Pointer) {\n\tC.witffi_free_box(p)\n}"),
+            "the debug build should free through the library"
+        );
+        assert!(
+            debug.contains("func AllocStats() []AllocStat {\n\ttext := ffiByteBufferToString(C.witffi_alloc_stats())")
+                && debug.contains("func CheckNoLeaks(t testing.TB) {"),
+            "the debug build should expose the counts"
+        );
+        assert!(
+            release.contains("//go:build !witffidebug\n")
+                && release.contains("func freeRustBox(p unsafe.Pointer) {\n\tC.free(p)\n}"),
+            "the release build should free directly"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        assert!(
+            !plain
+                .generate()
+                .expect("failed to generate Go code")
+                .contains("freeRustBox")
+                && plain
+                    .generate_alloc_accounting_files()
+                    .expect("failed to generate accounting files")
+                    .is_empty(),
+            "accounting should be opt-in"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_alloc_canaries() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) =
+            witffi_core::load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        let config = GoConfig {
+            alloc_canaries: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        assert!(
+            code.contains("C.witffi_free_box(unsafe.Pointer(")
+                && !code.contains("C.free(unsafe.Pointer(result"),
+            "Rust boxes should be freed through the library"
+        );
+
+        let config = GoConfig {
+            alloc_canaries: true,
+            alloc_accounting: true,
+            ..GoConfig::default()
+        };
+        let files = GoGenerator::new(&resolve, world_id, config)
+            .generate_alloc_accounting_files()
+            .expect("failed to generate accounting files");
+        assert!(
+            files.iter().all(|(_, code)| !code.contains("C.free(p)")),
+            "no build should free Rust boxes with C.free"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_cgocheck_tests() {
+        let source = r#"
+            package test:tags;
+
+            interface tags {
+                tag: func(names: list<string>, notes: list<option<string>>, pairs: list<tuple<string, u32>>);
+                count: func() -> u32;
+            }
+
+            world tagger {
+                export tags;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tags.wit", source)
+            .expect("failed to parse tags WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tagger"];
+
+        let config = GoConfig {
+            cgocheck_tests: true,
+            ..GoConfig::default()
+        };
+        let (file_name, test) = GoGenerator::new(&resolve, world_id, config)
+            .generate_cgocheck_test()
+            .expect("failed to generate cgocheck test")
+            .expect("lowered lists should get a cgocheck test");
+
+        eprintln!("--- {file_name} ---\n{test}\n--- End ---");
+
+        assert_eq!(file_name, "cgocheck_test.go");
+        assert!(
+            test.contains("func TestCgocheckLowering(t *testing.T) {"),
+            "missing the test"
+        );
+        assert!(
+            test.contains("\t\t_ = lowerListString([]string{\"witffi\"}, allocs)")
+                && test.contains(
+                    "\t\t_ = lowerListOptionString([]*string{cgocheckPtr[string](\"witffi\")}, allocs)"
+                )
+                && test.contains("{F0: \"witffi\", F1: 1}}, allocs)"),
+            "every list shape should be lowered with non-empty samples"
+        );
+        assert!(
+            test.contains("\t\tdefer allocs.free()"),
+            "the C memory should be released"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate_cgocheck_test()
+            .expect("failed to generate cgocheck test");
+        assert!(plain.is_none(), "the cgocheck test should be opt-in");
+    }
+
+    #[test]
+    fn test_generate_go_foreign_panics() {
+        let source = r#"
+            package test:counters;
+
+            interface counters {
+                parse: func(input: string) -> result<u32, string>;
+                count: func() -> u32;
+                reset: func();
+            }
+
+            world tally {
+                export counters;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("counters.wit", source)
+            .expect("failed to parse counters WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tally"];
+
+        let config = GoConfig {
+            foreign_panics: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type ErrForeignPanic struct {")
+                && code.contains("func init() {\n\tC.witffi_capture_panic_backtraces()\n}"),
+            "missing ErrForeignPanic"
+        );
+        assert!(
+            code.contains("lastError(\"witffi_counters_parse\")")
+                && !code.contains("fmt.Errorf(\"witffi_counters_parse failed"),
+            "failed calls should report panics through lastError"
+        );
+        assert!(
+            code.contains("\tresult := C.witffi_counters_count()\n\tcheckForeignPanic(\"witffi_counters_count\")\n")
+                && code.contains("\tC.witffi_counters_reset()\n\tcheckForeignPanic(\"witffi_counters_reset\")\n"),
+            "calls without an error should raise panics"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            !plain.contains("ErrForeignPanic")
+                && plain
+                    .contains("fmt.Errorf(\"witffi_counters_parse failed: %s\", readLastError())"),
+            "panic errors should be opt-in"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_import_panics() {
+        let source = r#"
+            package test:host;
+
+            interface host {
+                now: func() -> u64;
             }
 
-            TypeDefKind::Enum(e) => {
-                let go_name = names::to_go_type(wit_name);
-                writeln!(out)?;
-                if let Some(docs) = &typedef.docs.contents {
-                    Self::write_doc_comment(out, docs, "")?;
-                }
-                writeln!(out, "type {go_name} uint32")?;
-                writeln!(out)?;
-                writeln!(out, "const (")?;
-                for (i, case) in e.cases.iter().enumerate() {
-                    let variant_name = format!("{}{}", go_name, names::to_go_type(&case.name));
-                    if let Some(docs) = &case.docs.contents {
-                        Self::write_doc_comment(out, docs, "\t")?;
-                    }
-                    if i == 0 {
-                        writeln!(out, "\t{variant_name} {go_name} = iota")?;
-                    } else {
-                        writeln!(out, "\t{variant_name}")?;
-                    }
-                }
-                writeln!(out, ")")?;
+            interface clock {
+                tick: func() -> u64;
             }
 
-            TypeDefKind::Flags(flags) => {
-                let go_name = names::to_go_type(wit_name);
-                writeln!(out)?;
-                if let Some(docs) = &typedef.docs.contents {
-                    Self::write_doc_comment(out, docs, "")?;
+            world app {
+                import host;
+                export clock;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("host.wit", source)
+            .expect("failed to parse host WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
+
+        let code = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func recoverImportPanic() {\n\tp := recover()\n")
+                && code.contains("\tC.witffi_import_panicked(C.FfiByteSlice{\n"),
+            "recovered panics should be reported to Rust"
+        );
+        assert!(
+            code.contains(
+                "func witffi_import_host_now() C.uint64_t {\n\tdefer recoverImportPanic()\n"
+            ),
+            "every import should recover its panics"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_error_enum_sentinels() {
+        let source = r#"
+            package test:payments;
+
+            interface payments {
+                enum parse-error {
+                    invalid-address,
+                    timeout,
                 }
-                writeln!(out, "type {go_name} uint32")?;
-                writeln!(out)?;
-                writeln!(out, "const (")?;
-                for (i, flag) in flags.flags.iter().enumerate() {
-                    let flag_name = format!("{}{}", go_name, names::to_go_type(&flag.name));
-                    if let Some(docs) = &flag.docs.contents {
-                        Self::write_doc_comment(out, docs, "\t")?;
-                    }
-                    if i == 0 {
-                        writeln!(out, "\t{flag_name} {go_name} = 1 << iota")?;
-                    } else {
-                        writeln!(out, "\t{flag_name}")?;
-                    }
+
+                enum send-error {
+                    insufficient-funds,
+                    timeout,
                 }
-                writeln!(out, ")")?;
+
+                parse: func(input: string) -> result<u64, parse-error>;
+                send: func(amount: u64) -> result<_, send-error>;
             }
 
-            TypeDefKind::Type(inner) => {
-                let go_name = names::to_go_type(wit_name);
-                let inner_ty = self.type_to_go(inner);
-                // Skip self-referential aliases
-                if go_name != inner_ty {
-                    writeln!(out)?;
-                    writeln!(out, "type {go_name} = {inner_ty}")?;
-                }
+            world wallet {
+                export payments;
             }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("payments.wit", source)
+            .expect("failed to parse payments WIT");
+        let world_id = resolve.packages[pkg_id].worlds["wallet"];
 
-            TypeDefKind::List(_)
-            | TypeDefKind::Option(_)
-            | TypeDefKind::Result(_)
-            | TypeDefKind::Tuple(_) => {
-                // Handled inline when they appear as field/param types
+        let code = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\tErrInvalidAddress error = ParseErrorInvalidAddress\n"),
+            "error enum cases should have sentinels"
+        );
+        assert!(
+            code.contains("\tErrParseErrorTimeout error = ParseErrorTimeout\n")
+                && code.contains("\tErrSendErrorTimeout error = SendErrorTimeout\n"),
+            "cases shared between error enums should be qualified"
+        );
+        assert!(
+            code.contains("\tErrInsufficientFunds error = SendErrorInsufficientFunds\n"),
+            "unshared cases should stay short"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_error_chains() {
+        let source = r#"
+            package test:amounts;
+
+            interface amounts {
+                parse: func(input: string) -> result<u64, string>;
             }
 
-            other => {
-                writeln!(
-                    out,
-                    "// TODO: generate Go type for {wit_name} (kind: {})",
-                    other.as_str()
-                )?;
+            world app {
+                export amounts;
             }
-        }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("amounts.wit", source)
+            .expect("failed to parse amounts WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
 
-        Ok(())
+        let config = GoConfig {
+            error_chains: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\t\"strings\"\n") && code.contains("type ErrorChain struct {"),
+            "missing ErrorChain"
+        );
+        assert!(
+            code.contains("\tmessages := strings.Split(readLastError(), \"\\x1e\")\n"),
+            "chains should be split on the record separator"
+        );
+        assert!(
+            code.contains("\treturn fmt.Errorf(\"%s failed: %w\", op, readErrorChain())\n")
+                && code.contains("lastError(\"witffi_amounts_parse\")"),
+            "failed calls should wrap the rebuilt chain"
+        );
+        assert!(
+            !code.contains("takeForeignPanic"),
+            "chains should not need foreign panics"
+        );
     }
 
-    // ---- Conversion functions ----
+    #[test]
+    fn test_generate_go_abi_check() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) = witffi_core::load_wit(&wit_path).expect("failed to load WIT");
 
-    fn generate_conversion_functions(&self, out: &mut String) -> std::fmt::Result {
-        writeln!(out, "// ---- Conversion Functions ----")?;
+        let code = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
 
-        let reachable = self.collect_reachable_types();
-        for type_id in &reachable {
-            let typedef = &self.resolve.types[*type_id];
-            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+        let hash = format!("{:#018x}", witffi_core::abi_hash(&resolve, world_id));
+        assert!(
+            code.contains(&format!("const abiHash = {hash}\n")),
+            "the bindings should embed the hash of their world"
+        );
+        assert!(
+            code.contains("\tif uint64(C.witffi_abi_hash()) != abiHash {\n\t\tpanic(\"witffi: bindings out of date"),
+            "a mismatched library should fail at init"
+        );
+    }
 
-            match &typedef.kind {
-                TypeDefKind::Record(record) => {
-                    self.generate_record_conversion(out, wit_name, record)?;
-                }
-                TypeDefKind::Variant(variant) => {
-                    self.generate_variant_conversion(out, wit_name, variant)?;
-                }
-                _ => {
-                    // Other type kinds don't need conversion functions
-                }
+    #[test]
+    fn test_generate_go_lifecycle() {
+        let source = r#"
+            package test:host;
+
+            interface host {
+                log: func(message: string);
             }
-        }
 
-        Ok(())
-    }
+            interface clock {
+                tick: func() -> u64;
+            }
 
-    fn generate_record_conversion(
-        &self,
-        out: &mut String,
-        wit_name: &str,
-        record: &wit_parser::Record,
-    ) -> std::fmt::Result {
-        let go_name = names::to_go_type(wit_name);
-        let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+            world app {
+                import host;
+                export clock;
+            }
 
-        writeln!(out)?;
-        writeln!(out, "func convert{go_name}(ffi C.{c_name}) {go_name} {{")?;
+            world standalone {
+                export clock;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("host.wit", source)
+            .expect("failed to parse host WIT");
+        let config = || GoConfig {
+            lifecycle: true,
+            ..GoConfig::default()
+        };
 
-        // Separate fields into required (set in struct literal) and optional
-        // (set after construction)
-        let mut required_fields = Vec::new();
-        let mut optional_fields = Vec::new();
+        let world_id = resolve.packages[pkg_id].worlds["standalone"];
+        let code = GoGenerator::new(&resolve, world_id, config())
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+        assert!(
+            code.contains("func Init(opts ...InitOption) error {\n\treturn initLibrary(opts)\n}")
+                && code.contains("func Shutdown() {"),
+            "missing Init and Shutdown"
+        );
+        assert!(
+            code.contains("\tif !C.witffi_init(options) {\n")
+                && code.contains("\tC.witffi_shutdown()\n"),
+            "Init and Shutdown should call into Rust"
+        );
+        assert!(
+            code.contains("func WithOption(key, value string) InitOption {"),
+            "missing WithOption"
+        );
 
-        for field in &record.fields {
-            if self.is_option_type(&field.ty) {
-                optional_fields.push(field);
-            } else {
-                required_fields.push(field);
+        let world_id = resolve.packages[pkg_id].worlds["app"];
+        let code = GoGenerator::new(&resolve, world_id, config())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            code.contains("func Init(imports Imports, opts ...InitOption) error {")
+                && code.contains("\treturn initLibrary(opts)\n}")
+                && code.matches("func Init(").count() == 1,
+            "a world with imports should take the options in its Init"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_lazy_init() {
+        let source = r#"
+            package test:lazy;
+
+            interface parser {
+                parse: func(input: string) -> result<u32, string>;
             }
-        }
 
-        // Build the struct literal with required fields
-        writeln!(out, "\tresult := {go_name}{{")?;
-        for field in &required_fields {
-            let go_field = names::to_go_field(&field.name);
-            let c_field = names::to_rust_ident(&field.name);
-            let conversion = self.convert_ffi_to_go(&field.ty, &format!("ffi.{c_field}"));
-            writeln!(out, "\t\t{go_field}: {conversion},")?;
-        }
-        writeln!(out, "\t}}")?;
+            world lazy {
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("lazy.wit", source)
+            .expect("failed to parse lazy WIT");
+        let world_id = resolve.packages[pkg_id].worlds["lazy"];
 
-        // Handle optional fields
-        for field in &optional_fields {
-            let go_field = names::to_go_field(&field.name);
-            let c_field = names::to_rust_ident(&field.name);
-            self.generate_optional_field_conversion(out, &go_field, &c_field, &field.ty)?;
-        }
+        let eager = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            eager.contains("func init() {\n\tif uint64(C.witffi_abi_hash()) != abiHash"),
+            "eager bindings should check the ABI at package init"
+        );
+        assert!(
+            !eager.contains("ensureSetup"),
+            "eager bindings need no setup guard"
+        );
 
-        writeln!(out, "\treturn result")?;
-        writeln!(out, "}}")?;
+        let config = GoConfig {
+            init_mode: GoInitMode::Lazy,
+            dispatch_thread: true,
+            foreign_panics: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+        assert!(
+            !code.contains("func init()"),
+            "nothing should run at import time"
+        );
+        assert!(
+            code.contains("\tsetupOnce.Do(func() {\n\t\tcheckABI()\n\t\tstartDispatcher()\n\t\tcapturePanicBacktraces()\n\t})"),
+            "ensureSetup should run every setup function once"
+        );
+        assert!(
+            code.contains("func dispatch(call func()) {\n\tensureSetup()"),
+            "setup should happen before a call reaches the dispatcher"
+        );
+        assert!(code.contains("\t\"sync\""), "missing sync import");
+    }
 
-        Ok(())
+    #[test]
+    fn test_generate_go_log_bridge() {
+        let source = r#"
+            package test:logs;
+
+            interface parser {
+                parse: func(input: string) -> result<u32, string>;
+            }
+
+            world logs {
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("logs.wit", source)
+            .expect("failed to parse logs WIT");
+        let world_id = resolve.packages[pkg_id].worlds["logs"];
+        let config = GoConfig {
+            log_bridge: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func SetLogger(logger *slog.Logger) error {"),
+            "missing SetLogger"
+        );
+        assert!(
+            code.contains("\tif !C.witffi_set_log_sink(C.FfiLogSink(C.witffi_log_record), C.uint8_t(maxLevel)) {"),
+            "SetLogger should register the Go sink with Rust"
+        );
+        assert!(
+            code.contains("//export witffi_log_record\nfunc witffi_log_record(level C.uint8_t, target, message, fields C.FfiByteSlice) {")
+                && code.contains("extern void witffi_log_record(uint8_t level, FfiByteSlice target, FfiByteSlice message, FfiByteSlice fields);"),
+            "missing the exported sink"
+        );
+        assert!(
+            code.contains("\tlogger.LogAttrs(context.Background(), rustLevels[level-1], "),
+            "records should be logged at the mapped level"
+        );
+        assert!(
+            code.contains("\t\"log/slog\"") && code.contains("\t\"sync/atomic\""),
+            "missing imports"
+        );
+
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(
+            !plain.contains("SetLogger"),
+            "the log bridge should be opt-in"
+        );
     }
 
-    fn generate_variant_conversion(
-        &self,
-        out: &mut String,
-        wit_name: &str,
-        variant: &wit_parser::Variant,
-    ) -> std::fmt::Result {
-        let go_name = names::to_go_type(wit_name);
-        let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+    #[test]
+    fn test_generate_go_output_redirects() {
+        let source = r#"
+            package test:output;
 
-        writeln!(out)?;
-        writeln!(out, "func convert{go_name}(ffi C.{c_name}) {go_name} {{")?;
-        writeln!(out, "\tswitch ffi.tag {{")?;
+            interface clock {
+                tick: func() -> u64;
+            }
 
-        for case in &variant.cases {
-            let c_tag = names::to_c_enum_variant(
-                &names::to_c_type(&self.config.c_type_prefix, wit_name),
-                &case.name,
-            );
-            let case_type_name = format!("{}{}", go_name, names::to_go_type(&case.name));
-            let c_field = names::to_rust_ident(&case.name);
+            world output {
+                export clock;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("output.wit", source)
+            .expect("failed to parse output WIT");
+        let world_id = resolve.packages[pkg_id].worlds["output"];
+        let config = GoConfig {
+            lifecycle: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func WithStdout(w io.Writer) InitOption {")
+                && code.contains("func WithStderr(w io.Writer) InitOption {"),
+            "missing the output options"
+        );
+        assert!(
+            code.contains("\tif err := redirectOutputs(&config); err != nil {\n\t\treturn err\n\t}\n\tif !C.witffi_init(options) {"),
+            "output should be redirected before the library initializes"
+        );
+        assert!(
+            code.contains("\tC.witffi_shutdown()\n\trestoreOutputs()\n"),
+            "Shutdown should restore the streams once the library is shut down"
+        );
+        assert!(
+            code.contains("\tif ret, err := C.dup2(C.int(writer.Fd()), fd); ret < 0 {"),
+            "the stream should be pointed at the pipe"
+        );
+        assert!(
+            code.contains("#include <unistd.h>") && code.contains("\t\"os\""),
+            "missing includes"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_call_hooks() {
+        let source = r#"
+            package test:hooks;
+
+            interface parser {
+                parse: func(input: string, strict: bool) -> result<u32, string>;
+                reset: func();
+            }
 
-            writeln!(out, "\tcase C.{c_tag}:")?;
-            if let Some(ty) = &case.ty {
-                let payload = self.convert_variant_payload(ty, &c_field);
-                writeln!(out, "\t\treturn {case_type_name}{{Value: {payload}}}")?;
-            } else {
-                writeln!(out, "\t\treturn {case_type_name}{{}}")?;
+            world hooks {
+                export parser;
             }
-        }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("hooks.wit", source)
+            .expect("failed to parse hooks WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hooks"];
+        let config = GoConfig {
+            call_hooks: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
 
-        writeln!(out, "\tdefault:")?;
-        writeln!(
-            out,
-            "\t\tpanic(fmt.Sprintf(\"unknown {go_name} tag: %d\", ffi.tag))"
-        )?;
-        writeln!(out, "\t}}")?;
-        writeln!(out, "}}")?;
+        assert!(
+            code.contains("type CallHooks interface {")
+                && code.contains("func SetCallHooks(hooks CallHooks) {"),
+            "missing the hooks API"
+        );
+        assert!(
+            code.contains("\t\t(*activeHooks).BeforeCall(\"parser.parse\", []any{input, strict})\n")
+                && code.contains("\t\tr0, r1 := hookedCall()\n\t\t(*activeHooks).AfterCall(\"parser.parse\", time.Since(hookStart), r1)\n\t\treturn r0, r1\n\t}\n\treturn hookedCall()\n"),
+            "parse should report its arguments and error to the hooks"
+        );
+        assert!(
+            code.contains("\t\t(*activeHooks).AfterCall(\"parser.reset\", time.Since(hookStart), nil)\n\t\treturn\n\t}\n\thookedCall()\n"),
+            "reset cannot fail"
+        );
 
-        Ok(())
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        assert!(!plain.contains("hookedCall"), "call hooks should be opt-in");
     }
 
-    /// Generate a Go expression to convert a variant case payload.
-    fn convert_variant_payload(&self, ty: &Type, c_field: &str) -> String {
-        match ty {
-            Type::String => {
-                format!("ffiByteBufferToString(ffi.{c_field}.value)")
-            }
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        format!("ffiByteBufferToBytes(ffi.{c_field}.value)")
-                    }
-                    TypeDefKind::Type(aliased) => self.convert_variant_payload(aliased, c_field),
-                    _ => {
-                        let name = typedef.name.as_deref().unwrap_or("anonymous");
-                        let go_name = names::to_go_type(name);
-                        format!("convert{go_name}(ffi.{c_field}.value)")
-                    }
-                }
+    #[test]
+    fn test_generate_go_otel_spans() {
+        let source = r#"
+            package test:spans;
+
+            interface parser {
+                parse: func(input: string) -> result<u32, string>;
+                reset: func();
             }
-            _ => {
-                // Primitive payload
-                let go_ty = self.type_to_go(ty);
-                format!("{go_ty}(ffi.{c_field}.value)")
+
+            world spans {
+                export parser;
             }
-        }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("spans.wit", source)
+            .expect("failed to parse spans WIT");
+        let world_id = resolve.packages[pkg_id].worlds["spans"];
+        let config = GoConfig {
+            otel_spans: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("var otelTracer = otel.Tracer(\"spans\")"),
+            "missing the tracer"
+        );
+        assert!(
+            code.contains("\t_, span := otelTracer.Start(context.Background(), \"parser.parse\", trace.WithAttributes(attribute.String(\"wit.interface\", \"parser\"), attribute.String(\"wit.function\", \"parse\")))\n\tdefer span.End()\n"),
+            "parse should run in a span"
+        );
+        assert!(
+            code.contains("\tif r1 != nil {\n\t\tspan.RecordError(r1)\n\t\tspan.SetStatus(codes.Error, r1.Error())\n\t}\n"),
+            "errors should be recorded on the span"
+        );
+        assert!(
+            code.contains("\tdefer span.End()\n\ttracedCall()\n"),
+            "reset should run in a span"
+        );
+        assert!(
+            code.contains(
+                "\t\"go.opentelemetry.io/otel\"\n\t\"go.opentelemetry.io/otel/attribute\"\n"
+            ),
+            "missing the OpenTelemetry imports"
+        );
     }
 
-    /// Generate a Go expression to convert an FFI value to a Go value.
-    fn convert_ffi_to_go(&self, ty: &Type, access: &str) -> String {
-        match ty {
-            Type::Bool => format!("bool({access})"),
-            Type::U8
-            | Type::U16
-            | Type::U32
-            | Type::U64
-            | Type::S8
-            | Type::S16
-            | Type::S32
-            | Type::S64
-            | Type::F32
-            | Type::F64 => {
-                let go_ty = self.type_to_go(ty);
-                format!("{go_ty}({access})")
+    #[test]
+    fn test_generate_go_metrics() {
+        let source = r#"
+            package test:metrics;
+
+            interface parser {
+                parse: func(input: string) -> result<u32, string>;
+                reset: func();
             }
-            Type::Char => format!("rune({access})"),
-            Type::String => format!("ffiByteBufferToString({access})"),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        format!("ffiByteBufferToBytes({access})")
-                    }
-                    TypeDefKind::List(_) => {
-                        format!("ffiByteBufferToBytes({access}) /* TODO: decode list elements */")
-                    }
-                    TypeDefKind::Type(aliased) => self.convert_ffi_to_go(aliased, access),
-                    _ => {
-                        let name = typedef.name.as_deref().unwrap_or("anonymous");
-                        let go_name = names::to_go_type(name);
-                        format!("convert{go_name}({access})")
-                    }
-                }
+
+            world metrics {
+                export parser;
             }
-            Type::ErrorContext => format!("string({access})"),
-        }
-    }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("metrics.wit", source)
+            .expect("failed to parse metrics WIT");
+        let world_id = resolve.packages[pkg_id].worlds["metrics"];
+        let config = GoConfig {
+            metrics: true,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        let (file_name, metrics) = generator
+            .generate_metrics_file()
+            .expect("failed to generate metrics")
+            .expect("metrics.go should be generated");
+        eprintln!("--- Generated metrics ---\n{metrics}\n--- End ---");
 
-    /// Generate code for converting an optional (nullable pointer) FFI field.
-    fn generate_optional_field_conversion(
-        &self,
-        out: &mut String,
-        go_field: &str,
-        c_field: &str,
-        ty: &Type,
-    ) -> std::fmt::Result {
-        // Unwrap the Option layer to get the inner type
-        let inner_ty = self.unwrap_option(ty);
+        assert_eq!(file_name, "metrics.go");
+        assert!(
+            code.contains("func SetCallHooks(hooks CallHooks) {")
+                && code.contains("hookedCall := "),
+            "metrics should be recorded through the call hooks"
+        );
+        assert!(
+            metrics.contains(
+                "var metricsFunctions = []string{\n\t\"parser.parse\",\n\t\"parser.reset\",\n}"
+            ),
+            "every function's series should be created up front"
+        );
+        assert!(
+            metrics.contains("\t\tNamespace: \"metrics\",\n\t\tSubsystem: \"ffi\",\n\t\tName:      \"calls_total\",\n"),
+            "metric names should derive from the package"
+        );
+        assert!(
+            metrics.contains(
+                "func (m *Metrics) AfterCall(name string, dur time.Duration, err error) {"
+            ),
+            "Metrics should implement CallHooks"
+        );
 
-        writeln!(out, "\tif ffi.{c_field} != nil {{")?;
+        let plain = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        assert!(
+            plain
+                .generate_metrics_file()
+                .expect("failed to generate metrics")
+                .is_none(),
+            "metrics should be opt-in"
+        );
+    }
 
-        match inner_ty {
-            Type::Bool
-            | Type::U8
-            | Type::U16
-            | Type::U32
-            | Type::U64
-            | Type::S8
-            | Type::S16
-            | Type::S32
-            | Type::S64
-            | Type::F32
-            | Type::F64 => {
-                let go_ty = self.type_to_go(inner_ty);
-                writeln!(out, "\t\tv := {go_ty}(*ffi.{c_field})")?;
-                writeln!(out, "\t\tresult.{go_field} = &v")?;
-                writeln!(out, "\t\tC.free(unsafe.Pointer(ffi.{c_field}))")?;
-            }
-            Type::String => {
-                writeln!(out, "\t\tv := ffiByteBufferToString(*ffi.{c_field})")?;
-                writeln!(out, "\t\tresult.{go_field} = &v")?;
-                writeln!(out, "\t\tC.free(unsafe.Pointer(ffi.{c_field}))")?;
-            }
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        writeln!(out, "\t\tv := ffiByteBufferToBytes(*ffi.{c_field})")?;
-                        writeln!(out, "\t\tresult.{go_field} = v")?;
-                        writeln!(out, "\t\tC.free(unsafe.Pointer(ffi.{c_field}))")?;
-                    }
-                    TypeDefKind::Type(aliased) => {
-                        // Follow the alias and recurse
-                        return self
-                            .generate_optional_field_conversion(out, go_field, c_field, aliased);
-                    }
-                    _ => {
-                        let name = typedef.name.as_deref().unwrap_or("anonymous");
-                        let go_name = names::to_go_type(name);
-                        writeln!(out, "\t\tv := convert{go_name}(*ffi.{c_field})")?;
-                        writeln!(out, "\t\tresult.{go_field} = &v")?;
-                        writeln!(out, "\t\tC.free(unsafe.Pointer(ffi.{c_field}))")?;
-                    }
-                }
+    #[test]
+    fn test_generate_go_call_dump() {
+        let source = r#"
+            package test:dump;
+
+            interface wallet {
+                sign: func(key: list<u8>, message: string) -> result<list<u8>, string>;
             }
-            _ => {
-                writeln!(out, "\t\tv := *ffi.{c_field}")?;
-                writeln!(out, "\t\tresult.{go_field} = &v")?;
-                writeln!(out, "\t\tC.free(unsafe.Pointer(ffi.{c_field}))")?;
+
+            world dump {
+                export wallet;
             }
-        }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("dump.wit", source)
+            .expect("failed to parse dump WIT");
+        let world_id = resolve.packages[pkg_id].worlds["dump"];
+        let config = GoConfig {
+            call_dump: true,
+            call_dump_max_len: 64,
+            call_dump_redact: ["wallet.sign.key".to_string()].into(),
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        let files = generator
+            .generate_call_dump_files()
+            .expect("failed to generate call dump files");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
 
-        writeln!(out, "\t}}")?;
+        assert!(
+            code.contains(
+                "\tif !callDumpEnabled {\n\t\treturn dumpedCall()\n\t}\n\tr0, r1 := dumpedCall()\n"
+            ),
+            "the dump should compile away without the build tag"
+        );
+        assert!(
+            code.contains("\tdumpCall(\"wallet.sign\", []string{\"key\", \"message\"}, []any{\"<redacted>\", message}, []string{\"result\", \"err\"}, []any{r0, r1})\n"),
+            "the key should be redacted"
+        );
 
-        Ok(())
+        let names: Vec<&str> = files.iter().map(|(name, _)| name.as_str()).collect();
+        assert_eq!(names, ["call_dump_debug.go", "call_dump.go"]);
+        let (debug, release) = (&files[0].1, &files[1].1);
+        assert!(
+            debug.contains("//go:build witffidump\n")
+                && debug.contains("const callDumpEnabled = true")
+                && debug.contains("const callDumpMaxLen = 64"),
+            "the tagged file should dump calls"
+        );
+        assert!(
+            release.contains("//go:build !witffidump\n")
+                && release.contains("const callDumpEnabled = false"),
+            "the untagged file should switch dumps off"
+        );
     }
 
-    /// Check if a type is `option<T>`.
-    fn is_option_type(&self, ty: &Type) -> bool {
-        if let Type::Id(id) = ty {
-            let typedef = &self.resolve.types[*id];
-            match &typedef.kind {
-                TypeDefKind::Option(_) => true,
-                TypeDefKind::Type(aliased) => self.is_option_type(aliased),
-                _ => false,
-            }
-        } else {
-            false
-        }
+    #[test]
+    fn test_generate_go_handle_table() {
+        let (resolve, world_id) = load_counter_wit();
+        let config = GoConfig {
+            handle_table: true,
+            resource_cleanup: ResourceCleanup::AddCleanup,
+            ..GoConfig::default()
+        };
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        let files = generator
+            .generate_handle_table_files()
+            .expect("failed to generate handle table files");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("func LiveHandles() []HandleInfo {")
+                && code.contains("func CheckNoLiveHandles(t interface {"),
+            "missing the handle table API"
+        );
+        assert!(
+            code.contains("\ttrackHandle(\"Counter\", unsafe.Pointer(handle))\n"),
+            "wrapped handles should be tracked"
+        );
+        assert!(
+            code.contains("\tuntrackHandle(unsafe.Pointer(c.handle))\n"),
+            "Close should untrack the handle"
+        );
+        assert!(
+            code.contains(
+                "func(h *C.FfiCounter) {\n\t\tuntrackHandle(unsafe.Pointer(h))\n\t\tC.witffi_types_counter_drop(h)\n"
+            ),
+            "GC cleanup should untrack the handle"
+        );
+
+        let names: Vec<&str> = files.iter().map(|(name, _)| name.as_str()).collect();
+        assert_eq!(names, ["handles_debug.go", "handles.go"]);
+        assert!(
+            files[0].1.contains("//go:build witffidebug\n")
+                && files[0].1.contains("const handleStacks = true"),
+            "debug builds should record creation stacks"
+        );
+        assert!(
+            files[1].1.contains("//go:build !witffidebug\n")
+                && files[1].1.contains("const handleStacks = false"),
+            "other builds should not"
+        );
     }
 
-    /// Unwrap an `option<T>` to get the inner type `T`.
-    /// If the type is not an option, returns the type itself.
-    fn unwrap_option<'b>(&'b self, ty: &'b Type) -> &'b Type {
-        if let Type::Id(id) = ty {
-            let typedef = &self.resolve.types[*id];
-            match &typedef.kind {
-                TypeDefKind::Option(inner) => inner,
-                TypeDefKind::Type(aliased) => self.unwrap_option(aliased),
-                _ => ty,
-            }
-        } else {
-            ty
-        }
+    #[test]
+    fn test_generate_go_use_after_close() {
+        let (resolve, world_id) = load_counter_wit();
+        let generator = GoGenerator::new(&resolve, world_id, GoConfig::default());
+        let code = generator.generate().expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("var ErrClosed = errors.New(\"resource used after close\")")
+                && code.contains("\t\"errors\"\n")
+                && code.contains("\t\"sync/atomic\"\n"),
+            "missing ErrClosed"
+        );
+        assert!(
+            code.contains("{handle: handle, closed: new(atomic.Bool)}"),
+            "wrapped handles should share a closed flag with their views"
+        );
+        assert!(
+            code.contains("func (c *Counter) Close() {\n\tif !c.claim() {\n\t\treturn\n\t}"),
+            "Close should claim the handle once"
+        );
+        assert!(
+            code.contains(
+                "\treturn c != nil && c.handle != nil && c.closed.CompareAndSwap(false, true)"
+            ),
+            "claiming should be atomic"
+        );
+        assert!(
+            code.contains(
+                "func (c CounterRef) Get() uint64 {\n\tif !c.open() {\n\t\tpanic(ErrClosed)\n\t}"
+            ),
+            "infallible methods should panic with ErrClosed"
+        );
+        assert!(
+            code.contains("\tif !b.open() || !a.claim() {"),
+            "borrowed handles should be checked before owned ones are claimed"
+        );
     }
 
-    // ---- Public API generation ----
+    #[test]
+    fn test_generate_go_pprof_labels() {
+        let source = r#"
+            package test:profiled;
 
-    fn generate_api(&self, out: &mut String) -> std::fmt::Result {
-        writeln!(out, "// ---- Public API ----")?;
+            interface parser {
+                parse: func(input: string) -> result<u32, string>;
+                reset: func();
+            }
 
-        let funcs = exported_functions(self.resolve, self.world_id);
-        for ef in &funcs {
-            self.generate_api_function(out, ef)?;
-        }
+            world profiled {
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("profiled.wit", source)
+            .expect("failed to parse profiled WIT");
+        let world_id = resolve.packages[pkg_id].worlds["profiled"];
+        let config = GoConfig {
+            pprof_labels: true,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
 
-        Ok(())
+        assert!(
+            code.contains("\tpprof.Do(context.Background(), pprof.Labels(\"wit.function\", \"parser.parse\"), func(context.Context) {\n\t\tr0, r1 := labeledCall()\n\t\tlabeledResult = func() (uint32, error) { return r0, r1 }\n\t})\n\treturn labeledResult()\n"),
+            "parse should run under its label"
+        );
+        assert!(
+            code.contains("pprof.Labels(\"wit.function\", \"parser.reset\"), func(context.Context) { labeledCall() })\n"),
+            "reset should run under its label"
+        );
+        assert!(
+            code.contains("\t\"runtime/pprof\"\n") && code.contains("\t\"context\"\n"),
+            "missing the pprof imports"
+        );
     }
 
-    fn generate_api_function(&self, out: &mut String, ef: &ExportedFunction) -> std::fmt::Result {
-        let c_func_name = if ef.interface_name.is_empty() {
-            names::to_c_func(&self.config.c_prefix, &ef.function_name)
-        } else {
-            names::to_c_func(
-                &self.config.c_prefix,
-                &format!("{}_{}", ef.interface_name, ef.function_name),
-            )
-        };
+    #[test]
+    fn test_generate_go_declaration_order() {
+        let source = r#"
+            package test:ordered;
 
-        // Build the Go function name from interface + function
-        let go_func_name = if ef.interface_name.is_empty() {
-            names::to_go_func(&ef.function_name)
-        } else {
-            // For multi-interface worlds, combine interface + function name
-            // e.g., "functions" + "u256-to-string" -> "FunctionsU256ToString"
-            // But for single-function interfaces, just use the function name
-            // for brevity (e.g., "parser" + "parse" -> "Parse")
-            names::to_go_func(&format!("{}_{}", ef.interface_name, ef.function_name))
-        };
+            interface shapes {
+                record zulu {
+                    value: u32,
+                }
 
-        let result_decomposed = self.decompose_result(&ef.function.result);
+                record alpha {
+                    inner: zulu,
+                }
 
-        // Build Go parameters
-        let go_params: Vec<String> = ef
-            .function
-            .params
-            .iter()
-            .map(|p| {
-                let name = names::to_go_ident(&p.name);
-                let ty = self.type_to_go(&p.ty);
-                format!("{name} {ty}")
-            })
-            .collect();
+                zeta: func(a: alpha) -> u32;
+                beta: func() -> zulu;
+            }
 
-        // Build return type
-        let go_return = if let Some((ok_ty, _)) = &result_decomposed {
-            let ret = ok_ty
-                .as_ref()
-                .map(|t| self.type_to_go(t))
-                .unwrap_or_default();
-            if ret.is_empty() {
-                "error".to_string()
-            } else {
-                format!("({ret}, error)")
+            world ordered {
+                export shapes;
             }
-        } else {
-            ef.function
-                .result
-                .as_ref()
-                .map(|t| self.type_to_go(t))
-                .unwrap_or_default()
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("ordered.wit", source)
+            .expect("failed to parse ordered WIT");
+        let world_id = resolve.packages[pkg_id].worlds["ordered"];
+        let generate = || {
+            GoGenerator::new(&resolve, world_id, GoConfig::default())
+                .generate()
+                .expect("failed to generate Go code")
         };
+        let code = generate();
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
 
-        // Emit function signature
-        writeln!(out)?;
-        if let Some(docs) = &ef.function.docs.contents {
-            Self::write_doc_comment(out, docs, "")?;
-        }
-        let return_clause = if go_return.is_empty() {
-            String::new()
-        } else {
-            format!(" {go_return}")
+        let position = |needle: &str| {
+            code.find(needle)
+                .unwrap_or_else(|| panic!("missing `{needle}`"))
         };
-        writeln!(
-            out,
-            "func {go_func_name}({params}){return_clause} {{",
-            params = go_params.join(", ")
-        )?;
+        assert!(
+            position("type Alpha struct") < position("type Zulu struct"),
+            "types should be sorted by name, not dependency order"
+        );
+        assert!(
+            position("func convertAlpha(") < position("func convertZulu("),
+            "conversions should follow the type order"
+        );
+        assert!(
+            position("func ShapesBeta(") < position("func ShapesZeta("),
+            "functions should be sorted by name, not WIT order"
+        );
+        assert_eq!(code, generate(), "generation should be reproducible");
+    }
 
-        // Generate the function body
-        self.generate_api_function_body(out, ef, &c_func_name, &result_decomposed)?;
+    #[test]
+    fn test_generate_go_doc_comments() {
+        let source = r#"
+            /// Geometry helpers.
+            package test:geometry;
 
-        writeln!(out, "}}")?;
+            /// Shapes and the maths on them.
+            interface shapes {
+                /// A point on the plane.
+                record point {
+                    /// The horizontal coordinate.
+                    x: f64,
+                    y: f64,
+                }
 
-        Ok(())
+                /// Measure the distance between two points.
+                ///
+                /// Both points must be finite:
+                /// - `a` is the start
+                /// - `b` is the end
+                /// ```
+                /// d := ShapesDistance(a, b)
+                /// ```
+                distance: func(a: point, b: point) -> f64;
+
+                /// Whether a point lies on the unit circle.
+                on-circle: func(p: point) -> bool;
+            }
+
+            /// The geometry world.
+            world geometry {
+                export shapes;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("geometry.wit", source)
+            .expect("failed to parse geometry WIT");
+        let world_id = resolve.packages[pkg_id].worlds["geometry"];
+        let code = GoGenerator::new(&resolve, world_id, GoConfig::default())
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "// Package geometry holds the Go bindings of the geometry WIT world.\n//\n// Geometry helpers.\n//\n// The geometry world.\n//\n// # Shapes\n//\n// Shapes and the maths on them.\npackage geometry\n"
+            ),
+            "package, world and interface docs should make up the package doc"
+        );
+        assert!(
+            code.contains("// Point is a point on the plane.\ntype Point struct {\n\t// The horizontal coordinate.\n\tX float64\n"),
+            "type docs should open with the type name"
+        );
+        assert!(
+            code.contains(
+                "// ShapesDistance measures the distance between two points.\n//\n// Both points must be finite:\n//   - `a` is the start\n//   - `b` is the end\n//\n//\td := ShapesDistance(a, b)\nfunc ShapesDistance("
+            ),
+            "function docs should open with the function name, with lists and code in Go syntax"
+        );
+        assert!(
+            code.contains("// ShapesOnCircle reports whether a point lies on the unit circle.\n"),
+            "`Whether` docs should read as Go's `reports whether`"
+        );
     }
 
-    fn generate_api_function_body(
-        &self,
-        out: &mut String,
-        ef: &ExportedFunction,
-        c_func_name: &str,
-        result_decomposed: &Option<(Option<Type>, Option<Type>)>,
-    ) -> std::fmt::Result {
-        // Marshal input parameters
-        for p in &ef.function.params {
-            self.generate_param_marshaling(out, &p.name, &p.ty)?;
-        }
+    #[test]
+    fn test_generate_go_identifier_mapping() {
+        let source = r#"
+            package test:fetch;
 
-        // Build C function call arguments
-        let c_args: Vec<String> = ef
-            .function
-            .params
-            .iter()
-            .map(|p| {
-                let name = names::to_go_ident(&p.name);
-                if self.param_needs_marshaling(&p.ty) {
-                    format!("{name}Slice")
-                } else {
-                    let cgo_ty = self.type_to_cgo(&p.ty);
-                    format!("{cgo_ty}({name})")
+            interface http-client {
+                record request {
+                    chain-id: u64,
+                    request-uri: string,
                 }
-            })
-            .collect();
-        let c_args_str = c_args.join(", ");
 
-        // Call C function and handle result
-        if let Some((ok_ty, _)) = result_decomposed {
-            if let Some(ok_type) = ok_ty {
-                // result<T, E> with a value — returns pointer (null = error)
-                writeln!(out, "\tresultPtr := C.{c_func_name}({c_args_str})")?;
-                writeln!(out, "\tif resultPtr == nil {{")?;
-                let zero_val = self.go_zero_value(ok_type);
-                writeln!(
-                    out,
-                    "\t\treturn {zero_val}, fmt.Errorf(\"{c_func_name} failed: %s\", readLastError())"
-                )?;
-                writeln!(out, "\t}}")?;
-                let conversion = self.convert_ffi_to_go(ok_type, "*resultPtr");
-                writeln!(out, "\tresult := {conversion}")?;
-                // Free with type-specific free function
-                let free_func = self.result_free_func(ok_type);
-                writeln!(out, "\tC.{free_func}(resultPtr)")?;
-                writeln!(out, "\treturn result, nil")?;
-            } else {
-                // result<_, E> with no ok value — returns bool
-                writeln!(out, "\tsuccess := C.{c_func_name}({c_args_str})")?;
-                writeln!(out, "\tif !success {{")?;
-                writeln!(
-                    out,
-                    "\t\treturn fmt.Errorf(\"{c_func_name} failed: %s\", readLastError())"
-                )?;
-                writeln!(out, "\t}}")?;
-                writeln!(out, "\treturn nil")?;
+                fetch-url: func(request-id: u64, %type: string) -> request;
             }
-        } else if let Some(ret_ty) = &ef.function.result {
-            // Non-result return type — direct conversion
-            writeln!(out, "\tresult := C.{c_func_name}({c_args_str})")?;
-            let conversion = self.convert_ffi_to_go(ret_ty, "result");
-            writeln!(out, "\treturn {conversion}")?;
-        } else {
-            // Void return
-            writeln!(out, "\tC.{c_func_name}({c_args_str})")?;
-        }
 
-        Ok(())
+            world fetcher {
+                export http-client;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("fetch.wit", source)
+            .expect("failed to parse fetch WIT");
+        let world_id = resolve.packages[pkg_id].worlds["fetcher"];
+        let config = GoConfig {
+            naming: names::GoNaming {
+                initialisms: names::GO_INITIALISMS
+                    .iter()
+                    .map(|word| word.to_string())
+                    .collect(),
+                overrides: HashMap::from([("request".to_string(), "TxRequest".to_string())]),
+                keyword_suffix: "Value".to_string(),
+            },
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("type TxRequest struct {\n\tChainID uint64\n\tRequestURI string\n}"),
+            "initialisms should be in all caps, and overridden names used as given"
+        );
+        assert!(
+            code.contains("func HTTPClientFetchURL(requestID uint64, typeValue string)"),
+            "function and parameter names should follow the naming too"
+        );
+        assert!(
+            !code.contains("ChainId") && !code.contains("type Request struct"),
+            "no mechanical names should be left"
+        );
     }
 
-    /// Generate Go code to marshal a parameter into an FfiByteSlice.
-    fn generate_param_marshaling(
-        &self,
-        out: &mut String,
-        param_name: &str,
-        ty: &Type,
-    ) -> std::fmt::Result {
-        let go_name = names::to_go_ident(param_name);
+    #[test]
+    fn test_generate_go_subpackages() {
+        let source = r#"
+            package test:shop;
 
-        if !self.param_needs_marshaling(ty) {
-            return Ok(());
-        }
+            interface catalog {
+                /// A product for sale.
+                record item {
+                    name: string,
+                    price: u64,
+                }
 
-        match self.resolve_to_leaf(ty) {
-            Type::String => {
-                writeln!(out, "\t{go_name}Slice := C.FfiByteSlice{{")?;
-                writeln!(
-                    out,
-                    "\t\tptr: (*C.uint8_t)(unsafe.Pointer(unsafe.StringData({go_name}))),",
-                )?;
-                writeln!(out, "\t\tlen: C.uintptr_t(len({go_name})),")?;
-                writeln!(out, "\t}}")?;
+                enum color {
+                    red,
+                    green,
+                }
+
+                variant lookup {
+                    found(item),
+                    missing,
+                }
+
+                resource cart {
+                    constructor();
+                    add: func(i: item);
+                }
+
+                /// Find an item by name.
+                find: func(name: string) -> option<item>;
             }
-            _ => {
-                // list<u8> or other byte-slice types
-                writeln!(out, "\t{go_name}Slice := C.FfiByteSlice{{")?;
-                writeln!(
-                    out,
-                    "\t\tptr: (*C.uint8_t)(unsafe.Pointer(unsafe.SliceData({go_name}))),",
-                )?;
-                writeln!(out, "\t\tlen: C.uintptr_t(len({go_name})),")?;
-                writeln!(out, "\t}}")?;
+
+            world shop {
+                export catalog;
             }
-        }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("shop.wit", source)
+            .expect("failed to parse shop WIT");
+        let world_id = resolve.packages[pkg_id].worlds["shop"];
+        let config = GoConfig {
+            subpackages: true,
+            import_path: Some("example.com/shop".to_string()),
+            ..GoConfig::default()
+        };
+        let files = GoGenerator::new(&resolve, world_id, config)
+            .generate_subpackages()
+            .expect("failed to generate Go subpackages");
+        assert_eq!(files.len(), 1, "one subpackage per exported interface");
+        let (path, code) = &files[0];
+        eprintln!("--- Generated {path} ---\n{code}\n--- End ---");
 
-        Ok(())
+        assert_eq!(path, "catalog/bindings.go");
+        assert!(
+            code.contains("package catalog\n\nimport shop \"example.com/shop\"\n"),
+            "the subpackage should import the generated package"
+        );
+        assert!(
+            code.contains("// Item is a product for sale.\ntype Item = shop.Item\n"),
+            "types should be re-exported as aliases"
+        );
+        assert!(
+            code.contains("const (\n\tColorRed = shop.ColorRed\n\tColorGreen = shop.ColorGreen\n)"),
+            "enum cases should be re-exported"
+        );
+        assert!(
+            code.contains("type LookupFound = shop.LookupFound\n"),
+            "variant cases should be re-exported"
+        );
+        assert!(
+            code.contains("var NewCart = shop.NewCart\n"),
+            "resource functions should keep their names"
+        );
+        assert!(
+            code.contains("// Find finds an item by name.\nvar Find = shop.CatalogFind\n"),
+            "functions should drop the interface prefix"
+        );
+        assert!(!code.contains("Add"), "methods come with their resource");
+
+        let err = GoGenerator::new(
+            &resolve,
+            world_id,
+            GoConfig {
+                subpackages: true,
+                ..GoConfig::default()
+            },
+        )
+        .generate_subpackages()
+        .expect_err("subpackages need an import path");
+        assert!(matches!(err, Error::MissingImportPath));
     }
 
-    /// Check if a parameter type needs marshaling (String/[]byte → FfiByteSlice).
-    fn param_needs_marshaling(&self, ty: &Type) -> bool {
-        match ty {
-            Type::String => true,
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => true,
-                    TypeDefKind::Type(aliased) => self.param_needs_marshaling(aliased),
-                    _ => false,
-                }
+    #[test]
+    fn test_generate_go_plugin() {
+        /// Wraps errors in a company error type and marks each wrapper reviewed.
+        #[derive(Debug)]
+        struct CorpErrors;
+
+        impl GoPlugin for CorpErrors {
+            fn imports(&self) -> Vec<String> {
+                vec!["example.com/corp/corperr".to_string()]
             }
-            _ => false,
-        }
-    }
 
-    /// Follow type aliases to the leaf type.
-    fn resolve_to_leaf<'b>(&'b self, ty: &'b Type) -> &'b Type {
-        if let Type::Id(id) = ty {
-            let typedef = &self.resolve.types[*id];
-            if let TypeDefKind::Type(aliased) = &typedef.kind {
-                return self.resolve_to_leaf(aliased);
+            fn declarations(&self) -> String {
+                "// ErrCorp matches every error of the bindings.\nvar ErrCorp = corperr.Kind(\"lookup\")\n".to_string()
             }
-        }
-        ty
-    }
 
-    /// Get the Go zero value for a type (used in error returns).
-    fn go_zero_value(&self, ty: &Type) -> String {
-        match ty {
-            Type::Bool => "false".to_string(),
-            Type::U8
-            | Type::U16
-            | Type::U32
-            | Type::U64
-            | Type::S8
-            | Type::S16
-            | Type::S32
-            | Type::S64
-            | Type::F32
-            | Type::F64
-            | Type::Char => "0".to_string(),
-            Type::String => "\"\"".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(_) => "nil".to_string(),
-                    TypeDefKind::Option(_) => "nil".to_string(),
-                    TypeDefKind::Variant(_) => "nil".to_string(),
-                    TypeDefKind::Type(aliased) => self.go_zero_value(aliased),
-                    TypeDefKind::Record(_) => {
-                        let name = typedef.name.as_deref().unwrap_or("Anonymous");
-                        format!("{}{{}}", names::to_go_type(name))
-                    }
-                    TypeDefKind::Enum(_) | TypeDefKind::Flags(_) => "0".to_string(),
-                    _ => "nil".to_string(),
-                }
+            fn wrap_error(&self, function: &GoFunction, err: &str) -> Option<String> {
+                Some(format!("corperr.Wrap(\"{}\", {err})", function.wit_name))
+            }
+
+            fn rewrite_function(&self, function: &GoFunction, code: String) -> String {
+                code.replace(
+                    &format!("\nfunc {}(", function.go_name),
+                    &format!("\n// Reviewed by corp.\nfunc {}(", function.go_name),
+                )
             }
-            Type::ErrorContext => "\"\"".to_string(),
         }
-    }
 
-    /// Get the C free function name for a result ok type.
-    fn result_free_func(&self, ty: &Type) -> String {
-        match ty {
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
-                        let name = typedef.name.as_deref().unwrap_or("anonymous");
-                        names::to_c_func(&self.config.c_prefix, &format!("free-{name}"))
-                    }
-                    TypeDefKind::Type(aliased) => self.result_free_func(aliased),
-                    _ => format!("{}_free_byte_buffer", self.c_func_prefix()),
-                }
+        let source = r#"
+            package test:lookup;
+
+            interface lookup {
+                find: func(key: string) -> result<string, string>;
+                count: func() -> u32;
             }
-            Type::String => format!("{}_free_byte_buffer", self.c_func_prefix()),
-            _ => "free".to_string(),
+
+            world finder {
+                export lookup;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("lookup.wit", source)
+            .expect("failed to parse lookup WIT");
+        let world_id = resolve.packages[pkg_id].worlds["finder"];
+        let config = GoConfig {
+            plugins: vec![Arc::new(CorpErrors)],
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("\n\t\"example.com/corp/corperr\"\n"),
+            "the plugin's imports should be added"
+        );
+        assert!(
+            code.contains("var ErrCorp = corperr.Kind(\"lookup\")"),
+            "the plugin's declarations should be added"
+        );
+        assert!(
+            code.contains("\tpluginCall := func() (string, error) {")
+                && code.contains(
+                    "\tif r1 != nil {\n\t\tr1 = corperr.Wrap(\"lookup.find\", r1)\n\t}\n"
+                ),
+            "errors should be wrapped by the plugin"
+        );
+        assert_eq!(
+            code.matches("pluginCall := ").count(),
+            1,
+            "functions returning no error should be left alone"
+        );
+        assert!(
+            code.contains("// Reviewed by corp.\nfunc LookupFind(")
+                && code.contains("// Reviewed by corp.\nfunc LookupCount("),
+            "every wrapper should be rewritten by the plugin"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_parallel() {
+        // Enough functions and records to spread over several threads, named
+        // so that their sorted order is their WIT order
+        let mut source = String::from("package test:many;\n\ninterface many {\n");
+        for i in 0..40 {
+            source.push_str(&format!(
+                "    record item{i:02} {{ name: string, tags: list<string> }}\n    \
+                 get{i:02}: func(tags: list<string>) -> item{i:02};\n"
+            ));
         }
+        source.push_str("}\n\nworld lots {\n    export many;\n}\n");
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("many.wit", &source)
+            .expect("failed to parse many WIT");
+        let world_id = resolve.packages[pkg_id].worlds["lots"];
+        let generate = |threads| {
+            let config = GoConfig {
+                threads,
+                ..GoConfig::default()
+            };
+            GoGenerator::new(&resolve, world_id, config)
+                .generate()
+                .expect("failed to generate Go code")
+        };
+        let code = generate(1);
+
+        assert_eq!(
+            generate(4),
+            code,
+            "the output should not depend on the number of threads"
+        );
+        let position = |needle: &str| {
+            code.find(needle)
+                .unwrap_or_else(|| panic!("no {needle:?} in the bindings"))
+        };
+        assert!(
+            (0..39).all(|i| {
+                position(&format!("\nfunc ManyGet{i:02}("))
+                    < position(&format!("\nfunc ManyGet{:02}(", i + 1))
+                    && position(&format!("\nfunc convertItem{i:02}("))
+                        < position(&format!("\nfunc convertItem{:02}(", i + 1))
+            }),
+            "wrappers and conversions should keep the WIT order"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_deprecated() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "old.wit",
+                r#"
+package test:old@0.2.0;
+
+@since(version = 0.1.0)
+interface shop {
+    /// A priced item.
+    @since(version = 0.1.0)
+    @deprecated(version = 0.2.0)
+    record item {
+        price: u32,
     }
+
+    /// Look up an item.
+    @since(version = 0.1.0)
+    @deprecated(version = 0.2.0)
+    find: func(name: string) -> item;
+
+    @since(version = 0.1.0)
+    count: func() -> u32;
 }
 
-#[cfg(test)]
-mod tests {
-    use std::path::Path;
+world store {
+    @since(version = 0.1.0)
+    export shop;
+}
+"#,
+            )
+            .expect("failed to parse old WIT");
+        let world_id = resolve.packages[pkg_id].worlds["store"];
+        let generate = |deprecation_warnings| {
+            let config = GoConfig {
+                deprecation_warnings,
+                ..GoConfig::default()
+            };
+            GoGenerator::new(&resolve, world_id, config)
+                .generate()
+                .expect("failed to generate Go code")
+        };
 
-    use super::*;
+        let code = generate(false);
+        assert!(
+            code.contains(
+                "// Item is a priced item.\n//\n\
+                 // Deprecated: deprecated in version 0.2.0 of the WIT package.\ntype Item struct"
+            ),
+            "deprecated types should be marked in their doc comment"
+        );
+        assert!(
+            code.contains(
+                "//\n// Deprecated: deprecated in version 0.2.0 of the WIT package.\n\
+                 func ShopFind("
+            ),
+            "deprecated functions should be marked in their doc comment"
+        );
+        assert_eq!(
+            code.matches("// Deprecated:").count(),
+            2,
+            "functions that are not deprecated should not be marked"
+        );
+        assert!(
+            !code.contains("warnDeprecated"),
+            "deprecated calls should only warn when asked to"
+        );
+
+        let code = generate(true);
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+        assert!(
+            code.contains("func warnDeprecated(fn, version string) {")
+                && code.contains("\t\"log/slog\"\n"),
+            "the warning helper should be generated"
+        );
+        assert!(
+            code.contains(") (Item, error) {\n\twarnDeprecated(\"shop.find\", \"0.2.0\")\n")
+                || code.contains(") Item {\n\twarnDeprecated(\"shop.find\", \"0.2.0\")\n"),
+            "deprecated functions should warn when called"
+        );
+        assert_eq!(
+            code.matches("\twarnDeprecated(").count(),
+            1,
+            "functions that are not deprecated should not warn"
+        );
+    }
 
     #[test]
-    fn test_generate_go_from_eip681() {
-        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
-        let (resolve, world_id) =
-            witffi_core::load_wit(&wit_path).expect("failed to load eip681.wit");
+    fn test_generate_go_source_locations() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "shop.wit",
+                r#"
+package test:shop;
 
-        let config = GoConfig {
-            c_prefix: "zcash_eip681".to_string(),
-            c_type_prefix: "Ffi".to_string(),
-            go_package: None,
-            lib_name: "eip681_ffi".to_string(),
-        };
+interface shop {
+    /// A priced item.
+    record item {
+        price: u32,
+    }
 
-        let generator = GoGenerator::new(&resolve, world_id, config);
-        let code = generator.generate().expect("failed to generate Go code");
+    /// Look up an item.
+    find: func(name: string) -> item;
+
+    count: func() -> u32;
+}
 
+world store {
+    export shop;
+}
+"#,
+            )
+            .expect("failed to parse shop WIT");
+        let world_id = resolve.packages[pkg_id].worlds["store"];
+        let location = |line| SourceLocation {
+            file: "shop.wit".to_string(),
+            line,
+        };
+        let config = GoConfig {
+            source_locations: HashMap::from([
+                ("shop.item".to_string(), location(6)),
+                ("shop.find".to_string(), location(11)),
+            ]),
+            line_directives: Some("../wit".to_string()),
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
         eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
 
-        // Package declaration
         assert!(
-            code.contains("package eip681"),
-            "missing package declaration"
+            code.contains("// Item is a priced item.\n//\n// source: shop.wit:6\ntype Item struct"),
+            "types should note where they are defined"
         );
-
-        // CGo preamble
         assert!(
-            code.contains("#cgo LDFLAGS: -leip681_ffi"),
-            "missing cgo LDFLAGS"
+            code.contains("//\n// source: shop.wit:11\n//line ../wit/shop.wit:11\nfunc ShopFind("),
+            "wrappers should note where they are defined, and report positions there"
+        );
+        assert_eq!(
+            code.matches("// source: ").count(),
+            2,
+            "items without a location should not be noted"
         );
-        assert!(code.contains("import \"C\""), "missing import C");
 
-        // Helpers
+        let lines: Vec<&str> = code.lines().collect();
+        let restore = lines
+            .iter()
+            .position(|line| line.starts_with("//line bindings.go:"))
+            .expect("the position in the file should be restored after the wrapper");
+        assert_eq!(
+            lines[restore - 1],
+            "}",
+            "the position should be restored right after the wrapper"
+        );
+        // The directive gives the line number of the line after it
+        assert_eq!(
+            lines[restore],
+            format!("//line bindings.go:{}", restore + 2)
+        );
+    }
+
+    #[test]
+    fn test_generate_go_function_settings() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "pages.wit",
+                r#"
+package test:pages;
+
+interface render {
+    record entry {
+        id: u32,
+    }
+
+    page: func(id: u32) -> string;
+    entries: func() -> list<entry>;
+    fetch: func(url: string) -> result<string, string>;
+}
+
+world pages {
+    export render;
+}
+"#,
+            )
+            .expect("failed to parse pages WIT");
+        let world_id = resolve.packages[pkg_id].worlds["pages"];
+        let config = GoConfig {
+            zero_copy: HashSet::from(["render.page".to_string(), "render.entries".to_string()]),
+            context_functions: HashSet::from(["render.fetch".to_string()]),
+            function_names: HashMap::from([("render.page".to_string(), "Page".to_string())]),
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
         assert!(
-            code.contains("func ffiByteBufferToString"),
-            "missing ffiByteBufferToString"
+            code.contains("func Page(id uint32) BorrowedString {")
+                && code.contains("func RenderEntries() *ListView[Entry] {"),
+            "zero-copy functions should return the form fitting their result, under their \
+             own names if renamed"
         );
         assert!(
-            code.contains("func ffiByteBufferToBytes"),
-            "missing ffiByteBufferToBytes"
+            code.contains("func RenderFetch(ctx context.Context, url string) (string, error) {")
+                && code.contains("func RenderEntries() *ListView[Entry] {"),
+            "only the chosen functions should take a context"
         );
-        assert!(code.contains("func readLastError"), "missing readLastError");
+    }
+
+    #[test]
+    fn test_generate_go_purego() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "hashing.wit",
+                r#"
+package test:hashing;
+
+interface hasher {
+    type digest = list<u8>;
+
+    /// Hash some bytes.
+    hash: func(data: list<u8>, rounds: u32) -> digest;
+    describe: func(name: string) -> result<string, string>;
+    reset: func() -> result<_, string>;
+    version: func() -> u64;
+}
+
+world hashing {
+    export hasher;
+}
+"#,
+            )
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+        let config = GoConfig {
+            c_prefix: "hashing".to_string(),
+            lib_name: "hashing_ffi".to_string(),
+            backend: GoBackend::Purego,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config.clone())
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
 
-        // Types
         assert!(
-            code.contains("type NativeRequest struct"),
-            "missing NativeRequest"
+            code.starts_with(
+                "// Code generated by witffi. DO NOT EDIT.\n\n//go:build darwin && (amd64 || arm64)\n\n"
+            ),
+            "purego bindings should only build where purego passes structs by value"
         );
         assert!(
-            code.contains("type Erc20Request struct"),
-            "missing Erc20Request"
+            !code.contains("import \"C\"")
+                && code.contains("\"github.com/ebitengine/purego\"")
+                && code.contains("purego.RegisterLibFunc(f.fptr, lib, f.name)"),
+            "purego bindings should bind the library at run time without cgo"
         );
         assert!(
-            code.contains("type transactionRequestVariant interface"),
-            "missing variant interface"
+            code.contains("\thashing_hasher_hash func(ffiByteSlice, uint32) ffiByteBuffer\n")
+                && code.contains("\thashing_hasher_describe func(ffiByteSlice) unsafe.Pointer\n")
+                && code.contains("\thashing_hasher_reset func() bool\n")
+                && code.contains("\t{&hashing_hasher_version, \"hashing_hasher_version\"},"),
+            "each function should be bound with its C signature"
         );
         assert!(
-            code.contains("type TransactionRequestNative struct"),
-            "missing TransactionRequestNative"
+            code.contains("func HasherHash(data []byte, rounds uint32) Digest {")
+                && code.contains("func HasherDescribe(name string) (string, error) {")
+                && code.contains("func HasherReset() error {")
+                && code.contains("func HasherVersion() uint64 {"),
+            "the API should be that of the cgo bindings"
         );
         assert!(
-            code.contains("type TransactionRequest = transactionRequestVariant"),
-            "missing TransactionRequest alias"
+            code.contains(
+                "\tresultPtr := hashing_hasher_describe(goStringToFfiByteSlice(name))\n\
+                 \truntime.KeepAlive(name)\n"
+            ) && code.contains(
+                "\tresult := ffiByteBufferToString(*(*ffiByteBuffer)(resultPtr))\n\
+                 \tfreeRustBox(resultPtr)\n"
+            ),
+            "strings should be lent for the call, and boxed results freed once lifted"
+        );
+        assert!(
+            code.contains("const libraryEnv = \"HASHING_LIBRARY\"")
+                && code.contains("path = \"libhashing_ffi.dylib\""),
+            "the library should be found through the environment or by its name"
         );
 
-        // Conversion functions
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "points.wit",
+                r#"
+package test:points;
+
+interface geometry {
+    record point {
+        x: f64,
+        y: f64,
+    }
+
+    centroid: func(points: list<point>) -> point;
+}
+
+world points {
+    export geometry;
+}
+"#,
+            )
+            .expect("failed to parse points WIT");
+        let world_id = resolve.packages[pkg_id].worlds["points"];
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("records should need cgo");
+        assert_eq!(
+            err.to_string(),
+            "cannot generate purego bindings: `geometry.centroid` takes `points`, of a type only \
+             cgo can pass; use the cgo backend"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_wazero() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "hashing.wit",
+                r#"
+package test:hashing;
+
+interface hasher {
+    hash: func(data: list<u8>, rounds: u32) -> list<u8>;
+    describe: func(name: string) -> result<string, string>;
+    version: func() -> u64;
+}
+
+world hashing {
+    export hasher;
+}
+"#,
+            )
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+        let config = GoConfig {
+            c_prefix: "hashing".to_string(),
+            lib_name: "hashing_ffi".to_string(),
+            backend: GoBackend::Wazero,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config.clone())
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
         assert!(
-            code.contains("func convertNativeRequest"),
-            "missing convertNativeRequest"
+            !code.contains("import \"C\"")
+                && code.contains("\"github.com/tetratelabs/wazero\"")
+                && code.contains("//go:embed hashing_ffi.wasm\nvar wasmBinary []byte"),
+            "wazero bindings should embed the library as WebAssembly"
         );
         assert!(
-            code.contains("func convertTransactionRequest"),
-            "missing convertTransactionRequest"
+            code.contains("func HasherHash(data []byte, rounds uint32) []byte {")
+                && code.contains("func HasherDescribe(name string) (string, error) {")
+                && code.contains("func HasherVersion() uint64 {"),
+            "the API should be that of the cgo bindings"
         );
-
-        // Public API — parser interface
         assert!(
-            code.contains("func ParserParse("),
-            "missing ParserParse function"
+            code.contains(
+                "\tresultPtr := uint32(wasmCall(\"test:hashing/hasher#hash\", dataPtr, dataLen, \
+                 uint64(rounds)))\n\
+                 \tdefer wasmCall(\"cabi_post_test:hashing/hasher#hash\", uint64(resultPtr))\n"
+            ),
+            "functions should be called by their export name, and their results freed"
         );
         assert!(
-            code.contains("(TransactionRequest, error)"),
-            "missing result return type"
+            code.contains("\tif wasmLoad(resultPtr, 1) != 0 {\n")
+                && code.contains("wasmReadString(resultPtr+4))"),
+            "errors should be read from behind the discriminant"
         );
 
-        // Public API — functions interface
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "checks.wit",
+                r#"
+package test:checks;
+
+interface checker {
+    check: func() -> result<u32>;
+}
+
+world checks {
+    export checker;
+}
+"#,
+            )
+            .expect("failed to parse checks WIT");
+        let world_id = resolve.packages[pkg_id].worlds["checks"];
+        let err = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect_err("errors without a message should need cgo");
+        assert_eq!(
+            err.to_string(),
+            "cannot generate wazero bindings: `checker.check` returns an error other than a \
+             string; use the cgo backend"
+        );
+    }
+
+    #[test]
+    fn test_generate_go_wasmtime() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "hashing.wit",
+                r#"
+package test:hashing;
+
+interface hasher {
+    hash: func(data: list<u8>, rounds: u32) -> list<u8>;
+    scale: func(x: f32) -> f64;
+}
+
+world hashing {
+    export hasher;
+}
+"#,
+            )
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+        let generate = |backend| {
+            let config = GoConfig {
+                c_prefix: "hashing".to_string(),
+                lib_name: "hashing_ffi".to_string(),
+                backend,
+                ..GoConfig::default()
+            };
+            GoGenerator::new(&resolve, world_id, config)
+                .generate()
+                .expect("failed to generate Go code")
+        };
+        let code = generate(GoBackend::Wasmtime);
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
         assert!(
-            code.contains("func FunctionsU256ToString("),
-            "missing FunctionsU256ToString function"
+            code.contains("\t\"github.com/bytecodealliance/wasmtime-go/v25\"\n")
+                && !code.contains("tetratelabs")
+                && code.contains("func wasmInstance() *wasmtime.Instance {")
+                && code.contains("\t\tcase wasmtime.KindF32:\n"),
+            "wasmtime bindings should instantiate the module with wasmtime-go"
         );
         assert!(
-            code.contains("C.zcash_eip681_functions_u256_to_string"),
-            "missing C call for u256_to_string"
+            code.contains(
+                "\treturn math.Float64frombits(wasmCall(\"test:hashing/hasher#scale\", \
+                 uint64(math.Float32bits(x))))\n"
+            ),
+            "floats should cross as their bits"
+        );
+
+        // Only the runtime differs from wazero
+        let api = |code: &str| {
+            let types = code
+                .find("// ---- Types ----")
+                .expect("the bindings have types");
+            code[types..].to_string()
+        };
+        assert_eq!(
+            api(&code),
+            api(&generate(GoBackend::Wazero)),
+            "the API should be that of the wazero bindings"
         );
     }
 
     #[test]
-    fn test_go_type_mapping() {
-        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
-        let (resolve, world_id) =
-            witffi_core::load_wit(&wit_path).expect("failed to load eip681.wit");
+    fn test_generate_go_sidecar() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "hashing.wit",
+                r#"
+package test:hashing;
 
-        let config = GoConfig::default();
-        let generator = GoGenerator::new(&resolve, world_id, config);
+interface hasher {
+    hash: func(data: list<u8>, rounds: u32) -> list<u8>;
+    describe: func(name: string) -> result<string, string>;
+    version: func() -> u64;
+}
 
-        assert_eq!(generator.type_to_go(&Type::String), "string");
-        assert_eq!(generator.type_to_go(&Type::Bool), "bool");
-        assert_eq!(generator.type_to_go(&Type::U64), "uint64");
-        assert_eq!(generator.type_to_go(&Type::S32), "int32");
-        assert_eq!(generator.type_to_go(&Type::F64), "float64");
+world hashing {
+    export hasher;
+}
+"#,
+            )
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+        let config = GoConfig {
+            c_prefix: "hashing".to_string(),
+            lib_name: "hashing_ffi".to_string(),
+            backend: GoBackend::Sidecar,
+            ..GoConfig::default()
+        };
+        let code = GoGenerator::new(&resolve, world_id, config)
+            .generate()
+            .expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
+
+        assert!(
+            !code.contains("import \"C\"")
+                && code.contains("\t\"os/exec\"\n")
+                && code.contains("const sidecarEnv = \"HASHING_SIDECAR\"")
+                && code.contains("\t\tpath = \"hashing_ffi-sidecar\"\n")
+                && code.contains("func Start(path string, args ...string) error {"),
+            "sidecar bindings should start the library as a process"
+        );
+        assert!(
+            code.contains(&format!(
+                "const abiHash = {:#018x}",
+                witffi_core::abi_hash(&resolve, world_id)
+            )) && code.contains("binary.LittleEndian.Uint64(hash) != abiHash"),
+            "the sidecar should be checked against the world"
+        );
+        assert!(
+            code.contains(
+                "func HasherHash(data []byte, rounds uint32) []byte {\n\
+                 \targs := sidecarAppendBytes(nil, data)\n\
+                 \targs = binary.LittleEndian.AppendUint32(args, rounds)\n\
+                 \tpayload, err := sidecarCall(0, \"hashing_hasher_hash\", args)\n\
+                 \tif err != nil {\n\t\tpanic(err)\n\t}\n\
+                 \treturn sidecarBytes(payload)\n"
+            ),
+            "functions should be called by id, panicking if the sidecar fails them"
+        );
+        assert!(
+            code.contains(
+                "\tpayload, err := sidecarCall(1, \"hashing_hasher_describe\", args)\n\
+                 \tif err != nil {\n\t\treturn \"\", err\n\t}\n"
+            ) && code.contains("\treturn binary.LittleEndian.Uint64(payload)\n"),
+            "fallible functions should return the sidecar's errors"
+        );
     }
 
     #[test]
-    fn test_go_package_name_derivation() {
-        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
-        let (resolve, world_id) =
-            witffi_core::load_wit(&wit_path).expect("failed to load eip681.wit");
+    fn test_generate_go_grpc() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str(
+                "hashing.wit",
+                r#"
+package test:hashing;
 
-        // Default: derived from world name
+interface hasher {
+    hash: func(data: list<u8>, rounds: u32) -> list<u8>;
+    describe: func(name: string, loud: bool) -> result<string, string>;
+}
+
+world hashing {
+    export hasher;
+}
+"#,
+            )
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
         let config = GoConfig {
-            go_package: None,
+            c_prefix: "hashing".to_string(),
+            lib_name: "hashing_ffi".to_string(),
+            backend: GoBackend::Grpc,
             ..GoConfig::default()
         };
         let generator = GoGenerator::new(&resolve, world_id, config);
-        assert_eq!(generator.package_name(), "eip681");
+        let code = generator.generate().expect("failed to generate Go code");
+        eprintln!("--- Generated Go code ---\n{code}\n--- End ---");
 
-        // Override
-        let config2 = GoConfig {
-            go_package: Some("mypkg".to_string()),
+        assert!(
+            !code.contains("import \"C\"")
+                && code.contains("\t\"google.golang.org/protobuf/encoding/protowire\"\n")
+                && code.contains("func Dial(target string, opts ...grpc.DialOption) error {")
+                && code.contains("func UseConn(conn grpc.ClientConnInterface) {"),
+            "gRPC bindings should connect to the service"
+        );
+        assert!(
+            code.contains(
+                "func HasherHash(data []byte, rounds uint32) []byte {\n\
+                 \trequest := grpcAppendBytes(nil, 1, data)\n\
+                 \trequest = grpcAppendVarint(request, 2, uint64(rounds))\n\
+                 \t_, value, err := grpcCall(\"/test.hashing.Hashing/HasherHash\", \
+                 \"hashing_hasher_hash\", request)\n\
+                 \tif err != nil {\n\t\tpanic(err)\n\t}\n\
+                 \treturn value\n"
+            ),
+            "functions should encode their parameters as fields of the request"
+        );
+        assert!(
+            code.contains("\trequest = grpcAppendVarint(request, 2, protowire.EncodeBool(loud))\n")
+                && code.contains(
+                    "\tif err != nil {\n\t\treturn \"\", err\n\t}\n\treturn string(value), nil\n"
+                )
+                && code.contains("\tcase codes.Unknown:\n"),
+            "fallible functions should return the service's errors"
+        );
+
+        let (name, definition) = generator
+            .generate_proto_file()
+            .expect("failed to generate the proto file")
+            .expect("gRPC bindings come with a proto file");
+        assert_eq!(name, "hashing.proto");
+        assert!(
+            definition
+                .contains("  rpc HasherHash(HasherHashRequest) returns (HasherHashResponse);\n")
+        );
+    }
+
+    #[test]
+    fn test_generate_go_http_handlers() {
+        let source = r#"
+            package test:shop;
+
+            interface catalog {
+                enum color { red, dark-blue }
+
+                record item {
+                    full-name: string,
+                    color: color,
+                }
+
+                variant shape { circle(f32), square }
+
+                find: func(name: string, limit: u8) -> list<item>;
+                paint: func(item: item, color: color) -> result<item, string>;
+                reset: func();
+                area: func(shape: shape) -> f32;
+            }
+
+            world shop {
+                export catalog;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("shop.wit", source)
+            .expect("failed to parse shop WIT");
+        let world_id = resolve.packages[pkg_id].worlds["shop"];
+        let config = GoConfig {
+            http_handlers: true,
             ..GoConfig::default()
         };
-        let generator2 = GoGenerator::new(&resolve, world_id, config2);
-        assert_eq!(generator2.package_name(), "mypkg");
+        let generator = GoGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Go code");
+        let (file_name, handlers) = generator
+            .generate_http_handlers_file()
+            .expect("failed to generate HTTP handlers")
+            .expect("http_handlers.go should be generated");
+        eprintln!("--- Generated HTTP handlers ---\n{handlers}\n--- End ---");
+
+        assert_eq!(file_name, "http_handlers.go");
+        assert!(
+            code.contains("\tFullName string `json:\"full-name\"`\n"),
+            "record fields should be tagged with their WIT names"
+        );
+        assert!(
+            handlers.contains("\tmux.HandleFunc(\"POST /catalog.find\", handleCatalogFind)\n")
+                && handlers
+                    .contains("\tmux.HandleFunc(\"POST /catalog.reset\", handleCatalogReset)\n")
+                && !handlers.contains("CatalogArea"),
+            "functions should be served unless JSON cannot decode their values"
+        );
+        assert!(
+            handlers.contains(
+                "\tvar request struct {\n\
+                 \t\tName string `json:\"name\"`\n\
+                 \t\tLimit uint8 `json:\"limit\"`\n\
+                 \t}\n\
+                 \tif !httpDecode(w, r, &request) {\n\t\treturn\n\t}\n\
+                 \tvalue := CatalogFind(request.Name, request.Limit)\n\
+                 \thttpRespond(w, http.StatusOK, httpResponse{Value: value})\n"
+            ),
+            "handlers should call the function with the decoded parameters"
+        );
+        assert!(
+            handlers.contains(
+                "\tvalue, err := CatalogPaint(request.Item, request.Color)\n\
+                 \tif err != nil {\n\
+                 \t\thttpRespond(w, http.StatusUnprocessableEntity, httpResponse{Error: err.Error()})\n"
+            ) && handlers.contains("\tCatalogReset()\n\thttpRespond(w, http.StatusOK, httpResponse{})\n"),
+            "errors should be answered with 422"
+        );
+        assert!(
+            handlers.contains("func (e Color) MarshalText() ([]byte, error) {")
+                && handlers.contains("\tparsed, err := ParseColor(string(text))\n"),
+            "enums should marshal as their WIT names"
+        );
     }
 }
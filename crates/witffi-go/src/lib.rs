@@ -5,5 +5,7 @@
 //! frees the C memory immediately, matching Go's garbage-collected memory model.
 
 pub mod generate;
+pub mod plugin;
 
 pub use generate::GoGenerator;
+pub use plugin::{GoFunction, GoPlugin};
@@ -0,0 +1,56 @@
+//! Plugins customizing the generated Go without forking witffi.
+//!
+//! A [`GoPlugin`] is handed to the generator through
+//! [`GoConfig::plugins`](crate::generate::GoConfig::plugins) and is called
+//! at fixed points of generation: to add imports and declarations to the
+//! bindings, to wrap the errors the exported functions return (e.g. in a
+//! company-wide error type), and to rewrite the Go source of each wrapper.
+//! Every hook has a default doing nothing, so a plugin implements only the
+//! ones it needs. See `examples/error_plugin.rs` for a plugin wrapping
+//! errors.
+
+/// An exported WIT function, as [`GoPlugin`] hooks see it.
+#[derive(Debug, Clone, Copy)]
+pub struct GoFunction<'a> {
+    /// The WIT interface exporting the function, empty for functions the
+    /// world exports directly.
+    pub interface: &'a str,
+
+    /// The qualified WIT name of the function (e.g. "parser.parse", or
+    /// "types.counter.get" for a resource method).
+    pub wit_name: &'a str,
+
+    /// The name of the Go wrapper (e.g. "ParserParse").
+    pub go_name: &'a str,
+}
+
+/// Hooks into Go code generation. Plugins are called in the order they are
+/// configured, each one seeing the output of those before it.
+pub trait GoPlugin: std::fmt::Debug + Send + Sync {
+    /// Import paths of the Go packages the plugin's code uses (e.g.
+    /// "example.com/corp/errors").
+    fn imports(&self) -> Vec<String> {
+        Vec::new()
+    }
+
+    /// Go declarations added at the end of the bindings.
+    fn declarations(&self) -> String {
+        String::new()
+    }
+
+    /// A Go expression to return instead of `err`, an error the wrapper of
+    /// `function` is returning, or None to return it as it is. The
+    /// expression is only evaluated when `err` is not nil.
+    fn wrap_error(&self, function: &GoFunction, err: &str) -> Option<String> {
+        let _ = (function, err);
+        None
+    }
+
+    /// The Go source of the wrapper of `function` (with its `Into` and `Seq`
+    /// variants, if generated), rewritten. Called once the plugin's errors
+    /// are wrapped.
+    fn rewrite_function(&self, function: &GoFunction, code: String) -> String {
+        let _ = function;
+        code
+    }
+}
@@ -476,7 +476,18 @@ impl<'a> KotlinGenerator<'a> {
         )?;
         writeln!(out, "interface {interface_name} {{")?;
 
-        let funcs = exported_functions(self.resolve, self.world_id);
+        // Resources, async functions, streams, futures and error contexts
+        // are not yet supported by this backend.
+        let funcs: Vec<_> = exported_functions(self.resolve, self.world_id)
+            .into_iter()
+            .filter(|ef| {
+                ef.resource().is_none()
+                    && !ef.is_awaited(self.resolve)
+                    && ef.stream_item(self.resolve).is_none()
+                    && !ef.takes_byte_streams(self.resolve)
+                    && !ef.uses_error_context(self.resolve)
+            })
+            .collect();
         for ef in &funcs {
             let method_name = self.method_name(ef);
             let result_decomposed = self.decompose_result(&ef.function.result);
@@ -555,7 +566,18 @@ impl<'a> KotlinGenerator<'a> {
         writeln!(out)?;
 
         // Generate external fun declarations
-        let funcs = exported_functions(self.resolve, self.world_id);
+        // Resources, async functions, streams, futures and error contexts
+        // are not yet supported by this backend.
+        let funcs: Vec<_> = exported_functions(self.resolve, self.world_id)
+            .into_iter()
+            .filter(|ef| {
+                ef.resource().is_none()
+                    && !ef.is_awaited(self.resolve)
+                    && ef.stream_item(self.resolve).is_none()
+                    && !ef.takes_byte_streams(self.resolve)
+                    && !ef.uses_error_context(self.resolve)
+            })
+            .collect();
         for ef in &funcs {
             self.generate_external_fun(out, ef)?;
         }
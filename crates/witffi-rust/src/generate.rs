@@ -7,17 +7,28 @@
 //!    conversion logic, `extern "C"` wrapper functions, and error handling
 //! 4. A `witffi_register_jni!` macro that generates JNI `Java_` entry points,
 //!    JVM object construction, and exception-based error handling
-//! 5. `free_*` functions for heap-allocated C-ABI return types (inside the FFI macro)
-//! 6. A C header string
+//! 5. A `witffi_register_wasm!` macro that exports the functions a
+//!    WebAssembly build can pass through the canonical ABI
+//! 6. A `witffi_register_sidecar!` macro that serves the same functions to
+//!    bindings running the library in a process of its own
+//! 7. A `witffi_register_grpc!` macro that serves them as the world's gRPC
+//!    service
+//! 8. `free_*` functions for heap-allocated C-ABI return types (inside the FFI macro)
+//! 9. An `imports` module of safe wrappers around functions the world imports,
+//!    which the foreign caller implements
+//! 10. A C header string
 
 use std::collections::HashSet;
 use std::fmt::Write;
 
 use heck::ToSnakeCase;
 use snafu::prelude::*;
-use wit_parser::{Resolve, Type, TypeDefKind, TypeId, WorldId};
+use wit_parser::{Handle, Resolve, Type, TypeDefKind, TypeId, WorldId};
 
-use witffi_core::{ExportedFunction, exported_functions, names};
+use witffi_core::{
+    ExportedFunction, ImportResult, ImportSignature, ImportValue, canonical, exported_functions,
+    import_signature, imported_functions, ipc, names, plain_signature, proto,
+};
 
 /// Errors that can occur during Rust code generation.
 #[derive(Debug, Snafu)]
@@ -25,6 +36,10 @@ pub enum Error {
     /// A write to the output buffer failed.
     #[snafu(display("code generation write error"))]
     Write { source: std::fmt::Error },
+
+    /// The gRPC service the registration macro serves cannot be defined.
+    #[snafu(display("cannot define the gRPC service"))]
+    Proto { source: witffi_core::Error },
 }
 
 /// Describes what a C-ABI panic arm should return.
@@ -33,6 +48,10 @@ enum FfiPanicReturn<'a> {
     NullPtr,
     /// A boolean success/failure — return `false`.
     Bool,
+    /// A signed byte count — return `-1`.
+    Count,
+    /// Nothing, for functions returning `()`.
+    Unit,
     /// A typed return value — produce a type-appropriate empty sentinel.
     Type(&'a Type),
 }
@@ -49,6 +68,29 @@ pub struct RustConfig {
     pub kotlin_package: Option<String>,
     /// Library name for JNI `System.loadLibrary()` (e.g. "eip681ffi").
     pub library_name: Option<String>,
+    /// Also export an `_into` variant of each function returning a string
+    /// or `list<u8>`, which writes the result into a caller-supplied buffer.
+    pub into_variants: bool,
+    /// Records, by WIT name, whose `list<record>` results are also returned
+    /// column by column, through a `_columns` variant: one array per field.
+    pub columnar: HashSet<String>,
+    /// Route the boxes handed across the FFI through
+    /// `witffi_types::accounting`, and export `_free_box` and
+    /// `_alloc_stats` so the caller can free boxes and read the counts.
+    pub alloc_accounting: bool,
+    /// Install `witffi_types::canary::CanaryAlloc` as the library's global
+    /// allocator, which aborts on a write past either end of a block when
+    /// the block is freed, and export `_free_box` for the caller to free
+    /// boxes through it. For debug builds only.
+    pub alloc_canaries: bool,
+    /// Add `init_library` and `shutdown_library` to the world trait, called
+    /// through `_init` and `_shutdown` when the caller initializes the
+    /// library and shuts it down.
+    pub lifecycle: bool,
+    /// Export `_set_log_sink`, forwarding the library's `log` records to a
+    /// sink the caller registers. The library must enable `witffi-types`'
+    /// `log` feature.
+    pub log_bridge: bool,
 }
 
 impl Default for RustConfig {
@@ -58,6 +100,12 @@ impl Default for RustConfig {
             c_type_prefix: "Ffi".to_string(),
             kotlin_package: None,
             library_name: None,
+            into_variants: false,
+            columnar: HashSet::new(),
+            alloc_accounting: false,
+            alloc_canaries: false,
+            lifecycle: false,
+            log_bridge: false,
         }
     }
 }
@@ -82,10 +130,14 @@ impl<'a> RustGenerator<'a> {
     ///
     /// # Errors
     ///
-    /// Returns an error if writing to the output buffer fails.
+    /// Returns an error if the world belongs to no package, which names the
+    /// proto package of `witffi_register_grpc!`, or if writing to the output
+    /// buffer fails.
     pub fn generate(&self) -> Result<String, Error> {
+        let proto_package = proto::package_name(self.resolve, self.world_id).context(ProtoSnafu)?;
         let mut out = String::new();
-        self.generate_inner(&mut out).context(WriteSnafu)?;
+        self.generate_inner(&mut out, &proto_package)
+            .context(WriteSnafu)?;
         Ok(out)
     }
 
@@ -100,14 +152,18 @@ impl<'a> RustGenerator<'a> {
         Ok(out)
     }
 
-    fn generate_inner(&self, out: &mut String) -> std::fmt::Result {
+    fn generate_inner(&self, out: &mut String, proto_package: &str) -> std::fmt::Result {
         writeln!(out, "// Auto-generated by witffi. Do not edit.")?;
         writeln!(out)?;
 
         self.generate_idiomatic_types(out)?;
         self.generate_trait(out)?;
+        self.generate_imports(out)?;
         self.generate_register_ffi_macro(out)?;
         self.generate_register_jni_macro(out)?;
+        self.generate_register_wasm_macro(out)?;
+        self.generate_register_sidecar_macro(out)?;
+        self.generate_register_grpc_macro(out, proto_package)?;
 
         Ok(())
     }
@@ -132,6 +188,7 @@ impl<'a> RustGenerator<'a> {
         self.generate_c_function_decls(out)?;
         writeln!(out)?;
         self.generate_c_free_decls(out)?;
+        self.generate_c_import_decls(out)?;
 
         writeln!(out)?;
         writeln!(out, "#ifdef __cplusplus")?;
@@ -198,7 +255,11 @@ impl<'a> RustGenerator<'a> {
                     }
                 }
             }
-            TypeDefKind::List(ty) | TypeDefKind::Option(ty) => {
+            TypeDefKind::List(ty)
+            | TypeDefKind::Option(ty)
+            | TypeDefKind::FixedLengthList(ty, _)
+            | TypeDefKind::Stream(Some(ty))
+            | TypeDefKind::Future(Some(ty)) => {
                 self.visit_type(ty, visited, order);
             }
             TypeDefKind::Result(r) => {
@@ -217,9 +278,24 @@ impl<'a> RustGenerator<'a> {
             TypeDefKind::Type(ty) => {
                 self.visit_type(ty, visited, order);
             }
+            TypeDefKind::Handle(Handle::Own(id) | Handle::Borrow(id)) => {
+                self.visit_type_id(*id, visited, order);
+            }
             _ => {}
         }
 
+        // Structurally identical tuples and fixed-length lists share a single
+        // generated struct
+        if let Some(shape) = witffi_core::structural_shape_name(self.resolve, &typedef.kind) {
+            let seen = order.iter().any(|other| {
+                witffi_core::structural_shape_name(self.resolve, &self.resolve.types[*other].kind)
+                    .is_some_and(|s| s == shape)
+            });
+            if seen {
+                return;
+            }
+        }
+
         order.push(type_id);
     }
 
@@ -253,6 +329,26 @@ impl<'a> RustGenerator<'a> {
         writeln!(out, "// ---- Idiomatic Rust types ----")?;
         writeln!(out)?;
 
+        // 128-bit integers defined as `tuple<u64, u64>` share one slot among
+        // the reachable types with every other tuple of that shape, so each
+        // is aliased here
+        let world = &self.resolve.worlds[self.world_id];
+        for item in world.exports.values() {
+            let wit_parser::WorldItem::Interface { id, .. } = item else {
+                continue;
+            };
+            for (name, type_id) in &self.resolve.interfaces[*id].types {
+                if !matches!(self.resolve.types[*type_id].kind, TypeDefKind::Tuple(_)) {
+                    continue;
+                }
+                if let Some(wide) = witffi_core::wide_int(self.resolve, &Type::Id(*type_id)) {
+                    let inner_ty = if wide.is_signed() { "i128" } else { "u128" };
+                    writeln!(out, "pub type {} = {inner_ty};", names::to_rust_type(name))?;
+                    writeln!(out)?;
+                }
+            }
+        }
+
         let reachable = self.collect_reachable_types();
         for type_id in &reachable {
             self.generate_idiomatic_type_def(out, *type_id)?;
@@ -340,7 +436,11 @@ impl<'a> RustGenerator<'a> {
 
             TypeDefKind::Type(inner) => {
                 let rust_name = names::to_rust_type(wit_name);
-                let inner_ty = self.type_to_idiomatic(inner);
+                let inner_ty = match witffi_core::wide_int(self.resolve, &Type::Id(type_id)) {
+                    Some(wide) if wide.is_signed() => "i128".to_string(),
+                    Some(_) => "u128".to_string(),
+                    None => self.type_to_idiomatic(inner),
+                };
                 // Skip self-referential aliases (from `use` across interfaces)
                 if rust_name != inner_ty {
                     writeln!(out, "pub type {rust_name} = {inner_ty};")?;
@@ -351,10 +451,22 @@ impl<'a> RustGenerator<'a> {
             TypeDefKind::List(_)
             | TypeDefKind::Option(_)
             | TypeDefKind::Result(_)
-            | TypeDefKind::Tuple(_) => {
+            | TypeDefKind::Tuple(_)
+            | TypeDefKind::FixedLengthList(..) => {
                 // Handled inline when they appear as field/param types
             }
 
+            TypeDefKind::Stream(_) | TypeDefKind::Future(_) => {
+                // Streams are producers behind an opaque pointer (see
+                // `generate_ffi_stream_function`), and futures are awaited
+                // like async functions (see `generate_ffi_async_function`)
+            }
+
+            TypeDefKind::Resource | TypeDefKind::Handle(_) => {
+                // Resources are backed by associated types on the
+                // implementation trait (see `generate_trait`).
+            }
+
             other => {
                 writeln!(
                     out,
@@ -383,6 +495,12 @@ impl<'a> RustGenerator<'a> {
             Type::F64 => "f64".to_string(),
             Type::Char => "char".to_string(),
             Type::String => "String".to_string(),
+            Type::Id(_) if witffi_core::wide_int(self.resolve, ty).is_some() => {
+                match witffi_core::wide_int(self.resolve, ty) {
+                    Some(witffi_core::WideInt::S128) => "i128".to_string(),
+                    _ => "u128".to_string(),
+                }
+            }
             Type::Id(id) => {
                 let typedef = &self.resolve.types[*id];
                 match &typedef.kind {
@@ -405,22 +523,24 @@ impl<'a> RustGenerator<'a> {
                             .unwrap_or_else(|| "()".to_string());
                         format!("Result<{ok_ty}, {err_ty}>")
                     }
-                    TypeDefKind::Tuple(tuple) => {
-                        let types: Vec<String> = tuple
-                            .types
-                            .iter()
-                            .map(|t| self.type_to_idiomatic(t))
-                            .collect();
-                        format!("({})", types.join(", "))
+                    TypeDefKind::Tuple(tuple) => self.tuple_to_idiomatic(tuple),
+                    TypeDefKind::FixedLengthList(inner, len) => {
+                        format!("[{}; {len}]", self.type_to_idiomatic(inner))
                     }
                     TypeDefKind::Type(aliased) => self.type_to_idiomatic(aliased),
+                    TypeDefKind::Handle(Handle::Own(resource_id)) => {
+                        format!("Self::{}", self.resource_type_name(*resource_id))
+                    }
+                    TypeDefKind::Handle(Handle::Borrow(resource_id)) => {
+                        format!("&Self::{}", self.resource_type_name(*resource_id))
+                    }
                     _ => {
                         let name = typedef.name.as_deref().unwrap_or("Anonymous");
                         names::to_rust_type(name)
                     }
                 }
             }
-            Type::ErrorContext => "String".to_string(),
+            Type::ErrorContext => "witffi_types::ErrorContext".to_string(),
         }
     }
 
@@ -442,26 +562,119 @@ impl<'a> RustGenerator<'a> {
         )?;
         writeln!(out, "pub trait {trait_name} {{")?;
 
+        for resource_id in self.exported_resources() {
+            let wit_name = self.resolve.types[resource_id]
+                .name
+                .as_deref()
+                .unwrap_or("resource");
+            writeln!(out, "    /// Rust type backing the `{wit_name}` resource.")?;
+            writeln!(
+                out,
+                "    type {}: 'static;",
+                self.resource_type_name(resource_id)
+            )?;
+        }
+
         let funcs = exported_functions(self.resolve, self.world_id);
         for ef in &funcs {
             let method_name = self.trait_method_name(ef);
 
+            // Async futures and streams outlive the call, so they take owned
+            // arguments
             let params: Vec<String> = ef
                 .function
                 .params
                 .iter()
                 .map(|p| {
-                    format!(
-                        "{}: {}",
-                        names::to_rust_ident(&p.name),
+                    let ty = if ef.owns_arguments(self.resolve) {
+                        self.type_to_idiomatic(&p.ty)
+                    } else {
                         self.type_to_trait_param(&p.ty)
-                    )
+                    };
+                    format!("{}: {ty}", names::to_rust_ident(&p.name))
                 })
                 .collect();
 
-            let ret = self.function_return_to_trait(&ef.function.result);
+            let mut ret = match ef.stream_item(self.resolve) {
+                Some(Type::U8) => "impl std::io::Read + Send + 'static".to_string(),
+                Some(item) => format!(
+                    "impl Iterator<Item = {}> + Send + 'static",
+                    self.type_to_idiomatic(&item)
+                ),
+                None => self.function_return_to_trait(&ef.function.result),
+            };
+            if let Some(value) = ef.future_value(self.resolve) {
+                ret = self.function_return_to_trait(&Some(value));
+            }
+            if ef.is_awaited(self.resolve) {
+                ret = format!("impl std::future::Future<Output = {ret}> + Send + 'static");
+            }
+
+            match self.batch_default(ef, &funcs) {
+                Some(body) => {
+                    writeln!(
+                        out,
+                        "    fn {method_name}({}) -> {ret} {{",
+                        params.join(", ")
+                    )?;
+                    writeln!(out, "        {body}")?;
+                    writeln!(out, "    }}")?;
+                }
+                None => {
+                    writeln!(out, "    fn {method_name}({}) -> {ret};", params.join(", "))?;
+                }
+            }
+        }
+
+        if funcs.iter().any(|ef| ef.is_awaited(self.resolve)) {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "    /// Run the future behind an async function call, or a returned"
+            )?;
+            writeln!(out, "    /// `future<T>`, to completion.")?;
+            writeln!(out, "    ///")?;
+            writeln!(
+                out,
+                "    /// Each call gets its own thread by default; override this to spawn"
+            )?;
+            writeln!(out, "    /// onto an existing runtime instead.")?;
+            writeln!(out, "    fn spawn_task(task: witffi_types::AsyncTask) {{")?;
+            writeln!(out, "        witffi_types::spawn_thread(task);")?;
+            writeln!(out, "    }}")?;
+        }
 
-            writeln!(out, "    fn {method_name}({}) -> {ret};", params.join(", "))?;
+        if self.config.lifecycle {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "    /// Set up library-wide state, such as statics and thread pools, when"
+            )?;
+            writeln!(
+                out,
+                "    /// the caller initializes the library, with the key-value options it"
+            )?;
+            writeln!(
+                out,
+                "    /// passed. An error fails the caller's initialization."
+            )?;
+            writeln!(
+                out,
+                "    fn init_library(options: &[(String, String)]) -> Result<(), String> {{"
+            )?;
+            writeln!(out, "        let _ = options;")?;
+            writeln!(out, "        Ok(())")?;
+            writeln!(out, "    }}")?;
+            writeln!(out)?;
+            writeln!(
+                out,
+                "    /// Tear down library-wide state, such as flushing logs and dropping"
+            )?;
+            writeln!(
+                out,
+                "    /// caches, when the caller shuts the library down."
+            )?;
+            writeln!(out, "    fn shutdown_library() {{}}")?;
         }
 
         writeln!(out, "}}")?;
@@ -469,7 +682,17 @@ impl<'a> RustGenerator<'a> {
     }
 
     fn trait_method_name(&self, ef: &ExportedFunction) -> String {
-        if ef.interface_name.is_empty() {
+        if let Some(resource_id) = ef.resource() {
+            let resource_name = self.resolve.types[witffi_core::dealias(self.resolve, resource_id)]
+                .name
+                .as_deref()
+                .unwrap_or("resource");
+            names::to_rust_ident(&format!(
+                "{}-{resource_name}-{}",
+                ef.interface_name,
+                ef.item_name()
+            ))
+        } else if ef.interface_name.is_empty() {
             names::to_rust_ident(&ef.function_name)
         } else {
             names::to_rust_ident(&format!("{}-{}", ef.interface_name, ef.function_name))
@@ -479,18 +702,95 @@ impl<'a> RustGenerator<'a> {
     /// Map a WIT type to its Rust representation for trait method parameters.
     ///
     /// Parameters use borrowed types where possible: `&str` for strings,
-    /// `&[u8]` for byte slices.
+    /// `&[u8]` for byte slices, and `Option<&str>` for optional strings.
+    /// The default body of a batched function's trait method, calling the
+    /// function it batches once per element, if its arguments can be passed
+    /// on from the lists.
+    ///
+    /// Implementations may override it with a faster, vectorized version.
+    fn batch_default(&self, ef: &ExportedFunction, funcs: &[ExportedFunction]) -> Option<String> {
+        let base = ef.batch_of(self.resolve, funcs)?;
+        let mut elems = Vec::new();
+        let mut args = Vec::new();
+        for (p, base_param) in ef.function.params.iter().zip(&base.function.params) {
+            let name = names::to_rust_ident(&p.name);
+            // `list<u8>` is borrowed as a slice, so its bytes are copied out
+            let elems_expr = if self.type_to_trait_param(&p.ty).starts_with('&') {
+                format!("{name}.iter().copied()")
+            } else {
+                format!("{name}.into_iter()")
+            };
+            elems.push(elems_expr);
+            let trait_ty = self.type_to_trait_param(&base_param.ty);
+            let arg = if trait_ty == self.type_to_idiomatic(&base_param.ty) {
+                name
+            } else if trait_ty.starts_with('&') {
+                format!("&{name}")
+            } else if trait_ty.starts_with("Option<&") {
+                format!("{name}.as_deref()")
+            } else {
+                return None;
+            };
+            args.push(arg);
+        }
+
+        // Zipping nests the elements to the left, e.g. `((a, b), c)`
+        let mut iter = elems[0].clone();
+        let mut pattern = names::to_rust_ident(&ef.function.params[0].name);
+        for (p, elems) in ef.function.params.iter().zip(&elems).skip(1) {
+            let name = names::to_rust_ident(&p.name);
+            iter = format!("{iter}.zip({})", elems.trim_end_matches(".into_iter()"));
+            pattern = format!("({pattern}, {name})");
+        }
+        let call = format!(
+            "Self::{}({})",
+            self.trait_method_name(base),
+            args.join(", ")
+        );
+        Some(match ef.function.result {
+            Some(_) => format!("{iter}.map(|{pattern}| {call}).collect()"),
+            None => format!("{iter}.for_each(|{pattern}| {call})"),
+        })
+    }
+
     fn type_to_trait_param(&self, ty: &Type) -> String {
         match ty {
             Type::String => "&str".to_string(),
+            Type::Id(_) if witffi_core::wide_int(self.resolve, ty).is_some() => {
+                self.type_to_idiomatic(ty)
+            }
             Type::Id(id) => {
                 let typedef = &self.resolve.types[*id];
                 match &typedef.kind {
                     TypeDefKind::List(Type::U8) => "&[u8]".to_string(),
+                    TypeDefKind::Stream(Some(Type::U8)) => {
+                        "witffi_types::ByteStream<'_>".to_string()
+                    }
+                    TypeDefKind::Option(inner) => {
+                        format!("Option<{}>", self.type_to_trait_param(inner))
+                    }
                     TypeDefKind::List(inner) => {
                         format!("Vec<{}>", self.type_to_idiomatic(inner))
                     }
                     TypeDefKind::Type(aliased) => self.type_to_trait_param(aliased),
+                    TypeDefKind::Tuple(tuple) => {
+                        // Tuple parameters are flattened across the C ABI, so
+                        // their elements are borrowed like top-level params.
+                        let types: Vec<String> = tuple
+                            .types
+                            .iter()
+                            .map(|t| self.type_to_trait_param(t))
+                            .collect();
+                        if types.len() == 1 {
+                            format!("({},)", types[0])
+                        } else {
+                            format!("({})", types.join(", "))
+                        }
+                    }
+                    TypeDefKind::FixedLengthList(inner, len) => {
+                        // Flattened like tuples, one parameter per element
+                        format!("[{}; {len}]", self.type_to_trait_param(inner))
+                    }
                     _ => self.type_to_idiomatic(ty),
                 }
             }
@@ -505,1009 +805,1490 @@ impl<'a> RustGenerator<'a> {
         }
     }
 
-    // ---- C-ABI type mapping (used by witffi_register_ffi! and C header) ----
+    // ---- Imported functions ----
 
-    /// Map a WIT type to its Rust FFI-safe `#[repr(C)]` representation.
-    fn type_to_c_rust(&self, ty: &Type) -> String {
-        match ty {
-            Type::Bool => "bool".to_string(),
-            Type::U8 => "u8".to_string(),
-            Type::U16 => "u16".to_string(),
-            Type::U32 => "u32".to_string(),
-            Type::U64 => "u64".to_string(),
-            Type::S8 => "i8".to_string(),
-            Type::S16 => "i16".to_string(),
-            Type::S32 => "i32".to_string(),
-            Type::S64 => "i64".to_string(),
-            Type::F32 => "f32".to_string(),
-            Type::F64 => "f64".to_string(),
-            Type::Char => "u32".to_string(),
-            Type::String => "witffi_types::FfiByteBuffer".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(inner) => match inner {
-                        Type::U8 => "witffi_types::FfiByteBuffer".to_string(),
-                        _ => format!(
-                            "witffi_types::FfiByteBuffer /* list<{}> */",
-                            self.type_to_c_rust(inner)
-                        ),
-                    },
-                    TypeDefKind::Option(inner) => {
-                        format!("*mut {}", self.type_to_c_rust(inner))
-                    }
-                    TypeDefKind::Result(result) => {
-                        let ok_ty = result
-                            .ok
-                            .as_ref()
-                            .map(|t| self.type_to_c_rust(t))
-                            .unwrap_or_else(|| "()".to_string());
-                        format!("/* result<{ok_ty}, ...> */")
-                    }
-                    TypeDefKind::Tuple(tuple) => {
-                        let types: Vec<String> =
-                            tuple.types.iter().map(|t| self.type_to_c_rust(t)).collect();
-                        format!("/* tuple<{}> */", types.join(", "))
-                    }
-                    TypeDefKind::Type(aliased) => self.type_to_c_rust(aliased),
-                    _ => {
-                        let name = typedef.name.as_deref().unwrap_or("Anonymous");
-                        names::to_c_type(&self.config.c_type_prefix, name)
-                    }
-                }
+    /// The imported functions with their C-ABI shapes. Unsupported imports
+    /// are rejected up front by `witffi_core::check_imported_functions`.
+    fn imports(&self) -> Vec<(ExportedFunction, ImportSignature)> {
+        imported_functions(self.resolve, self.world_id)
+            .into_iter()
+            .filter_map(|ef| {
+                let sig = import_signature(self.resolve, &ef).ok()?;
+                Some((ef, sig))
+            })
+            .collect()
+    }
+
+    /// The Rust type of an imported value as passed across the C ABI.
+    fn import_c_type(&self, value: ImportValue, input: bool) -> String {
+        match value {
+            ImportValue::Scalar(ty) => self.type_to_idiomatic(&ty),
+            ImportValue::String | ImportValue::Bytes if input => {
+                "witffi_types::FfiByteSlice".to_string()
             }
-            Type::ErrorContext => "u32 /* error-context */".to_string(),
+            ImportValue::String | ImportValue::Bytes => "witffi_types::FfiByteBuffer".to_string(),
+            ImportValue::Own(_) | ImportValue::Borrow(_) => "*mut std::ffi::c_void".to_string(),
         }
     }
 
-    /// Map a WIT type to its FFI-safe input representation.
-    ///
-    /// Input types differ from output types: strings and byte slices use
-    /// `FfiByteSlice` (const pointer, caller-owned) rather than `FfiByteBuffer`
-    /// (mutable pointer, callee-owned).
-    fn type_to_ffi_input(&self, ty: &Type) -> String {
-        match ty {
-            Type::String => "witffi_types::FfiByteSlice".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => "witffi_types::FfiByteSlice".to_string(),
-                    TypeDefKind::List(_) => "witffi_types::FfiByteSlice".to_string(),
-                    TypeDefKind::Type(aliased) => self.type_to_ffi_input(aliased),
-                    _ => self.type_to_c_rust(ty),
-                }
+    /// The C-ABI parameters of an imported function as `(name, Rust type)`,
+    /// including the `ok_out`/`err_out` pointers of fallible imports.
+    fn import_c_params(&self, sig: &ImportSignature) -> Vec<(String, String)> {
+        let mut params: Vec<(String, String)> = sig
+            .params
+            .iter()
+            .map(|(name, value)| (names::to_rust_ident(name), self.import_c_type(*value, true)))
+            .collect();
+        if let ImportResult::Fallible(ok) = sig.result {
+            if let Some(ok) = ok {
+                params.push((
+                    "ok_out".to_string(),
+                    format!("*mut {}", self.import_c_type(ok, false)),
+                ));
             }
-            _ => self.type_to_c_rust(ty),
+            params.push((
+                "err_out".to_string(),
+                "*mut witffi_types::FfiByteBuffer".to_string(),
+            ));
         }
+        params
     }
 
-    // ---- witffi_register_ffi! macro generation ----
+    /// Lift a value returned by an import implementation, held in `expr`.
+    /// Returned resources are owned by the generic implementation type `T`.
+    fn import_lift_expr(&self, value: ImportValue, expr: &str, module_path: &str) -> String {
+        match value {
+            ImportValue::Scalar(_) => expr.to_string(),
+            ImportValue::String => format!("unsafe {{ {module_path}take_string({expr}) }}"),
+            ImportValue::Bytes => format!("unsafe {{ {module_path}take_buffer({expr}) }}"),
+            ImportValue::Own(id) | ImportValue::Borrow(id) => format!(
+                "unsafe {{ {module_path}take_handle::<T::{}>({expr}) }}",
+                self.resource_type_name(id)
+            ),
+        }
+    }
 
-    fn generate_register_ffi_macro(&self, out: &mut String) -> std::fmt::Result {
-        let world = &self.resolve.worlds[self.world_id];
-        let trait_name = names::to_rust_type(&world.name);
-        let prefix = self.config.c_prefix.to_snake_case();
+    /// Generate the `imports` module wrapping the functions the world
+    /// imports.
+    ///
+    /// The foreign caller implements each import as an unmangled
+    /// `{prefix}_import_{interface}_{function}` symbol (generated for Go as
+    /// a cgo export). Strings and bytes returned by it are allocated with
+    /// `malloc` and freed here once copied. A panic in an implementation is
+    /// reported through `{prefix}_import_panicked` rather than unwinding,
+    /// and resumed as a Rust panic once the import returns.
+    fn generate_imports(&self, out: &mut String) -> std::fmt::Result {
+        let imports = self.imports();
+        if imports.is_empty() {
+            return Ok(());
+        }
+
+        let returns_buffer =
+            |value: &ImportValue| matches!(value, ImportValue::String | ImportValue::Bytes);
+        let returns_string = |value: &ImportValue| *value == ImportValue::String;
+        let returns_handle = |value: &ImportValue| matches!(value, ImportValue::Own(_));
+        let (mut uses_buffers, mut uses_strings, mut uses_handles) = (false, false, false);
+        for (_, sig) in &imports {
+            let returned = match sig.result {
+                ImportResult::Value(value) | ImportResult::Fallible(Some(value)) => Some(value),
+                ImportResult::None | ImportResult::Fallible(None) => None,
+            };
+            let fallible = matches!(sig.result, ImportResult::Fallible(_));
+            uses_buffers |= fallible || returned.as_ref().is_some_and(returns_buffer);
+            uses_strings |= fallible || returned.as_ref().is_some_and(returns_string);
+            uses_handles |= returned.as_ref().is_some_and(returns_handle);
+        }
 
-        writeln!(out, "// ---- C-ABI Registration macro ----")?;
+        writeln!(out)?;
+        writeln!(out, "// ---- Imported functions ----")?;
         writeln!(out)?;
         writeln!(
             out,
-            "/// Register a concrete type for C-ABI FFI (Swift, Go, C consumers)."
+            "/// Functions the world imports, implemented by the foreign caller and"
         )?;
-        writeln!(out, "///")?;
+        writeln!(out, "/// callable from the implementation.")?;
+        writeln!(out, "pub mod imports {{")?;
+        writeln!(out, "    unsafe extern \"C\" {{")?;
+        if uses_buffers {
+            writeln!(out, "        fn free(ptr: *mut std::ffi::c_void);")?;
+        }
+        for (ef, sig) in &imports {
+            let c_name = ef.c_import_name(&self.config.c_prefix);
+            let params: Vec<String> = self
+                .import_c_params(sig)
+                .iter()
+                .map(|(name, ty)| format!("{name}: {ty}"))
+                .collect();
+            let ret = match sig.result {
+                ImportResult::None => String::new(),
+                ImportResult::Value(value) => format!(" -> {}", self.import_c_type(value, false)),
+                ImportResult::Fallible(_) => " -> bool".to_string(),
+            };
+            writeln!(out, "        fn {c_name}({}){ret};", params.join(", "))?;
+        }
+        writeln!(out, "    }}")?;
+
+        let prefix = self.config.c_prefix.to_snake_case();
+        writeln!(out)?;
+        writeln!(out, "    std::thread_local! {{")?;
         writeln!(
             out,
-            "/// This macro generates `#[repr(C)]` shadow types, conversion logic,"
+            "        // The message of a panic in the caller's implementation of the"
         )?;
+        writeln!(out, "        // import being called.")?;
         writeln!(
             out,
-            "/// `extern \"C\"` wrapper functions, error handling, and free functions."
+            "        static IMPORT_PANIC: std::cell::RefCell<Option<String>> = const {{ std::cell::RefCell::new(None) }};"
         )?;
-        writeln!(out, "///")?;
-        writeln!(out, "/// # Example")?;
-        writeln!(out, "///")?;
-        writeln!(out, "/// ```ignore")?;
-        writeln!(out, "/// struct MyImpl;")?;
-        writeln!(out, "/// impl {trait_name} for MyImpl {{")?;
-        writeln!(out, "///     // ... implement trait methods ...")?;
-        writeln!(out, "/// }}")?;
-        writeln!(out, "/// witffi_register_ffi!(MyImpl);")?;
-        writeln!(out, "/// ```")?;
-        writeln!(out, "#[macro_export]")?;
-        writeln!(out, "macro_rules! witffi_register_ffi {{")?;
-        writeln!(out, "    ($impl_type:ty) => {{")?;
+        writeln!(out, "    }}")?;
         writeln!(out)?;
-
-        // Generate repr(C) shadow types inside the macro
-        self.generate_ffi_shadow_types(out)?;
-
-        // Generate conversion functions (idiomatic -> repr(C))
-        self.generate_ffi_conversions(out)?;
-
-        // Generate free functions
-        self.generate_ffi_free_functions(out)?;
-
-        // Thread-local error storage
         writeln!(
             out,
-            "        // Last error message stored for FFI error reporting."
+            "    /// Record that the caller's implementation of the import being called"
         )?;
-        writeln!(out, "        std::thread_local! {{")?;
         writeln!(
             out,
-            "            static LAST_ERROR: std::cell::RefCell<Option<String>> = const {{ std::cell::RefCell::new(None) }};"
+            "    /// panicked, which it reports here instead of unwinding into Rust."
         )?;
-        writeln!(out, "        }}")?;
-        writeln!(out)?;
-
-        // Error handling functions
-        self.generate_ffi_error_functions(out, &prefix)?;
-
-        // Generate extern "C" fns for each exported function
-        let funcs = exported_functions(self.resolve, self.world_id);
-        for ef in &funcs {
-            self.generate_ffi_extern_function(out, ef)?;
-        }
-
-        writeln!(out, "    }};")?;
-        writeln!(out, "}}")?;
+        writeln!(out, "    ///")?;
+        writeln!(out, "    /// # Safety")?;
+        writeln!(out, "    ///")?;
+        writeln!(
+            out,
+            "    /// `message` must point to valid memory for the duration of the call."
+        )?;
+        writeln!(out, "    #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "    pub unsafe extern \"C\" fn {prefix}_import_panicked(message: witffi_types::FfiByteSlice) {{"
+        )?;
+        writeln!(
+            out,
+            "        let message = String::from_utf8_lossy(unsafe {{ message.as_bytes() }}).into_owned();"
+        )?;
+        writeln!(
+            out,
+            "        IMPORT_PANIC.with(|p| *p.borrow_mut() = Some(message));"
+        )?;
+        writeln!(out, "    }}")?;
         writeln!(out)?;
-
-        // Backward-compatible alias
         writeln!(
             out,
-            "/// Backward-compatible alias for [`witffi_register_ffi!`]."
+            "    /// Resume a panic the caller reported while implementing `import`."
         )?;
-        writeln!(out, "#[macro_export]")?;
-        writeln!(out, "macro_rules! witffi_register {{")?;
-        writeln!(out, "    ($impl_type:ty) => {{")?;
-        writeln!(out, "        witffi_register_ffi!($impl_type);")?;
-        writeln!(out, "    }};")?;
-        writeln!(out, "}}")?;
-
-        Ok(())
-    }
+        writeln!(out, "    fn check_panic(import: &str) {{")?;
+        writeln!(
+            out,
+            "        if let Some(message) = IMPORT_PANIC.with(|p| p.borrow_mut().take()) {{"
+        )?;
+        writeln!(
+            out,
+            "            panic!(\"{{import}} panicked: {{message}}\");"
+        )?;
+        writeln!(out, "        }}")?;
+        writeln!(out, "    }}")?;
 
-    fn generate_ffi_shadow_types(&self, out: &mut String) -> std::fmt::Result {
-        writeln!(out, "        // ---- repr(C) shadow types for C-ABI ----")?;
-        writeln!(out)?;
-
-        let reachable = self.collect_reachable_types();
-        for type_id in &reachable {
-            self.generate_ffi_shadow_type_def(out, *type_id)?;
+        if uses_buffers {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "    /// Copy a buffer the caller allocated with `malloc`, then free it."
+            )?;
+            writeln!(
+                out,
+                "    unsafe fn take_buffer(buf: witffi_types::FfiByteBuffer) -> Vec<u8> {{"
+            )?;
+            writeln!(out, "        if buf.ptr.is_null() {{")?;
+            writeln!(out, "            return Vec::new();")?;
+            writeln!(out, "        }}")?;
+            writeln!(
+                out,
+                "        let bytes = unsafe {{ std::slice::from_raw_parts(buf.ptr, buf.len) }}.to_vec();"
+            )?;
+            writeln!(out, "        unsafe {{ free(buf.ptr.cast()) }};")?;
+            writeln!(out, "        bytes")?;
+            writeln!(out, "    }}")?;
+        }
+        if uses_strings {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "    /// Take a UTF-8 string the caller allocated with `malloc`."
+            )?;
+            writeln!(
+                out,
+                "    unsafe fn take_string(buf: witffi_types::FfiByteBuffer) -> String {{"
+            )?;
+            writeln!(
+                out,
+                "        String::from_utf8_lossy(&unsafe {{ take_buffer(buf) }}).into_owned()"
+            )?;
+            writeln!(out, "    }}")?;
+        }
+        if uses_handles {
+            writeln!(out)?;
+            writeln!(
+                out,
+                "    /// Take back ownership of a resource the caller returned."
+            )?;
+            writeln!(
+                out,
+                "    unsafe fn take_handle<R>(ptr: *mut std::ffi::c_void) -> R {{"
+            )?;
+            writeln!(
+                out,
+                "        assert!(!ptr.is_null(), \"an import returned a null resource handle\");"
+            )?;
+            writeln!(out, "        *unsafe {{ Box::from_raw(ptr as *mut R) }}")?;
+            writeln!(out, "    }}")?;
         }
 
-        Ok(())
-    }
-
-    fn generate_ffi_shadow_type_def(&self, out: &mut String, type_id: TypeId) -> std::fmt::Result {
-        let typedef = &self.resolve.types[type_id];
-        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
-
-        match &typedef.kind {
-            TypeDefKind::Record(record) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-
-                writeln!(out, "        #[repr(C)]")?;
-                writeln!(out, "        #[derive(Debug)]")?;
-                writeln!(out, "        pub struct {c_name} {{")?;
-                for field in &record.fields {
-                    let field_name = names::to_rust_ident(&field.name);
-                    let field_type = self.type_to_c_rust(&field.ty);
-                    writeln!(out, "            pub {field_name}: {field_type},")?;
-                }
-                writeln!(out, "        }}")?;
-                writeln!(out)?;
+        // World-level imports live directly in `imports`, interface imports
+        // in a submodule per interface
+        let mut interfaces: Vec<&str> = Vec::new();
+        for (ef, _) in &imports {
+            if !interfaces.contains(&ef.interface_name.as_str()) {
+                interfaces.push(&ef.interface_name);
             }
-
-            TypeDefKind::Variant(variant) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                let tag_name = format!("{c_name}Tag");
-
-                writeln!(out, "        #[repr(u32)]")?;
-                writeln!(out, "        #[derive(Debug, Clone, Copy, PartialEq, Eq)]")?;
-                writeln!(out, "        pub enum {tag_name} {{")?;
-                for (i, case) in variant.cases.iter().enumerate() {
-                    let variant_name = names::to_rust_type(&case.name);
-                    writeln!(out, "            {variant_name} = {i},")?;
-                }
-                writeln!(out, "        }}")?;
+        }
+        for iface in interfaces {
+            let (indent, module_path) = if iface.is_empty() {
+                ("    ", "")
+            } else {
                 writeln!(out)?;
-
-                for case in &variant.cases {
-                    if let Some(ty) = &case.ty {
-                        let payload_name =
-                            format!("{c_name}{}Payload", names::to_rust_type(&case.name));
-                        writeln!(out, "        #[repr(C)]")?;
-                        writeln!(out, "        #[derive(Debug)]")?;
-                        writeln!(out, "        pub struct {payload_name} {{")?;
-                        writeln!(out, "            pub value: {},", self.type_to_c_rust(ty))?;
-                        writeln!(out, "        }}")?;
-                        writeln!(out)?;
-                    }
+                writeln!(out, "    pub mod {} {{", names::to_rust_ident(iface))?;
+                ("        ", "super::")
+            };
+            let mut first = true;
+            for (ef, sig) in imports.iter().filter(|(ef, _)| ef.interface_name == iface) {
+                if !first || iface.is_empty() {
+                    writeln!(out)?;
                 }
+                first = false;
+                self.generate_import_wrapper(out, ef, sig, indent, module_path)?;
+            }
+            if !iface.is_empty() {
+                writeln!(out, "    }}")?;
+            }
+        }
 
-                writeln!(out, "        #[repr(C)]")?;
-                writeln!(out, "        #[derive(Debug)]")?;
-                writeln!(out, "        pub struct {c_name} {{")?;
-                writeln!(out, "            pub tag: {tag_name},")?;
-                for case in &variant.cases {
-                    let field_name = names::to_rust_ident(&case.name);
-                    if case.ty.is_some() {
-                        let payload_name =
-                            format!("{c_name}{}Payload", names::to_rust_type(&case.name));
-                        writeln!(out, "            pub {field_name}: *mut {payload_name},")?;
+        writeln!(out, "}}")?;
+        Ok(())
+    }
+
+    /// Generate the safe Rust wrapper calling one imported function.
+    fn generate_import_wrapper(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        sig: &ImportSignature,
+        indent: &str,
+        module_path: &str,
+    ) -> std::fmt::Result {
+        let c_name = ef.c_import_name(&self.config.c_prefix);
+        let params: Vec<String> = sig
+            .params
+            .iter()
+            .map(|(name, value)| {
+                let ty = match value {
+                    ImportValue::Scalar(ty) => self.type_to_idiomatic(ty),
+                    ImportValue::String => "&str".to_string(),
+                    ImportValue::Bytes => "&[u8]".to_string(),
+                    ImportValue::Own(id) => format!("T::{}", self.resource_type_name(*id)),
+                    ImportValue::Borrow(id) => format!("&T::{}", self.resource_type_name(*id)),
+                };
+                format!("{}: {ty}", names::to_rust_ident(name))
+            })
+            .collect();
+        let mut args: Vec<String> = sig
+            .params
+            .iter()
+            .map(|(name, value)| {
+                let name = names::to_rust_ident(name);
+                match value {
+                    ImportValue::Scalar(_) => name,
+                    ImportValue::String | ImportValue::Bytes => format!(
+                        "witffi_types::FfiByteSlice {{ ptr: {name}.as_ptr(), len: {name}.len() }}"
+                    ),
+                    // Owned resources are boxed like exported handles; borrows
+                    // point at the caller's value, which outlives the call
+                    ImportValue::Own(_) => {
+                        format!("{} as *mut std::ffi::c_void", self.box_into_raw(&name))
                     }
+                    ImportValue::Borrow(id) => format!(
+                        "{name} as *const T::{} as *mut std::ffi::c_void",
+                        self.resource_type_name(*id)
+                    ),
                 }
-                writeln!(out, "        }}")?;
-                writeln!(out)?;
+            })
+            .collect();
+        let lifted_type = |value: ImportValue| match value {
+            ImportValue::Scalar(ty) => self.type_to_idiomatic(&ty),
+            ImportValue::String => "String".to_string(),
+            ImportValue::Bytes => "Vec<u8>".to_string(),
+            ImportValue::Own(id) | ImportValue::Borrow(id) => {
+                format!("T::{}", self.resource_type_name(id))
             }
+        };
+        // Resources are the implementation's associated types, so functions
+        // passing them are generic over the implementation
+        let returned = match sig.result {
+            ImportResult::Value(value) | ImportResult::Fallible(Some(value)) => Some(value),
+            ImportResult::None | ImportResult::Fallible(None) => None,
+        };
+        let generics = if sig
+            .params
+            .iter()
+            .map(|(_, value)| *value)
+            .chain(returned)
+            .any(|value| matches!(value, ImportValue::Own(_) | ImportValue::Borrow(_)))
+        {
+            let world = &self.resolve.worlds[self.world_id];
+            format!(
+                "<T: super::{module_path}{}>",
+                names::to_rust_type(&world.name)
+            )
+        } else {
+            String::new()
+        };
+        let ret = match sig.result {
+            ImportResult::None => String::new(),
+            ImportResult::Value(value) => format!(" -> {}", lifted_type(value)),
+            ImportResult::Fallible(ok) => format!(
+                " -> Result<{}, String>",
+                ok.map(lifted_type).unwrap_or_else(|| "()".to_string())
+            ),
+        };
 
-            TypeDefKind::Enum(e) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                writeln!(out, "        #[repr(u32)]")?;
-                writeln!(out, "        #[derive(Debug, Clone, Copy, PartialEq, Eq)]")?;
-                writeln!(out, "        pub enum {c_name} {{")?;
-                for (i, case) in e.cases.iter().enumerate() {
-                    let case_name = names::to_rust_type(&case.name);
-                    writeln!(out, "            {case_name} = {i},")?;
+        if let Some(docs) = &ef.function.docs.contents {
+            for line in docs.trim().lines().map(str::trim_end) {
+                if line.is_empty() {
+                    writeln!(out, "{indent}///")?;
+                } else {
+                    writeln!(out, "{indent}/// {line}")?;
                 }
-                writeln!(out, "        }}")?;
-                writeln!(out)?;
             }
-
-            TypeDefKind::Flags(flags) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                writeln!(out, "        pub type {c_name} = u32;")?;
-                for (i, flag) in flags.flags.iter().enumerate() {
-                    let const_name = names::to_c_enum_variant(wit_name, &flag.name);
-                    writeln!(out, "        pub const {const_name}: {c_name} = 1 << {i};")?;
-                }
-                writeln!(out)?;
+        }
+        writeln!(
+            out,
+            "{indent}pub fn {}{generics}({}){ret} {{",
+            names::to_rust_ident(&ef.function_name),
+            params.join(", ")
+        )?;
+        // Checked before anything returned is lifted, since an import that
+        // panicked returns zero values
+        let check_panic = format!("{indent}    {module_path}check_panic(\"{c_name}\");");
+        match sig.result {
+            ImportResult::None => {
+                writeln!(
+                    out,
+                    "{indent}    unsafe {{ {module_path}{c_name}({}) }};",
+                    args.join(", ")
+                )?;
+                writeln!(out, "{check_panic}")?;
             }
-
-            TypeDefKind::Type(inner) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                let inner_ty = self.type_to_c_rust(inner);
-                if c_name != inner_ty {
-                    writeln!(out, "        pub type {c_name} = {inner_ty};")?;
-                    writeln!(out)?;
+            ImportResult::Value(ImportValue::Scalar(_)) => {
+                writeln!(
+                    out,
+                    "{indent}    let result = unsafe {{ {module_path}{c_name}({}) }};",
+                    args.join(", ")
+                )?;
+                writeln!(out, "{check_panic}")?;
+                writeln!(out, "{indent}    result")?;
+            }
+            ImportResult::Value(value) => {
+                writeln!(
+                    out,
+                    "{indent}    let result = unsafe {{ {module_path}{c_name}({}) }};",
+                    args.join(", ")
+                )?;
+                writeln!(out, "{check_panic}")?;
+                writeln!(
+                    out,
+                    "{indent}    {}",
+                    self.import_lift_expr(value, "result", module_path)
+                )?;
+            }
+            ImportResult::Fallible(ok) => {
+                if let Some(ok) = ok {
+                    let default = match ok {
+                        ImportValue::Scalar(ty) => {
+                            format!("{}::default()", self.type_to_idiomatic(&ty))
+                        }
+                        ImportValue::String | ImportValue::Bytes => {
+                            "witffi_types::FfiByteBuffer::empty()".to_string()
+                        }
+                        ImportValue::Own(_) | ImportValue::Borrow(_) => {
+                            "std::ptr::null_mut()".to_string()
+                        }
+                    };
+                    writeln!(out, "{indent}    let mut ok_out = {default};")?;
+                    args.push("&mut ok_out".to_string());
                 }
+                writeln!(
+                    out,
+                    "{indent}    let mut err_out = witffi_types::FfiByteBuffer::empty();"
+                )?;
+                args.push("&mut err_out".to_string());
+                writeln!(
+                    out,
+                    "{indent}    let success = unsafe {{ {module_path}{c_name}({}) }};",
+                    args.join(", ")
+                )?;
+                writeln!(out, "{check_panic}")?;
+                writeln!(out, "{indent}    if success {{")?;
+                let value = ok
+                    .map(|ok| self.import_lift_expr(ok, "ok_out", module_path))
+                    .unwrap_or_else(|| "()".to_string());
+                writeln!(out, "{indent}        Ok({value})")?;
+                writeln!(out, "{indent}    }} else {{")?;
+                writeln!(
+                    out,
+                    "{indent}        Err({})",
+                    self.import_lift_expr(ImportValue::String, "err_out", module_path)
+                )?;
+                writeln!(out, "{indent}    }}")?;
             }
-
-            _ => {}
         }
-
+        writeln!(out, "{indent}}}")?;
         Ok(())
     }
 
-    fn generate_ffi_conversions(&self, out: &mut String) -> std::fmt::Result {
-        writeln!(
-            out,
-            "        // ---- Conversions: idiomatic -> repr(C) ----"
-        )?;
-        writeln!(out)?;
+    // ---- Resource helpers ----
 
-        let reachable = self.collect_reachable_types();
-        for type_id in &reachable {
-            let typedef = &self.resolve.types[*type_id];
-            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+    /// Collect all resources reachable from the world's exports.
+    fn exported_resources(&self) -> Vec<TypeId> {
+        self.collect_reachable_types()
+            .into_iter()
+            .filter(|id| matches!(self.resolve.types[*id].kind, TypeDefKind::Resource))
+            .collect()
+    }
 
-            match &typedef.kind {
-                TypeDefKind::Record(record) => {
-                    let rust_name = names::to_rust_type(wit_name);
-                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                    let fn_name = format!("{}_to_ffi", wit_name.to_snake_case());
+    /// The associated type name backing a resource (e.g. `Counter`).
+    fn resource_type_name(&self, resource_id: TypeId) -> String {
+        let resource_id = witffi_core::dealias(self.resolve, resource_id);
+        let name = self.resolve.types[resource_id]
+            .name
+            .as_deref()
+            .unwrap_or("resource");
+        names::to_rust_type(name)
+    }
 
-                    writeln!(out, "        fn {fn_name}(v: {rust_name}) -> {c_name} {{")?;
-                    writeln!(out, "            {c_name} {{")?;
-                    for field in &record.fields {
-                        let field_name = names::to_rust_ident(&field.name);
-                        let conversion =
-                            self.generate_to_ffi_expr(&field.ty, &format!("v.{field_name}"));
-                        writeln!(out, "                {field_name}: {conversion},")?;
-                    }
-                    writeln!(out, "            }}")?;
-                    writeln!(out, "        }}")?;
-                    writeln!(out)?;
-                }
+    /// The fully-qualified associated type path used inside the FFI macro,
+    /// e.g. `<$impl_type as Eip681>::Counter`.
+    fn resource_impl_path(&self, resource_id: TypeId) -> String {
+        let world = &self.resolve.worlds[self.world_id];
+        let trait_name = names::to_rust_type(&world.name);
+        format!(
+            "<$impl_type as {trait_name}>::{}",
+            self.resource_type_name(resource_id)
+        )
+    }
 
-                TypeDefKind::Variant(variant) => {
-                    let rust_name = names::to_rust_type(wit_name);
-                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                    let tag_name = format!("{c_name}Tag");
-                    let fn_name = format!("{}_to_ffi", wit_name.to_snake_case());
+    // ---- Tuple helpers ----
 
-                    writeln!(out, "        fn {fn_name}(v: {rust_name}) -> {c_name} {{")?;
-                    writeln!(out, "            match v {{")?;
+    /// The repr(C) struct name for a tuple, e.g. `FfiTuple2StringU64`.
+    fn tuple_c_name(&self, tuple: &wit_parser::Tuple) -> String {
+        format!(
+            "{}{}",
+            self.config.c_type_prefix,
+            witffi_core::tuple_shape_name(self.resolve, tuple)
+        )
+    }
 
-                    // We need to build the match arms. Each arm sets the tag
-                    // and assigns the active payload pointer; the others are null.
-                    for (i, case) in variant.cases.iter().enumerate() {
-                        let variant_name = names::to_rust_type(&case.name);
-                        let tag_variant = format!("{tag_name}::{variant_name}");
+    /// The snake_case stem used for a tuple's helper functions, e.g.
+    /// `tuple2_string_u64`.
+    fn tuple_fn_stem(&self, tuple: &wit_parser::Tuple) -> String {
+        witffi_core::tuple_shape_name(self.resolve, tuple).to_snake_case()
+    }
 
-                        if let Some(ty) = &case.ty {
-                            let payload_name =
-                                format!("{c_name}{}Payload", names::to_rust_type(&case.name));
-                            let conversion = self.generate_to_ffi_expr(ty, "inner");
+    /// The idiomatic Rust tuple type of a tuple, even one that is a 128-bit
+    /// integer.
+    fn tuple_to_idiomatic(&self, tuple: &wit_parser::Tuple) -> String {
+        let types: Vec<String> = tuple
+            .types
+            .iter()
+            .map(|t| self.type_to_idiomatic(t))
+            .collect();
+        if types.len() == 1 {
+            format!("({},)", types[0])
+        } else {
+            format!("({})", types.join(", "))
+        }
+    }
 
-                            writeln!(
-                                out,
-                                "                {rust_name}::{variant_name}(inner) => {c_name} {{"
-                            )?;
-                            writeln!(out, "                    tag: {tag_variant},")?;
+    /// Whether a tuple needs a separate borrowed struct (e.g.
+    /// `FfiTuple2StringU64Input`) when passed inside a list or option, i.e.
+    /// whether any element is passed differently as input.
+    fn tuple_has_input_struct(&self, tuple: &wit_parser::Tuple) -> bool {
+        tuple
+            .types
+            .iter()
+            .any(|t| self.type_to_ffi_input(t) != self.type_to_c_rust(t))
+    }
 
-                            // Set all case fields
-                            for (j, other_case) in variant.cases.iter().enumerate() {
-                                if other_case.ty.is_none() {
-                                    continue;
-                                }
-                                let other_field = names::to_rust_ident(&other_case.name);
-                                if j == i {
-                                    writeln!(
-                                        out,
-                                        "                    {other_field}: Box::into_raw(Box::new({payload_name} {{ value: {conversion} }})),"
-                                    )?;
-                                } else {
-                                    writeln!(
-                                        out,
-                                        "                    {other_field}: std::ptr::null_mut(),"
-                                    )?;
-                                }
-                            }
+    // ---- Fixed-length list helpers ----
 
-                            writeln!(out, "                }},")?;
+    /// The repr(C) struct name for a fixed-length list, e.g. `FfiArray4U8`.
+    ///
+    /// The struct holds the elements inline (`elems: [T; N]`), matching the
+    /// canonical ABI layout of `list<T, N>`.
+    fn array_c_name(&self, elem: &Type, len: u32) -> String {
+        format!(
+            "{}{}",
+            self.config.c_type_prefix,
+            witffi_core::fixed_list_shape_name(self.resolve, elem, len)
+        )
+    }
+
+    /// The snake_case stem used for a fixed-length list's helper functions,
+    /// e.g. `array4_u8`.
+    fn array_fn_stem(&self, elem: &Type, len: u32) -> String {
+        witffi_core::fixed_list_shape_name(self.resolve, elem, len).to_snake_case()
+    }
+
+    // ---- List and nested result helpers ----
+
+    /// The C struct name for a typedef generated once per shape, e.g.
+    /// `FfiListPoint` for `list<point>` or `FfiResultU32String` for a nested
+    /// `result<u32, string>`.
+    fn shape_c_name(&self, type_id: TypeId) -> String {
+        let shape =
+            witffi_core::structural_shape_name(self.resolve, &self.resolve.types[type_id].kind)
+                .unwrap_or_else(|| "Anonymous".to_string());
+        format!("{}{shape}", self.config.c_type_prefix)
+    }
+
+    /// The snake_case stem used for a shape's helper functions, e.g.
+    /// `list_point`.
+    fn shape_fn_stem(&self, type_id: TypeId) -> String {
+        witffi_core::structural_shape_name(self.resolve, &self.resolve.types[type_id].kind)
+            .unwrap_or_else(|| "anonymous".to_string())
+            .to_snake_case()
+    }
+
+    /// Whether a result typedef is nested inside another type (and so is
+    /// passed as a repr(C) struct) rather than returned directly.
+    fn is_nested_result(&self, type_id: TypeId) -> bool {
+        let Some(shape) =
+            witffi_core::structural_shape_name(self.resolve, &self.resolve.types[type_id].kind)
+        else {
+            return false;
+        };
+        witffi_core::nested_result_shapes(
+            self.resolve,
+            self.world_id,
+            &self.collect_reachable_types(),
+        )
+        .contains(&shape)
+    }
+
+    /// Flatten a parameter into its C-ABI parameters.
+    ///
+    /// Tuples and fixed-length lists are passed element-wise (`p_0`, `p_1`,
+    /// ...) so that string and list elements can be borrowed as
+    /// `FfiByteSlice`s like any other parameter; everything else is passed as
+    /// a single parameter.
+    fn flatten_param(&self, name: &str, ty: &Type, out: &mut Vec<(String, Type)>) {
+        if let Type::Id(id) = ty {
+            match &self.resolve.types[*id].kind {
+                TypeDefKind::Tuple(tuple) => {
+                    for (i, elem) in tuple.types.iter().enumerate() {
+                        self.flatten_param(&format!("{name}_{i}"), elem, out);
+                    }
+                    return;
+                }
+                TypeDefKind::FixedLengthList(elem, len) => {
+                    for i in 0..*len {
+                        self.flatten_param(&format!("{name}_{i}"), elem, out);
+                    }
+                    return;
+                }
+                TypeDefKind::Type(aliased) => return self.flatten_param(name, aliased, out),
+                _ => {}
+            }
+        }
+        out.push((name.to_string(), *ty));
+    }
+
+    /// Flatten all of a function's parameters into C-ABI parameters.
+    fn flat_params(&self, ef: &ExportedFunction) -> Vec<(String, Type)> {
+        let mut flat = Vec::new();
+        for p in &ef.function.params {
+            self.flatten_param(&names::to_rust_ident(&p.name), &p.ty, &mut flat);
+        }
+        flat
+    }
+
+    // ---- Variant payload helpers ----
+
+    /// Whether a value of this type owns boxed variant payloads, either
+    /// directly or through records and tuples held by value.
+    ///
+    /// Options and lists are not followed: their allocations are released by
+    /// the consumer while converting.
+    fn has_boxed_payloads(&self, ty: &Type) -> bool {
+        match ty {
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::Variant(variant) => variant.cases.iter().any(|c| c.ty.is_some()),
+                TypeDefKind::Record(record) => {
+                    record.fields.iter().any(|f| self.has_boxed_payloads(&f.ty))
+                }
+                TypeDefKind::Tuple(tuple) => tuple.types.iter().any(|t| self.has_boxed_payloads(t)),
+                TypeDefKind::FixedLengthList(elem, _) => self.has_boxed_payloads(elem),
+                TypeDefKind::Type(aliased) => self.has_boxed_payloads(aliased),
+                _ => false,
+            },
+            _ => false,
+        }
+    }
+
+    /// The name of the recursive release function for a record, variant,
+    /// tuple or fixed-length list, e.g. `shape_release`.
+    fn release_fn_name(&self, type_id: TypeId) -> String {
+        let typedef = &self.resolve.types[type_id];
+        match &typedef.kind {
+            TypeDefKind::Tuple(tuple) => format!("{}_release", self.tuple_fn_stem(tuple)),
+            TypeDefKind::FixedLengthList(elem, len) => {
+                format!("{}_release", self.array_fn_stem(elem, *len))
+            }
+            _ => {
+                let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                format!("{}_release", wit_name.to_snake_case())
+            }
+        }
+    }
+
+    /// A statement releasing the boxed payloads owned by `expr`, if any.
+    fn release_stmt(&self, ty: &Type, expr: &str) -> Option<String> {
+        if !self.has_boxed_payloads(ty) {
+            return None;
+        }
+        match ty {
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::Type(aliased) => self.release_stmt(aliased, expr),
+                _ => Some(format!("{}(&{expr});", self.release_fn_name(*id))),
+            },
+            _ => None,
+        }
+    }
+
+    /// Generate recursive release functions that free every boxed variant
+    /// payload reachable from a value, innermost first.
+    fn generate_release_functions(&self, out: &mut String) -> std::fmt::Result {
+        let reachable = self.collect_reachable_types();
+        for type_id in &reachable {
+            let ty = Type::Id(*type_id);
+            if !self.has_boxed_payloads(&ty) {
+                continue;
+            }
+            let typedef = &self.resolve.types[*type_id];
+            let c_name = match &typedef.kind {
+                TypeDefKind::Tuple(tuple) => self.tuple_c_name(tuple),
+                TypeDefKind::FixedLengthList(elem, len) => self.array_c_name(elem, *len),
+                TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
+                    let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                    names::to_c_type(&self.config.c_type_prefix, wit_name)
+                }
+                _ => continue,
+            };
+            let fn_name = self.release_fn_name(*type_id);
+
+            if matches!(typedef.kind, TypeDefKind::Tuple(_)) {
+                // Unused when the tuple only appears as an inline variant payload
+                writeln!(out, "        #[allow(dead_code)]")?;
+            }
+            writeln!(out, "        fn {fn_name}(v: &{c_name}) {{")?;
+            match &typedef.kind {
+                TypeDefKind::Variant(variant) => {
+                    for case in &variant.cases {
+                        let Some(case_ty) = &case.ty else { continue };
+                        let field_name = names::to_rust_ident(&case.name);
+                        writeln!(out, "            if !v.{field_name}.is_null() {{")?;
+                        let stmts: Vec<String> =
+                            match witffi_core::payload_fields(self.resolve, case_ty) {
+                                Some(fields) => fields
+                                    .iter()
+                                    .enumerate()
+                                    .filter_map(|(i, ty)| {
+                                        self.release_stmt(ty, &format!("payload.f{i}"))
+                                    })
+                                    .collect(),
+                                None => self
+                                    .release_stmt(case_ty, "payload.value")
+                                    .into_iter()
+                                    .collect(),
+                            };
+                        if stmts.is_empty() {
+                            writeln!(
+                                out,
+                                "                drop(unsafe {{ {} }});",
+                                self.box_from_raw(&format!("v.{field_name}"))
+                            )?;
                         } else {
                             writeln!(
                                 out,
-                                "                {rust_name}::{variant_name} => {c_name} {{"
+                                "                let payload = unsafe {{ {} }};",
+                                self.box_from_raw(&format!("v.{field_name}"))
                             )?;
-                            writeln!(out, "                    tag: {tag_variant},")?;
-                            for other_case in &variant.cases {
-                                if other_case.ty.is_some() {
-                                    let other_field = names::to_rust_ident(&other_case.name);
-                                    writeln!(
-                                        out,
-                                        "                    {other_field}: std::ptr::null_mut(),"
-                                    )?;
-                                }
+                            for stmt in stmts {
+                                writeln!(out, "                {stmt}")?;
                             }
-                            writeln!(out, "                }},")?;
                         }
+                        writeln!(out, "            }}")?;
+                    }
+                }
+                TypeDefKind::Record(record) => {
+                    for field in &record.fields {
+                        let field_name = names::to_rust_ident(&field.name);
+                        if let Some(stmt) = self.release_stmt(&field.ty, &format!("v.{field_name}"))
+                        {
+                            writeln!(out, "            {stmt}")?;
+                        }
+                    }
+                }
+                TypeDefKind::Tuple(tuple) => {
+                    for (i, elem) in tuple.types.iter().enumerate() {
+                        if let Some(stmt) = self.release_stmt(elem, &format!("v.f{i}")) {
+                            writeln!(out, "            {stmt}")?;
+                        }
+                    }
+                }
+                TypeDefKind::FixedLengthList(elem, _) => {
+                    if let Some(stmt) = self.release_stmt(elem, "*e") {
+                        writeln!(out, "            for e in v.elems.iter() {{")?;
+                        writeln!(out, "                {stmt}")?;
+                        writeln!(out, "            }}")?;
                     }
-
-                    writeln!(out, "            }}")?;
-                    writeln!(out, "        }}")?;
-                    writeln!(out)?;
                 }
-
                 _ => {}
             }
+            writeln!(out, "        }}")?;
+            writeln!(out)?;
         }
 
         Ok(())
     }
 
-    /// Generate a Rust expression that converts an idiomatic value to its FFI representation.
-    fn generate_to_ffi_expr(&self, ty: &Type, expr: &str) -> String {
+    /// Write the body of a `{prefix}_free_*` function for a boxed value,
+    /// releasing nested variant payloads before the box itself.
+    fn generate_free_ptr_body(&self, out: &mut String, type_id: TypeId) -> std::fmt::Result {
+        match self.release_stmt(&Type::Id(type_id), "*v") {
+            Some(stmt) => {
+                writeln!(out, "            if !ptr.is_null() {{")?;
+                writeln!(
+                    out,
+                    "                let v = unsafe {{ {} }};",
+                    self.box_from_raw("ptr")
+                )?;
+                writeln!(out, "                {stmt}")?;
+                writeln!(out, "            }}")?;
+            }
+            None => writeln!(out, "            unsafe {{ witffi_types::free_ptr(ptr) }};")?,
+        }
+        Ok(())
+    }
+
+    /// Check if a type is a resource handle (`own<T>` or `borrow<T>`).
+    fn is_handle(&self, ty: &Type) -> bool {
         match ty {
-            Type::String => format!("witffi_types::FfiByteBuffer::from_string({expr})"),
-            Type::Bool
-            | Type::U8
-            | Type::U16
-            | Type::U32
-            | Type::U64
-            | Type::S8
-            | Type::S16
-            | Type::S32
-            | Type::S64
-            | Type::F32
-            | Type::F64 => expr.to_string(),
-            Type::Char => format!("{expr} as u32"),
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::Handle(_) => true,
+                TypeDefKind::Type(aliased) => self.is_handle(aliased),
+                _ => false,
+            },
+            _ => false,
+        }
+    }
+
+    // ---- C-ABI type mapping (used by witffi_register_ffi! and C header) ----
+
+    /// Map a WIT type to its Rust FFI-safe `#[repr(C)]` representation.
+    fn type_to_c_rust(&self, ty: &Type) -> String {
+        match ty {
+            Type::Bool => "bool".to_string(),
+            Type::U8 => "u8".to_string(),
+            Type::U16 => "u16".to_string(),
+            Type::U32 => "u32".to_string(),
+            Type::U64 => "u64".to_string(),
+            Type::S8 => "i8".to_string(),
+            Type::S16 => "i16".to_string(),
+            Type::S32 => "i32".to_string(),
+            Type::S64 => "i64".to_string(),
+            Type::F32 => "f32".to_string(),
+            Type::F64 => "f64".to_string(),
+            Type::Char => "u32".to_string(),
+            Type::String => "witffi_types::FfiByteBuffer".to_string(),
             Type::Id(id) => {
                 let typedef = &self.resolve.types[*id];
                 match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        format!("witffi_types::FfiByteBuffer::from_vec({expr})")
-                    }
-                    TypeDefKind::List(_) => {
-                        // General list serialization — placeholder
-                        format!(
-                            "witffi_types::FfiByteBuffer::from_vec({expr}.into()) /* TODO: list serialization */"
-                        )
-                    }
+                    TypeDefKind::List(inner) => match inner {
+                        Type::U8 => "witffi_types::FfiByteBuffer".to_string(),
+                        _ => format!("witffi_types::FfiList<{}>", self.type_to_c_rust(inner)),
+                    },
                     TypeDefKind::Option(inner) => {
-                        let inner_expr = self.generate_to_ffi_expr(inner, "v");
-                        format!("witffi_types::option_to_ptr({expr}.map(|v| {inner_expr}))")
+                        format!("*mut {}", self.type_to_c_rust(inner))
                     }
-                    TypeDefKind::Type(aliased) => self.generate_to_ffi_expr(aliased, expr),
-                    TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
-                        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
-                        let fn_name = format!("{}_to_ffi", wit_name.to_snake_case());
-                        format!("{fn_name}({expr})")
+                    TypeDefKind::Result(_) => self.shape_c_name(*id),
+                    TypeDefKind::Tuple(tuple) => self.tuple_c_name(tuple),
+                    TypeDefKind::FixedLengthList(elem, len) => self.array_c_name(elem, *len),
+                    TypeDefKind::Type(aliased) => self.type_to_c_rust(aliased),
+                    TypeDefKind::Handle(_) => "*mut std::ffi::c_void".to_string(),
+                    _ => {
+                        let name = typedef.name.as_deref().unwrap_or("Anonymous");
+                        names::to_c_type(&self.config.c_type_prefix, name)
                     }
-                    TypeDefKind::Enum(_) | TypeDefKind::Flags(_) => expr.to_string(),
-                    _ => expr.to_string(),
                 }
             }
-            Type::ErrorContext => format!("{expr} as u32"),
+            Type::ErrorContext => "witffi_types::FfiErrorContext".to_string(),
         }
     }
 
-    fn generate_ffi_free_functions(&self, out: &mut String) -> std::fmt::Result {
-        let prefix = self.config.c_prefix.to_snake_case();
-
-        writeln!(out, "        // ---- Free functions ----")?;
-        writeln!(out)?;
-        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
-        writeln!(out, "        #[unsafe(no_mangle)]")?;
-        writeln!(
-            out,
-            "        pub unsafe extern \"C\" fn {prefix}_free_byte_buffer(buf: witffi_types::FfiByteBuffer) {{"
-        )?;
-        writeln!(out, "            unsafe {{ buf.free() }};")?;
-        writeln!(out, "        }}")?;
-        writeln!(out)?;
-
-        let reachable = self.collect_reachable_types();
-        for type_id in &reachable {
-            let typedef = &self.resolve.types[*type_id];
-            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
-
-            match &typedef.kind {
-                TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
-                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                    let free_name =
-                        names::to_c_func(&self.config.c_prefix, &format!("free-{wit_name}"));
-
-                    writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
-                    writeln!(out, "        #[unsafe(no_mangle)]")?;
-                    writeln!(
-                        out,
-                        "        pub unsafe extern \"C\" fn {free_name}(ptr: *mut {c_name}) {{"
-                    )?;
-                    writeln!(out, "            unsafe {{ witffi_types::free_ptr(ptr) }};")?;
-                    writeln!(out, "        }}")?;
-                    writeln!(out)?;
+    /// Map a WIT type to its FFI-safe input representation.
+    ///
+    /// Input types differ from output types: strings and byte slices use
+    /// `FfiByteSlice` (const pointer, caller-owned) rather than `FfiByteBuffer`
+    /// (mutable pointer, callee-owned).
+    fn type_to_ffi_input(&self, ty: &Type) -> String {
+        match ty {
+            Type::String => "witffi_types::FfiByteSlice".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => "witffi_types::FfiByteSlice".to_string(),
+                    TypeDefKind::Stream(Some(Type::U8)) => {
+                        "witffi_types::FfiByteSource".to_string()
+                    }
+                    TypeDefKind::List(inner) => {
+                        format!("witffi_types::FfiSlice<{}>", self.type_to_ffi_input(inner))
+                    }
+                    TypeDefKind::Option(inner) => {
+                        format!("*const {}", self.type_to_ffi_input(inner))
+                    }
+                    TypeDefKind::Tuple(tuple)
+                        if witffi_core::wide_int(self.resolve, ty).is_none()
+                            && self.tuple_has_input_struct(tuple) =>
+                    {
+                        format!("{}Input", self.tuple_c_name(tuple))
+                    }
+                    TypeDefKind::Type(aliased) => self.type_to_ffi_input(aliased),
+                    // Validated by `{enum}_from_ffi`
+                    TypeDefKind::Enum(_) => "u32".to_string(),
+                    _ => self.type_to_c_rust(ty),
                 }
-                _ => {}
             }
+            Type::ErrorContext => "witffi_types::FfiErrorContextInput".to_string(),
+            _ => self.type_to_c_rust(ty),
         }
-
-        Ok(())
     }
 
-    fn generate_ffi_error_functions(&self, out: &mut String, prefix: &str) -> std::fmt::Result {
-        writeln!(out, "        #[unsafe(no_mangle)]")?;
+    // ---- witffi_register_ffi! macro generation ----
+
+    fn generate_register_ffi_macro(&self, out: &mut String) -> std::fmt::Result {
+        let world = &self.resolve.worlds[self.world_id];
+        let trait_name = names::to_rust_type(&world.name);
+        let prefix = self.config.c_prefix.to_snake_case();
+
+        writeln!(out, "// ---- C-ABI Registration macro ----")?;
+        writeln!(out)?;
         writeln!(
             out,
-            "        pub extern \"C\" fn {prefix}_last_error_length() -> i32 {{"
+            "/// Register a concrete type for C-ABI FFI (Swift, Go, C consumers)."
         )?;
-        writeln!(out, "            LAST_ERROR.with(|e| {{")?;
+        writeln!(out, "///")?;
         writeln!(
             out,
-            "                e.borrow().as_ref().map(|s| s.len() as i32 + 1).unwrap_or(0)"
+            "/// This macro generates `#[repr(C)]` shadow types, conversion logic,"
         )?;
-        writeln!(out, "            }})")?;
-        writeln!(out, "        }}")?;
-        writeln!(out)?;
-
-        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
-        writeln!(out, "        #[unsafe(no_mangle)]")?;
         writeln!(
             out,
-            "        pub unsafe extern \"C\" fn {prefix}_error_message_utf8(buf: *mut std::os::raw::c_char, len: i32) -> i32 {{"
+            "/// `extern \"C\"` wrapper functions, error handling, and free functions."
         )?;
-        writeln!(out, "            LAST_ERROR.with(|e| {{")?;
-        writeln!(out, "                match e.borrow().as_ref() {{")?;
-        writeln!(out, "                    Some(msg) => {{")?;
-        writeln!(
-            out,
-            "                        let c_msg = match std::ffi::CString::new(msg.as_str()) {{"
-        )?;
-        writeln!(out, "                            Ok(c) => c,")?;
-        writeln!(out, "                            Err(_) => return -1,")?;
-        writeln!(out, "                        }};")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// # Example")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// ```ignore")?;
+        writeln!(out, "/// struct MyImpl;")?;
+        writeln!(out, "/// impl {trait_name} for MyImpl {{")?;
+        writeln!(out, "///     // ... implement trait methods ...")?;
+        writeln!(out, "/// }}")?;
+        writeln!(out, "/// witffi_register_ffi!(MyImpl);")?;
+        writeln!(out, "/// ```")?;
+        writeln!(out, "#[macro_export]")?;
+        writeln!(out, "macro_rules! witffi_register_ffi {{")?;
+        writeln!(out, "    ($impl_type:ty) => {{")?;
+        writeln!(out)?;
+
+        // Generate repr(C) shadow types inside the macro
+        self.generate_ffi_shadow_types(out)?;
+
+        // Generate conversion functions (idiomatic -> repr(C))
+        self.generate_ffi_conversions(out)?;
+
+        // Every block handed to the caller carries canaries, checked when
+        // it comes back to be freed
+        if self.config.alloc_canaries {
+            writeln!(out, "        #[global_allocator]")?;
+            writeln!(
+                out,
+                "        static WITFFI_ALLOCATOR: witffi_types::canary::CanaryAlloc = witffi_types::canary::CanaryAlloc::new();"
+            )?;
+            writeln!(out)?;
+        }
+
+        // Generate free functions
+        self.generate_ffi_free_functions(out)?;
+
+        // Thread-local error storage
         writeln!(
             out,
-            "                        let bytes = c_msg.as_bytes_with_nul();"
+            "        // Last error message stored for FFI error reporting."
         )?;
+        writeln!(out, "        std::thread_local! {{")?;
         writeln!(
             out,
-            "                        let copy_len = bytes.len().min(len as usize);"
+            "            static LAST_ERROR: std::cell::RefCell<Option<String>> = const {{ std::cell::RefCell::new(None) }};"
         )?;
         writeln!(
             out,
-            "                        unsafe {{ std::ptr::copy_nonoverlapping(bytes.as_ptr(), buf as *mut u8, copy_len) }};"
+            "            // The backtrace (empty unless captured) of a panic that set"
         )?;
-        writeln!(out, "                        copy_len as i32")?;
-        writeln!(out, "                    }}")?;
-        writeln!(out, "                    None => 0,")?;
-        writeln!(out, "                }}")?;
-        writeln!(out, "            }})")?;
-        writeln!(out, "        }}")?;
-        writeln!(out)?;
-
-        writeln!(out, "        #[unsafe(no_mangle)]")?;
         writeln!(
             out,
-            "        pub extern \"C\" fn {prefix}_clear_last_error() {{"
+            "            // LAST_ERROR, until taken with `_take_panic`."
         )?;
         writeln!(
             out,
-            "            LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+            "            static LAST_PANIC: std::cell::RefCell<Option<String>> = const {{ std::cell::RefCell::new(None) }};"
         )?;
         writeln!(out, "        }}")?;
         writeln!(out)?;
 
-        Ok(())
-    }
-
-    fn generate_ffi_extern_function(
-        &self,
-        out: &mut String,
-        ef: &ExportedFunction,
-    ) -> std::fmt::Result {
-        let c_func_name = if ef.interface_name.is_empty() {
-            names::to_c_func(&self.config.c_prefix, &ef.function_name)
-        } else {
-            names::to_c_func(
-                &self.config.c_prefix,
-                &format!("{}-{}", ef.interface_name, ef.function_name),
-            )
-        };
-
-        let trait_method = self.trait_method_name(ef);
-        let result_decomposed = self.decompose_result(&ef.function.result);
-
-        // Build C parameter list (using FFI-safe input types)
-        let c_params: Vec<String> = ef
-            .function
-            .params
-            .iter()
-            .map(|p| {
-                format!(
-                    "{}: {}",
-                    names::to_rust_ident(&p.name),
-                    self.type_to_ffi_input(&p.ty)
-                )
-            })
-            .collect();
-
-        // Determine C return type
-        let c_return = if let Some((ref ok_ty, _)) = result_decomposed {
-            match ok_ty {
-                Some(ty) => format!("*mut {}", self.type_to_c_rust(ty)),
-                None => "bool".to_string(),
-            }
-        } else {
-            match &ef.function.result {
-                Some(ty) => self.type_to_c_rust(ty),
-                None => "()".to_string(),
-            }
-        };
+        // Error handling functions
+        self.generate_ffi_error_functions(out, &prefix)?;
 
-        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
-        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        if self.config.lifecycle {
+            self.generate_ffi_lifecycle_functions(out, &prefix)?;
+        }
 
-        if c_return == "()" {
+        if self.config.log_bridge {
+            writeln!(out, "        #[unsafe(no_mangle)]")?;
             writeln!(
                 out,
-                "        pub unsafe extern \"C\" fn {c_func_name}({}) {{",
-                c_params.join(", ")
+                "        pub extern \"C\" fn {prefix}_set_log_sink(sink: Option<witffi_types::FfiLogSink>, max_level: u8) -> bool {{"
             )?;
-        } else {
             writeln!(
                 out,
-                "        pub unsafe extern \"C\" fn {c_func_name}({}) -> {c_return} {{",
-                c_params.join(", ")
+                "            witffi_types::logging::set_sink(sink, max_level)"
             )?;
+            writeln!(out, "        }}")?;
+            writeln!(out)?;
         }
 
+        // The caller's bindings check that they were generated from the
+        // same world before making any call
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
         writeln!(
             out,
-            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+            "        pub extern \"C\" fn {prefix}_abi_hash() -> u64 {{"
         )?;
-
-        // Convert parameters from FFI types to trait-compatible types
-        for p in &ef.function.params {
-            let c_name = names::to_rust_ident(&p.name);
-            self.generate_param_conversion(out, &c_name, &p.ty, "                ")?;
-        }
-
-        let rust_args: Vec<String> = ef
-            .function
-            .params
-            .iter()
-            .map(|p| format!("{}_rust", names::to_rust_ident(&p.name)))
-            .collect();
-
         writeln!(
             out,
-            "                <$impl_type>::{trait_method}({})",
-            rust_args.join(", ")
+            "            {:#018x}",
+            witffi_core::abi_hash(self.resolve, self.world_id)
         )?;
-        writeln!(out, "            }}));")?;
+        writeln!(out, "        }}")?;
         writeln!(out)?;
 
-        // Handle the result - convert idiomatic return to FFI
-        if let Some((ref ok_ty, _)) = result_decomposed {
-            let has_ok_value = ok_ty.is_some();
+        // Cancellation tokens the caller binds to its calls
+        self.generate_ffi_cancel_functions(out, &prefix)?;
 
-            writeln!(out, "            match result {{")?;
-            writeln!(out, "                Ok(Ok(value)) => {{")?;
-            writeln!(
-                out,
-                "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
-            )?;
-            if has_ok_value {
-                let ok_type = ok_ty.as_ref().unwrap();
-                let conversion = self.generate_to_ffi_expr(ok_type, "value");
-                writeln!(
-                    out,
-                    "                    Box::into_raw(Box::new({conversion}))"
-                )?;
-            } else {
-                writeln!(out, "                    true")?;
-            }
-            writeln!(out, "                }}")?;
-            writeln!(out, "                Ok(Err(e)) => {{")?;
+        // Calls started for `future<T>` results are cancelled and released
+        // through shared functions
+        let funcs = exported_functions(self.resolve, self.world_id);
+        if funcs
+            .iter()
+            .any(|ef| ef.future_value(self.resolve).is_some())
+        {
+            self.generate_ffi_future_functions(out, &prefix)?;
+        }
+
+        // Error contexts created by the caller draw their identities from
+        // the same counter as those created in Rust
+        if funcs.iter().any(|ef| ef.uses_error_context(self.resolve)) {
+            writeln!(out, "        #[unsafe(no_mangle)]")?;
             writeln!(
                 out,
-                "                    LAST_ERROR.with(|e_cell| *e_cell.borrow_mut() = Some(format!(\"{{e}}\")));"
+                "        pub extern \"C\" fn {prefix}_error_context_new_id() -> u64 {{"
             )?;
-            if has_ok_value {
-                writeln!(out, "                    std::ptr::null_mut()")?;
-            } else {
-                writeln!(out, "                    false")?;
+            writeln!(out, "            witffi_types::ErrorContext::new_id()")?;
+            writeln!(out, "        }}")?;
+            writeln!(out)?;
+        }
+
+        // Generate extern "C" fns for each exported function
+        for ef in &funcs {
+            self.generate_ffi_extern_function(out, ef)?;
+            if self.into_result(ef).is_some() {
+                self.generate_ffi_into_function(out, ef)?;
             }
-            writeln!(out, "                }}")?;
-            let panic_ret = if has_ok_value {
-                FfiPanicReturn::NullPtr
-            } else {
-                FfiPanicReturn::Bool
-            };
-            self.generate_panic_arm(out, panic_ret)?;
-            writeln!(out, "            }}")?;
-        } else {
-            writeln!(out, "            match result {{")?;
-            writeln!(out, "                Ok(value) => {{")?;
-            match &ef.function.result {
-                Some(ty) => {
-                    let conversion = self.generate_to_ffi_expr(ty, "value");
-                    writeln!(out, "                    {conversion}")?;
-                }
-                None => {
-                    writeln!(out, "                    value")?;
-                }
+            if self.columns_result(ef).is_some() {
+                self.generate_ffi_columns_function(out, ef)?;
             }
-            writeln!(out, "                }}")?;
-            let panic_ret = match &ef.function.result {
-                Some(ty) => FfiPanicReturn::Type(ty),
-                None => FfiPanicReturn::Bool,
-            };
-            self.generate_panic_arm(out, panic_ret)?;
-            writeln!(out, "            }}")?;
         }
 
-        writeln!(out, "        }}")?;
+        writeln!(out, "    }};")?;
+        writeln!(out, "}}")?;
         writeln!(out)?;
 
-        Ok(())
-    }
-
-    /// Generate the `Err(panic) => { ... }` arm for a C-ABI wrapper's
-    /// `catch_unwind` result.
-    ///
-    /// `error_value` determines the sentinel returned on panic:
-    /// - `FfiPanicReturn::NullPtr` — `std::ptr::null_mut()` (boxed result types)
-    /// - `FfiPanicReturn::Bool` — `false` (result types without an Ok payload)
-    /// - `FfiPanicReturn::Type(ty)` — a type-appropriate empty value
-    fn generate_panic_arm(
-        &self,
-        out: &mut String,
-        error_value: FfiPanicReturn<'_>,
-    ) -> std::fmt::Result {
-        writeln!(out, "                Err(panic) => {{")?;
-        writeln!(
-            out,
-            "                    let msg = if let Some(s) = panic.downcast_ref::<&str>() {{"
-        )?;
-        writeln!(out, "                        s.to_string()")?;
-        writeln!(
-            out,
-            "                    }} else if let Some(s) = panic.downcast_ref::<String>() {{"
-        )?;
-        writeln!(out, "                        s.clone()")?;
-        writeln!(out, "                    }} else {{")?;
-        writeln!(out, "                        \"unknown panic\".to_string()")?;
-        writeln!(out, "                    }};")?;
+        // Backward-compatible alias
         writeln!(
             out,
-            "                    LAST_ERROR.with(|e| *e.borrow_mut() = Some(msg));"
+            "/// Backward-compatible alias for [`witffi_register_ffi!`]."
         )?;
-        let sentinel = match error_value {
-            FfiPanicReturn::NullPtr => "std::ptr::null_mut()".to_string(),
-            FfiPanicReturn::Bool => "false".to_string(),
-            FfiPanicReturn::Type(ty) => self.ffi_error_default(ty),
-        };
-        writeln!(out, "                    {sentinel}")?;
-        writeln!(out, "                }}")?;
+        writeln!(out, "#[macro_export]")?;
+        writeln!(out, "macro_rules! witffi_register {{")?;
+        writeln!(out, "    ($impl_type:ty) => {{")?;
+        writeln!(out, "        witffi_register_ffi!($impl_type);")?;
+        writeln!(out, "    }};")?;
+        writeln!(out, "}}")?;
+
         Ok(())
     }
 
-    /// Produce a default/empty expression suitable for a C-ABI return type on
-    /// error. For pointer types this is `null_mut`, for byte-buffer types an
-    /// empty buffer, and for primitives `Default::default()`.
-    fn ffi_error_default(&self, ty: &Type) -> String {
-        match ty {
-            Type::String => "witffi_types::FfiByteBuffer::from_string(String::new())".to_string(),
-            Type::Bool
-            | Type::U8
-            | Type::U16
-            | Type::U32
-            | Type::U64
-            | Type::S8
-            | Type::S16
-            | Type::S32
-            | Type::S64
-            | Type::F32
-            | Type::F64
-            | Type::Char => "Default::default()".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        "witffi_types::FfiByteBuffer::from_vec(Vec::new())".to_string()
-                    }
-                    TypeDefKind::List(_) => {
-                        "witffi_types::FfiByteBuffer::from_vec(Vec::new())".to_string()
-                    }
-                    TypeDefKind::Type(aliased) => self.ffi_error_default(aliased),
-                    TypeDefKind::Option(_) => "std::ptr::null_mut()".to_string(),
-                    TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
-                        "std::ptr::null_mut()".to_string()
-                    }
-                    _ => "Default::default()".to_string(),
-                }
-            }
-            _ => "Default::default()".to_string(),
-        }
-    }
+    fn generate_ffi_shadow_types(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "        // ---- repr(C) shadow types for C-ABI ----")?;
+        writeln!(out)?;
 
-    fn generate_param_conversion(
-        &self,
-        out: &mut String,
-        c_name: &str,
-        ty: &Type,
-        indent: &str,
-    ) -> std::fmt::Result {
-        match ty {
-            Type::String => {
-                writeln!(
-                    out,
-                    "{indent}let {c_name}_rust = unsafe {{ {c_name}.as_str_unchecked() }};"
-                )?;
-            }
-            Type::Bool
-            | Type::U8
-            | Type::U16
-            | Type::U32
-            | Type::U64
-            | Type::S8
-            | Type::S16
-            | Type::S32
-            | Type::S64
-            | Type::F32
-            | Type::F64 => {
-                writeln!(out, "{indent}let {c_name}_rust = {c_name};")?;
-            }
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        writeln!(
-                            out,
-                            "{indent}let {c_name}_rust = unsafe {{ {c_name}.as_bytes() }};"
-                        )?;
-                    }
-                    TypeDefKind::Type(aliased) => {
-                        self.generate_param_conversion(out, c_name, aliased, indent)?;
-                    }
-                    _ => {
-                        writeln!(out, "{indent}let {c_name}_rust = {c_name};")?;
-                    }
-                }
-            }
-            _ => {
-                writeln!(out, "{indent}let {c_name}_rust = {c_name};")?;
-            }
+        let reachable = self.collect_reachable_types();
+        for type_id in &reachable {
+            self.generate_ffi_shadow_type_def(out, *type_id)?;
         }
+
         Ok(())
     }
 
-    // ---- witffi_register_jni! macro generation ----
+    fn generate_ffi_shadow_type_def(&self, out: &mut String, type_id: TypeId) -> std::fmt::Result {
+        let typedef = &self.resolve.types[type_id];
+        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
 
-    fn generate_register_jni_macro(&self, out: &mut String) -> std::fmt::Result {
-        let world = &self.resolve.worlds[self.world_id];
-        let trait_name = names::to_rust_type(&world.name);
+        match &typedef.kind {
+            TypeDefKind::Record(record) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
 
-        // Derive Kotlin package from WIT package name or config
-        let kotlin_package = self
-            .config
-            .kotlin_package
-            .clone()
-            .unwrap_or_else(|| self.derive_kotlin_package());
-        let jni_class_path = kotlin_package.replace('.', "/");
-        let world_class = names::to_rust_type(&world.name);
+                writeln!(out, "        #[repr(C)]")?;
+                writeln!(out, "        #[derive(Debug)]")?;
+                writeln!(out, "        pub struct {c_name} {{")?;
+                for field in &record.fields {
+                    let field_name = names::to_rust_ident(&field.name);
+                    let field_type = self.type_to_c_rust(&field.ty);
+                    writeln!(out, "            pub {field_name}: {field_type},")?;
+                }
+                writeln!(out, "        }}")?;
+                writeln!(out)?;
 
-        writeln!(out, "// ---- JNI Registration macro ----")?;
-        writeln!(out)?;
-        writeln!(
-            out,
-            "/// Register a concrete type for JNI (Kotlin/Android consumers)."
-        )?;
-        writeln!(out, "///")?;
-        writeln!(
-            out,
-            "/// This macro generates `Java_` JNI entry points, JVM object"
-        )?;
-        writeln!(
-            out,
-            "/// construction helpers, and exception-based error handling."
-        )?;
-        writeln!(out, "///")?;
-        writeln!(
-            out,
-            "/// Requires the `jni` crate as a dependency of the FFI crate."
-        )?;
-        writeln!(out, "///")?;
-        writeln!(out, "/// # Example")?;
-        writeln!(out, "///")?;
-        writeln!(out, "/// ```ignore")?;
-        writeln!(out, "/// struct MyImpl;")?;
-        writeln!(out, "/// impl {trait_name} for MyImpl {{")?;
-        writeln!(out, "///     // ... implement trait methods ...")?;
-        writeln!(out, "/// }}")?;
-        writeln!(out, "/// witffi_register_jni!(MyImpl);")?;
-        writeln!(out, "/// ```")?;
-        writeln!(out, "#[macro_export]")?;
-        writeln!(out, "macro_rules! witffi_register_jni {{")?;
-        writeln!(out, "    ($impl_type:ty) => {{")?;
+                if self.columnar_records().contains(&type_id) {
+                    writeln!(out, "        #[repr(C)]")?;
+                    writeln!(out, "        #[derive(Debug)]")?;
+                    writeln!(out, "        pub struct {c_name}Columns {{")?;
+                    writeln!(out, "            pub len: usize,")?;
+                    for field in &record.fields {
+                        let field_name = names::to_rust_ident(&field.name);
+                        let field_type = self.type_to_c_rust(&field.ty);
+                        writeln!(out, "            pub {field_name}: *mut {field_type},")?;
+                    }
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+            }
 
-        // Generate JNI conversion helpers for each record/variant type
-        self.generate_jni_conversion_helpers(out, &kotlin_package)?;
+            TypeDefKind::Variant(variant) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                let tag_name = format!("{c_name}Tag");
 
-        // Generate JNI entry points
-        let funcs = exported_functions(self.resolve, self.world_id);
-        for ef in &funcs {
-            self.generate_jni_entry_point(out, ef, &jni_class_path, &world_class)?;
-        }
+                writeln!(out, "        #[repr(u32)]")?;
+                writeln!(out, "        #[derive(Debug, Clone, Copy, PartialEq, Eq)]")?;
+                writeln!(out, "        pub enum {tag_name} {{")?;
+                for (i, case) in variant.cases.iter().enumerate() {
+                    let variant_name = names::to_rust_type(&case.name);
+                    writeln!(out, "            {variant_name} = {i},")?;
+                }
+                writeln!(out, "        }}")?;
+                writeln!(out)?;
 
-        // Trim trailing blank line before closing brace
-        if out.ends_with("\n\n") {
-            out.pop();
-        }
-        writeln!(out, "    }};")?;
-        writeln!(out, "}}")?;
+                for case in &variant.cases {
+                    if let Some(ty) = &case.ty {
+                        let payload_name =
+                            format!("{c_name}{}Payload", names::to_rust_type(&case.name));
+                        writeln!(out, "        #[repr(C)]")?;
+                        writeln!(out, "        #[derive(Debug)]")?;
+                        writeln!(out, "        pub struct {payload_name} {{")?;
+                        match witffi_core::payload_fields(self.resolve, ty) {
+                            Some(fields) => {
+                                for (i, field_ty) in fields.iter().enumerate() {
+                                    let field_type = self.type_to_c_rust(field_ty);
+                                    writeln!(out, "            pub f{i}: {field_type},")?;
+                                }
+                            }
+                            None => writeln!(
+                                out,
+                                "            pub value: {},",
+                                self.type_to_c_rust(ty)
+                            )?,
+                        }
+                        writeln!(out, "        }}")?;
+                        writeln!(out)?;
+                    }
+                }
 
-        Ok(())
-    }
+                writeln!(out, "        #[repr(C)]")?;
+                writeln!(out, "        #[derive(Debug)]")?;
+                writeln!(out, "        pub struct {c_name} {{")?;
+                writeln!(out, "            pub tag: {tag_name},")?;
+                for case in &variant.cases {
+                    let field_name = names::to_rust_ident(&case.name);
+                    if case.ty.is_some() {
+                        let payload_name =
+                            format!("{c_name}{}Payload", names::to_rust_type(&case.name));
+                        writeln!(out, "            pub {field_name}: *mut {payload_name},")?;
+                    }
+                }
+                writeln!(out, "        }}")?;
+                writeln!(out)?;
+            }
 
-    fn derive_kotlin_package(&self) -> String {
-        let world = &self.resolve.worlds[self.world_id];
-        // Try to get the package name from the WIT world's package
-        if let Some(pkg_id) = world.package {
-            let pkg = &self.resolve.packages[pkg_id];
-            let ns = &pkg.name.namespace;
-            let name = &pkg.name.name;
-            return format!("{ns}.{name}");
-        }
-        // Fallback to the world name
-        world.name.clone()
-    }
+            TypeDefKind::Enum(e) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                writeln!(out, "        #[repr(u32)]")?;
+                writeln!(out, "        #[derive(Debug, Clone, Copy, PartialEq, Eq)]")?;
+                writeln!(out, "        pub enum {c_name} {{")?;
+                for (i, case) in e.cases.iter().enumerate() {
+                    let case_name = names::to_rust_type(&case.name);
+                    writeln!(out, "            {case_name} = {i},")?;
+                }
+                writeln!(out, "        }}")?;
+                writeln!(out)?;
+            }
 
-    fn generate_jni_conversion_helpers(
-        &self,
-        out: &mut String,
-        kotlin_package: &str,
-    ) -> std::fmt::Result {
-        let jni_pkg = kotlin_package.replace('.', "/");
+            TypeDefKind::Tuple(tuple) => {
+                let c_name = self.tuple_c_name(tuple);
 
-        writeln!(out, "        // ---- JNI conversion helpers ----")?;
-        writeln!(out)?;
+                writeln!(out, "        #[repr(C)]")?;
+                writeln!(out, "        #[derive(Debug)]")?;
+                writeln!(out, "        pub struct {c_name} {{")?;
+                for (i, ty) in tuple.types.iter().enumerate() {
+                    let field_type = self.type_to_c_rust(ty);
+                    writeln!(out, "            pub f{i}: {field_type},")?;
+                }
+                writeln!(out, "        }}")?;
+                writeln!(out)?;
 
-        let reachable = self.collect_reachable_types();
-        for type_id in &reachable {
-            let typedef = &self.resolve.types[*type_id];
-            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                if self.tuple_has_input_struct(tuple) {
+                    writeln!(out, "        #[repr(C)]")?;
+                    writeln!(out, "        #[derive(Debug)]")?;
+                    writeln!(out, "        pub struct {c_name}Input {{")?;
+                    for (i, ty) in tuple.types.iter().enumerate() {
+                        let field_type = self.type_to_ffi_input(ty);
+                        writeln!(out, "            pub f{i}: {field_type},")?;
+                    }
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+            }
 
-            match &typedef.kind {
-                TypeDefKind::Record(record) => {
-                    let rust_name = names::to_rust_type(wit_name);
-                    let kotlin_name = names::to_kotlin_type(wit_name);
-                    let fn_name = format!("to_jni_{}", wit_name.to_snake_case());
-                    let class_path = format!("{jni_pkg}/{kotlin_name}");
+            TypeDefKind::Result(result) if self.is_nested_result(type_id) => {
+                let c_name = self.shape_c_name(type_id);
 
-                    writeln!(out, "        fn {fn_name}<'local>(")?;
-                    writeln!(out, "            env: &mut jni::JNIEnv<'local>,")?;
-                    writeln!(out, "            value: &{rust_name},")?;
+                writeln!(out, "        #[repr(C)]")?;
+                writeln!(out, "        #[derive(Debug)]")?;
+                writeln!(out, "        pub struct {c_name} {{")?;
+                writeln!(out, "            pub is_ok: bool,")?;
+                if let Some(ok) = &result.ok {
+                    writeln!(out, "            pub ok: *mut {},", self.type_to_c_rust(ok))?;
+                }
+                if let Some(err) = &result.err {
                     writeln!(
                         out,
-                        "        ) -> jni::errors::Result<jni::objects::JObject<'local>> {{"
+                        "            pub err: *mut {},",
+                        self.type_to_c_rust(err)
                     )?;
+                }
+                writeln!(out, "        }}")?;
+                writeln!(out)?;
+            }
 
-                    // Build constructor args
-                    for field in &record.fields {
-                        let field_name = names::to_rust_ident(&field.name);
-                        let jni_conv = self
-                            .generate_to_jni_field_expr(&field.ty, &format!("value.{field_name}"));
-                        writeln!(out, "            let _{field_name} = {jni_conv};")?;
-                    }
-
-                    // Build JNI descriptor
-                    let descriptor = self.record_jni_constructor_descriptor(record, kotlin_package);
+            TypeDefKind::FixedLengthList(elem, len) => {
+                let c_name = self.array_c_name(elem, *len);
+                let elem_type = self.type_to_c_rust(elem);
 
-                    // Build JValue array
-                    writeln!(out, "            let args: &[jni::objects::JValue] = &[")?;
+                writeln!(out, "        #[repr(C)]")?;
+                writeln!(out, "        #[derive(Debug)]")?;
+                writeln!(out, "        pub struct {c_name} {{")?;
+                writeln!(out, "            pub elems: [{elem_type}; {len}],")?;
+                writeln!(out, "        }}")?;
+                writeln!(out)?;
+            }
+
+            TypeDefKind::Flags(flags) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                writeln!(out, "        pub type {c_name} = u32;")?;
+                for (i, flag) in flags.flags.iter().enumerate() {
+                    let const_name = names::to_c_enum_variant(wit_name, &flag.name);
+                    writeln!(out, "        pub const {const_name}: {c_name} = 1 << {i};")?;
+                }
+                writeln!(out)?;
+            }
+
+            TypeDefKind::Type(inner) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                let inner_ty = self.type_to_c_rust(inner);
+                if c_name != inner_ty {
+                    writeln!(out, "        pub type {c_name} = {inner_ty};")?;
+                    writeln!(out)?;
+                }
+            }
+
+            _ => {}
+        }
+
+        Ok(())
+    }
+
+    fn generate_ffi_conversions(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(
+            out,
+            "        // ---- Conversions: idiomatic -> repr(C) ----"
+        )?;
+        writeln!(out)?;
+
+        let reachable = self.collect_reachable_types();
+        for type_id in &reachable {
+            let typedef = &self.resolve.types[*type_id];
+            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+
+            match &typedef.kind {
+                TypeDefKind::Record(record) => {
+                    let rust_name = names::to_rust_type(wit_name);
+                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                    let fn_name = format!("{}_to_ffi", wit_name.to_snake_case());
+
+                    writeln!(out, "        fn {fn_name}(v: {rust_name}) -> {c_name} {{")?;
+                    writeln!(out, "            {c_name} {{")?;
                     for field in &record.fields {
                         let field_name = names::to_rust_ident(&field.name);
-                        let jvalue = self.type_to_jvalue(&field.ty, &format!("_{field_name}"));
-                        writeln!(out, "                {jvalue},")?;
+                        let conversion =
+                            self.generate_to_ffi_expr(&field.ty, &format!("v.{field_name}"));
+                        writeln!(out, "                {field_name}: {conversion},")?;
                     }
-                    writeln!(out, "            ];")?;
-
-                    writeln!(
-                        out,
-                        "            env.new_object(\"{class_path}\", \"{descriptor}\", args)"
-                    )?;
+                    writeln!(out, "            }}")?;
                     writeln!(out, "        }}")?;
                     writeln!(out)?;
                 }
 
                 TypeDefKind::Variant(variant) => {
                     let rust_name = names::to_rust_type(wit_name);
-                    let kotlin_name = names::to_kotlin_type(wit_name);
-                    let fn_name = format!("to_jni_{}", wit_name.to_snake_case());
-                    let base_class_path = format!("{jni_pkg}/{kotlin_name}");
+                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                    let tag_name = format!("{c_name}Tag");
+                    let fn_name = format!("{}_to_ffi", wit_name.to_snake_case());
 
-                    writeln!(out, "        fn {fn_name}<'local>(")?;
-                    writeln!(out, "            env: &mut jni::JNIEnv<'local>,")?;
-                    writeln!(out, "            value: &{rust_name},")?;
-                    writeln!(
-                        out,
-                        "        ) -> jni::errors::Result<jni::objects::JObject<'local>> {{"
-                    )?;
-                    writeln!(out, "            match value {{")?;
+                    writeln!(out, "        fn {fn_name}(v: {rust_name}) -> {c_name} {{")?;
+                    writeln!(out, "            match v {{")?;
 
-                    for case in &variant.cases {
-                        let case_name = names::to_rust_type(&case.name);
-                        let kotlin_case = names::to_kotlin_type(&case.name);
-                        let inner_class = format!("{base_class_path}${kotlin_case}");
+                    // We need to build the match arms. Each arm sets the tag
+                    // and assigns the active payload pointer; the others are null.
+                    for (i, case) in variant.cases.iter().enumerate() {
+                        let variant_name = names::to_rust_type(&case.name);
+                        let tag_variant = format!("{tag_name}::{variant_name}");
 
                         if let Some(ty) = &case.ty {
-                            let jni_conv = self.generate_to_jni_field_expr(ty, "inner");
-                            let descriptor =
-                                format!("({})V", self.type_to_jni_descriptor(ty, kotlin_package));
-                            writeln!(out, "                {rust_name}::{case_name}(inner) => {{")?;
-                            writeln!(out, "                    let _inner = {jni_conv};")?;
-                            let jvalue = self.type_to_jvalue(ty, "_inner");
+                            let payload_name =
+                                format!("{c_name}{}Payload", names::to_rust_type(&case.name));
+                            let payload = match witffi_core::payload_fields(self.resolve, ty) {
+                                Some(fields) => {
+                                    let fields: Vec<String> = fields
+                                        .iter()
+                                        .enumerate()
+                                        .map(|(i, field_ty)| {
+                                            let conversion = self.generate_to_ffi_expr(
+                                                field_ty,
+                                                &format!("inner.{i}"),
+                                            );
+                                            format!("f{i}: {conversion}")
+                                        })
+                                        .collect();
+                                    format!("{payload_name} {{ {} }}", fields.join(", "))
+                                }
+                                None => {
+                                    let conversion = self.generate_to_ffi_expr(ty, "inner");
+                                    format!("{payload_name} {{ value: {conversion} }}")
+                                }
+                            };
+
                             writeln!(
                                 out,
-                                "                    env.new_object(\"{inner_class}\", \"{descriptor}\", &[{jvalue}])"
+                                "                {rust_name}::{variant_name}(inner) => {c_name} {{"
                             )?;
-                            writeln!(out, "                }}")?;
+                            writeln!(out, "                    tag: {tag_variant},")?;
+
+                            // Set all case fields
+                            for (j, other_case) in variant.cases.iter().enumerate() {
+                                if other_case.ty.is_none() {
+                                    continue;
+                                }
+                                let other_field = names::to_rust_ident(&other_case.name);
+                                if j == i {
+                                    writeln!(
+                                        out,
+                                        "                    {other_field}: {},",
+                                        self.box_into_raw(&payload)
+                                    )?;
+                                } else {
+                                    writeln!(
+                                        out,
+                                        "                    {other_field}: std::ptr::null_mut(),"
+                                    )?;
+                                }
+                            }
+
+                            writeln!(out, "                }},")?;
                         } else {
-                            writeln!(out, "                {rust_name}::{case_name} => {{")?;
                             writeln!(
                                 out,
-                                "                    env.new_object(\"{inner_class}\", \"()V\", &[])"
+                                "                {rust_name}::{variant_name} => {c_name} {{"
                             )?;
-                            writeln!(out, "                }}")?;
+                            writeln!(out, "                    tag: {tag_variant},")?;
+                            for other_case in &variant.cases {
+                                if other_case.ty.is_some() {
+                                    let other_field = names::to_rust_ident(&other_case.name);
+                                    writeln!(
+                                        out,
+                                        "                    {other_field}: std::ptr::null_mut(),"
+                                    )?;
+                                }
+                            }
+                            writeln!(out, "                }},")?;
+                        }
+                    }
+
+                    writeln!(out, "            }}")?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+
+                TypeDefKind::Tuple(tuple) => {
+                    let rust_ty = self.tuple_to_idiomatic(tuple);
+                    let c_name = self.tuple_c_name(tuple);
+                    let fn_name = format!("{}_to_ffi", self.tuple_fn_stem(tuple));
+
+                    // Unused when the tuple only appears as an inline variant payload
+                    writeln!(out, "        #[allow(dead_code)]")?;
+                    writeln!(out, "        fn {fn_name}(v: {rust_ty}) -> {c_name} {{")?;
+                    writeln!(out, "            {c_name} {{")?;
+                    for (i, ty) in tuple.types.iter().enumerate() {
+                        let conversion = self.generate_to_ffi_expr(ty, &format!("v.{i}"));
+                        writeln!(out, "                f{i}: {conversion},")?;
+                    }
+                    writeln!(out, "            }}")?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+
+                TypeDefKind::Result(result) if self.is_nested_result(*type_id) => {
+                    let rust_ty = self.type_to_idiomatic(&Type::Id(*type_id));
+                    let c_name = self.shape_c_name(*type_id);
+                    let fn_name = format!("{}_to_ffi", self.shape_fn_stem(*type_id));
+
+                    // The payload of the taken arm is boxed; the other is null
+                    writeln!(out, "        fn {fn_name}(v: {rust_ty}) -> {c_name} {{")?;
+                    writeln!(out, "            match v {{")?;
+                    for (case, is_ok) in [("Ok", true), ("Err", false)] {
+                        let payload = if is_ok { &result.ok } else { &result.err };
+                        let binding = if payload.is_some() { "v" } else { "()" };
+                        writeln!(out, "                {case}({binding}) => {c_name} {{")?;
+                        writeln!(out, "                    is_ok: {is_ok},")?;
+                        for (field, ty, taken) in
+                            [("ok", &result.ok, is_ok), ("err", &result.err, !is_ok)]
+                        {
+                            let Some(ty) = ty else { continue };
+                            if taken {
+                                let conversion = self.generate_to_ffi_expr(ty, "v");
+                                writeln!(
+                                    out,
+                                    "                    {field}: {},",
+                                    self.box_into_raw(&conversion)
+                                )?;
+                            } else {
+                                writeln!(
+                                    out,
+                                    "                    {field}: std::ptr::null_mut(),"
+                                )?;
+                            }
                         }
+                        writeln!(out, "                }},")?;
+                    }
+                    writeln!(out, "            }}")?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+
+                TypeDefKind::FixedLengthList(elem, len) => {
+                    let rust_ty = self.type_to_idiomatic(&Type::Id(*type_id));
+                    let c_name = self.array_c_name(elem, *len);
+                    let fn_name = format!("{}_to_ffi", self.array_fn_stem(elem, *len));
+                    let conversion = self.generate_to_ffi_expr(elem, "e");
+
+                    writeln!(out, "        fn {fn_name}(v: {rust_ty}) -> {c_name} {{")?;
+                    writeln!(out, "            {c_name} {{")?;
+                    if conversion == "e" {
+                        writeln!(out, "                elems: v,")?;
+                    } else {
+                        writeln!(out, "                elems: v.map(|e| {conversion}),")?;
                     }
+                    writeln!(out, "            }}")?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
 
+                TypeDefKind::Enum(e) => {
+                    let rust_name = names::to_rust_type(wit_name);
+                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                    let snake = wit_name.to_snake_case();
+
+                    // Enums are the only type passed by value in both
+                    // directions, so they also get a conversion for
+                    // parameters. Those arrive as a bare u32, as the caller
+                    // may pass any value, and one naming no case fails the
+                    // call through the panic arm.
+                    writeln!(out, "        #[allow(dead_code)]")?;
+                    writeln!(
+                        out,
+                        "        fn {snake}_to_ffi(v: {rust_name}) -> {c_name} {{"
+                    )?;
+                    writeln!(out, "            match v {{")?;
+                    for case in &e.cases {
+                        let case_name = names::to_rust_type(&case.name);
+                        writeln!(
+                            out,
+                            "                {rust_name}::{case_name} => {c_name}::{case_name},"
+                        )?;
+                    }
+                    writeln!(out, "            }}")?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                    writeln!(out, "        #[allow(dead_code)]")?;
+                    writeln!(out, "        fn {snake}_from_ffi(v: u32) -> {rust_name} {{")?;
+                    writeln!(out, "            match v {{")?;
+                    for case in &e.cases {
+                        let case_name = names::to_rust_type(&case.name);
+                        writeln!(
+                            out,
+                            "                v if v == {c_name}::{case_name} as u32 => {rust_name}::{case_name},"
+                        )?;
+                    }
+                    writeln!(
+                        out,
+                        "                v => panic!(\"{{v}} is not a case of enum `{wit_name}`\"),"
+                    )?;
                     writeln!(out, "            }}")?;
                     writeln!(out, "        }}")?;
                     writeln!(out)?;
@@ -1520,10 +2301,10 @@ impl<'a> RustGenerator<'a> {
         Ok(())
     }
 
-    /// Generate a JNI field expression for converting a Rust value.
-    fn generate_to_jni_field_expr(&self, ty: &Type, expr: &str) -> String {
+    /// Generate a Rust expression that converts an idiomatic value to its FFI representation.
+    fn generate_to_ffi_expr(&self, ty: &Type, expr: &str) -> String {
         match ty {
-            Type::String => format!("env.new_string(&{expr})?"),
+            Type::String => format!("witffi_types::FfiByteBuffer::from_string({expr})"),
             Type::Bool
             | Type::U8
             | Type::U16
@@ -1535,48 +2316,1897 @@ impl<'a> RustGenerator<'a> {
             | Type::S64
             | Type::F32
             | Type::F64 => expr.to_string(),
-            Type::Char => format!("{expr} as u16"),
+            Type::Char => format!("{expr} as u32"),
+            Type::Id(id) if witffi_core::wide_int(self.resolve, ty).is_some() => {
+                // 128-bit integers are split into (low, high) words
+                let tuple_id = witffi_core::dealias(self.resolve, *id);
+                let TypeDefKind::Tuple(tuple) = &self.resolve.types[tuple_id].kind else {
+                    unreachable!("wide integers are tuple aliases");
+                };
+                format!(
+                    "{}_to_ffi(({expr} as u64, ({expr} >> 64) as u64))",
+                    self.tuple_fn_stem(tuple)
+                )
+            }
             Type::Id(id) => {
                 let typedef = &self.resolve.types[*id];
                 match &typedef.kind {
                     TypeDefKind::List(Type::U8) => {
-                        format!("env.byte_array_from_slice(&{expr})?")
+                        format!("witffi_types::FfiByteBuffer::from_vec({expr})")
                     }
-                    TypeDefKind::Option(inner) => {
-                        let inner_expr = self.generate_to_jni_field_expr(inner, "v");
-                        let is_primitive = self.is_jni_primitive(inner);
-                        if is_primitive {
-                            let boxed_class = self.type_to_jni_boxed_class(inner);
-                            let box_descriptor = self.type_to_jni_box_descriptor(inner);
-                            let jval = self.type_to_jvalue_primitive(inner, "v");
-                            format!(
-                                "match &{expr} {{ Some(v) => env.new_object(\"{boxed_class}\", \"{box_descriptor}\", &[{jval}])?, None => jni::objects::JObject::null() }}"
-                            )
+                    TypeDefKind::List(inner) => {
+                        let conversion = self.generate_to_ffi_expr(inner, "e");
+                        if conversion == "e" {
+                            format!("witffi_types::FfiList::from_vec({expr})")
                         } else {
                             format!(
-                                "match &{expr} {{ Some(v) => {{ let _v = {inner_expr}; jni::objects::JObject::from(_v) }}, None => jni::objects::JObject::null() }}"
+                                "witffi_types::FfiList::from_vec({expr}.into_iter().map(|e| {conversion}).collect())"
                             )
                         }
                     }
-                    TypeDefKind::Type(aliased) => self.generate_to_jni_field_expr(aliased, expr),
+                    TypeDefKind::Result(_) => {
+                        format!("{}_to_ffi({expr})", self.shape_fn_stem(*id))
+                    }
+                    TypeDefKind::Option(inner) => {
+                        let inner_expr = self.generate_to_ffi_expr(inner, "v");
+                        format!("witffi_types::option_to_ptr({expr}.map(|v| {inner_expr}))")
+                    }
+                    TypeDefKind::Type(aliased) => self.generate_to_ffi_expr(aliased, expr),
                     TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
                         let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
-                        let fn_name = format!("to_jni_{}", wit_name.to_snake_case());
-                        format!("{fn_name}(env, &{expr})?")
+                        let fn_name = format!("{}_to_ffi", wit_name.to_snake_case());
+                        format!("{fn_name}({expr})")
+                    }
+                    TypeDefKind::Enum(_) => {
+                        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                        format!("{}_to_ffi({expr})", wit_name.to_snake_case())
+                    }
+                    TypeDefKind::Tuple(tuple) => {
+                        format!("{}_to_ffi({expr})", self.tuple_fn_stem(tuple))
+                    }
+                    TypeDefKind::FixedLengthList(elem, len) => {
+                        format!("{}_to_ffi({expr})", self.array_fn_stem(elem, *len))
+                    }
+                    TypeDefKind::Flags(_) => expr.to_string(),
+                    TypeDefKind::Handle(_) => {
+                        format!("{} as *mut std::ffi::c_void", self.box_into_raw(&expr))
                     }
                     _ => expr.to_string(),
                 }
             }
-            Type::ErrorContext => format!("env.new_string(&{expr})?"),
+            Type::ErrorContext => format!("{expr}.into_ffi()"),
         }
     }
 
-    fn is_jni_primitive(&self, ty: &Type) -> bool {
-        matches!(
-            ty,
-            Type::Bool
-                | Type::U8
-                | Type::U16
+    fn generate_ffi_free_functions(&self, out: &mut String) -> std::fmt::Result {
+        let prefix = self.config.c_prefix.to_snake_case();
+
+        writeln!(out, "        // ---- Free functions ----")?;
+        writeln!(out)?;
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {prefix}_free_byte_buffer(buf: witffi_types::FfiByteBuffer) {{"
+        )?;
+        writeln!(out, "            unsafe {{ buf.free() }};")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        if self.config.alloc_accounting || self.config.alloc_canaries {
+            writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+            writeln!(out, "        #[unsafe(no_mangle)]")?;
+            writeln!(
+                out,
+                "        pub unsafe extern \"C\" fn {prefix}_free_box(ptr: *mut std::ffi::c_void) {{"
+            )?;
+            writeln!(
+                out,
+                "            unsafe {{ witffi_types::accounting::free_box(ptr) }};"
+            )?;
+            writeln!(out, "        }}")?;
+            writeln!(out)?;
+        }
+        if self.config.alloc_accounting {
+            writeln!(out, "        #[unsafe(no_mangle)]")?;
+            writeln!(
+                out,
+                "        pub extern \"C\" fn {prefix}_alloc_stats() -> witffi_types::FfiByteBuffer {{"
+            )?;
+            writeln!(out, "            witffi_types::accounting::stats_buffer()")?;
+            writeln!(out, "        }}")?;
+            writeln!(out)?;
+        }
+
+        self.generate_release_functions(out)?;
+
+        for record_id in self.columnar_records() {
+            let TypeDefKind::Record(record) = &self.resolve.types[record_id].kind else {
+                continue;
+            };
+            let wit_name = self.resolve.types[record_id]
+                .name
+                .as_deref()
+                .unwrap_or("anonymous");
+            let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+            let free_name =
+                names::to_c_func(&self.config.c_prefix, &format!("free-{wit_name}-columns"));
+
+            writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+            writeln!(out, "        #[unsafe(no_mangle)]")?;
+            writeln!(
+                out,
+                "        pub unsafe extern \"C\" fn {free_name}(columns: {c_name}Columns) {{"
+            )?;
+            for field in &record.fields {
+                let field_name = names::to_rust_ident(&field.name);
+                writeln!(
+                    out,
+                    "            drop(unsafe {{ Box::from_raw(std::ptr::slice_from_raw_parts_mut(columns.{field_name}, columns.len)) }});"
+                )?;
+            }
+            writeln!(out, "        }}")?;
+            writeln!(out)?;
+        }
+
+        let reachable = self.collect_reachable_types();
+        for type_id in &reachable {
+            let typedef = &self.resolve.types[*type_id];
+            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+
+            match &typedef.kind {
+                TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
+                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                    let free_name =
+                        names::to_c_func(&self.config.c_prefix, &format!("free-{wit_name}"));
+
+                    writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+                    writeln!(out, "        #[unsafe(no_mangle)]")?;
+                    writeln!(
+                        out,
+                        "        pub unsafe extern \"C\" fn {free_name}(ptr: *mut {c_name}) {{"
+                    )?;
+                    self.generate_free_ptr_body(out, *type_id)?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+                TypeDefKind::Tuple(tuple) => {
+                    let c_name = self.tuple_c_name(tuple);
+                    let free_name = format!("{prefix}_free_{}", self.tuple_fn_stem(tuple));
+
+                    writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+                    writeln!(out, "        #[unsafe(no_mangle)]")?;
+                    writeln!(
+                        out,
+                        "        pub unsafe extern \"C\" fn {free_name}(ptr: *mut {c_name}) {{"
+                    )?;
+                    self.generate_free_ptr_body(out, *type_id)?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+                TypeDefKind::List(elem) if *elem != Type::U8 => {
+                    // Strings and nested lists inside the elements are freed by
+                    // the caller while converting them; only the elements'
+                    // variant payloads and the list itself are released here.
+                    let c_elem = self.type_to_c_rust(elem);
+                    let free_name = format!("{prefix}_free_{}", self.shape_fn_stem(*type_id));
+
+                    writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+                    writeln!(out, "        #[unsafe(no_mangle)]")?;
+                    writeln!(
+                        out,
+                        "        pub unsafe extern \"C\" fn {free_name}(list: witffi_types::FfiList<{c_elem}>) {{"
+                    )?;
+                    match self.release_stmt(elem, "*e") {
+                        Some(stmt) => {
+                            writeln!(
+                                out,
+                                "            for e in unsafe {{ list.into_vec() }}.iter() {{"
+                            )?;
+                            writeln!(out, "                {stmt}")?;
+                            writeln!(out, "            }}")?;
+                        }
+                        None => writeln!(out, "            drop(unsafe {{ list.into_vec() }});")?,
+                    }
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+                TypeDefKind::FixedLengthList(elem, len) => {
+                    let c_name = self.array_c_name(elem, *len);
+                    let free_name = format!("{prefix}_free_{}", self.array_fn_stem(elem, *len));
+
+                    writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+                    writeln!(out, "        #[unsafe(no_mangle)]")?;
+                    writeln!(
+                        out,
+                        "        pub unsafe extern \"C\" fn {free_name}(ptr: *mut {c_name}) {{"
+                    )?;
+                    self.generate_free_ptr_body(out, *type_id)?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+                TypeDefKind::Resource => {
+                    let drop_name = format!(
+                        "{}_drop",
+                        witffi_core::resource_c_prefix(
+                            self.resolve,
+                            &self.config.c_prefix,
+                            *type_id
+                        )
+                    );
+                    let impl_path = self.resource_impl_path(*type_id);
+
+                    writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+                    writeln!(out, "        #[unsafe(no_mangle)]")?;
+                    writeln!(
+                        out,
+                        "        pub unsafe extern \"C\" fn {drop_name}(ptr: *mut std::ffi::c_void) {{"
+                    )?;
+                    // A panicking destructor must not unwind into the caller
+                    writeln!(
+                        out,
+                        "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| unsafe {{"
+                    )?;
+                    writeln!(
+                        out,
+                        "                witffi_types::free_ptr(ptr as *mut {impl_path})"
+                    )?;
+                    writeln!(out, "            }}));")?;
+                    writeln!(out, "            match result {{")?;
+                    writeln!(out, "                Ok(()) => {{}}")?;
+                    self.generate_panic_arm(out, FfiPanicReturn::Unit)?;
+                    writeln!(out, "            }}")?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+                _ => {}
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Generate the functions cancelling and releasing the calls started for
+    /// `future<T>` results.
+    fn generate_ffi_future_functions(&self, out: &mut String, prefix: &str) -> std::fmt::Result {
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {prefix}_future_cancel(call: *mut std::ffi::c_void) {{"
+        )?;
+        writeln!(
+            out,
+            "            unsafe {{ witffi_types::future_cancel(call) }}"
+        )?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {prefix}_future_release(call: *mut std::ffi::c_void) {{"
+        )?;
+        writeln!(
+            out,
+            "            unsafe {{ witffi_types::future_release(call) }}"
+        )?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    fn generate_ffi_error_functions(&self, out: &mut String, prefix: &str) -> std::fmt::Result {
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub extern \"C\" fn {prefix}_last_error_length() -> i32 {{"
+        )?;
+        writeln!(out, "            LAST_ERROR.with(|e| {{")?;
+        writeln!(
+            out,
+            "                e.borrow().as_ref().map(|s| s.len() as i32 + 1).unwrap_or(0)"
+        )?;
+        writeln!(out, "            }})")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {prefix}_error_message_utf8(buf: *mut std::os::raw::c_char, len: i32) -> i32 {{"
+        )?;
+        writeln!(out, "            LAST_ERROR.with(|e| {{")?;
+        writeln!(out, "                match e.borrow().as_ref() {{")?;
+        writeln!(out, "                    Some(msg) => {{")?;
+        writeln!(
+            out,
+            "                        let c_msg = match std::ffi::CString::new(msg.as_str()) {{"
+        )?;
+        writeln!(out, "                            Ok(c) => c,")?;
+        writeln!(out, "                            Err(_) => return -1,")?;
+        writeln!(out, "                        }};")?;
+        writeln!(
+            out,
+            "                        let bytes = c_msg.as_bytes_with_nul();"
+        )?;
+        writeln!(
+            out,
+            "                        let copy_len = bytes.len().min(len as usize);"
+        )?;
+        writeln!(
+            out,
+            "                        unsafe {{ std::ptr::copy_nonoverlapping(bytes.as_ptr(), buf as *mut u8, copy_len) }};"
+        )?;
+        writeln!(out, "                        copy_len as i32")?;
+        writeln!(out, "                    }}")?;
+        writeln!(out, "                    None => 0,")?;
+        writeln!(out, "                }}")?;
+        writeln!(out, "            }})")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub extern \"C\" fn {prefix}_clear_last_error() {{"
+        )?;
+        writeln!(
+            out,
+            "            LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+        )?;
+        writeln!(
+            out,
+            "            LAST_PANIC.with(|p| *p.borrow_mut() = None);"
+        )?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {prefix}_take_panic(backtrace_out: *mut witffi_types::FfiByteBuffer) -> bool {{"
+        )?;
+        writeln!(
+            out,
+            "            match LAST_PANIC.with(|p| p.borrow_mut().take()) {{"
+        )?;
+        writeln!(out, "                Some(backtrace) => {{")?;
+        writeln!(out, "                    if !backtrace_out.is_null() {{")?;
+        writeln!(
+            out,
+            "                        unsafe {{ *backtrace_out = witffi_types::FfiByteBuffer::from_string(backtrace) }};"
+        )?;
+        writeln!(out, "                    }}")?;
+        writeln!(out, "                    true")?;
+        writeln!(out, "                }}")?;
+        writeln!(out, "                None => false,")?;
+        writeln!(out, "            }}")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub extern \"C\" fn {prefix}_capture_panic_backtraces() {{"
+        )?;
+        writeln!(out, "            #[cfg(debug_assertions)]")?;
+        writeln!(out, "            witffi_types::capture_panic_backtraces();")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    /// Generate `_init` and `_shutdown`, calling the world trait's
+    /// `init_library` and `shutdown_library`.
+    fn generate_ffi_lifecycle_functions(&self, out: &mut String, prefix: &str) -> std::fmt::Result {
+        let trait_name = names::to_rust_type(&self.resolve.worlds[self.world_id].name);
+
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {prefix}_init(options: witffi_types::FfiByteSlice) -> bool {{"
+        )?;
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+        )?;
+        writeln!(
+            out,
+            "                let options = witffi_types::init_options(unsafe {{ options.as_bytes() }});"
+        )?;
+        writeln!(
+            out,
+            "                <$impl_type as {trait_name}>::init_library(&options)"
+        )?;
+        writeln!(out, "            }}));")?;
+        writeln!(out, "            match result {{")?;
+        writeln!(out, "                Ok(Ok(())) => {{")?;
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+        )?;
+        writeln!(out, "                    true")?;
+        writeln!(out, "                }}")?;
+        writeln!(out, "                Ok(Err(e)) => {{")?;
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e_cell| *e_cell.borrow_mut() = Some(e));"
+        )?;
+        writeln!(out, "                    false")?;
+        writeln!(out, "                }}")?;
+        self.generate_panic_arm(out, FfiPanicReturn::Bool)?;
+        writeln!(out, "            }}")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(out, "        pub extern \"C\" fn {prefix}_shutdown() {{")?;
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(|| <$impl_type as {trait_name}>::shutdown_library());"
+        )?;
+        writeln!(out, "            match result {{")?;
+        writeln!(out, "                Ok(()) => {{}}")?;
+        self.generate_panic_arm(out, FfiPanicReturn::Unit)?;
+        writeln!(out, "            }}")?;
+        // The caller may redirect stdout only until the library shuts down
+        writeln!(
+            out,
+            "            let _ = std::io::Write::flush(&mut std::io::stdout());"
+        )?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    /// Generate the functions creating, cancelling and binding the tokens
+    /// behind `witffi_types::is_cancelled`.
+    ///
+    /// A caller binds a token to its thread with `_cancel_token_enter` around
+    /// the calls it may cancel, and cancels it from any thread.
+    fn generate_ffi_cancel_functions(&self, out: &mut String, prefix: &str) -> std::fmt::Result {
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub extern \"C\" fn {prefix}_cancel_token_new() -> *mut std::ffi::c_void {{"
+        )?;
+        writeln!(out, "            witffi_types::cancel_token_new()")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+        for name in ["cancel", "free", "enter"] {
+            writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+            writeln!(out, "        #[unsafe(no_mangle)]")?;
+            writeln!(
+                out,
+                "        pub unsafe extern \"C\" fn {prefix}_cancel_token_{name}(token: *mut std::ffi::c_void) {{"
+            )?;
+            writeln!(
+                out,
+                "            unsafe {{ witffi_types::cancel_token_{name}(token) }}"
+            )?;
+            writeln!(out, "        }}")?;
+            writeln!(out)?;
+        }
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub extern \"C\" fn {prefix}_cancel_token_exit() {{"
+        )?;
+        writeln!(out, "            witffi_types::cancel_token_exit()")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    fn generate_ffi_extern_function(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        // A function returning `future<T>` is started like an async function
+        // returning `T`
+        let awaited = ef.awaited(self.resolve);
+        let ef = awaited.as_ref().unwrap_or(ef);
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+
+        let trait_method = self.trait_method_name(ef);
+        let result_decomposed = self.decompose_result(&ef.function.result);
+
+        // Build C parameter list (using FFI-safe input types)
+        let mut c_params: Vec<String> = self
+            .flat_params(ef)
+            .iter()
+            .map(|(name, ty)| format!("{name}: {}", self.type_to_ffi_input(ty)))
+            .collect();
+        if let Some(err_ty) = ef.error_payload(self.resolve) {
+            let err_c = self.type_to_c_rust(&err_ty);
+            c_params.push(format!("err_out: *mut *mut {err_c}"));
+        }
+        if let Some(item) = ef.stream_item(self.resolve) {
+            return self.generate_ffi_stream_function(out, ef, c_params, &item);
+        }
+
+        // Determine C return type (handles are already pointers, so they
+        // are returned directly rather than boxed)
+        let c_return = if let Some((ref ok_ty, _)) = result_decomposed {
+            match ok_ty {
+                Some(ty) if self.is_handle(ty) => self.type_to_c_rust(ty),
+                Some(ty) => format!("*mut {}", self.type_to_c_rust(ty)),
+                None => "bool".to_string(),
+            }
+        } else {
+            match &ef.function.result {
+                Some(ty) => self.type_to_c_rust(ty),
+                None => "()".to_string(),
+            }
+        };
+
+        if ef.is_async() || awaited.is_some() {
+            let cancellable = awaited.is_some();
+            return self.generate_ffi_async_function(out, ef, c_params, &c_return, cancellable);
+        }
+
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+
+        if c_return == "()" {
+            writeln!(
+                out,
+                "        pub unsafe extern \"C\" fn {c_func_name}({}) {{",
+                c_params.join(", ")
+            )?;
+        } else {
+            writeln!(
+                out,
+                "        pub unsafe extern \"C\" fn {c_func_name}({}) -> {c_return} {{",
+                c_params.join(", ")
+            )?;
+        }
+
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+        )?;
+
+        // Convert parameters from FFI types to trait-compatible types
+        for p in &ef.function.params {
+            let c_name = names::to_rust_ident(&p.name);
+            self.generate_param_conversion(out, &c_name, &p.ty, "                ")?;
+        }
+
+        let rust_args: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| format!("{}_rust", names::to_rust_ident(&p.name)))
+            .collect();
+
+        writeln!(
+            out,
+            "                <$impl_type>::{trait_method}({})",
+            rust_args.join(", ")
+        )?;
+        writeln!(out, "            }}));")?;
+        writeln!(out)?;
+
+        self.generate_ffi_result_match(out, ef)?;
+
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    /// Generate `{c_func_name}_into`, which calls a function returning a
+    /// string or `list<u8>` and copies the result into the caller's buffer.
+    ///
+    /// It returns the length of the result, copying it only if it fits in
+    /// `dst_len` bytes, or `-1` on failure. A caller whose buffer was too
+    /// small calls again with one of at least the returned length.
+    fn generate_ffi_into_function(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        let trait_method = self.trait_method_name(ef);
+        let mut c_params: Vec<String> = self
+            .flat_params(ef)
+            .iter()
+            .map(|(name, ty)| format!("{name}: {}", self.type_to_ffi_input(ty)))
+            .collect();
+        c_params.push("dst: *mut u8".to_string());
+        c_params.push("dst_len: usize".to_string());
+
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {c_func_name}_into({}) -> isize {{",
+            c_params.join(", ")
+        )?;
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+        )?;
+        for p in &ef.function.params {
+            let c_name = names::to_rust_ident(&p.name);
+            self.generate_param_conversion(out, &c_name, &p.ty, "                ")?;
+        }
+        let rust_args: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| format!("{}_rust", names::to_rust_ident(&p.name)))
+            .collect();
+        writeln!(
+            out,
+            "                <$impl_type>::{trait_method}({})",
+            rust_args.join(", ")
+        )?;
+        writeln!(out, "            }}));")?;
+        writeln!(out)?;
+
+        let fallible = self.decompose_result(&ef.function.result).is_some();
+        writeln!(out, "            match result {{")?;
+        if fallible {
+            writeln!(out, "                Ok(Ok(value)) => {{")?;
+        } else {
+            writeln!(out, "                Ok(value) => {{")?;
+        }
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+        )?;
+        writeln!(
+            out,
+            "                    let bytes: &[u8] = value.as_ref();"
+        )?;
+        writeln!(
+            out,
+            "                    if !bytes.is_empty() && bytes.len() <= dst_len {{"
+        )?;
+        writeln!(
+            out,
+            "                        unsafe {{ std::ptr::copy_nonoverlapping(bytes.as_ptr(), dst, bytes.len()) }};"
+        )?;
+        writeln!(out, "                    }}")?;
+        writeln!(out, "                    bytes.len() as isize")?;
+        writeln!(out, "                }}")?;
+        if fallible {
+            writeln!(out, "                Ok(Err(e)) => {{")?;
+            writeln!(
+                out,
+                "                    LAST_ERROR.with(|e_cell| *e_cell.borrow_mut() = Some(format!(\"{{e}}\")));"
+            )?;
+            writeln!(out, "                    -1")?;
+            writeln!(out, "                }}")?;
+        }
+        self.generate_panic_arm(out, FfiPanicReturn::Count)?;
+        writeln!(out, "            }}")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    /// The string or `list<u8>` result of `ef`, when `into_variants` gives it
+    /// an `_into` variant.
+    fn into_result(&self, ef: &ExportedFunction) -> Option<Type> {
+        if !self.config.into_variants {
+            return None;
+        }
+        ef.into_result(self.resolve)
+    }
+
+    /// Generate `{c_func_name}_columns`, which calls a function returning a
+    /// `list<record>` and hands the result back as one array per field.
+    ///
+    /// It returns `false` on failure; on success the caller releases the
+    /// arrays with `{prefix}_free_{record}_columns`.
+    fn generate_ffi_columns_function(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        let Some(record_id) = self.columns_result(ef) else {
+            return Ok(());
+        };
+        let TypeDefKind::Record(record) = &self.resolve.types[record_id].kind else {
+            return Ok(());
+        };
+        let wit_name = self.resolve.types[record_id]
+            .name
+            .as_deref()
+            .unwrap_or("anonymous");
+        let columns_name = format!(
+            "{}Columns",
+            names::to_c_type(&self.config.c_type_prefix, wit_name)
+        );
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        let trait_method = self.trait_method_name(ef);
+        let mut c_params: Vec<String> = self
+            .flat_params(ef)
+            .iter()
+            .map(|(name, ty)| format!("{name}: {}", self.type_to_ffi_input(ty)))
+            .collect();
+        c_params.push(format!("columns_out: *mut {columns_name}"));
+
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {c_func_name}_columns({}) -> bool {{",
+            c_params.join(", ")
+        )?;
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+        )?;
+        for p in &ef.function.params {
+            let c_name = names::to_rust_ident(&p.name);
+            self.generate_param_conversion(out, &c_name, &p.ty, "                ")?;
+        }
+        let rust_args: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| format!("{}_rust", names::to_rust_ident(&p.name)))
+            .collect();
+        writeln!(
+            out,
+            "                <$impl_type>::{trait_method}({})",
+            rust_args.join(", ")
+        )?;
+        writeln!(out, "            }}));")?;
+        writeln!(out)?;
+
+        let fallible = self.decompose_result(&ef.function.result).is_some();
+        writeln!(out, "            match result {{")?;
+        if fallible {
+            writeln!(out, "                Ok(Ok(value)) => {{")?;
+        } else {
+            writeln!(out, "                Ok(value) => {{")?;
+        }
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+        )?;
+        writeln!(out, "                    let columns = {columns_name} {{")?;
+        writeln!(out, "                        len: value.len(),")?;
+        for field in &record.fields {
+            let field_name = names::to_rust_ident(&field.name);
+            let field_type = self.type_to_c_rust(&field.ty);
+            writeln!(
+                out,
+                "                        {field_name}: Box::into_raw(value.iter().map(|e| e.{field_name}).collect::<Box<[{field_type}]>>()) as *mut {field_type},"
+            )?;
+        }
+        writeln!(out, "                    }};")?;
+        writeln!(
+            out,
+            "                    unsafe {{ *columns_out = columns }};"
+        )?;
+        writeln!(out, "                    true")?;
+        writeln!(out, "                }}")?;
+        if fallible {
+            writeln!(out, "                Ok(Err(e)) => {{")?;
+            writeln!(
+                out,
+                "                    LAST_ERROR.with(|e_cell| *e_cell.borrow_mut() = Some(format!(\"{{e}}\")));"
+            )?;
+            writeln!(out, "                    false")?;
+            writeln!(out, "                }}")?;
+        }
+        self.generate_panic_arm(out, FfiPanicReturn::Bool)?;
+        writeln!(out, "            }}")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    /// The record of the `list<record>` result of `ef`, when `columnar`
+    /// gives it a `_columns` variant.
+    fn columns_result(&self, ef: &ExportedFunction) -> Option<TypeId> {
+        ef.columns_result(self.resolve, &self.config.columnar)
+    }
+
+    /// The records returned column by column by some exported function, in
+    /// the order of their first use.
+    /// Box `expr` and hand out the raw pointer, accounted for when
+    /// `alloc_accounting` is set.
+    fn box_into_raw(&self, expr: &str) -> String {
+        if self.config.alloc_accounting {
+            format!("witffi_types::accounting::into_raw({expr})")
+        } else {
+            format!("Box::into_raw(Box::new({expr}))")
+        }
+    }
+
+    /// Take back a boxed pointer, accounted for when `alloc_accounting` is
+    /// set. The result is an unsafe expression.
+    fn box_from_raw(&self, ptr: &str) -> String {
+        if self.config.alloc_accounting {
+            format!("witffi_types::accounting::from_raw({ptr})")
+        } else {
+            format!("Box::from_raw({ptr})")
+        }
+    }
+
+    fn columnar_records(&self) -> Vec<TypeId> {
+        let mut records = Vec::new();
+        for ef in exported_functions(self.resolve, self.world_id) {
+            if let Some(record_id) = self.columns_result(&ef)
+                && !records.contains(&record_id)
+            {
+                records.push(record_id);
+            }
+        }
+        records
+    }
+
+    /// Generate the C-ABI functions of an async exported function.
+    ///
+    /// Calling `{c_func_name}` copies the arguments and starts the future,
+    /// which calls `complete(user_data, task)` once it is done; the caller
+    /// then collects the result with `{c_func_name}_finish(task)`, which
+    /// returns like the synchronous function would. Collecting the result on
+    /// the caller's thread keeps `_last_error` working.
+    ///
+    /// A `cancellable` call (a returned `future<T>`) also returns the call
+    /// pointer for `_future_cancel`, and completes with a null task if it is
+    /// cancelled.
+    fn generate_ffi_async_function(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        mut c_params: Vec<String>,
+        c_return: &str,
+        cancellable: bool,
+    ) -> std::fmt::Result {
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        let trait_name = names::to_rust_type(&self.resolve.worlds[self.world_id].name);
+
+        // Typed errors are collected with the result
+        let err_out = ef
+            .error_payload(self.resolve)
+            .map(|_| c_params.pop().unwrap());
+        c_params.push("complete: witffi_types::FfiAsyncComplete".to_string());
+        c_params.push("user_data: usize".to_string());
+
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        let (start_return, start_func) = if cancellable {
+            (" -> *mut std::ffi::c_void", "start_future")
+        } else {
+            ("", "start_async")
+        };
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {c_func_name}({}){start_return} {{",
+            c_params.join(", ")
+        )?;
+        writeln!(
+            out,
+            "            let future = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+        )?;
+        self.generate_owned_call(out, ef)?;
+        writeln!(out, "            }}));")?;
+        writeln!(out, "            witffi_types::{start_func}(")?;
+        writeln!(
+            out,
+            "                <$impl_type as {trait_name}>::spawn_task,"
+        )?;
+        writeln!(out, "                async move {{")?;
+        writeln!(out, "                    match future {{")?;
+        writeln!(out, "                        Ok(future) => future.await,")?;
+        writeln!(
+            out,
+            "                        Err(panic) => std::panic::resume_unwind(panic),"
+        )?;
+        writeln!(out, "                    }}")?;
+        writeln!(out, "                }},")?;
+        writeln!(out, "                complete,")?;
+        writeln!(out, "                user_data,")?;
+        writeln!(out, "            ){}", if cancellable { "" } else { ";" })?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        let mut finish_params = vec!["task: *mut std::ffi::c_void".to_string()];
+        finish_params.extend(err_out);
+        let output = match &ef.function.result {
+            Some(ty) => self.type_to_impl_idiomatic(ty),
+            None => "()".to_string(),
+        };
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        let return_clause = if c_return == "()" {
+            String::new()
+        } else {
+            format!(" -> {c_return}")
+        };
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {c_func_name}_finish({}){return_clause} {{",
+            finish_params.join(", ")
+        )?;
+        writeln!(
+            out,
+            "            let result = unsafe {{ witffi_types::finish_async::<{output}>(task) }};"
+        )?;
+        writeln!(out)?;
+        self.generate_ffi_result_match(out, ef)?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    /// Generate the call to the implementation of a function taking owned
+    /// arguments (see `ExportedFunction::owns_arguments`), inside a
+    /// `catch_unwind` closure.
+    fn generate_owned_call(&self, out: &mut String, ef: &ExportedFunction) -> std::fmt::Result {
+        for p in &ef.function.params {
+            let c_name = names::to_rust_ident(&p.name);
+            self.generate_param_conversion(out, &c_name, &p.ty, "                ")?;
+            // Copy borrowed arguments, which the caller may free on return
+            if let Some(owned) = self.into_owned_expr(&p.ty, &format!("{c_name}_rust")) {
+                writeln!(out, "                let {c_name}_rust = {owned};")?;
+            }
+        }
+        let rust_args: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| format!("{}_rust", names::to_rust_ident(&p.name)))
+            .collect();
+        writeln!(
+            out,
+            "                <$impl_type>::{}({})",
+            self.trait_method_name(ef),
+            rust_args.join(", ")
+        )?;
+        Ok(())
+    }
+
+    /// Generate the C-ABI functions of an exported function returning a
+    /// `stream<T>`.
+    ///
+    /// Calling `{c_func_name}` copies the arguments and returns an opaque
+    /// producer (null on panic). The caller pulls each element with
+    /// `{c_func_name}_next(stream)`, which returns it boxed like a
+    /// `result<T, E>` ok value, or null once the stream is exhausted (or the
+    /// producer panicked, which sets `_last_error`), and releases the producer
+    /// with `{c_func_name}_drop(stream)`.
+    ///
+    /// A `stream<u8>` producer is a reader instead, which the caller fills
+    /// its buffers from with `{c_func_name}_read` (see
+    /// `generate_ffi_byte_stream_read`).
+    fn generate_ffi_stream_function(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        c_params: Vec<String>,
+        item: &Type,
+    ) -> std::fmt::Result {
+        let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+        let item_rust = self.type_to_impl_idiomatic(item);
+        let bytes = *item == Type::U8;
+
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {c_func_name}({}) -> *mut std::ffi::c_void {{",
+            c_params.join(", ")
+        )?;
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+        )?;
+        self.generate_owned_call(out, ef)?;
+        writeln!(out, "            }}));")?;
+        writeln!(out)?;
+        writeln!(out, "            match result {{")?;
+        writeln!(out, "                Ok(items) => {{")?;
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+        )?;
+        if bytes {
+            writeln!(
+                out,
+                "                    witffi_types::byte_stream_into_ptr(items)"
+            )?;
+        } else {
+            writeln!(
+                out,
+                "                    witffi_types::stream_into_ptr::<{item_rust}>(items)"
+            )?;
+        }
+        writeln!(out, "                }}")?;
+        self.generate_panic_arm(out, FfiPanicReturn::NullPtr)?;
+        writeln!(out, "            }}")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        if bytes {
+            self.generate_ffi_byte_stream_read(out, &c_func_name)?;
+        } else {
+            self.generate_ffi_stream_next(out, &c_func_name, item)?;
+        }
+
+        let drop = if bytes {
+            "witffi_types::byte_stream_drop(stream)".to_string()
+        } else {
+            format!("witffi_types::stream_drop::<{item_rust}>(stream)")
+        };
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {c_func_name}_drop(stream: *mut std::ffi::c_void) {{"
+        )?;
+        writeln!(out, "            unsafe {{ {drop} }}")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    /// Generate `{c_func_name}_next`, which pulls the next element of a
+    /// `stream<T>` producer.
+    fn generate_ffi_stream_next(
+        &self,
+        out: &mut String,
+        c_func_name: &str,
+        item: &Type,
+    ) -> std::fmt::Result {
+        let item_rust = self.type_to_impl_idiomatic(item);
+        let item_c = if self.is_handle(item) {
+            self.type_to_c_rust(item)
+        } else {
+            format!("*mut {}", self.type_to_c_rust(item))
+        };
+        let conversion = self.generate_to_ffi_expr(item, "value");
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {c_func_name}_next(stream: *mut std::ffi::c_void) -> {item_c} {{"
+        )?;
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| unsafe {{"
+        )?;
+        writeln!(
+            out,
+            "                witffi_types::stream_next::<{item_rust}>(stream)"
+        )?;
+        writeln!(out, "            }}));")?;
+        writeln!(out)?;
+        writeln!(out, "            match result {{")?;
+        writeln!(out, "                Ok(Some(value)) => {{")?;
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+        )?;
+        if self.is_handle(item) {
+            writeln!(out, "                    {conversion}")?;
+        } else {
+            writeln!(
+                out,
+                "                    {}",
+                self.box_into_raw(&conversion)
+            )?;
+        }
+        writeln!(out, "                }}")?;
+        writeln!(out, "                Ok(None) => {{")?;
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+        )?;
+        writeln!(out, "                    std::ptr::null_mut()")?;
+        writeln!(out, "                }}")?;
+        self.generate_panic_arm(out, FfiPanicReturn::NullPtr)?;
+        writeln!(out, "            }}")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+        Ok(())
+    }
+
+    /// Generate `{c_func_name}_read(stream, buf, len)`, which reads up to
+    /// `len` bytes of a `stream<u8>` producer into `buf` and returns how many
+    /// were read, `0` once the stream is exhausted or `-1` if reading failed
+    /// (which sets `_last_error`).
+    fn generate_ffi_byte_stream_read(
+        &self,
+        out: &mut String,
+        c_func_name: &str,
+    ) -> std::fmt::Result {
+        writeln!(out, "        #[allow(clippy::missing_safety_doc)]")?;
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(
+            out,
+            "        pub unsafe extern \"C\" fn {c_func_name}_read(stream: *mut std::ffi::c_void, buf: *mut u8, len: usize) -> isize {{"
+        )?;
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| unsafe {{"
+        )?;
+        writeln!(
+            out,
+            "                witffi_types::byte_stream_read(stream, buf, len)"
+        )?;
+        writeln!(out, "            }}));")?;
+        writeln!(out)?;
+        writeln!(out, "            match result {{")?;
+        writeln!(out, "                Ok(Ok(n)) => {{")?;
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+        )?;
+        writeln!(out, "                    n as isize")?;
+        writeln!(out, "                }}")?;
+        writeln!(out, "                Ok(Err(err)) => {{")?;
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = Some(err.to_string()));"
+        )?;
+        writeln!(out, "                    -1")?;
+        writeln!(out, "                }}")?;
+        self.generate_panic_arm(out, FfiPanicReturn::Count)?;
+        writeln!(out, "            }}")?;
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+        Ok(())
+    }
+
+    /// The owned counterpart of a trait parameter held in `expr`, or `None`
+    /// if the parameter is already owned.
+    fn into_owned_expr(&self, ty: &Type, expr: &str) -> Option<String> {
+        match ty {
+            Type::String => Some(format!("{expr}.to_string()")),
+            Type::Id(_) if witffi_core::wide_int(self.resolve, ty).is_some() => None,
+            Type::Id(id) => match &self.resolve.types[*id].kind {
+                TypeDefKind::List(Type::U8) => Some(format!("{expr}.to_vec()")),
+                TypeDefKind::Type(aliased) => self.into_owned_expr(aliased, expr),
+                TypeDefKind::Option(inner) => self
+                    .into_owned_expr(inner, "v")
+                    .map(|owned| format!("{expr}.map(|v| {owned})")),
+                TypeDefKind::FixedLengthList(inner, _) => self
+                    .into_owned_expr(inner, "v")
+                    .map(|owned| format!("{expr}.map(|v| {owned})")),
+                TypeDefKind::Tuple(tuple) => {
+                    let elems: Vec<Option<String>> = (0..tuple.types.len())
+                        .map(|i| self.into_owned_expr(&tuple.types[i], &format!("{expr}.{i}")))
+                        .collect();
+                    if elems.iter().all(Option::is_none) {
+                        return None;
+                    }
+                    let elems: Vec<String> = elems
+                        .into_iter()
+                        .enumerate()
+                        .map(|(i, owned)| owned.unwrap_or_else(|| format!("{expr}.{i}")))
+                        .collect();
+                    let trailing = if elems.len() == 1 { "," } else { "" };
+                    Some(format!("({}{trailing})", elems.join(", ")))
+                }
+                _ => None,
+            },
+            _ => None,
+        }
+    }
+
+    /// The idiomatic type of `ty` as named inside `witffi_register_ffi!`,
+    /// where resources are the registered implementation's associated types.
+    fn type_to_impl_idiomatic(&self, ty: &Type) -> String {
+        let trait_name = names::to_rust_type(&self.resolve.worlds[self.world_id].name);
+        self.type_to_idiomatic(ty)
+            .replace("Self::", &format!("<$impl_type as {trait_name}>::"))
+    }
+
+    /// Generate the `match result { ... }` converting the outcome of calling
+    /// the implementation (an idiomatic value, or the panic that replaced it)
+    /// into the C-ABI return value.
+    fn generate_ffi_result_match(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+    ) -> std::fmt::Result {
+        let result_decomposed = self.decompose_result(&ef.function.result);
+        let error_payload = ef.error_payload(self.resolve);
+
+        // Handle the result - convert idiomatic return to FFI
+        if let Some((ref ok_ty, _)) = result_decomposed {
+            let has_ok_value = ok_ty.is_some();
+
+            writeln!(out, "            match result {{")?;
+            writeln!(out, "                Ok(Ok(value)) => {{")?;
+            writeln!(
+                out,
+                "                    LAST_ERROR.with(|e| *e.borrow_mut() = None);"
+            )?;
+            if has_ok_value {
+                let ok_type = ok_ty.as_ref().unwrap();
+                let conversion = self.generate_to_ffi_expr(ok_type, "value");
+                if self.is_handle(ok_type) {
+                    writeln!(out, "                    {conversion}")?;
+                } else {
+                    writeln!(
+                        out,
+                        "                    {}",
+                        self.box_into_raw(&conversion)
+                    )?;
+                }
+            } else {
+                writeln!(out, "                    true")?;
+            }
+            writeln!(out, "                }}")?;
+            writeln!(out, "                Ok(Err(e)) => {{")?;
+            if let Some(err_ty) = error_payload {
+                // Typed errors keep a debug message for `_last_error` and hand
+                // the boxed payload to the caller, who frees it. Error
+                // contexts already carry a debug message.
+                let conversion = self.generate_to_ffi_expr(&err_ty, "e");
+                let message = if err_ty == Type::ErrorContext {
+                    "{e}"
+                } else {
+                    "{e:?}"
+                };
+                writeln!(
+                    out,
+                    "                    LAST_ERROR.with(|e_cell| *e_cell.borrow_mut() = Some(format!(\"{message}\")));"
+                )?;
+                writeln!(out, "                    if !err_out.is_null() {{")?;
+                writeln!(
+                    out,
+                    "                        unsafe {{ *err_out = {} }};",
+                    self.box_into_raw(&conversion)
+                )?;
+                writeln!(out, "                    }}")?;
+            } else {
+                writeln!(
+                    out,
+                    "                    LAST_ERROR.with(|e_cell| *e_cell.borrow_mut() = Some(format!(\"{{e}}\")));"
+                )?;
+            }
+            if has_ok_value {
+                writeln!(out, "                    std::ptr::null_mut()")?;
+            } else {
+                writeln!(out, "                    false")?;
+            }
+            writeln!(out, "                }}")?;
+            let panic_ret = if has_ok_value {
+                FfiPanicReturn::NullPtr
+            } else {
+                FfiPanicReturn::Bool
+            };
+            self.generate_panic_arm(out, panic_ret)?;
+            writeln!(out, "            }}")?;
+        } else {
+            writeln!(out, "            match result {{")?;
+            writeln!(out, "                Ok(value) => {{")?;
+            match &ef.function.result {
+                Some(ty) => {
+                    let conversion = self.generate_to_ffi_expr(ty, "value");
+                    writeln!(out, "                    {conversion}")?;
+                }
+                None => {
+                    writeln!(out, "                    value")?;
+                }
+            }
+            writeln!(out, "                }}")?;
+            let panic_ret = match &ef.function.result {
+                Some(ty) => FfiPanicReturn::Type(ty),
+                None => FfiPanicReturn::Unit,
+            };
+            self.generate_panic_arm(out, panic_ret)?;
+            writeln!(out, "            }}")?;
+        }
+
+        Ok(())
+    }
+
+    /// Generate the `Err(panic) => { ... }` arm for a C-ABI wrapper's
+    /// `catch_unwind` result.
+    ///
+    /// `error_value` determines the sentinel returned on panic:
+    /// - `FfiPanicReturn::NullPtr` — `std::ptr::null_mut()` (boxed result types)
+    /// - `FfiPanicReturn::Bool` — `false` (result types without an Ok payload)
+    /// - `FfiPanicReturn::Unit` — `()` (functions returning nothing)
+    /// - `FfiPanicReturn::Type(ty)` — a type-appropriate empty value
+    fn generate_panic_arm(
+        &self,
+        out: &mut String,
+        error_value: FfiPanicReturn<'_>,
+    ) -> std::fmt::Result {
+        writeln!(out, "                Err(panic) => {{")?;
+        writeln!(
+            out,
+            "                    let msg = if let Some(s) = panic.downcast_ref::<&str>() {{"
+        )?;
+        writeln!(out, "                        s.to_string()")?;
+        writeln!(
+            out,
+            "                    }} else if let Some(s) = panic.downcast_ref::<String>() {{"
+        )?;
+        writeln!(out, "                        s.clone()")?;
+        writeln!(out, "                    }} else {{")?;
+        writeln!(out, "                        \"unknown panic\".to_string()")?;
+        writeln!(out, "                    }};")?;
+        writeln!(
+            out,
+            "                    LAST_ERROR.with(|e| *e.borrow_mut() = Some(msg));"
+        )?;
+        writeln!(
+            out,
+            "                    LAST_PANIC.with(|p| *p.borrow_mut() = Some(witffi_types::take_panic_backtrace().unwrap_or_default()));"
+        )?;
+        let sentinel = match error_value {
+            FfiPanicReturn::NullPtr => "std::ptr::null_mut()".to_string(),
+            FfiPanicReturn::Bool => "false".to_string(),
+            FfiPanicReturn::Count => "-1".to_string(),
+            FfiPanicReturn::Unit => "()".to_string(),
+            FfiPanicReturn::Type(ty) => self.ffi_error_default(ty),
+        };
+        writeln!(out, "                    {sentinel}")?;
+        writeln!(out, "                }}")?;
+        Ok(())
+    }
+
+    /// Produce a default/empty expression suitable for a C-ABI return type on
+    /// error. For pointer types this is `null_mut`, for byte-buffer types an
+    /// empty buffer, and for primitives `Default::default()`.
+    fn ffi_error_default(&self, ty: &Type) -> String {
+        match ty {
+            Type::String => "witffi_types::FfiByteBuffer::from_string(String::new())".to_string(),
+            Type::Bool
+            | Type::U8
+            | Type::U16
+            | Type::U32
+            | Type::U64
+            | Type::S8
+            | Type::S16
+            | Type::S32
+            | Type::S64
+            | Type::F32
+            | Type::F64
+            | Type::Char => "Default::default()".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => {
+                        "witffi_types::FfiByteBuffer::from_vec(Vec::new())".to_string()
+                    }
+                    TypeDefKind::List(_) => {
+                        "witffi_types::FfiList::from_vec(Vec::new())".to_string()
+                    }
+                    TypeDefKind::Type(aliased) => self.ffi_error_default(aliased),
+                    TypeDefKind::Option(_) => "std::ptr::null_mut()".to_string(),
+                    TypeDefKind::Record(_) | TypeDefKind::Variant(_) | TypeDefKind::Handle(_) => {
+                        "std::ptr::null_mut()".to_string()
+                    }
+                    // Tuples, fixed-length lists and results returned by value
+                    // are plain repr(C) structs of integers, buffers and
+                    // pointers, for which all-zero is valid.
+                    TypeDefKind::Tuple(_)
+                    | TypeDefKind::FixedLengthList(..)
+                    | TypeDefKind::Result(_) => "unsafe { std::mem::zeroed() }".to_string(),
+                    // Enums have no default, so the first case stands in
+                    TypeDefKind::Enum(e) => {
+                        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                        format!(
+                            "{}::{}",
+                            names::to_c_type(&self.config.c_type_prefix, wit_name),
+                            names::to_rust_type(&e.cases[0].name)
+                        )
+                    }
+                    _ => "Default::default()".to_string(),
+                }
+            }
+            Type::ErrorContext => {
+                "witffi_types::FfiErrorContext { id: 0, debug_message: witffi_types::FfiByteBuffer::empty() }"
+                    .to_string()
+            }
+        }
+    }
+
+    fn generate_param_conversion(
+        &self,
+        out: &mut String,
+        c_name: &str,
+        ty: &Type,
+        indent: &str,
+    ) -> std::fmt::Result {
+        match ty {
+            Type::String => {
+                writeln!(
+                    out,
+                    "{indent}let {c_name}_rust = unsafe {{ {c_name}.as_str_unchecked() }};"
+                )?;
+            }
+            Type::Bool
+            | Type::U8
+            | Type::U16
+            | Type::U32
+            | Type::U64
+            | Type::S8
+            | Type::S16
+            | Type::S32
+            | Type::S64
+            | Type::F32
+            | Type::F64 => {
+                writeln!(out, "{indent}let {c_name}_rust = {c_name};")?;
+            }
+            Type::Char => {
+                // Not every u32 is a Unicode scalar value; reject the rest
+                // through the panic arm, like an enum naming no case
+                writeln!(
+                    out,
+                    "{indent}let {c_name}_rust = {};",
+                    char_from_ffi(c_name)
+                )?;
+            }
+            Type::Id(_) if witffi_core::wide_int(self.resolve, ty).is_some() => {
+                // Reassemble the (low, high) words flattened by `flatten_param`
+                let cast = match witffi_core::wide_int(self.resolve, ty) {
+                    Some(witffi_core::WideInt::S128) => " as i128",
+                    _ => "",
+                };
+                writeln!(
+                    out,
+                    "{indent}let {c_name}_rust = ((({c_name}_1 as u128) << 64) | {c_name}_0 as u128){cast};"
+                )?;
+            }
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => {
+                        writeln!(
+                            out,
+                            "{indent}let {c_name}_rust = unsafe {{ {c_name}.as_bytes() }};"
+                        )?;
+                    }
+                    TypeDefKind::Stream(Some(Type::U8)) => {
+                        // The caller serves reads until the call returns
+                        writeln!(
+                            out,
+                            "{indent}let {c_name}_rust = unsafe {{ witffi_types::ByteStream::new({c_name}) }};"
+                        )?;
+                    }
+                    TypeDefKind::List(elem) => {
+                        let elem_expr = self.owned_input_expr(elem, "e");
+                        writeln!(
+                            out,
+                            "{indent}let {c_name}_rust: Vec<_> = unsafe {{ {c_name}.as_slice() }}.iter().map(|e| {elem_expr}).collect();"
+                        )?;
+                    }
+                    TypeDefKind::Type(aliased) => {
+                        self.generate_param_conversion(out, c_name, aliased, indent)?;
+                    }
+                    TypeDefKind::Enum(_) => {
+                        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                        writeln!(
+                            out,
+                            "{indent}let {c_name}_rust = {}_from_ffi({c_name});",
+                            wit_name.to_snake_case()
+                        )?;
+                    }
+                    TypeDefKind::Option(inner) => {
+                        // Optional inputs are nullable pointers to the inner
+                        // input representation, borrowed for the call.
+                        let inner_expr = self.option_input_expr(inner, "v");
+                        writeln!(
+                            out,
+                            "{indent}let {c_name}_rust = unsafe {{ {c_name}.as_ref() }}.map(|v| {inner_expr});"
+                        )?;
+                    }
+                    TypeDefKind::Tuple(tuple) => {
+                        // Reassemble the flattened elements (see `flatten_param`)
+                        let mut elems = Vec::new();
+                        for (i, elem) in tuple.types.iter().enumerate() {
+                            let elem_name = format!("{c_name}_{i}");
+                            self.generate_param_conversion(out, &elem_name, elem, indent)?;
+                            elems.push(format!("{elem_name}_rust"));
+                        }
+                        let trailing = if elems.len() == 1 { "," } else { "" };
+                        writeln!(
+                            out,
+                            "{indent}let {c_name}_rust = ({}{trailing});",
+                            elems.join(", ")
+                        )?;
+                    }
+                    TypeDefKind::FixedLengthList(elem, len) => {
+                        // Reassemble the flattened elements (see `flatten_param`)
+                        let mut elems = Vec::new();
+                        for i in 0..*len {
+                            let elem_name = format!("{c_name}_{i}");
+                            self.generate_param_conversion(out, &elem_name, elem, indent)?;
+                            elems.push(format!("{elem_name}_rust"));
+                        }
+                        writeln!(out, "{indent}let {c_name}_rust = [{}];", elems.join(", "))?;
+                    }
+                    TypeDefKind::Handle(Handle::Own(resource_id)) => {
+                        let impl_path = self.resource_impl_path(*resource_id);
+                        writeln!(
+                            out,
+                            "{indent}let {c_name}_rust = unsafe {{ *{} }};",
+                            self.box_from_raw(&format!("{c_name} as *mut {impl_path}"))
+                        )?;
+                    }
+                    TypeDefKind::Handle(Handle::Borrow(resource_id)) => {
+                        let impl_path = self.resource_impl_path(*resource_id);
+                        writeln!(
+                            out,
+                            "{indent}let {c_name}_rust = unsafe {{ &*({c_name} as *const {impl_path}) }};"
+                        )?;
+                    }
+                    _ => {
+                        writeln!(out, "{indent}let {c_name}_rust = {c_name};")?;
+                    }
+                }
+            }
+            Type::ErrorContext => {
+                writeln!(
+                    out,
+                    "{indent}let {c_name}_rust = unsafe {{ witffi_types::ErrorContext::from_ffi_input(&{c_name}) }};"
+                )?;
+            }
+        }
+        Ok(())
+    }
+
+    /// Convert a reference `v` to the input representation of an optional
+    /// parameter's inner type into its trait parameter type.
+    fn option_input_expr(&self, ty: &Type, v: &str) -> String {
+        match ty {
+            Type::String => format!("unsafe {{ {v}.as_str_unchecked() }}"),
+            Type::Char => char_from_ffi(&format!("*{v}")),
+            Type::Id(_) if witffi_core::wide_int(self.resolve, ty).is_some() => {
+                let cast = match witffi_core::wide_int(self.resolve, ty) {
+                    Some(witffi_core::WideInt::S128) => " as i128",
+                    _ => "",
+                };
+                format!("((({v}.f1 as u128) << 64) | {v}.f0 as u128){cast}")
+            }
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => format!("unsafe {{ {v}.as_bytes() }}"),
+                    TypeDefKind::List(elem) => format!(
+                        "unsafe {{ {v}.as_slice() }}.iter().map(|e| {}).collect::<Vec<_>>()",
+                        self.owned_input_expr(elem, "e")
+                    ),
+                    TypeDefKind::FixedLengthList(elem, _) => format!(
+                        "{v}.elems.each_ref().map(|e| {})",
+                        self.option_input_expr(elem, "e")
+                    ),
+                    TypeDefKind::Tuple(tuple) => {
+                        let elems: Vec<String> = (0..tuple.types.len())
+                            .map(|i| {
+                                self.option_input_expr(&tuple.types[i], &format!("(&{v}.f{i})"))
+                            })
+                            .collect();
+                        let trailing = if elems.len() == 1 { "," } else { "" };
+                        format!("({}{trailing})", elems.join(", "))
+                    }
+                    TypeDefKind::Type(aliased) => self.option_input_expr(aliased, v),
+                    TypeDefKind::Enum(_) => {
+                        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                        format!("{}_from_ffi(*{v})", wit_name.to_snake_case())
+                    }
+                    _ => format!("*{v}"),
+                }
+            }
+            Type::ErrorContext => {
+                format!("unsafe {{ witffi_types::ErrorContext::from_ffi_input({v}) }}")
+            }
+            _ => format!("*{v}"),
+        }
+    }
+
+    /// Convert a reference `v` to the input representation of a list element
+    /// into its idiomatic type. Elements are copied out of the caller's
+    /// memory, so strings and bytes become owned.
+    fn owned_input_expr(&self, ty: &Type, v: &str) -> String {
+        if witffi_core::wide_int(self.resolve, ty).is_some() {
+            return self.option_input_expr(ty, v);
+        }
+        if let Type::Id(id) = ty {
+            match &self.resolve.types[*id].kind {
+                TypeDefKind::List(Type::U8) => {
+                    return format!("unsafe {{ {v}.as_bytes() }}.to_vec()");
+                }
+                TypeDefKind::Option(inner) => {
+                    return format!(
+                        "unsafe {{ (*{v}).as_ref() }}.map(|e| {})",
+                        self.owned_input_expr(inner, "e")
+                    );
+                }
+                TypeDefKind::Tuple(tuple) => {
+                    let elems: Vec<String> = (0..tuple.types.len())
+                        .map(|i| self.owned_input_expr(&tuple.types[i], &format!("(&{v}.f{i})")))
+                        .collect();
+                    let trailing = if elems.len() == 1 { "," } else { "" };
+                    return format!("({}{trailing})", elems.join(", "));
+                }
+                TypeDefKind::Type(aliased) => return self.owned_input_expr(aliased, v),
+                _ => {}
+            }
+        }
+        match ty {
+            Type::String => format!("unsafe {{ {v}.as_str_unchecked() }}.to_string()"),
+            _ => self.option_input_expr(ty, v),
+        }
+    }
+
+    // ---- witffi_register_jni! macro generation ----
+
+    fn generate_register_jni_macro(&self, out: &mut String) -> std::fmt::Result {
+        let world = &self.resolve.worlds[self.world_id];
+        let trait_name = names::to_rust_type(&world.name);
+
+        // Derive Kotlin package from WIT package name or config
+        let kotlin_package = self
+            .config
+            .kotlin_package
+            .clone()
+            .unwrap_or_else(|| self.derive_kotlin_package());
+        let jni_class_path = kotlin_package.replace('.', "/");
+        let world_class = names::to_rust_type(&world.name);
+
+        writeln!(out, "// ---- JNI Registration macro ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "/// Register a concrete type for JNI (Kotlin/Android consumers)."
+        )?;
+        writeln!(out, "///")?;
+        writeln!(
+            out,
+            "/// This macro generates `Java_` JNI entry points, JVM object"
+        )?;
+        writeln!(
+            out,
+            "/// construction helpers, and exception-based error handling."
+        )?;
+        writeln!(out, "///")?;
+        writeln!(
+            out,
+            "/// Requires the `jni` crate as a dependency of the FFI crate."
+        )?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// # Example")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// ```ignore")?;
+        writeln!(out, "/// struct MyImpl;")?;
+        writeln!(out, "/// impl {trait_name} for MyImpl {{")?;
+        writeln!(out, "///     // ... implement trait methods ...")?;
+        writeln!(out, "/// }}")?;
+        writeln!(out, "/// witffi_register_jni!(MyImpl);")?;
+        writeln!(out, "/// ```")?;
+        writeln!(out, "#[macro_export]")?;
+        writeln!(out, "macro_rules! witffi_register_jni {{")?;
+        writeln!(out, "    ($impl_type:ty) => {{")?;
+
+        // Generate JNI conversion helpers for each record/variant type
+        self.generate_jni_conversion_helpers(out, &kotlin_package)?;
+
+        // Generate JNI entry points (resources, async functions, streams,
+        // futures and error contexts are not yet supported over JNI)
+        let funcs = exported_functions(self.resolve, self.world_id);
+        for ef in funcs.iter().filter(|ef| {
+            ef.resource().is_none()
+                && !ef.is_awaited(self.resolve)
+                && ef.stream_item(self.resolve).is_none()
+                && !ef.takes_byte_streams(self.resolve)
+                && !ef.uses_error_context(self.resolve)
+        }) {
+            self.generate_jni_entry_point(out, ef, &jni_class_path, &world_class)?;
+        }
+
+        // Trim trailing blank line before closing brace
+        if out.ends_with("\n\n") {
+            out.pop();
+        }
+        writeln!(out, "    }};")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    fn derive_kotlin_package(&self) -> String {
+        let world = &self.resolve.worlds[self.world_id];
+        // Try to get the package name from the WIT world's package
+        if let Some(pkg_id) = world.package {
+            let pkg = &self.resolve.packages[pkg_id];
+            let ns = &pkg.name.namespace;
+            let name = &pkg.name.name;
+            return format!("{ns}.{name}");
+        }
+        // Fallback to the world name
+        world.name.clone()
+    }
+
+    fn generate_jni_conversion_helpers(
+        &self,
+        out: &mut String,
+        kotlin_package: &str,
+    ) -> std::fmt::Result {
+        let jni_pkg = kotlin_package.replace('.', "/");
+
+        writeln!(out, "        // ---- JNI conversion helpers ----")?;
+        writeln!(out)?;
+
+        let reachable = self.collect_reachable_types();
+        for type_id in &reachable {
+            let typedef = &self.resolve.types[*type_id];
+            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+
+            match &typedef.kind {
+                TypeDefKind::Record(record) => {
+                    let rust_name = names::to_rust_type(wit_name);
+                    let kotlin_name = names::to_kotlin_type(wit_name);
+                    let fn_name = format!("to_jni_{}", wit_name.to_snake_case());
+                    let class_path = format!("{jni_pkg}/{kotlin_name}");
+
+                    writeln!(out, "        fn {fn_name}<'local>(")?;
+                    writeln!(out, "            env: &mut jni::JNIEnv<'local>,")?;
+                    writeln!(out, "            value: &{rust_name},")?;
+                    writeln!(
+                        out,
+                        "        ) -> jni::errors::Result<jni::objects::JObject<'local>> {{"
+                    )?;
+
+                    // Build constructor args
+                    for field in &record.fields {
+                        let field_name = names::to_rust_ident(&field.name);
+                        let jni_conv = self
+                            .generate_to_jni_field_expr(&field.ty, &format!("value.{field_name}"));
+                        writeln!(out, "            let _{field_name} = {jni_conv};")?;
+                    }
+
+                    // Build JNI descriptor
+                    let descriptor = self.record_jni_constructor_descriptor(record, kotlin_package);
+
+                    // Build JValue array
+                    writeln!(out, "            let args: &[jni::objects::JValue] = &[")?;
+                    for field in &record.fields {
+                        let field_name = names::to_rust_ident(&field.name);
+                        let jvalue = self.type_to_jvalue(&field.ty, &format!("_{field_name}"));
+                        writeln!(out, "                {jvalue},")?;
+                    }
+                    writeln!(out, "            ];")?;
+
+                    writeln!(
+                        out,
+                        "            env.new_object(\"{class_path}\", \"{descriptor}\", args)"
+                    )?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+
+                TypeDefKind::Variant(variant) => {
+                    let rust_name = names::to_rust_type(wit_name);
+                    let kotlin_name = names::to_kotlin_type(wit_name);
+                    let fn_name = format!("to_jni_{}", wit_name.to_snake_case());
+                    let base_class_path = format!("{jni_pkg}/{kotlin_name}");
+
+                    writeln!(out, "        fn {fn_name}<'local>(")?;
+                    writeln!(out, "            env: &mut jni::JNIEnv<'local>,")?;
+                    writeln!(out, "            value: &{rust_name},")?;
+                    writeln!(
+                        out,
+                        "        ) -> jni::errors::Result<jni::objects::JObject<'local>> {{"
+                    )?;
+                    writeln!(out, "            match value {{")?;
+
+                    for case in &variant.cases {
+                        let case_name = names::to_rust_type(&case.name);
+                        let kotlin_case = names::to_kotlin_type(&case.name);
+                        let inner_class = format!("{base_class_path}${kotlin_case}");
+
+                        if let Some(ty) = &case.ty {
+                            let jni_conv = self.generate_to_jni_field_expr(ty, "inner");
+                            let descriptor =
+                                format!("({})V", self.type_to_jni_descriptor(ty, kotlin_package));
+                            writeln!(out, "                {rust_name}::{case_name}(inner) => {{")?;
+                            writeln!(out, "                    let _inner = {jni_conv};")?;
+                            let jvalue = self.type_to_jvalue(ty, "_inner");
+                            writeln!(
+                                out,
+                                "                    env.new_object(\"{inner_class}\", \"{descriptor}\", &[{jvalue}])"
+                            )?;
+                            writeln!(out, "                }}")?;
+                        } else {
+                            writeln!(out, "                {rust_name}::{case_name} => {{")?;
+                            writeln!(
+                                out,
+                                "                    env.new_object(\"{inner_class}\", \"()V\", &[])"
+                            )?;
+                            writeln!(out, "                }}")?;
+                        }
+                    }
+
+                    writeln!(out, "            }}")?;
+                    writeln!(out, "        }}")?;
+                    writeln!(out)?;
+                }
+
+                _ => {}
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Generate a JNI field expression for converting a Rust value.
+    fn generate_to_jni_field_expr(&self, ty: &Type, expr: &str) -> String {
+        match ty {
+            Type::String => format!("env.new_string(&{expr})?"),
+            Type::Bool
+            | Type::U8
+            | Type::U16
+            | Type::U32
+            | Type::U64
+            | Type::S8
+            | Type::S16
+            | Type::S32
+            | Type::S64
+            | Type::F32
+            | Type::F64 => expr.to_string(),
+            Type::Char => format!("{expr} as u16"),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => {
+                        format!("env.byte_array_from_slice(&{expr})?")
+                    }
+                    TypeDefKind::Option(inner) => {
+                        let inner_expr = self.generate_to_jni_field_expr(inner, "v");
+                        let is_primitive = self.is_jni_primitive(inner);
+                        if is_primitive {
+                            let boxed_class = self.type_to_jni_boxed_class(inner);
+                            let box_descriptor = self.type_to_jni_box_descriptor(inner);
+                            let jval = self.type_to_jvalue_primitive(inner, "v");
+                            format!(
+                                "match &{expr} {{ Some(v) => env.new_object(\"{boxed_class}\", \"{box_descriptor}\", &[{jval}])?, None => jni::objects::JObject::null() }}"
+                            )
+                        } else {
+                            format!(
+                                "match &{expr} {{ Some(v) => {{ let _v = {inner_expr}; jni::objects::JObject::from(_v) }}, None => jni::objects::JObject::null() }}"
+                            )
+                        }
+                    }
+                    TypeDefKind::Type(aliased) => self.generate_to_jni_field_expr(aliased, expr),
+                    TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
+                        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                        let fn_name = format!("to_jni_{}", wit_name.to_snake_case());
+                        format!("{fn_name}(env, &{expr})?")
+                    }
+                    _ => expr.to_string(),
+                }
+            }
+            Type::ErrorContext => format!("env.new_string(&{expr})?"),
+        }
+    }
+
+    fn is_jni_primitive(&self, ty: &Type) -> bool {
+        matches!(
+            ty,
+            Type::Bool
+                | Type::U8
+                | Type::U16
                 | Type::U32
                 | Type::U64
                 | Type::S8
@@ -1589,869 +4219,4387 @@ impl<'a> RustGenerator<'a> {
         )
     }
 
-    fn type_to_jni_boxed_class(&self, ty: &Type) -> &'static str {
-        match ty {
-            Type::Bool => "java/lang/Boolean",
-            Type::U8 | Type::S8 => "java/lang/Byte",
-            Type::U16 | Type::S16 => "java/lang/Short",
-            Type::U32 | Type::S32 => "java/lang/Integer",
-            Type::U64 | Type::S64 => "java/lang/Long",
-            Type::F32 => "java/lang/Float",
-            Type::F64 => "java/lang/Double",
-            Type::Char => "java/lang/Character",
-            _ => "java/lang/Object",
-        }
+    fn type_to_jni_boxed_class(&self, ty: &Type) -> &'static str {
+        match ty {
+            Type::Bool => "java/lang/Boolean",
+            Type::U8 | Type::S8 => "java/lang/Byte",
+            Type::U16 | Type::S16 => "java/lang/Short",
+            Type::U32 | Type::S32 => "java/lang/Integer",
+            Type::U64 | Type::S64 => "java/lang/Long",
+            Type::F32 => "java/lang/Float",
+            Type::F64 => "java/lang/Double",
+            Type::Char => "java/lang/Character",
+            _ => "java/lang/Object",
+        }
+    }
+
+    fn type_to_jni_box_descriptor(&self, ty: &Type) -> &'static str {
+        match ty {
+            Type::Bool => "(Z)V",
+            Type::U8 | Type::S8 => "(B)V",
+            Type::U16 | Type::S16 => "(S)V",
+            Type::U32 | Type::S32 => "(I)V",
+            Type::U64 | Type::S64 => "(J)V",
+            Type::F32 => "(F)V",
+            Type::F64 => "(D)V",
+            Type::Char => "(C)V",
+            _ => "(Ljava/lang/Object;)V",
+        }
+    }
+
+    fn type_to_jvalue_primitive(&self, ty: &Type, expr: &str) -> String {
+        match ty {
+            Type::Bool => format!("jni::objects::JValue::Bool(*{expr} as u8)"),
+            Type::U8 | Type::S8 => format!("jni::objects::JValue::Byte(*{expr} as i8)"),
+            Type::U16 | Type::S16 => format!("jni::objects::JValue::Short(*{expr} as i16)"),
+            Type::U32 | Type::S32 => format!("jni::objects::JValue::Int(*{expr} as i32)"),
+            Type::U64 | Type::S64 => format!("jni::objects::JValue::Long(*{expr} as i64)"),
+            Type::F32 => format!("jni::objects::JValue::Float(*{expr})"),
+            Type::F64 => format!("jni::objects::JValue::Double(*{expr})"),
+            Type::Char => format!("jni::objects::JValue::Char(*{expr} as u16)"),
+            _ => "jni::objects::JValue::Object(&jni::objects::JObject::null())".to_string(),
+        }
+    }
+
+    fn type_to_jvalue(&self, ty: &Type, expr: &str) -> String {
+        match ty {
+            Type::Bool => format!("jni::objects::JValue::Bool({expr} as u8)"),
+            Type::U8 | Type::S8 => format!("jni::objects::JValue::Byte({expr} as i8)"),
+            Type::U16 | Type::S16 => format!("jni::objects::JValue::Short({expr} as i16)"),
+            Type::U32 | Type::S32 => format!("jni::objects::JValue::Int({expr} as i32)"),
+            Type::U64 | Type::S64 => format!("jni::objects::JValue::Long({expr} as i64)"),
+            Type::F32 => format!("jni::objects::JValue::Float({expr})"),
+            Type::F64 => format!("jni::objects::JValue::Double({expr})"),
+            Type::Char => format!("jni::objects::JValue::Char({expr} as u16)"),
+            Type::String => format!("jni::objects::JValue::Object(&{expr})"),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => {
+                        format!("jni::objects::JValue::Object(&{expr})")
+                    }
+                    TypeDefKind::Option(_) => {
+                        format!("jni::objects::JValue::Object(&{expr})")
+                    }
+                    TypeDefKind::Type(aliased) => self.type_to_jvalue(aliased, expr),
+                    TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
+                        format!("jni::objects::JValue::Object(&{expr})")
+                    }
+                    _ => format!("jni::objects::JValue::Object(&{expr})"),
+                }
+            }
+            Type::ErrorContext => format!("jni::objects::JValue::Object(&{expr})"),
+        }
+    }
+
+    fn type_to_jni_descriptor(&self, ty: &Type, kotlin_package: &str) -> String {
+        let jni_pkg = kotlin_package.replace('.', "/");
+        match ty {
+            Type::Bool => "Z".to_string(),
+            Type::U8 | Type::S8 => "B".to_string(),
+            Type::U16 | Type::S16 => "S".to_string(),
+            Type::U32 | Type::S32 => "I".to_string(),
+            Type::U64 | Type::S64 => "J".to_string(),
+            Type::F32 => "F".to_string(),
+            Type::F64 => "D".to_string(),
+            Type::Char => "C".to_string(),
+            Type::String => "Ljava/lang/String;".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => "[B".to_string(),
+                    TypeDefKind::List(_) => "[Ljava/lang/Object;".to_string(),
+                    TypeDefKind::Option(inner) => {
+                        if self.is_jni_primitive(inner) {
+                            format!("L{};", self.type_to_jni_boxed_class(inner))
+                        } else {
+                            self.type_to_jni_descriptor(inner, kotlin_package)
+                        }
+                    }
+                    TypeDefKind::Type(aliased) => {
+                        self.type_to_jni_descriptor(aliased, kotlin_package)
+                    }
+                    _ => {
+                        let name = typedef.name.as_deref().unwrap_or("Object");
+                        let kotlin_name = names::to_kotlin_type(name);
+                        format!("L{jni_pkg}/{kotlin_name};")
+                    }
+                }
+            }
+            Type::ErrorContext => "Ljava/lang/String;".to_string(),
+        }
+    }
+
+    fn record_jni_constructor_descriptor(
+        &self,
+        record: &wit_parser::Record,
+        kotlin_package: &str,
+    ) -> String {
+        let params: Vec<String> = record
+            .fields
+            .iter()
+            .map(|f| self.type_to_jni_descriptor(&f.ty, kotlin_package))
+            .collect();
+        format!("({})V", params.join(""))
+    }
+
+    fn generate_jni_entry_point(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        jni_class_path: &str,
+        world_class: &str,
+    ) -> std::fmt::Result {
+        let trait_method = self.trait_method_name(ef);
+        let result_decomposed = self.decompose_result(&ef.function.result);
+
+        // Build the JNI function name
+        // The Kotlin generator emits @JvmStatic on the companion's native method,
+        // so the JVM resolves it as a static method on the outer class.
+        // Convention: Java_{package}_{Class}_native{Method}
+        let method_pascal = names::to_rust_type(&if ef.interface_name.is_empty() {
+            ef.function_name.clone()
+        } else {
+            format!("{}-{}", ef.interface_name, ef.function_name)
+        });
+        let jni_func_name = format!(
+            "Java_{}_{world_class}_native{method_pascal}",
+            jni_class_path.replace('/', "_"),
+        );
+
+        // Build JNI parameter list
+        let mut jni_params = vec![
+            "mut env: jni::JNIEnv<'local>".to_string(),
+            "_class: jni::objects::JClass<'local>".to_string(),
+        ];
+        for p in &ef.function.params {
+            let name = names::to_rust_ident(&p.name);
+            let jni_ty = self.type_to_jni_param_type(&p.ty);
+            jni_params.push(format!("{name}: {jni_ty}"));
+        }
+
+        // Determine return type
+        let jni_return = if result_decomposed.is_some() {
+            "jni::sys::jobject".to_string()
+        } else {
+            match &ef.function.result {
+                Some(ty) => self.type_to_jni_return_type(ty),
+                None => "()".to_string(),
+            }
+        };
+
+        writeln!(out, "        #[unsafe(no_mangle)]")?;
+        writeln!(out, "        pub extern \"C\" fn {jni_func_name}<'local>(")?;
+        for param in &jni_params {
+            writeln!(out, "            {param},")?;
+        }
+        if jni_return == "()" {
+            writeln!(out, "        ) {{")?;
+        } else {
+            writeln!(out, "        ) -> {jni_return} {{")?;
+        }
+
+        // Rebind env as &mut so it can be used before and after catch_unwind
+        // without being moved into the closure.
+        writeln!(out, "            let env = &mut env;")?;
+        writeln!(out)?;
+
+        // Step 1: Convert JNI params to Rust types (uses env directly)
+        let early_return = if jni_return == "()" {
+            "return".to_string()
+        } else if jni_return == "jni::sys::jobject" {
+            "return std::ptr::null_mut()".to_string()
+        } else {
+            "return Default::default()".to_string()
+        };
+        for p in &ef.function.params {
+            let name = names::to_rust_ident(&p.name);
+            self.generate_jni_param_conversion_safe(out, &name, &p.ty, &early_return)?;
+        }
+
+        let rust_args: Vec<String> = ef
+            .function
+            .params
+            .iter()
+            .map(|p| {
+                let name = format!("{}_rust", names::to_rust_ident(&p.name));
+                if self.jni_arg_needs_borrow(&p.ty) {
+                    format!("&{name}")
+                } else {
+                    name
+                }
+            })
+            .collect();
+
+        // Step 2: Call the trait method inside catch_unwind (pure Rust, no env)
+        writeln!(
+            out,
+            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+        )?;
+        writeln!(
+            out,
+            "                <$impl_type>::{trait_method}({})",
+            rust_args.join(", ")
+        )?;
+        writeln!(out, "            }}));")?;
+        writeln!(out)?;
+
+        // Step 3: Handle result and convert to JNI (uses env directly)
+        if let Some((ref ok_ty, _)) = result_decomposed {
+            writeln!(out, "            match result {{")?;
+            writeln!(out, "                Ok(Ok(value)) => {{")?;
+            if let Some(ty) = ok_ty {
+                let jni_conv = self.generate_to_jni_field_expr(ty, "value");
+                // Wrap conversion in a closure so `?` works inside it
+                writeln!(
+                    out,
+                    "                    let mut convert = || -> jni::errors::Result<jni::sys::jobject> {{"
+                )?;
+                writeln!(out, "                        let obj = {jni_conv};")?;
+                writeln!(out, "                        Ok(obj.into_raw())")?;
+                writeln!(out, "                    }};")?;
+                writeln!(out, "                    match convert() {{")?;
+                writeln!(out, "                        Ok(ptr) => ptr,")?;
+                writeln!(out, "                        Err(e) => {{")?;
+                writeln!(
+                    out,
+                    "                            let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
+                )?;
+                writeln!(out, "                            std::ptr::null_mut()")?;
+                writeln!(out, "                        }}")?;
+                writeln!(out, "                    }}")?;
+            } else {
+                writeln!(out, "                    std::ptr::null_mut()")?;
+            }
+            writeln!(out, "                }}")?;
+            writeln!(out, "                Ok(Err(e)) => {{")?;
+            writeln!(
+                out,
+                "                    let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
+            )?;
+            writeln!(out, "                    std::ptr::null_mut()")?;
+            writeln!(out, "                }}")?;
+            writeln!(out, "                Err(_panic) => {{")?;
+            writeln!(
+                out,
+                "                    let _ = env.throw_new(\"java/lang/RuntimeException\", \"Rust panic\");"
+            )?;
+            writeln!(out, "                    std::ptr::null_mut()")?;
+            writeln!(out, "                }}")?;
+            writeln!(out, "            }}")?;
+        } else {
+            writeln!(out, "            match result {{")?;
+            let is_primitive_return = match &ef.function.result {
+                Some(ty) => self.is_jni_primitive(ty),
+                None => true,
+            };
+            if is_primitive_return {
+                writeln!(out, "                Ok(value) => value,")?;
+            } else {
+                let ty = ef.function.result.as_ref().unwrap();
+                let jni_conv = self.generate_to_jni_field_expr(ty, "value");
+                writeln!(out, "                Ok(value) => {{")?;
+                writeln!(
+                    out,
+                    "                    let convert = || -> jni::errors::Result<jni::sys::jobject> {{"
+                )?;
+                writeln!(out, "                        let obj = {jni_conv};")?;
+                writeln!(out, "                        Ok(obj.into_raw())")?;
+                writeln!(out, "                    }};")?;
+                writeln!(out, "                    match convert() {{")?;
+                writeln!(out, "                        Ok(ptr) => ptr,")?;
+                writeln!(out, "                        Err(e) => {{")?;
+                writeln!(
+                    out,
+                    "                            let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
+                )?;
+                writeln!(out, "                            std::ptr::null_mut()")?;
+                writeln!(out, "                        }}")?;
+                writeln!(out, "                    }}")?;
+                writeln!(out, "                }}")?;
+            }
+            writeln!(out, "                Err(_panic) => {{")?;
+            writeln!(
+                out,
+                "                    let _ = env.throw_new(\"java/lang/RuntimeException\", \"Rust panic\");"
+            )?;
+            if is_primitive_return {
+                writeln!(out, "                    Default::default()")?;
+            } else {
+                writeln!(out, "                    std::ptr::null_mut()")?;
+            }
+            writeln!(out, "                }}")?;
+            writeln!(out, "            }}")?;
+        }
+
+        writeln!(out, "        }}")?;
+        writeln!(out)?;
+
+        Ok(())
+    }
+
+    fn type_to_jni_param_type(&self, ty: &Type) -> String {
+        match ty {
+            Type::String => "jni::objects::JString<'local>".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => "jni::objects::JByteArray<'local>".to_string(),
+                    TypeDefKind::Type(aliased) => self.type_to_jni_param_type(aliased),
+                    _ => "jni::objects::JObject<'local>".to_string(),
+                }
+            }
+            Type::Bool => "jni::sys::jboolean".to_string(),
+            Type::U8 | Type::S8 => "jni::sys::jbyte".to_string(),
+            Type::U16 | Type::S16 => "jni::sys::jshort".to_string(),
+            Type::U32 | Type::S32 => "jni::sys::jint".to_string(),
+            Type::U64 | Type::S64 => "jni::sys::jlong".to_string(),
+            Type::F32 => "jni::sys::jfloat".to_string(),
+            Type::F64 => "jni::sys::jdouble".to_string(),
+            _ => "jni::objects::JObject<'local>".to_string(),
+        }
+    }
+
+    fn type_to_jni_return_type(&self, ty: &Type) -> String {
+        match ty {
+            Type::Bool => "jni::sys::jboolean".to_string(),
+            Type::U8 | Type::S8 => "jni::sys::jbyte".to_string(),
+            Type::U16 | Type::S16 => "jni::sys::jshort".to_string(),
+            Type::U32 | Type::S32 => "jni::sys::jint".to_string(),
+            Type::U64 | Type::S64 => "jni::sys::jlong".to_string(),
+            Type::F32 => "jni::sys::jfloat".to_string(),
+            Type::F64 => "jni::sys::jdouble".to_string(),
+            _ => "jni::sys::jobject".to_string(),
+        }
+    }
+
+    /// Check if a JNI-converted arg needs a borrow when passed to the trait method.
+    ///
+    /// The JNI conversion produces owned types (e.g. `String`, `Vec<u8>`) but
+    /// the trait method uses borrowed types (`&str`, `&[u8]`) for these.
+    fn jni_arg_needs_borrow(&self, ty: &Type) -> bool {
+        match ty {
+            Type::String => true,
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => true,
+                    TypeDefKind::Type(aliased) => self.jni_arg_needs_borrow(aliased),
+                    _ => false,
+                }
+            }
+            _ => false,
+        }
+    }
+
+    /// Generate JNI parameter conversion code that is safe to use outside of
+    /// a `Result`-returning context. Instead of using `?`, conversion errors
+    /// throw a Java exception and return early with `early_return`.
+    fn generate_jni_param_conversion_safe(
+        &self,
+        out: &mut String,
+        name: &str,
+        ty: &Type,
+        early_return: &str,
+    ) -> std::fmt::Result {
+        match ty {
+            Type::String => {
+                writeln!(
+                    out,
+                    "            let {name}_rust: String = match env.get_string(&{name}) {{"
+                )?;
+                writeln!(out, "                Ok(s) => s.into(),")?;
+                writeln!(out, "                Err(e) => {{")?;
+                writeln!(
+                    out,
+                    "                    let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
+                )?;
+                writeln!(out, "                    {early_return};")?;
+                writeln!(out, "                }}")?;
+                writeln!(out, "            }};")?;
+            }
+            Type::Bool => {
+                writeln!(out, "            let {name}_rust = {name} != 0;")?;
+            }
+            Type::U8 => writeln!(out, "            let {name}_rust = {name} as u8;")?,
+            Type::U16 => writeln!(out, "            let {name}_rust = {name} as u16;")?,
+            Type::U32 => writeln!(out, "            let {name}_rust = {name} as u32;")?,
+            Type::U64 => writeln!(out, "            let {name}_rust = {name} as u64;")?,
+            Type::S8 => writeln!(out, "            let {name}_rust = {name} as i8;")?,
+            Type::S16 => writeln!(out, "            let {name}_rust = {name} as i16;")?,
+            Type::S32 => writeln!(out, "            let {name}_rust = {name} as i32;")?,
+            Type::S64 => writeln!(out, "            let {name}_rust = {name} as i64;")?,
+            Type::F32 | Type::F64 => {
+                writeln!(out, "            let {name}_rust = {name};")?;
+            }
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => {
+                        writeln!(
+                            out,
+                            "            let {name}_rust = match env.convert_byte_array(&{name}) {{"
+                        )?;
+                        writeln!(out, "                Ok(v) => v,")?;
+                        writeln!(out, "                Err(e) => {{")?;
+                        writeln!(
+                            out,
+                            "                    let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
+                        )?;
+                        writeln!(out, "                    {early_return};")?;
+                        writeln!(out, "                }}")?;
+                        writeln!(out, "            }};")?;
+                    }
+                    TypeDefKind::Type(aliased) => {
+                        self.generate_jni_param_conversion_safe(out, name, aliased, early_return)?;
+                    }
+                    _ => {
+                        writeln!(out, "            let {name}_rust = {name};")?;
+                    }
+                }
+            }
+            _ => {
+                writeln!(out, "            let {name}_rust = {name};")?;
+            }
+        }
+        Ok(())
+    }
+
+    // ---- witffi_register_wasm! macro generation ----
+
+    fn generate_register_wasm_macro(&self, out: &mut String) -> std::fmt::Result {
+        let world = &self.resolve.worlds[self.world_id];
+        let trait_name = names::to_rust_type(&world.name);
+
+        writeln!(out, "// ---- Wasm Registration macro ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "/// Register a concrete type for WebAssembly (Go consumers running it under"
+        )?;
+        writeln!(out, "/// wazero).")?;
+        writeln!(out, "///")?;
+        writeln!(
+            out,
+            "/// Built for `wasm32-wasip1`, this macro exports each freestanding function"
+        )?;
+        writeln!(
+            out,
+            "/// passing numbers, bools, strings and `list<u8>` under its component export"
+        )?;
+        writeln!(
+            out,
+            "/// name, following the canonical ABI, with the `cabi_realloc` its callers"
+        )?;
+        writeln!(
+            out,
+            "/// allocate arguments through. It expands to nothing for other targets."
+        )?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// # Example")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// ```ignore")?;
+        writeln!(out, "/// struct MyImpl;")?;
+        writeln!(out, "/// impl {trait_name} for MyImpl {{")?;
+        writeln!(out, "///     // ... implement trait methods ...")?;
+        writeln!(out, "/// }}")?;
+        writeln!(out, "/// witffi_register_wasm!(MyImpl);")?;
+        writeln!(out, "/// ```")?;
+        writeln!(out, "#[macro_export]")?;
+        writeln!(out, "macro_rules! witffi_register_wasm {{")?;
+        writeln!(out, "    ($impl_type:ty) => {{")?;
+        writeln!(out, "        #[cfg(target_family = \"wasm\")]")?;
+        writeln!(
+            out,
+            "        // The helpers go unused when no function passes what they convert"
+        )?;
+        writeln!(out, "        #[allow(dead_code)]")?;
+        writeln!(out, "        const _: () = {{")?;
+
+        let area_size = canonical::RETURN_AREA_SIZE;
+        writeln!(
+            out,
+            "            // Results too big for a core value are written here, and read"
+        )?;
+        writeln!(out, "            // by the caller before the next call")?;
+        writeln!(out, "            #[repr(align(8))]")?;
+        writeln!(out, "            struct ReturnArea([u8; {area_size}]);")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            static mut RETURN_AREA: ReturnArea = ReturnArea([0; {area_size}]);"
+        )?;
+        writeln!(out)?;
+        writeln!(out, "            #[unsafe(no_mangle)]")?;
+        writeln!(out, "            pub unsafe extern \"C\" fn cabi_realloc(")?;
+        writeln!(out, "                old_ptr: *mut u8,")?;
+        writeln!(out, "                old_size: usize,")?;
+        writeln!(out, "                align: usize,")?;
+        writeln!(out, "                new_size: usize,")?;
+        writeln!(out, "            ) -> *mut u8 {{")?;
+        writeln!(out, "                if new_size == 0 {{")?;
+        writeln!(out, "                    return align as *mut u8;")?;
+        writeln!(out, "                }}")?;
+        writeln!(
+            out,
+            "                let layout = std::alloc::Layout::from_size_align(new_size, align)"
+        )?;
+        writeln!(
+            out,
+            "                    .expect(\"cabi_realloc: invalid layout\");"
+        )?;
+        writeln!(out, "                let ptr = if old_size == 0 {{")?;
+        writeln!(
+            out,
+            "                    unsafe {{ std::alloc::alloc(layout) }}"
+        )?;
+        writeln!(out, "                }} else {{")?;
+        writeln!(out, "                    unsafe {{")?;
+        writeln!(out, "                        std::alloc::realloc(")?;
+        writeln!(out, "                            old_ptr,")?;
+        writeln!(
+            out,
+            "                            std::alloc::Layout::from_size_align_unchecked(old_size, align),"
+        )?;
+        writeln!(out, "                            new_size,")?;
+        writeln!(out, "                        )")?;
+        writeln!(out, "                    }}")?;
+        writeln!(out, "                }};")?;
+        writeln!(out, "                if ptr.is_null() {{")?;
+        writeln!(
+            out,
+            "                    std::alloc::handle_alloc_error(layout);"
+        )?;
+        writeln!(out, "                }}")?;
+        writeln!(out, "                ptr")?;
+        writeln!(out, "            }}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            /// Take the bytes the caller allocated with `cabi_realloc`."
+        )?;
+        writeln!(
+            out,
+            "            unsafe fn take_bytes(ptr: *mut u8, len: usize) -> Vec<u8> {{"
+        )?;
+        writeln!(
+            out,
+            "                unsafe {{ Vec::from_raw_parts(ptr, len, len) }}"
+        )?;
+        writeln!(out, "            }}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            /// Write `bytes` to `at` as a pointer and a length, leaking them"
+        )?;
+        writeln!(out, "            /// until `free_buffer`.")?;
+        writeln!(
+            out,
+            "            unsafe fn write_buffer(at: *mut u8, bytes: Vec<u8>) {{"
+        )?;
+        writeln!(
+            out,
+            "                let bytes = Box::into_raw(bytes.into_boxed_slice());"
+        )?;
+        writeln!(out, "                unsafe {{")?;
+        writeln!(
+            out,
+            "                    at.cast::<*mut u8>().write(bytes.cast::<u8>());"
+        )?;
+        writeln!(
+            out,
+            "                    at.add(4).cast::<usize>().write(bytes.len());"
+        )?;
+        writeln!(out, "                }}")?;
+        writeln!(out, "            }}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            /// Free the bytes `write_buffer` wrote to `at`."
+        )?;
+        writeln!(out, "            unsafe fn free_buffer(at: *mut u8) {{")?;
+        writeln!(out, "                unsafe {{")?;
+        writeln!(
+            out,
+            "                    let ptr = at.cast::<*mut u8>().read();"
+        )?;
+        writeln!(
+            out,
+            "                    let len = at.add(4).cast::<usize>().read();"
+        )?;
+        writeln!(
+            out,
+            "                    drop(Box::from_raw(std::ptr::slice_from_raw_parts_mut(ptr, len)));"
+        )?;
+        writeln!(out, "                }}")?;
+        writeln!(out, "            }}")?;
+
+        for ef in exported_functions(self.resolve, self.world_id) {
+            if let Some(sig) = canonical::wasm_signature(self.resolve, &ef) {
+                writeln!(out)?;
+                self.generate_wasm_export(out, &ef, &sig)?;
+            }
+        }
+
+        writeln!(out, "        }};")?;
+        writeln!(out, "    }};")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the export of `ef` and, for a result returned through the
+    /// return area, its `cabi_post_` function.
+    fn generate_wasm_export(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        sig: &ImportSignature,
+    ) -> std::fmt::Result {
+        let export_name = canonical::export_name(self.resolve, self.world_id, ef);
+        let fn_name = format!(
+            "wasm_{}",
+            ef.c_func_name(self.resolve, &self.config.c_prefix)
+        );
+        let trait_method = self.trait_method_name(ef);
+
+        let mut params = Vec::new();
+        let mut lifts = Vec::new();
+        let mut args = Vec::new();
+        for (name, value) in &sig.params {
+            let name = names::to_rust_ident(name);
+            match value {
+                ImportValue::Scalar(ty) => {
+                    let core = canonical::flat(*value)[0].name();
+                    params.push(format!("{name}: {core}"));
+                    args.push(self.wasm_lift_scalar(ty, &name));
+                }
+                ImportValue::String => {
+                    params.push(format!("{name}_ptr: *mut u8, {name}_len: usize"));
+                    lifts.push(format!(
+                        "let {name} = unsafe {{ String::from_utf8_unchecked(take_bytes({name}_ptr, {name}_len)) }};"
+                    ));
+                    args.push(format!("&{name}"));
+                }
+                ImportValue::Bytes => {
+                    params.push(format!("{name}_ptr: *mut u8, {name}_len: usize"));
+                    lifts.push(format!(
+                        "let {name} = unsafe {{ take_bytes({name}_ptr, {name}_len) }};"
+                    ));
+                    args.push(format!("&{name}"));
+                }
+                // Rejected by `wasm_signature`
+                ImportValue::Own(_) | ImportValue::Borrow(_) => {}
+            }
+        }
+        let call = format!("<$impl_type>::{trait_method}({})", args.join(", "));
+        let returns_pointer = canonical::returns_pointer(sig.result);
+        let ret = match sig.result {
+            _ if returns_pointer => " -> *mut u8".to_string(),
+            ImportResult::Value(value) => format!(" -> {}", canonical::flat(value)[0].name()),
+            _ => String::new(),
+        };
+
+        writeln!(
+            out,
+            "            #[unsafe(export_name = \"{export_name}\")]"
+        )?;
+        writeln!(
+            out,
+            "            pub unsafe extern \"C\" fn {fn_name}({}){ret} {{",
+            params.join(", ")
+        )?;
+        for lift in &lifts {
+            writeln!(out, "                {lift}")?;
+        }
+        if returns_pointer {
+            writeln!(
+                out,
+                "                let area = (&raw mut RETURN_AREA).cast::<u8>();"
+            )?;
+        }
+        match sig.result {
+            ImportResult::None => writeln!(out, "                {call};")?,
+            ImportResult::Value(ImportValue::Scalar(ty)) => {
+                writeln!(
+                    out,
+                    "                {}",
+                    self.wasm_lower_scalar(&ty, &call)
+                )?;
+            }
+            ImportResult::Value(value) => {
+                let bytes = match value {
+                    ImportValue::String => "result.into_bytes()",
+                    _ => "result",
+                };
+                writeln!(out, "                let result = {call};")?;
+                writeln!(
+                    out,
+                    "                unsafe {{ write_buffer(area, {bytes}) }};"
+                )?;
+                writeln!(out, "                area")?;
+            }
+            ImportResult::Fallible(ok) => {
+                let offset = canonical::payload_offset(ok);
+                writeln!(out, "                match {call} {{")?;
+                match ok {
+                    Some(ImportValue::Scalar(ty)) => {
+                        writeln!(out, "                    Ok(value) => unsafe {{")?;
+                        writeln!(out, "                        area.write(0);")?;
+                        writeln!(
+                            out,
+                            "                        area.add({offset}).cast::<{}>().write(value);",
+                            self.type_to_idiomatic(&ty)
+                        )?;
+                        writeln!(out, "                    }},")?;
+                    }
+                    Some(value) => {
+                        let bytes = match value {
+                            ImportValue::String => "value.into_bytes()",
+                            _ => "value",
+                        };
+                        writeln!(out, "                    Ok(value) => unsafe {{")?;
+                        writeln!(out, "                        area.write(0);")?;
+                        writeln!(
+                            out,
+                            "                        write_buffer(area.add({offset}), {bytes});"
+                        )?;
+                        writeln!(out, "                    }},")?;
+                    }
+                    None => writeln!(
+                        out,
+                        "                    Ok(()) => unsafe {{ area.write(0) }},"
+                    )?,
+                }
+                writeln!(out, "                    Err(message) => unsafe {{")?;
+                writeln!(out, "                        area.write(1);")?;
+                writeln!(
+                    out,
+                    "                        write_buffer(area.add({offset}), message.into_bytes());"
+                )?;
+                writeln!(out, "                    }},")?;
+                writeln!(out, "                }}")?;
+                writeln!(out, "                area")?;
+            }
+        }
+        writeln!(out, "            }}")?;
+
+        if !returns_pointer {
+            return Ok(());
+        }
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            #[unsafe(export_name = \"{}\")]",
+            canonical::post_return_name(&export_name)
+        )?;
+        writeln!(
+            out,
+            "            pub unsafe extern \"C\" fn {fn_name}_post_return(area: *mut u8) {{"
+        )?;
+        match sig.result {
+            // An error, or an ok value with no buffer to free
+            ImportResult::Fallible(ok @ (None | Some(ImportValue::Scalar(_)))) => {
+                writeln!(out, "                unsafe {{")?;
+                writeln!(out, "                    if area.read() != 0 {{")?;
+                writeln!(
+                    out,
+                    "                        free_buffer(area.add({}));",
+                    canonical::payload_offset(ok)
+                )?;
+                writeln!(out, "                    }}")?;
+                writeln!(out, "                }}")?;
+            }
+            // The ok value and the error are buffers at the same offset
+            ImportResult::Fallible(ok) => {
+                writeln!(
+                    out,
+                    "                unsafe {{ free_buffer(area.add({})) }};",
+                    canonical::payload_offset(ok)
+                )?;
+            }
+            _ => writeln!(out, "                unsafe {{ free_buffer(area) }};")?,
+        }
+        writeln!(out, "            }}")?;
+
+        Ok(())
+    }
+
+    /// The Rust expression lifting `expr`, the core value of a scalar of
+    /// `ty`, into the idiomatic type of `ty`.
+    fn wasm_lift_scalar(&self, ty: &Type, expr: &str) -> String {
+        let idiomatic = self.type_to_idiomatic(ty);
+        let core = canonical::flat(ImportValue::Scalar(*ty))[0].name();
+        match ty {
+            Type::Bool => format!("{expr} != 0"),
+            _ if idiomatic == core => expr.to_string(),
+            _ => format!("{expr} as {idiomatic}"),
+        }
+    }
+
+    /// The Rust expression lowering `expr`, a scalar of `ty`, into its core
+    /// value.
+    fn wasm_lower_scalar(&self, ty: &Type, expr: &str) -> String {
+        let idiomatic = self.type_to_idiomatic(ty);
+        let core = canonical::flat(ImportValue::Scalar(*ty))[0].name();
+        if idiomatic == core {
+            expr.to_string()
+        } else {
+            format!("{expr} as {core}")
+        }
+    }
+
+    // ---- witffi_register_sidecar! macro generation ----
+
+    fn generate_register_sidecar_macro(&self, out: &mut String) -> std::fmt::Result {
+        let world = &self.resolve.worlds[self.world_id];
+        let trait_name = names::to_rust_type(&world.name);
+
+        writeln!(out)?;
+        writeln!(out, "// ---- Sidecar Registration macro ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "/// Register a concrete type for a sidecar process (Go consumers running it"
+        )?;
+        writeln!(out, "/// apart from their own).")?;
+        writeln!(out, "///")?;
+        writeln!(
+            out,
+            "/// This macro defines `witffi_serve_sidecar`, which serves each freestanding"
+        )?;
+        writeln!(
+            out,
+            "/// function passing numbers, bools, strings and `list<u8>` to the bindings"
+        )?;
+        writeln!(
+            out,
+            "/// on stdin and stdout until stdin closes. The sidecar is a binary whose"
+        )?;
+        writeln!(out, "/// `main` calls it.")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// # Example")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// ```ignore")?;
+        writeln!(out, "/// struct MyImpl;")?;
+        writeln!(out, "/// impl {trait_name} for MyImpl {{")?;
+        writeln!(out, "///     // ... implement trait methods ...")?;
+        writeln!(out, "/// }}")?;
+        writeln!(out, "/// witffi_register_sidecar!(MyImpl);")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// // src/bin/sidecar.rs")?;
+        writeln!(out, "/// fn main() -> std::io::Result<()> {{")?;
+        writeln!(out, "///     my_crate::witffi_serve_sidecar()")?;
+        writeln!(out, "/// }}")?;
+        writeln!(out, "/// ```")?;
+        writeln!(out, "#[macro_export]")?;
+        writeln!(out, "macro_rules! witffi_register_sidecar {{")?;
+        writeln!(out, "    ($impl_type:ty) => {{")?;
+        writeln!(
+            out,
+            "        /// Serve calls from the bindings on stdin and stdout until stdin"
+        )?;
+        writeln!(
+            out,
+            "        /// closes. A call that panics is answered with the panic's message."
+        )?;
+        writeln!(out, "        #[cfg(not(target_family = \"wasm\"))]")?;
+        writeln!(
+            out,
+            "        pub fn witffi_serve_sidecar() -> std::io::Result<()> {{"
+        )?;
+        writeln!(out, "            use std::io::{{Read, Write}};")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            /// Read a frame, or None once `input` closes."
+        )?;
+        writeln!(
+            out,
+            "            fn read_frame(input: &mut impl Read) -> std::io::Result<Option<Vec<u8>>> {{"
+        )?;
+        writeln!(out, "                let mut len = [0; 4];")?;
+        writeln!(out, "                match input.read_exact(&mut len) {{")?;
+        writeln!(out, "                    Ok(()) => {{}}")?;
+        writeln!(
+            out,
+            "                    Err(e) if e.kind() == std::io::ErrorKind::UnexpectedEof => return Ok(None),"
+        )?;
+        writeln!(out, "                    Err(e) => return Err(e),")?;
+        writeln!(out, "                }}")?;
+        writeln!(
+            out,
+            "                let mut frame = vec![0; u32::from_le_bytes(len) as usize];"
+        )?;
+        writeln!(out, "                input.read_exact(&mut frame)?;")?;
+        writeln!(out, "                Ok(Some(frame))")?;
+        writeln!(out, "            }}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            fn write_frame(output: &mut impl Write, frame: &[u8]) -> std::io::Result<()> {{"
+        )?;
+        writeln!(
+            out,
+            "                output.write_all(&(frame.len() as u32).to_le_bytes())?;"
+        )?;
+        writeln!(out, "                output.write_all(frame)?;")?;
+        writeln!(out, "                output.flush()")?;
+        writeln!(out, "            }}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            /// Append `bytes` to `response` behind their length."
+        )?;
+        writeln!(out, "            #[allow(dead_code)]")?;
+        writeln!(
+            out,
+            "            fn put_buffer(response: &mut Vec<u8>, bytes: &[u8]) {{"
+        )?;
+        writeln!(
+            out,
+            "                response.extend_from_slice(&(bytes.len() as u32).to_le_bytes());"
+        )?;
+        writeln!(out, "                response.extend_from_slice(bytes);")?;
+        writeln!(out, "            }}")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "            /// The rest of a request, read in order. A short request panics,"
+        )?;
+        writeln!(out, "            /// failing the call.")?;
+        writeln!(out, "            struct Args<'a>(&'a [u8]);")?;
+        writeln!(out)?;
+        writeln!(out, "            #[allow(dead_code)]")?;
+        writeln!(out, "            impl Args<'_> {{")?;
+        writeln!(
+            out,
+            "                fn take<const N: usize>(&mut self) -> [u8; N] {{"
+        )?;
+        writeln!(
+            out,
+            "                    let (head, rest) = self.0.split_at(N);"
+        )?;
+        writeln!(out, "                    self.0 = rest;")?;
+        writeln!(
+            out,
+            "                    head.try_into().expect(\"split at N\")"
+        )?;
+        writeln!(out, "                }}")?;
+        writeln!(out)?;
+        writeln!(out, "                fn bytes(&mut self) -> Vec<u8> {{")?;
+        writeln!(
+            out,
+            "                    let len = u32::from_le_bytes(self.take()) as usize;"
+        )?;
+        writeln!(
+            out,
+            "                    let (head, rest) = self.0.split_at(len);"
+        )?;
+        writeln!(out, "                    self.0 = rest;")?;
+        writeln!(out, "                    head.to_vec()")?;
+        writeln!(out, "                }}")?;
+        writeln!(out)?;
+        writeln!(out, "                fn string(&mut self) -> String {{")?;
+        writeln!(
+            out,
+            "                    String::from_utf8(self.bytes()).expect(\"strings are UTF-8\")"
+        )?;
+        writeln!(out, "                }}")?;
+        writeln!(out, "            }}")?;
+        writeln!(out)?;
+        writeln!(out, "            let mut input = std::io::stdin().lock();")?;
+        writeln!(
+            out,
+            "            let mut output = std::io::stdout().lock();"
+        )?;
+        writeln!(
+            out,
+            "            // The bindings check that they were generated from the same world"
+        )?;
+        writeln!(
+            out,
+            "            write_frame(&mut output, &{:#018x}u64.to_le_bytes())?;",
+            witffi_core::abi_hash(self.resolve, self.world_id)
+        )?;
+        writeln!(
+            out,
+            "            while let Some(request) = read_frame(&mut input)? {{"
+        )?;
+        writeln!(
+            out,
+            "                let served = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
+        )?;
+        writeln!(out, "                    let mut args = Args(&request);")?;
+        writeln!(
+            out,
+            "                    let mut response = vec![{}u8];",
+            ipc::STATUS_OK
+        )?;
+        writeln!(
+            out,
+            "                    match u32::from_le_bytes(args.take()) {{"
+        )?;
+        for ef in exported_functions(self.resolve, self.world_id) {
+            if let Some(sig) = ipc::ipc_signature(self.resolve, &ef) {
+                self.generate_sidecar_arm(out, &ef, &sig)?;
+            }
+        }
+        writeln!(
+            out,
+            "                        id => panic!(\"no function has id {{id}}\"),"
+        )?;
+        writeln!(out, "                    }}")?;
+        writeln!(out, "                    response")?;
+        writeln!(out, "                }}));")?;
+        writeln!(
+            out,
+            "                let response = served.unwrap_or_else(|payload| {{"
+        )?;
+        writeln!(out, "                    let message = payload")?;
+        writeln!(out, "                        .downcast_ref::<&str>()")?;
+        writeln!(out, "                        .map(|s| s.to_string())")?;
+        writeln!(
+            out,
+            "                        .or_else(|| payload.downcast_ref::<String>().cloned())"
+        )?;
+        writeln!(
+            out,
+            "                        .unwrap_or_else(|| \"the call panicked\".to_string());"
+        )?;
+        writeln!(
+            out,
+            "                    let mut response = vec![{}u8];",
+            ipc::STATUS_PANIC
+        )?;
+        writeln!(
+            out,
+            "                    put_buffer(&mut response, message.as_bytes());"
+        )?;
+        writeln!(out, "                    response")?;
+        writeln!(out, "                }});")?;
+        writeln!(out, "                write_frame(&mut output, &response)?;")?;
+        writeln!(out, "            }}")?;
+        writeln!(out, "            Ok(())")?;
+        writeln!(out, "        }}")?;
+        writeln!(out, "    }};")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the match arm serving a request for `ef`.
+    fn generate_sidecar_arm(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        sig: &ImportSignature,
+    ) -> std::fmt::Result {
+        // Functions are served from the world's exports, which all have an id
+        let Some(id) = ipc::function_id(self.resolve, self.world_id, ef) else {
+            return Ok(());
+        };
+        let trait_method = self.trait_method_name(ef);
+
+        writeln!(out, "                        {id} => {{")?;
+        let mut args = Vec::new();
+        for (name, value) in &sig.params {
+            let name = names::to_rust_ident(name);
+            let read = match value {
+                ImportValue::Scalar(Type::Bool) => "args.take::<1>()[0] != 0".to_string(),
+                ImportValue::Scalar(ty) => {
+                    format!("{}::from_le_bytes(args.take())", self.type_to_idiomatic(ty))
+                }
+                ImportValue::String => "args.string()".to_string(),
+                ImportValue::Bytes => "args.bytes()".to_string(),
+                // Rejected by `ipc_signature`
+                ImportValue::Own(_) | ImportValue::Borrow(_) => continue,
+            };
+            writeln!(out, "                            let {name} = {read};")?;
+            args.push(match value {
+                ImportValue::String | ImportValue::Bytes => format!("&{name}"),
+                _ => name,
+            });
+        }
+        let call = format!("<$impl_type>::{trait_method}({})", args.join(", "));
+        match sig.result {
+            ImportResult::None => writeln!(out, "                            {call};")?,
+            ImportResult::Value(value) => {
+                writeln!(out, "                            let value = {call};")?;
+                writeln!(
+                    out,
+                    "                            {};",
+                    self.sidecar_put(value, "value")
+                )?;
+            }
+            ImportResult::Fallible(ok) => {
+                writeln!(out, "                            match {call} {{")?;
+                match ok {
+                    Some(value) => writeln!(
+                        out,
+                        "                                Ok(value) => {},",
+                        self.sidecar_put(value, "value")
+                    )?,
+                    None => writeln!(out, "                                Ok(()) => {{}}")?,
+                }
+                writeln!(out, "                                Err(message) => {{")?;
+                writeln!(
+                    out,
+                    "                                    response[0] = {};",
+                    ipc::STATUS_ERR
+                )?;
+                writeln!(
+                    out,
+                    "                                    put_buffer(&mut response, message.as_bytes());"
+                )?;
+                writeln!(out, "                                }}")?;
+                writeln!(out, "                            }}")?;
+            }
+        }
+        writeln!(out, "                        }}")?;
+        Ok(())
+    }
+
+    /// The Rust statement appending `expr`, a value of `value`, to the
+    /// response.
+    fn sidecar_put(&self, value: ImportValue, expr: &str) -> String {
+        match value {
+            ImportValue::Scalar(Type::Bool) => format!("response.push({expr} as u8)"),
+            ImportValue::String => format!("put_buffer(&mut response, {expr}.as_bytes())"),
+            ImportValue::Bytes => format!("put_buffer(&mut response, &{expr})"),
+            _ => format!("response.extend_from_slice(&{expr}.to_le_bytes())"),
+        }
+    }
+
+    // ---- witffi_register_grpc! macro generation ----
+
+    fn generate_register_grpc_macro(
+        &self,
+        out: &mut String,
+        proto_package: &str,
+    ) -> std::fmt::Result {
+        let world = &self.resolve.worlds[self.world_id];
+        let trait_name = names::to_rust_type(&world.name);
+        let service = proto::service_name(self.resolve, self.world_id);
+        let server = format!("{}_server", service.to_snake_case());
+
+        writeln!(out)?;
+        writeln!(out, "// ---- gRPC Registration macro ----")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "/// Register a concrete type for a gRPC service (Go consumers calling it"
+        )?;
+        writeln!(out, "/// over the network).")?;
+        writeln!(out, "///")?;
+        writeln!(
+            out,
+            "/// This macro defines `WitffiGrpc`, which implements the service tonic-build"
+        )?;
+        writeln!(
+            out,
+            "/// generates into the module `$proto` from the world's proto file, serving"
+        )?;
+        writeln!(
+            out,
+            "/// each freestanding function passing numbers, bools, strings and"
+        )?;
+        writeln!(
+            out,
+            "/// `list<u8>`. Calls run on tokio's blocking threads. An error is returned"
+        )?;
+        writeln!(
+            out,
+            "/// as status UNKNOWN and a panic as INTERNAL, each with its message."
+        )?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// # Example")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// ```ignore")?;
+        writeln!(out, "/// mod proto {{")?;
+        writeln!(out, "///     tonic::include_proto!(\"{proto_package}\");")?;
+        writeln!(out, "/// }}")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// struct MyImpl;")?;
+        writeln!(out, "/// impl {trait_name} for MyImpl {{")?;
+        writeln!(out, "///     // ... implement trait methods ...")?;
+        writeln!(out, "/// }}")?;
+        writeln!(out, "/// witffi_register_grpc!(MyImpl, proto);")?;
+        writeln!(out, "///")?;
+        writeln!(out, "/// tonic::transport::Server::builder()")?;
+        writeln!(
+            out,
+            "///     .add_service(proto::{server}::{service}Server::new(WitffiGrpc))"
+        )?;
+        writeln!(out, "///     .serve(addr)")?;
+        writeln!(out, "///     .await?;")?;
+        writeln!(out, "/// ```")?;
+        writeln!(out, "#[macro_export]")?;
+        writeln!(out, "macro_rules! witffi_register_grpc {{")?;
+        writeln!(out, "    ($impl_type:ty, $proto:ident) => {{")?;
+        writeln!(
+            out,
+            "        /// The gRPC service of the world, calling `$impl_type`."
+        )?;
+        writeln!(out, "        #[cfg(not(target_family = \"wasm\"))]")?;
+        writeln!(out, "        pub struct WitffiGrpc;")?;
+        writeln!(out)?;
+        writeln!(out, "        #[cfg(not(target_family = \"wasm\"))]")?;
+        writeln!(out, "        const _: () = {{")?;
+        writeln!(
+            out,
+            "            /// The status of a call that panicked, or was cancelled."
+        )?;
+        writeln!(out, "            #[allow(dead_code)]")?;
+        writeln!(
+            out,
+            "            fn panic_status(error: tokio::task::JoinError) -> tonic::Status {{"
+        )?;
+        writeln!(
+            out,
+            "                let message = match error.try_into_panic() {{"
+        )?;
+        writeln!(out, "                    Ok(payload) => payload")?;
+        writeln!(out, "                        .downcast_ref::<&str>()")?;
+        writeln!(out, "                        .map(|s| s.to_string())")?;
+        writeln!(
+            out,
+            "                        .or_else(|| payload.downcast_ref::<String>().cloned())"
+        )?;
+        writeln!(
+            out,
+            "                        .unwrap_or_else(|| \"the call panicked\".to_string()),"
+        )?;
+        writeln!(out, "                    Err(error) => error.to_string(),")?;
+        writeln!(out, "                }};")?;
+        writeln!(out, "                tonic::Status::internal(message)")?;
+        writeln!(out, "            }}")?;
+        writeln!(out)?;
+        writeln!(out, "            #[tonic::async_trait]")?;
+        writeln!(
+            out,
+            "            impl $proto::{server}::{service} for WitffiGrpc {{"
+        )?;
+        let mut first = true;
+        for ef in exported_functions(self.resolve, self.world_id) {
+            if let Some(sig) = plain_signature(self.resolve, &ef) {
+                if !first {
+                    writeln!(out)?;
+                }
+                first = false;
+                self.generate_grpc_method(out, &ef, &sig)?;
+            }
+        }
+        writeln!(out, "            }}")?;
+        writeln!(out, "        }};")?;
+        writeln!(out, "    }};")?;
+        writeln!(out, "}}")?;
+
+        Ok(())
+    }
+
+    /// Generate the method of the service calling `ef`.
+    fn generate_grpc_method(
+        &self,
+        out: &mut String,
+        ef: &ExportedFunction,
+        sig: &ImportSignature,
+    ) -> std::fmt::Result {
+        let rpc = proto::rpc_name(ef);
+        let trait_method = self.trait_method_name(ef);
+
+        writeln!(out, "                async fn {}(", rpc.to_snake_case())?;
+        writeln!(out, "                    &self,")?;
+        // A request without fields goes unread
+        let request = if sig.params.is_empty() {
+            "_request"
+        } else {
+            "request"
+        };
+        writeln!(
+            out,
+            "                    {request}: tonic::Request<$proto::{rpc}Request>,"
+        )?;
+        writeln!(
+            out,
+            "                ) -> Result<tonic::Response<$proto::{rpc}Response>, tonic::Status> {{"
+        )?;
+        if !sig.params.is_empty() {
+            writeln!(
+                out,
+                "                    let request = request.into_inner();"
+            )?;
+        }
+        let mut args = Vec::new();
+        for (name, value) in &sig.params {
+            let field = names::to_rust_ident(&proto::field_name(name));
+            match value {
+                // Widened to 32 bits on the wire
+                ImportValue::Scalar(ty @ (Type::U8 | Type::U16 | Type::S8 | Type::S16)) => {
+                    let rust_ty = self.type_to_idiomatic(ty);
+                    writeln!(
+                        out,
+                        "                    let {field} = {rust_ty}::try_from(request.{field}).map_err(|_| {{"
+                    )?;
+                    writeln!(
+                        out,
+                        "                        tonic::Status::invalid_argument(\"`{name}` is out of range\")"
+                    )?;
+                    writeln!(out, "                    }})?;")?;
+                    args.push(field);
+                }
+                ImportValue::String | ImportValue::Bytes => args.push(format!("&request.{field}")),
+                _ => args.push(format!("request.{field}")),
+            }
+        }
+        let call = format!(
+            "tokio::task::spawn_blocking(move || <$impl_type>::{trait_method}({}))",
+            args.join(", ")
+        );
+        let ok = match sig.result {
+            ImportResult::None => None,
+            ImportResult::Value(value) => Some(value),
+            ImportResult::Fallible(ok) => ok,
+        };
+        let binding = if ok.is_some() { "let value = " } else { "" };
+        writeln!(out, "                    {binding}{call}")?;
+        writeln!(out, "                        .await")?;
+        if let ImportResult::Fallible(_) = sig.result {
+            writeln!(out, "                        .map_err(panic_status)?")?;
+            writeln!(
+                out,
+                "                        .map_err(tonic::Status::unknown)?;"
+            )?;
+        } else {
+            writeln!(out, "                        .map_err(panic_status)?;")?;
+        }
+        let fields = match ok {
+            // Widened to 32 bits on the wire
+            Some(ImportValue::Scalar(Type::U8 | Type::U16 | Type::S8 | Type::S16)) => {
+                " value: value.into() "
+            }
+            Some(_) => " value ",
+            None => "",
+        };
+        writeln!(
+            out,
+            "                    Ok(tonic::Response::new($proto::{rpc}Response {{{fields}}}))"
+        )?;
+        writeln!(out, "                }}")?;
+        Ok(())
+    }
+
+    // ---- C header generation ----
+
+    fn generate_c_types(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "/* ---- Types ---- */")?;
+        writeln!(out)?;
+        writeln!(
+            out,
+            "/* FfiByteSlice and FfiByteBuffer are provided by witffi_types.h */"
+        )?;
+        writeln!(out)?;
+
+        let reachable = self.collect_reachable_types();
+        for type_id in &reachable {
+            self.generate_c_type_def(out, *type_id)?;
+        }
+
+        Ok(())
+    }
+
+    fn generate_c_type_def(&self, out: &mut String, type_id: TypeId) -> std::fmt::Result {
+        let typedef = &self.resolve.types[type_id];
+        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+
+        match &typedef.kind {
+            TypeDefKind::Record(record) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                writeln!(out, "typedef struct {{")?;
+                for field in &record.fields {
+                    let field_name = names::to_rust_ident(&field.name);
+                    let field_type = self.type_to_c_header(&field.ty);
+                    writeln!(out, "    {field_type} {field_name};")?;
+                }
+                writeln!(out, "}} {c_name};")?;
+                writeln!(out)?;
+
+                if self.columnar_records().contains(&type_id) {
+                    writeln!(out, "typedef struct {{")?;
+                    writeln!(out, "    size_t len;")?;
+                    for field in &record.fields {
+                        let field_name = names::to_rust_ident(&field.name);
+                        let field_type = self.type_to_c_header(&field.ty);
+                        writeln!(out, "    {field_type} *{field_name};")?;
+                    }
+                    writeln!(out, "}} {c_name}Columns;")?;
+                    writeln!(out)?;
+                }
+            }
+
+            TypeDefKind::Variant(variant) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                let tag_name = format!("{c_name}Tag");
+
+                writeln!(out, "typedef enum {{")?;
+                for (i, case) in variant.cases.iter().enumerate() {
+                    let variant_upper = names::to_c_enum_variant(&c_name, &case.name);
+                    writeln!(out, "    {variant_upper} = {i},")?;
+                }
+                writeln!(out, "}} {tag_name};")?;
+                writeln!(out)?;
+
+                for case in &variant.cases {
+                    if let Some(ty) = &case.ty {
+                        let payload_name =
+                            format!("{c_name}{}Payload", names::to_rust_type(&case.name));
+                        writeln!(out, "typedef struct {{")?;
+                        match witffi_core::payload_fields(self.resolve, ty) {
+                            Some(fields) => {
+                                for (i, field_ty) in fields.iter().enumerate() {
+                                    writeln!(out, "    {} f{i};", self.type_to_c_header(field_ty))?;
+                                }
+                            }
+                            None => writeln!(out, "    {} value;", self.type_to_c_header(ty))?,
+                        }
+                        writeln!(out, "}} {payload_name};")?;
+                        writeln!(out)?;
+                    }
+                }
+
+                writeln!(out, "typedef struct {{")?;
+                writeln!(out, "    {tag_name} tag;")?;
+                for case in &variant.cases {
+                    if case.ty.is_some() {
+                        let field_name = names::to_rust_ident(&case.name);
+                        let payload_name =
+                            format!("{c_name}{}Payload", names::to_rust_type(&case.name));
+                        writeln!(out, "    {payload_name} *{field_name};")?;
+                    }
+                }
+                writeln!(out, "}} {c_name};")?;
+                writeln!(out)?;
+            }
+
+            TypeDefKind::Enum(e) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                writeln!(out, "typedef enum {{")?;
+                for (i, case) in e.cases.iter().enumerate() {
+                    let case_upper = names::to_c_enum_variant(&c_name, &case.name);
+                    writeln!(out, "    {case_upper} = {i},")?;
+                }
+                writeln!(out, "}} {c_name};")?;
+                writeln!(out)?;
+            }
+
+            TypeDefKind::Type(inner) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                let inner_c = self.type_to_c_header(inner);
+                if c_name != inner_c {
+                    writeln!(out, "typedef {inner_c} {c_name};")?;
+                    writeln!(out)?;
+                }
+            }
+
+            TypeDefKind::Tuple(tuple) => {
+                let c_name = self.tuple_c_name(tuple);
+                writeln!(out, "typedef struct {{")?;
+                for (i, ty) in tuple.types.iter().enumerate() {
+                    let field_type = self.type_to_c_header(ty);
+                    writeln!(out, "    {field_type} f{i};")?;
+                }
+                writeln!(out, "}} {c_name};")?;
+                writeln!(out)?;
+
+                if self.tuple_has_input_struct(tuple) {
+                    writeln!(out, "typedef struct {{")?;
+                    for (i, ty) in tuple.types.iter().enumerate() {
+                        let field_type = self.type_to_c_header_input(ty);
+                        writeln!(out, "    {field_type} f{i};")?;
+                    }
+                    writeln!(out, "}} {c_name}Input;")?;
+                    writeln!(out)?;
+                }
+            }
+
+            TypeDefKind::List(elem) if *elem != Type::U8 => {
+                let c_name = self.shape_c_name(type_id);
+                writeln!(out, "typedef struct {{")?;
+                writeln!(out, "    {} *ptr;", self.type_to_c_header(elem))?;
+                writeln!(out, "    size_t len;")?;
+                writeln!(out, "}} {c_name};")?;
+                writeln!(out)?;
+                writeln!(out, "typedef struct {{")?;
+                writeln!(out, "    {} const *ptr;", self.type_to_c_header_input(elem))?;
+                writeln!(out, "    size_t len;")?;
+                writeln!(out, "}} {c_name}Slice;")?;
+                writeln!(out)?;
+            }
+
+            TypeDefKind::Result(result) if self.is_nested_result(type_id) => {
+                let c_name = self.shape_c_name(type_id);
+                writeln!(out, "typedef struct {{")?;
+                writeln!(out, "    bool is_ok;")?;
+                if let Some(ok) = &result.ok {
+                    writeln!(out, "    {} *ok;", self.type_to_c_header(ok))?;
+                }
+                if let Some(err) = &result.err {
+                    writeln!(out, "    {} *err;", self.type_to_c_header(err))?;
+                }
+                writeln!(out, "}} {c_name};")?;
+                writeln!(out)?;
+            }
+
+            TypeDefKind::FixedLengthList(elem, len) => {
+                let c_name = self.array_c_name(elem, *len);
+                let elem_type = self.type_to_c_header(elem);
+                writeln!(out, "typedef struct {{")?;
+                writeln!(out, "    {elem_type} elems[{len}];")?;
+                writeln!(out, "}} {c_name};")?;
+                writeln!(out)?;
+            }
+
+            TypeDefKind::Flags(flags) => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                writeln!(out, "typedef uint32_t {c_name};")?;
+                for (i, flag) in flags.flags.iter().enumerate() {
+                    let const_name = names::to_c_enum_variant(&c_name, &flag.name);
+                    writeln!(out, "#define {const_name} (({c_name})1 << {i})")?;
+                }
+                writeln!(out)?;
+            }
+
+            TypeDefKind::Resource => {
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                writeln!(out, "typedef struct {c_name} {c_name};")?;
+                writeln!(out)?;
+            }
+
+            _ => {}
+        }
+
+        Ok(())
+    }
+
+    fn type_to_c_header(&self, ty: &Type) -> String {
+        match ty {
+            Type::Bool => "bool".to_string(),
+            Type::U8 => "uint8_t".to_string(),
+            Type::U16 => "uint16_t".to_string(),
+            Type::U32 => "uint32_t".to_string(),
+            Type::U64 => "uint64_t".to_string(),
+            Type::S8 => "int8_t".to_string(),
+            Type::S16 => "int16_t".to_string(),
+            Type::S32 => "int32_t".to_string(),
+            Type::S64 => "int64_t".to_string(),
+            Type::F32 => "float".to_string(),
+            Type::F64 => "double".to_string(),
+            Type::Char => "uint32_t".to_string(),
+            Type::String => "FfiByteBuffer".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => "FfiByteBuffer".to_string(),
+                    TypeDefKind::List(_) | TypeDefKind::Result(_) => self.shape_c_name(*id),
+                    TypeDefKind::Option(inner) => format!("{}*", self.type_to_c_header(inner)),
+                    TypeDefKind::Type(aliased) => self.type_to_c_header(aliased),
+                    TypeDefKind::Tuple(tuple) => self.tuple_c_name(tuple),
+                    TypeDefKind::FixedLengthList(elem, len) => self.array_c_name(elem, *len),
+                    TypeDefKind::Handle(Handle::Own(resource_id) | Handle::Borrow(resource_id)) => {
+                        let resource_id = witffi_core::dealias(self.resolve, *resource_id);
+                        let name = self.resolve.types[resource_id]
+                            .name
+                            .as_deref()
+                            .unwrap_or("void");
+                        format!("{}*", names::to_c_type(&self.config.c_type_prefix, name))
+                    }
+                    _ => {
+                        let name = typedef.name.as_deref().unwrap_or("void");
+                        names::to_c_type(&self.config.c_type_prefix, name)
+                    }
+                }
+            }
+            Type::ErrorContext => "FfiErrorContext".to_string(),
+        }
+    }
+
+    /// Map a WIT type to its C header input representation.
+    fn type_to_c_header_input(&self, ty: &Type) -> String {
+        match ty {
+            Type::String => "FfiByteSlice".to_string(),
+            Type::Id(id) => {
+                let typedef = &self.resolve.types[*id];
+                match &typedef.kind {
+                    TypeDefKind::List(Type::U8) => "FfiByteSlice".to_string(),
+                    TypeDefKind::Stream(Some(Type::U8)) => "FfiByteSource".to_string(),
+                    TypeDefKind::List(_) => format!("{}Slice", self.shape_c_name(*id)),
+                    TypeDefKind::Option(inner) => {
+                        format!("const {}*", self.type_to_c_header_input(inner))
+                    }
+                    TypeDefKind::Tuple(tuple)
+                        if witffi_core::wide_int(self.resolve, ty).is_none()
+                            && self.tuple_has_input_struct(tuple) =>
+                    {
+                        format!("{}Input", self.tuple_c_name(tuple))
+                    }
+                    TypeDefKind::Type(aliased) => self.type_to_c_header_input(aliased),
+                    _ => self.type_to_c_header(ty),
+                }
+            }
+            Type::ErrorContext => "FfiErrorContextInput".to_string(),
+            _ => self.type_to_c_header(ty),
+        }
+    }
+
+    fn generate_c_function_decls(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "/* ---- Functions ---- */")?;
+        writeln!(out)?;
+
+        let prefix = self.config.c_prefix.to_snake_case();
+        writeln!(out, "int32_t {prefix}_last_error_length(void);")?;
+        writeln!(
+            out,
+            "int32_t {prefix}_error_message_utf8(char *buf, int32_t len);"
+        )?;
+        writeln!(out, "void {prefix}_clear_last_error(void);")?;
+        writeln!(
+            out,
+            "bool {prefix}_take_panic(FfiByteBuffer *backtrace_out);"
+        )?;
+        writeln!(out, "void {prefix}_capture_panic_backtraces(void);")?;
+        writeln!(out, "uint64_t {prefix}_abi_hash(void);")?;
+        if self.config.lifecycle {
+            writeln!(out, "bool {prefix}_init(FfiByteSlice options);")?;
+            writeln!(out, "void {prefix}_shutdown(void);")?;
+        }
+        if self.config.log_bridge {
+            writeln!(
+                out,
+                "bool {prefix}_set_log_sink(FfiLogSink sink, uint8_t max_level);"
+            )?;
+        }
+        writeln!(out)?;
+        writeln!(out, "void *{prefix}_cancel_token_new(void);")?;
+        writeln!(out, "void {prefix}_cancel_token_cancel(void *token);")?;
+        writeln!(out, "void {prefix}_cancel_token_free(void *token);")?;
+        writeln!(out, "void {prefix}_cancel_token_enter(void *token);")?;
+        writeln!(out, "void {prefix}_cancel_token_exit(void);")?;
+        writeln!(out)?;
+
+        let funcs = exported_functions(self.resolve, self.world_id);
+        if funcs
+            .iter()
+            .any(|ef| ef.future_value(self.resolve).is_some())
+        {
+            writeln!(out, "void {prefix}_future_cancel(void *call);")?;
+            writeln!(out, "void {prefix}_future_release(void *call);")?;
+            writeln!(out)?;
+        }
+        if funcs.iter().any(|ef| ef.uses_error_context(self.resolve)) {
+            writeln!(out, "uint64_t {prefix}_error_context_new_id(void);")?;
+            writeln!(out)?;
+        }
+
+        for ef in &funcs {
+            // Functions returning `future<T>` are declared like async
+            // functions returning `T`
+            let awaited = ef.awaited(self.resolve);
+            let ef = awaited.as_ref().unwrap_or(ef);
+            let c_func_name = ef.c_func_name(self.resolve, &self.config.c_prefix);
+
+            let result_decomposed = self.decompose_result(&ef.function.result);
+
+            let mut c_params: Vec<String> = self
+                .flat_params(ef)
+                .iter()
+                .map(|(name, ty)| format!("{} {name}", self.type_to_c_header_input(ty)))
+                .collect();
+            let err_out = ef.error_payload(self.resolve).map(|err_ty| {
+                let err_c = self.type_to_c_header(&err_ty);
+                format!("{err_c}** err_out")
+            });
+            // Async functions start the call here and return the result
+            // from `_finish` (see `generate_ffi_async_function`)
+            let mut finish_params = vec!["void *task".to_string()];
+            if ef.is_async() || awaited.is_some() {
+                c_params.push("FfiAsyncComplete complete".to_string());
+                c_params.push("uintptr_t user_data".to_string());
+                finish_params.extend(err_out);
+            } else {
+                c_params.extend(err_out);
+            }
+
+            let c_return = if let Some((ref ok_ty, _)) = result_decomposed {
+                match ok_ty {
+                    Some(ty) if self.is_handle(ty) => self.type_to_c_header(ty),
+                    Some(ty) => format!("{}*", self.type_to_c_header(ty)),
+                    None => "bool".to_string(),
+                }
+            } else {
+                match &ef.function.result {
+                    Some(ty) => self.type_to_c_header(ty),
+                    None => "void".to_string(),
+                }
+            };
+
+            let params_str = if c_params.is_empty() {
+                "void".to_string()
+            } else {
+                c_params.join(", ")
+            };
+
+            if ef.stream_item(self.resolve) == Some(Type::U8) {
+                writeln!(out, "void *{c_func_name}({params_str});")?;
+                writeln!(
+                    out,
+                    "intptr_t {c_func_name}_read(void *stream, uint8_t *buf, size_t len);"
+                )?;
+                writeln!(out, "void {c_func_name}_drop(void *stream);")?;
+            } else if let Some(item) = ef.stream_item(self.resolve) {
+                let item_c = if self.is_handle(&item) {
+                    self.type_to_c_header(&item)
+                } else {
+                    format!("{}*", self.type_to_c_header(&item))
+                };
+                writeln!(out, "void *{c_func_name}({params_str});")?;
+                writeln!(out, "{item_c} {c_func_name}_next(void *stream);")?;
+                writeln!(out, "void {c_func_name}_drop(void *stream);")?;
+            } else if ef.is_async() || awaited.is_some() {
+                let start_return = if awaited.is_some() { "void *" } else { "void " };
+                writeln!(out, "{start_return}{c_func_name}({params_str});")?;
+                writeln!(
+                    out,
+                    "{c_return} {c_func_name}_finish({});",
+                    finish_params.join(", ")
+                )?;
+            } else {
+                writeln!(out, "{c_return} {c_func_name}({params_str});")?;
+            }
+            if self.into_result(ef).is_some() {
+                c_params.extend(["uint8_t *dst".to_string(), "size_t dst_len".to_string()]);
+                writeln!(out, "intptr_t {c_func_name}_into({});", c_params.join(", "))?;
+            }
+            if let Some(record_id) = self.columns_result(ef) {
+                let wit_name = self.resolve.types[record_id]
+                    .name
+                    .as_deref()
+                    .unwrap_or("anonymous");
+                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                let mut params = c_params.clone();
+                params.push(format!("{c_name}Columns *columns_out"));
+                writeln!(out, "bool {c_func_name}_columns({});", params.join(", "))?;
+            }
+        }
+
+        Ok(())
+    }
+
+    /// Declare the functions the world imports, which the caller defines.
+    fn generate_c_import_decls(&self, out: &mut String) -> std::fmt::Result {
+        let imports = self.imports();
+        if imports.is_empty() {
+            return Ok(());
+        }
+
+        writeln!(out)?;
+        writeln!(
+            out,
+            "/* ---- Imported functions (defined by the caller) ---- */"
+        )?;
+        writeln!(out)?;
+        for (ef, sig) in &imports {
+            let c_type = |value: ImportValue, input: bool| match value {
+                ImportValue::Scalar(ty) => self.type_to_c_header(&ty),
+                ImportValue::String | ImportValue::Bytes if input => "FfiByteSlice".to_string(),
+                ImportValue::String | ImportValue::Bytes => "FfiByteBuffer".to_string(),
+                ImportValue::Own(id) | ImportValue::Borrow(id) => {
+                    let name = self.resolve.types[id].name.as_deref().unwrap_or("void");
+                    format!("{}*", names::to_c_type(&self.config.c_type_prefix, name))
+                }
+            };
+            let mut params: Vec<String> = sig
+                .params
+                .iter()
+                .map(|(name, value)| format!("{} {}", c_type(*value, true), name.to_snake_case()))
+                .collect();
+            let c_return = match sig.result {
+                ImportResult::None => "void".to_string(),
+                ImportResult::Value(value) => c_type(value, false),
+                ImportResult::Fallible(ok) => {
+                    if let Some(ok) = ok {
+                        params.push(format!("{} *ok_out", c_type(ok, false)));
+                    }
+                    params.push("FfiByteBuffer *err_out".to_string());
+                    "bool".to_string()
+                }
+            };
+            let params_str = if params.is_empty() {
+                "void".to_string()
+            } else {
+                params.join(", ")
+            };
+            writeln!(
+                out,
+                "{c_return} {}({params_str});",
+                ef.c_import_name(&self.config.c_prefix)
+            )?;
+        }
+        writeln!(
+            out,
+            "void {}_import_panicked(FfiByteSlice message);",
+            self.config.c_prefix.to_snake_case()
+        )?;
+
+        Ok(())
+    }
+
+    fn generate_c_free_decls(&self, out: &mut String) -> std::fmt::Result {
+        writeln!(out, "/* ---- Free functions ---- */")?;
+        writeln!(out)?;
+
+        let prefix = self.config.c_prefix.to_snake_case();
+        writeln!(out, "void {prefix}_free_byte_buffer(FfiByteBuffer buf);")?;
+        if self.config.alloc_accounting || self.config.alloc_canaries {
+            writeln!(out, "void {prefix}_free_box(void *ptr);")?;
+        }
+        if self.config.alloc_accounting {
+            writeln!(out, "FfiByteBuffer {prefix}_alloc_stats(void);")?;
+        }
+
+        for record_id in self.columnar_records() {
+            let wit_name = self.resolve.types[record_id]
+                .name
+                .as_deref()
+                .unwrap_or("anonymous");
+            let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+            let free_name =
+                names::to_c_func(&self.config.c_prefix, &format!("free-{wit_name}-columns"));
+            writeln!(out, "void {free_name}({c_name}Columns columns);")?;
+        }
+
+        let reachable = self.collect_reachable_types();
+        for type_id in &reachable {
+            let typedef = &self.resolve.types[*type_id];
+            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+
+            match &typedef.kind {
+                TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
+                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                    let free_name =
+                        names::to_c_func(&self.config.c_prefix, &format!("free-{wit_name}"));
+                    writeln!(out, "void {free_name}({c_name} *ptr);")?;
+                }
+                TypeDefKind::Tuple(tuple) => {
+                    let c_name = self.tuple_c_name(tuple);
+                    let free_name = format!("{prefix}_free_{}", self.tuple_fn_stem(tuple));
+                    writeln!(out, "void {free_name}({c_name} *ptr);")?;
+                }
+                TypeDefKind::List(elem) if *elem != Type::U8 => {
+                    let c_name = self.shape_c_name(*type_id);
+                    let free_name = format!("{prefix}_free_{}", self.shape_fn_stem(*type_id));
+                    writeln!(out, "void {free_name}({c_name} list);")?;
+                }
+                TypeDefKind::FixedLengthList(elem, len) => {
+                    let c_name = self.array_c_name(elem, *len);
+                    let free_name = format!("{prefix}_free_{}", self.array_fn_stem(elem, *len));
+                    writeln!(out, "void {free_name}({c_name} *ptr);")?;
+                }
+                TypeDefKind::Resource => {
+                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
+                    let drop_name = format!(
+                        "{}_drop",
+                        witffi_core::resource_c_prefix(
+                            self.resolve,
+                            &self.config.c_prefix,
+                            *type_id
+                        )
+                    );
+                    writeln!(out, "void {drop_name}({c_name} *ptr);")?;
+                }
+                _ => {}
+            }
+        }
+
+        Ok(())
+    }
+}
+
+/// The Rust expression lifting `expr`, a `u32` from the caller, into a
+/// `char`, panicking if it is not a Unicode scalar value.
+fn char_from_ffi(expr: &str) -> String {
+    format!(
+        "char::from_u32({expr}).unwrap_or_else(|| panic!(\"invalid char {{:#x}}: not a Unicode scalar value\", {expr}))"
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::path::Path;
+    use witffi_core::load_wit;
+
+    fn test_config() -> RustConfig {
+        RustConfig {
+            c_prefix: "zcash_eip681".to_string(),
+            c_type_prefix: "Ffi".to_string(),
+            kotlin_package: Some("zcash.eip681".to_string()),
+            library_name: Some("eip681ffi".to_string()),
+            into_variants: false,
+            columnar: HashSet::new(),
+            alloc_accounting: false,
+            alloc_canaries: false,
+            lifecycle: false,
+            log_bridge: false,
+        }
+    }
+
+    #[test]
+    fn test_generate_rust_from_eip681() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+
+        // Print first for debugging
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        // Idiomatic types
+        assert!(
+            code.contains("pub struct NativeRequest"),
+            "missing idiomatic NativeRequest struct"
+        );
+        assert!(
+            code.contains("pub enum TransactionRequest"),
+            "missing idiomatic TransactionRequest enum"
+        );
+        assert!(
+            code.contains("pub schema_prefix: String,"),
+            "idiomatic type should use String, not FfiByteBuffer"
+        );
+        assert!(
+            code.contains("pub chain_id: Option<u64>,"),
+            "idiomatic type should use Option<u64>"
+        );
+
+        // Trait uses idiomatic types
+        assert!(code.contains("pub trait Eip681"), "missing trait Eip681");
+        assert!(
+            code.contains("fn parser_parse(input: &str) -> Result<TransactionRequest, String>"),
+            "trait should return idiomatic TransactionRequest"
+        );
+
+        // FFI macro
+        assert!(
+            code.contains("macro_rules! witffi_register_ffi"),
+            "missing witffi_register_ffi macro"
+        );
+
+        // JNI macro
+        assert!(
+            code.contains("macro_rules! witffi_register_jni"),
+            "missing witffi_register_jni macro"
+        );
+
+        // Backward compat alias
+        assert!(
+            code.contains("macro_rules! witffi_register {"),
+            "missing backward-compatible witffi_register alias"
+        );
+
+        // repr(C) types inside FFI macro
+        assert!(
+            code.contains("pub struct FfiNativeRequest"),
+            "missing repr(C) FfiNativeRequest inside FFI macro"
+        );
+        assert!(
+            code.contains("#[repr(C)]"),
+            "missing repr(C) attribute inside FFI macro"
+        );
+
+        // Conversion functions inside FFI macro
+        assert!(
+            code.contains("fn native_request_to_ffi"),
+            "missing native_request_to_ffi conversion"
+        );
+        assert!(
+            code.contains("fn transaction_request_to_ffi"),
+            "missing transaction_request_to_ffi conversion"
+        );
+
+        // Cancellation tokens inside FFI macro
+        assert!(
+            code.contains("fn zcash_eip681_cancel_token_new() -> *mut std::ffi::c_void {")
+                && code.contains("unsafe { witffi_types::cancel_token_enter(token) }"),
+            "missing cancellation token functions"
+        );
+    }
+
+    #[test]
+    fn test_generate_c_header_from_eip681() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated C header ===\n{header}");
+
+        assert!(header.contains("#pragma once"), "missing pragma once");
+        assert!(header.contains("FfiByteBuffer"), "missing FfiByteBuffer");
+        assert!(header.contains("FfiByteSlice"), "missing FfiByteSlice");
+        assert!(
+            header.contains("zcash_eip681_parser_parse(FfiByteSlice input)"),
+            "missing parser_parse or wrong param type"
+        );
+        assert!(
+            header.contains("void zcash_eip681_cancel_token_enter(void *token);")
+                && header.contains("void zcash_eip681_cancel_token_exit(void);"),
+            "missing cancellation token functions"
+        );
+    }
+
+    #[test]
+    fn test_generate_jni_macro_content() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+
+        // JNI conversion helpers
+        assert!(
+            code.contains("fn to_jni_native_request"),
+            "missing to_jni_native_request helper"
+        );
+        assert!(
+            code.contains("fn to_jni_transaction_request"),
+            "missing to_jni_transaction_request helper"
+        );
+
+        // JNI entry point
+        assert!(
+            code.contains("Java_zcash_eip681_Eip681_nativeParserParse"),
+            "missing JNI entry point function name"
+        );
+
+        // JNI error handling
+        assert!(
+            code.contains("java/lang/RuntimeException"),
+            "missing RuntimeException in JNI error handling"
+        );
+
+        // JNI class paths
+        assert!(
+            code.contains("zcash/eip681/NativeRequest"),
+            "missing JNI class path for NativeRequest"
+        );
+    }
+
+    #[test]
+    fn test_idiomatic_types_generated() {
+        let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
+        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load eip681.wit");
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+
+        // Verify idiomatic types use Rust-native types, not FFI types
+        assert!(
+            code.contains("pub value_atomic: Option<Vec<u8>>,"),
+            "idiomatic type should use Option<Vec<u8>>"
+        );
+        assert!(
+            code.contains("pub display: String,"),
+            "idiomatic type should use String"
+        );
+
+        // Variant should be a proper Rust enum
+        assert!(
+            code.contains("Native(NativeRequest),"),
+            "variant should use idiomatic enum syntax"
+        );
+        assert!(
+            code.contains("Erc20(Erc20Request),"),
+            "variant should use idiomatic enum syntax"
+        );
+        assert!(
+            code.contains("Unrecognised(String),"),
+            "variant should use String for string payloads"
+        );
+    }
+
+    const COUNTER_WIT: &str = r#"
+        package test:counter;
+
+        interface types {
+            resource counter {
+                constructor(start: u64);
+                get: func() -> u64;
+                increment: func(by: u64);
+                merge: static func(a: counter, b: borrow<counter>) -> counter;
+            }
+
+            try-counter: func(start: string) -> result<counter, string>;
+        }
+
+        world counter {
+            export types;
+        }
+    "#;
+
+    fn load_counter_wit() -> (Resolve, WorldId) {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("counter.wit", COUNTER_WIT)
+            .expect("failed to parse counter WIT");
+        let world_id = resolve.packages[pkg_id].worlds["counter"];
+        (resolve, world_id)
+    }
+
+    #[test]
+    fn test_generate_resource_bindings() {
+        let (resolve, world_id) = load_counter_wit();
+        let config = RustConfig {
+            c_prefix: "witffi".to_string(),
+            ..test_config()
+        };
+
+        let generator = RustGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Rust code");
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        // Trait exposes an associated type per resource
+        assert!(
+            code.contains("type Counter: 'static;"),
+            "missing associated type for counter resource"
+        );
+        assert!(
+            code.contains("fn types_counter_new(start: u64) -> Self::Counter;"),
+            "constructor should return the associated type"
+        );
+        assert!(
+            code.contains("fn types_counter_get(self_: &Self::Counter) -> u64;"),
+            "method should borrow the associated type"
+        );
+        assert!(
+            code.contains(
+                "fn types_counter_merge(a: Self::Counter, b: &Self::Counter) -> Self::Counter;"
+            ),
+            "static should take own and borrow handles"
+        );
+
+        // C ABI functions use opaque pointers
+        assert!(
+            code.contains(
+                "pub unsafe extern \"C\" fn witffi_types_counter_new(start: u64) -> *mut std::ffi::c_void {"
+            ),
+            "constructor should return an opaque handle"
+        );
+        assert!(
+            code.contains(
+                "pub unsafe extern \"C\" fn witffi_types_counter_drop(ptr: *mut std::ffi::c_void) {"
+            ),
+            "missing drop function for counter resource"
+        );
+        assert!(
+            code.contains("*Box::from_raw(a as *mut <$impl_type as Counter>::Counter)"),
+            "own parameters should take ownership of the boxed resource"
+        );
+        assert!(
+            code.contains("&*(b as *const <$impl_type as Counter>::Counter)"),
+            "borrow parameters should reference the boxed resource"
+        );
+        assert!(
+            code.contains(
+                "pub unsafe extern \"C\" fn witffi_types_try_counter(start: witffi_types::FfiByteSlice) -> *mut std::ffi::c_void {"
+            ),
+            "result<own<T>> should return the handle directly"
+        );
+
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+        eprintln!("=== Generated C header ===\n{header}");
+
+        assert!(
+            header.contains("typedef struct FfiCounter FfiCounter;"),
+            "missing opaque typedef for counter"
+        );
+        assert!(
+            header.contains("FfiCounter* witffi_types_counter_new(uint64_t start);"),
+            "missing constructor declaration"
+        );
+        assert!(
+            header.contains("uint64_t witffi_types_counter_get(FfiCounter* self_);"),
+            "missing method declaration"
+        );
+        assert!(
+            header.contains("void witffi_types_counter_drop(FfiCounter *ptr);"),
+            "missing drop declaration"
+        );
+        assert!(
+            header.contains("FfiCounter* witffi_types_try_counter(FfiByteSlice start);"),
+            "result<own<T>> should return the handle directly"
+        );
+    }
+
+    #[test]
+    fn test_generate_c_header_flags() {
+        let source = r#"
+            package test:flags;
+
+            interface files {
+                flags permissions {
+                    read,
+                    write,
+                }
+
+                get-permissions: func(path: string) -> permissions;
+            }
+
+            world filesystem {
+                export files;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("flags.wit", source)
+            .expect("failed to parse flags WIT");
+        let world_id = resolve.packages[pkg_id].worlds["filesystem"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+        eprintln!("=== Generated C header ===\n{header}");
+
+        assert!(
+            header.contains("typedef uint32_t FfiPermissions;"),
+            "missing flags typedef"
+        );
+        assert!(
+            header.contains("#define FFI_PERMISSIONS_WRITE ((FfiPermissions)1 << 1)"),
+            "missing flag bit constant"
+        );
+        assert!(
+            header
+                .contains("FfiPermissions zcash_eip681_files_get_permissions(FfiByteSlice path);"),
+            "missing flags-returning function"
+        );
+    }
+
+    #[test]
+    fn test_generate_enum_conversions() {
+        let source = r#"
+            package test:colors;
+
+            interface palette {
+                enum color {
+                    red,
+                    green,
+                }
+
+                complement: func(c: color) -> color;
+            }
+
+            world colors {
+                export palette;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("colors.wit", source)
+            .expect("failed to parse colors WIT");
+        let world_id = resolve.packages[pkg_id].worlds["colors"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        assert!(
+            code.contains("fn color_to_ffi(v: Color) -> FfiColor {"),
+            "missing idiomatic -> repr(C) enum conversion"
+        );
+        assert!(
+            code.contains("v if v == FfiColor::Green as u32 => Color::Green,"),
+            "missing repr(C) -> idiomatic enum conversion"
+        );
+        assert!(
+            code.contains("v => panic!(\"{v} is not a case of enum `color`\"),"),
+            "values naming no case should fail the call"
+        );
+        assert!(
+            code.contains("fn zcash_eip681_palette_complement(c: u32) -> FfiColor {")
+                && code.contains("let c_rust = color_from_ffi(c);"),
+            "enum params should be taken as u32 and validated"
+        );
+        assert!(
+            code.contains("color_to_ffi(value)"),
+            "enum results should convert to repr(C)"
+        );
+    }
+
+    #[test]
+    fn test_generate_tuple_bindings() {
+        let source = r#"
+            package test:tuples;
+
+            interface stats {
+                lookup: func(key: string) -> tuple<string, u64>;
+                try-lookup: func(key: string) -> result<tuple<string, u64>, string>;
+                store: func(entry: tuple<string, u64>) -> bool;
+            }
+
+            world tuples {
+                export stats;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tuples.wit", source)
+            .expect("failed to parse tuples WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tuples"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        assert!(
+            code.contains("fn stats_lookup(key: &str) -> (String, u64);"),
+            "trait should use idiomatic tuples"
+        );
+        assert!(
+            code.contains("fn stats_store(entry: (&str, u64)) -> bool;"),
+            "tuple params should borrow their elements"
+        );
+        assert_eq!(
+            code.matches("pub struct FfiTuple2StringU64 {").count(),
+            1,
+            "identical tuples should share one repr(C) struct"
+        );
+        assert!(
+            code.contains("fn tuple2_string_u64_to_ffi(v: (String, u64)) -> FfiTuple2StringU64 {"),
+            "missing tuple conversion"
+        );
+        assert!(
+            code.contains("entry_0: witffi_types::FfiByteSlice, entry_1: u64"),
+            "tuple params should be flattened"
+        );
+        assert!(
+            code.contains("let entry_rust = (entry_0_rust, entry_1_rust);"),
+            "flattened tuple params should be reassembled"
+        );
+        assert!(
+            code.contains("fn zcash_eip681_free_tuple2_string_u64(ptr: *mut FfiTuple2StringU64)"),
+            "missing tuple free function"
+        );
+
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+        eprintln!("=== Generated C header ===\n{header}");
+        assert!(
+            header.contains("    FfiByteBuffer f0;\n    uint64_t f1;\n} FfiTuple2StringU64;"),
+            "missing tuple typedef"
+        );
+        assert!(
+            header.contains("FfiTuple2StringU64 zcash_eip681_stats_lookup(FfiByteSlice key);"),
+            "missing tuple-returning function"
+        );
+        assert!(
+            header
+                .contains("bool zcash_eip681_stats_store(FfiByteSlice entry_0, uint64_t entry_1);"),
+            "tuple params should be flattened in the header"
+        );
+    }
+
+    #[test]
+    fn test_generate_nested_variant_bindings() {
+        let source = r#"
+            package test:nested;
+
+            interface exprs {
+                variant leaf {
+                    number(s64),
+                    name(string),
+                }
+
+                record labelled {
+                    label: string,
+                    value: leaf,
+                }
+
+                variant tree {
+                    leaf(leaf),
+                    labelled(labelled),
+                    pair(tuple<leaf, leaf>),
+                    empty,
+                }
+
+                root: func() -> result<tree, string>;
+            }
+
+            world nested {
+                export exprs;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("nested.wit", source)
+            .expect("failed to parse nested WIT");
+        let world_id = resolve.packages[pkg_id].worlds["nested"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+
+        eprintln!("--- Generated Rust code ---\n{code}\n--- End ---");
+
+        // Lowering boxes each payload and recurses into nested variants
+        assert!(
+            code.contains(
+                "leaf: Box::into_raw(Box::new(FfiTreeLeafPayload { value: leaf_to_ffi(inner) })),"
+            ),
+            "nested variant payloads should be lowered recursively"
+        );
+        assert!(
+            code.contains("pub value: FfiLeaf,"),
+            "payload structs should hold nested variants by value"
+        );
+
+        // Releasing walks the payload boxes innermost first
+        assert!(
+            code.contains("fn leaf_release(v: &FfiLeaf) {"),
+            "missing leaf release function"
+        );
+        assert!(
+            code.contains("drop(unsafe { Box::from_raw(v.name) });"),
+            "leaf payload boxes should be freed"
+        );
+        assert!(
+            code.contains("fn tree_release(v: &FfiTree) {"),
+            "missing tree release function"
+        );
+        assert!(
+            code.contains("leaf_release(&payload.value);"),
+            "nested variant payloads should be released recursively"
+        );
+        assert!(
+            code.contains("labelled_release(&payload.value);"),
+            "records holding variants should be released recursively"
+        );
+        assert!(
+            code.contains(
+                "fn labelled_release(v: &FfiLabelled) {\n            leaf_release(&v.value);"
+            ),
+            "record release should recurse into variant fields"
+        );
+        assert!(
+            code.contains("leaf_release(&payload.f0);\n                leaf_release(&payload.f1);"),
+            "tuple payload fields holding variants should be released recursively"
+        );
+        assert!(
+            code.contains("                tree_release(&*v);"),
+            "free_tree should release nested payloads"
+        );
+    }
+
+    #[test]
+    fn test_generate_option_params() {
+        let source = r#"
+            package test:options;
+
+            interface lookup {
+                find: func(name: option<string>, limit: option<u32>) -> option<string>;
+            }
+
+            world options {
+                export lookup;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("options.wit", source)
+            .expect("failed to parse options WIT");
+        let world_id = resolve.packages[pkg_id].worlds["options"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("--- Generated Rust code ---\n{code}\n--- End ---");
+        eprintln!("--- Generated C header ---\n{header}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "fn lookup_find(name: Option<&str>, limit: Option<u32>) -> Option<String>;"
+            ),
+            "optional params should be borrowed in the trait"
+        );
+        assert!(
+            code.contains("name: *const witffi_types::FfiByteSlice, limit: *const u32"),
+            "optional params should be nullable pointers"
+        );
+        assert!(
+            code.contains(
+                "let name_rust = unsafe { name.as_ref() }.map(|v| unsafe { v.as_str_unchecked() });"
+            ),
+            "optional string params should be borrowed"
+        );
+        assert!(
+            code.contains("let limit_rust = unsafe { limit.as_ref() }.map(|v| *v);"),
+            "optional primitive params should be copied"
+        );
+        assert!(
+            header.contains("const FfiByteSlice* name, const uint32_t* limit"),
+            "header should declare optional params as const pointers"
+        );
+    }
+
+    const TYPED_ERROR_WIT: &str = r#"
+        package test:errors;
+
+        interface parser {
+            variant parse-error {
+                empty,
+                invalid-char(u32),
+                too-long(u64),
+            }
+
+            parse: func(input: string) -> result<u64, parse-error>;
+            check: func(input: string) -> result<_, parse-error>;
+            describe: func(input: string) -> result<string, string>;
+        }
+
+        world errors {
+            export parser;
+        }
+    "#;
+
+    #[test]
+    fn test_generate_typed_errors() {
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("errors.wit", TYPED_ERROR_WIT)
+            .expect("failed to parse errors WIT");
+        let world_id = resolve.packages[pkg_id].worlds["errors"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("--- Generated Rust code ---\n{code}\n--- End ---");
+        eprintln!("--- Generated C header ---\n{header}\n--- End ---");
+
+        assert!(
+            code.contains(
+                "input: witffi_types::FfiByteSlice, err_out: *mut *mut FfiParseError) -> *mut u64"
+            ),
+            "typed errors should add an err_out parameter"
+        );
+        assert!(
+            code.contains("unsafe { *err_out = Box::into_raw(Box::new(parse_error_to_ffi(e))) };"),
+            "typed errors should be lowered into err_out"
+        );
+        assert!(
+            code.contains("Some(format!(\"{e:?}\"))"),
+            "typed errors should record a debug message"
+        );
+        assert_eq!(
+            code.matches("err_out: *mut *mut FfiParseError").count(),
+            2,
+            "both typed-error functions should take err_out"
+        );
+        assert!(
+            code.contains("fn zcash_eip681_parser_describe(input: witffi_types::FfiByteSlice) ->"),
+            "string errors should not take err_out"
+        );
+        assert!(
+            header.contains(
+                "bool zcash_eip681_parser_check(FfiByteSlice input, FfiParseError** err_out);"
+            ),
+            "header should declare err_out"
+        );
+    }
+
+    #[test]
+    fn test_generate_char_params() {
+        let source = r#"
+            package test:chars;
+
+            interface text {
+                next-char: func(c: char, fallback: option<char>) -> char;
+            }
+
+            world chars {
+                export text;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("chars.wit", source)
+            .expect("failed to parse chars WIT");
+        let world_id = resolve.packages[pkg_id].worlds["chars"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+
+        eprintln!("--- Generated Rust code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("fn text_next_char(c: char, fallback: Option<char>) -> char;"),
+            "chars should be Rust chars in the trait"
+        );
+        assert!(
+            code.contains("(c: u32, fallback: *const u32) -> u32"),
+            "chars should cross the C ABI as u32"
+        );
+        assert!(
+            code.contains(
+                "let c_rust = char::from_u32(c).unwrap_or_else(|| panic!(\"invalid char {:#x}: not a Unicode scalar value\", c));"
+            ),
+            "char params should be validated"
+        );
+        assert!(
+            code.contains(".map(|v| char::from_u32(*v).unwrap_or_else(|| panic!(\"invalid char {:#x}: not a Unicode scalar value\", *v)));"),
+            "optional char params should be validated"
+        );
+        assert!(
+            !code.contains("REPLACEMENT_CHARACTER"),
+            "invalid chars should be rejected, not substituted"
+        );
+        assert!(
+            code.contains("value as u32"),
+            "char results should be lowered"
+        );
     }
 
-    fn type_to_jni_box_descriptor(&self, ty: &Type) -> &'static str {
-        match ty {
-            Type::Bool => "(Z)V",
-            Type::U8 | Type::S8 => "(B)V",
-            Type::U16 | Type::S16 => "(S)V",
-            Type::U32 | Type::S32 => "(I)V",
-            Type::U64 | Type::S64 => "(J)V",
-            Type::F32 => "(F)V",
-            Type::F64 => "(D)V",
-            Type::Char => "(C)V",
-            _ => "(Ljava/lang/Object;)V",
-        }
+    #[test]
+    fn test_generate_wide_int_bindings() {
+        let source = r#"
+            package test:wide;
+
+            interface ledger {
+                type u128 = tuple<u64, u64>;
+                type s128 = tuple<u64, u64>;
+
+                record entry {
+                    amount: u128,
+                }
+
+                balance: func(account: string) -> u128;
+                adjust: func(delta: s128) -> result<s128, string>;
+            }
+
+            world wide {
+                export ledger;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("wide.wit", source)
+            .expect("failed to parse wide WIT");
+        let world_id = resolve.packages[pkg_id].worlds["wide"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+
+        eprintln!("--- Generated Rust code ---\n{code}\n--- End ---");
+
+        assert!(
+            code.contains("pub type U128 = u128;"),
+            "u128 alias should be u128"
+        );
+        assert!(
+            code.contains("pub type S128 = i128;"),
+            "s128 alias should be i128"
+        );
+        assert!(
+            code.contains("pub amount: u128,"),
+            "record fields should use u128"
+        );
+        assert!(
+            code.contains("fn ledger_adjust(delta: i128) -> Result<i128, String>;"),
+            "trait should use i128"
+        );
+        assert!(
+            code.contains("(delta_0: u64, delta_1: u64)"),
+            "128-bit params should be passed as two words"
+        );
+        assert!(
+            code.contains(
+                "let delta_rust = (((delta_1 as u128) << 64) | delta_0 as u128) as i128;"
+            ),
+            "128-bit params should be reassembled"
+        );
+        assert!(
+            code.contains("tuple2_u64u64_to_ffi((value as u64, (value >> 64) as u64))"),
+            "128-bit results should be split into words"
+        );
+        assert!(
+            code.contains(
+                "amount: tuple2_u64u64_to_ffi((v.amount as u64, (v.amount >> 64) as u64)),"
+            ),
+            "128-bit record fields should be split into words"
+        );
     }
 
-    fn type_to_jvalue_primitive(&self, ty: &Type, expr: &str) -> String {
-        match ty {
-            Type::Bool => format!("jni::objects::JValue::Bool(*{expr} as u8)"),
-            Type::U8 | Type::S8 => format!("jni::objects::JValue::Byte(*{expr} as i8)"),
-            Type::U16 | Type::S16 => format!("jni::objects::JValue::Short(*{expr} as i16)"),
-            Type::U32 | Type::S32 => format!("jni::objects::JValue::Int(*{expr} as i32)"),
-            Type::U64 | Type::S64 => format!("jni::objects::JValue::Long(*{expr} as i64)"),
-            Type::F32 => format!("jni::objects::JValue::Float(*{expr})"),
-            Type::F64 => format!("jni::objects::JValue::Double(*{expr})"),
-            Type::Char => format!("jni::objects::JValue::Char(*{expr} as u16)"),
-            _ => "jni::objects::JValue::Object(&jni::objects::JObject::null())".to_string(),
-        }
+    #[test]
+    fn test_generate_fixed_list_bindings() {
+        let source = r#"
+            package test:arrays;
+
+            interface hashing {
+                record block {
+                    hash: list<u8, 32>,
+                    tags: list<string, 2>,
+                }
+
+                digest: func(data: list<u8>) -> list<u8, 32>;
+                try-block: func(height: u64) -> result<block, string>;
+                mix: func(seed: list<u32, 3>) -> u32;
+            }
+
+            world arrays {
+                export hashing;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("arrays.wit", source)
+            .expect("failed to parse arrays WIT");
+        let world_id = resolve.packages[pkg_id].worlds["arrays"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        assert!(
+            code.contains("pub hash: [u8; 32],"),
+            "fixed-length list fields should be arrays"
+        );
+        assert!(
+            code.contains("fn hashing_digest(data: &[u8]) -> [u8; 32];"),
+            "trait should return arrays"
+        );
+        assert!(
+            code.contains("fn hashing_mix(seed: [u32; 3]) -> u32;"),
+            "trait should take arrays"
+        );
+        assert_eq!(
+            code.matches("pub struct FfiArray32U8 {").count(),
+            1,
+            "identical fixed-length lists should share one repr(C) struct"
+        );
+        assert!(
+            code.contains("pub elems: [u8; 32],"),
+            "fixed-length lists should be stored inline"
+        );
+        assert!(
+            code.contains("fn array32_u8_to_ffi(v: [u8; 32]) -> FfiArray32U8 {"),
+            "missing fixed-length list conversion"
+        );
+        assert!(
+            code.contains("elems: v.map(|e| witffi_types::FfiByteBuffer::from_string(e)),"),
+            "string elements should be converted"
+        );
+        assert!(
+            code.contains("seed_0: u32, seed_1: u32, seed_2: u32"),
+            "fixed-length list params should be flattened"
+        );
+        assert!(
+            code.contains("let seed_rust = [seed_0_rust, seed_1_rust, seed_2_rust];"),
+            "flattened fixed-length list params should be reassembled"
+        );
+
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+        eprintln!("=== Generated C header ===\n{header}");
+        assert!(
+            header.contains("typedef struct {\n    uint8_t elems[32];\n} FfiArray32U8;"),
+            "missing fixed-length list typedef"
+        );
+        assert!(
+            header.contains("FfiArray32U8 zcash_eip681_hashing_digest(FfiByteSlice data);"),
+            "missing array-returning function"
+        );
+        assert!(
+            header.contains(
+                "uint32_t zcash_eip681_hashing_mix(uint32_t seed_0, uint32_t seed_1, uint32_t seed_2);"
+            ),
+            "fixed-length list params should be flattened in the header"
+        );
     }
 
-    fn type_to_jvalue(&self, ty: &Type, expr: &str) -> String {
-        match ty {
-            Type::Bool => format!("jni::objects::JValue::Bool({expr} as u8)"),
-            Type::U8 | Type::S8 => format!("jni::objects::JValue::Byte({expr} as i8)"),
-            Type::U16 | Type::S16 => format!("jni::objects::JValue::Short({expr} as i16)"),
-            Type::U32 | Type::S32 => format!("jni::objects::JValue::Int({expr} as i32)"),
-            Type::U64 | Type::S64 => format!("jni::objects::JValue::Long({expr} as i64)"),
-            Type::F32 => format!("jni::objects::JValue::Float({expr})"),
-            Type::F64 => format!("jni::objects::JValue::Double({expr})"),
-            Type::Char => format!("jni::objects::JValue::Char({expr} as u16)"),
-            Type::String => format!("jni::objects::JValue::Object(&{expr})"),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        format!("jni::objects::JValue::Object(&{expr})")
-                    }
-                    TypeDefKind::Option(_) => {
-                        format!("jni::objects::JValue::Object(&{expr})")
-                    }
-                    TypeDefKind::Type(aliased) => self.type_to_jvalue(aliased, expr),
-                    TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
-                        format!("jni::objects::JValue::Object(&{expr})")
-                    }
-                    _ => format!("jni::objects::JValue::Object(&{expr})"),
+    #[test]
+    fn test_generate_nested_container_bindings() {
+        let source = r#"
+            package test:nested;
+
+            interface geometry {
+                record point {
+                    x: s32,
+                    y: s32,
                 }
+
+                record mesh {
+                    faces: list<list<list<point>>>,
+                    labels: list<option<string>>,
+                }
+
+                build: func(rows: list<list<string>>) -> mesh;
+                validate: func(ids: list<u32>) -> list<result<u32, string>>;
             }
-            Type::ErrorContext => format!("jni::objects::JValue::Object(&{expr})"),
-        }
+
+            world nested {
+                export geometry;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("nested.wit", source)
+            .expect("failed to parse nested WIT");
+        let world_id = resolve.packages[pkg_id].worlds["nested"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        assert!(
+            code.contains(
+                "pub faces: witffi_types::FfiList<witffi_types::FfiList<witffi_types::FfiList<FfiPoint>>>,"
+            ),
+            "nested lists should be nested FfiLists"
+        );
+        assert!(
+            code.contains("pub labels: witffi_types::FfiList<*mut witffi_types::FfiByteBuffer>,"),
+            "lists of options should hold nullable pointers"
+        );
+        assert!(
+            code.contains("witffi_types::FfiList::from_vec(v.faces.into_iter().map(|e| witffi_types::FfiList::from_vec(e.into_iter().map(|e| witffi_types::FfiList::from_vec(e.into_iter().map(|e| point_to_ffi(e)).collect())).collect())).collect())"),
+            "nested lists should be converted level by level"
+        );
+        assert!(
+            code.contains("pub struct FfiResultU32String {"),
+            "nested results should get a repr(C) struct"
+        );
+        assert!(
+            code.contains(
+                "fn result_u32_string_to_ffi(v: Result<u32, String>) -> FfiResultU32String {"
+            ),
+            "missing nested result conversion"
+        );
+        assert!(
+            code.contains(
+                "rows: witffi_types::FfiSlice<witffi_types::FfiSlice<witffi_types::FfiByteSlice>>"
+            ),
+            "nested list params should be borrowed slices"
+        );
+        assert!(
+            code.contains("let rows_rust: Vec<_> = unsafe { rows.as_slice() }.iter().map(|e| unsafe { e.as_slice() }.iter().map(|e| unsafe { e.as_str_unchecked() }.to_string()).collect::<Vec<_>>()).collect();"),
+            "nested list params should be copied into owned Vecs"
+        );
+        assert!(
+            code.contains("fn zcash_eip681_free_list_list_list_point("),
+            "missing list free function"
+        );
+
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+        eprintln!("=== Generated C header ===\n{header}");
+
+        assert!(
+            header.contains("    FfiListListPoint *ptr;\n    size_t len;\n} FfiListListListPoint;"),
+            "header should declare nested list structs"
+        );
+        assert!(
+            header.contains("} FfiListListStringSlice;"),
+            "header should declare input list slices"
+        );
+        assert!(
+            header.contains(
+                "    bool is_ok;\n    uint32_t *ok;\n    FfiByteBuffer *err;\n} FfiResultU32String;"
+            ),
+            "header should declare nested result structs"
+        );
     }
 
-    fn type_to_jni_descriptor(&self, ty: &Type, kotlin_package: &str) -> String {
-        let jni_pkg = kotlin_package.replace('.', "/");
-        match ty {
-            Type::Bool => "Z".to_string(),
-            Type::U8 | Type::S8 => "B".to_string(),
-            Type::U16 | Type::S16 => "S".to_string(),
-            Type::U32 | Type::S32 => "I".to_string(),
-            Type::U64 | Type::S64 => "J".to_string(),
-            Type::F32 => "F".to_string(),
-            Type::F64 => "D".to_string(),
-            Type::Char => "C".to_string(),
-            Type::String => "Ljava/lang/String;".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => "[B".to_string(),
-                    TypeDefKind::List(_) => "[Ljava/lang/Object;".to_string(),
-                    TypeDefKind::Option(inner) => {
-                        if self.is_jni_primitive(inner) {
-                            format!("L{};", self.type_to_jni_boxed_class(inner))
-                        } else {
-                            self.type_to_jni_descriptor(inner, kotlin_package)
-                        }
-                    }
-                    TypeDefKind::Type(aliased) => {
-                        self.type_to_jni_descriptor(aliased, kotlin_package)
-                    }
-                    _ => {
-                        let name = typedef.name.as_deref().unwrap_or("Object");
-                        let kotlin_name = names::to_kotlin_type(name);
-                        format!("L{jni_pkg}/{kotlin_name};")
-                    }
+    #[test]
+    fn test_generate_tuple_list_params() {
+        let source = r#"
+            package test:catalog;
+
+            interface catalog {
+                index: func(entries: list<tuple<string, u32>>) -> list<tuple<string, u32>>;
+            }
+
+            world catalog-world {
+                export catalog;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("catalog.wit", source)
+            .expect("failed to parse catalog WIT");
+        let world_id = resolve.packages[pkg_id].worlds["catalog-world"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        assert!(
+            code.contains("pub struct FfiTuple2StringU32Input {\n            pub f0: witffi_types::FfiByteSlice,"),
+            "tuples holding strings should get an input struct"
+        );
+        assert!(
+            code.contains("entries: witffi_types::FfiSlice<FfiTuple2StringU32Input>"),
+            "tuple list params should borrow input structs"
+        );
+        assert!(
+            code.contains(".iter().map(|e| (unsafe { (&e.f0).as_str_unchecked() }.to_string(), *(&e.f1))).collect();"),
+            "tuple elements should be copied into owned tuples"
+        );
+
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+        eprintln!("=== Generated C header ===\n{header}");
+
+        assert!(
+            header.contains("} FfiTuple2StringU32Input;"),
+            "header should declare tuple input structs"
+        );
+    }
+
+    #[test]
+    fn test_generate_multi_field_variant_payloads() {
+        let source = r#"
+            package test:shapes;
+
+            interface geometry {
+                variant shape {
+                    circle(f32),
+                    rect(tuple<u8, u64, string>),
+                    point(tuple<s16, s16>),
+                    none,
                 }
+
+                largest: func() -> result<shape, string>;
             }
-            Type::ErrorContext => "Ljava/lang/String;".to_string(),
-        }
-    }
 
-    fn record_jni_constructor_descriptor(
-        &self,
-        record: &wit_parser::Record,
-        kotlin_package: &str,
-    ) -> String {
-        let params: Vec<String> = record
-            .fields
-            .iter()
-            .map(|f| self.type_to_jni_descriptor(&f.ty, kotlin_package))
-            .collect();
-        format!("({})V", params.join(""))
+            world shapes {
+                export geometry;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("shapes.wit", source)
+            .expect("failed to parse shapes WIT");
+        let world_id = resolve.packages[pkg_id].worlds["shapes"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate header");
+
+        eprintln!("--- Generated Rust code ---\n{code}\n--- End ---");
+        eprintln!("--- Generated header ---\n{header}\n--- End ---");
+
+        assert!(
+            code.contains("pub struct FfiShapeCirclePayload {\n            pub value: f32,"),
+            "single payloads should keep the value field"
+        );
+        assert!(
+            code.contains(
+                "pub struct FfiShapeRectPayload {\n            pub f0: u8,\n            pub f1: u64,\n            pub f2: witffi_types::FfiByteBuffer,"
+            ),
+            "tuple payloads should get one field per element"
+        );
+        assert!(
+            code.contains(
+                "FfiShapeRectPayload { f0: inner.0, f1: inner.1, f2: witffi_types::FfiByteBuffer::from_string(inner.2) }"
+            ),
+            "tuple payload elements should be lowered individually"
+        );
+        assert!(
+            code.contains("drop(unsafe { Box::from_raw(v.rect) });"),
+            "flat tuple payloads only need the box freed"
+        );
+        assert!(
+            header.contains(
+                "    uint8_t f0;\n    uint64_t f1;\n    FfiByteBuffer f2;\n} FfiShapeRectPayload;"
+            ),
+            "header payload structs should mirror the tuple fields"
+        );
     }
 
-    fn generate_jni_entry_point(
-        &self,
-        out: &mut String,
-        ef: &ExportedFunction,
-        jni_class_path: &str,
-        world_class: &str,
-    ) -> std::fmt::Result {
-        let trait_method = self.trait_method_name(ef);
-        let result_decomposed = self.decompose_result(&ef.function.result);
+    #[test]
+    fn test_generate_cross_package_types() {
+        let common = r#"
+            package test:common;
+
+            interface money {
+                record amount {
+                    value: u64,
+                    currency: string,
+                }
+            }
+        "#;
+        let source = r#"
+            package test:shop;
 
-        // Build the JNI function name
-        // The Kotlin generator emits @JvmStatic on the companion's native method,
-        // so the JVM resolves it as a static method on the outer class.
-        // Convention: Java_{package}_{Class}_native{Method}
-        let method_pascal = names::to_rust_type(&if ef.interface_name.is_empty() {
-            ef.function_name.clone()
-        } else {
-            format!("{}-{}", ef.interface_name, ef.function_name)
-        });
-        let jni_func_name = format!(
-            "Java_{}_{world_class}_native{method_pascal}",
-            jni_class_path.replace('/', "_"),
+            interface prices {
+                use test:common/money.{amount};
+
+                price: func(sku: string) -> amount;
+            }
+
+            interface refunds {
+                use test:common/money.{amount};
+
+                refund: func(sku: string, total: u64) -> amount;
+            }
+
+            world shop {
+                export prices;
+                export refunds;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        resolve
+            .push_str("common.wit", common)
+            .expect("failed to parse common WIT");
+        let pkg_id = resolve
+            .push_str("shop.wit", source)
+            .expect("failed to parse shop WIT");
+        let world_id = resolve.packages[pkg_id].worlds["shop"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate header");
+
+        eprintln!("--- Generated Rust code ---\n{code}\n--- End ---");
+
+        assert_eq!(
+            code.matches("pub struct Amount {").count(),
+            1,
+            "types from another package should be generated once"
+        );
+        assert!(
+            !code.contains("pub type Amount = Amount;"),
+            "`use` aliases should not redeclare the type"
         );
+        assert!(
+            code.contains("fn prices_price(sku: &str) -> Amount;"),
+            "dependent interfaces should reference the shared type"
+        );
+        assert_eq!(
+            header.matches("} FfiAmount;").count(),
+            1,
+            "the header should declare the shared type once"
+        );
+    }
 
-        // Build JNI parameter list
-        let mut jni_params = vec![
-            "mut env: jni::JNIEnv<'local>".to_string(),
-            "_class: jni::objects::JClass<'local>".to_string(),
-        ];
-        for p in &ef.function.params {
-            let name = names::to_rust_ident(&p.name);
-            let jni_ty = self.type_to_jni_param_type(&p.ty);
-            jni_params.push(format!("{name}: {jni_ty}"));
-        }
+    #[test]
+    fn test_generate_imported_functions() {
+        let source = r#"
+            package test:host;
+
+            interface host {
+                /// Read a file on the caller's side.
+                read-file: func(path: string) -> result<string, string>;
+                log: func(message: string);
+                checksum: func(data: list<u8>) -> u32;
+                flush: func() -> result<_, string>;
+            }
 
-        // Determine return type
-        let jni_return = if result_decomposed.is_some() {
-            "jni::sys::jobject".to_string()
-        } else {
-            match &ef.function.result {
-                Some(ty) => self.type_to_jni_return_type(ty),
-                None => "()".to_string(),
+            interface parser {
+                parse: func(input: string) -> u32;
             }
-        };
 
-        writeln!(out, "        #[unsafe(no_mangle)]")?;
-        writeln!(out, "        pub extern \"C\" fn {jni_func_name}<'local>(")?;
-        for param in &jni_params {
-            writeln!(out, "            {param},")?;
-        }
-        if jni_return == "()" {
-            writeln!(out, "        ) {{")?;
-        } else {
-            writeln!(out, "        ) -> {jni_return} {{")?;
-        }
+            world app {
+                import host;
+                export parser;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("host.wit", source)
+            .expect("failed to parse host WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
 
-        // Rebind env as &mut so it can be used before and after catch_unwind
-        // without being moved into the closure.
-        writeln!(out, "            let env = &mut env;")?;
-        writeln!(out)?;
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
 
-        // Step 1: Convert JNI params to Rust types (uses env directly)
-        let early_return = if jni_return == "()" {
-            "return".to_string()
-        } else if jni_return == "jni::sys::jobject" {
-            "return std::ptr::null_mut()".to_string()
-        } else {
-            "return Default::default()".to_string()
-        };
-        for p in &ef.function.params {
-            let name = names::to_rust_ident(&p.name);
-            self.generate_jni_param_conversion_safe(out, &name, &p.ty, &early_return)?;
-        }
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
 
-        let rust_args: Vec<String> = ef
-            .function
-            .params
-            .iter()
-            .map(|p| {
-                let name = format!("{}_rust", names::to_rust_ident(&p.name));
-                if self.jni_arg_needs_borrow(&p.ty) {
-                    format!("&{name}")
-                } else {
-                    name
-                }
-            })
-            .collect();
+        assert!(
+            code.contains(
+                "        fn zcash_eip681_import_host_read_file(path: witffi_types::FfiByteSlice, ok_out: *mut witffi_types::FfiByteBuffer, err_out: *mut witffi_types::FfiByteBuffer) -> bool;"
+            ),
+            "fallible imports should be declared with ok/err out-pointers"
+        );
+        assert!(
+            code.contains("    pub mod host {"),
+            "imports should be grouped by interface"
+        );
+        assert!(
+            code.contains("        /// Read a file on the caller's side.\n        pub fn read_file(path: &str) -> Result<String, String> {"),
+            "fallible imports should return a Result"
+        );
+        assert!(
+            code.contains("super::zcash_eip681_import_host_read_file(witffi_types::FfiByteSlice { ptr: path.as_ptr(), len: path.len() }, &mut ok_out, &mut err_out)"),
+            "string arguments should be borrowed as slices"
+        );
+        assert!(
+            code.contains("Ok(unsafe { super::take_string(ok_out) })"),
+            "returned strings should be copied and freed"
+        );
+        assert!(
+            code.contains("pub fn checksum(data: &[u8]) -> u32 {"),
+            "bytes and numbers should map to Rust types"
+        );
+        assert!(
+            code.contains("pub fn flush() -> Result<(), String> {"),
+            "result<_, string> imports should return Result<(), String>"
+        );
+        assert!(
+            code.contains("unsafe { free(buf.ptr.cast()) };"),
+            "returned buffers should be released with free"
+        );
+        assert!(
+            !code.contains("fn host_read_file"),
+            "imports should not be part of the implementation trait"
+        );
+        assert!(
+            header.contains(
+                "bool zcash_eip681_import_host_read_file(FfiByteSlice path, FfiByteBuffer *ok_out, FfiByteBuffer *err_out);"
+            ),
+            "the header should declare imports for the caller to define"
+        );
+        assert!(
+            header.contains("void zcash_eip681_import_host_log(FfiByteSlice message);"),
+            "infallible imports should be declared directly"
+        );
+    }
 
-        // Step 2: Call the trait method inside catch_unwind (pure Rust, no env)
-        writeln!(
-            out,
-            "            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {{"
-        )?;
-        writeln!(
-            out,
-            "                <$impl_type>::{trait_method}({})",
-            rust_args.join(", ")
-        )?;
-        writeln!(out, "            }}));")?;
-        writeln!(out)?;
+    #[test]
+    fn test_generate_imported_resources() {
+        let source = r#"
+            package test:bidi;
 
-        // Step 3: Handle result and convert to JNI (uses env directly)
-        if let Some((ref ok_ty, _)) = result_decomposed {
-            writeln!(out, "            match result {{")?;
-            writeln!(out, "                Ok(Ok(value)) => {{")?;
-            if let Some(ty) = ok_ty {
-                let jni_conv = self.generate_to_jni_field_expr(ty, "value");
-                // Wrap conversion in a closure so `?` works inside it
-                writeln!(
-                    out,
-                    "                    let mut convert = || -> jni::errors::Result<jni::sys::jobject> {{"
-                )?;
-                writeln!(out, "                        let obj = {jni_conv};")?;
-                writeln!(out, "                        Ok(obj.into_raw())")?;
-                writeln!(out, "                    }};")?;
-                writeln!(out, "                    match convert() {{")?;
-                writeln!(out, "                        Ok(ptr) => ptr,")?;
-                writeln!(out, "                        Err(e) => {{")?;
-                writeln!(
-                    out,
-                    "                            let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
-                )?;
-                writeln!(out, "                            std::ptr::null_mut()")?;
-                writeln!(out, "                        }}")?;
-                writeln!(out, "                    }}")?;
-            } else {
-                writeln!(out, "                    std::ptr::null_mut()")?;
+            interface types {
+                resource counter;
             }
-            writeln!(out, "                }}")?;
-            writeln!(out, "                Ok(Err(e)) => {{")?;
-            writeln!(
-                out,
-                "                    let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
-            )?;
-            writeln!(out, "                    std::ptr::null_mut()")?;
-            writeln!(out, "                }}")?;
-            writeln!(out, "                Err(_panic) => {{")?;
-            writeln!(
-                out,
-                "                    let _ = env.throw_new(\"java/lang/RuntimeException\", \"Rust panic\");"
-            )?;
-            writeln!(out, "                    std::ptr::null_mut()")?;
-            writeln!(out, "                }}")?;
-            writeln!(out, "            }}")?;
-        } else {
-            writeln!(out, "            match result {{")?;
-            let is_primitive_return = match &ef.function.result {
-                Some(ty) => self.is_jni_primitive(ty),
-                None => true,
-            };
-            if is_primitive_return {
-                writeln!(out, "                Ok(value) => value,")?;
-            } else {
-                let ty = ef.function.result.as_ref().unwrap();
-                let jni_conv = self.generate_to_jni_field_expr(ty, "value");
-                writeln!(out, "                Ok(value) => {{")?;
-                writeln!(
-                    out,
-                    "                    let convert = || -> jni::errors::Result<jni::sys::jobject> {{"
-                )?;
-                writeln!(out, "                        let obj = {jni_conv};")?;
-                writeln!(out, "                        Ok(obj.into_raw())")?;
-                writeln!(out, "                    }};")?;
-                writeln!(out, "                    match convert() {{")?;
-                writeln!(out, "                        Ok(ptr) => ptr,")?;
-                writeln!(out, "                        Err(e) => {{")?;
-                writeln!(
-                    out,
-                    "                            let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
-                )?;
-                writeln!(out, "                            std::ptr::null_mut()")?;
-                writeln!(out, "                        }}")?;
-                writeln!(out, "                    }}")?;
-                writeln!(out, "                }}")?;
+
+            interface host {
+                use types.{counter};
+
+                /// Inspect a counter owned by Rust.
+                inspect: func(c: borrow<counter>) -> u64;
+                adopt: func(c: counter);
+                spawn: func() -> result<counter, string>;
+            }
+
+            interface api {
+                use types.{counter};
+
+                make-counter: func(start: u64) -> counter;
+                tally: func(c: borrow<counter>) -> u64;
             }
-            writeln!(out, "                Err(_panic) => {{")?;
-            writeln!(
-                out,
-                "                    let _ = env.throw_new(\"java/lang/RuntimeException\", \"Rust panic\");"
-            )?;
-            if is_primitive_return {
-                writeln!(out, "                    Default::default()")?;
-            } else {
-                writeln!(out, "                    std::ptr::null_mut()")?;
+
+            world app {
+                import host;
+                export api;
             }
-            writeln!(out, "                }}")?;
-            writeln!(out, "            }}")?;
-        }
 
-        writeln!(out, "        }}")?;
-        writeln!(out)?;
+            world lonely {
+                import host;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("bidi.wit", source)
+            .expect("failed to parse bidi WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
 
-        Ok(())
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
+
+        assert!(
+            code.contains("pub fn inspect<T: super::super::App>(c: &T::Counter) -> u64 {"),
+            "borrowed resources should be the implementation's associated type"
+        );
+        assert!(
+            code.contains(
+                "super::zcash_eip681_import_host_inspect(c as *const T::Counter as *mut std::ffi::c_void)"
+            ),
+            "borrows should pass the caller's value without giving it up"
+        );
+        assert!(
+            code.contains("Box::into_raw(Box::new(c)) as *mut std::ffi::c_void"),
+            "owned resources should be boxed like exported handles"
+        );
+        assert!(
+            code.contains("pub fn spawn<T: super::super::App>() -> Result<T::Counter, String> {"),
+            "returned resources should be owned by the caller"
+        );
+        assert!(
+            code.contains("Ok(unsafe { super::take_handle::<T::Counter>(ok_out) })"),
+            "returned handles should be unboxed"
+        );
+        // An implementation can lend its borrow on to an import and back
+        assert!(
+            code.contains("fn api_tally(c: &Self::Counter) -> u64;"),
+            "exports should borrow the same associated type"
+        );
+        assert!(
+            code.contains("&*(c as *const <$impl_type as App>::Counter)"),
+            "exports should reborrow handles passed back from imports"
+        );
+        assert!(
+            header.contains("uint64_t zcash_eip681_import_host_inspect(FfiCounter* c);"),
+            "the header should declare handles as resource pointers"
+        );
     }
 
-    fn type_to_jni_param_type(&self, ty: &Type) -> String {
-        match ty {
-            Type::String => "jni::objects::JString<'local>".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => "jni::objects::JByteArray<'local>".to_string(),
-                    TypeDefKind::Type(aliased) => self.type_to_jni_param_type(aliased),
-                    _ => "jni::objects::JObject<'local>".to_string(),
-                }
+    #[test]
+    fn test_generate_async_functions() {
+        let source = r#"
+            package test:tasks;
+
+            interface types {
+                resource job;
             }
-            Type::Bool => "jni::sys::jboolean".to_string(),
-            Type::U8 | Type::S8 => "jni::sys::jbyte".to_string(),
-            Type::U16 | Type::S16 => "jni::sys::jshort".to_string(),
-            Type::U32 | Type::S32 => "jni::sys::jint".to_string(),
-            Type::U64 | Type::S64 => "jni::sys::jlong".to_string(),
-            Type::F32 => "jni::sys::jfloat".to_string(),
-            Type::F64 => "jni::sys::jdouble".to_string(),
-            _ => "jni::objects::JObject<'local>".to_string(),
-        }
-    }
 
-    fn type_to_jni_return_type(&self, ty: &Type) -> String {
-        match ty {
-            Type::Bool => "jni::sys::jboolean".to_string(),
-            Type::U8 | Type::S8 => "jni::sys::jbyte".to_string(),
-            Type::U16 | Type::S16 => "jni::sys::jshort".to_string(),
-            Type::U32 | Type::S32 => "jni::sys::jint".to_string(),
-            Type::U64 | Type::S64 => "jni::sys::jlong".to_string(),
-            Type::F32 => "jni::sys::jfloat".to_string(),
-            Type::F64 => "jni::sys::jdouble".to_string(),
-            _ => "jni::sys::jobject".to_string(),
-        }
-    }
+            interface api {
+                use types.{job};
 
-    /// Check if a JNI-converted arg needs a borrow when passed to the trait method.
-    ///
-    /// The JNI conversion produces owned types (e.g. `String`, `Vec<u8>`) but
-    /// the trait method uses borrowed types (`&str`, `&[u8]`) for these.
-    fn jni_arg_needs_borrow(&self, ty: &Type) -> bool {
-        match ty {
-            Type::String => true,
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => true,
-                    TypeDefKind::Type(aliased) => self.jni_arg_needs_borrow(aliased),
-                    _ => false,
-                }
+                /// Fetch a page.
+                fetch: async func(url: string, retries: option<u32>) -> result<string, string>;
+                sleep: async func(ms: u64);
+                label: async func(tags: tuple<string, u32>) -> string;
+                start: async func(id: u64) -> job;
             }
-            _ => false,
-        }
+
+            world tasks {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("tasks.wit", source)
+            .expect("failed to parse tasks WIT");
+        let world_id = resolve.packages[pkg_id].worlds["tasks"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
+
+        assert!(
+            code.contains(
+                "fn api_fetch(url: String, retries: Option<u32>) -> impl std::future::Future<Output = Result<String, String>> + Send + 'static;"
+            ),
+            "async methods should take owned arguments and return a future"
+        );
+        assert!(
+            code.contains("fn spawn_task(task: witffi_types::AsyncTask) {\n        witffi_types::spawn_thread(task);"),
+            "the trait should spawn tasks on a thread by default"
+        );
+        assert!(
+            code.contains("complete: witffi_types::FfiAsyncComplete, user_data: usize) {"),
+            "async functions should take a completion callback"
+        );
+        assert!(
+            code.contains("let url_rust = url_rust.to_string();")
+                && code.contains("let tags_rust = (tags_rust.0.to_string(), tags_rust.1);"),
+            "borrowed arguments should be copied before the call returns"
+        );
+        assert!(
+            code.contains("<$impl_type as Tasks>::spawn_task,"),
+            "futures should be spawned by the implementation"
+        );
+        assert!(
+            code.contains(
+                "pub unsafe extern \"C\" fn zcash_eip681_api_fetch_finish(task: *mut std::ffi::c_void) -> *mut witffi_types::FfiByteBuffer {"
+            ) && code.contains("witffi_types::finish_async::<Result<String, String>>(task)"),
+            "results should be collected like synchronous returns"
+        );
+        assert!(
+            code.contains("witffi_types::finish_async::<<$impl_type as Tasks>::Job>(task)"),
+            "resources should be named through the implementation"
+        );
+        assert!(
+            !code.contains("fn Java_"),
+            "async functions are not yet supported over JNI"
+        );
+        assert!(
+            header.contains(
+                "void zcash_eip681_api_sleep(uint64_t ms, FfiAsyncComplete complete, uintptr_t user_data);"
+            ) && header.contains("void zcash_eip681_api_sleep_finish(void *task);"),
+            "the header should declare both halves of the call"
+        );
     }
 
-    /// Generate JNI parameter conversion code that is safe to use outside of
-    /// a `Result`-returning context. Instead of using `?`, conversion errors
-    /// throw a Java exception and return early with `early_return`.
-    fn generate_jni_param_conversion_safe(
-        &self,
-        out: &mut String,
-        name: &str,
-        ty: &Type,
-        early_return: &str,
-    ) -> std::fmt::Result {
-        match ty {
-            Type::String => {
-                writeln!(
-                    out,
-                    "            let {name}_rust: String = match env.get_string(&{name}) {{"
-                )?;
-                writeln!(out, "                Ok(s) => s.into(),")?;
-                writeln!(out, "                Err(e) => {{")?;
-                writeln!(
-                    out,
-                    "                    let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
-                )?;
-                writeln!(out, "                    {early_return};")?;
-                writeln!(out, "                }}")?;
-                writeln!(out, "            }};")?;
+    #[test]
+    fn test_generate_stream_results() {
+        let source = r#"
+            package test:logs;
+
+            interface types {
+                resource cursor;
+
+                record entry {
+                    line: string,
+                    level: u8,
+                }
             }
-            Type::Bool => {
-                writeln!(out, "            let {name}_rust = {name} != 0;")?;
+
+            interface api {
+                use types.{cursor, entry};
+
+                /// Follow a log file.
+                tail: func(path: string) -> stream<entry>;
+                counts: func() -> stream<u32>;
+                cursors: func() -> stream<cursor>;
             }
-            Type::U8 => writeln!(out, "            let {name}_rust = {name} as u8;")?,
-            Type::U16 => writeln!(out, "            let {name}_rust = {name} as u16;")?,
-            Type::U32 => writeln!(out, "            let {name}_rust = {name} as u32;")?,
-            Type::U64 => writeln!(out, "            let {name}_rust = {name} as u64;")?,
-            Type::S8 => writeln!(out, "            let {name}_rust = {name} as i8;")?,
-            Type::S16 => writeln!(out, "            let {name}_rust = {name} as i16;")?,
-            Type::S32 => writeln!(out, "            let {name}_rust = {name} as i32;")?,
-            Type::S64 => writeln!(out, "            let {name}_rust = {name} as i64;")?,
-            Type::F32 | Type::F64 => {
-                writeln!(out, "            let {name}_rust = {name};")?;
+
+            world logs {
+                export api;
             }
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        writeln!(
-                            out,
-                            "            let {name}_rust = match env.convert_byte_array(&{name}) {{"
-                        )?;
-                        writeln!(out, "                Ok(v) => v,")?;
-                        writeln!(out, "                Err(e) => {{")?;
-                        writeln!(
-                            out,
-                            "                    let _ = env.throw_new(\"java/lang/RuntimeException\", format!(\"{{e}}\"));"
-                        )?;
-                        writeln!(out, "                    {early_return};")?;
-                        writeln!(out, "                }}")?;
-                        writeln!(out, "            }};")?;
-                    }
-                    TypeDefKind::Type(aliased) => {
-                        self.generate_jni_param_conversion_safe(out, name, aliased, early_return)?;
-                    }
-                    _ => {
-                        writeln!(out, "            let {name}_rust = {name};")?;
-                    }
-                }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("logs.wit", source)
+            .expect("failed to parse logs WIT");
+        let world_id = resolve.packages[pkg_id].worlds["logs"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
+
+        assert!(
+            code.contains(
+                "fn api_tail(path: String) -> impl Iterator<Item = Entry> + Send + 'static;"
+            ),
+            "stream methods should take owned arguments and return an iterator"
+        );
+        assert!(
+            code.contains("let path_rust = path_rust.to_string();"),
+            "borrowed arguments should be copied before the call returns"
+        );
+        assert!(
+            code.contains("witffi_types::stream_into_ptr::<Entry>(items)"),
+            "the iterator should be returned as an opaque producer"
+        );
+        assert!(
+            code.contains(
+                "fn zcash_eip681_api_tail_next(stream: *mut std::ffi::c_void) -> *mut FfiEntry {"
+            ),
+            "elements should be pulled one at a time"
+        );
+        assert!(
+            code.contains("witffi_types::stream_next::<Entry>(stream)"),
+            "elements should be pulled from the producer"
+        );
+        assert!(
+            code.contains("fn zcash_eip681_api_cursors_next(stream: *mut std::ffi::c_void) -> *mut std::ffi::c_void {"),
+            "resource elements should be returned as handles"
+        );
+        assert!(
+            code.contains("witffi_types::stream_drop::<Entry>(stream)"),
+            "producers should be dropped by the caller"
+        );
+        assert!(
+            !code.contains("TODO: generate type"),
+            "stream types should not need a type definition"
+        );
+        assert!(
+            header.contains("void *zcash_eip681_api_tail(FfiByteSlice path);")
+                && header.contains("FfiEntry* zcash_eip681_api_tail_next(void *stream);")
+                && header.contains("void zcash_eip681_api_tail_drop(void *stream);"),
+            "the header should declare the stream functions"
+        );
+        assert!(
+            header.contains("FfiCursor* zcash_eip681_api_cursors_next(void *stream);"),
+            "the header should declare handle elements"
+        );
+    }
+
+    #[test]
+    fn test_generate_future_results() {
+        let source = r#"
+            package test:jobs;
+
+            interface api {
+                /// Render a report.
+                render: func(name: string) -> future<result<string, string>>;
+                count: func() -> future<u64>;
             }
-            _ => {
-                writeln!(out, "            let {name}_rust = {name};")?;
+
+            world jobs {
+                export api;
             }
-        }
-        Ok(())
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("jobs.wit", source)
+            .expect("failed to parse jobs WIT");
+        let world_id = resolve.packages[pkg_id].worlds["jobs"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
+
+        assert!(
+            code.contains(
+                "fn api_render(name: String) -> impl std::future::Future<Output = Result<String, String>> + Send + 'static;"
+            ),
+            "future results should be returned as futures over owned arguments"
+        );
+        assert!(
+            code.contains("fn spawn_task(task: witffi_types::AsyncTask) {"),
+            "futures should be spawned by the implementation"
+        );
+        assert!(
+            code.contains(
+                "complete: witffi_types::FfiAsyncComplete, user_data: usize) -> *mut std::ffi::c_void {"
+            ),
+            "starting a future should return its call pointer"
+        );
+        assert!(
+            code.contains("witffi_types::start_future(\n"),
+            "futures should be started cancellably"
+        );
+        assert!(
+            code.contains("witffi_types::finish_async::<Result<String, String>>(task)"),
+            "the awaited value should be collected like an async result"
+        );
+        assert!(
+            code.contains("fn zcash_eip681_future_cancel(call: *mut std::ffi::c_void) {")
+                && code.contains("fn zcash_eip681_future_release(call: *mut std::ffi::c_void) {"),
+            "calls should be cancellable and releasable"
+        );
+        assert!(
+            !code.contains("TODO: generate type"),
+            "future types should not need a type definition"
+        );
+        assert!(
+            header.contains("void zcash_eip681_future_cancel(void *call);")
+                && header.contains("void zcash_eip681_future_release(void *call);"),
+            "the header should declare the shared future functions"
+        );
+        assert!(
+            header.contains(
+                "void *zcash_eip681_api_render(FfiByteSlice name, FfiAsyncComplete complete, uintptr_t user_data);"
+            ) && header.contains("FfiByteBuffer* zcash_eip681_api_render_finish(void *task);"),
+            "the header should declare the start and finish functions"
+        );
     }
 
-    // ---- C header generation ----
+    #[test]
+    fn test_generate_byte_streams() {
+        let source = r#"
+            package test:files;
+
+            interface api {
+                /// Download a file.
+                download: func(url: string) -> stream<u8>;
+                upload: func(name: string, data: stream<u8>) -> result<u64, string>;
+            }
+
+            world files {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("files.wit", source)
+            .expect("failed to parse files WIT");
+        let world_id = resolve.packages[pkg_id].worlds["files"];
 
-    fn generate_c_types(&self, out: &mut String) -> std::fmt::Result {
-        writeln!(out, "/* ---- Types ---- */")?;
-        writeln!(out)?;
-        writeln!(
-            out,
-            "/* FfiByteSlice and FfiByteBuffer are provided by witffi_types.h */"
-        )?;
-        writeln!(out)?;
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
 
-        let reachable = self.collect_reachable_types();
-        for type_id in &reachable {
-            self.generate_c_type_def(out, *type_id)?;
-        }
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
 
-        Ok(())
+        assert!(
+            code.contains("fn api_download(url: String) -> impl std::io::Read + Send + 'static;"),
+            "byte stream results should be readers"
+        );
+        assert!(
+            code.contains("witffi_types::byte_stream_into_ptr(items)"),
+            "the reader should be returned as an opaque producer"
+        );
+        assert!(
+            code.contains("fn zcash_eip681_api_download_read(stream: *mut std::ffi::c_void, buf: *mut u8, len: usize) -> isize {")
+                && code.contains("witffi_types::byte_stream_read(stream, buf, len)"),
+            "bytes should be read in chunks"
+        );
+        assert!(
+            code.contains("witffi_types::byte_stream_drop(stream)"),
+            "readers should be dropped by the caller"
+        );
+        assert!(
+            !code.contains("fn zcash_eip681_api_download_next"),
+            "byte streams should not be pulled one byte at a time"
+        );
+        assert!(
+            code.contains(
+                "fn api_upload(name: &str, data: witffi_types::ByteStream<'_>) -> Result<u64, String>;"
+            ),
+            "byte stream parameters should be borrowed readers"
+        );
+        assert!(
+            code.contains("data: witffi_types::FfiByteSource")
+                && code.contains("let data_rust = unsafe { witffi_types::ByteStream::new(data) };"),
+            "byte stream parameters should read from the caller's source"
+        );
+        assert!(
+            header.contains("void *zcash_eip681_api_download(FfiByteSlice url);")
+                && header.contains(
+                    "intptr_t zcash_eip681_api_download_read(void *stream, uint8_t *buf, size_t len);"
+                )
+                && header.contains("void zcash_eip681_api_download_drop(void *stream);"),
+            "the header should declare the byte stream functions"
+        );
+        assert!(
+            header.contains("FfiByteSource data"),
+            "the header should declare byte stream parameters"
+        );
     }
 
-    fn generate_c_type_def(&self, out: &mut String, type_id: TypeId) -> std::fmt::Result {
-        let typedef = &self.resolve.types[type_id];
-        let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+    #[test]
+    fn test_generate_error_contexts() {
+        let source = r#"
+            package test:contexts;
 
-        match &typedef.kind {
-            TypeDefKind::Record(record) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                writeln!(out, "typedef struct {{")?;
-                for field in &record.fields {
-                    let field_name = names::to_rust_ident(&field.name);
-                    let field_type = self.type_to_c_header(&field.ty);
-                    writeln!(out, "    {field_type} {field_name};")?;
-                }
-                writeln!(out, "}} {c_name};")?;
-                writeln!(out)?;
+            interface api {
+                type failure = error-context;
+
+                describe: func(ctx: error-context) -> string;
+                open: func(path: string) -> result<u32, error-context>;
+                watch: func(path: string) -> future<result<u32, failure>>;
             }
 
-            TypeDefKind::Variant(variant) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                let tag_name = format!("{c_name}Tag");
+            world contexts {
+                export api;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("contexts.wit", source)
+            .expect("failed to parse contexts WIT");
+        let world_id = resolve.packages[pkg_id].worlds["contexts"];
 
-                writeln!(out, "typedef enum {{")?;
-                for (i, case) in variant.cases.iter().enumerate() {
-                    let variant_upper = names::to_c_enum_variant(&c_name, &case.name);
-                    writeln!(out, "    {variant_upper} = {i},")?;
-                }
-                writeln!(out, "}} {tag_name};")?;
-                writeln!(out)?;
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
 
-                for case in &variant.cases {
-                    if let Some(ty) = &case.ty {
-                        let payload_name =
-                            format!("{c_name}{}Payload", names::to_rust_type(&case.name));
-                        let value_type = self.type_to_c_header(ty);
-                        writeln!(out, "typedef struct {{")?;
-                        writeln!(out, "    {value_type} value;")?;
-                        writeln!(out, "}} {payload_name};")?;
-                        writeln!(out)?;
-                    }
-                }
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
 
-                writeln!(out, "typedef struct {{")?;
-                writeln!(out, "    {tag_name} tag;")?;
-                for case in &variant.cases {
-                    if case.ty.is_some() {
-                        let field_name = names::to_rust_ident(&case.name);
-                        let payload_name =
-                            format!("{c_name}{}Payload", names::to_rust_type(&case.name));
-                        writeln!(out, "    {payload_name} *{field_name};")?;
-                    }
-                }
-                writeln!(out, "}} {c_name};")?;
-                writeln!(out)?;
-            }
+        assert!(
+            code.contains("fn api_describe(ctx: witffi_types::ErrorContext) -> String;")
+                && code.contains(
+                    "fn api_open(path: &str) -> Result<u32, witffi_types::ErrorContext>;"
+                ),
+            "error contexts should map to witffi_types::ErrorContext"
+        );
+        assert!(
+            code.contains("ctx: witffi_types::FfiErrorContextInput")
+                && code.contains(
+                    "let ctx_rust = unsafe { witffi_types::ErrorContext::from_ffi_input(&ctx) };"
+                ),
+            "error context parameters should be copied from the caller"
+        );
+        assert_eq!(
+            code.matches("err_out: *mut *mut witffi_types::FfiErrorContext")
+                .count(),
+            2,
+            "error-context errors should be handed back through err_out"
+        );
+        assert!(
+            code.contains("unsafe { *err_out = Box::into_raw(Box::new(e.into_ffi())) };"),
+            "error contexts should keep their identity and message"
+        );
+        assert!(
+            code.contains("fn zcash_eip681_error_context_new_id() -> u64 {")
+                && header.contains("uint64_t zcash_eip681_error_context_new_id(void);"),
+            "callers should be able to create error contexts"
+        );
+        assert!(
+            header.contains("FfiErrorContextInput ctx")
+                && header.contains("FfiErrorContext** err_out"),
+            "the header should use the shared error-context types"
+        );
+        assert!(
+            !code.contains("u32 /* error-context */"),
+            "error contexts should no longer be bare handles"
+        );
+    }
 
-            TypeDefKind::Enum(e) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                writeln!(out, "typedef enum {{")?;
-                for (i, case) in e.cases.iter().enumerate() {
-                    let case_upper = names::to_c_enum_variant(&c_name, &case.name);
-                    writeln!(out, "    {case_upper} = {i},")?;
-                }
-                writeln!(out, "}} {c_name};")?;
-                writeln!(out)?;
+    #[test]
+    fn test_generate_batch_functions() {
+        let source = r#"
+            package test:uris;
+
+            interface parser {
+                parse: func(input: string) -> result<u32, string>;
+                join: func(base: string, path: string) -> string;
+                record-hit: func(code: u32);
             }
 
-            TypeDefKind::Type(inner) => {
-                let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                let inner_c = self.type_to_c_header(inner);
-                if c_name != inner_c {
-                    writeln!(out, "typedef {inner_c} {c_name};")?;
-                    writeln!(out)?;
-                }
+            world uris {
+                export parser;
             }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("uris.wit", source)
+            .expect("failed to parse uris WIT");
+        let world_id = resolve.packages[pkg_id].worlds["uris"];
+        witffi_core::add_batch_functions(
+            &mut resolve,
+            world_id,
+            &[
+                "parser.parse".to_string(),
+                "parser.join".to_string(),
+                "parser.record-hit".to_string(),
+            ],
+        )
+        .expect("failed to add batch functions");
 
-            _ => {}
-        }
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
 
-        Ok(())
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
+
+        assert!(
+            code.contains(
+                "    fn parser_parse_batch(input: Vec<String>) -> Vec<Result<u32, String>> {\n        input.into_iter().map(|input| Self::parser_parse(&input)).collect()\n    }"
+            ),
+            "batched functions should default to calling the function per element"
+        );
+        assert!(
+            code.contains(
+                "base.into_iter().zip(path).map(|(base, path)| Self::parser_join(&base, &path)).collect()"
+            ) && code.contains(
+                "code.into_iter().for_each(|code| Self::parser_record_hit(code))"
+            ),
+            "several parameters should be zipped, and results may be empty"
+        );
+        assert!(
+            header.contains("zcash_eip681_parser_parse_batch("),
+            "the batched form should cross the C ABI once"
+        );
     }
 
-    fn type_to_c_header(&self, ty: &Type) -> String {
-        match ty {
-            Type::Bool => "bool".to_string(),
-            Type::U8 => "uint8_t".to_string(),
-            Type::U16 => "uint16_t".to_string(),
-            Type::U32 => "uint32_t".to_string(),
-            Type::U64 => "uint64_t".to_string(),
-            Type::S8 => "int8_t".to_string(),
-            Type::S16 => "int16_t".to_string(),
-            Type::S32 => "int32_t".to_string(),
-            Type::S64 => "int64_t".to_string(),
-            Type::F32 => "float".to_string(),
-            Type::F64 => "double".to_string(),
-            Type::Char => "uint32_t".to_string(),
-            Type::String => "FfiByteBuffer".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => "FfiByteBuffer".to_string(),
-                    TypeDefKind::List(_) => "FfiByteBuffer".to_string(),
-                    TypeDefKind::Option(inner) => format!("{}*", self.type_to_c_header(inner)),
-                    TypeDefKind::Type(aliased) => self.type_to_c_header(aliased),
-                    _ => {
-                        let name = typedef.name.as_deref().unwrap_or("void");
-                        names::to_c_type(&self.config.c_type_prefix, name)
-                    }
-                }
+    #[test]
+    fn test_generate_into_variants() {
+        let source = r#"
+            package test:uris;
+
+            interface parser {
+                normalize: func(input: string) -> result<string, string>;
+                encode: func(input: string) -> list<u8>;
+                count: func(input: string) -> u32;
             }
-            Type::ErrorContext => "uint32_t".to_string(),
-        }
-    }
 
-    /// Map a WIT type to its C header input representation.
-    fn type_to_c_header_input(&self, ty: &Type) -> String {
-        match ty {
-            Type::String => "FfiByteSlice".to_string(),
-            Type::Id(id) => {
-                let typedef = &self.resolve.types[*id];
-                match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => "FfiByteSlice".to_string(),
-                    TypeDefKind::List(_) => "FfiByteSlice".to_string(),
-                    TypeDefKind::Type(aliased) => self.type_to_c_header_input(aliased),
-                    _ => self.type_to_c_header(ty),
-                }
+            world uris {
+                export parser;
             }
-            _ => self.type_to_c_header(ty),
-        }
-    }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("uris.wit", source)
+            .expect("failed to parse uris WIT");
+        let world_id = resolve.packages[pkg_id].worlds["uris"];
+
+        let config = RustConfig {
+            into_variants: true,
+            ..test_config()
+        };
+        let generator = RustGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
 
-    fn generate_c_function_decls(&self, out: &mut String) -> std::fmt::Result {
-        writeln!(out, "/* ---- Functions ---- */")?;
-        writeln!(out)?;
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
 
-        let prefix = self.config.c_prefix.to_snake_case();
-        writeln!(out, "int32_t {prefix}_last_error_length(void);")?;
-        writeln!(
-            out,
-            "int32_t {prefix}_error_message_utf8(char *buf, int32_t len);"
-        )?;
-        writeln!(out, "void {prefix}_clear_last_error(void);")?;
-        writeln!(out)?;
+        assert!(
+            code.contains(
+                "pub unsafe extern \"C\" fn zcash_eip681_parser_normalize_into(input: witffi_types::FfiByteSlice, dst: *mut u8, dst_len: usize) -> isize {"
+            ) && code.contains("pub unsafe extern \"C\" fn zcash_eip681_parser_encode_into("),
+            "string and byte results should get an _into variant"
+        );
+        assert!(
+            code.contains("                    let bytes: &[u8] = value.as_ref();\n                    if !bytes.is_empty() && bytes.len() <= dst_len {")
+                && code.contains("                    bytes.len() as isize"),
+            "results should only be copied when they fit"
+        );
+        assert!(
+            !code.contains("zcash_eip681_parser_count_into"),
+            "other results should not get an _into variant"
+        );
+        assert!(
+            header.contains(
+                "intptr_t zcash_eip681_parser_normalize_into(FfiByteSlice input, uint8_t *dst, size_t dst_len);"
+            ),
+            "the _into variant should be declared"
+        );
 
-        let funcs = exported_functions(self.resolve, self.world_id);
-        for ef in &funcs {
-            let c_func_name = if ef.interface_name.is_empty() {
-                names::to_c_func(&self.config.c_prefix, &ef.function_name)
-            } else {
-                names::to_c_func(
-                    &self.config.c_prefix,
-                    &format!("{}-{}", ef.interface_name, ef.function_name),
-                )
-            };
+        let plain = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
+        assert!(
+            !plain.contains("_normalize_into("),
+            "_into variants should be opt-in"
+        );
+    }
 
-            let result_decomposed = self.decompose_result(&ef.function.result);
+    #[test]
+    fn test_generate_columnar_lists() {
+        let source = r#"
+            package test:geo;
+
+            interface shapes {
+                record point {
+                    x: f64,
+                    y: f64,
+                    visible: bool,
+                }
 
-            let c_params: Vec<String> = ef
-                .function
-                .params
-                .iter()
-                .map(|p| {
-                    format!(
-                        "{} {}",
-                        self.type_to_c_header_input(&p.ty),
-                        names::to_rust_ident(&p.name)
-                    )
-                })
-                .collect();
+                outline: func(sides: u32) -> result<list<point>, string>;
+                corners: func() -> list<point>;
+            }
 
-            let c_return = if let Some((ref ok_ty, _)) = result_decomposed {
-                match ok_ty {
-                    Some(ty) => format!("{}*", self.type_to_c_header(ty)),
-                    None => "bool".to_string(),
-                }
-            } else {
-                match &ef.function.result {
-                    Some(ty) => self.type_to_c_header(ty),
-                    None => "void".to_string(),
-                }
-            };
+            world geo {
+                export shapes;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("geo.wit", source)
+            .expect("failed to parse geo WIT");
+        let world_id = resolve.packages[pkg_id].worlds["geo"];
+
+        let config = RustConfig {
+            columnar: HashSet::from(["point".to_string()]),
+            ..test_config()
+        };
+        let generator = RustGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
 
-            let params_str = if c_params.is_empty() {
-                "void".to_string()
-            } else {
-                c_params.join(", ")
-            };
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
 
-            writeln!(out, "{c_return} {c_func_name}({params_str});")?;
-        }
+        assert!(
+            code.contains("        pub struct FfiPointColumns {\n            pub len: usize,\n            pub x: *mut f64,\n            pub y: *mut f64,\n            pub visible: *mut bool,\n        }"),
+            "each field should get its own column"
+        );
+        assert!(
+            code.contains(
+                "pub unsafe extern \"C\" fn zcash_eip681_shapes_outline_columns(sides: u32, columns_out: *mut FfiPointColumns) -> bool {"
+            ) && code.contains("pub unsafe extern \"C\" fn zcash_eip681_shapes_corners_columns("),
+            "list<point> results should get a _columns variant"
+        );
+        assert!(
+            code.contains(
+                "x: Box::into_raw(value.iter().map(|e| e.x).collect::<Box<[f64]>>()) as *mut f64,"
+            ),
+            "columns should be gathered from the elements"
+        );
+        assert!(
+            code.contains(
+                "pub unsafe extern \"C\" fn zcash_eip681_free_point_columns(columns: FfiPointColumns) {"
+            ),
+            "the columns should be freed together"
+        );
+        assert!(
+            header.contains("} FfiPointColumns;")
+                && header.contains(
+                    "bool zcash_eip681_shapes_outline_columns(uint32_t sides, FfiPointColumns *columns_out);"
+                )
+                && header.contains("void zcash_eip681_free_point_columns(FfiPointColumns columns);"),
+            "the _columns variant should be declared"
+        );
 
-        Ok(())
+        let plain = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
+        assert!(!plain.contains("Columns"), "columns should be opt-in");
     }
 
-    fn generate_c_free_decls(&self, out: &mut String) -> std::fmt::Result {
-        writeln!(out, "/* ---- Free functions ---- */")?;
-        writeln!(out)?;
+    #[test]
+    fn test_generate_alloc_accounting() {
+        let source = r#"
+            package test:shapes;
+
+            interface shapes {
+                record rect {
+                    w: f64,
+                    h: f64,
+                }
 
-        let prefix = self.config.c_prefix.to_snake_case();
-        writeln!(out, "void {prefix}_free_byte_buffer(FfiByteBuffer buf);")?;
+                variant shape {
+                    empty,
+                    rect(rect),
+                }
 
-        let reachable = self.collect_reachable_types();
-        for type_id in &reachable {
-            let typedef = &self.resolve.types[*type_id];
-            let wit_name = typedef.name.as_deref().unwrap_or("anonymous");
+                parse: func(input: string) -> result<shape, string>;
+            }
 
-            match &typedef.kind {
-                TypeDefKind::Record(_) | TypeDefKind::Variant(_) => {
-                    let c_name = names::to_c_type(&self.config.c_type_prefix, wit_name);
-                    let free_name =
-                        names::to_c_func(&self.config.c_prefix, &format!("free-{wit_name}"));
-                    writeln!(out, "void {free_name}({c_name} *ptr);")?;
-                }
-                _ => {}
+            world geo {
+                export shapes;
             }
-        }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("shapes.wit", source)
+            .expect("failed to parse shapes WIT");
+        let world_id = resolve.packages[pkg_id].worlds["geo"];
+
+        let config = RustConfig {
+            alloc_accounting: true,
+            ..test_config()
+        };
+        let generator = RustGenerator::new(&resolve, world_id, config);
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
 
-        Ok(())
-    }
-}
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
 
-#[cfg(test)]
-mod tests {
-    use super::*;
-    use std::path::Path;
-    use witffi_core::load_wit;
+        assert!(
+            code.contains(
+                "rect: witffi_types::accounting::into_raw(FfiShapeRectPayload { value: rect_to_ffi(inner) }),"
+            ),
+            "variant payloads should be boxed through the accounting"
+        );
+        assert!(
+            code.contains("drop(unsafe { witffi_types::accounting::from_raw(v.rect) });"),
+            "variant payloads should be released through the accounting"
+        );
+        assert!(
+            !code.contains("Box::into_raw(Box::new("),
+            "no box should bypass the accounting"
+        );
+        assert!(
+            code.contains(
+                "pub unsafe extern \"C\" fn zcash_eip681_free_box(ptr: *mut std::ffi::c_void) {"
+            ) && code.contains(
+                "pub extern \"C\" fn zcash_eip681_alloc_stats() -> witffi_types::FfiByteBuffer {"
+            ),
+            "the box free and stats functions should be exported"
+        );
+        assert!(
+            header.contains("void zcash_eip681_free_box(void *ptr);")
+                && header.contains("FfiByteBuffer zcash_eip681_alloc_stats(void);"),
+            "the box free and stats functions should be declared"
+        );
 
-    fn test_config() -> RustConfig {
-        RustConfig {
-            c_prefix: "zcash_eip681".to_string(),
-            c_type_prefix: "Ffi".to_string(),
-            kotlin_package: Some("zcash.eip681".to_string()),
-            library_name: Some("eip681ffi".to_string()),
-        }
+        let plain = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
+        assert!(
+            !plain.contains("witffi_types::accounting") && !plain.contains("_free_box"),
+            "accounting should be opt-in"
+        );
     }
 
     #[test]
-    fn test_generate_rust_from_eip681() {
+    fn test_generate_alloc_canaries() {
         let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
         let (resolve, world_id) = load_wit(&wit_path).expect("failed to load eip681.wit");
 
-        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let config = RustConfig {
+            alloc_canaries: true,
+            ..test_config()
+        };
+        let generator = RustGenerator::new(&resolve, world_id, config);
         let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
 
-        // Print first for debugging
-        eprintln!("=== Generated Rust ===\n{code}");
-
-        // Idiomatic types
         assert!(
-            code.contains("pub struct NativeRequest"),
-            "missing idiomatic NativeRequest struct"
+            code.contains(
+                "        #[global_allocator]\n        static WITFFI_ALLOCATOR: witffi_types::canary::CanaryAlloc = witffi_types::canary::CanaryAlloc::new();"
+            ),
+            "the canary allocator should be installed"
         );
         assert!(
-            code.contains("pub enum TransactionRequest"),
-            "missing idiomatic TransactionRequest enum"
+            code.contains("pub unsafe extern \"C\" fn zcash_eip681_free_box(")
+                && header.contains("void zcash_eip681_free_box(void *ptr);"),
+            "boxes should be freed through the library"
         );
         assert!(
-            code.contains("pub schema_prefix: String,"),
-            "idiomatic type should use String, not FfiByteBuffer"
+            !code.contains("_alloc_stats") && !header.contains("_alloc_stats"),
+            "canaries should not export the accounting"
         );
+
+        let plain = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
         assert!(
-            code.contains("pub chain_id: Option<u64>,"),
-            "idiomatic type should use Option<u64>"
+            !plain.contains("global_allocator"),
+            "canaries should be opt-in"
         );
+    }
+
+    #[test]
+    fn test_generate_panic_reporting() {
+        let source = r#"
+            package test:counters;
+
+            interface types {
+                resource counter {
+                    constructor(start: u32);
+                    get: func() -> u32;
+                }
+            }
+
+            world counters {
+                export types;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("counters.wit", source)
+            .expect("failed to parse counters WIT");
+        let world_id = resolve.packages[pkg_id].worlds["counters"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
 
-        // Trait uses idiomatic types
-        assert!(code.contains("pub trait Eip681"), "missing trait Eip681");
         assert!(
-            code.contains("fn parser_parse(input: &str) -> Result<TransactionRequest, String>"),
-            "trait should return idiomatic TransactionRequest"
+            code.contains("LAST_PANIC.with(|p| *p.borrow_mut() = Some(witffi_types::take_panic_backtrace().unwrap_or_default()));"),
+            "caught panics should be recorded as panics"
         );
-
-        // FFI macro
         assert!(
-            code.contains("macro_rules! witffi_register_ffi"),
-            "missing witffi_register_ffi macro"
+            code.contains("pub unsafe extern \"C\" fn zcash_eip681_take_panic(backtrace_out: *mut witffi_types::FfiByteBuffer) -> bool {")
+                && header.contains("bool zcash_eip681_take_panic(FfiByteBuffer *backtrace_out);"),
+            "the caller should be able to take a panic"
         );
-
-        // JNI macro
         assert!(
-            code.contains("macro_rules! witffi_register_jni"),
-            "missing witffi_register_jni macro"
+            code.contains("            #[cfg(debug_assertions)]\n            witffi_types::capture_panic_backtraces();")
+                && header.contains("void zcash_eip681_capture_panic_backtraces(void);"),
+            "backtraces should only be captured in debug builds"
         );
-
-        // Backward compat alias
         assert!(
-            code.contains("macro_rules! witffi_register {"),
-            "missing backward-compatible witffi_register alias"
+            code.contains("            let result = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| unsafe {\n                witffi_types::free_ptr(ptr as *mut"),
+            "resource destructors should not unwind into the caller"
         );
+    }
+
+    #[test]
+    fn test_generate_import_panics() {
+        let source = r#"
+            package test:host;
+
+            interface host {
+                log: func(message: string);
+                now: func() -> u64;
+                fetch: func(url: string) -> result<string, string>;
+            }
+
+            world app {
+                import host;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("host.wit", source)
+            .expect("failed to parse host WIT");
+        let world_id = resolve.packages[pkg_id].worlds["app"];
+
+        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated Rust ===\n{code}\n=== Generated header ===\n{header}");
 
-        // repr(C) types inside FFI macro
         assert!(
-            code.contains("pub struct FfiNativeRequest"),
-            "missing repr(C) FfiNativeRequest inside FFI macro"
+            code.contains("    pub unsafe extern \"C\" fn zcash_eip681_import_panicked(message: witffi_types::FfiByteSlice) {")
+                && header.contains("void zcash_eip681_import_panicked(FfiByteSlice message);"),
+            "the caller should be able to report a panic"
         );
         assert!(
-            code.contains("#[repr(C)]"),
-            "missing repr(C) attribute inside FFI macro"
+            code.contains("            unsafe { super::zcash_eip681_import_host_log(witffi_types::FfiByteSlice { ptr: message.as_ptr(), len: message.len() }) };\n            super::check_panic(\"zcash_eip681_import_host_log\");\n"),
+            "void imports should resume panics after the call"
         );
-
-        // Conversion functions inside FFI macro
         assert!(
-            code.contains("fn native_request_to_ffi"),
-            "missing native_request_to_ffi conversion"
+            code.contains("            let result = unsafe { super::zcash_eip681_import_host_now() };\n            super::check_panic(\"zcash_eip681_import_host_now\");\n            result\n"),
+            "scalar imports should resume panics before returning"
         );
         assert!(
-            code.contains("fn transaction_request_to_ffi"),
-            "missing transaction_request_to_ffi conversion"
+            code.contains("            super::check_panic(\"zcash_eip681_import_host_fetch\");\n            if success {"),
+            "fallible imports should resume panics before lifting"
         );
     }
 
     #[test]
-    fn test_generate_c_header_from_eip681() {
+    fn test_generate_abi_hash() {
         let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
-        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load eip681.wit");
+        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load WIT");
 
         let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let code = generator.generate().expect("failed to generate Rust code");
         let header = generator
             .generate_c_header()
             .expect("failed to generate C header");
 
-        eprintln!("=== Generated C header ===\n{header}");
-
-        assert!(header.contains("#pragma once"), "missing pragma once");
-        assert!(header.contains("FfiByteBuffer"), "missing FfiByteBuffer");
-        assert!(header.contains("FfiByteSlice"), "missing FfiByteSlice");
+        let hash = format!("{:#018x}", witffi_core::abi_hash(&resolve, world_id));
         assert!(
-            header.contains("zcash_eip681_parser_parse(FfiByteSlice input)"),
-            "missing parser_parse or wrong param type"
+            code.contains(&format!(
+                "        pub extern \"C\" fn zcash_eip681_abi_hash() -> u64 {{\n            {hash}\n        }}"
+            )),
+            "the library should report the hash of its world"
+        );
+        assert!(
+            header.contains("uint64_t zcash_eip681_abi_hash(void);"),
+            "the header should declare the hash"
         );
     }
 
     #[test]
-    fn test_generate_jni_macro_content() {
+    fn test_generate_lifecycle() {
         let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
-        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load eip681.wit");
+        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load WIT");
 
-        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let config = RustConfig {
+            lifecycle: true,
+            ..test_config()
+        };
+        let generator = RustGenerator::new(&resolve, world_id, config);
         let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated Rust ===\n{code}");
 
-        // JNI conversion helpers
         assert!(
-            code.contains("fn to_jni_native_request"),
-            "missing to_jni_native_request helper"
+            code.contains(
+                "    fn init_library(options: &[(String, String)]) -> Result<(), String> {"
+            ) && code.contains("    fn shutdown_library() {}"),
+            "the trait should have overridable lifecycle hooks"
         );
         assert!(
-            code.contains("fn to_jni_transaction_request"),
-            "missing to_jni_transaction_request helper"
+            code.contains("pub unsafe extern \"C\" fn zcash_eip681_init(options: witffi_types::FfiByteSlice) -> bool {")
+                && code.contains("let options = witffi_types::init_options(unsafe { options.as_bytes() });"),
+            "_init should decode the caller's options"
         );
-
-        // JNI entry point
         assert!(
-            code.contains("Java_zcash_eip681_Eip681_nativeParserParse"),
-            "missing JNI entry point function name"
+            code.contains("pub extern \"C\" fn zcash_eip681_shutdown() {"),
+            "missing _shutdown"
         );
-
-        // JNI error handling
         assert!(
-            code.contains("java/lang/RuntimeException"),
-            "missing RuntimeException in JNI error handling"
+            code.contains("let _ = std::io::Write::flush(&mut std::io::stdout());"),
+            "_shutdown should flush what the library printed"
+        );
+        assert!(
+            header.contains("bool zcash_eip681_init(FfiByteSlice options);")
+                && header.contains("void zcash_eip681_shutdown(void);"),
+            "the header should declare the lifecycle functions"
         );
 
-        // JNI class paths
+        let plain = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
         assert!(
-            code.contains("zcash/eip681/NativeRequest"),
-            "missing JNI class path for NativeRequest"
+            !plain.contains("init_library"),
+            "lifecycle hooks should be opt-in"
         );
     }
 
     #[test]
-    fn test_idiomatic_types_generated() {
+    fn test_generate_log_bridge() {
         let wit_path = Path::new(env!("CARGO_MANIFEST_DIR")).join("../../wit/eip681.wit");
-        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load eip681.wit");
+        let (resolve, world_id) = load_wit(&wit_path).expect("failed to load WIT");
 
-        let generator = RustGenerator::new(&resolve, world_id, test_config());
+        let config = RustConfig {
+            log_bridge: true,
+            ..test_config()
+        };
+        let generator = RustGenerator::new(&resolve, world_id, config);
         let code = generator.generate().expect("failed to generate Rust code");
+        let header = generator
+            .generate_c_header()
+            .expect("failed to generate C header");
+
+        eprintln!("=== Generated Rust ===\n{code}");
 
-        // Verify idiomatic types use Rust-native types, not FFI types
         assert!(
-            code.contains("pub value_atomic: Option<Vec<u8>>,"),
-            "idiomatic type should use Option<Vec<u8>>"
+            code.contains("pub extern \"C\" fn zcash_eip681_set_log_sink(sink: Option<witffi_types::FfiLogSink>, max_level: u8) -> bool {")
+                && code.contains("witffi_types::logging::set_sink(sink, max_level)"),
+            "_set_log_sink should register the caller's sink"
         );
         assert!(
-            code.contains("pub display: String,"),
-            "idiomatic type should use String"
+            header.contains("bool zcash_eip681_set_log_sink(FfiLogSink sink, uint8_t max_level);"),
+            "the header should declare _set_log_sink"
         );
 
-        // Variant should be a proper Rust enum
+        let plain = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
         assert!(
-            code.contains("Native(NativeRequest),"),
-            "variant should use idiomatic enum syntax"
+            !plain.contains("set_log_sink"),
+            "the log bridge should be opt-in"
+        );
+    }
+
+    #[test]
+    fn test_generate_wasm_exports() {
+        let source = r#"
+            package test:hashing;
+
+            interface hasher {
+                resource state {
+                    update: func(data: list<u8>);
+                }
+
+                hash: func(data: list<u8>, rounds: u32) -> list<u8>;
+                describe: func(name: string, loud: bool) -> result<string, string>;
+                count: func() -> result<u64, string>;
+                version: func() -> u64;
+            }
+
+            world hashing {
+                export hasher;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("hashing.wit", source)
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+
+        let code = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
+
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        assert!(
+            code.contains("macro_rules! witffi_register_wasm {")
+                && code.contains("        #[cfg(target_family = \"wasm\")]\n")
+                && code.contains("            pub unsafe extern \"C\" fn cabi_realloc("),
+            "the Wasm macro should only export for Wasm targets, with cabi_realloc"
         );
         assert!(
-            code.contains("Erc20(Erc20Request),"),
-            "variant should use idiomatic enum syntax"
+            code.contains(
+                "            #[unsafe(export_name = \"test:hashing/hasher#hash\")]\n            pub unsafe extern \"C\" fn wasm_zcash_eip681_hasher_hash(data_ptr: *mut u8, data_len: usize, rounds: i32) -> *mut u8 {"
+            ) && code.contains(
+                "let result = <$impl_type>::hasher_hash(&data, rounds as u32);"
+            ),
+            "functions should be exported under their component export names"
         );
         assert!(
-            code.contains("Unrecognised(String),"),
-            "variant should use String for string payloads"
+            code.contains("<$impl_type>::hasher_describe(&name, loud != 0)")
+                && code.contains("write_buffer(area.add(4), value.into_bytes());")
+                && code.contains("area.add(8).cast::<u64>().write(value);"),
+            "result payloads should be laid out behind the discriminant"
+        );
+        assert!(
+            code.contains(
+                "            #[unsafe(export_name = \"cabi_post_test:hashing/hasher#count\")]"
+            ) && code.contains("                    if area.read() != 0 {"),
+            "results in the return area should be freed by cabi_post_ functions"
+        );
+        assert!(
+            code.contains(
+                "            pub unsafe extern \"C\" fn wasm_zcash_eip681_hasher_version() -> i64 {\n                <$impl_type>::hasher_version() as i64\n"
+            ) && !code.contains("cabi_post_test:hashing/hasher#version"),
+            "scalar results should be returned directly"
+        );
+        assert!(
+            !code.contains("wasm_zcash_eip681_hasher_state_update"),
+            "resource functions should not be exported"
+        );
+    }
+
+    #[test]
+    fn test_generate_sidecar() {
+        let source = r#"
+            package test:hashing;
+
+            interface hasher {
+                resource state {
+                    update: func(data: list<u8>);
+                }
+
+                hash: func(data: list<u8>, rounds: u32) -> list<u8>;
+                describe: func(name: string, loud: bool) -> result<string, string>;
+                version: func() -> u64;
+            }
+
+            world hashing {
+                export hasher;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("hashing.wit", source)
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+
+        let code = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
+
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        let hash = format!("{:#018x}", witffi_core::abi_hash(&resolve, world_id));
+        assert!(
+            code.contains("macro_rules! witffi_register_sidecar {")
+                && code.contains("        pub fn witffi_serve_sidecar() -> std::io::Result<()> {")
+                && code.contains(&format!(
+                    "            write_frame(&mut output, &{hash}u64.to_le_bytes())?;"
+                )),
+            "the sidecar should greet the bindings with the ABI hash"
+        );
+        let hash_fn = exported_functions(&resolve, world_id)
+            .into_iter()
+            .find(|ef| ef.function.name == "hash")
+            .expect("the world exports hash");
+        let id = ipc::function_id(&resolve, world_id, &hash_fn).expect("hash has an id");
+        assert!(
+            code.contains(&format!(
+                "                        {id} => {{\n\
+                 \x20                           let data = args.bytes();\n\
+                 \x20                           let rounds = u32::from_le_bytes(args.take());\n\
+                 \x20                           let value = <$impl_type>::hasher_hash(&data, rounds);\n\
+                 \x20                           put_buffer(&mut response, &value);\n"
+            )),
+            "requests should be dispatched by function id"
+        );
+        assert!(
+            code.contains("let loud = args.take::<1>()[0] != 0;")
+                && code.contains("                                    response[0] = 1;\n")
+                && code.contains("response.extend_from_slice(&value.to_le_bytes());"),
+            "errors should be answered with their status"
+        );
+        assert!(
+            code.contains("std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {")
+                && code.contains("let mut response = vec![2u8];"),
+            "panics should be answered with their message"
+        );
+    }
+
+    #[test]
+    fn test_generate_grpc() {
+        let source = r#"
+            package test:hashing;
+
+            interface hasher {
+                resource state {
+                    update: func(data: list<u8>);
+                }
+
+                hash: func(data: list<u8>, rounds: u8) -> list<u8>;
+                describe: func(full-name: string) -> result<_, string>;
+            }
+
+            world hashing {
+                export hasher;
+                export version: func() -> u16;
+            }
+        "#;
+        let mut resolve = Resolve::default();
+        let pkg_id = resolve
+            .push_str("hashing.wit", source)
+            .expect("failed to parse hashing WIT");
+        let world_id = resolve.packages[pkg_id].worlds["hashing"];
+
+        let code = RustGenerator::new(&resolve, world_id, test_config())
+            .generate()
+            .expect("failed to generate Rust code");
+
+        eprintln!("=== Generated Rust ===\n{code}");
+
+        assert!(
+            code.contains("macro_rules! witffi_register_grpc {")
+                && code
+                    .contains("            impl $proto::hashing_server::Hashing for WitffiGrpc {"),
+            "the macro should implement the service tonic-build generates"
+        );
+        assert!(
+            code.contains(
+                "                    request: tonic::Request<$proto::HasherHashRequest>,\n"
+            ) && code.contains("let rounds = u8::try_from(request.rounds).map_err(|_| {")
+                && code.contains(
+                    "let value = tokio::task::spawn_blocking(move || \
+                     <$impl_type>::hasher_hash(&request.data, rounds))"
+                )
+                && code.contains("Ok(tonic::Response::new($proto::HasherHashResponse { value }))"),
+            "fields should be passed to the implementation and the result returned"
+        );
+        assert!(
+            code.contains("<$impl_type>::hasher_describe(&request.full_name))")
+                && code.contains("                        .map_err(tonic::Status::unknown)?;\n"),
+            "errors should be returned as status UNKNOWN"
+        );
+        assert!(
+            code.contains(
+                "                    _request: tonic::Request<$proto::VersionRequest>,\n"
+            ) && code.contains("$proto::VersionResponse { value: value.into() }"),
+            "narrow results should widen to their field"
+        );
+        assert!(
+            code.contains("                tonic::Status::internal(message)\n")
+                && !code.contains("async fn hasher_state_update("),
+            "panics should be INTERNAL and resource functions not served"
         );
     }
 }
@@ -825,28 +825,46 @@ impl<'a> SwiftGenerator<'a> {
     }
 
     /// Generate Swift code to extract a variant case payload from the FFI struct.
+    ///
+    /// Inline tuple payloads are read field by field into a Swift tuple.
     fn convert_variant_payload(&self, ty: &Type, c_field: &str) -> String {
-        match ty {
-            Type::String => {
-                format!("let payload = ffiByteBufferToString(ffi.{c_field}!.pointee.value)")
+        match witffi_core::payload_fields(self.resolve, ty) {
+            Some(fields) => {
+                let elems: Vec<String> = fields
+                    .iter()
+                    .enumerate()
+                    .map(|(i, field_ty)| {
+                        self.payload_value_expr(field_ty, &format!("ffi.{c_field}!.pointee.f{i}"))
+                    })
+                    .collect();
+                format!("let payload = ({})", elems.join(", "))
+            }
+            None => {
+                let value = format!("ffi.{c_field}!.pointee.value");
+                format!("let payload = {}", self.payload_value_expr(ty, &value))
             }
+        }
+    }
+
+    /// A Swift expression converting a payload value at `access`.
+    fn payload_value_expr(&self, ty: &Type, access: &str) -> String {
+        match ty {
+            Type::String => format!("ffiByteBufferToString({access})"),
             Type::Id(id) => {
                 let typedef = &self.resolve.types[*id];
                 match &typedef.kind {
-                    TypeDefKind::List(Type::U8) => {
-                        format!("let payload = ffiByteBufferToData(ffi.{c_field}!.pointee.value)")
-                    }
-                    TypeDefKind::Type(aliased) => self.convert_variant_payload(aliased, c_field),
+                    TypeDefKind::List(Type::U8) => format!("ffiByteBufferToData({access})"),
+                    TypeDefKind::Type(aliased) => self.payload_value_expr(aliased, access),
                     _ => {
                         let name = typedef.name.as_deref().unwrap_or("anonymous");
                         let swift_name = names::to_swift_type(name);
-                        format!("let payload = convert{swift_name}(ffi.{c_field}!.pointee.value)")
+                        format!("convert{swift_name}({access})")
                     }
                 }
             }
             _ => {
                 // Primitive payload
-                format!("let payload = ffi.{c_field}!.pointee.value")
+                access.to_string()
             }
         }
     }
@@ -866,7 +884,18 @@ impl<'a> SwiftGenerator<'a> {
         writeln!(out, "/// {namespace} FFI bindings.")?;
         writeln!(out, "public enum {namespace} {{")?;
 
-        let funcs = exported_functions(self.resolve, self.world_id);
+        // Resources, async functions, streams, futures and error contexts
+        // are not yet supported by this backend.
+        let funcs: Vec<_> = exported_functions(self.resolve, self.world_id)
+            .into_iter()
+            .filter(|ef| {
+                ef.resource().is_none()
+                    && !ef.is_awaited(self.resolve)
+                    && ef.stream_item(self.resolve).is_none()
+                    && !ef.takes_byte_streams(self.resolve)
+                    && !ef.uses_error_context(self.resolve)
+            })
+            .collect();
         for ef in &funcs {
             self.generate_api_function(out, ef)?;
         }
@@ -981,7 +1010,7 @@ impl<'a> SwiftGenerator<'a> {
             }
 
             // Build C function call arguments
-            let c_args = self.build_c_call_args(params);
+            let c_args = self.build_c_call_args(ef);
             let inner_indent = "        ".to_string() + &"    ".repeat(nesting);
 
             // Call the C function and handle result
@@ -1001,7 +1030,7 @@ impl<'a> SwiftGenerator<'a> {
             }
         } else {
             // No string marshaling needed, call directly
-            let c_args = self.build_c_call_args(params);
+            let c_args = self.build_c_call_args(ef);
             self.generate_c_call_and_result(
                 out,
                 c_func_name,
@@ -1032,8 +1061,13 @@ impl<'a> SwiftGenerator<'a> {
     }
 
     /// Build the C function call argument list.
-    fn build_c_call_args(&self, params: &[wit_parser::Param]) -> String {
-        params
+    ///
+    /// Typed error payloads are not decoded yet, so `err_out` is passed as
+    /// `nil` and errors surface through `readLastError()`.
+    fn build_c_call_args(&self, ef: &ExportedFunction) -> String {
+        let mut args: Vec<String> = ef
+            .function
+            .params
             .iter()
             .map(|p| {
                 let name = names::to_swift_ident(&p.name);
@@ -1043,8 +1077,11 @@ impl<'a> SwiftGenerator<'a> {
                     name
                 }
             })
-            .collect::<Vec<_>>()
-            .join(", ")
+            .collect();
+        if ef.error_payload(self.resolve).is_some() {
+            args.push("nil".to_string());
+        }
+        args.join(", ")
     }
 
     /// Generate the C function call and result handling.
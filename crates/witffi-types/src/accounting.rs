@@ -0,0 +1,233 @@
+//! Accounting of the allocations handed across the FFI boundary.
+//!
+//! With the `alloc-accounting` feature enabled, every buffer, list and boxed
+//! value that generated code hands to the caller is recorded by address and
+//! type when it is allocated, and struck off when it is freed, whichever side
+//! frees it. [`stats`] then reports, per type, how many allocations were
+//! made and how many of them were freed; a count that keeps growing apart
+//! points at a leak.
+//!
+//! Without the feature the hooks compile away: [`into_raw`] and [`from_raw`]
+//! are plain `Box` conversions and [`stats`] is always empty.
+
+use std::ffi::c_void;
+
+use crate::FfiByteBuffer;
+
+unsafe extern "C" {
+    fn free(ptr: *mut c_void);
+}
+
+/// The allocation counts of one type.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct AllocStat {
+    /// The type, with module paths stripped (e.g. `FfiByteBuffer`).
+    pub kind: String,
+    /// Number of allocations made.
+    pub allocs: u64,
+    /// Number of those allocations freed.
+    pub frees: u64,
+}
+
+impl AllocStat {
+    /// Number of allocations not yet freed.
+    pub fn live(&self) -> u64 {
+        self.allocs.saturating_sub(self.frees)
+    }
+}
+
+#[cfg(feature = "alloc-accounting")]
+mod ledger {
+    use std::collections::{BTreeMap, HashMap};
+    use std::sync::{LazyLock, Mutex, MutexGuard};
+
+    #[derive(Default)]
+    pub(super) struct Ledger {
+        /// Live allocations, by address.
+        pub live: HashMap<usize, &'static str>,
+        /// Allocation and free counts, by type name.
+        pub counts: BTreeMap<&'static str, (u64, u64)>,
+    }
+
+    static LEDGER: LazyLock<Mutex<Ledger>> = LazyLock::new(Default::default);
+
+    pub(super) fn lock() -> MutexGuard<'static, Ledger> {
+        // A panic while holding the lock leaves the counts usable
+        LEDGER.lock().unwrap_or_else(|e| e.into_inner())
+    }
+}
+
+/// Record an allocation of type `kind` at `ptr`.
+///
+/// A no-op without the `alloc-accounting` feature.
+pub fn track(ptr: *const c_void, kind: &'static str) {
+    #[cfg(feature = "alloc-accounting")]
+    {
+        let mut ledger = ledger::lock();
+        ledger.live.insert(ptr as usize, kind);
+        ledger.counts.entry(kind).or_default().0 += 1;
+    }
+    #[cfg(not(feature = "alloc-accounting"))]
+    let _ = (ptr, kind);
+}
+
+/// Record that the allocation at `ptr` was freed.
+///
+/// Pointers that were never tracked are ignored. A no-op without the
+/// `alloc-accounting` feature.
+pub fn untrack(ptr: *const c_void) {
+    #[cfg(feature = "alloc-accounting")]
+    {
+        let mut ledger = ledger::lock();
+        if let Some(kind) = ledger.live.remove(&(ptr as usize)) {
+            ledger.counts.entry(kind).or_default().1 += 1;
+        }
+    }
+    #[cfg(not(feature = "alloc-accounting"))]
+    let _ = ptr;
+}
+
+/// Box `value` and hand out the raw pointer, recording the allocation.
+///
+/// The accounted counterpart of `Box::into_raw(Box::new(value))`.
+pub fn into_raw<T>(value: T) -> *mut T {
+    let ptr = Box::into_raw(Box::new(value));
+    if size_of::<T>() > 0 {
+        track(ptr as *const c_void, std::any::type_name::<T>());
+    }
+    ptr
+}
+
+/// Take back a pointer handed out by [`into_raw`], recording the free.
+///
+/// # Safety
+///
+/// Same as `Box::from_raw`: the pointer must have been allocated by a
+/// `Box<T>` and must not be used again afterwards.
+pub unsafe fn from_raw<T>(ptr: *mut T) -> Box<T> {
+    if size_of::<T>() > 0 {
+        untrack(ptr as *const c_void);
+    }
+    unsafe { Box::from_raw(ptr) }
+}
+
+/// Free a boxed value, recording the free.
+///
+/// Callers that release a boxed value themselves rather than through a
+/// generated release function (as Go bindings release the boxes of
+/// options and results) go through this so the free is accounted for.
+/// The value is freed with C `free`, or through the
+/// [`CanaryAlloc`](crate::canary::CanaryAlloc) once one is installed.
+///
+/// # Safety
+///
+/// The pointer must be null or have been allocated by this library's
+/// global allocator, which must be compatible with C `free` unless it is a
+/// `CanaryAlloc`, and must not be used again afterwards.
+pub unsafe fn free_box(ptr: *mut c_void) {
+    if !ptr.is_null() {
+        untrack(ptr);
+        if crate::canary::is_installed() {
+            unsafe { crate::canary::free(ptr.cast()) };
+        } else {
+            unsafe { free(ptr) };
+        }
+    }
+}
+
+/// The allocation counts so far, one entry per type, sorted by type.
+///
+/// Always empty without the `alloc-accounting` feature.
+pub fn stats() -> Vec<AllocStat> {
+    #[allow(unused_mut)]
+    let mut stats: Vec<AllocStat> = Vec::new();
+    #[cfg(feature = "alloc-accounting")]
+    for (kind, (allocs, frees)) in &ledger::lock().counts {
+        let kind = short_type_name(kind);
+        match stats.iter_mut().find(|s| s.kind == kind) {
+            Some(stat) => {
+                stat.allocs += allocs;
+                stat.frees += frees;
+            }
+            None => stats.push(AllocStat {
+                kind,
+                allocs: *allocs,
+                frees: *frees,
+            }),
+        }
+    }
+    stats.sort_by(|a, b| a.kind.cmp(&b.kind));
+    stats
+}
+
+/// The allocation counts as text, one `kind\tallocs\tfrees` line per type.
+///
+/// The buffer itself is not accounted for, so reading the counts does not
+/// change them. The caller frees it like any other [`FfiByteBuffer`].
+pub fn stats_buffer() -> FfiByteBuffer {
+    let mut text = String::new();
+    for stat in stats() {
+        text.push_str(&format!("{}\t{}\t{}\n", stat.kind, stat.allocs, stat.frees));
+    }
+    let mut bytes = text.into_bytes().into_boxed_slice();
+    let buf = FfiByteBuffer {
+        ptr: bytes.as_mut_ptr(),
+        len: bytes.len(),
+    };
+    std::mem::forget(bytes);
+    buf
+}
+
+/// Strip the module paths from a type name, so that
+/// `witffi_types::FfiList<my_crate::FfiPoint>` becomes `FfiList<FfiPoint>`.
+#[cfg_attr(not(feature = "alloc-accounting"), allow(dead_code))]
+fn short_type_name(name: &str) -> String {
+    let mut out = String::new();
+    let mut segment = String::new();
+    let mut chars = name.chars().peekable();
+    while let Some(c) = chars.next() {
+        if c == ':' && chars.peek() == Some(&':') {
+            chars.next();
+            segment.clear();
+        } else if c.is_alphanumeric() || c == '_' {
+            segment.push(c);
+        } else {
+            out.push_str(&segment);
+            segment.clear();
+            out.push(c);
+        }
+    }
+    out.push_str(&segment);
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_short_type_name() {
+        assert_eq!(
+            short_type_name("witffi_types::FfiList<my_crate::ffi::FfiPoint>"),
+            "FfiList<FfiPoint>"
+        );
+        assert_eq!(short_type_name("u32"), "u32");
+    }
+
+    #[cfg(feature = "alloc-accounting")]
+    #[test]
+    fn test_boxes_are_counted() {
+        struct Counted(#[allow(dead_code)] u64);
+
+        let count = || {
+            stats()
+                .into_iter()
+                .find(|s| s.kind == "Counted")
+                .map(|s| (s.allocs, s.frees))
+        };
+        let ptr = into_raw(Counted(1));
+        assert_eq!(count(), Some((1, 0)));
+        drop(unsafe { from_raw(ptr) });
+        assert_eq!(count(), Some((1, 1)));
+    }
+}
@@ -0,0 +1,175 @@
+//! A debug allocator stamping canaries around every allocation.
+//!
+//! [`CanaryAlloc`] wraps another allocator (the system allocator by
+//! default). Each block it hands out is preceded by a header recording its
+//! layout and a canary word, and followed by a trailing canary. Both are
+//! verified when the block is freed: a write past either end of a buffer,
+//! such as hand-written export glue overrunning a buffer it hands to the
+//! caller, aborts the process with a report there and then, instead of
+//! silently corrupting whatever memory comes next.
+//!
+//! Blocks from this allocator must not be released with C `free`. Callers
+//! that free boxed values themselves go through
+//! [`accounting::free_box`](crate::accounting::free_box), which releases
+//! them through this allocator once it is installed.
+
+use std::alloc::{GlobalAlloc, Layout, System};
+use std::sync::atomic::{AtomicBool, Ordering};
+
+/// The value stamped before and after every block.
+const CANARY: u64 = 0x7769_7466_6669_cafe;
+
+/// The layout of a block as requested, stored just before it.
+#[repr(C)]
+struct Header {
+    size: usize,
+    align: usize,
+    canary: u64,
+}
+
+static INSTALLED: AtomicBool = AtomicBool::new(false);
+
+/// An allocator stamping canaries around every block and verifying them on
+/// free.
+///
+/// Install it as the global allocator of the library, in debug builds:
+///
+/// ```ignore
+/// #[global_allocator]
+/// static ALLOCATOR: witffi_types::canary::CanaryAlloc =
+///     witffi_types::canary::CanaryAlloc::new();
+/// ```
+#[derive(Debug, Default)]
+pub struct CanaryAlloc<A = System> {
+    inner: A,
+}
+
+impl CanaryAlloc<System> {
+    /// Stamp canaries around the blocks of the system allocator.
+    pub const fn new() -> Self {
+        Self { inner: System }
+    }
+}
+
+impl<A> CanaryAlloc<A> {
+    /// Stamp canaries around the blocks of `inner`.
+    pub const fn with_allocator(inner: A) -> Self {
+        Self { inner }
+    }
+}
+
+/// Whether a [`CanaryAlloc`] has handed out any block in this process.
+pub fn is_installed() -> bool {
+    INSTALLED.load(Ordering::Relaxed)
+}
+
+/// The bytes before a block of the given alignment: the header, padded so
+/// that the block stays aligned.
+fn prefix_len(align: usize) -> usize {
+    size_of::<Header>().next_multiple_of(align)
+}
+
+/// The layout of the whole stamped allocation holding a block of `layout`.
+fn outer_layout(layout: Layout) -> Option<Layout> {
+    let align = layout.align().max(align_of::<Header>());
+    let size = prefix_len(align)
+        .checked_add(layout.size())?
+        .checked_add(size_of::<u64>())?;
+    Layout::from_size_align(size, align).ok()
+}
+
+/// Report a damaged canary and abort, since the heap can no longer be
+/// trusted.
+fn corrupted(ptr: *mut u8, size: usize, what: &str) -> ! {
+    eprintln!("witffi: heap corruption: {what} the {size}-byte block at {ptr:p}");
+    std::process::abort()
+}
+
+/// Verify the canaries around `ptr` and return the block's layout as
+/// requested.
+///
+/// # Safety
+///
+/// `ptr` must be a block handed out by a [`CanaryAlloc`].
+unsafe fn check(ptr: *mut u8) -> Layout {
+    let header = unsafe { ptr.sub(size_of::<Header>()).cast::<Header>().read() };
+    if header.canary != CANARY {
+        corrupted(ptr, header.size, "write before");
+    }
+    let trailer = unsafe { ptr.add(header.size).cast::<u64>().read_unaligned() };
+    if trailer != CANARY {
+        corrupted(ptr, header.size, "write past");
+    }
+    // The header itself was intact, so its layout is the one requested
+    unsafe { Layout::from_size_align_unchecked(header.size, header.align) }
+}
+
+unsafe impl<A: GlobalAlloc> GlobalAlloc for CanaryAlloc<A> {
+    unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+        INSTALLED.store(true, Ordering::Relaxed);
+        let Some(outer) = outer_layout(layout) else {
+            return std::ptr::null_mut();
+        };
+        let base = unsafe { self.inner.alloc(outer) };
+        if base.is_null() {
+            return base;
+        }
+        unsafe {
+            let ptr = base.add(prefix_len(outer.align()));
+            ptr.sub(size_of::<Header>()).cast::<Header>().write(Header {
+                size: layout.size(),
+                align: layout.align(),
+                canary: CANARY,
+            });
+            ptr.add(layout.size()).cast::<u64>().write_unaligned(CANARY);
+            ptr
+        }
+    }
+
+    unsafe fn dealloc(&self, ptr: *mut u8, _layout: Layout) {
+        // Free with the stamped layout, which stays right even for callers
+        // that (like `FfiByteBuffer`) free with the length rather than the
+        // capacity
+        let layout = unsafe { check(ptr) };
+        let Some(outer) = outer_layout(layout) else {
+            return;
+        };
+        unsafe {
+            self.inner
+                .dealloc(ptr.sub(prefix_len(outer.align())), outer)
+        };
+    }
+}
+
+/// Free a block of the installed [`CanaryAlloc`] without knowing its
+/// layout, which the block's header records.
+///
+/// # Safety
+///
+/// `ptr` must be a live block handed out by the global allocator, which must
+/// be a [`CanaryAlloc`].
+pub unsafe fn free(ptr: *mut u8) {
+    let layout = unsafe { check(ptr) };
+    unsafe { std::alloc::dealloc(ptr, layout) };
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_blocks_keep_their_layout() {
+        let alloc = CanaryAlloc::new();
+        for (size, align) in [(1, 1), (24, 8), (3, 64)] {
+            let layout = Layout::from_size_align(size, align).unwrap();
+            unsafe {
+                let ptr = alloc.alloc(layout);
+                assert!(!ptr.is_null());
+                assert_eq!(ptr as usize % align, 0, "blocks should stay aligned");
+                ptr.write_bytes(0xaa, size);
+                assert_eq!(check(ptr), layout);
+                alloc.dealloc(ptr, layout);
+            }
+        }
+    }
+}
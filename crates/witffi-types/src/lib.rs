@@ -5,20 +5,68 @@
 //!
 //! - [`FfiByteSlice`]: A borrowed, caller-owned byte slice (const pointer)
 //! - [`FfiByteBuffer`]: An owned, callee-allocated byte buffer (must be freed)
+//! - [`FfiSlice`]: A borrowed, caller-owned list of `repr(C)` elements
+//! - [`FfiList`]: An owned, callee-allocated list of `repr(C)` elements
 //! - [`option_to_ptr`]: Convert `Option<T>` to a nullable heap pointer
 //! - [`free_ptr`]: Free a heap-allocated value returned by [`option_to_ptr`]
+//! - [`start_async`] / [`finish_async`]: Run an async function call and hand
+//!   its output back through an [`FfiAsyncComplete`] callback
+//! - [`start_future`] / [`future_cancel`] / [`future_release`]: Run the
+//!   future returned for a `future<T>` result, which the caller may cancel
+//! - [`stream_into_ptr`] / [`stream_next`] / [`stream_drop`]: Hand a `stream<T>`
+//!   producer to the caller, which pulls its elements one at a time
+//! - [`byte_stream_into_ptr`] / [`byte_stream_read`] / [`byte_stream_drop`]:
+//!   Hand a `stream<u8>` reader to the caller, which pulls bytes in chunks
+//! - [`FfiByteSource`] / [`ByteStream`]: Read a `stream<u8>` parameter from
+//!   the caller while the call runs
+//! - [`ErrorContext`] / [`FfiErrorContext`]: A component-model `error-context`
+//!   value and its FFI representation
+//! - [`CancelToken`] / [`is_cancelled`]: Let the caller cancel a running call,
+//!   which the implementation checks for cooperatively
+//! - [`accounting`]: Optional per-type counts of the allocations handed to
+//!   the caller and freed again, for tracking down leaks
+//! - [`capture_panic_backtraces`] / [`take_panic_backtrace`]: Record where
+//!   the panics caught at the FFI boundary were raised
+//! - [`canary`]: A debug allocator catching writes past the ends of the
+//!   buffers handed to the caller
+//! - [`init_options`]: Decode the options the caller initializes the
+//!   library with
+//! - [`error_chain`]: Serialize an error and its sources into one error
+//!   message, for the caller to rebuild as a chain
+//! - [`FfiLogSink`] / `logging`: Forward the library's `log` records to the
+//!   caller's logger (with the `log` feature)
 //!
 //! Generated code references these types via fully-qualified paths
 //! (e.g. `witffi_types::FfiByteBuffer`) so consumers only need to add
 //! `witffi-types` as a dependency.
 
+use std::cell::RefCell;
+use std::ffi::c_void;
+use std::future::Future;
+use std::io::Read;
+use std::marker::PhantomData;
+use std::panic::AssertUnwindSafe;
+use std::pin::Pin;
 use std::ptr;
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::task::{Context, Poll, Wake, Waker};
+
+pub mod accounting;
+pub mod canary;
+#[cfg(feature = "log")]
+pub mod logging;
 
 /// An FFI-safe borrowed byte slice (caller-owned, const pointer).
 ///
 /// Used for input parameters: the caller owns the data and the callee
 /// must not free it. For strings, the data is UTF-8 encoded without a
 /// null terminator.
+///
+/// The data is only valid until the call returns. It may be memory of the
+/// caller's garbage-collected runtime, pinned just for the call (as Go
+/// bindings generated with `--go-pin-bytes` pass byte slices), so the
+/// callee must copy whatever it keeps rather than hold on to the pointer.
 #[repr(C)]
 #[derive(Debug, Clone, Copy)]
 pub struct FfiByteSlice {
@@ -90,6 +138,9 @@ impl FfiByteBuffer {
             len: v.len(),
         };
         std::mem::forget(v);
+        if buf.len > 0 {
+            accounting::track(buf.ptr as *const c_void, std::any::type_name::<Self>());
+        }
         buf
     }
 
@@ -113,11 +164,98 @@ impl FfiByteBuffer {
     /// behavior.
     pub unsafe fn free(self) {
         if !self.ptr.is_null() && self.len > 0 {
+            accounting::untrack(self.ptr as *const c_void);
             drop(unsafe { Vec::from_raw_parts(self.ptr, self.len, self.len) });
         }
     }
 }
 
+/// An FFI-safe borrowed list of `repr(C)` elements (caller-owned, const
+/// pointer).
+///
+/// The list counterpart of [`FfiByteSlice`], used for `list<T>` input
+/// parameters whose elements are not bytes.
+#[repr(C)]
+#[derive(Debug)]
+pub struct FfiSlice<T> {
+    /// Pointer to the first element (must be valid for `len` elements, or null if `len == 0`).
+    pub ptr: *const T,
+    /// Number of elements.
+    pub len: usize,
+}
+
+impl<T> Clone for FfiSlice<T> {
+    fn clone(&self) -> Self {
+        *self
+    }
+}
+
+impl<T> Copy for FfiSlice<T> {}
+
+impl<T> FfiSlice<T> {
+    /// View the list as a slice.
+    ///
+    /// # Safety
+    ///
+    /// The pointer must be valid for `len` elements and the data must not be
+    /// mutated for the lifetime of the returned reference.
+    pub unsafe fn as_slice(&self) -> &[T] {
+        if self.ptr.is_null() || self.len == 0 {
+            &[]
+        } else {
+            unsafe { std::slice::from_raw_parts(self.ptr, self.len) }
+        }
+    }
+}
+
+/// An FFI-safe owned list of `repr(C)` elements (callee-allocated, must be
+/// freed).
+///
+/// The list counterpart of [`FfiByteBuffer`], used for `list<T>` output
+/// values whose elements are not bytes. Generated code frees it with a
+/// `*_free_list_*` function once the caller has converted the elements.
+#[repr(C)]
+#[derive(Debug)]
+pub struct FfiList<T> {
+    /// Pointer to the first element (must be valid for `len` elements).
+    pub ptr: *mut T,
+    /// Number of elements.
+    pub len: usize,
+}
+
+impl<T> FfiList<T> {
+    /// Create a list from an owned `Vec<T>`.
+    ///
+    /// The vector's allocation is transferred to the list. The caller must
+    /// eventually reclaim it via [`FfiList::into_vec`].
+    pub fn from_vec(v: Vec<T>) -> Self {
+        // Go through a boxed slice so that the capacity equals the length
+        let len = v.len();
+        let ptr = Box::into_raw(v.into_boxed_slice()) as *mut T;
+        if len > 0 && size_of::<T>() > 0 {
+            accounting::track(ptr as *const c_void, std::any::type_name::<Self>());
+        }
+        Self { ptr, len }
+    }
+
+    /// Take back ownership of the elements.
+    ///
+    /// # Safety
+    ///
+    /// The list must have been created by [`FfiList::from_vec`] (or be
+    /// zeroed) and must not be used again afterwards.
+    pub unsafe fn into_vec(self) -> Vec<T> {
+        if self.ptr.is_null() {
+            Vec::new()
+        } else {
+            if self.len > 0 && size_of::<T>() > 0 {
+                accounting::untrack(self.ptr as *const c_void);
+            }
+            unsafe { Vec::from_raw_parts(self.ptr, self.len, self.len) }
+        }
+    }
+}
+
 /// Convert an `Option<T>` into a nullable heap-allocated pointer.
 ///
 /// - `Some(v)` is boxed and returned as a raw pointer
@@ -127,7 +265,7 @@ impl FfiByteBuffer {
 /// [`free_ptr`] or the appropriate generated free function.
 pub fn option_to_ptr<T>(v: Option<T>) -> *mut T {
     match v {
-        Some(val) => Box::into_raw(Box::new(val)),
+        Some(val) => accounting::into_raw(val),
         None => ptr::null_mut(),
     }
 }
@@ -143,10 +281,629 @@ pub fn option_to_ptr<T>(v: Option<T>) -> *mut T {
 /// process, or be null.
 pub unsafe fn free_ptr<T>(ptr: *mut T) {
     if !ptr.is_null() {
-        drop(unsafe { Box::from_raw(ptr) });
+        drop(unsafe { accounting::from_raw(ptr) });
+    }
+}
+
+thread_local! {
+    static PANIC_BACKTRACE: RefCell<Option<String>> = const { RefCell::new(None) };
+}
+
+/// Capture the backtrace of every panic from now on, for
+/// [`take_panic_backtrace`] to return on the panicking thread.
+///
+/// Installs, once, a panic hook capturing the backtrace before running the
+/// previously installed hook. Capturing is slow, so generated code only
+/// calls this in debug builds.
+pub fn capture_panic_backtraces() {
+    static INSTALL: std::sync::Once = std::sync::Once::new();
+    INSTALL.call_once(|| {
+        let previous = std::panic::take_hook();
+        std::panic::set_hook(Box::new(move |info| {
+            let backtrace = std::backtrace::Backtrace::force_capture().to_string();
+            let _ = PANIC_BACKTRACE.try_with(|b| *b.borrow_mut() = Some(backtrace));
+            previous(info);
+        }));
+    });
+}
+
+/// Take the backtrace of the last panic on this thread, if
+/// [`capture_panic_backtraces`] captured one.
+pub fn take_panic_backtrace() -> Option<String> {
+    PANIC_BACKTRACE.with(|b| b.borrow_mut().take())
+}
+
+/// Decode the key-value options passed to a generated `_init` function:
+/// keys and values alternately, each followed by a NUL byte.
+pub fn init_options(bytes: &[u8]) -> Vec<(String, String)> {
+    let mut fields = bytes
+        .split(|b| *b == 0)
+        .map(|field| String::from_utf8_lossy(field).into_owned());
+    let mut options = Vec::new();
+    while let (Some(key), Some(value)) = (fields.next(), fields.next()) {
+        options.push((key, value));
+    }
+    options
+}
+
+/// The separator between the messages of an [`error_chain`].
+pub const ERROR_CHAIN_SEPARATOR: char = '\u{1e}';
+
+/// Serialize `error` and its chain of sources into a single error message,
+/// outermost first, separated by [`ERROR_CHAIN_SEPARATOR`].
+///
+/// Return it as the error of a `result<T, string>` for bindings that rebuild
+/// the chain as wrapped errors (Go with `--go-error-chains`):
+///
+/// ```ignore
+/// fn parser_parse(input: &str) -> Result<u64, String> {
+///     parse(input).map_err(|e| witffi_types::error_chain(&e))
+/// }
+/// ```
+///
+/// A separator within a message is replaced by a space.
+pub fn error_chain<E: std::error::Error + ?Sized>(error: &E) -> String {
+    let mut messages = vec![error.to_string()];
+    let mut source = error.source();
+    while let Some(error) = source {
+        messages.push(error.to_string());
+        source = error.source();
+    }
+    messages
+        .iter()
+        .map(|m| m.replace(ERROR_CHAIN_SEPARATOR, " "))
+        .collect::<Vec<_>>()
+        .join(&ERROR_CHAIN_SEPARATOR.to_string())
+}
+
+/// The completion callback of an async function call.
+///
+/// Called exactly once, possibly from another thread, with the caller's
+/// `user_data` and a task pointer that the caller passes to the function's
+/// `_finish` counterpart to collect the result.
+pub type FfiAsyncComplete = extern "C" fn(user_data: usize, task: *mut c_void);
+
+/// The caller's receiver of the library's log records, registered with
+/// `logging::set_sink`.
+///
+/// Called from whichever thread logs, with the record's level (1 for errors
+/// through 5 for trace), target, formatted message and key-value fields,
+/// encoded as keys and values alternately, each followed by a NUL byte. All
+/// three slices are only valid for the duration of the call.
+pub type FfiLogSink =
+    extern "C" fn(level: u8, target: FfiByteSlice, message: FfiByteSlice, fields: FfiByteSlice);
+
+/// The future running an async function call, handed to the
+/// implementation's spawner.
+pub type AsyncTask = Pin<Box<dyn Future<Output = ()> + Send + 'static>>;
+
+/// Wakes a thread blocked in [`block_on`].
+struct ThreadWaker(std::thread::Thread);
+
+impl Wake for ThreadWaker {
+    fn wake(self: Arc<Self>) {
+        self.0.unpark();
+    }
+}
+
+/// Drive a future to completion on the current thread, parking it while the
+/// future is pending.
+pub fn block_on<F: Future>(future: F) -> F::Output {
+    let mut future = std::pin::pin!(future);
+    let waker = Waker::from(Arc::new(ThreadWaker(std::thread::current())));
+    let mut cx = Context::from_waker(&waker);
+    loop {
+        if let Poll::Ready(output) = future.as_mut().poll(&mut cx) {
+            return output;
+        }
+        std::thread::park();
+    }
+}
+
+/// Run a task to completion on a new thread.
+///
+/// This is the default spawner for async functions, which needs no runtime.
+pub fn spawn_thread(task: AsyncTask) {
+    std::thread::spawn(move || block_on(task));
+}
+
+/// Start an async function call, spawning `future` with `spawn` and calling
+/// `complete` once it finishes.
+///
+/// A panic while polling `future` is caught and handed on as the task's
+/// result, like the output itself.
+pub fn start_async<F>(spawn: fn(AsyncTask), future: F, complete: FfiAsyncComplete, user_data: usize)
+where
+    F: Future + Send + 'static,
+    F::Output: Send + 'static,
+{
+    // Never cancelled, so the task is never null
+    let call = start_future(spawn, future, complete, user_data);
+    unsafe { future_release(call) };
+}
+
+/// The cancellation state shared by a started future and its caller.
+#[derive(Default)]
+struct FutureCall {
+    cancelled: AtomicBool,
+    waker: Mutex<Option<Waker>>,
+}
+
+/// Start the future behind a `future<T>` result like [`start_async`], and
+/// return the call pointer the caller cancels it with.
+///
+/// If the call is cancelled before `future` finishes, `future` is dropped
+/// and `complete` is called with a null task. The caller must release the
+/// call pointer with [`future_release`] once it no longer needs to cancel.
+pub fn start_future<F>(
+    spawn: fn(AsyncTask),
+    future: F,
+    complete: FfiAsyncComplete,
+    user_data: usize,
+) -> *mut c_void
+where
+    F: Future + Send + 'static,
+    F::Output: Send + 'static,
+{
+    let call = Arc::new(FutureCall::default());
+    let state = Arc::clone(&call);
+    let token = current_cancel_token();
+    let mut future = Box::pin(future);
+    spawn(Box::pin(async move {
+        let output = std::future::poll_fn(|cx| {
+            *state.waker.lock().unwrap_or_else(|e| e.into_inner()) = Some(cx.waker().clone());
+            // Checked after registering the waker, so a cancel is never missed
+            if state.cancelled.load(Ordering::Acquire) {
+                return Poll::Ready(None);
+            }
+            let poll = || with_cancel_token(token.clone(), || future.as_mut().poll(cx));
+            match std::panic::catch_unwind(AssertUnwindSafe(poll)) {
+                Ok(Poll::Ready(output)) => Poll::Ready(Some(Ok(output))),
+                Ok(Poll::Pending) => Poll::Pending,
+                Err(panic) => Poll::Ready(Some(Err(panic))),
+            }
+        })
+        .await;
+        drop(future);
+        let task = match output {
+            Some(output) => {
+                let task: Box<std::thread::Result<F::Output>> = Box::new(output);
+                Box::into_raw(task) as *mut c_void
+            }
+            None => ptr::null_mut(),
+        };
+        complete(user_data, task);
+    }));
+    Arc::into_raw(call) as *mut c_void
+}
+
+/// Ask a future started with [`start_future`] to stop. It is dropped the next
+/// time it would be polled, unless it has already finished.
+///
+/// # Safety
+///
+/// `call` must come from [`start_future`] and not have been released.
+pub unsafe fn future_cancel(call: *mut c_void) {
+    let call = unsafe { &*(call as *const FutureCall) };
+    call.cancelled.store(true, Ordering::Release);
+    let waker = call.waker.lock().unwrap_or_else(|e| e.into_inner()).take();
+    if let Some(waker) = waker {
+        waker.wake();
+    }
+}
+
+/// Release the call pointer returned by [`start_future`]. The future keeps
+/// running.
+///
+/// # Safety
+///
+/// `call` must come from [`start_future`] and must not be used again
+/// afterwards.
+pub unsafe fn future_release(call: *mut c_void) {
+    drop(unsafe { Arc::from_raw(call as *const FutureCall) });
+}
+
+/// Take the result of an async function call from the task pointer passed to
+/// its completion callback.
+///
+/// # Safety
+///
+/// `task` must come from the completion callback of a [`start_async`] call
+/// whose future outputs `T`, and must not be used again afterwards.
+pub unsafe fn finish_async<T>(task: *mut c_void) -> std::thread::Result<T> {
+    *unsafe { Box::from_raw(task as *mut std::thread::Result<T>) }
+}
+
+/// The producer behind a `stream<T>` handed to the caller.
+type StreamProducer<T> = Box<dyn Iterator<Item = T> + Send>;
+
+/// Box a stream producer into the opaque pointer handed to the caller.
+///
+/// The caller pulls elements with [`stream_next`], so nothing is produced
+/// until it asks, and must release the pointer with [`stream_drop`].
+pub fn stream_into_ptr<T: 'static>(items: impl Iterator<Item = T> + Send + 'static) -> *mut c_void {
+    let producer: StreamProducer<T> = Box::new(CancelScoped::new(items));
+    Box::into_raw(Box::new(producer)) as *mut c_void
+}
+
+/// Produce the next element of a stream, or `None` once it is exhausted.
+///
+/// # Safety
+///
+/// `stream` must come from [`stream_into_ptr`] with elements of type `T`, and
+/// must not have been dropped or be in use by another thread.
+pub unsafe fn stream_next<T>(stream: *mut c_void) -> Option<T> {
+    let producer = unsafe { &mut *(stream as *mut StreamProducer<T>) };
+    producer.next()
+}
+
+/// Drop a stream producer, cancelling whatever it would produce next.
+///
+/// If the pointer is null, this is a no-op.
+///
+/// # Safety
+///
+/// `stream` must come from [`stream_into_ptr`] with elements of type `T`, or
+/// be null, and must not be used again afterwards.
+pub unsafe fn stream_drop<T>(stream: *mut c_void) {
+    if !stream.is_null() {
+        drop(unsafe { Box::from_raw(stream as *mut StreamProducer<T>) });
+    }
+}
+
+/// The reader behind a `stream<u8>` handed to the caller.
+type ByteProducer = Box<dyn Read + Send>;
+
+/// Box a `stream<u8>` reader into the opaque pointer handed to the caller.
+///
+/// The caller pulls bytes with [`byte_stream_read`], a chunk at a time, and
+/// must release the pointer with [`byte_stream_drop`].
+pub fn byte_stream_into_ptr(reader: impl Read + Send + 'static) -> *mut c_void {
+    let producer: ByteProducer = Box::new(CancelScoped::new(reader));
+    Box::into_raw(Box::new(producer)) as *mut c_void
+}
+
+/// Read up to `len` bytes of a byte stream into `buf`, returning how many
+/// were read; `0` means the stream is exhausted.
+///
+/// # Safety
+///
+/// `stream` must come from [`byte_stream_into_ptr`] and must not have been
+/// dropped or be in use by another thread. `buf` must be valid for writing
+/// `len` bytes.
+pub unsafe fn byte_stream_read(
+    stream: *mut c_void,
+    buf: *mut u8,
+    len: usize,
+) -> std::io::Result<usize> {
+    if len == 0 {
+        return Ok(0);
+    }
+    let producer = unsafe { &mut *(stream as *mut ByteProducer) };
+    let buf = unsafe { std::slice::from_raw_parts_mut(buf, len) };
+    loop {
+        match producer.read(buf) {
+            Err(e) if e.kind() == std::io::ErrorKind::Interrupted => continue,
+            result => return result,
+        }
     }
 }
 
+/// Drop a byte stream reader.
+///
+/// If the pointer is null, this is a no-op.
+///
+/// # Safety
+///
+/// `stream` must come from [`byte_stream_into_ptr`], or be null, and must not
+/// be used again afterwards.
+pub unsafe fn byte_stream_drop(stream: *mut c_void) {
+    if !stream.is_null() {
+        drop(unsafe { Box::from_raw(stream as *mut ByteProducer) });
+    }
+}
+
+/// The caller's read function for a `stream<u8>` parameter.
+///
+/// Reads up to `len` bytes into `buf` and returns how many were read, `0` at
+/// the end of the stream or a negative value on failure.
+pub type FfiByteRead = extern "C" fn(user_data: usize, buf: *mut u8, len: usize) -> isize;
+
+/// An FFI-safe `stream<u8>` parameter: the caller's read function and the
+/// user data to pass to it.
+#[repr(C)]
+#[derive(Debug, Clone, Copy)]
+pub struct FfiByteSource {
+    /// Reads the next chunk of the stream.
+    pub read: FfiByteRead,
+    /// Opaque caller data passed back to `read`.
+    pub user_data: usize,
+}
+
+/// A `stream<u8>` parameter, read from the caller as an [`std::io::Read`].
+///
+/// The caller only serves reads while the call that passed the stream runs,
+/// so the stream borrows that call and cannot outlive it.
+#[derive(Debug)]
+pub struct ByteStream<'a> {
+    source: FfiByteSource,
+    call: PhantomData<&'a ()>,
+}
+
+impl ByteStream<'_> {
+    /// Wrap the caller's byte source.
+    ///
+    /// # Safety
+    ///
+    /// `source` must serve reads for as long as the returned stream lives.
+    pub unsafe fn new(source: FfiByteSource) -> Self {
+        Self {
+            source,
+            call: PhantomData,
+        }
+    }
+}
+
+impl Read for ByteStream<'_> {
+    fn read(&mut self, buf: &mut [u8]) -> std::io::Result<usize> {
+        if buf.is_empty() {
+            return Ok(0);
+        }
+        let n = (self.source.read)(self.source.user_data, buf.as_mut_ptr(), buf.len());
+        usize::try_from(n)
+            .map(|n| n.min(buf.len()))
+            .map_err(|_| std::io::Error::other("the caller failed to read the stream"))
+    }
+}
+
+/// The next identity handed out by [`ErrorContext::new_id`].
+static NEXT_ERROR_CONTEXT_ID: AtomicU64 = AtomicU64::new(1);
+
+/// A component-model `error-context` value: a debug message with an identity.
+///
+/// Error contexts are compared by identity rather than by message, so a
+/// context passed across the FFI boundary and back is still equal to the
+/// original, while two contexts that happen to share a message are not.
+#[derive(Debug, Clone)]
+pub struct ErrorContext {
+    id: u64,
+    debug_message: String,
+}
+
+impl ErrorContext {
+    /// Create a new error context with a fresh identity.
+    pub fn new(debug_message: impl Into<String>) -> Self {
+        Self {
+            id: Self::new_id(),
+            debug_message: debug_message.into(),
+        }
+    }
+
+    /// Allocate a fresh error-context identity.
+    ///
+    /// Identities are never `0`, which is left for "no error context".
+    pub fn new_id() -> u64 {
+        NEXT_ERROR_CONTEXT_ID.fetch_add(1, Ordering::Relaxed)
+    }
+
+    /// The identity of this error context.
+    pub fn id(&self) -> u64 {
+        self.id
+    }
+
+    /// The debug message of this error context.
+    pub fn debug_message(&self) -> &str {
+        &self.debug_message
+    }
+
+    /// Convert into the owned FFI representation handed to the caller.
+    pub fn into_ffi(self) -> FfiErrorContext {
+        FfiErrorContext {
+            id: self.id,
+            debug_message: FfiByteBuffer::from_string(self.debug_message),
+        }
+    }
+
+    /// Copy an error context passed in by the caller.
+    ///
+    /// # Safety
+    ///
+    /// `input.debug_message` must be valid for its length and hold UTF-8.
+    pub unsafe fn from_ffi_input(input: &FfiErrorContextInput) -> Self {
+        Self {
+            id: input.id,
+            debug_message: unsafe { input.debug_message.as_str_unchecked() }.to_string(),
+        }
+    }
+}
+
+impl PartialEq for ErrorContext {
+    fn eq(&self, other: &Self) -> bool {
+        self.id == other.id
+    }
+}
+
+impl Eq for ErrorContext {}
+
+impl std::hash::Hash for ErrorContext {
+    fn hash<H: std::hash::Hasher>(&self, state: &mut H) {
+        self.id.hash(state);
+    }
+}
+
+impl std::fmt::Display for ErrorContext {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.debug_message)
+    }
+}
+
+impl std::error::Error for ErrorContext {}
+
+/// An FFI-safe owned `error-context` (callee-allocated, message must be freed).
+#[repr(C)]
+#[derive(Debug)]
+pub struct FfiErrorContext {
+    /// The identity of the error context.
+    pub id: u64,
+    /// The UTF-8 debug message.
+    pub debug_message: FfiByteBuffer,
+}
+
+/// An FFI-safe borrowed `error-context` (caller-owned).
+#[repr(C)]
+#[derive(Debug, Clone, Copy)]
+pub struct FfiErrorContextInput {
+    /// The identity of the error context.
+    pub id: u64,
+    /// The UTF-8 debug message.
+    pub debug_message: FfiByteSlice,
+}
+
+/// A cooperative cancellation flag shared by a caller and the calls it
+/// binds the token to.
+///
+/// Implementations check [`is_cancelled`] during long-running work and
+/// return early once the caller has given up on the call.
+#[derive(Debug, Clone, Default)]
+pub struct CancelToken(Arc<AtomicBool>);
+
+impl CancelToken {
+    /// Create a token that has not been cancelled.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Cancel the calls this token is bound to.
+    pub fn cancel(&self) {
+        self.0.store(true, Ordering::Release);
+    }
+
+    /// Whether the token has been cancelled.
+    pub fn is_cancelled(&self) -> bool {
+        self.0.load(Ordering::Acquire)
+    }
+}
+
+std::thread_local! {
+    static CANCEL_TOKEN: RefCell<Option<CancelToken>> = const { RefCell::new(None) };
+    /// The tokens [`cancel_token_enter`] replaced, restored by
+    /// [`cancel_token_exit`], so calls nested through imports keep their own.
+    static ENTERED_TOKENS: RefCell<Vec<Option<CancelToken>>> = const { RefCell::new(Vec::new()) };
+}
+
+/// The cancellation token bound to the call running on this thread, if the
+/// caller bound one.
+pub fn current_cancel_token() -> Option<CancelToken> {
+    CANCEL_TOKEN.with(|t| t.borrow().clone())
+}
+
+/// Whether the caller has cancelled the call running on this thread.
+///
+/// Always `false` when the caller did not bind a [`CancelToken`]. Futures
+/// and streams keep the token of the call that started them.
+pub fn is_cancelled() -> bool {
+    CANCEL_TOKEN.with(|t| t.borrow().as_ref().is_some_and(CancelToken::is_cancelled))
+}
+
+/// Run `f` with `token` bound as the current cancellation token, restoring
+/// the previous one afterwards, even if `f` panics.
+pub fn with_cancel_token<R>(token: Option<CancelToken>, f: impl FnOnce() -> R) -> R {
+    struct Restore(Option<CancelToken>);
+
+    impl Drop for Restore {
+        fn drop(&mut self) {
+            CANCEL_TOKEN.with(|t| *t.borrow_mut() = self.0.take());
+        }
+    }
+
+    let _restore = Restore(CANCEL_TOKEN.with(|t| t.replace(token)));
+    f()
+}
+
+/// A stream producer that keeps the cancellation token of the call that
+/// returned it, since its elements are produced in later calls.
+struct CancelScoped<T> {
+    inner: T,
+    token: Option<CancelToken>,
+}
+
+impl<T> CancelScoped<T> {
+    fn new(inner: T) -> Self {
+        Self {
+            inner,
+            token: current_cancel_token(),
+        }
+    }
+}
+
+impl<I: Iterator> Iterator for CancelScoped<I> {
+    type Item = I::Item;
+
+    fn next(&mut self) -> Option<I::Item> {
+        with_cancel_token(self.token.clone(), || self.inner.next())
+    }
+}
+
+impl<R: Read> Read for CancelScoped<R> {
+    fn read(&mut self, buf: &mut [u8]) -> std::io::Result<usize> {
+        with_cancel_token(self.token.clone(), || self.inner.read(buf))
+    }
+}
+
+/// Create a cancellation token for the caller to bind with
+/// [`cancel_token_enter`]. It must be released with [`cancel_token_free`].
+pub fn cancel_token_new() -> *mut c_void {
+    Box::into_raw(Box::new(CancelToken::new())) as *mut c_void
+}
+
+/// Cancel the calls a token is bound to. Safe to call from any thread while
+/// the calls run.
+///
+/// # Safety
+///
+/// `token` must come from [`cancel_token_new`] and not have been freed.
+pub unsafe fn cancel_token_cancel(token: *mut c_void) {
+    unsafe { &*(token as *const CancelToken) }.cancel();
+}
+
+/// Free a cancellation token. Calls it is still bound to keep their own
+/// reference to it.
+///
+/// If the pointer is null, this is a no-op.
+///
+/// # Safety
+///
+/// `token` must come from [`cancel_token_new`], or be null, and must not be
+/// used again afterwards.
+pub unsafe fn cancel_token_free(token: *mut c_void) {
+    if !token.is_null() {
+        drop(unsafe { Box::from_raw(token as *mut CancelToken) });
+    }
+}
+
+/// Bind a cancellation token to the calls made on this thread, until
+/// [`cancel_token_exit`].
+///
+/// Bindings nest: a call the implementation reenters through an import
+/// (e.g. a Go import calling back into an exported function) may bind its
+/// own token, and the outer call's token is restored once it exits.
+///
+/// # Safety
+///
+/// `token` must come from [`cancel_token_new`] and not have been freed.
+pub unsafe fn cancel_token_enter(token: *mut c_void) {
+    let token = unsafe { &*(token as *const CancelToken) }.clone();
+    let outer = CANCEL_TOKEN.with(|t| t.replace(Some(token)));
+    ENTERED_TOKENS.with(|entered| entered.borrow_mut().push(outer));
+}
+
+/// Unbind the cancellation token bound by the matching
+/// [`cancel_token_enter`], restoring the token it replaced.
+pub fn cancel_token_exit() {
+    let outer = ENTERED_TOKENS.with(|entered| entered.borrow_mut().pop().flatten());
+    CANCEL_TOKEN.with(|t| *t.borrow_mut() = outer);
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -200,6 +957,42 @@ mod tests {
         assert_eq!(s, "hello");
     }
 
+    #[test]
+    fn test_slice_as_slice() {
+        let data = [1u32, 2, 3];
+        let slice = FfiSlice {
+            ptr: data.as_ptr(),
+            len: data.len(),
+        };
+        assert_eq!(unsafe { slice.as_slice() }, &[1, 2, 3]);
+
+        let empty: FfiSlice<u32> = FfiSlice {
+            ptr: ptr::null(),
+            len: 0,
+        };
+        assert!(unsafe { empty.as_slice() }.is_empty());
+    }
+
+    #[test]
+    fn test_list_round_trip() {
+        let mut v = Vec::with_capacity(8);
+        v.extend([FfiByteBuffer::from_string("a".to_string())]);
+        let list = FfiList::from_vec(v);
+        assert_eq!(list.len, 1);
+
+        let elems = unsafe { list.into_vec() };
+        assert_eq!(elems.len(), 1);
+        for buf in elems {
+            unsafe { buf.free() };
+        }
+
+        let empty: FfiList<u64> = FfiList {
+            ptr: ptr::null_mut(),
+            len: 0,
+        };
+        assert!(unsafe { empty.into_vec() }.is_empty());
+    }
+
     #[test]
     fn test_option_to_ptr_some() {
         let ptr = option_to_ptr(Some(42u64));
@@ -215,4 +1008,281 @@ mod tests {
         // Freeing null should be a no-op
         unsafe { free_ptr(ptr) };
     }
+
+    #[test]
+    fn test_block_on_woken_from_another_thread() {
+        let (sender, receiver) = std::sync::mpsc::channel::<Waker>();
+        let waker_thread = std::thread::spawn(move || {
+            let waker = receiver.recv().unwrap();
+            waker.wake();
+        });
+
+        let mut sender = Some(sender);
+        let output = block_on(std::future::poll_fn(|cx| match sender.take() {
+            Some(sender) => {
+                sender.send(cx.waker().clone()).unwrap();
+                Poll::Pending
+            }
+            None => Poll::Ready(42u64),
+        }));
+        assert_eq!(output, 42);
+        waker_thread.join().unwrap();
+    }
+
+    /// A channel receiving the tasks passed to [`send_task`] with the returned
+    /// user data. The sender is leaked, since a task may still be sending
+    /// once the test has received it.
+    fn task_channel() -> (usize, std::sync::mpsc::Receiver<usize>) {
+        let (sender, receiver) = std::sync::mpsc::channel::<usize>();
+        let sender: &'static _ = Box::leak(Box::new(sender));
+        (sender as *const _ as usize, receiver)
+    }
+
+    extern "C" fn send_task(user_data: usize, task: *mut c_void) {
+        let sender = unsafe { &*(user_data as *const std::sync::mpsc::Sender<usize>) };
+        sender.send(task as usize).unwrap();
+    }
+
+    #[test]
+    fn test_async_round_trip() {
+        let (user_data, receiver) = task_channel();
+
+        start_async(
+            spawn_thread,
+            async { "done".to_string() },
+            send_task,
+            user_data,
+        );
+        let task = receiver.recv().unwrap() as *mut c_void;
+        let output = unsafe { finish_async::<String>(task) };
+        assert_eq!(output.unwrap(), "done");
+
+        start_async(
+            spawn_thread,
+            async { panic!("no luck") },
+            send_task,
+            user_data,
+        );
+        let task = receiver.recv().unwrap() as *mut c_void;
+        let panic = unsafe { finish_async::<()>(task) }.unwrap_err();
+        assert_eq!(panic.downcast_ref::<&str>(), Some(&"no luck"));
+    }
+
+    #[test]
+    fn test_future_cancel() {
+        let (user_data, receiver) = task_channel();
+
+        // Dropped along with the future once cancelled
+        let (dropped_tx, dropped_rx) = std::sync::mpsc::channel::<()>();
+        let call = start_future(
+            spawn_thread,
+            async move {
+                let _dropped = dropped_tx;
+                std::future::pending::<u32>().await
+            },
+            send_task,
+            user_data,
+        );
+        unsafe { future_cancel(call) };
+        let task = receiver.recv().unwrap() as *mut c_void;
+        assert!(
+            task.is_null(),
+            "cancelled futures should complete without a task"
+        );
+        assert!(dropped_rx.recv().is_err(), "the future should be dropped");
+        unsafe { future_release(call) };
+
+        let call = start_future(spawn_thread, async { 7u32 }, send_task, user_data);
+        let task = receiver.recv().unwrap() as *mut c_void;
+        unsafe { future_release(call) };
+        assert_eq!(unsafe { finish_async::<u32>(task) }.unwrap(), 7);
+    }
+
+    #[test]
+    fn test_stream_pulls_lazily() {
+        let produced = Arc::new(std::sync::atomic::AtomicUsize::new(0));
+        let counter = produced.clone();
+        let stream = stream_into_ptr((1u64..=3).inspect(move |_| {
+            counter.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
+        }));
+        assert_eq!(produced.load(std::sync::atomic::Ordering::SeqCst), 0);
+
+        assert_eq!(unsafe { stream_next::<u64>(stream) }, Some(1));
+        assert_eq!(unsafe { stream_next::<u64>(stream) }, Some(2));
+        assert_eq!(produced.load(std::sync::atomic::Ordering::SeqCst), 2);
+        unsafe { stream_drop::<u64>(stream) };
+        assert_eq!(produced.load(std::sync::atomic::Ordering::SeqCst), 2);
+
+        // Dropping null should be a no-op
+        unsafe { stream_drop::<u64>(ptr::null_mut()) };
+    }
+
+    #[test]
+    fn test_byte_streams() {
+        let stream = byte_stream_into_ptr(std::io::Cursor::new(b"hello".to_vec()));
+        let mut buf = [0u8; 3];
+        let n = unsafe { byte_stream_read(stream, buf.as_mut_ptr(), buf.len()) }.unwrap();
+        assert_eq!(&buf[..n], b"hel");
+        let n = unsafe { byte_stream_read(stream, buf.as_mut_ptr(), buf.len()) }.unwrap();
+        assert_eq!(&buf[..n], b"lo");
+        let n = unsafe { byte_stream_read(stream, buf.as_mut_ptr(), buf.len()) }.unwrap();
+        assert_eq!(n, 0, "an exhausted stream should read nothing");
+        unsafe { byte_stream_drop(stream) };
+        unsafe { byte_stream_drop(ptr::null_mut()) };
+
+        extern "C" fn read_cursor(user_data: usize, buf: *mut u8, len: usize) -> isize {
+            let cursor = unsafe { &mut *(user_data as *mut std::io::Cursor<&[u8]>) };
+            let buf = unsafe { std::slice::from_raw_parts_mut(buf, len) };
+            cursor.read(buf).map_or(-1, |n| n as isize)
+        }
+        let mut cursor = std::io::Cursor::new(&b"from the caller"[..]);
+        let source = FfiByteSource {
+            read: read_cursor,
+            user_data: &mut cursor as *mut _ as usize,
+        };
+        let mut text = String::new();
+        unsafe { ByteStream::new(source) }
+            .read_to_string(&mut text)
+            .unwrap();
+        assert_eq!(text, "from the caller");
+
+        extern "C" fn read_failure(_: usize, _: *mut u8, _: usize) -> isize {
+            -1
+        }
+        let source = FfiByteSource {
+            read: read_failure,
+            user_data: 0,
+        };
+        let err = unsafe { ByteStream::new(source) }
+            .read(&mut buf)
+            .unwrap_err();
+        assert_eq!(err.to_string(), "the caller failed to read the stream");
+    }
+
+    #[test]
+    fn test_error_context_identity() {
+        let a = ErrorContext::new("boom");
+        let b = ErrorContext::new("boom");
+        assert_ne!(a, b);
+        assert_ne!(a.id(), 0);
+        assert_eq!(a.to_string(), "boom");
+
+        // Round trip through the FFI representations
+        let out = a.clone().into_ffi();
+        let bytes =
+            unsafe { std::slice::from_raw_parts(out.debug_message.ptr, out.debug_message.len) };
+        let input = FfiErrorContextInput {
+            id: out.id,
+            debug_message: FfiByteSlice {
+                ptr: bytes.as_ptr(),
+                len: bytes.len(),
+            },
+        };
+        let back = unsafe { ErrorContext::from_ffi_input(&input) };
+        assert_eq!(back, a);
+        assert_eq!(back.debug_message(), "boom");
+        unsafe { out.debug_message.free() };
+    }
+
+    #[test]
+    fn test_cancel_tokens() {
+        let token = cancel_token_new();
+        unsafe { cancel_token_enter(token) };
+        assert!(!is_cancelled());
+        let stream = stream_into_ptr(std::iter::repeat_with(is_cancelled));
+        cancel_token_exit();
+
+        // The stream keeps the token of the call that returned it
+        assert_eq!(unsafe { stream_next::<bool>(stream) }, Some(false));
+        unsafe { cancel_token_cancel(token) };
+        assert!(
+            !is_cancelled(),
+            "the token is no longer bound to this thread"
+        );
+        assert_eq!(unsafe { stream_next::<bool>(stream) }, Some(true));
+        unsafe { stream_drop::<bool>(stream) };
+        unsafe { cancel_token_free(token) };
+
+        let cancelled = CancelToken::new();
+        cancelled.cancel();
+        assert!(with_cancel_token(Some(cancelled), is_cancelled));
+        assert!(!is_cancelled(), "the previous token should be restored");
+    }
+
+    #[test]
+    fn test_nested_cancel_tokens() {
+        // Every other level cancels its own token, as if each call reentered
+        // through an import with a context of its own
+        const DEPTH: usize = 512;
+        let tokens: Vec<*mut c_void> = (0..DEPTH).map(|_| cancel_token_new()).collect();
+        for (depth, token) in tokens.iter().enumerate() {
+            unsafe { cancel_token_enter(*token) };
+            if depth % 2 == 1 {
+                unsafe { cancel_token_cancel(*token) };
+            }
+            assert_eq!(is_cancelled(), depth % 2 == 1, "entering depth {depth}");
+        }
+        for depth in (0..DEPTH).rev() {
+            assert_eq!(is_cancelled(), depth % 2 == 1, "leaving depth {depth}");
+            cancel_token_exit();
+        }
+        assert!(
+            current_cancel_token().is_none(),
+            "the outermost exit should unbind every token"
+        );
+        for token in tokens {
+            unsafe { cancel_token_free(token) };
+        }
+
+        // Calls on other threads keep their own bindings
+        let token = cancel_token_new();
+        unsafe { cancel_token_enter(token) };
+        unsafe { cancel_token_cancel(token) };
+        let handles: Vec<_> = (0..8)
+            .map(|_| std::thread::spawn(|| (is_cancelled(), current_cancel_token().is_none())))
+            .collect();
+        for handle in handles {
+            assert_eq!(handle.join().expect("thread panicked"), (false, true));
+        }
+        assert!(is_cancelled());
+        cancel_token_exit();
+        unsafe { cancel_token_free(token) };
+    }
+
+    #[test]
+    fn test_error_chain() {
+        #[derive(Debug)]
+        struct Outer(std::num::ParseIntError);
+
+        impl std::fmt::Display for Outer {
+            fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+                write!(f, "invalid amount")
+            }
+        }
+
+        impl std::error::Error for Outer {
+            fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+                Some(&self.0)
+            }
+        }
+
+        let inner = "x".parse::<u32>().unwrap_err();
+        assert_eq!(
+            error_chain(&Outer(inner.clone())),
+            format!("invalid amount\u{1e}{inner}")
+        );
+        assert_eq!(error_chain(&inner), inner.to_string());
+    }
+
+    #[test]
+    fn test_init_options() {
+        assert_eq!(
+            init_options(b"threads\x004\x00cache\x00\x00"),
+            [
+                ("threads".to_string(), "4".to_string()),
+                ("cache".to_string(), String::new())
+            ]
+        );
+        assert!(init_options(b"").is_empty());
+    }
 }
@@ -0,0 +1,144 @@
+//! Forwarding of `log` records to a sink registered by the caller.
+//!
+//! With the `log` feature enabled, [`set_sink`] installs a [`log::Log`]
+//! implementation as the library's global logger, handing every record to
+//! the caller's [`FfiLogSink`](crate::FfiLogSink): its level, target,
+//! formatted message and key-value fields. The caller logs them with its own
+//! logger, so the library's diagnostics land alongside the caller's.
+//!
+//! `tracing` events reach the sink too when the library enables `tracing`'s
+//! `log` feature, which emits them as `log` records while no `tracing`
+//! subscriber is installed.
+
+use std::sync::{LazyLock, RwLock};
+
+use log::kv::{self, Key, Value, VisitSource};
+
+use crate::{FfiByteSlice, FfiLogSink};
+
+/// The caller's sink, if one is registered.
+static SINK: RwLock<Option<FfiLogSink>> = RwLock::new(None);
+
+/// Whether [`SinkLogger`] is the global logger, which only the first
+/// [`set_sink`] can make it.
+static INSTALLED: LazyLock<bool> = LazyLock::new(|| log::set_logger(&SinkLogger).is_ok());
+
+/// A logger handing every record to the registered sink.
+struct SinkLogger;
+
+impl log::Log for SinkLogger {
+    fn enabled(&self, metadata: &log::Metadata<'_>) -> bool {
+        metadata.level() <= log::max_level()
+    }
+
+    fn log(&self, record: &log::Record<'_>) {
+        if !self.enabled(record.metadata()) {
+            return;
+        }
+        let Some(sink) = *SINK.read().unwrap_or_else(|e| e.into_inner()) else {
+            return;
+        };
+        let message = record.args().to_string();
+        let mut fields = FieldEncoder::default();
+        let _ = record.key_values().visit(&mut fields);
+        sink(
+            record.level() as u8,
+            byte_slice(record.target().as_bytes()),
+            byte_slice(message.as_bytes()),
+            byte_slice(&fields.0),
+        );
+    }
+
+    fn flush(&self) {}
+}
+
+/// Encodes key-value fields like [`init_options`](crate::init_options)
+/// decodes them: keys and values alternately, each followed by a NUL byte.
+#[derive(Default)]
+struct FieldEncoder(Vec<u8>);
+
+impl<'kvs> VisitSource<'kvs> for FieldEncoder {
+    fn visit_pair(&mut self, key: Key<'kvs>, value: Value<'kvs>) -> Result<(), kv::Error> {
+        for field in [key.as_str().to_string(), value.to_string()] {
+            self.0.extend(field.bytes().filter(|b| *b != 0));
+            self.0.push(0);
+        }
+        Ok(())
+    }
+}
+
+fn byte_slice(bytes: &[u8]) -> FfiByteSlice {
+    FfiByteSlice {
+        ptr: bytes.as_ptr(),
+        len: bytes.len(),
+    }
+}
+
+/// Forward the records up to `max_level` (1 for errors through 5 for trace,
+/// as numbered by [`log::Level`]) to `sink`, or none with a `max_level` of 0
+/// or no sink.
+///
+/// Returns false, forwarding nothing, if the library installed a logger of
+/// its own before the first call.
+pub fn set_sink(sink: Option<FfiLogSink>, max_level: u8) -> bool {
+    if !*INSTALLED {
+        return false;
+    }
+    *SINK.write().unwrap_or_else(|e| e.into_inner()) = sink;
+    let max_level = match (sink, max_level) {
+        (None, _) | (_, 0) => log::LevelFilter::Off,
+        (_, 1) => log::LevelFilter::Error,
+        (_, 2) => log::LevelFilter::Warn,
+        (_, 3) => log::LevelFilter::Info,
+        (_, 4) => log::LevelFilter::Debug,
+        _ => log::LevelFilter::Trace,
+    };
+    log::set_max_level(max_level);
+    true
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Mutex;
+
+    use super::*;
+
+    static RECORDS: Mutex<Vec<(u8, String, String, Vec<(String, String)>)>> =
+        Mutex::new(Vec::new());
+
+    extern "C" fn record(
+        level: u8,
+        target: FfiByteSlice,
+        message: FfiByteSlice,
+        fields: FfiByteSlice,
+    ) {
+        let text = |s: FfiByteSlice| String::from_utf8_lossy(unsafe { s.as_bytes() }).into_owned();
+        let fields = crate::init_options(unsafe { fields.as_bytes() });
+        RECORDS
+            .lock()
+            .unwrap()
+            .push((level, text(target), text(message), fields));
+    }
+
+    #[test]
+    fn test_records_reach_the_sink() {
+        assert!(set_sink(Some(record), 3));
+        log::info!(target: "parser", input = "0x1", len = 3; "parsed {} bytes", 3);
+        log::debug!("above the max level");
+        assert!(set_sink(None, 5));
+        log::error!("no sink");
+
+        assert_eq!(
+            *RECORDS.lock().unwrap(),
+            [(
+                3,
+                "parser".to_string(),
+                "parsed 3 bytes".to_string(),
+                vec![
+                    ("input".to_string(), "0x1".to_string()),
+                    ("len".to_string(), "3".to_string()),
+                ],
+            )]
+        );
+    }
+}
@@ -1,12 +1,13 @@
-//! Build task library for eip681 FFI binding generation.
+//! Build task library for the examples' FFI binding generation.
 //!
-//! Provides a single [`generate`] function that produces all FFI artifacts
-//! (Rust scaffolding, C headers, Kotlin bindings, Go bindings, Swift bindings)
-//! from the eip681 WIT definition. Configuration values and relative output
-//! paths are hardcoded to the eip681 example layout.
+//! Provides a [`generate`] function that produces all FFI artifacts (Rust
+//! scaffolding, C headers, Kotlin bindings, Go bindings, Swift bindings) from
+//! the eip681 WIT definition, and [`generate_reentrancy`], producing the Rust
+//! and Go artifacts of the reentrancy example. Configuration values and
+//! relative output paths are hardcoded to the examples' layout.
 //!
-//! Used by both the `xtask` binary (`cargo xtask generate`) and
-//! `examples/eip681-ffi/build.rs`.
+//! Used by both the `xtask` binary (`cargo xtask generate`) and the
+//! examples' `build.rs`.
 
 use std::path::Path;
 
@@ -118,6 +119,38 @@ const SWIFT_TYPES_HEADER: &str =
 const SWIFT_MODULE_MAP: &str =
     "examples/eip681-swift/Sources/CZcashEip681/include/module.modulemap";
 
+// ---- Hardcoded reentrancy configuration ----
+
+/// C function name prefix of the reentrancy example.
+const REENTRANCY_C_PREFIX: &str = "witffi_reentrancy";
+
+/// Library name of the reentrancy example.
+const REENTRANCY_LIBRARY_NAME: &str = "reentrancy_ffi";
+
+/// The interface whose calls the reentrancy example's Go bindings serialize.
+const REENTRANCY_SINGLE_THREADED: &str = "nest";
+
+/// Path to the reentrancy WIT definition file.
+const REENTRANCY_WIT_PATH: &str = "wit/reentrancy.wit";
+
+/// Rust scaffolding output of the reentrancy example.
+const REENTRANCY_RUST_OUTPUT: &str = "examples/reentrancy-ffi/src/ffi.rs";
+
+/// C header output of the reentrancy example's FFI crate.
+const REENTRANCY_FFI_HEADER: &str = "examples/reentrancy-ffi/ffi.h";
+
+/// witffi_types.h output of the reentrancy example's FFI crate.
+const REENTRANCY_FFI_TYPES_HEADER: &str = "examples/reentrancy-ffi/witffi_types.h";
+
+/// Go bindings output of the reentrancy example.
+const REENTRANCY_GO_OUTPUT: &str = "examples/reentrancy-go/bindings.go";
+
+/// Go C header output of the reentrancy example.
+const REENTRANCY_GO_FFI_HEADER: &str = "examples/reentrancy-go/ffi.h";
+
+/// Go witffi_types.h output of the reentrancy example.
+const REENTRANCY_GO_TYPES_HEADER: &str = "examples/reentrancy-go/witffi_types.h";
+
 // ---- Public API ----
 
 /// Generate all eip681 FFI artifacts from the WIT definition.
@@ -142,6 +175,12 @@ pub fn generate(workspace_root: &Path) -> Result<(), Error> {
         c_type_prefix: C_TYPE_PREFIX.to_string(),
         kotlin_package: Some(KOTLIN_PACKAGE.to_string()),
         library_name: Some(LIBRARY_NAME.to_string()),
+        into_variants: false,
+        columnar: Default::default(),
+        alloc_accounting: false,
+        alloc_canaries: false,
+        lifecycle: false,
+        log_bridge: false,
     };
     let rust_generator = witffi_rust::RustGenerator::new(&resolve, world_id, rust_config);
 
@@ -192,6 +231,15 @@ pub fn generate(workspace_root: &Path) -> Result<(), Error> {
         c_type_prefix: C_TYPE_PREFIX.to_string(),
         go_package: None,
         lib_name: LIBRARY_NAME.to_string(),
+        // Follow Go style for initialisms (`ChainID`, not `ChainId`)
+        naming: witffi_core::names::GoNaming {
+            initialisms: witffi_core::names::GO_INITIALISMS
+                .iter()
+                .map(|word| word.to_string())
+                .collect(),
+            ..Default::default()
+        },
+        ..Default::default()
     };
     let go_generator = witffi_go::GoGenerator::new(&resolve, world_id, go_config);
     let go_code = go_generator.generate().context(GenerateGoSnafu)?;
@@ -201,6 +249,12 @@ pub fn generate(workspace_root: &Path) -> Result<(), Error> {
     write_file(&go_path, &go_code)?;
     eprintln!("Wrote {}", go_path.display());
 
+    // Format it as `witffi generate` does, so `gofmt -l` stays clean.
+    let _ = std::process::Command::new("gofmt")
+        .arg("-w")
+        .arg(&go_path)
+        .status();
+
     // ---- Go C headers (CGo requires headers alongside .go source) ----
 
     let go_header_path = workspace_root.join(GO_FFI_HEADER);
@@ -248,6 +302,99 @@ pub fn generate(workspace_root: &Path) -> Result<(), Error> {
     Ok(())
 }
 
+/// Generate the reentrancy example's Rust scaffolding, C headers, and Go
+/// bindings from its WIT definition.
+///
+/// The Go bindings serialize calls into the `nest` interface, so that nested
+/// calls through it exercise the single-threaded call lock.
+///
+/// # Errors
+///
+/// Returns an error if WIT loading, code generation, or file I/O fails.
+pub fn generate_reentrancy(workspace_root: &Path) -> Result<(), Error> {
+    let wit_path = workspace_root.join(REENTRANCY_WIT_PATH);
+    let (resolve, world_id) = witffi_core::load_wit(&wit_path).context(LoadWitSnafu {
+        path: wit_path.display().to_string(),
+    })?;
+
+    // ---- Rust scaffolding ----
+
+    let rust_config = witffi_rust::generate::RustConfig {
+        c_prefix: REENTRANCY_C_PREFIX.to_string(),
+        c_type_prefix: C_TYPE_PREFIX.to_string(),
+        kotlin_package: None,
+        library_name: Some(REENTRANCY_LIBRARY_NAME.to_string()),
+        into_variants: false,
+        columnar: Default::default(),
+        alloc_accounting: false,
+        alloc_canaries: false,
+        lifecycle: false,
+        log_bridge: false,
+    };
+    let rust_generator = witffi_rust::RustGenerator::new(&resolve, world_id, rust_config);
+
+    let rust_code = rust_generator.generate().context(GenerateRustSnafu)?;
+    let rust_path = workspace_root.join(REENTRANCY_RUST_OUTPUT);
+    ensure_parent_dir(&rust_path)?;
+    write_file(&rust_path, &rust_code)?;
+    eprintln!("Wrote {}", rust_path.display());
+
+    let _ = std::process::Command::new("rustfmt")
+        .arg("--edition")
+        .arg("2024")
+        .arg(&rust_path)
+        .status();
+
+    // ---- C headers (FFI crate) ----
+
+    let c_header = rust_generator
+        .generate_c_header()
+        .context(GenerateCHeaderSnafu)?;
+
+    let ffi_header_path = workspace_root.join(REENTRANCY_FFI_HEADER);
+    write_file(&ffi_header_path, &c_header)?;
+    eprintln!("Wrote {}", ffi_header_path.display());
+
+    let ffi_types_path = workspace_root.join(REENTRANCY_FFI_TYPES_HEADER);
+    write_file(&ffi_types_path, witffi_rust::WITFFI_TYPES_HEADER)?;
+    eprintln!("Wrote {}", ffi_types_path.display());
+
+    // ---- Go bindings ----
+
+    let go_config = witffi_go::generate::GoConfig {
+        c_prefix: REENTRANCY_C_PREFIX.to_string(),
+        c_type_prefix: C_TYPE_PREFIX.to_string(),
+        go_package: None,
+        lib_name: REENTRANCY_LIBRARY_NAME.to_string(),
+        single_threaded: [REENTRANCY_SINGLE_THREADED.to_string()].into(),
+        ..Default::default()
+    };
+    let go_generator = witffi_go::GoGenerator::new(&resolve, world_id, go_config);
+    let go_code = go_generator.generate().context(GenerateGoSnafu)?;
+
+    let go_path = workspace_root.join(REENTRANCY_GO_OUTPUT);
+    ensure_parent_dir(&go_path)?;
+    write_file(&go_path, &go_code)?;
+    eprintln!("Wrote {}", go_path.display());
+
+    let _ = std::process::Command::new("gofmt")
+        .arg("-w")
+        .arg(&go_path)
+        .status();
+
+    // ---- Go C headers ----
+
+    let go_header_path = workspace_root.join(REENTRANCY_GO_FFI_HEADER);
+    write_file(&go_header_path, &c_header)?;
+    eprintln!("Wrote {}", go_header_path.display());
+
+    let go_types_path = workspace_root.join(REENTRANCY_GO_TYPES_HEADER);
+    write_file(&go_types_path, witffi_rust::WITFFI_TYPES_HEADER)?;
+    eprintln!("Wrote {}", go_types_path.display());
+
+    Ok(())
+}
+
 // ---- Helpers ----
 
 /// Write content to a file, wrapping I/O errors with the path.
@@ -1,4 +1,4 @@
-//! xtask CLI — generate eip681 FFI bindings from WIT definitions.
+//! xtask CLI — generate the examples' FFI bindings from WIT definitions.
 //!
 //! Run via `cargo xtask generate` to regenerate all binding artifacts.
 
@@ -12,7 +12,7 @@ type Result<T, E = snafu::Whatever> = std::result::Result<T, E>;
 #[derive(Parser)]
 #[command(
     name = "xtask",
-    about = "Build task runner for eip681 FFI binding generation"
+    about = "Build task runner for the examples' FFI binding generation"
 )]
 struct Cli {
     #[command(subcommand)]
@@ -33,6 +33,8 @@ fn main() -> Result<()> {
         Commands::Generate => {
             let workspace_root = workspace_root()?;
             xtask::generate(&workspace_root).whatever_context("binding generation failed")?;
+            xtask::generate_reentrancy(&workspace_root)
+                .whatever_context("reentrancy binding generation failed")?;
             eprintln!("Done.");
         }
     }
@@ -193,6 +193,18 @@ macro_rules! witffi_register_ffi {
             unsafe { buf.free() };
         }
 
+        fn transaction_request_release(v: &FfiTransactionRequest) {
+            if !v.native.is_null() {
+                drop(unsafe { Box::from_raw(v.native) });
+            }
+            if !v.erc20.is_null() {
+                drop(unsafe { Box::from_raw(v.erc20) });
+            }
+            if !v.unrecognised.is_null() {
+                drop(unsafe { Box::from_raw(v.unrecognised) });
+            }
+        }
+
         #[allow(clippy::missing_safety_doc)]
         #[unsafe(no_mangle)]
         pub unsafe extern "C" fn zcash_eip681_free_native_request(ptr: *mut FfiNativeRequest) {
@@ -208,12 +220,18 @@ macro_rules! witffi_register_ffi {
         #[allow(clippy::missing_safety_doc)]
         #[unsafe(no_mangle)]
         pub unsafe extern "C" fn zcash_eip681_free_transaction_request(ptr: *mut FfiTransactionRequest) {
-            unsafe { witffi_types::free_ptr(ptr) };
+            if !ptr.is_null() {
+                let v = unsafe { Box::from_raw(ptr) };
+                transaction_request_release(&*v);
+            }
         }
 
         // Last error message stored for FFI error reporting.
         std::thread_local! {
             static LAST_ERROR: std::cell::RefCell<Option<String>> = const { std::cell::RefCell::new(None) };
+            // The backtrace (empty unless captured) of a panic that set
+            // LAST_ERROR, until taken with `_take_panic`.
+            static LAST_PANIC: std::cell::RefCell<Option<String>> = const { std::cell::RefCell::new(None) };
         }
 
         #[unsafe(no_mangle)]
@@ -246,6 +264,60 @@ macro_rules! witffi_register_ffi {
         #[unsafe(no_mangle)]
         pub extern "C" fn zcash_eip681_clear_last_error() {
             LAST_ERROR.with(|e| *e.borrow_mut() = None);
+            LAST_PANIC.with(|p| *p.borrow_mut() = None);
+        }
+
+        #[allow(clippy::missing_safety_doc)]
+        #[unsafe(no_mangle)]
+        pub unsafe extern "C" fn zcash_eip681_take_panic(backtrace_out: *mut witffi_types::FfiByteBuffer) -> bool {
+            match LAST_PANIC.with(|p| p.borrow_mut().take()) {
+                Some(backtrace) => {
+                    if !backtrace_out.is_null() {
+                        unsafe { *backtrace_out = witffi_types::FfiByteBuffer::from_string(backtrace) };
+                    }
+                    true
+                }
+                None => false,
+            }
+        }
+
+        #[unsafe(no_mangle)]
+        pub extern "C" fn zcash_eip681_capture_panic_backtraces() {
+            #[cfg(debug_assertions)]
+            witffi_types::capture_panic_backtraces();
+        }
+
+        #[unsafe(no_mangle)]
+        pub extern "C" fn zcash_eip681_abi_hash() -> u64 {
+            0x1128c9160bcd1888
+        }
+
+        #[unsafe(no_mangle)]
+        pub extern "C" fn zcash_eip681_cancel_token_new() -> *mut std::ffi::c_void {
+            witffi_types::cancel_token_new()
+        }
+
+        #[allow(clippy::missing_safety_doc)]
+        #[unsafe(no_mangle)]
+        pub unsafe extern "C" fn zcash_eip681_cancel_token_cancel(token: *mut std::ffi::c_void) {
+            unsafe { witffi_types::cancel_token_cancel(token) }
+        }
+
+        #[allow(clippy::missing_safety_doc)]
+        #[unsafe(no_mangle)]
+        pub unsafe extern "C" fn zcash_eip681_cancel_token_free(token: *mut std::ffi::c_void) {
+            unsafe { witffi_types::cancel_token_free(token) }
+        }
+
+        #[allow(clippy::missing_safety_doc)]
+        #[unsafe(no_mangle)]
+        pub unsafe extern "C" fn zcash_eip681_cancel_token_enter(token: *mut std::ffi::c_void) {
+            unsafe { witffi_types::cancel_token_enter(token) }
+        }
+
+        #[unsafe(no_mangle)]
+        pub extern "C" fn zcash_eip681_cancel_token_exit() {
+            witffi_types::cancel_token_exit()
         }
 
         #[allow(clippy::missing_safety_doc)]
@@ -274,6 +346,7 @@ macro_rules! witffi_register_ffi {
                         "unknown panic".to_string()
                     };
                     LAST_ERROR.with(|e| *e.borrow_mut() = Some(msg));
+                    LAST_PANIC.with(|p| *p.borrow_mut() = Some(witffi_types::take_panic_backtrace().unwrap_or_default()));
                     std::ptr::null_mut()
                 }
             }
@@ -300,6 +373,7 @@ macro_rules! witffi_register_ffi {
                         "unknown panic".to_string()
                     };
                     LAST_ERROR.with(|e| *e.borrow_mut() = Some(msg));
+                    LAST_PANIC.with(|p| *p.borrow_mut() = Some(witffi_types::take_panic_backtrace().unwrap_or_default()));
                     witffi_types::FfiByteBuffer::from_string(String::new())
                 }
             }
@@ -537,3 +611,302 @@ macro_rules! witffi_register_jni {
         }
     };
 }
+// ---- Wasm Registration macro ----
+
+/// Register a concrete type for WebAssembly (Go consumers running it under
+/// wazero).
+///
+/// Built for `wasm32-wasip1`, this macro exports each freestanding function
+/// passing numbers, bools, strings and `list<u8>` under its component export
+/// name, following the canonical ABI, with the `cabi_realloc` its callers
+/// allocate arguments through. It expands to nothing for other targets.
+///
+/// # Example
+///
+/// ```ignore
+/// struct MyImpl;
+/// impl Eip681 for MyImpl {
+///     // ... implement trait methods ...
+/// }
+/// witffi_register_wasm!(MyImpl);
+/// ```
+#[macro_export]
+macro_rules! witffi_register_wasm {
+    ($impl_type:ty) => {
+        #[cfg(target_family = "wasm")]
+        // The helpers go unused when no function passes what they convert
+        #[allow(dead_code)]
+        const _: () = {
+            // Results too big for a core value are written here, and read
+            // by the caller before the next call
+            #[repr(align(8))]
+            struct ReturnArea([u8; 16]);
+
+            static mut RETURN_AREA: ReturnArea = ReturnArea([0; 16]);
+
+            #[unsafe(no_mangle)]
+            pub unsafe extern "C" fn cabi_realloc(
+                old_ptr: *mut u8,
+                old_size: usize,
+                align: usize,
+                new_size: usize,
+            ) -> *mut u8 {
+                if new_size == 0 {
+                    return align as *mut u8;
+                }
+                let layout = std::alloc::Layout::from_size_align(new_size, align)
+                    .expect("cabi_realloc: invalid layout");
+                let ptr = if old_size == 0 {
+                    unsafe { std::alloc::alloc(layout) }
+                } else {
+                    unsafe {
+                        std::alloc::realloc(
+                            old_ptr,
+                            std::alloc::Layout::from_size_align_unchecked(old_size, align),
+                            new_size,
+                        )
+                    }
+                };
+                if ptr.is_null() {
+                    std::alloc::handle_alloc_error(layout);
+                }
+                ptr
+            }
+
+            /// Take the bytes the caller allocated with `cabi_realloc`.
+            unsafe fn take_bytes(ptr: *mut u8, len: usize) -> Vec<u8> {
+                unsafe { Vec::from_raw_parts(ptr, len, len) }
+            }
+
+            /// Write `bytes` to `at` as a pointer and a length, leaking them
+            /// until `free_buffer`.
+            unsafe fn write_buffer(at: *mut u8, bytes: Vec<u8>) {
+                let bytes = Box::into_raw(bytes.into_boxed_slice());
+                unsafe {
+                    at.cast::<*mut u8>().write(bytes.cast::<u8>());
+                    at.add(4).cast::<usize>().write(bytes.len());
+                }
+            }
+
+            /// Free the bytes `write_buffer` wrote to `at`.
+            unsafe fn free_buffer(at: *mut u8) {
+                unsafe {
+                    let ptr = at.cast::<*mut u8>().read();
+                    let len = at.add(4).cast::<usize>().read();
+                    drop(Box::from_raw(std::ptr::slice_from_raw_parts_mut(ptr, len)));
+                }
+            }
+
+            #[unsafe(export_name = "zcash:eip681/functions#u256-to-string")]
+            pub unsafe extern "C" fn wasm_zcash_eip681_functions_u256_to_string(
+                input_ptr: *mut u8,
+                input_len: usize,
+            ) -> *mut u8 {
+                let input = unsafe { take_bytes(input_ptr, input_len) };
+                let area = (&raw mut RETURN_AREA).cast::<u8>();
+                let result = <$impl_type>::functions_u256_to_string(&input);
+                unsafe { write_buffer(area, result.into_bytes()) };
+                area
+            }
+
+            #[unsafe(export_name = "cabi_post_zcash:eip681/functions#u256-to-string")]
+            pub unsafe extern "C" fn wasm_zcash_eip681_functions_u256_to_string_post_return(
+                area: *mut u8,
+            ) {
+                unsafe { free_buffer(area) };
+            }
+        };
+    };
+}
+
+// ---- Sidecar Registration macro ----
+
+/// Register a concrete type for a sidecar process (Go consumers running it
+/// apart from their own).
+///
+/// This macro defines `witffi_serve_sidecar`, which serves each freestanding
+/// function passing numbers, bools, strings and `list<u8>` to the bindings
+/// on stdin and stdout until stdin closes. The sidecar is a binary whose
+/// `main` calls it.
+///
+/// # Example
+///
+/// ```ignore
+/// struct MyImpl;
+/// impl Eip681 for MyImpl {
+///     // ... implement trait methods ...
+/// }
+/// witffi_register_sidecar!(MyImpl);
+///
+/// // src/bin/sidecar.rs
+/// fn main() -> std::io::Result<()> {
+///     my_crate::witffi_serve_sidecar()
+/// }
+/// ```
+#[macro_export]
+macro_rules! witffi_register_sidecar {
+    ($impl_type:ty) => {
+        /// Serve calls from the bindings on stdin and stdout until stdin
+        /// closes. A call that panics is answered with the panic's message.
+        #[cfg(not(target_family = "wasm"))]
+        pub fn witffi_serve_sidecar() -> std::io::Result<()> {
+            use std::io::{Read, Write};
+
+            /// Read a frame, or None once `input` closes.
+            fn read_frame(input: &mut impl Read) -> std::io::Result<Option<Vec<u8>>> {
+                let mut len = [0; 4];
+                match input.read_exact(&mut len) {
+                    Ok(()) => {}
+                    Err(e) if e.kind() == std::io::ErrorKind::UnexpectedEof => return Ok(None),
+                    Err(e) => return Err(e),
+                }
+                let mut frame = vec![0; u32::from_le_bytes(len) as usize];
+                input.read_exact(&mut frame)?;
+                Ok(Some(frame))
+            }
+
+            fn write_frame(output: &mut impl Write, frame: &[u8]) -> std::io::Result<()> {
+                output.write_all(&(frame.len() as u32).to_le_bytes())?;
+                output.write_all(frame)?;
+                output.flush()
+            }
+
+            /// Append `bytes` to `response` behind their length.
+            #[allow(dead_code)]
+            fn put_buffer(response: &mut Vec<u8>, bytes: &[u8]) {
+                response.extend_from_slice(&(bytes.len() as u32).to_le_bytes());
+                response.extend_from_slice(bytes);
+            }
+
+            /// The rest of a request, read in order. A short request panics,
+            /// failing the call.
+            struct Args<'a>(&'a [u8]);
+
+            #[allow(dead_code)]
+            impl Args<'_> {
+                fn take<const N: usize>(&mut self) -> [u8; N] {
+                    let (head, rest) = self.0.split_at(N);
+                    self.0 = rest;
+                    head.try_into().expect("split at N")
+                }
+
+                fn bytes(&mut self) -> Vec<u8> {
+                    let len = u32::from_le_bytes(self.take()) as usize;
+                    let (head, rest) = self.0.split_at(len);
+                    self.0 = rest;
+                    head.to_vec()
+                }
+
+                fn string(&mut self) -> String {
+                    String::from_utf8(self.bytes()).expect("strings are UTF-8")
+                }
+            }
+
+            let mut input = std::io::stdin().lock();
+            let mut output = std::io::stdout().lock();
+            // The bindings check that they were generated from the same world
+            write_frame(&mut output, &0x1128c9160bcd1888u64.to_le_bytes())?;
+            while let Some(request) = read_frame(&mut input)? {
+                let served = std::panic::catch_unwind(std::panic::AssertUnwindSafe(|| {
+                    let mut args = Args(&request);
+                    let mut response = vec![0u8];
+                    match u32::from_le_bytes(args.take()) {
+                        1 => {
+                            let input = args.bytes();
+                            let value = <$impl_type>::functions_u256_to_string(&input);
+                            put_buffer(&mut response, value.as_bytes());
+                        }
+                        id => panic!("no function has id {id}"),
+                    }
+                    response
+                }));
+                let response = served.unwrap_or_else(|payload| {
+                    let message = payload
+                        .downcast_ref::<&str>()
+                        .map(|s| s.to_string())
+                        .or_else(|| payload.downcast_ref::<String>().cloned())
+                        .unwrap_or_else(|| "the call panicked".to_string());
+                    let mut response = vec![2u8];
+                    put_buffer(&mut response, message.as_bytes());
+                    response
+                });
+                write_frame(&mut output, &response)?;
+            }
+            Ok(())
+        }
+    };
+}
+
+// ---- gRPC Registration macro ----
+
+/// Register a concrete type for a gRPC service (Go consumers calling it
+/// over the network).
+///
+/// This macro defines `WitffiGrpc`, which implements the service tonic-build
+/// generates into the module `$proto` from the world's proto file, serving
+/// each freestanding function passing numbers, bools, strings and
+/// `list<u8>`. Calls run on tokio's blocking threads. An error is returned
+/// as status UNKNOWN and a panic as INTERNAL, each with its message.
+///
+/// # Example
+///
+/// ```ignore
+/// mod proto {
+///     tonic::include_proto!("zcash.eip681");
+/// }
+///
+/// struct MyImpl;
+/// impl Eip681 for MyImpl {
+///     // ... implement trait methods ...
+/// }
+/// witffi_register_grpc!(MyImpl, proto);
+///
+/// tonic::transport::Server::builder()
+///     .add_service(proto::eip681_server::Eip681Server::new(WitffiGrpc))
+///     .serve(addr)
+///     .await?;
+/// ```
+#[macro_export]
+macro_rules! witffi_register_grpc {
+    ($impl_type:ty, $proto:ident) => {
+        /// The gRPC service of the world, calling `$impl_type`.
+        #[cfg(not(target_family = "wasm"))]
+        pub struct WitffiGrpc;
+
+        #[cfg(not(target_family = "wasm"))]
+        const _: () = {
+            /// The status of a call that panicked, or was cancelled.
+            #[allow(dead_code)]
+            fn panic_status(error: tokio::task::JoinError) -> tonic::Status {
+                let message = match error.try_into_panic() {
+                    Ok(payload) => payload
+                        .downcast_ref::<&str>()
+                        .map(|s| s.to_string())
+                        .or_else(|| payload.downcast_ref::<String>().cloned())
+                        .unwrap_or_else(|| "the call panicked".to_string()),
+                    Err(error) => error.to_string(),
+                };
+                tonic::Status::internal(message)
+            }
+
+            #[tonic::async_trait]
+            impl $proto::eip681_server::Eip681 for WitffiGrpc {
+                async fn functions_u256_to_string(
+                    &self,
+                    request: tonic::Request<$proto::FunctionsU256ToStringRequest>,
+                ) -> Result<tonic::Response<$proto::FunctionsU256ToStringResponse>, tonic::Status>
+                {
+                    let request = request.into_inner();
+                    let value = tokio::task::spawn_blocking(move || {
+                        <$impl_type>::functions_u256_to_string(&request.input)
+                    })
+                    .await
+                    .map_err(panic_status)?;
+                    Ok(tonic::Response::new(
+                        $proto::FunctionsU256ToStringResponse { value },
+                    ))
+                }
+            }
+        };
+    };
+}
@@ -1,5 +1,13 @@
 // Code generated by witffi. DO NOT EDIT.
 
+// Package eip681 holds the Go bindings of the eip681 WIT world.
+//
+// EIP-681 transaction request parsing.
+//
+// This package provides types and functions for parsing EIP-681
+// Ethereum transaction request URIs (e.g. `ethereum:0x...?value=1000`).
+//
+// The eip681 world exports a parser for EIP-681 URIs.
 package eip681
 
 /*
@@ -12,6 +20,8 @@ import "C"
 
 import (
 	"fmt"
+	"runtime"
+	"sync"
 	"unsafe"
 )
 
@@ -37,115 +47,156 @@ func ffiByteBufferToBytes(buf C.FfiByteBuffer) []byte {
 	return b
 }
 
+// scratchPool holds byte buffers reused to stage error messages read
+// from Rust.
+var scratchPool = sync.Pool{New: func() any { return new([]byte) }}
+
 func readLastError() string {
 	length := C.zcash_eip681_last_error_length()
 	if length <= 0 {
 		return "unknown error"
 	}
-	buf := make([]byte, length)
+	scratch := scratchPool.Get().(*[]byte)
+	defer scratchPool.Put(scratch)
+	if cap(*scratch) < int(length) {
+		*scratch = make([]byte, length)
+	}
+	buf := (*scratch)[:length]
 	C.zcash_eip681_error_message_utf8((*C.char)(unsafe.Pointer(&buf[0])), length)
 	return string(buf[:length-1])
 }
 
+// abiHash identifies the WIT world these bindings were generated from,
+// which the Rust library must have been generated from too.
+const abiHash = 0x1128c9160bcd1888
+
+func init() {
+	if uint64(C.zcash_eip681_abi_hash()) != abiHash {
+		panic("witffi: bindings out of date: the Rust library was generated from a different version of the WIT world; re-run witffi and rebuild")
+	}
+}
+
 // ---- Types ----
 
-// A native ETH transfer request.
+// Erc20Request is an ERC-20 token transfer request.
+type Erc20Request struct {
+	// The chain ID, if specified.
+	ChainID *uint64
+	// The token contract address (ERC-55 checksummed hex string).
+	TokenContractAddress string
+	// The recipient address.
+	RecipientAddress string
+	// The value in atomic token units.
+	ValueAtomic U256
+	// The canonical display string.
+	Display string
+}
+
+// NativeRequest is a native ETH transfer request.
 type NativeRequest struct {
 	// The schema prefix (e.g. "ethereum").
 	SchemaPrefix string
 	// The chain ID, if specified.
-	ChainId *uint64
+	ChainID *uint64
 	// The recipient address (ERC-55 checksummed hex string).
 	RecipientAddress string
 	// The value in atomic units (wei), if specified.
-	ValueAtomic *[]byte
+	ValueAtomic *U256
 	// The gas limit, if specified.
-	GasLimit *[]byte
+	GasLimit *U256
 	// The gas price, if specified.
-	GasPrice *[]byte
+	GasPrice *U256
 	// The canonical display string (round-trips through parsing).
 	Display string
 }
 
-// An ERC-20 token transfer request.
-type Erc20Request struct {
-	// The chain ID, if specified.
-	ChainId *uint64
-	// The token contract address (ERC-55 checksummed hex string).
-	TokenContractAddress string
-	// The recipient address.
-	RecipientAddress string
-	// The value in atomic token units.
-	ValueAtomic []byte
-	// The canonical display string.
-	Display string
-}
-
 type transactionRequestVariant interface {
 	isTransactionRequest()
 }
 
-// A parsed EIP-681 transaction request.
+// TransactionRequest is a parsed EIP-681 transaction request.
 type TransactionRequest = transactionRequestVariant
 
-// A native ETH transfer.
-type TransactionRequestNative struct { Value NativeRequest }
+// TransactionRequestNative is a native ETH transfer.
+type TransactionRequestNative struct{ Value NativeRequest }
+
 func (TransactionRequestNative) isTransactionRequest() {}
 
-// An ERC-20 token transfer.
-type TransactionRequestErc20 struct { Value Erc20Request }
+// TransactionRequestErc20 is an ERC-20 token transfer.
+type TransactionRequestErc20 struct{ Value Erc20Request }
+
 func (TransactionRequestErc20) isTransactionRequest() {}
 
-// An unrecognised request (raw URI string preserved).
-type TransactionRequestUnrecognised struct { Value string }
+// TransactionRequestUnrecognised is an unrecognised request (raw URI string preserved).
+type TransactionRequestUnrecognised struct{ Value string }
+
 func (TransactionRequestUnrecognised) isTransactionRequest() {}
 
+// U256 is a 256-bit unsigned integer, encoded as 32 bytes big-endian.
 type U256 = []byte
 
 // ---- Conversion Functions ----
 
+func convertErc20Request(ffi C.FfiErc20Request) Erc20Request {
+	result := Erc20Request{
+		TokenContractAddress: ffiByteBufferToString(ffi.token_contract_address),
+		RecipientAddress:     ffiByteBufferToString(ffi.recipient_address),
+		ValueAtomic:          ffiByteBufferToBytes(ffi.value_atomic),
+		Display:              ffiByteBufferToString(ffi.display),
+	}
+	if ffi.chain_id != nil {
+		v := uint64(*ffi.chain_id)
+		result.ChainID = &v
+		C.free(unsafe.Pointer(ffi.chain_id))
+	}
+	return result
+}
+
 func convertNativeRequest(ffi C.FfiNativeRequest) NativeRequest {
 	result := NativeRequest{
-		SchemaPrefix: ffiByteBufferToString(ffi.schema_prefix),
+		SchemaPrefix:     ffiByteBufferToString(ffi.schema_prefix),
 		RecipientAddress: ffiByteBufferToString(ffi.recipient_address),
-		Display: ffiByteBufferToString(ffi.display),
+		Display:          ffiByteBufferToString(ffi.display),
 	}
 	if ffi.chain_id != nil {
 		v := uint64(*ffi.chain_id)
-		result.ChainId = &v
+		result.ChainID = &v
 		C.free(unsafe.Pointer(ffi.chain_id))
 	}
 	if ffi.value_atomic != nil {
 		v := ffiByteBufferToBytes(*ffi.value_atomic)
-		result.ValueAtomic = v
+		result.ValueAtomic = &v
 		C.free(unsafe.Pointer(ffi.value_atomic))
 	}
 	if ffi.gas_limit != nil {
 		v := ffiByteBufferToBytes(*ffi.gas_limit)
-		result.GasLimit = v
+		result.GasLimit = &v
 		C.free(unsafe.Pointer(ffi.gas_limit))
 	}
 	if ffi.gas_price != nil {
 		v := ffiByteBufferToBytes(*ffi.gas_price)
-		result.GasPrice = v
+		result.GasPrice = &v
 		C.free(unsafe.Pointer(ffi.gas_price))
 	}
 	return result
 }
 
-func convertErc20Request(ffi C.FfiErc20Request) Erc20Request {
-	result := Erc20Request{
-		TokenContractAddress: ffiByteBufferToString(ffi.token_contract_address),
-		RecipientAddress: ffiByteBufferToString(ffi.recipient_address),
-		ValueAtomic: ffiByteBufferToBytes(ffi.value_atomic),
-		Display: ffiByteBufferToString(ffi.display),
+func convertOptionU256(ffi *C.FfiByteBuffer) *U256 {
+	if ffi == nil {
+		return nil
 	}
-	if ffi.chain_id != nil {
-		v := uint64(*ffi.chain_id)
-		result.ChainId = &v
-		C.free(unsafe.Pointer(ffi.chain_id))
+	v := ffiByteBufferToBytes(*ffi)
+	C.free(unsafe.Pointer(ffi))
+	return &v
+}
+
+func convertOptionU64(ffi *C.uint64_t) *uint64 {
+	if ffi == nil {
+		return nil
 	}
-	return result
+	v := uint64(*ffi)
+	C.free(unsafe.Pointer(ffi))
+	return &v
 }
 
 func convertTransactionRequest(ffi C.FfiTransactionRequest) TransactionRequest {
@@ -163,10 +214,22 @@ func convertTransactionRequest(ffi C.FfiTransactionRequest) TransactionRequest {
 
 // ---- Public API ----
 
-// Parse an EIP-681 URI string into a transaction request.
+// FunctionsU256ToString converts a u256 type to a string for display
+func FunctionsU256ToString(input U256) string {
+	inputSlice := C.FfiByteSlice{
+		ptr: (*C.uint8_t)(unsafe.Pointer(unsafe.SliceData(input))),
+		len: C.uintptr_t(len(input)),
+	}
+	result := C.zcash_eip681_functions_u256_to_string(inputSlice)
+	return ffiByteBufferToString(result)
+}
+
+// ParserParse parses an EIP-681 URI string into a transaction request.
 //
 // Returns an error string if parsing fails.
 func ParserParse(input string) (TransactionRequest, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	inputSlice := C.FfiByteSlice{
 		ptr: (*C.uint8_t)(unsafe.Pointer(unsafe.StringData(input))),
 		len: C.uintptr_t(len(input)),
@@ -179,13 +242,3 @@ func ParserParse(input string) (TransactionRequest, error) {
 	C.zcash_eip681_free_transaction_request(resultPtr)
 	return result, nil
 }
-
-// Convert a u256 type to a string for display
-func FunctionsU256ToString(input []byte) string {
-	inputSlice := C.FfiByteSlice{
-		ptr: (*C.uint8_t)(unsafe.Pointer(unsafe.SliceData(input))),
-		len: C.uintptr_t(len(input)),
-	}
-	result := C.zcash_eip681_functions_u256_to_string(inputSlice)
-	return ffiByteBufferToString(result)
-}
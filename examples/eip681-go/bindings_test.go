@@ -23,8 +23,8 @@ func TestParseNativeTransfer(t *testing.T) {
 	if r.SchemaPrefix != "ethereum" {
 		t.Errorf("schema = %q, want %q", r.SchemaPrefix, "ethereum")
 	}
-	if r.ChainId != nil {
-		t.Errorf("chainId = %v, want nil", *r.ChainId)
+	if r.ChainID != nil {
+		t.Errorf("chainId = %v, want nil", *r.ChainID)
 	}
 	if r.ValueAtomic == nil {
 		t.Error("valueAtomic is nil, want non-nil")
@@ -104,3 +104,25 @@ func TestParseNativeRoundTrip(t *testing.T) {
 		t.Errorf("schema mismatch: %q vs %q", native.Value.SchemaPrefix, native2.Value.SchemaPrefix)
 	}
 }
+
+func BenchmarkParseNative(b *testing.B) {
+	uri := "ethereum:0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359?value=2014000000000000000"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParserParse(uri); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Failing calls stage the error message in a pooled buffer, so this
+// reports one allocation per call fewer than bindings generated with
+// --go-no-buffer-pool.
+func BenchmarkParseInvalidInput(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParserParse("not-a-valid-uri"); err == nil {
+			b.Fatal("expected error for invalid input, got nil")
+		}
+	}
+}
@@ -34,13 +34,13 @@ func main() {
 		fmt.Println("  Type:      Native ETH transfer")
 		fmt.Printf("  Schema:    %s\n", r.SchemaPrefix)
 		fmt.Printf("  To:        %s\n", r.RecipientAddress)
-		if r.ChainId != nil {
-			fmt.Printf("  Chain ID:  %d\n", *r.ChainId)
+		if r.ChainID != nil {
+			fmt.Printf("  Chain ID:  %d\n", *r.ChainID)
 		} else {
 			fmt.Println("  Chain ID:  mainnet (default)")
 		}
 		if r.ValueAtomic != nil {
-			fmt.Printf("  Value:     %d bytes (big-endian u256)\n", len(r.ValueAtomic))
+			fmt.Printf("  Value:     %d bytes (big-endian u256)\n", len(*r.ValueAtomic))
 		}
 		fmt.Printf("  Display:   %s\n", r.Display)
 	default:
@@ -65,8 +65,8 @@ func main() {
 		fmt.Println("  Type:      ERC-20 token transfer")
 		fmt.Printf("  Token:     %s\n", r.TokenContractAddress)
 		fmt.Printf("  To:        %s\n", r.RecipientAddress)
-		if r.ChainId != nil {
-			fmt.Printf("  Chain ID:  %d\n", *r.ChainId)
+		if r.ChainID != nil {
+			fmt.Printf("  Chain ID:  %d\n", *r.ChainID)
 		} else {
 			fmt.Println("  Chain ID:  mainnet (default)")
 		}
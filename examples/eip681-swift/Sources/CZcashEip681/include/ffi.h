@@ -66,6 +66,15 @@ typedef FfiByteBuffer FfiU256;
 int32_t zcash_eip681_last_error_length(void);
 int32_t zcash_eip681_error_message_utf8(char *buf, int32_t len);
 void zcash_eip681_clear_last_error(void);
+bool zcash_eip681_take_panic(FfiByteBuffer *backtrace_out);
+void zcash_eip681_capture_panic_backtraces(void);
+uint64_t zcash_eip681_abi_hash(void);
+
+void *zcash_eip681_cancel_token_new(void);
+void zcash_eip681_cancel_token_cancel(void *token);
+void zcash_eip681_cancel_token_free(void *token);
+void zcash_eip681_cancel_token_enter(void *token);
+void zcash_eip681_cancel_token_exit(void);
 
 FfiTransactionRequest* zcash_eip681_parser_parse(FfiByteSlice input);
 FfiByteBuffer zcash_eip681_functions_u256_to_string(FfiByteSlice input);
@@ -0,0 +1,13 @@
+//! Build script that delegates to `xtask` to generate FFI scaffolding,
+//! C headers, and Go bindings from the reentrancy WIT definition.
+
+use std::path::Path;
+
+fn main() {
+    let workspace_root = Path::new(env!("CARGO_WORKSPACE_DIR"));
+    println!(
+        "cargo::rerun-if-changed={}",
+        workspace_root.join("wit/reentrancy.wit").display()
+    );
+    xtask::generate_reentrancy(workspace_root).expect("xtask reentrancy binding generation failed");
+}
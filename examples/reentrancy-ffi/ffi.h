@@ -0,0 +1,48 @@
+/* Auto-generated by witffi. Do not edit. */
+#pragma once
+
+#include <stdint.h>
+#include <stdbool.h>
+#include <stddef.h>
+
+#include "witffi_types.h"
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* ---- Types ---- */
+
+/* FfiByteSlice and FfiByteBuffer are provided by witffi_types.h */
+
+
+/* ---- Functions ---- */
+
+int32_t witffi_reentrancy_last_error_length(void);
+int32_t witffi_reentrancy_error_message_utf8(char *buf, int32_t len);
+void witffi_reentrancy_clear_last_error(void);
+bool witffi_reentrancy_take_panic(FfiByteBuffer *backtrace_out);
+void witffi_reentrancy_capture_panic_backtraces(void);
+uint64_t witffi_reentrancy_abi_hash(void);
+
+void *witffi_reentrancy_cancel_token_new(void);
+void witffi_reentrancy_cancel_token_cancel(void *token);
+void witffi_reentrancy_cancel_token_free(void *token);
+void witffi_reentrancy_cancel_token_enter(void *token);
+void witffi_reentrancy_cancel_token_exit(void);
+
+uint32_t* witffi_reentrancy_nest_descend(uint32_t depth, uint32_t fail_at);
+uint32_t* witffi_reentrancy_spin_descend(uint32_t depth, uint32_t fail_at);
+
+/* ---- Free functions ---- */
+
+void witffi_reentrancy_free_byte_buffer(FfiByteBuffer buf);
+
+/* ---- Imported functions (defined by the caller) ---- */
+
+bool witffi_reentrancy_import_host_ascend(uint32_t depth, uint32_t fail_at, bool serialized, uint32_t *ok_out, FfiByteBuffer *err_out);
+void witffi_reentrancy_import_panicked(FfiByteSlice message);
+
+#ifdef __cplusplus
+}
+#endif
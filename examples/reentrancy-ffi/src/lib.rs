@@ -0,0 +1,41 @@
+//! Reentrancy FFI library — nested calls through witffi imports.
+//!
+//! Each exported `descend` calls the imported `ascend`, which the foreign
+//! caller implements by calling back into `descend` one level down. A call
+//! of depth `n` so nests `n` Rust frames between `n` foreign ones, on one
+//! thread, which exercises the import trampolines, the thread-local last
+//! error, and the foreign caller's locks under reentry.
+#![allow(non_camel_case_types, non_snake_case, unused_unsafe)]
+
+// build.rs generates src/ffi.rs — pull it in as a module.
+mod ffi;
+use ffi::*;
+
+/// Descend from `depth`, calling back into the caller through the `nest`
+/// interface if `serialized` is set and `spin` otherwise.
+///
+/// Errors from deeper levels are wrapped with the `interface` and depth they
+/// passed through, so the caller can check the whole chain arrived intact.
+fn descend(interface: &str, depth: u32, fail_at: u32, serialized: bool) -> Result<u32, String> {
+    if depth == 0 {
+        return Ok(0);
+    }
+    imports::host::ascend(depth, fail_at, serialized)
+        .map(|calls| calls + 1)
+        .map_err(|err| format!("{interface} at depth {depth}: {err}"))
+}
+
+struct Impl;
+
+impl Reentrancy for Impl {
+    fn nest_descend(depth: u32, fail_at: u32) -> Result<u32, String> {
+        descend("nest", depth, fail_at, true)
+    }
+
+    fn spin_descend(depth: u32, fail_at: u32) -> Result<u32, String> {
+        descend("spin", depth, fail_at, false)
+    }
+}
+
+// Stamp out `extern "C"` functions for C-ABI consumers (Go/CGo).
+witffi_register_ffi!(Impl);
@@ -0,0 +1,60 @@
+/* witffi_types.h — Shared FFI types for witffi-generated code. */
+/* This header is part of the witffi-types crate. Do not edit. */
+#pragma once
+
+#include <stdint.h>
+#include <stddef.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* A borrowed byte slice (caller-owned, const pointer). */
+typedef struct {
+    const uint8_t *ptr;
+    size_t len;
+} FfiByteSlice;
+
+/* An owned byte buffer (callee-allocated, must be freed). */
+typedef struct {
+    uint8_t *ptr;
+    size_t len;
+} FfiByteBuffer;
+
+/* The completion callback of an async function call. Called once, possibly
+   from another thread, with the caller's user_data and a task to pass to the
+   function's _finish counterpart. */
+typedef void (*FfiAsyncComplete)(uintptr_t user_data, void *task);
+
+/* The caller's receiver of the library's log records: level (1 = error
+   through 5 = trace), target, message, and key-value fields as keys and
+   values alternately, each followed by a NUL byte. Called from whichever
+   thread logs; the slices are only valid during the call. */
+typedef void (*FfiLogSink)(uint8_t level, FfiByteSlice target, FfiByteSlice message, FfiByteSlice fields);
+
+/* The caller's read function for a stream<u8> parameter. Reads up to len
+   bytes into buf and returns how many were read, 0 at the end of the stream
+   or a negative value on failure. */
+typedef intptr_t (*FfiByteRead)(uintptr_t user_data, uint8_t *buf, size_t len);
+
+/* A stream<u8> parameter, read from the caller while the call runs. */
+typedef struct {
+    FfiByteRead read;
+    uintptr_t user_data;
+} FfiByteSource;
+
+/* An owned error-context (callee-allocated, debug_message must be freed). */
+typedef struct {
+    uint64_t id;
+    FfiByteBuffer debug_message;
+} FfiErrorContext;
+
+/* A borrowed error-context (caller-owned). */
+typedef struct {
+    uint64_t id;
+    FfiByteSlice debug_message;
+} FfiErrorContextInput;
+
+#ifdef __cplusplus
+}
+#endif
@@ -0,0 +1,3 @@
+module github.com/schell/witffi/examples/reentrancy-go
+
+go 1.20
@@ -0,0 +1,178 @@
+package reentrancy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// host implements ascend by calling back into Rust one level down, so every
+// call nests Go and Rust frames on the calling thread.
+type host struct {
+	// panicAt, if nonzero, is the depth at which ascend panics.
+	panicAt atomic.Uint32
+}
+
+func (h *host) Ascend(depth uint32, failAt uint32, serialized bool) (uint32, error) {
+	if depth == h.panicAt.Load() {
+		panic(fmt.Sprintf("boom at depth %d", depth))
+	}
+	if depth == failAt {
+		return 0, fmt.Errorf("ascend failed at depth %d", depth)
+	}
+	descend := SpinDescend
+	if serialized {
+		descend = NestDescend
+	}
+	calls, err := descend(depth-1, failAt)
+	if err != nil {
+		return 0, err
+	}
+	return calls + 1, nil
+}
+
+var theHost host
+
+func TestMain(m *testing.M) {
+	if err := Init(Imports{Host: &theHost}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// descenders are the exported functions under test, by interface name.
+var descenders = map[string]func(depth uint32, failAt uint32) (uint32, error){
+	"nest": NestDescend,
+	"spin": SpinDescend,
+}
+
+// noFailure is a failAt no call reaches.
+const noFailure = ^uint32(0)
+
+// wantChain is the error a call of depth into interface fails with when
+// ascend fails at failAt: every level wraps the error from the one below.
+func wantChain(name string, depth uint32, failAt uint32) string {
+	msg := fmt.Sprintf("ascend failed at depth %d", failAt)
+	for d := failAt; d <= depth; d++ {
+		msg = fmt.Sprintf("witffi_reentrancy_%s_descend failed: %s at depth %d: %s", name, name, d, msg)
+	}
+	return msg
+}
+
+func TestNestedCalls(t *testing.T) {
+	for name, descend := range descenders {
+		for depth := uint32(0); depth <= 64; depth++ {
+			calls, err := descend(depth, noFailure)
+			if err != nil {
+				t.Fatalf("%s(%d): %v", name, depth, err)
+			}
+			if calls != 2*depth {
+				t.Errorf("%s(%d) = %d calls, want %d", name, depth, calls, 2*depth)
+			}
+		}
+	}
+}
+
+func TestNestedErrors(t *testing.T) {
+	for name, descend := range descenders {
+		for failAt := uint32(1); failAt <= 8; failAt++ {
+			_, err := descend(8, failAt)
+			if err == nil {
+				t.Fatalf("%s(8) failing at %d: expected an error", name, failAt)
+			}
+			if want := wantChain(name, 8, failAt); err.Error() != want {
+				t.Errorf("%s(8) failing at %d:\n got %q\nwant %q", name, failAt, err, want)
+			}
+		}
+
+		// A failure deep down leaves nothing behind for the next call
+		calls, err := descend(8, noFailure)
+		if err != nil || calls != 16 {
+			t.Errorf("%s(8) after failures = %d, %v; want 16, nil", name, calls, err)
+		}
+	}
+}
+
+func TestNestedPanic(t *testing.T) {
+	theHost.panicAt.Store(3)
+	defer theHost.panicAt.Store(0)
+
+	for name, descend := range descenders {
+		_, err := descend(6, noFailure)
+		if err == nil {
+			t.Fatalf("%s(6): expected the panic at depth 3 to fail the call", name)
+		}
+		for _, want := range []string{"boom at depth 3", fmt.Sprintf("%s at depth 6: ", name)} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s(6) = %q, want it to contain %q", name, err, want)
+			}
+		}
+	}
+
+	theHost.panicAt.Store(0)
+	for name, descend := range descenders {
+		if calls, err := descend(6, noFailure); err != nil || calls != 12 {
+			t.Errorf("%s(6) after a panic = %d, %v; want 12, nil", name, calls, err)
+		}
+	}
+}
+
+// TestConcurrentNestedCalls descends from many goroutines at once, through
+// both interfaces, failing some calls part way down. Calls into `nest` wait
+// on each other, but not on the nested calls their own imports make.
+func TestConcurrentNestedCalls(t *testing.T) {
+	const goroutines = 32
+	const iterations = 200
+	const depth = 12
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			name := "spin"
+			if g%2 == 0 {
+				name = "nest"
+			}
+			descend := descenders[name]
+			for i := 0; i < iterations; i++ {
+				failAt := noFailure
+				if i%3 == 0 {
+					failAt = uint32(1 + (g+i)%depth)
+				}
+				calls, err := descend(depth, failAt)
+				if failAt == noFailure {
+					if err != nil || calls != 2*depth {
+						errs <- fmt.Errorf("%s(%d) = %d, %v; want %d, nil", name, depth, calls, err, 2*depth)
+						return
+					}
+					continue
+				}
+				if want := wantChain(name, depth, failAt); err == nil || err.Error() != want {
+					errs <- fmt.Errorf("%s(%d) failing at %d = %v; want %q", name, depth, failAt, err, want)
+					return
+				}
+			}
+		}(g)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("nested calls deadlocked")
+	}
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}